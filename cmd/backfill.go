@@ -0,0 +1,109 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+type backfillOptions struct {
+	Orchestrator     string
+	Start            string
+	End              string
+	MaxRowsPerSecond uint64
+	Status           bool
+}
+
+// BackfillOptions stores the command-line option values for the backfill
+// command.
+var BackfillOptions backfillOptions
+
+var backfillCmd = &cobra.Command{
+	Use:   "backfill",
+	Short: "Rebuild consolidated tables for a time range",
+	Long: `Rebuild the consolidated flows tables from the main flows table for a time
+range, for example after enabling a new field in the schema. The backfill
+runs in the background in the orchestrator. Use --status to display its
+progress. When run again with the same time range after a failure or an
+interruption, it resumes where it has stopped.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		url := fmt.Sprintf("%s/api/v0/orchestrator/clickhouse/backfill",
+			strings.TrimSuffix(BackfillOptions.Orchestrator, "/"))
+		var resp *http.Response
+		if BackfillOptions.Status {
+			var err error
+			resp, err = http.Get(url)
+			if err != nil {
+				return fmt.Errorf("unable to contact orchestrator: %w", err)
+			}
+		} else {
+			if BackfillOptions.Start == "" || BackfillOptions.End == "" {
+				return errors.New("--start and --end are mandatory")
+			}
+			start, err := time.Parse(time.RFC3339, BackfillOptions.Start)
+			if err != nil {
+				return fmt.Errorf("invalid start time: %w", err)
+			}
+			end, err := time.Parse(time.RFC3339, BackfillOptions.End)
+			if err != nil {
+				return fmt.Errorf("invalid end time: %w", err)
+			}
+			body, err := json.Marshal(struct {
+				Start            time.Time `json:"start"`
+				End              time.Time `json:"end"`
+				MaxRowsPerSecond uint64    `json:"max-rows-per-second,omitempty"`
+			}{
+				Start:            start,
+				End:              end,
+				MaxRowsPerSecond: BackfillOptions.MaxRowsPerSecond,
+			})
+			if err != nil {
+				return fmt.Errorf("unable to encode request: %w", err)
+			}
+			resp, err = http.Post(url, "application/json", bytes.NewReader(body))
+			if err != nil {
+				return fmt.Errorf("unable to contact orchestrator: %w", err)
+			}
+		}
+		defer resp.Body.Close()
+		output, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("unable to read answer: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+			return fmt.Errorf("orchestrator returned %s: %s",
+				resp.Status, strings.TrimSpace(string(output)))
+		}
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, output, "", "  "); err != nil {
+			return fmt.Errorf("unable to decode answer: %w", err)
+		}
+		cmd.Println(indented.String())
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(backfillCmd)
+	backfillCmd.Flags().StringVar(&BackfillOptions.Orchestrator, "orchestrator",
+		"http://akvorado-orchestrator:8080", "URL of the orchestrator")
+	backfillCmd.Flags().StringVar(&BackfillOptions.Start, "start", "",
+		"Start of the time range (RFC 3339)")
+	backfillCmd.Flags().StringVar(&BackfillOptions.End, "end", "",
+		"End of the time range, excluded (RFC 3339)")
+	backfillCmd.Flags().Uint64Var(&BackfillOptions.MaxRowsPerSecond, "max-rows-per-second", 0,
+		"Maximum number of rows to read per second (default from configuration)")
+	backfillCmd.Flags().BoolVar(&BackfillOptions.Status, "status", false,
+		"Display the progress of the last backfill")
+}
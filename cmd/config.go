@@ -23,6 +23,7 @@ import (
 	"akvorado/common/helpers/yaml"
 
 	"akvorado/common/helpers"
+	"akvorado/orchestrator"
 )
 
 // ConfigRelatedOptions are command-line options related to handling a
@@ -39,32 +40,13 @@ func (c ConfigRelatedOptions) Parse(out io.Writer, component string, config inte
 	var rawConfig gin.H
 	if cfgFile := c.Path; cfgFile != "" {
 		if strings.HasPrefix(cfgFile, "http://") || strings.HasPrefix(cfgFile, "https://") {
-			u, err := url.Parse(cfgFile)
+			u, err := configurationURL(cfgFile, component)
 			if err != nil {
-				return fmt.Errorf("cannot parse configuration URL: %w", err)
+				return err
 			}
-			if u.Path == "" {
-				u.Path = fmt.Sprintf("/api/v0/orchestrator/configuration/%s", component)
-			}
-			if u.Fragment != "" {
-				u.Path = fmt.Sprintf("%s/%s", u.Path, u.Fragment)
-			}
-			resp, err := http.Get(u.String())
+			rawConfig, _, err = fetchConfiguration(u, "")
 			if err != nil {
-				return fmt.Errorf("unable to fetch configuration file: %w", err)
-			}
-			defer resp.Body.Close()
-			contentType := resp.Header.Get("Content-Type")
-			mediaType, _, err := mime.ParseMediaType(contentType)
-			if (mediaType != "application/x-yaml" && mediaType != "application/yaml") || err != nil {
-				return fmt.Errorf("received configuration file is not YAML (%s)", contentType)
-			}
-			input, err := io.ReadAll(resp.Body)
-			if err != nil {
-				return fmt.Errorf("unable to read configuration file: %w", err)
-			}
-			if err := yaml.Unmarshal(input, &rawConfig); err != nil {
-				return fmt.Errorf("unable to parse YAML configuration file: %w", err)
+				return err
 			}
 		} else {
 			cfgFile, err := filepath.EvalSymlinks(cfgFile)
@@ -81,6 +63,66 @@ func (c ConfigRelatedOptions) Parse(out io.Writer, component string, config inte
 		}
 	}
 
+	return c.decode(out, component, rawConfig, config)
+}
+
+// configurationURL returns the URL to fetch the configuration of a component
+// from the orchestrator.
+func configurationURL(cfgFile string, component string) (string, error) {
+	u, err := url.Parse(cfgFile)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse configuration URL: %w", err)
+	}
+	if u.Path == "" {
+		u.Path = fmt.Sprintf("/api/v0/orchestrator/configuration/%s", component)
+	}
+	if u.Fragment != "" {
+		u.Path = fmt.Sprintf("%s/%s", u.Path, u.Fragment)
+		u.Fragment = ""
+	}
+	return u.String(), nil
+}
+
+// fetchConfiguration fetches a raw configuration from the orchestrator. It
+// also returns the hash of the configuration. If the provided hash matches
+// the current configuration, a nil configuration is returned.
+func fetchConfiguration(u string, hash string) (gin.H, string, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot build configuration request: %w", err)
+	}
+	if hash != "" {
+		req.Header.Set("If-None-Match", fmt.Sprintf(`"%s"`, hash))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to fetch configuration file: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && hash != "" {
+		return nil, hash, nil
+	}
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if (mediaType != "application/x-yaml" && mediaType != "application/yaml") || err != nil {
+		return nil, "", fmt.Errorf("received configuration file is not YAML (%s)", contentType)
+	}
+	input, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to read configuration file: %w", err)
+	}
+	var rawConfig gin.H
+	if err := yaml.Unmarshal(input, &rawConfig); err != nil {
+		return nil, "", fmt.Errorf("unable to parse YAML configuration file: %w", err)
+	}
+	if rawConfig == nil {
+		rawConfig = gin.H{}
+	}
+	return rawConfig, orchestrator.ConfigurationHash(input), nil
+}
+
+// decode decodes a raw configuration into the provided configuration.
+func (c ConfigRelatedOptions) decode(out io.Writer, component string, rawConfig gin.H, config interface{}) error {
 	// Parse provided configuration
 	defaultHook, disableDefaultHook := DefaultHook()
 	zeroSliceHook, disableZeroSliceHook := ZeroSliceHook()
@@ -159,6 +201,14 @@ func (c ConfigRelatedOptions) Parse(out io.Writer, component string, config inte
 		if err != nil {
 			return fmt.Errorf("unable to dump configuration: %w", err)
 		}
+		var rawOutput interface{}
+		if err := yaml.Unmarshal(output, &rawOutput); err != nil {
+			return fmt.Errorf("unable to dump configuration: %w", err)
+		}
+		output, err = yaml.Marshal(redactSecrets(rawOutput))
+		if err != nil {
+			return fmt.Errorf("unable to dump configuration: %w", err)
+		}
 		out.Write([]byte("---\n"))
 		out.Write(output)
 		out.Write([]byte("\n"))
@@ -167,6 +217,60 @@ func (c ConfigRelatedOptions) Parse(out io.Writer, component string, config inte
 	return nil
 }
 
+// secretKeys are the configuration keys whose values are redacted when
+// dumping the configuration.
+var secretKeys = map[string]bool{
+	"password":                 true,
+	"saslpassword":             true,
+	"authenticationpassphrase": true,
+	"privacypassphrase":        true,
+	"communities":              true,
+	"token":                    true,
+}
+
+// redactSecrets replaces the values of secret keys in the provided raw
+// configuration.
+func redactSecrets(config interface{}) interface{} {
+	switch config := config.(type) {
+	case map[string]interface{}:
+		for k, v := range config {
+			if secretKeys[k] {
+				config[k] = redactValue(v)
+			} else {
+				config[k] = redactSecrets(v)
+			}
+		}
+	case []interface{}:
+		for idx, v := range config {
+			config[idx] = redactSecrets(v)
+		}
+	}
+	return config
+}
+
+// redactValue replaces all non-empty scalar values by a placeholder.
+func redactValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for k, v := range value {
+			value[k] = redactValue(v)
+		}
+		return value
+	case []interface{}:
+		for idx, v := range value {
+			value[idx] = redactValue(v)
+		}
+		return value
+	case nil:
+		return nil
+	case string:
+		if value == "" {
+			return value
+		}
+	}
+	return "******"
+}
+
 // DefaultHook will reset the destination value to its default using
 // the Reset() method if present.
 func DefaultHook() (mapstructure.DecodeHookFunc, func()) {
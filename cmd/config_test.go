@@ -187,6 +187,79 @@ module2:
 	}
 }
 
+func TestDumpRedactSecrets(t *testing.T) {
+	type snmpConfiguration struct {
+		Communities        map[string][]string
+		SecurityParameters map[string]struct {
+			UserName                 string
+			AuthenticationPassphrase string
+			PrivacyPassphrase        string
+		}
+	}
+	type secretConfiguration struct {
+		Username string
+		Password string
+		Empty    struct {
+			Password string
+		}
+		SNMP snmpConfiguration
+	}
+	config := `---
+username: alfred
+password: hunter2
+snmp:
+ communities:
+  ::/0: [public, private]
+ security-parameters:
+  2001:db8::/64:
+   user-name: alfred
+   authentication-passphrase: hunter3
+   privacy-passphrase: hunter4
+`
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(configFile, []byte(config), 0o644)
+
+	c := cmd.ConfigRelatedOptions{
+		Path: configFile,
+		Dump: true,
+	}
+	parsed := secretConfiguration{}
+	out := bytes.NewBuffer([]byte{})
+	if err := c.Parse(out, "dummy", &parsed); err != nil {
+		t.Fatalf("Parse() error:\n%+v", err)
+	}
+	if parsed.Password != "hunter2" {
+		t.Errorf("Parse() password = %q, expected %q", parsed.Password, "hunter2")
+	}
+
+	var gotRaw gin.H
+	if err := yaml.Unmarshal(out.Bytes(), &gotRaw); err != nil {
+		t.Fatalf("Unmarshal() error:\n%+v", err)
+	}
+	expectedRaw := gin.H{
+		"username": "alfred",
+		"password": "******",
+		"empty": gin.H{
+			"password": "",
+		},
+		"snmp": gin.H{
+			"communities": gin.H{
+				"::/0": []interface{}{"******", "******"},
+			},
+			"securityparameters": gin.H{
+				"2001:db8::/64": gin.H{
+					"username":                 "alfred",
+					"authenticationpassphrase": "******",
+					"privacypassphrase":        "******",
+				},
+			},
+		},
+	}
+	if diff := helpers.Diff(gotRaw, expectedRaw); diff != "" {
+		t.Errorf("Parse() (-got, +want):\n%s", diff)
+	}
+}
+
 func TestEnvOverride(t *testing.T) {
 	// Configuration file
 	config := `---
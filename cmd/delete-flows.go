@@ -0,0 +1,110 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+type deleteFlowsOptions struct {
+	Orchestrator string
+	Start        string
+	End          string
+	Exporter     string
+	SrcPrefix    string
+	DstPrefix    string
+	Confirm      string
+}
+
+// DeleteFlowsOptions stores the command-line option values for the
+// delete-flows command.
+var DeleteFlowsOptions deleteFlowsOptions
+
+var deleteFlowsCmd = &cobra.Command{
+	Use:   "delete-flows",
+	Short: "Delete flows for a time range",
+	Long: `Delete flows stored in ClickHouse for a time range, optionally
+restricted to an exporter or to source or destination prefixes. Without
+--confirm, the deletion plan is displayed with a confirmation token. Run the
+command again with the same options and --confirm set to this token to
+execute the deletion.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		start, err := time.Parse(time.RFC3339, DeleteFlowsOptions.Start)
+		if err != nil {
+			return fmt.Errorf("invalid start time: %w", err)
+		}
+		end, err := time.Parse(time.RFC3339, DeleteFlowsOptions.End)
+		if err != nil {
+			return fmt.Errorf("invalid end time: %w", err)
+		}
+		body, err := json.Marshal(struct {
+			Start     time.Time `json:"start"`
+			End       time.Time `json:"end"`
+			Exporter  string    `json:"exporter,omitempty"`
+			SrcPrefix string    `json:"src-prefix,omitempty"`
+			DstPrefix string    `json:"dst-prefix,omitempty"`
+			Confirm   string    `json:"confirm,omitempty"`
+		}{
+			Start:     start,
+			End:       end,
+			Exporter:  DeleteFlowsOptions.Exporter,
+			SrcPrefix: DeleteFlowsOptions.SrcPrefix,
+			DstPrefix: DeleteFlowsOptions.DstPrefix,
+			Confirm:   DeleteFlowsOptions.Confirm,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to encode request: %w", err)
+		}
+		url := fmt.Sprintf("%s/api/v0/orchestrator/clickhouse/delete",
+			strings.TrimSuffix(DeleteFlowsOptions.Orchestrator, "/"))
+		resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("unable to contact orchestrator: %w", err)
+		}
+		defer resp.Body.Close()
+		output, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("unable to read answer: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("orchestrator returned %s: %s",
+				resp.Status, strings.TrimSpace(string(output)))
+		}
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, output, "", "  "); err != nil {
+			return fmt.Errorf("unable to decode answer: %w", err)
+		}
+		cmd.Println(indented.String())
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(deleteFlowsCmd)
+	deleteFlowsCmd.Flags().StringVar(&DeleteFlowsOptions.Orchestrator, "orchestrator",
+		"http://akvorado-orchestrator:8080", "URL of the orchestrator")
+	deleteFlowsCmd.Flags().StringVar(&DeleteFlowsOptions.Start, "start", "",
+		"Start of the time range (RFC 3339)")
+	deleteFlowsCmd.Flags().StringVar(&DeleteFlowsOptions.End, "end", "",
+		"End of the time range, excluded (RFC 3339)")
+	deleteFlowsCmd.Flags().StringVar(&DeleteFlowsOptions.Exporter, "exporter", "",
+		"Only delete flows from this exporter")
+	deleteFlowsCmd.Flags().StringVar(&DeleteFlowsOptions.SrcPrefix, "src-prefix", "",
+		"Only delete flows whose source address is in this prefix")
+	deleteFlowsCmd.Flags().StringVar(&DeleteFlowsOptions.DstPrefix, "dst-prefix", "",
+		"Only delete flows whose destination address is in this prefix")
+	deleteFlowsCmd.Flags().StringVar(&DeleteFlowsOptions.Confirm, "confirm", "",
+		"Confirmation token returned when planning the deletion")
+	deleteFlowsCmd.MarkFlagRequired("start")
+	deleteFlowsCmd.MarkFlagRequired("end")
+}
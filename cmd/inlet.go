@@ -4,23 +4,30 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/cobra"
 
+	"akvorado/common/clickhousedb"
 	"akvorado/common/daemon"
 	"akvorado/common/helpers"
+	"akvorado/common/helpers/bimap"
 	"akvorado/common/httpserver"
 	"akvorado/common/reporter"
 	"akvorado/common/schema"
+	inletclickhouse "akvorado/inlet/clickhouse"
 	"akvorado/inlet/core"
 	"akvorado/inlet/flow"
 	"akvorado/inlet/kafka"
 	"akvorado/inlet/metadata"
 	"akvorado/inlet/metadata/provider/snmp"
+	inletnats "akvorado/inlet/nats"
 	"akvorado/inlet/routing"
 	"akvorado/inlet/routing/provider/bmp"
 )
@@ -32,25 +39,80 @@ type InletConfiguration struct {
 	Flow      flow.Configuration
 	Metadata  metadata.Configuration
 	Routing   routing.Configuration
-	Kafka     kafka.Configuration
-	Core      core.Configuration
-	Schema    schema.Configuration
+	// Output selects where flows are exported.
+	Output InletOutput
+	Kafka  kafka.Configuration
+	// NATS is used to publish flows when the output is NATS.
+	NATS inletnats.Configuration
+	// ClickHouse is used to insert flows when Kafka is disabled.
+	ClickHouse inletclickhouse.Configuration
+	Core       core.Configuration
+	Schema     schema.Configuration
+	// ReloadInterval is the interval to check for configuration updates
+	// when the configuration is fetched from the orchestrator. 0 disables
+	// reload.
+	ReloadInterval time.Duration `validate:"isdefault|min=10s"`
 }
 
 // Reset resets the configuration for the inlet command to its default value.
 func (c *InletConfiguration) Reset() {
 	*c = InletConfiguration{
-		HTTP:      httpserver.DefaultConfiguration(),
-		Reporting: reporter.DefaultConfiguration(),
-		Flow:      flow.DefaultConfiguration(),
-		Metadata:  metadata.DefaultConfiguration(),
-		Routing:   routing.DefaultConfiguration(),
-		Kafka:     kafka.DefaultConfiguration(),
-		Core:      core.DefaultConfiguration(),
-		Schema:    schema.DefaultConfiguration(),
+		HTTP:       httpserver.DefaultConfiguration(),
+		Reporting:  reporter.DefaultConfiguration(),
+		Flow:       flow.DefaultConfiguration(),
+		Metadata:   metadata.DefaultConfiguration(),
+		Routing:    routing.DefaultConfiguration(),
+		Output:     InletOutputKafka,
+		Kafka:      kafka.DefaultConfiguration(),
+		NATS:       inletnats.DefaultConfiguration(),
+		ClickHouse: inletclickhouse.DefaultConfiguration(),
+		Core:       core.DefaultConfiguration(),
+		Schema:     schema.DefaultConfiguration(),
+
+		ReloadInterval: time.Minute,
 	}
 	c.Metadata.Providers = []metadata.ProviderConfiguration{{Config: snmp.DefaultConfiguration()}}
-	c.Routing.Provider.Config = bmp.DefaultConfiguration()
+	c.Routing.Providers = []routing.ProviderConfiguration{{Config: bmp.DefaultConfiguration()}}
+}
+
+// InletOutput selects where the inlet exports flows.
+type InletOutput int
+
+const (
+	// InletOutputKafka exports flows to Kafka.
+	InletOutputKafka InletOutput = iota
+	// InletOutputNATS exports flows to NATS JetStream.
+	InletOutputNATS
+)
+
+var inletOutputMap = bimap.New(map[InletOutput]string{
+	InletOutputKafka: "kafka",
+	InletOutputNATS:  "nats",
+})
+
+// MarshalText turns an output to text
+func (o InletOutput) MarshalText() ([]byte, error) {
+	got, ok := inletOutputMap.LoadValue(o)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown output")
+}
+
+// String turns an output to string
+func (o InletOutput) String() string {
+	got, _ := inletOutputMap.LoadValue(o)
+	return got
+}
+
+// UnmarshalText provides an output from text
+func (o *InletOutput) UnmarshalText(input []byte) error {
+	got, ok := inletOutputMap.LoadKey(string(input))
+	if ok {
+		*o = got
+		return nil
+	}
+	return errors.New("unknown output")
 }
 
 type inletOptions struct {
@@ -117,36 +179,84 @@ func inletStart(r *reporter.Reporter, config InletConfiguration, checkOnly bool)
 	}
 	metadataComponent, err := metadata.New(r, config.Metadata, metadata.Dependencies{
 		Daemon: daemonComponent,
+		HTTP:   httpComponent,
 	})
 	if err != nil {
 		return fmt.Errorf("unable to initialize metadata component: %w", err)
 	}
 	routingComponent, err := routing.New(r, config.Routing, routing.Dependencies{
 		Daemon: daemonComponent,
+		HTTP:   httpComponent,
 	})
 	if err != nil {
 		return fmt.Errorf("unable to initialize routing component: %w", err)
 	}
-	kafkaComponent, err := kafka.New(r, config.Kafka, kafka.Dependencies{
-		Daemon: daemonComponent,
-		Schema: schemaComponent,
-	})
-	if err != nil {
-		return fmt.Errorf("unable to initialize Kafka component: %w", err)
+	var (
+		kafkaComponent        *kafka.Component
+		natsComponent         *inletnats.Component
+		clickhouseDBComponent *clickhousedb.Component
+		clickhouseComponent   *inletclickhouse.Component
+	)
+	switch {
+	case config.Kafka.Disable:
+		clickhouseDBComponent, err = clickhousedb.New(r, config.ClickHouse.Configuration, clickhousedb.Dependencies{
+			Daemon: daemonComponent,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to initialize ClickHouse component: %w", err)
+		}
+		clickhouseComponent, err = inletclickhouse.New(r, config.ClickHouse, inletclickhouse.Dependencies{
+			Daemon:     daemonComponent,
+			ClickHouse: clickhouseDBComponent,
+			Schema:     schemaComponent,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to initialize ClickHouse exporter component: %w", err)
+		}
+	case config.Output == InletOutputNATS:
+		natsComponent, err = inletnats.New(r, config.NATS, inletnats.Dependencies{
+			Daemon: daemonComponent,
+			Schema: schemaComponent,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to initialize NATS component: %w", err)
+		}
+	default:
+		kafkaComponent, err = kafka.New(r, config.Kafka, kafka.Dependencies{
+			Daemon: daemonComponent,
+			HTTP:   httpComponent,
+			Schema: schemaComponent,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to initialize Kafka component: %w", err)
+		}
 	}
 	coreComponent, err := core.New(r, config.Core, core.Dependencies{
-		Daemon:   daemonComponent,
-		Flow:     flowComponent,
-		Metadata: metadataComponent,
-		Routing:  routingComponent,
-		Kafka:    kafkaComponent,
-		HTTP:     httpComponent,
-		Schema:   schemaComponent,
+		Daemon:     daemonComponent,
+		Flow:       flowComponent,
+		Metadata:   metadataComponent,
+		Routing:    routingComponent,
+		Kafka:      kafkaComponent,
+		NATS:       natsComponent,
+		ClickHouse: clickhouseComponent,
+		HTTP:       httpComponent,
+		Schema:     schemaComponent,
 	})
 	if err != nil {
 		return fmt.Errorf("unable to initialize core component: %w", err)
 	}
 
+	// Reload configuration from the orchestrator
+	var reloader *inletReloader
+	if path := InletOptions.Path; config.ReloadInterval > 0 &&
+		(strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")) {
+		u, err := configurationURL(path, "inlet")
+		if err != nil {
+			return err
+		}
+		reloader = newInletReloader(r, u, config, coreComponent)
+	}
+
 	// Expose some information and metrics
 	addCommonHTTPHandlers(r, "inlet", httpComponent)
 	versionMetrics(r)
@@ -156,14 +266,25 @@ func inletStart(r *reporter.Reporter, config InletConfiguration, checkOnly bool)
 		return nil
 	}
 
-	// Start all the components.
+	// Start all the components. They are stopped in reverse order: flow
+	// inputs are stopped first, then core processes the remaining flows
+	// and the output (Kafka, NATS or ClickHouse) flushes them.
 	components := []interface{}{
 		httpComponent,
 		metadataComponent,
 		routingComponent,
-		kafkaComponent,
-		coreComponent,
-		flowComponent,
+	}
+	switch {
+	case kafkaComponent != nil:
+		components = append(components, kafkaComponent)
+	case natsComponent != nil:
+		components = append(components, natsComponent)
+	default:
+		components = append(components, clickhouseDBComponent, clickhouseComponent)
+	}
+	components = append(components, coreComponent, flowComponent)
+	if reloader != nil {
+		components = append(components, reloader)
 	}
 	return StartStopComponents(r, daemonComponent, components)
 }
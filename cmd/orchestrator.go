@@ -81,6 +81,7 @@ components and centralizes configuration of the various other components.`,
 			config.ClickHouse.Kafka.Configuration = config.Kafka.Configuration
 			for idx := range config.Inlet {
 				config.Inlet[idx].Kafka.Configuration = config.Kafka.Configuration
+				config.Inlet[idx].ClickHouse.Configuration = config.ClickHouse.Configuration
 				config.Inlet[idx].Schema = config.Schema
 			}
 			for idx := range config.Console {
@@ -0,0 +1,159 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"gopkg.in/tomb.v2"
+
+	"akvorado/common/helpers/yaml"
+	"akvorado/common/reporter"
+	"akvorado/inlet/core"
+)
+
+// inletReloader polls the orchestrator for updates of the inlet
+// configuration and applies the settings which can be changed without
+// restarting.
+type inletReloader struct {
+	r        *reporter.Reporter
+	t        tomb.Tomb
+	url      string
+	interval time.Duration
+	core     *core.Component
+
+	startup     InletConfiguration // configuration used at startup
+	applied     core.Configuration // last applied core configuration
+	fetchedHash string             // hash of the last fetched configuration
+	restart     bool               // a restart is needed to apply the configuration
+	hashInfo    *reporter.GaugeVec
+}
+
+func newInletReloader(r *reporter.Reporter, url string, config InletConfiguration, coreComponent *core.Component) *inletReloader {
+	return &inletReloader{
+		r:        r,
+		url:      url,
+		interval: config.ReloadInterval,
+		core:     coreComponent,
+		startup:  config,
+		applied:  config.Core,
+		hashInfo: r.GaugeVec(reporter.GaugeOpts{
+			Name: "configuration_info",
+			Help: "Hash of the configuration currently applied.",
+		}, []string{"hash"}),
+	}
+}
+
+// Start starts polling the orchestrator.
+func (ir *inletReloader) Start() error {
+	ir.r.Info().Str("url", ir.url).Msg("starting configuration reloader")
+	ir.t.Go(func() error {
+		ticker := time.NewTicker(ir.interval)
+		defer ticker.Stop()
+		for {
+			if err := ir.check(); err != nil {
+				ir.r.Err(err).Msg("unable to check for configuration updates")
+			}
+			select {
+			case <-ir.t.Dying():
+				return nil
+			case <-ticker.C:
+			}
+		}
+	})
+	return nil
+}
+
+// Stop stops polling the orchestrator.
+func (ir *inletReloader) Stop() error {
+	defer ir.r.Info().Msg("configuration reloader stopped")
+	ir.t.Kill(nil)
+	return ir.t.Wait()
+}
+
+// check fetches the configuration from the orchestrator and applies it if
+// it has changed.
+func (ir *inletReloader) check() error {
+	rawConfig, hash, err := fetchConfiguration(ir.url, ir.fetchedHash)
+	if err != nil {
+		return err
+	}
+	if rawConfig == nil {
+		// Not modified
+		return nil
+	}
+	var config InletConfiguration
+	if err := (ConfigRelatedOptions{}).decode(io.Discard, "inlet", rawConfig, &config); err != nil {
+		return fmt.Errorf("cannot decode configuration %s: %w", hash, err)
+	}
+	ir.fetchedHash = hash
+	ir.apply(hash, config)
+	return nil
+}
+
+// apply applies a new configuration. Settings which cannot be changed
+// without restarting are only logged.
+func (ir *inletReloader) apply(hash string, config InletConfiguration) {
+	reloadable, _ := core.CompareConfigurations(ir.applied, config.Core)
+	restart := compareInletConfigurations(ir.startup, config)
+	if len(reloadable) > 0 {
+		ir.core.Reload(config.Core)
+		ir.applied = config.Core
+		for idx := range reloadable {
+			reloadable[idx] = fmt.Sprintf("core.%s", reloadable[idx])
+		}
+		ir.r.Info().Str("hash", hash).Strs("sections", reloadable).Msg("configuration reloaded")
+	}
+	if len(restart) > 0 {
+		ir.r.Warn().Str("hash", hash).Strs("sections", restart).
+			Msg("configuration changed, restart needed to apply these sections")
+		ir.restart = true
+		return
+	}
+	if ir.restart {
+		ir.r.Info().Str("hash", hash).Msg("configuration does not need a restart anymore")
+		ir.restart = false
+	}
+	ir.hashInfo.Reset()
+	ir.hashInfo.WithLabelValues(hash).Set(1)
+}
+
+// compareInletConfigurations returns the sections of the inlet configuration
+// which have changed and cannot be applied without restarting.
+func compareInletConfigurations(previous, next InletConfiguration) []string {
+	changed := func(a, b any) bool {
+		outA, errA := yaml.Marshal(a)
+		outB, errB := yaml.Marshal(b)
+		return errA != nil || errB != nil || !bytes.Equal(outA, outB)
+	}
+	restart := []string{}
+	for _, section := range []struct {
+		Name     string
+		Previous any
+		Next     any
+	}{
+		{"reporting", previous.Reporting, next.Reporting},
+		{"http", previous.HTTP, next.HTTP},
+		{"flow", previous.Flow, next.Flow},
+		{"metadata", previous.Metadata, next.Metadata},
+		{"routing", previous.Routing, next.Routing},
+		{"output", previous.Output, next.Output},
+		{"kafka", previous.Kafka, next.Kafka},
+		{"nats", previous.NATS, next.NATS},
+		{"clickhouse", previous.ClickHouse, next.ClickHouse},
+		{"schema", previous.Schema, next.Schema},
+		{"reload-interval", previous.ReloadInterval, next.ReloadInterval},
+	} {
+		if changed(section.Previous, section.Next) {
+			restart = append(restart, section.Name)
+		}
+	}
+	if _, coreRestart := core.CompareConfigurations(previous.Core, next.Core); coreRestart {
+		restart = append(restart, "core")
+	}
+	return restart
+}
@@ -0,0 +1,146 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package cmd
+
+import (
+	"fmt"
+	"testing"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/helpers/yaml"
+	"akvorado/common/httpserver"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/core"
+	"akvorado/orchestrator"
+)
+
+func TestInletReloader(t *testing.T) {
+	r := reporter.NewMock(t)
+	h := httpserver.NewMock(t, r)
+	o, err := orchestrator.New(r, orchestrator.DefaultConfiguration(), orchestrator.Dependencies{HTTP: h})
+	if err != nil {
+		t.Fatalf("orchestrator.New() error:\n%+v", err)
+	}
+
+	// Configuration 0 is the initial one, 1 has a new classifier, 2 has a
+	// new Kafka topic.
+	config := InletConfiguration{}
+	config.Reset()
+	var rule core.ExporterClassifierRule
+	if err := rule.UnmarshalText([]byte(`ClassifySite("paris")`)); err != nil {
+		t.Fatalf("UnmarshalText() error:\n%+v", err)
+	}
+	o.RegisterConfiguration(orchestrator.InletService, config)
+	config1 := config
+	config1.Core.ExporterClassifiers = []core.ExporterClassifierRule{rule}
+	o.RegisterConfiguration(orchestrator.InletService, config1)
+	config2 := config1
+	config2.Kafka.Topic = "other-flows"
+	o.RegisterConfiguration(orchestrator.InletService, config2)
+	hash := func(config InletConfiguration) string {
+		output, err := yaml.Marshal(config)
+		if err != nil {
+			t.Fatalf("yaml.Marshal() error:\n%+v", err)
+		}
+		return orchestrator.ConfigurationHash(output)
+	}
+	url := func(index int) string {
+		return fmt.Sprintf("http://%s/api/v0/orchestrator/configuration/inlet/%d", h.LocalAddr(), index)
+	}
+
+	coreComponent, err := core.New(r, config.Core, core.Dependencies{
+		Daemon: daemon.NewMock(t),
+		Schema: schema.NewMock(t),
+	})
+	if err != nil {
+		t.Fatalf("core.New() error:\n%+v", err)
+	}
+	ir := newInletReloader(r, url(0), config, coreComponent)
+
+	for _, step := range []struct {
+		Description     string
+		Index           int
+		ExpectedHash    string
+		ExpectedApplied int // number of exporter classifiers
+		ExpectedRestart bool
+	}{
+		{"initial configuration", 0, hash(config), 0, false},
+		{"unchanged configuration", 0, hash(config), 0, false},
+		{"reloadable configuration", 1, hash(config1), 1, false},
+		{"configuration needing a restart", 2, hash(config1), 1, true},
+		{"back to reloadable configuration", 1, hash(config1), 1, false},
+	} {
+		ir.url = url(step.Index)
+		if err := ir.check(); err != nil {
+			t.Fatalf("check(%s) error:\n%+v", step.Description, err)
+		}
+		gotMetrics := r.GetMetrics("akvorado_cmd_", "configuration_")
+		expectedMetrics := map[string]string{
+			fmt.Sprintf(`configuration_info{hash="%s"}`, step.ExpectedHash): "1",
+		}
+		if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+			t.Fatalf("check(%s) metrics (-got, +want):\n%s", step.Description, diff)
+		}
+		if got := len(ir.applied.ExporterClassifiers); got != step.ExpectedApplied {
+			t.Fatalf("check(%s) applied %d classifiers, expected %d",
+				step.Description, got, step.ExpectedApplied)
+		}
+		if ir.restart != step.ExpectedRestart {
+			t.Fatalf("check(%s) restart = %v, expected %v",
+				step.Description, ir.restart, step.ExpectedRestart)
+		}
+	}
+}
+
+func TestCompareInletConfigurations(t *testing.T) {
+	var rule core.ExporterClassifierRule
+	if err := rule.UnmarshalText([]byte(`ClassifySite("paris")`)); err != nil {
+		t.Fatalf("UnmarshalText() error:\n%+v", err)
+	}
+	cases := []struct {
+		Description string
+		Update      func(*InletConfiguration)
+		Expected    []string
+	}{
+		{
+			Description: "no change",
+			Update:      func(*InletConfiguration) {},
+			Expected:    []string{},
+		}, {
+			Description: "classifiers only",
+			Update: func(c *InletConfiguration) {
+				c.Core.ExporterClassifiers = []core.ExporterClassifierRule{rule}
+			},
+			Expected: []string{},
+		}, {
+			Description: "Kafka topic and core workers",
+			Update: func(c *InletConfiguration) {
+				c.Kafka.Topic = "other-flows"
+				c.Core.Workers = 4
+			},
+			Expected: []string{"kafka", "core"},
+		}, {
+			Description: "reload interval",
+			Update: func(c *InletConfiguration) {
+				c.ReloadInterval = 0
+			},
+			Expected: []string{"reload-interval"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			previous := InletConfiguration{}
+			previous.Reset()
+			next := InletConfiguration{}
+			next.Reset()
+			tc.Update(&next)
+			got := compareInletConfigurations(previous, next)
+			if diff := helpers.Diff(got, tc.Expected); diff != "" {
+				t.Fatalf("compareInletConfigurations() (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
@@ -4,6 +4,8 @@
 package clickhousedb
 
 import (
+	"fmt"
+	"regexp"
 	"time"
 
 	"akvorado/common/helpers"
@@ -19,6 +21,9 @@ type Configuration struct {
 	Cluster string
 	// Database defines the database to use
 	Database string `validate:"required"`
+	// TablePrefix is prepended to the names of the tables, views, and
+	// dictionaries managed by Akvorado.
+	TablePrefix string
 	// Username defines the username to use for authentication
 	Username string `validate:"required"`
 	// Password defines the password to use for authentication
@@ -45,3 +50,20 @@ func DefaultConfiguration() Configuration {
 		},
 	}
 }
+
+// TableName returns the name of the provided table or dictionary, prefixed
+// with the configured table prefix.
+func (c Configuration) TableName(name string) string {
+	return c.TablePrefix + name
+}
+
+// PrefixDictionaries prefixes the names of the dictionaries used by the
+// dictGet functions in the provided query with the configured table prefix.
+func (c Configuration) PrefixDictionaries(query string) string {
+	if c.TablePrefix == "" {
+		return query
+	}
+	return dictGetRegexp.ReplaceAllString(query, fmt.Sprintf("${1}('%s", c.TablePrefix))
+}
+
+var dictGetRegexp = regexp.MustCompile(`\b(dictGet\w*)\('`)
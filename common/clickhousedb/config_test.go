@@ -14,3 +14,26 @@ func TestDefaultConfiguration(t *testing.T) {
 		t.Fatalf("validate.Struct() error:\n%+v", err)
 	}
 }
+
+func TestTablePrefix(t *testing.T) {
+	config := DefaultConfiguration()
+	query := `SELECT dictGetOrDefault('asns', 'name', SrcAS, ''), dictGet('networks', 'city', SrcAddr) FROM flows`
+	if got := config.TableName("flows"); got != "flows" {
+		t.Errorf("TableName() == %q, expected %q", got, "flows")
+	}
+	if got := config.PrefixDictionaries(query); got != query {
+		t.Errorf("PrefixDictionaries() == %q, expected %q", got, query)
+	}
+
+	config.TablePrefix = "akvorado_"
+	if got := config.TableName("flows"); got != "akvorado_flows" {
+		t.Errorf("TableName() == %q, expected %q", got, "akvorado_flows")
+	}
+	expected := `SELECT dictGetOrDefault('akvorado_asns', 'name', SrcAS, ''), dictGet('akvorado_networks', 'city', SrcAddr) FROM flows`
+	if got := config.PrefixDictionaries(query); got != expected {
+		t.Errorf("PrefixDictionaries() == %q, expected %q", got, expected)
+	}
+	if got, expected := EscapeLike(config.TablePrefix), `akvorado\\_`; got != expected {
+		t.Errorf("EscapeLike() == %q, expected %q", got, expected)
+	}
+}
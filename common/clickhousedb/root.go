@@ -6,6 +6,8 @@ package clickhousedb
 
 import (
 	"context"
+	"fmt"
+	"regexp"
 	"time"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
@@ -34,11 +36,23 @@ type Dependencies struct {
 
 // New creates a new ClickHouse wrapper
 func New(r *reporter.Reporter, config Configuration, dependencies Dependencies) (*Component, error) {
+	if !tablePrefixRegexp.MatchString(config.TablePrefix) {
+		return nil, fmt.Errorf("invalid table prefix %q", config.TablePrefix)
+	}
 	tlsConfig, err := config.TLS.MakeTLSConfig()
 
 	if err != nil {
 		return nil, err
 	}
+	if tlsConfig != nil && config.TLS.CertFile != "" {
+		// Reload the client certificate when it is rotated
+		reloader, err := helpers.NewCertificateReloader(config.TLS.CertFile, config.TLS.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = nil
+		tlsConfig.GetClientCertificate = reloader.GetClientCertificate
+	}
 	conn, err := clickhouse.Open(&clickhouse.Options{
 		Addr:             config.Servers,
 		ConnOpenStrategy: clickhouse.ConnOpenRoundRobin,
@@ -79,6 +93,22 @@ func New(r *reporter.Reporter, config Configuration, dependencies Dependencies)
 	return &c, nil
 }
 
+// tablePrefixRegexp matches the valid table prefixes. They should not need to
+// be quoted.
+var tablePrefixRegexp = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// TableName returns the name of the provided table or dictionary, prefixed
+// with the configured table prefix.
+func (c *Component) TableName(name string) string {
+	return c.config.TableName(name)
+}
+
+// PrefixDictionaries prefixes the names of the dictionaries used in the
+// provided query with the configured table prefix.
+func (c *Component) PrefixDictionaries(query string) string {
+	return c.config.PrefixDictionaries(query)
+}
+
 // Start initializes the connection to ClickHouse
 func (c *Component) Start() error {
 	c.r.Info().Msg("starting ClickHouse component")
@@ -11,6 +11,7 @@ import (
 	"go.uber.org/mock/gomock"
 
 	"akvorado/common/clickhousedb/mocks"
+	"akvorado/common/daemon"
 	"akvorado/common/helpers"
 	"akvorado/common/reporter"
 )
@@ -155,3 +156,12 @@ func TestRealClickHouse(t *testing.T) {
 		}
 	})
 }
+
+func TestInvalidTablePrefix(t *testing.T) {
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration()
+	config.TablePrefix = "akvorado-"
+	if _, err := New(r, config, Dependencies{Daemon: daemon.NewMock(t)}); err == nil {
+		t.Fatal("New() did not error")
+	}
+}
@@ -19,6 +19,12 @@ func (c *Component) ExecOnCluster(ctx context.Context, query string, args ...any
 	return c.Exec(ctx, query, args...)
 }
 
+// EscapeLike escapes a string to be used as a literal inside a LIKE pattern
+// in a quoted string. The result is not quoted.
+func EscapeLike(s string) string {
+	return strings.NewReplacer(`%`, `\\%`, `_`, `\\_`).Replace(s)
+}
+
 var (
 	spacesRegexp                   = regexp.MustCompile("\\s+")
 	statementBeforeOnClusterRegexp = regexp.MustCompile(fmt.Sprintf("^((?i)%s)", strings.Join([]string{
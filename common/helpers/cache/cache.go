@@ -19,10 +19,15 @@ var ErrVersion = errors.New("cache version mismatch")
 
 // Cache is a thread-safe in-memory key/value store
 type Cache[K comparable, V any] struct {
-	items map[K]*item[V]
-	mu    sync.RWMutex
+	items   map[K]*item[V]
+	mu      sync.RWMutex
+	maxSize int
 }
 
+// evictionSamples is the number of items to examine to find the item to
+// evict when a bounded cache is full.
+const evictionSamples = 5
+
 // item is a cache item, including last access and last update
 type item[V any] struct {
 	Object       V
@@ -37,6 +42,16 @@ func New[K comparable, V any]() *Cache[K, V] {
 	}
 }
 
+// NewBounded creates a new instance of the cache holding at most the
+// specified number of items. When the cache is full, adding a new item evicts
+// the least recently accessed item among a few random ones.
+func NewBounded[K comparable, V any](maxSize int) *Cache[K, V] {
+	return &Cache[K, V]{
+		items:   make(map[K]*item[V]),
+		maxSize: maxSize,
+	}
+}
+
 func (c *Cache[K, V]) zero() V {
 	var v V
 	return v
@@ -51,10 +66,37 @@ func (c *Cache[K, V]) Put(now time.Time, key K, object V) {
 		LastUpdated:  n,
 	}
 	c.mu.Lock()
+	if _, ok := c.items[key]; !ok && c.maxSize > 0 && len(c.items) >= c.maxSize {
+		c.evict()
+	}
 	c.items[key] = &item
 	c.mu.Unlock()
 }
 
+// evict removes one item from the cache. It should be called with the lock
+// held. As map iteration order is random, the evicted item is the least
+// recently accessed one among a few random items.
+func (c *Cache[K, V]) evict() {
+	var (
+		oldestKey K
+		oldest    int64
+		samples   int
+	)
+	for k, v := range c.items {
+		last := atomic.LoadInt64(&v.LastAccessed)
+		if samples == 0 || last < oldest {
+			oldestKey, oldest = k, last
+		}
+		samples++
+		if samples >= evictionSamples {
+			break
+		}
+	}
+	if samples > 0 {
+		delete(c.items, oldestKey)
+	}
+}
+
 // Get retrieves an object from the cache. If now is uninitialized, time of last
 // access is not updated.
 func (c *Cache[K, V]) Get(now time.Time, key K) (V, bool) {
@@ -82,6 +124,27 @@ func (c *Cache[K, V]) Items() map[K]V {
 	return result
 }
 
+// Entry is an object from the cache with the time of its last update.
+type Entry[V any] struct {
+	Object      V
+	LastUpdated time.Time
+}
+
+// Entries retrieve all the entries in the cache, including their time of last
+// update.
+func (c *Cache[K, V]) Entries() map[K]Entry[V] {
+	result := map[K]Entry[V]{}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for k, v := range c.items {
+		result[k] = Entry[V]{
+			Object:      v.Object,
+			LastUpdated: time.Unix(v.LastUpdated, 0),
+		}
+	}
+	return result
+}
+
 // ItemsLastUpdatedBefore returns the items whose last update is before the
 // provided time.
 func (c *Cache[K, V]) ItemsLastUpdatedBefore(before time.Time) map[K]V {
@@ -112,6 +175,35 @@ func (c *Cache[K, V]) DeleteLastAccessedBefore(before time.Time) int {
 	return count
 }
 
+// DeleteLastUpdatedBefore removes items whose last update is before the
+// provided time.
+func (c *Cache[K, V]) DeleteLastUpdatedBefore(before time.Time) int {
+	count := 0
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, v := range c.items {
+		if v.LastUpdated < before.Unix() {
+			delete(c.items, k)
+			count++
+		}
+	}
+	return count
+}
+
+// DeleteFunc removes items whose key matches the provided function.
+func (c *Cache[K, V]) DeleteFunc(match func(K) bool) int {
+	count := 0
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.items {
+		if match(k) {
+			delete(c.items, k)
+			count++
+		}
+	}
+	return count
+}
+
 // Size returns the size of the cache
 func (c *Cache[K, V]) Size() int {
 	c.mu.RLock()
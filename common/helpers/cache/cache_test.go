@@ -56,6 +56,33 @@ func TestGetPut(t *testing.T) {
 	}
 }
 
+func TestBounded(t *testing.T) {
+	c := cache.NewBounded[netip.Addr, string](3)
+	t1 := time.Date(2022, time.December, 31, 10, 23, 0, 0, time.UTC)
+	t2 := t1.Add(time.Minute)
+	t3 := t2.Add(time.Minute)
+	c.Put(t1, netip.MustParseAddr("::ffff:127.0.0.1"), "entry1")
+	c.Put(t2, netip.MustParseAddr("::ffff:127.0.0.2"), "entry2")
+	c.Put(t3, netip.MustParseAddr("::ffff:127.0.0.3"), "entry3")
+
+	// Updating an existing item does not evict anything
+	c.Put(t3, netip.MustParseAddr("::ffff:127.0.0.3"), "entry4")
+	if got := c.Size(); got != 3 {
+		t.Fatalf("Size() == %d, expected 3", got)
+	}
+
+	// Adding a new item evicts the least recently accessed one. With 3 items,
+	// all of them are examined.
+	c.Put(t3, netip.MustParseAddr("::ffff:127.0.0.5"), "entry5")
+	if got := c.Size(); got != 3 {
+		t.Fatalf("Size() == %d, expected 3", got)
+	}
+	expectCacheGet(t, c, "127.0.0.1", "", false)
+	expectCacheGet(t, c, "127.0.0.2", "entry2", true)
+	expectCacheGet(t, c, "127.0.0.3", "entry4", true)
+	expectCacheGet(t, c, "127.0.0.5", "entry5", true)
+}
+
 func TestDeleteLastAccessedBefore(t *testing.T) {
 	c := cache.New[netip.Addr, string]()
 	t1 := time.Date(2022, time.December, 31, 10, 23, 0, 0, time.UTC)
@@ -117,3 +144,56 @@ func TestItemsLastUpdatedBefore(t *testing.T) {
 		t.Errorf("ItemsLastUpdatedBefore() (-got, +want):\n%s", diff)
 	}
 }
+
+func TestDeleteLastUpdatedBefore(t *testing.T) {
+	c := cache.New[netip.Addr, string]()
+	t1 := time.Date(2022, time.December, 31, 10, 23, 0, 0, time.UTC)
+	t2 := t1.Add(time.Minute)
+	t3 := t2.Add(time.Minute)
+	c.Put(t1, netip.MustParseAddr("::ffff:127.0.0.1"), "entry1")
+	c.Put(t2, netip.MustParseAddr("::ffff:127.0.0.2"), "entry2")
+	c.Put(t3, netip.MustParseAddr("::ffff:127.0.0.3"), "entry3")
+
+	// Access does not change last update
+	c.Get(t3, netip.MustParseAddr("::ffff:127.0.0.1"))
+
+	if count := c.DeleteLastUpdatedBefore(t2); count != 1 {
+		t.Errorf("DeleteLastUpdatedBefore(): got %d, expected %d", count, 1)
+	}
+	expectCacheGet(t, c, "127.0.0.1", "", false)
+	expectCacheGet(t, c, "127.0.0.2", "entry2", true)
+	expectCacheGet(t, c, "127.0.0.3", "entry3", true)
+}
+
+func TestEntriesAndDeleteFunc(t *testing.T) {
+	c := cache.New[netip.Addr, string]()
+	t1 := time.Date(2022, time.December, 31, 10, 23, 0, 0, time.UTC)
+	t2 := t1.Add(time.Minute)
+	c.Put(t1, netip.MustParseAddr("::ffff:127.0.0.1"), "entry1")
+	c.Put(t2, netip.MustParseAddr("::ffff:127.0.0.2"), "entry2")
+	c.Put(t2, netip.MustParseAddr("::ffff:127.0.0.3"), "entry3")
+
+	got := c.Entries()
+	for k, v := range got {
+		v.LastUpdated = v.LastUpdated.UTC()
+		got[k] = v
+	}
+	expected := map[netip.Addr]cache.Entry[string]{
+		netip.MustParseAddr("::ffff:127.0.0.1"): {Object: "entry1", LastUpdated: t1},
+		netip.MustParseAddr("::ffff:127.0.0.2"): {Object: "entry2", LastUpdated: t2},
+		netip.MustParseAddr("::ffff:127.0.0.3"): {Object: "entry3", LastUpdated: t2},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("Entries() (-got, +want):\n%s", diff)
+	}
+
+	count := c.DeleteFunc(func(k netip.Addr) bool {
+		return k != netip.MustParseAddr("::ffff:127.0.0.2")
+	})
+	if count != 2 {
+		t.Errorf("DeleteFunc(): got %d, expected %d", count, 2)
+	}
+	expectCacheGet(t, c, "127.0.0.1", "", false)
+	expectCacheGet(t, c, "127.0.0.2", "entry2", true)
+	expectCacheGet(t, c, "127.0.0.3", "", false)
+}
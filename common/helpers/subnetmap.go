@@ -177,6 +177,10 @@ func SubnetMapUnmarshallerHook[V any]() mapstructure.DecodeHookFunc {
 				if err != nil {
 					return nil, fmt.Errorf("failed to parse key %s: %w", key, err)
 				}
+				if _, ok := output[key]; ok {
+					// Otherwise, the kept value would depend on the iteration order
+					return nil, fmt.Errorf("key %s overlaps with another key for the same subnet", k)
+				}
 				output[key] = v.Interface()
 			}
 		} else {
@@ -96,6 +96,13 @@ func TestSubnetMapUnmarshalHook(t *testing.T) {
 			Description: "Invalid IP",
 			Input:       gin.H{"200.33.300.1": "customer"},
 			Error:       true,
+		}, {
+			Description: "Same subnet twice",
+			Input: gin.H{
+				"192.0.2.0/24":         "customer1",
+				"::ffff:192.0.2.0/120": "customer2",
+			},
+			Error: true,
 		}, {
 			Description: "Random key",
 			Input:       gin.H{"kfgdjgkfj": "customer"},
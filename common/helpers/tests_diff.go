@@ -28,13 +28,15 @@ func formatByte(v interface{}) string {
 
 func defaultPrettyFormatters() map[reflect.Type]interface{} {
 	result := map[reflect.Type]interface{}{
-		reflect.TypeOf(net.IP{}):            fmt.Sprint,
-		reflect.TypeOf(netip.Addr{}):        fmt.Sprint,
-		reflect.TypeOf(time.Time{}):         fmt.Sprint,
-		reflect.TypeOf(SubnetMap[string]{}): fmt.Sprint,
-		reflect.TypeOf(SubnetMap[uint]{}):   fmt.Sprint,
-		reflect.TypeOf(SubnetMap[uint16]{}): fmt.Sprint,
-		reflect.TypeOf(byte(0)):             formatByte,
+		reflect.TypeOf(net.IP{}):                   fmt.Sprint,
+		reflect.TypeOf(netip.Addr{}):               fmt.Sprint,
+		reflect.TypeOf(time.Time{}):                fmt.Sprint,
+		reflect.TypeOf(SubnetMap[string]{}):        fmt.Sprint,
+		reflect.TypeOf(SubnetMap[int]{}):           fmt.Sprint,
+		reflect.TypeOf(SubnetMap[uint]{}):          fmt.Sprint,
+		reflect.TypeOf(SubnetMap[uint16]{}):        fmt.Sprint,
+		reflect.TypeOf(SubnetMap[time.Duration]{}): fmt.Sprint,
+		reflect.TypeOf(byte(0)):                    formatByte,
 	}
 	for t, fn := range nonDefaultPrettyFormatters {
 		result[t] = fn
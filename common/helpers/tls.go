@@ -9,6 +9,8 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sync"
+	"time"
 )
 
 // TLSConfiguration defines TLS configuration.
@@ -24,6 +26,8 @@ type TLSConfiguration struct {
 	CertFile string `validate:"required_with=KeyFile"`
 	// KeyFile tells the location of the user key if any.
 	KeyFile string
+	// ServerName overrides the name used to check the server certificate.
+	ServerName string
 }
 
 // MakeTLSConfig Create and *tls.Config from a TLSConfiguration.
@@ -34,6 +38,7 @@ func (config TLSConfiguration) MakeTLSConfig() (*tls.Config, error) {
 	}
 	tlsConfig := &tls.Config{
 		InsecureSkipVerify: !config.Verify,
+		ServerName:         config.ServerName,
 	}
 	// Read CA certificate if provided
 	if config.CAFile != "" {
@@ -60,3 +65,68 @@ func (config TLSConfiguration) MakeTLSConfig() (*tls.Config, error) {
 	}
 	return tlsConfig, nil
 }
+
+// CertificateReloader provides a client certificate and reloads it when the
+// certificate or the key file is modified.
+type CertificateReloader struct {
+	certFile string
+	keyFile  string
+
+	lock    sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewCertificateReloader creates a new certificate reloader for the provided
+// certificate and key files. If the key file is empty, the key is expected to
+// be in the certificate file. The certificate is loaded immediately.
+func NewCertificateReloader(certFile, keyFile string) (*CertificateReloader, error) {
+	if keyFile == "" {
+		keyFile = certFile
+	}
+	cr := &CertificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// reload loads the certificate if one of the files has been modified since
+// the last successful load. It should be called with the lock held or before
+// the reloader is shared.
+func (cr *CertificateReloader) reload() error {
+	var modTime time.Time
+	for _, file := range []string{cr.certFile, cr.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("cannot read user certificate: %w", err)
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	if cr.cert != nil && modTime.Equal(cr.modTime) {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("cannot read user certificate: %w", err)
+	}
+	cr.cert = &cert
+	cr.modTime = modTime
+	return nil
+}
+
+// GetClientCertificate returns the current client certificate. It is
+// suitable for tls.Config.GetClientCertificate. When the certificate cannot
+// be reloaded, the previous one is returned. This way, a certificate and a
+// key not updated at the same time do not break new connections.
+func (cr *CertificateReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+	cr.reload()
+	return cr.cert, nil
+}
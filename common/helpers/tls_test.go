@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package helpers_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"akvorado/common/helpers"
+)
+
+// writeCertificate writes a self-signed certificate with the provided serial
+// number and its key to the provided path.
+func writeCertificate(t *testing.T, path string, serial int64) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error:\n%+v", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error:\n%+v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey() error:\n%+v", err)
+	}
+	content := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	content = append(content, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})...)
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatalf("WriteFile() error:\n%+v", err)
+	}
+}
+
+func TestMakeTLSConfig(t *testing.T) {
+	config, err := helpers.TLSConfiguration{
+		Enable:     true,
+		Verify:     true,
+		ServerName: "clickhouse.example.com",
+	}.MakeTLSConfig()
+	if err != nil {
+		t.Fatalf("MakeTLSConfig() error:\n%+v", err)
+	}
+	if config.ServerName != "clickhouse.example.com" {
+		t.Errorf("MakeTLSConfig().ServerName == %q, expected %q",
+			config.ServerName, "clickhouse.example.com")
+	}
+	if config.InsecureSkipVerify {
+		t.Error("MakeTLSConfig().InsecureSkipVerify == true, expected false")
+	}
+}
+
+func TestCertificateReloader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cert.pem")
+	if _, err := helpers.NewCertificateReloader(path, ""); err == nil {
+		t.Fatal("NewCertificateReloader() did not error")
+	}
+
+	writeCertificate(t, path, 1)
+	cr, err := helpers.NewCertificateReloader(path, "")
+	if err != nil {
+		t.Fatalf("NewCertificateReloader() error:\n%+v", err)
+	}
+	serial := func() int64 {
+		t.Helper()
+		cert, err := cr.GetClientCertificate(nil)
+		if err != nil {
+			t.Fatalf("GetClientCertificate() error:\n%+v", err)
+		}
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatalf("ParseCertificate() error:\n%+v", err)
+		}
+		return parsed.SerialNumber.Int64()
+	}
+	if got := serial(); got != 1 {
+		t.Fatalf("GetClientCertificate() serial == %d, expected 1", got)
+	}
+
+	// Rotate the certificate
+	writeCertificate(t, path, 2)
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatalf("Chtimes() error:\n%+v", err)
+	}
+	if got := serial(); got != 2 {
+		t.Fatalf("GetClientCertificate() serial == %d, expected 2", got)
+	}
+
+	// Corrupt the certificate, the previous one should be kept
+	if err := os.WriteFile(path, []byte("garbage"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error:\n%+v", err)
+	}
+	future = future.Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatalf("Chtimes() error:\n%+v", err)
+	}
+	if got := serial(); got != 2 {
+		t.Fatalf("GetClientCertificate() serial == %d, expected 2", got)
+	}
+}
@@ -61,8 +61,11 @@ func isListen(fl validator.FieldLevel) bool {
 		return false
 	}
 
-	// If host is specified, it should match a DNS name
+	// If host is specified, it should be an IP address or match a DNS name
 	if host != "" {
+		if _, err := netip.ParseAddr(host); err == nil {
+			return true
+		}
 		return Validate.Var(host, "hostname_rfc1123") == nil
 	}
 	return true
@@ -26,10 +26,14 @@ func TestListenValidator(t *testing.T) {
 		{helpers.Mark(), ":161", false},
 		{helpers.Mark(), ":0", false},
 		{helpers.Mark(), "127.0.0.1:0", false},
+		{helpers.Mark(), "[::]:2055", false},
+		{helpers.Mark(), "[2001:db8::1]:2055", false},
+		{helpers.Mark(), "[::1]:0", false},
 		{helpers.Mark(), "localhost", true},
 		{helpers.Mark(), "127.0.0.1", true},
 		{helpers.Mark(), "127.0.0.1:what", true},
 		{helpers.Mark(), "127.0.0.1:100000", true},
+		{helpers.Mark(), "::1:2055", true},
 	}
 	for _, tc := range cases {
 		s.Listen = tc.Listen
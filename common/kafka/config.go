@@ -10,15 +10,20 @@ import (
 	"crypto/sha512"
 	"errors"
 	"fmt"
+	"slices"
 
 	"akvorado/common/helpers"
 	"akvorado/common/helpers/bimap"
+	"akvorado/common/reporter"
 
 	"github.com/IBM/sarama"
 )
 
 // Configuration defines how we connect to a Kafka cluster.
 type Configuration struct {
+	// Disable tells to not use Kafka. The inlet inserts flows directly into
+	// ClickHouse instead.
+	Disable bool
 	// Topic defines the topic to write flows to.
 	Topic string `validate:"required"`
 	// Brokers is the list of brokers to connect to.
@@ -27,6 +32,46 @@ type Configuration struct {
 	Version Version
 	// TLS defines TLS configuration
 	TLS TLSAndSASLConfiguration
+	// TopicRules routes flows to additional topics. The first matching rule
+	// wins. Flows not matching any rule are sent to the main topic.
+	TopicRules []TopicRule `validate:"dive"`
+}
+
+// TopicRule defines a rule to route flows to an additional topic.
+type TopicRule struct {
+	// Condition is an expression on flow fields. It is compiled by the
+	// inlet.
+	Condition string `validate:"required"`
+	// Suffix is appended to the main topic name.
+	Suffix string `validate:"required"`
+	// ConfigEntries overrides the topic configuration entries for this topic.
+	ConfigEntries map[string]*string
+}
+
+// TopicName returns the name of the topic for the provided suffix (empty for
+// the main topic) and schema hash (empty when the topic does not depend on
+// the schema).
+func (config Configuration) TopicName(suffix string, hash string) string {
+	name := config.Topic
+	if suffix != "" {
+		name = fmt.Sprintf("%s-%s", name, suffix)
+	}
+	if hash != "" {
+		name = fmt.Sprintf("%s-%s", name, hash)
+	}
+	return name
+}
+
+// TopicSuffixes returns the list of distinct topic suffixes used by the topic
+// rules, in order of appearance.
+func (config Configuration) TopicSuffixes() []string {
+	suffixes := []string{}
+	for _, rule := range config.TopicRules {
+		if !slices.Contains(suffixes, rule.Suffix) {
+			suffixes = append(suffixes, rule.Suffix)
+		}
+	}
+	return suffixes
 }
 
 // TLSAndSASLConfiguration defines TLS configuration.
@@ -38,6 +83,19 @@ type TLSAndSASLConfiguration struct {
 	SASLPassword string `validate:"required_with=SASLAlgorithm SASLUsername"`
 	// SASLMechanism tells the SASL algorithm
 	SASLMechanism SASLMechanism `validate:"required_with=SASLUsername"`
+	// SASLOAuthTokenURL is the token endpoint for the OAuth2 client
+	// credentials flow. SASLUsername and SASLPassword are used as client ID
+	// and client secret.
+	SASLOAuthTokenURL string `validate:"omitempty,url"`
+	// SASLOAuthScopes are the scopes to request with the OAuth2 client
+	// credentials flow.
+	SASLOAuthScopes []string
+	// SASLOAuthTokenCommand is a command to execute to get an OAuth token. The
+	// token is expected on the standard output.
+	SASLOAuthTokenCommand []string
+	// SASLOAuthMSKIAMRegion enables the AWS MSK IAM signer for the provided
+	// region.
+	SASLOAuthMSKIAMRegion string
 }
 
 // DefaultConfiguration represents the default configuration for connecting to Kafka.
@@ -90,6 +148,8 @@ const (
 	SASLSCRAMSHA256
 	// SASLSCRAMSHA512 enables SCRAM challenge with SHA512
 	SASLSCRAMSHA512
+	// SASLOAuth enables OAUTHBEARER with a token provider
+	SASLOAuth
 )
 
 var saslAlgorithmMap = bimap.New(map[SASLMechanism]string{
@@ -97,6 +157,7 @@ var saslAlgorithmMap = bimap.New(map[SASLMechanism]string{
 	SASLPlainText:   "plain",
 	SASLSCRAMSHA256: "scram-sha256",
 	SASLSCRAMSHA512: "scram-sha512",
+	SASLOAuth:       "oauth",
 })
 
 // MarshalText turns a SASL algorithm to text
@@ -129,7 +190,7 @@ func (sa *SASLMechanism) UnmarshalText(input []byte) error {
 }
 
 // NewConfig returns a Sarama Kafka configuration ready to use.
-func NewConfig(config Configuration) (*sarama.Config, error) {
+func NewConfig(r *reporter.Reporter, config Configuration) (*sarama.Config, error) {
 	kafkaConfig := sarama.NewConfig()
 	kafkaConfig.Version = sarama.KafkaVersion(config.Version)
 	kafkaConfig.ClientID = fmt.Sprintf("akvorado-%s", helpers.AkvoradoVersion)
@@ -141,7 +202,16 @@ func NewConfig(config Configuration) (*sarama.Config, error) {
 		kafkaConfig.Net.TLS.Enable = true
 		kafkaConfig.Net.TLS.Config = tlsConfig
 		// SASL
-		if config.TLS.SASLUsername != "" {
+		if config.TLS.SASLMechanism == SASLOAuth {
+			tokenProvider, err := newTokenProvider(r, config.TLS)
+			if err != nil {
+				return nil, err
+			}
+			kafkaConfig.Net.SASL.Enable = true
+			kafkaConfig.Net.SASL.Handshake = true
+			kafkaConfig.Net.SASL.Mechanism = sarama.SASLTypeOAuth
+			kafkaConfig.Net.SASL.TokenProvider = tokenProvider
+		} else if config.TLS.SASLUsername != "" {
 			kafkaConfig.Net.SASL.Enable = true
 			kafkaConfig.Net.SASL.User = config.TLS.SASLUsername
 			kafkaConfig.Net.SASL.Password = config.TLS.SASLPassword
@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"akvorado/common/helpers"
+	"akvorado/common/reporter"
 
 	"github.com/IBM/sarama"
 	"github.com/gin-gonic/gin"
@@ -62,11 +63,47 @@ func TestKafkaNewConfig(t *testing.T) {
 					SASLMechanism: SASLSCRAMSHA512,
 				},
 			},
+		}, {
+			description: "SASL OAuth client credentials",
+			config: Configuration{
+				TLS: TLSAndSASLConfiguration{
+					TLSConfiguration: helpers.TLSConfiguration{
+						Enable: true,
+					},
+					SASLUsername:      "hello",
+					SASLPassword:      "password",
+					SASLMechanism:     SASLOAuth,
+					SASLOAuthTokenURL: "https://auth.example.com/token",
+					SASLOAuthScopes:   []string{"kafka"},
+				},
+			},
+		}, {
+			description: "SASL OAuth token command",
+			config: Configuration{
+				TLS: TLSAndSASLConfiguration{
+					TLSConfiguration: helpers.TLSConfiguration{
+						Enable: true,
+					},
+					SASLMechanism:         SASLOAuth,
+					SASLOAuthTokenCommand: []string{"echo", "token"},
+				},
+			},
+		}, {
+			description: "SASL OAuth MSK IAM",
+			config: Configuration{
+				TLS: TLSAndSASLConfiguration{
+					TLSConfiguration: helpers.TLSConfiguration{
+						Enable: true,
+					},
+					SASLMechanism:         SASLOAuth,
+					SASLOAuthMSKIAMRegion: "eu-west-3",
+				},
+			},
 		},
 	}
 	for _, tc := range cases {
 		t.Run(tc.description, func(t *testing.T) {
-			kafkaConfig, err := NewConfig(tc.config)
+			kafkaConfig, err := NewConfig(reporter.NewMock(t), tc.config)
 			if err != nil {
 				t.Fatalf("NewConfig() error:\n%+v", err)
 			}
@@ -77,6 +114,36 @@ func TestKafkaNewConfig(t *testing.T) {
 	}
 }
 
+func TestKafkaNewConfigOAuthErrors(t *testing.T) {
+	cases := []struct {
+		description string
+		config      TLSAndSASLConfiguration
+	}{
+		{
+			description: "no token source",
+			config: TLSAndSASLConfiguration{
+				TLSConfiguration: helpers.TLSConfiguration{Enable: true},
+				SASLMechanism:    SASLOAuth,
+			},
+		}, {
+			description: "two token sources",
+			config: TLSAndSASLConfiguration{
+				TLSConfiguration:      helpers.TLSConfiguration{Enable: true},
+				SASLMechanism:         SASLOAuth,
+				SASLOAuthTokenURL:     "https://auth.example.com/token",
+				SASLOAuthMSKIAMRegion: "eu-west-3",
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			if _, err := NewConfig(reporter.NewMock(t), Configuration{TLS: tc.config}); err == nil {
+				t.Fatal("NewConfig() did not error")
+			}
+		})
+	}
+}
+
 func TestTLSConfiguration(t *testing.T) {
 	helpers.TestConfigurationDecode(t, helpers.ConfigurationDecodeCases{
 		{
@@ -161,6 +228,37 @@ func TestTLSConfiguration(t *testing.T) {
 					SASLMechanism: SASLSCRAMSHA256,
 				},
 			},
+		}, {
+			Description: "TLS SASL OAuth",
+			Initial:     func() interface{} { return DefaultConfiguration() },
+			Configuration: func() interface{} {
+				return gin.H{
+					"tls": gin.H{
+						"enable":               true,
+						"sasl-username":        "hello",
+						"sasl-password":        "bye",
+						"sasl-mechanism":       "oauth",
+						"sasl-oauth-token-url": "https://auth.example.com/token",
+						"sasl-oauth-scopes":    []string{"kafka", "akvorado"},
+					},
+				}
+			},
+			Expected: Configuration{
+				Topic:   "flows",
+				Brokers: []string{"127.0.0.1:9092"},
+				Version: Version(sarama.V2_8_1_0),
+				TLS: TLSAndSASLConfiguration{
+					TLSConfiguration: helpers.TLSConfiguration{
+						Enable: true,
+						Verify: true,
+					},
+					SASLUsername:      "hello",
+					SASLPassword:      "bye",
+					SASLMechanism:     SASLOAuth,
+					SASLOAuthTokenURL: "https://auth.example.com/token",
+					SASLOAuthScopes:   []string{"kafka", "akvorado"},
+				},
+			},
 		},
 	})
 }
@@ -168,3 +266,25 @@ func TestTLSConfiguration(t *testing.T) {
 func TestMarshalUnmarshal(t *testing.T) {
 	saslAlgorithmMap.TestMarshalUnmarshal(t)
 }
+
+func TestTopicNames(t *testing.T) {
+	config := DefaultConfiguration()
+	config.TopicRules = []TopicRule{
+		{Condition: "true", Suffix: "ix"},
+		{Condition: "false", Suffix: "transit"},
+		{Condition: "false", Suffix: "ix"},
+	}
+	if diff := helpers.Diff(config.TopicSuffixes(), []string{"ix", "transit"}); diff != "" {
+		t.Fatalf("TopicSuffixes() (-got, +want):\n%s", diff)
+	}
+	got := []string{
+		config.TopicName("", ""),
+		config.TopicName("", "hash"),
+		config.TopicName("ix", ""),
+		config.TopicName("ix", "hash"),
+	}
+	expected := []string{"flows", "flows-hash", "flows-ix", "flows-ix-hash"}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("TopicName() (-got, +want):\n%s", diff)
+	}
+}
@@ -0,0 +1,109 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package kafka
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"akvorado/common/helpers"
+)
+
+const (
+	mskIAMService   = "kafka-cluster"
+	mskIAMAction    = "kafka-cluster:Connect"
+	mskIAMExpires   = 15 * time.Minute
+	mskIAMAlgorithm = "AWS4-HMAC-SHA256"
+)
+
+// mskIAMFetcher returns a fetcher building tokens for AWS MSK IAM
+// authentication. A token is a presigned URL (AWS signature version 4) for
+// the "kafka-cluster:Connect" action, encoded in base64. If no access key is
+// provided, credentials are read from the AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY, and AWS_SESSION_TOKEN environment variables.
+func mskIAMFetcher(region, accessKey, secretKey string, now func() time.Time) tokenFetcher {
+	return func(context.Context) (string, time.Time, error) {
+		key, secret, sessionToken := accessKey, secretKey, ""
+		if key == "" {
+			key = os.Getenv("AWS_ACCESS_KEY_ID")
+			secret = os.Getenv("AWS_SECRET_ACCESS_KEY")
+			sessionToken = os.Getenv("AWS_SESSION_TOKEN")
+		}
+		if key == "" || secret == "" {
+			return "", time.Time{}, errors.New("no AWS credentials available")
+		}
+		signTime := now().UTC()
+		token := mskIAMToken(region, key, secret, sessionToken, signTime)
+		return token, signTime.Add(mskIAMExpires), nil
+	}
+}
+
+// mskIAMToken builds an AWS MSK IAM token.
+func mskIAMToken(region, accessKey, secretKey, sessionToken string, signTime time.Time) string {
+	host := fmt.Sprintf("kafka.%s.amazonaws.com", region)
+	amzDate := signTime.Format("20060102T150405Z")
+	date := signTime.Format("20060102")
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", date, region, mskIAMService)
+
+	query := url.Values{}
+	query.Set("Action", mskIAMAction)
+	query.Set("X-Amz-Algorithm", mskIAMAlgorithm)
+	query.Set("X-Amz-Credential", fmt.Sprintf("%s/%s", accessKey, scope))
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", fmt.Sprintf("%d", int(mskIAMExpires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	if sessionToken != "" {
+		query.Set("X-Amz-Security-Token", sessionToken)
+	}
+	canonicalQuery := awsQueryEncode(query)
+
+	emptyPayload := sha256.Sum256(nil)
+	canonicalRequest := strings.Join([]string{
+		"GET",
+		"/",
+		canonicalQuery,
+		fmt.Sprintf("host:%s\n", host),
+		"host",
+		hex.EncodeToString(emptyPayload[:]),
+	}, "\n")
+	hashedRequest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		mskIAMAlgorithm,
+		amzDate,
+		scope,
+		hex.EncodeToString(hashedRequest[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, mskIAMService)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	query.Set("X-Amz-Signature", signature)
+	query.Set("User-Agent", fmt.Sprintf("akvorado/%s", helpers.AkvoradoVersion))
+	signedURL := fmt.Sprintf("https://%s/?%s", host, awsQueryEncode(query))
+	return base64.RawURLEncoding.EncodeToString([]byte(signedURL))
+}
+
+// awsQueryEncode encodes a query string as expected by AWS signature version
+// 4: keys are sorted and spaces are encoded as %20.
+func awsQueryEncode(query url.Values) string {
+	return strings.ReplaceAll(query.Encode(), "+", "%20")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
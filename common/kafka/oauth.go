@@ -0,0 +1,204 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"golang.org/x/oauth2/clientcredentials"
+
+	"akvorado/common/reporter"
+)
+
+const (
+	// tokenFetchTimeout is the maximum time to get a new token.
+	tokenFetchTimeout = 10 * time.Second
+	// tokenDefaultLifetime is the lifetime assumed for tokens without an
+	// expiration time.
+	tokenDefaultLifetime = 15 * time.Minute
+)
+
+// tokenFetcher returns a new token with its expiration time. A zero
+// expiration time means the expiration is unknown.
+type tokenFetcher func(ctx context.Context) (string, time.Time, error)
+
+// tokenProvider implements sarama.AccessTokenProvider. Tokens are cached and
+// refreshed when 80% of their lifetime has elapsed. Sarama only asks for a
+// token when opening a new connection to a broker, therefore existing
+// connections are not affected by a refresh. When a refresh fails, the
+// current token is used until it expires. After that, an error is returned
+// and Sarama will retry later to connect to the broker.
+type tokenProvider struct {
+	r      *reporter.Reporter
+	fetch  tokenFetcher
+	method string
+	now    func() time.Time
+
+	lock      sync.Mutex
+	token     string
+	expiry    time.Time
+	refreshAt time.Time
+
+	metrics struct {
+		refreshes     *reporter.CounterVec
+		refreshErrors *reporter.CounterVec
+		expiry        *reporter.GaugeVec
+	}
+}
+
+// newTokenProvider creates a new token provider from the provided
+// configuration. Exactly one source of tokens should be configured.
+func newTokenProvider(r *reporter.Reporter, config TLSAndSASLConfiguration) (*tokenProvider, error) {
+	p := tokenProvider{
+		r:   r,
+		now: time.Now,
+	}
+	sources := 0
+	if config.SASLOAuthTokenURL != "" {
+		sources++
+		p.method = "client-credentials"
+		p.fetch = clientCredentialsFetcher(config)
+	}
+	if len(config.SASLOAuthTokenCommand) > 0 {
+		sources++
+		p.method = "command"
+		p.fetch = commandFetcher(config.SASLOAuthTokenCommand)
+	}
+	if config.SASLOAuthMSKIAMRegion != "" {
+		sources++
+		p.method = "msk-iam"
+		p.fetch = mskIAMFetcher(config.SASLOAuthMSKIAMRegion,
+			config.SASLUsername, config.SASLPassword, p.now)
+	}
+	if sources != 1 {
+		return nil, errors.New("exactly one of token URL, token command, or MSK IAM region should be provided for SASL OAuth")
+	}
+
+	p.metrics.refreshes = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "sasl_oauth_token_refreshes_total",
+			Help: "Number of successful SASL OAuth token refreshes.",
+		},
+		[]string{"method"},
+	)
+	p.metrics.refreshErrors = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "sasl_oauth_token_refresh_errors_total",
+			Help: "Number of failed SASL OAuth token refreshes.",
+		},
+		[]string{"method"},
+	)
+	p.metrics.expiry = r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "sasl_oauth_token_expiry_seconds",
+			Help: "Expiration time of the current SASL OAuth token as a Unix timestamp.",
+		},
+		[]string{"method"},
+	)
+	return &p, nil
+}
+
+// Token returns a token, refreshing it if needed.
+func (p *tokenProvider) Token() (*sarama.AccessToken, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	now := p.now()
+	if p.token != "" && now.Before(p.refreshAt) {
+		return &sarama.AccessToken{Token: p.token}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), tokenFetchTimeout)
+	defer cancel()
+	token, expiry, err := p.fetch(ctx)
+	if err != nil {
+		p.metrics.refreshErrors.WithLabelValues(p.method).Inc()
+		if p.token != "" && now.Before(p.expiry) {
+			p.r.Warn().Err(err).Str("method", p.method).
+				Msg("cannot refresh SASL OAuth token, keep using the current one")
+			return &sarama.AccessToken{Token: p.token}, nil
+		}
+		p.r.Err(err).Str("method", p.method).Msg("cannot get SASL OAuth token")
+		return nil, fmt.Errorf("cannot get SASL OAuth token: %w", err)
+	}
+	if expiry.IsZero() {
+		expiry = now.Add(tokenDefaultLifetime)
+	}
+	p.token = token
+	p.expiry = expiry
+	p.refreshAt = now.Add(expiry.Sub(now) * 8 / 10)
+	p.metrics.refreshes.WithLabelValues(p.method).Inc()
+	p.metrics.expiry.WithLabelValues(p.method).Set(float64(expiry.Unix()))
+	return &sarama.AccessToken{Token: token}, nil
+}
+
+// clientCredentialsFetcher returns a fetcher using the OAuth2 client
+// credentials flow.
+func clientCredentialsFetcher(config TLSAndSASLConfiguration) tokenFetcher {
+	ccConfig := clientcredentials.Config{
+		ClientID:     config.SASLUsername,
+		ClientSecret: config.SASLPassword,
+		TokenURL:     config.SASLOAuthTokenURL,
+		Scopes:       config.SASLOAuthScopes,
+	}
+	return func(ctx context.Context) (string, time.Time, error) {
+		token, err := ccConfig.Token(ctx)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		return token.AccessToken, token.Expiry, nil
+	}
+}
+
+// commandFetcher returns a fetcher executing the provided command. If the
+// token is a JWT, its expiration time is extracted from the "exp" claim.
+func commandFetcher(command []string) tokenFetcher {
+	return func(ctx context.Context) (string, time.Time, error) {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			if stderr.Len() > 0 {
+				return "", time.Time{}, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+			}
+			return "", time.Time{}, err
+		}
+		token := strings.TrimSpace(stdout.String())
+		if token == "" {
+			return "", time.Time{}, errors.New("empty token returned by command")
+		}
+		return token, jwtExpiry(token), nil
+	}
+}
+
+// jwtExpiry returns the expiration time of a JWT. If the token is not a JWT or
+// has no expiration time, a zero time is returned. The signature is not
+// checked.
+func jwtExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
@@ -2,7 +2,7 @@
 // SPDX-License-Identifier: AGPL-3.0-only
 
 // Package remotedatasourcefetcher offers a component to refresh internal data periodically
-// from a set of remote HTTP sources in JSON format.
+// from a set of remote HTTP sources in JSON, YAML, or CSV format.
 package remotedatasourcefetcher
 
 import (
@@ -16,8 +16,10 @@ import (
 // RemoteDataSource defines a remote network definition.
 type RemoteDataSource struct {
 	// URL is the URL to fetch to get remote network definition.
-	// It should provide a JSON file.
+	// It should provide a file in the configured format.
 	URL string `validate:"url"`
+	// Format is the format of the remote data (json, yaml, or csv)
+	Format string `validate:"omitempty,oneof=json yaml csv"`
 	// Method defines which method to use (GET or POST)
 	Method string `validate:"oneof=GET POST"`
 	// Headers defines additional headers to send
@@ -26,8 +28,9 @@ type RemoteDataSource struct {
 	Proxy bool
 	// Timeout tells the maximum time the remote request should take
 	Timeout time.Duration `validate:"min=1s"`
-	// Transform is a jq string to transform the received JSON
-	// data into a list of network attributes.
+	// Transform is a jq string to transform the received data into a
+	// list of network attributes. CSV data is provided as a list of
+	// objects using the header row as keys.
 	Transform TransformQuery
 	// Interval tells how much time to wait before updating the source.
 	Interval time.Duration `validate:"min=1m"`
@@ -65,6 +68,7 @@ func (jq TransformQuery) MarshalText() ([]byte, error) {
 func DefaultRemoteDataSourceConfiguration() RemoteDataSource {
 	return RemoteDataSource{
 		Method:  "GET",
+		Format:  "json",
 		Timeout: time.Minute,
 	}
 }
@@ -28,6 +28,7 @@ func TestRemoteDataSourceDecode(t *testing.T) {
 			Expected: RemoteDataSource{
 				URL:      "https://example.net",
 				Method:   "GET",
+				Format:   "json",
 				Timeout:  time.Minute,
 				Interval: 10 * time.Minute,
 			},
@@ -44,6 +45,7 @@ func TestRemoteDataSourceDecode(t *testing.T) {
 			Expected: RemoteDataSource{
 				URL:       "https://example.net",
 				Method:    "GET",
+				Format:    "json",
 				Timeout:   time.Minute,
 				Interval:  10 * time.Minute,
 				Transform: MustParseTransformQuery(".[]"),
@@ -63,6 +65,7 @@ func TestRemoteDataSourceDecode(t *testing.T) {
 			Expected: RemoteDataSource{
 				URL:       "https://example.net",
 				Method:    "POST",
+				Format:    "json",
 				Timeout:   2 * time.Minute,
 				Interval:  10 * time.Minute,
 				Transform: MustParseTransformQuery(".[]"),
@@ -82,12 +85,32 @@ func TestRemoteDataSourceDecode(t *testing.T) {
 			Expected: RemoteDataSource{
 				URL:      "https://example.net",
 				Method:   "GET",
+				Format:   "json",
 				Timeout:  time.Minute,
 				Interval: 10 * time.Minute,
 				Transform: MustParseTransformQuery(`
 .prefixes[] | {prefix: .ip_prefix, tenant: "amazon", region: .region, role: .service|ascii_downcase}
 `),
 			},
+		}, {
+			Description: "CSV format",
+			Initial:     func() interface{} { return RemoteDataSource{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"url":       "https://example.net/networks.csv",
+					"format":    "csv",
+					"interval":  "10m",
+					"transform": ".[]",
+				}
+			},
+			Expected: RemoteDataSource{
+				URL:       "https://example.net/networks.csv",
+				Method:    "GET",
+				Format:    "csv",
+				Timeout:   time.Minute,
+				Interval:  10 * time.Minute,
+				Transform: MustParseTransformQuery(".[]"),
+			},
 		}, {
 			Description: "Incorrect transform",
 			Initial:     func() interface{} { return RemoteDataSource{} },
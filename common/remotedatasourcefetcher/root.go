@@ -6,10 +6,14 @@ package remotedatasourcefetcher
 import (
 	"bufio"
 	"context"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,6 +21,7 @@ import (
 	"github.com/itchyny/gojq"
 	"github.com/mitchellh/mapstructure"
 	"gopkg.in/tomb.v2"
+	"gopkg.in/yaml.v3"
 
 	"akvorado/common/reporter"
 )
@@ -33,6 +38,10 @@ type Component[T interface{}] struct {
 	dataSources map[string]RemoteDataSource
 	metrics     metrics
 
+	refreshChannels map[string]chan bool
+	failuresLock    sync.Mutex
+	failures        map[string]error
+
 	DataSourcesReady chan bool // closed when all data sources are ready
 }
 
@@ -44,6 +53,12 @@ func New[T interface{}](r *reporter.Reporter, provider ProviderFunc, dataType st
 		dataType:         dataType,
 		dataSources:      dataSources,
 		DataSourcesReady: make(chan bool),
+
+		refreshChannels: make(map[string]chan bool, len(dataSources)),
+		failures:        make(map[string]error),
+	}
+	for name := range dataSources {
+		c.refreshChannels[name] = make(chan bool, 1)
 	}
 	c.initMetrics()
 	return &c, nil
@@ -64,7 +79,14 @@ func (c *Component[T]) Fetch(ctx context.Context, name string, source RemoteData
 	for headerName, headerValue := range source.Headers {
 		req.Header.Set(headerName, headerValue)
 	}
-	req.Header.Set("accept", "application/json")
+	switch source.Format {
+	case "yaml":
+		req.Header.Set("accept", "application/yaml")
+	case "csv":
+		req.Header.Set("accept", "text/csv")
+	default:
+		req.Header.Set("accept", "application/json")
+	}
 	if err != nil {
 		l.Err(err).Msg("unable to build new request")
 		return results, fmt.Errorf("unable to build new request: %w", err)
@@ -81,11 +103,10 @@ func (c *Component[T]) Fetch(ctx context.Context, name string, source RemoteData
 		return results, err
 	}
 	reader := bufio.NewReader(resp.Body)
-	decoder := json.NewDecoder(reader)
-	var got interface{}
-	if err := decoder.Decode(&got); err != nil {
-		l.Err(err).Msg("cannot decode JSON output")
-		return results, fmt.Errorf("cannot decode JSON output: %w", err)
+	got, err := decode(reader, source.Format)
+	if err != nil {
+		l.Err(err).Msg("cannot decode output")
+		return results, fmt.Errorf("cannot decode output: %w", err)
 	}
 
 	iter := source.Transform.Query.RunWithContext(ctx, got)
@@ -103,6 +124,8 @@ func (c *Component[T]) Fetch(ctx context.Context, name string, source RemoteData
 			Metadata:   nil,
 			Result:     &result,
 			DecodeHook: mapstructure.TextUnmarshallerHookFunc(),
+			// CSV only provides strings
+			WeaklyTypedInput: source.Format == "csv",
 		}
 		decoder, err := mapstructure.NewDecoder(config)
 		if err != nil {
@@ -122,9 +145,80 @@ func (c *Component[T]) Fetch(ctx context.Context, name string, source RemoteData
 	return results, nil
 }
 
+// decode decodes the received data using the provided format. CSV data is
+// turned into a list of objects using the header row as keys.
+func decode(reader io.Reader, format string) (interface{}, error) {
+	var got interface{}
+	switch format {
+	case "yaml":
+		if err := yaml.NewDecoder(reader).Decode(&got); err != nil {
+			return nil, fmt.Errorf("cannot decode YAML: %w", err)
+		}
+	case "csv":
+		records, err := csv.NewReader(reader).ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode CSV: %w", err)
+		}
+		if len(records) == 0 {
+			return nil, errors.New("cannot decode CSV: missing header")
+		}
+		header := records[0]
+		rows := make([]interface{}, 0, len(records)-1)
+		for _, record := range records[1:] {
+			row := make(map[string]interface{}, len(header))
+			for idx, key := range header {
+				if idx < len(record) && record[idx] != "" {
+					row[key] = record[idx]
+				}
+			}
+			rows = append(rows, row)
+		}
+		got = rows
+	default:
+		if err := json.NewDecoder(reader).Decode(&got); err != nil {
+			return nil, fmt.Errorf("cannot decode JSON: %w", err)
+		}
+	}
+	return got, nil
+}
+
+// Refresh triggers an immediate update of all data sources.
+func (c *Component[T]) Refresh() {
+	for _, ch := range c.refreshChannels {
+		select {
+		case ch <- true:
+		default:
+		}
+	}
+}
+
+// healthcheck reports a warning when the last update of a data source failed.
+// In this case, the previous data is still used.
+func (c *Component[T]) healthcheck(_ context.Context) reporter.HealthcheckResult {
+	c.failuresLock.Lock()
+	defer c.failuresLock.Unlock()
+	if len(c.failures) == 0 {
+		return reporter.HealthcheckResult{Status: reporter.HealthcheckOK, Reason: "ok"}
+	}
+	names := make([]string, 0, len(c.failures))
+	for name := range c.failures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	reasons := make([]string, 0, len(names))
+	for _, name := range names {
+		reasons = append(reasons, fmt.Sprintf("%s: %s", name, c.failures[name]))
+	}
+	return reporter.HealthcheckResult{
+		Status: reporter.HealthcheckWarning,
+		Reason: strings.Join(reasons, ", "),
+	}
+}
+
 // Start the remote data source fetcher component.
 func (c *Component[T]) Start() error {
 	c.r.Info().Msg("starting remote data source fetcher component")
+	c.r.RegisterHealthcheck(fmt.Sprintf("remotedatasourcefetcher/%s", c.dataType), c.healthcheck)
 
 	var notReadySources sync.WaitGroup
 	notReadySources.Add(len(c.dataSources))
@@ -135,8 +229,13 @@ func (c *Component[T]) Start() error {
 
 	for name, source := range c.dataSources {
 		if source.Transform.Query == nil {
-			source.Transform.Query, _ = gojq.Parse(".")
+			if source.Format == "csv" {
+				source.Transform.Query, _ = gojq.Parse(".[]")
+			} else {
+				source.Transform.Query, _ = gojq.Parse(".")
+			}
 		}
+		refreshChannel := c.refreshChannels[name]
 
 		c.t.Go(func() error {
 			c.metrics.remoteDataSourceCount.WithLabelValues(c.dataType, name).Set(0)
@@ -172,12 +271,16 @@ func (c *Component[T]) Start() error {
 				ctx, cancel := context.WithTimeout(c.t.Context(nil), source.Timeout)
 				count, err := c.provider(ctx, name, source)
 				cancel()
+				c.failuresLock.Lock()
 				if err == nil {
 					c.metrics.remoteDataSourceUpdates.WithLabelValues(c.dataType, name).Inc()
 					c.metrics.remoteDataSourceCount.WithLabelValues(c.dataType, name).Set(float64(count))
+					delete(c.failures, name)
 				} else {
 					c.metrics.remoteDataSourceErrors.WithLabelValues(c.dataType, name, err.Error()).Inc()
+					c.failures[name] = err
 				}
+				c.failuresLock.Unlock()
 				if err == nil && !ready {
 					ready = true
 					notReadySources.Done()
@@ -202,6 +305,8 @@ func (c *Component[T]) Start() error {
 					return nil
 				case <-retryTicker.C:
 				case <-regularTicker.C:
+				case <-refreshChannel:
+					c.r.Debug().Str("name", name).Msg("refresh requested")
 				}
 			}
 		})
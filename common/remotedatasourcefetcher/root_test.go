@@ -131,3 +131,103 @@ func TestRemoteDataSourceFetcher(t *testing.T) {
 	// We now should be able to resolve our remote data from remote source
 
 }
+
+type csvData struct {
+	Prefix string
+	ASN    uint32
+}
+
+func TestRemoteDataSourceFetcherCSV(t *testing.T) {
+	var requestsLock sync.Mutex
+	requests := 0
+	failing := false
+	mux := http.NewServeMux()
+	mux.Handle("/data.csv", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requestsLock.Lock()
+		defer requestsLock.Unlock()
+		requests++
+		if failing {
+			w.WriteHeader(500)
+			return
+		}
+		w.Header().Add("Content-Type", "text/csv")
+		w.WriteHeader(200)
+		w.Write([]byte("prefix,asn\n192.0.2.0/24,64501\n198.51.100.0/24,64502\n"))
+	}))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error:\n%+v", err)
+	}
+	server := &http.Server{
+		Addr:    listener.Addr().String(),
+		Handler: mux,
+	}
+	go server.Serve(listener)
+	defer server.Shutdown(context.Background())
+
+	r := reporter.NewMock(t)
+	config := map[string]RemoteDataSource{
+		"local": {
+			URL:      fmt.Sprintf("http://%s/data.csv", listener.Addr()),
+			Method:   "GET",
+			Format:   "csv",
+			Timeout:  time.Second,
+			Interval: time.Hour,
+		},
+	}
+	var dataLock sync.Mutex
+	var data []csvData
+	var fetcher *Component[csvData]
+	fetcher, _ = New[csvData](r, func(ctx context.Context, name string, source RemoteDataSource) (int, error) {
+		results, err := fetcher.Fetch(ctx, name, source)
+		if err != nil {
+			return 0, err
+		}
+		dataLock.Lock()
+		data = results
+		dataLock.Unlock()
+		return len(results), nil
+	}, "test", config)
+	fetcher.Start()
+	defer func() {
+		fetcher.t.Kill(nil)
+		fetcher.t.Wait()
+	}()
+
+	select {
+	case <-fetcher.DataSourcesReady:
+	case <-time.After(time.Second):
+		t.Fatal("data source not ready")
+	}
+	expected := []csvData{
+		{Prefix: "192.0.2.0/24", ASN: 64501},
+		{Prefix: "198.51.100.0/24", ASN: 64502},
+	}
+	dataLock.Lock()
+	if diff := helpers.Diff(data, expected); diff != "" {
+		t.Fatalf("Fetch() (-got, +want):\n%s", diff)
+	}
+	dataLock.Unlock()
+
+	// Failing refresh keeps the previous data and raises a warning
+	requestsLock.Lock()
+	failing = true
+	requestsLock.Unlock()
+	fetcher.Refresh()
+	time.Sleep(50 * time.Millisecond)
+	requestsLock.Lock()
+	// The refresh fails and switching to the retry ticker triggers an
+	// immediate second attempt.
+	if requests != 3 {
+		t.Errorf("requests = %d, expected 3", requests)
+	}
+	requestsLock.Unlock()
+	dataLock.Lock()
+	if diff := helpers.Diff(data, expected); diff != "" {
+		t.Fatalf("Fetch() (-got, +want):\n%s", diff)
+	}
+	dataLock.Unlock()
+	if got := fetcher.healthcheck(context.Background()); got.Status != reporter.HealthcheckWarning {
+		t.Errorf("healthcheck() = %v, expected warning", got)
+	}
+}
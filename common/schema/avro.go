@@ -0,0 +1,166 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package schema
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// AvroEncoder transcodes flows encoded with `ProtobufMarshal` to the Avro
+// binary encoding. The Avro schema contains the same fields as the protobuf
+// definition. Each field has a default value, therefore enabling or disabling
+// a column produces a compatible schema.
+type AvroEncoder struct {
+	columns []*Column
+	fields  []int // protobuf index to position in columns
+	schema  string
+}
+
+type avroField struct {
+	Name    string      `json:"name"`
+	Type    interface{} `json:"type"`
+	Default interface{} `json:"default"`
+}
+
+type avroValue struct {
+	varints []uint64
+	bytes   []byte
+}
+
+// NewAvroEncoder creates a new Avro encoder for the current schema.
+func (schema *Schema) NewAvroEncoder() *AvroEncoder {
+	e := AvroEncoder{
+		fields: make([]int, len(schema.protobufIndex)),
+	}
+	for idx := range e.fields {
+		e.fields[idx] = -1
+	}
+	fields := []avroField{}
+	for _, column := range schema.columns {
+		if column.Disabled {
+			continue
+		}
+		columns := []*Column{schema.columnIndex[column.Key]}
+		for _, column := range column.ClickHouseTransformFrom {
+			columns = append(columns, schema.columnIndex[column.Key])
+		}
+		for _, column := range columns {
+			if column.ProtobufIndex <= 0 {
+				continue
+			}
+			var field avroField
+			switch {
+			case column.ProtobufRepeated:
+				field = avroField{Type: map[string]string{"type": "array", "items": "long"}, Default: []uint64{}}
+			case column.ProtobufType == protoreflect.Uint64Kind, column.ProtobufType == protoreflect.Uint32Kind:
+				field = avroField{Type: "long", Default: 0}
+			default:
+				// IP addresses, enums and strings
+				field = avroField{Type: "string", Default: ""}
+			}
+			field.Name = column.Name
+			e.fields[column.ProtobufIndex] = len(e.columns)
+			e.columns = append(e.columns, column)
+			fields = append(fields, field)
+		}
+	}
+	schemaJSON, _ := json.Marshal(map[string]interface{}{
+		"type":      "record",
+		"name":      "FlowMessage",
+		"namespace": "akvorado",
+		"fields":    fields,
+	})
+	e.schema = string(schemaJSON)
+	return &e
+}
+
+// Schema returns the Avro schema as JSON.
+func (e *AvroEncoder) Schema() string {
+	return e.schema
+}
+
+// Marshal transcodes a flow encoded with `ProtobufMarshal` to Avro and
+// appends the result to the provided buffer.
+func (e *AvroEncoder) Marshal(buf []byte, input []byte) ([]byte, error) {
+	size, n := protowire.ConsumeVarint(input)
+	if n < 0 {
+		return nil, protowire.ParseError(n)
+	}
+	if uint64(len(input)-n) != size {
+		return nil, fmt.Errorf("bad length for protobuf message: %d != %d", len(input)-n, size)
+	}
+	values := make([]avroValue, len(e.columns))
+	b := input[n:]
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		value := b[:n]
+		b = b[n:]
+		if int(num) >= len(e.fields) || e.fields[num] < 0 {
+			continue
+		}
+		idx := e.fields[num]
+		switch typ {
+		case protowire.VarintType:
+			varint, _ := protowire.ConsumeVarint(value)
+			values[idx].varints = append(values[idx].varints, varint)
+		case protowire.BytesType:
+			values[idx].bytes, _ = protowire.ConsumeBytes(value)
+		}
+	}
+
+	for idx, column := range e.columns {
+		value := values[idx]
+		switch {
+		case column.ProtobufRepeated:
+			if len(value.varints) > 0 {
+				buf = binary.AppendVarint(buf, int64(len(value.varints)))
+				for _, varint := range value.varints {
+					buf = binary.AppendVarint(buf, int64(varint))
+				}
+			}
+			buf = binary.AppendVarint(buf, 0)
+		case column.ProtobufType == protoreflect.Uint64Kind, column.ProtobufType == protoreflect.Uint32Kind:
+			var varint uint64
+			if len(value.varints) > 0 {
+				varint = value.varints[0]
+			}
+			buf = binary.AppendVarint(buf, int64(varint))
+		case column.ProtobufType == protoreflect.EnumKind:
+			var varint uint64
+			if len(value.varints) > 0 {
+				varint = value.varints[0]
+			}
+			buf = avroAppendString(buf, []byte(column.ProtobufEnum[int(varint)]))
+		case column.ProtobufType == protoreflect.BytesKind:
+			var ip []byte
+			if len(value.bytes) == 16 {
+				ip = []byte(netip.AddrFrom16([16]byte(value.bytes)).Unmap().String())
+			}
+			buf = avroAppendString(buf, ip)
+		default:
+			buf = avroAppendString(buf, value.bytes)
+		}
+	}
+	return buf, nil
+}
+
+// avroAppendString appends a string (or bytes) using Avro binary encoding.
+func avroAppendString(buf []byte, value []byte) []byte {
+	buf = binary.AppendVarint(buf, int64(len(value)))
+	return append(buf, value...)
+}
@@ -0,0 +1,168 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package schema
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/netip"
+	"testing"
+
+	"akvorado/common/helpers"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestAvroSchema(t *testing.T) {
+	flows := Schema{
+		columns: []Column{
+			{
+				Key:            ColumnTimeReceived,
+				ClickHouseType: "DateTime",
+				ProtobufType:   protoreflect.Uint64Kind,
+			},
+			{Key: ColumnExporterAddress, ClickHouseType: "LowCardinality(IPv6)"},
+			{Key: ColumnExporterName, ClickHouseType: "LowCardinality(String)"},
+			{Key: ColumnSrcAS, ClickHouseType: "UInt32"},
+			{Key: ColumnDstASPath, ClickHouseType: "Array(UInt32)"},
+			{
+				Key:            ColumnInIfBoundary,
+				ClickHouseType: "Enum8('undefined' = 0, 'external' = 1, 'internal' = 2)",
+				ProtobufType:   protoreflect.EnumKind,
+				ProtobufEnum: map[int]string{
+					0: "UNDEFINED",
+					1: "EXTERNAL",
+					2: "INTERNAL",
+				},
+			},
+			{Key: ColumnBytes, ClickHouseType: "UInt64", Disabled: true},
+		},
+	}.finalize()
+
+	var got interface{}
+	if err := json.Unmarshal([]byte(flows.NewAvroEncoder().Schema()), &got); err != nil {
+		t.Fatalf("json.Unmarshal() error:\n%+v", err)
+	}
+	expected := map[string]interface{}{
+		"type":      "record",
+		"name":      "FlowMessage",
+		"namespace": "akvorado",
+		"fields": []interface{}{
+			map[string]interface{}{"name": "TimeReceived", "type": "long", "default": 0.},
+			map[string]interface{}{"name": "ExporterAddress", "type": "string", "default": ""},
+			map[string]interface{}{"name": "ExporterName", "type": "string", "default": ""},
+			map[string]interface{}{"name": "SrcAS", "type": "long", "default": 0.},
+			map[string]interface{}{"name": "DstAS", "type": "long", "default": 0.},
+			map[string]interface{}{
+				"name":    "DstASPath",
+				"type":    map[string]interface{}{"type": "array", "items": "long"},
+				"default": []interface{}{},
+			},
+			map[string]interface{}{"name": "InIfBoundary", "type": "string", "default": ""},
+			map[string]interface{}{"name": "OutIfBoundary", "type": "string", "default": ""},
+		},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("Schema() (-got, +want):\n%s", diff)
+	}
+}
+
+// avroDecode decodes an Avro record using the provided encoder schema. Only
+// the types used by AvroEncoder are supported.
+func avroDecode(t *testing.T, e *AvroEncoder, input []byte) map[string]interface{} {
+	t.Helper()
+	readLong := func() int64 {
+		value, n := binary.Varint(input)
+		if n <= 0 {
+			t.Fatalf("cannot decode long")
+		}
+		input = input[n:]
+		return value
+	}
+	readString := func() string {
+		length := readLong()
+		value := string(input[:length])
+		input = input[length:]
+		return value
+	}
+	var schema struct {
+		Fields []struct {
+			Name string      `json:"name"`
+			Type interface{} `json:"type"`
+		} `json:"fields"`
+	}
+	if err := json.Unmarshal([]byte(e.Schema()), &schema); err != nil {
+		t.Fatalf("json.Unmarshal() error:\n%+v", err)
+	}
+	result := map[string]interface{}{}
+	for _, field := range schema.Fields {
+		switch field.Type {
+		case "long":
+			result[field.Name] = readLong()
+		case "string":
+			result[field.Name] = readString()
+		default:
+			values := []int64{}
+			for count := readLong(); count != 0; count = readLong() {
+				for ; count > 0; count-- {
+					values = append(values, readLong())
+				}
+			}
+			result[field.Name] = values
+		}
+	}
+	if len(input) > 0 {
+		t.Fatalf("%d bytes remaining after decoding", len(input))
+	}
+	return result
+}
+
+func TestAvroMarshal(t *testing.T) {
+	c := NewMock(t).EnableAllColumns()
+	e := c.NewAvroEncoder()
+	bf := &FlowMessage{}
+	bf.TimeReceived = 1000
+	bf.SamplingRate = 20000
+	bf.ExporterAddress = netip.MustParseAddr("::ffff:203.0.113.14")
+	bf.SrcAddr = netip.MustParseAddr("2001:db8::1")
+	bf.DstASPath = []uint32{65000, 65001, 65002}
+	c.ProtobufAppendVarint(bf, ColumnBytes, 200)
+	c.ProtobufAppendVarint(bf, ColumnInIfBoundary, uint64(InterfaceBoundaryExternal))
+	c.ProtobufAppendBytes(bf, ColumnExporterName, []byte("exporter1"))
+
+	got, err := e.Marshal([]byte{0xff}, c.ProtobufMarshal(bf))
+	if err != nil {
+		t.Fatalf("Marshal() error:\n%+v", err)
+	}
+	if got[0] != 0xff {
+		t.Fatalf("Marshal() did not append to the provided buffer")
+	}
+	decoded := avroDecode(t, e, got[1:])
+	expected := map[string]interface{}{
+		"TimeReceived":    int64(1000),
+		"SamplingRate":    int64(20000),
+		"ExporterAddress": "203.0.113.14",
+		"ExporterName":    "exporter1",
+		"SrcAddr":         "2001:db8::1",
+		"DstAddr":         "",
+		"DstASPath":       []int64{65000, 65001, 65002},
+		"DstCommunities":  []int64{},
+		"Bytes":           int64(200),
+		"Packets":         int64(0),
+		"InIfBoundary":    "EXTERNAL",
+		"OutIfBoundary":   "UNDEFINED",
+	}
+	for key, value := range expected {
+		if diff := helpers.Diff(decoded[key], value); diff != "" {
+			t.Errorf("Marshal() %s (-got, +want):\n%s", key, diff)
+		}
+	}
+}
+
+func TestAvroMarshalInvalid(t *testing.T) {
+	e := NewMock(t).NewAvroEncoder()
+	if _, err := e.Marshal(nil, []byte{10, 1, 2}); err == nil {
+		t.Fatal("Marshal() did not error")
+	}
+}
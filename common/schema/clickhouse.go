@@ -5,6 +5,8 @@ package schema
 
 import (
 	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"golang.org/x/exp/slices"
@@ -62,6 +64,16 @@ func (schema Schema) ClickHouseSelectColumns(options ...ClickHouseTableOption) [
 	return cols
 }
 
+// ClickHouseColumns returns the columns matching the options. The name and the
+// type of a column are modified according to the options.
+func (schema Schema) ClickHouseColumns(options ...ClickHouseTableOption) []Column {
+	cols := []Column{}
+	schema.clickhouseIterate(func(column Column) {
+		cols = append(cols, column)
+	}, options...)
+	return cols
+}
+
 func (schema Schema) clickhouseIterate(fn func(Column), options ...ClickHouseTableOption) {
 	for _, column := range schema.Columns() {
 		if slices.Contains(options, ClickHouseSkipTimeReceived) && column.Key == ColumnTimeReceived {
@@ -120,3 +132,146 @@ func (schema Schema) ClickHousePrimaryKeys() []string {
 	}
 	return cols
 }
+
+// applyColumnStorage applies a storage override to the matching column. The
+// codec is validated against the resulting type and normalized to the form
+// ClickHouse uses in system.columns.
+func (schema *Schema) applyColumnStorage(cs ColumnStorage) error {
+	column, ok := schema.LookupColumnByName(cs.Name)
+	if !ok {
+		return fmt.Errorf("storage for column %q: unknown column", cs.Name)
+	}
+	if cs.LowCardinality != nil {
+		baseType, isLowCardinality := clickhouseUnwrapLowCardinality(column.ClickHouseType)
+		if *cs.LowCardinality != isLowCardinality {
+			if slices.Contains(schema.ClickHouseSortingKeys(), column.Name) {
+				return fmt.Errorf("storage for column %q: cannot change LowCardinality of a sorting key", cs.Name)
+			}
+			if *cs.LowCardinality {
+				if !clickhouseLowCardinalityCompatible(baseType) {
+					return fmt.Errorf("storage for column %q: type %s cannot be LowCardinality", cs.Name, baseType)
+				}
+				column.ClickHouseType = fmt.Sprintf("LowCardinality(%s)", baseType)
+			} else {
+				column.ClickHouseType = baseType
+			}
+		}
+	}
+	if cs.Codec != "" {
+		if column.ClickHouseAlias != "" {
+			return fmt.Errorf("storage for column %q: cannot set a codec on an alias", cs.Name)
+		}
+		codec, err := clickhouseNormalizeCodec(cs.Codec, column.ClickHouseType)
+		if err != nil {
+			return fmt.Errorf("storage for column %q: %w", cs.Name, err)
+		}
+		column.ClickHouseCodec = codec
+	}
+	return nil
+}
+
+// clickhouseUnwrapLowCardinality returns the type wrapped by LowCardinality
+// and whether the type was wrapped.
+func clickhouseUnwrapLowCardinality(chType string) (string, bool) {
+	if strings.HasPrefix(chType, "LowCardinality(") && strings.HasSuffix(chType, ")") {
+		return chType[len("LowCardinality(") : len(chType)-1], true
+	}
+	return chType, false
+}
+
+// clickhouseLowCardinalityCompatible tells if a type can be wrapped into
+// LowCardinality.
+func clickhouseLowCardinalityCompatible(chType string) bool {
+	if strings.HasPrefix(chType, "FixedString(") {
+		return true
+	}
+	if _, ok := clickhouseIntegerSizes[chType]; ok {
+		return true
+	}
+	return slices.Contains([]string{"String", "IPv4", "IPv6", "Float32", "Float64"}, chType)
+}
+
+// clickhouseIntegerSizes maps integer-like types to their size in bytes.
+var clickhouseIntegerSizes = map[string]int{
+	"UInt8": 1, "Int8": 1,
+	"UInt16": 2, "Int16": 2, "Date": 2,
+	"UInt32": 4, "Int32": 4, "DateTime": 4,
+	"UInt64": 8, "Int64": 8,
+}
+
+// clickhouseCodecRegexp matches a single codec with an optional parameter.
+var clickhouseCodecRegexp = regexp.MustCompile(`^([A-Za-z0-9]+)(?:\((\d+)\))?$`)
+
+// clickhouseNormalizeCodec checks a list of codecs against the provided
+// column type and returns it in the form used by ClickHouse.
+func clickhouseNormalizeCodec(codecs string, chType string) (string, error) {
+	result := []string{}
+	general := false
+	for _, codec := range strings.Split(codecs, ",") {
+		codec = strings.TrimSpace(codec)
+		matches := clickhouseCodecRegexp.FindStringSubmatch(codec)
+		if matches == nil {
+			return "", fmt.Errorf("cannot parse codec %q", codec)
+		}
+		name, hasParam := strings.ToLower(matches[1]), matches[2] != ""
+		param, _ := strconv.Atoi(matches[2])
+		specialized := false
+		switch name {
+		case "none", "lz4", "lz4hc", "zstd":
+			general = true
+			switch {
+			case name == "none" || name == "lz4":
+				if hasParam {
+					return "", fmt.Errorf("codec %q does not accept a parameter", codec)
+				}
+				codec = strings.ToUpper(name)
+			case name == "lz4hc" && !hasParam:
+				codec = "LZ4HC(0)"
+			case name == "lz4hc" && param <= 12:
+				codec = fmt.Sprintf("LZ4HC(%d)", param)
+			case name == "zstd" && !hasParam:
+				codec = "ZSTD(1)"
+			case name == "zstd" && param >= 1 && param <= 22:
+				codec = fmt.Sprintf("ZSTD(%d)", param)
+			default:
+				return "", fmt.Errorf("invalid level for codec %q", codec)
+			}
+		case "delta", "doubledelta", "t64":
+			specialized = true
+			size, ok := clickhouseIntegerSizes[chType]
+			if !ok {
+				return "", fmt.Errorf("codec %q is not compatible with type %s", codec, chType)
+			}
+			switch {
+			case name == "delta" && !hasParam:
+				codec = fmt.Sprintf("Delta(%d)", size)
+			case name == "delta" && (param == 1 || param == 2 || param == 4 || param == 8):
+				codec = fmt.Sprintf("Delta(%d)", param)
+			case name == "delta":
+				return "", fmt.Errorf("invalid size for codec %q", codec)
+			case hasParam:
+				return "", fmt.Errorf("codec %q does not accept a parameter", codec)
+			case name == "doubledelta":
+				codec = "DoubleDelta"
+			default:
+				codec = "T64"
+			}
+		case "gorilla":
+			specialized = true
+			if chType != "Float32" && chType != "Float64" {
+				return "", fmt.Errorf("codec %q is not compatible with type %s", codec, chType)
+			}
+			if hasParam {
+				return "", fmt.Errorf("codec %q does not accept a parameter", codec)
+			}
+			codec = "Gorilla"
+		default:
+			return "", fmt.Errorf("unknown codec %q", codec)
+		}
+		if specialized && general {
+			return "", fmt.Errorf("codec %q should be before general purpose codecs", codec)
+		}
+		result = append(result, codec)
+	}
+	return strings.Join(result, ", "), nil
+}
@@ -5,6 +5,7 @@ package schema
 
 import (
 	"errors"
+	"fmt"
 
 	"akvorado/common/helpers"
 )
@@ -23,6 +24,16 @@ type Configuration struct {
 	Materialize []ColumnKey
 	// CustomDictionaries allows enrichment of flows with custom metadata
 	CustomDictionaries map[string]CustomDict `validate:"dive"`
+	// CustomColumns declares additional columns populated by the inlet (for
+	// example, from enterprise-specific IPFIX fields)
+	CustomColumns []CustomColumn `validate:"dive"`
+	// CustomNetworkAttributes declares additional attributes for networks.
+	// Each of them is exposed as a column for both the source and the
+	// destination address.
+	CustomNetworkAttributes []string `validate:"dive,alpha,lowercase"`
+	// ColumnStorage overrides how some columns are stored in ClickHouse
+	// (compression codec and LowCardinality wrapping).
+	ColumnStorage []ColumnStorage `validate:"dive"`
 }
 
 // CustomDict represents a single custom dictionary
@@ -32,6 +43,10 @@ type CustomDict struct {
 	Source     string                `validate:"required"`
 	Layout     string                `validate:"required,oneof=hashed iptrie complex_key_hashed"`
 	Dimensions []string              `validate:"required"`
+	// QueryTime computes the attributes at query time instead of storing
+	// them with each flow. Changes to the dictionary then also apply to
+	// past flows.
+	QueryTime bool
 }
 
 // CustomDictKey represents a single key (matching) column of a custom dictionary
@@ -50,6 +65,23 @@ type CustomDictAttribute struct {
 	Default string `validate:"omitempty,alphanum"`
 }
 
+// CustomColumn represents a single custom column
+type CustomColumn struct {
+	Name string `validate:"required,alphanum"`
+	Type string `validate:"required,oneof=uint string ip"`
+}
+
+// ColumnStorage overrides the storage of a single column in ClickHouse
+type ColumnStorage struct {
+	Name string `validate:"required"`
+	// Codec is the list of compression codecs to use for the column (for
+	// example, "Delta, ZSTD(3)").
+	Codec string
+	// LowCardinality tells if the type of the column should be wrapped into
+	// LowCardinality. When not set, the default for the column is kept.
+	LowCardinality *bool
+}
+
 // DefaultConfiguration returns the default configuration for the schema component.
 func DefaultConfiguration() Configuration {
 	return Configuration{}
@@ -64,8 +96,13 @@ func (ck ColumnKey) MarshalText() ([]byte, error) {
 	return nil, errors.New("unknown column name")
 }
 
+// String turns a column key to a string. Keys of columns defined by the
+// configuration are not global and are displayed with their numeric value.
 func (ck ColumnKey) String() string {
-	name, _ := columnNameMap.LoadValue(ck)
+	name, ok := columnNameMap.LoadValue(ck)
+	if !ok {
+		return fmt.Sprintf("ColumnKey(%d)", ck)
+	}
 	return name
 }
 
@@ -84,6 +121,12 @@ func (c *Component) GetCustomDictConfig() map[string]CustomDict {
 	return c.c.CustomDictionaries
 }
 
+// GetCustomNetworkAttributes returns the custom network attributes declared in
+// this schema
+func (c *Component) GetCustomNetworkAttributes() []string {
+	return c.c.CustomNetworkAttributes
+}
+
 // DefaultCustomDictConfiguration is the default config for a CustomDict
 func DefaultCustomDictConfiguration() CustomDict {
 	return CustomDict{
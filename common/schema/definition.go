@@ -66,6 +66,60 @@ func (ib *InterfaceBoundary) UnmarshalText(input []byte) error {
 	return errUnknownInterfaceBoundary
 }
 
+// RPKIStatus is the result of the RPKI origin validation of a route.
+type RPKIStatus uint
+
+const (
+	// RPKIStatusUnknown means the route was not validated.
+	RPKIStatusUnknown RPKIStatus = iota
+	// RPKIStatusValid means a VRP matches the prefix and the origin AS
+	RPKIStatusValid
+	// RPKIStatusInvalid means VRPs cover the prefix but none matches
+	RPKIStatusInvalid
+	// RPKIStatusNotFound means no VRP covers the prefix
+	RPKIStatusNotFound
+)
+
+var (
+	rpkiStatusMap = bimap.New(map[RPKIStatus]string{
+		RPKIStatusUnknown:  "unknown",
+		RPKIStatusValid:    "valid",
+		RPKIStatusInvalid:  "invalid",
+		RPKIStatusNotFound: "not-found",
+	})
+	errUnknownRPKIStatus = errors.New("unknown RPKI status")
+)
+
+// MarshalText turns an RPKI status to text
+func (rs RPKIStatus) MarshalText() ([]byte, error) {
+	got, ok := rpkiStatusMap.LoadValue(rs)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errUnknownRPKIStatus
+}
+
+// String turns an RPKI status to string
+func (rs RPKIStatus) String() string {
+	got, _ := rpkiStatusMap.LoadValue(rs)
+	return got
+}
+
+// UnmarshalText provides an RPKI status from text
+func (rs *RPKIStatus) UnmarshalText(input []byte) error {
+	got, ok := rpkiStatusMap.LoadKey(string(input))
+	if ok {
+		*rs = got
+		return nil
+	}
+	return errUnknownRPKIStatus
+}
+
+// NetPrefixLenUnknown is the prefix length used in SrcNetPrefixLen and
+// DstNetPrefixLen when no prefix was found. 0 is a valid prefix length (default
+// route).
+const NetPrefixLenUnknown = 255
+
 const (
 	// DictionaryASNs is the name of the asns clickhouse dictionary.
 	DictionaryASNs string = "asns"
@@ -73,6 +127,8 @@ const (
 	DictionaryProtocols string = "protocols"
 	// DictionaryICMP is the name of the icmp clickhouse dictionary.
 	DictionaryICMP string = "icmp"
+	// DictionaryDSCP is the name of the dscp clickhouse dictionary.
+	DictionaryDSCP string = "dscp"
 	// DictionaryNetworks is the name of the networks clickhouse dictionary.
 	DictionaryNetworks string = "networks"
 	// DictionaryTCP is the name of the TCP clickhouse dictionary
@@ -81,6 +137,17 @@ const (
 	DictionaryUDP string = "udp"
 )
 
+// networksBuiltinAttributes are the attributes of the networks dictionary
+// always present. Custom network attributes cannot use these names.
+var networksBuiltinAttributes = []string{
+	"network", "name", "role", "site", "region",
+	"city", "state", "country", "tenant", "asn", "asname",
+}
+
+// TCPFlagsMask is the mask applied to TCP flags (FIN to NS). Other bits are
+// reserved and dropped.
+const TCPFlagsMask = 0x1ff
+
 // revive:disable
 const (
 	ColumnTimeReceived ColumnKey = iota + 1
@@ -127,6 +194,10 @@ const (
 	ColumnDstGeoState
 	ColumnSrcGeoCity
 	ColumnDstGeoCity
+	ColumnSrcASName
+	ColumnDstASName
+	ColumnSrcThreatList
+	ColumnDstThreatList
 	ColumnDstASPath
 	ColumnDst1stAS
 	ColumnDst2ndAS
@@ -156,10 +227,13 @@ const (
 	ColumnDstMAC
 	ColumnIPTTL
 	ColumnIPTos
+	ColumnDSCP
+	ColumnECN
 	ColumnIPFragmentID
 	ColumnIPFragmentOffset
 	ColumnIPv6FlowLabel
 	ColumnTCPFlags
+	ColumnTCPHandshake
 	ColumnICMPv4
 	ColumnICMPv4Type
 	ColumnICMPv4Code
@@ -172,6 +246,20 @@ const (
 	ColumnMPLS2ndLabel
 	ColumnMPLS3rdLabel
 	ColumnMPLS4thLabel
+	ColumnOuterSrcAddr
+	ColumnOuterDstAddr
+	ColumnDropReason
+	ColumnOuterVlan
+	ColumnGTPTEID
+	ColumnDuplicate
+	ColumnFlowClass
+	ColumnDstRPKIStatus
+	ColumnSrcNetPrefixLen
+	ColumnDstNetPrefixLen
+	ColumnDstNextHop
+	ColumnDstLocalPref
+	ColumnDstMED
+	ColumnVRF
 
 	// ColumnLast points to after the last static column, custom dictionaries
 	// (dynamic columns) come after ColumnLast
@@ -217,9 +305,9 @@ func flows() Schema {
 			{Key: ColumnSamplingRate, NoDisable: true, ClickHouseType: "UInt64", ConsoleNotDimension: true},
 			{Key: ColumnExporterAddress, ParserType: "ip", ClickHouseType: "LowCardinality(IPv6)"},
 			{Key: ColumnExporterName, ParserType: "string", ClickHouseType: "LowCardinality(String)", ClickHouseNotSortingKey: true},
-			{Key: ColumnExporterGroup, ParserType: "string", ClickHouseType: "LowCardinality(String)", ClickHouseNotSortingKey: true},
-			{Key: ColumnExporterRole, ParserType: "string", ClickHouseType: "LowCardinality(String)", ClickHouseNotSortingKey: true},
-			{Key: ColumnExporterSite, ParserType: "string", ClickHouseType: "LowCardinality(String)", ClickHouseNotSortingKey: true},
+			{Key: ColumnExporterGroup, Disabled: true, ParserType: "string", ClickHouseType: "LowCardinality(String)", ClickHouseNotSortingKey: true},
+			{Key: ColumnExporterRole, Disabled: true, ParserType: "string", ClickHouseType: "LowCardinality(String)", ClickHouseNotSortingKey: true},
+			{Key: ColumnExporterSite, Disabled: true, ParserType: "string", ClickHouseType: "LowCardinality(String)", ClickHouseNotSortingKey: true},
 			{Key: ColumnExporterRegion, ParserType: "string", ClickHouseType: "LowCardinality(String)", ClickHouseNotSortingKey: true},
 			{Key: ColumnExporterTenant, ParserType: "string", ClickHouseType: "LowCardinality(String)", ClickHouseNotSortingKey: true},
 			{
@@ -356,6 +444,33 @@ END`,
 				ClickHouseType:         "LowCardinality(String)",
 				ClickHouseGenerateFrom: "c_DstNetworks[state]",
 			},
+			{
+				Key:                     ColumnSrcASName,
+				Disabled:                true,
+				ParserType:              "string",
+				ClickHouseType:          "LowCardinality(String)",
+				ClickHouseNotSortingKey: true,
+				ClickHouseGenerateFrom: `if(c_SrcNetworks[asname] != '' AND c_SrcNetworks[asn] = if(SrcAS = 0, c_SrcNetworks[asn], SrcAS),
+   c_SrcNetworks[asname],
+   dictGetOrDefault('asns', 'name', if(SrcAS = 0, c_SrcNetworks[asn], SrcAS), ''))`,
+			},
+			{
+				Key:                     ColumnDstASName,
+				Disabled:                true,
+				ParserType:              "string",
+				ClickHouseType:          "LowCardinality(String)",
+				ClickHouseNotSortingKey: true,
+				ClickHouseGenerateFrom: `if(c_DstNetworks[asname] != '' AND c_DstNetworks[asn] = if(DstAS = 0, c_DstNetworks[asn], DstAS),
+   c_DstNetworks[asname],
+   dictGetOrDefault('asns', 'name', if(DstAS = 0, c_DstNetworks[asn], DstAS), ''))`,
+			},
+			{
+				Key:                     ColumnSrcThreatList,
+				Disabled:                true,
+				ParserType:              "string",
+				ClickHouseType:          "LowCardinality(String)",
+				ClickHouseNotSortingKey: true,
+			},
 			{
 				Key:                ColumnDstASPath,
 				ClickHouseMainOnly: true,
@@ -407,7 +522,7 @@ END`,
 			},
 			{Key: ColumnInIfName, ParserType: "string", ClickHouseType: "LowCardinality(String)"},
 			{Key: ColumnInIfDescription, ParserType: "string", ClickHouseType: "LowCardinality(String)", ClickHouseNotSortingKey: true},
-			{Key: ColumnInIfSpeed, ParserType: "uint", ClickHouseType: "UInt32", ClickHouseNotSortingKey: true},
+			{Key: ColumnInIfSpeed, Disabled: true, ParserType: "uint", ClickHouseType: "UInt32", ClickHouseNotSortingKey: true},
 			{Key: ColumnInIfConnectivity, ParserType: "string", ClickHouseType: "LowCardinality(String)", ClickHouseNotSortingKey: true},
 			{Key: ColumnInIfProvider, ParserType: "string", ClickHouseType: "LowCardinality(String)", ClickHouseNotSortingKey: true},
 			{
@@ -490,10 +605,38 @@ END`,
 			{Key: ColumnSrcMAC, Disabled: true, Group: ColumnGroupL2, ClickHouseType: "UInt64"},
 			{Key: ColumnIPTTL, Disabled: true, Group: ColumnGroupL3L4, ParserType: "uint", ClickHouseType: "UInt8"},
 			{Key: ColumnIPTos, Disabled: true, Group: ColumnGroupL3L4, ParserType: "uint", ClickHouseType: "UInt8"},
+			{
+				Key:            ColumnDSCP,
+				Depends:        []ColumnKey{ColumnIPTos},
+				Disabled:       true,
+				Group:          ColumnGroupL3L4,
+				ParserType:     "string",
+				ClickHouseType: "LowCardinality(String)",
+				ClickHouseAlias: fmt.Sprintf(`dictGetOrDefault('%s', 'name', bitShiftRight(IPTos, 2), `, DictionaryDSCP) +
+					`toString(bitShiftRight(IPTos, 2)))`,
+			},
+			{
+				Key:             ColumnECN,
+				Depends:         []ColumnKey{ColumnIPTos},
+				Disabled:        true,
+				Group:           ColumnGroupL3L4,
+				ParserType:      "uint",
+				ClickHouseType:  "UInt8",
+				ClickHouseAlias: "bitAnd(IPTos, 3)",
+			},
 			{Key: ColumnIPFragmentID, Disabled: true, Group: ColumnGroupL3L4, ParserType: "uint", ClickHouseType: "UInt32"},
 			{Key: ColumnIPFragmentOffset, Disabled: true, Group: ColumnGroupL3L4, ParserType: "uint", ClickHouseType: "UInt16"},
 			{Key: ColumnIPv6FlowLabel, Disabled: true, Group: ColumnGroupL3L4, ParserType: "uint", ClickHouseType: "UInt32"},
 			{Key: ColumnTCPFlags, Disabled: true, Group: ColumnGroupL3L4, ParserType: "uint", ClickHouseType: "UInt16"},
+			{
+				Key:             ColumnTCPHandshake,
+				Depends:         []ColumnKey{ColumnTCPFlags},
+				Disabled:        true,
+				Group:           ColumnGroupL3L4,
+				ParserType:      "uint",
+				ClickHouseType:  "UInt8",
+				ClickHouseAlias: "bitAnd(TCPFlags, 18) = 2", // SYN without ACK
+			},
 			{Key: ColumnICMPv4Type, Disabled: true, Group: ColumnGroupL3L4, ParserType: "uint", ClickHouseType: "UInt8"},
 			{Key: ColumnICMPv4Code, Disabled: true, Group: ColumnGroupL3L4, ParserType: "uint", ClickHouseType: "UInt8"},
 			{Key: ColumnICMPv6Type, Disabled: true, Group: ColumnGroupL3L4, ParserType: "uint", ClickHouseType: "UInt8"},
@@ -570,6 +713,113 @@ END`,
 				ClickHouseAlias:    "MPLSLabels[4]",
 				ParserType:         "uint",
 			},
+			{
+				Key:                ColumnOuterSrcAddr,
+				Disabled:           true,
+				ParserType:         "ip",
+				ClickHouseType:     "IPv6",
+				ClickHouseMainOnly: true,
+				ConsoleTruncateIP:  true,
+			},
+			{
+				Key:                ColumnOuterDstAddr,
+				Disabled:           true,
+				ParserType:         "ip",
+				ClickHouseType:     "IPv6",
+				ClickHouseMainOnly: true,
+				ConsoleTruncateIP:  true,
+			},
+			{
+				Key:                     ColumnDropReason,
+				Disabled:                true,
+				ParserType:              "string",
+				ClickHouseType:          "LowCardinality(String)",
+				ClickHouseNotSortingKey: true,
+			},
+			{Key: ColumnOuterVlan, ParserType: "uint", ClickHouseType: "UInt16", Disabled: true, Group: ColumnGroupL2},
+			{
+				Key:                ColumnGTPTEID,
+				Disabled:           true,
+				ParserType:         "uint",
+				ClickHouseType:     "UInt32",
+				ClickHouseMainOnly: true,
+			},
+			{
+				Key:                     ColumnDuplicate,
+				Disabled:                true,
+				ParserType:              "uint",
+				ClickHouseType:          "UInt8",
+				ClickHouseNotSortingKey: true,
+			},
+			{
+				Key:                     ColumnFlowClass,
+				Disabled:                true,
+				ParserType:              "string",
+				ClickHouseType:          "LowCardinality(String)",
+				ClickHouseNotSortingKey: true,
+			},
+			{
+				Key:      ColumnDstRPKIStatus,
+				Disabled: true,
+				ClickHouseType: fmt.Sprintf("Enum8('unknown' = %d, 'valid' = %d, 'invalid' = %d, 'not-found' = %d)",
+					RPKIStatusUnknown, RPKIStatusValid, RPKIStatusInvalid, RPKIStatusNotFound),
+				ClickHouseNotSortingKey: true,
+				ProtobufType:            protoreflect.EnumKind,
+				ProtobufEnumName:        "RPKIStatus",
+				ProtobufEnum: map[int]string{
+					int(RPKIStatusUnknown):  "RPKI_UNKNOWN",
+					int(RPKIStatusValid):    "RPKI_VALID",
+					int(RPKIStatusInvalid):  "RPKI_INVALID",
+					int(RPKIStatusNotFound): "RPKI_NOT_FOUND",
+				},
+			},
+			{
+				Key:                     ColumnSrcNetPrefixLen,
+				Disabled:                true,
+				ParserType:              "uint",
+				ClickHouseType:          "UInt8",
+				ClickHouseGenerateFrom:  fmt.Sprintf("if(SrcNetPrefixLen = %d, c_SrcNetworks[prefixlen], SrcNetPrefixLen)", NetPrefixLenUnknown),
+				ClickHouseSelfGenerated: true,
+				ClickHouseNotSortingKey: true,
+			},
+			{
+				Key:                     ColumnDstNetPrefixLen,
+				Disabled:                true,
+				ParserType:              "uint",
+				ClickHouseType:          "UInt8",
+				ClickHouseGenerateFrom:  fmt.Sprintf("if(DstNetPrefixLen = %d, c_DstNetworks[prefixlen], DstNetPrefixLen)", NetPrefixLenUnknown),
+				ClickHouseSelfGenerated: true,
+				ClickHouseNotSortingKey: true,
+			},
+			{
+				Key:                     ColumnDstNextHop,
+				Disabled:                true,
+				ParserType:              "ip",
+				ClickHouseType:          "LowCardinality(IPv6)",
+				ClickHouseCodec:         "ZSTD(1)",
+				ClickHouseNotSortingKey: true,
+			},
+			{
+				Key:                     ColumnDstLocalPref,
+				Disabled:                true,
+				ParserType:              "uint",
+				ClickHouseType:          "UInt32",
+				ClickHouseNotSortingKey: true,
+			},
+			{
+				Key:                     ColumnDstMED,
+				Disabled:                true,
+				ParserType:              "uint",
+				ClickHouseType:          "UInt32",
+				ClickHouseNotSortingKey: true,
+			},
+			{
+				Key:                     ColumnVRF,
+				Disabled:                true,
+				ParserType:              "string",
+				ClickHouseType:          "LowCardinality(String)",
+				ClickHouseNotSortingKey: true,
+			},
 		},
 	}.finalize()
 }
@@ -584,11 +834,11 @@ func (schema Schema) finalize() Schema {
 	ncolumns := []Column{}
 	for _, column := range schema.columns {
 		// Add true name
-		name, ok := columnNameMap.LoadValue(column.Key)
-		if !ok {
-			panic(fmt.Sprintf("missing name mapping for %d", column.Key))
-		}
 		if column.Name == "" {
+			name, ok := columnNameMap.LoadValue(column.Key)
+			if !ok {
+				panic(fmt.Sprintf("missing name mapping for %d", column.Key))
+			}
 			column.Name = name
 		}
 
@@ -623,7 +873,7 @@ func (schema Schema) finalize() Schema {
 
 		// Expand the schema Src → Dst and InIf → OutIf
 		alreadyExists := func(name string) bool {
-			key, _ := columnNameMap.LoadKey(name)
+			key, _ := schema.lookupColumnKey(name)
 			for _, column := range schema.columns {
 				if column.Key == key {
 					return true
@@ -634,10 +884,11 @@ func (schema Schema) finalize() Schema {
 		if strings.HasPrefix(column.Name, "Src") {
 			column.Name = fmt.Sprintf("Dst%s", column.Name[3:])
 			if !alreadyExists(column.Name) {
-				column.Key, ok = columnNameMap.LoadKey(column.Name)
+				key, ok := schema.lookupColumnKey(column.Name)
 				if !ok {
 					panic(fmt.Sprintf("missing name mapping for %q", column.Name))
 				}
+				column.Key = key
 				column.ClickHouseAlias = strings.ReplaceAll(column.ClickHouseAlias, "Src", "Dst")
 				column.ClickHouseTransformFrom = slices.Clone(column.ClickHouseTransformFrom)
 				ncolumns = append(ncolumns, column)
@@ -645,10 +896,11 @@ func (schema Schema) finalize() Schema {
 		} else if strings.HasPrefix(column.Name, "InIf") {
 			column.Name = fmt.Sprintf("OutIf%s", column.Name[4:])
 			if !alreadyExists(column.Name) {
-				column.Key, ok = columnNameMap.LoadKey(column.Name)
+				key, ok := schema.lookupColumnKey(column.Name)
 				if !ok {
 					panic(fmt.Sprintf("missing name mapping for %q", column.Name))
 				}
+				column.Key = key
 				column.ClickHouseAlias = strings.ReplaceAll(column.ClickHouseAlias, "InIf", "OutIf")
 				column.ClickHouseTransformFrom = slices.Clone(column.ClickHouseTransformFrom)
 				ncolumns = append(ncolumns, column)
@@ -711,6 +963,18 @@ func (schema Schema) finalize() Schema {
 			schema.columnIndex[column.Key] = &schema.columns[i].ClickHouseTransformFrom[j]
 		}
 	}
+	maxProtobufIndex := protowire.Number(0)
+	for _, column := range schema.columnIndex {
+		if column != nil && column.ProtobufIndex > maxProtobufIndex {
+			maxProtobufIndex = column.ProtobufIndex
+		}
+	}
+	schema.protobufIndex = make([]*Column, maxProtobufIndex+1)
+	for _, column := range schema.columnIndex {
+		if column != nil && column.ProtobufIndex > 0 {
+			schema.protobufIndex[column.ProtobufIndex] = column
+		}
+	}
 
 	// Update disabledGroups
 	schema.disabledGroups = *bitset.New(uint(ColumnGroupLast))
@@ -69,5 +69,6 @@ func TestDisabledGroup(t *testing.T) {
 
 func TestMarshalUnmarshal(t *testing.T) {
 	interfaceBoundaryMap.TestMarshalUnmarshal(t)
+	rpkiStatusMap.TestMarshalUnmarshal(t)
 	columnNameMap.TestMarshalUnmarshal(t)
 }
@@ -5,9 +5,17 @@ package schema
 
 import "strings"
 
+// lookupColumnKey returns the key of a column from its name.
+func (schema *Schema) lookupColumnKey(name string) (ColumnKey, bool) {
+	if key, ok := schema.dynamicColumnNames[name]; ok {
+		return key, true
+	}
+	return columnNameMap.LoadKey(name)
+}
+
 // LookupColumnByName can lookup a column by its name.
 func (schema *Schema) LookupColumnByName(name string) (*Column, bool) {
-	key, ok := columnNameMap.LoadKey(name)
+	key, ok := schema.lookupColumnKey(name)
 	if !ok {
 		return &Column{}, false
 	}
@@ -26,7 +34,11 @@ func (schema *Schema) LookupColumnByKey(key ColumnKey) (*Column, bool) {
 // ReverseColumnDirection reverts the direction of a provided column name.
 func (schema *Schema) ReverseColumnDirection(key ColumnKey) ColumnKey {
 	var candidateName string
-	name := key.String()
+	column, ok := schema.LookupColumnByKey(key)
+	if !ok {
+		return key
+	}
+	name := column.Name
 	if strings.HasPrefix(name, "Src") {
 		candidateName = "Dst" + name[3:]
 	}
@@ -39,10 +51,8 @@ func (schema *Schema) ReverseColumnDirection(key ColumnKey) ColumnKey {
 	if strings.HasPrefix(name, "Out") {
 		candidateName = "In" + name[3:]
 	}
-	if candidateKey, ok := columnNameMap.LoadKey(candidateName); ok {
-		if column, ok := schema.LookupColumnByKey(candidateKey); ok && !column.Disabled {
-			return candidateKey
-		}
+	if column, ok := schema.LookupColumnByName(candidateName); ok && !column.Disabled {
+		return column.Key
 	}
 	return key
 }
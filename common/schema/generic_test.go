@@ -27,6 +27,19 @@ func TestLookupColumnByName(t *testing.T) {
 	}
 }
 
+func TestLookupColumnByNameFromOtherSchema(t *testing.T) {
+	// Custom columns are registered globally
+	config := DefaultConfiguration()
+	config.CustomColumns = []CustomColumn{{Name: "LookupCustomColumn", Type: "string"}}
+	if _, err := New(config); err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	c := NewMock(t)
+	if _, ok := c.LookupColumnByName("LookupCustomColumn"); ok {
+		t.Fatal("LookupByName() found a column from another schema")
+	}
+}
+
 func TestReverseColumnDirection(t *testing.T) {
 	c := NewMock(t)
 	cases := []struct {
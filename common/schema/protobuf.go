@@ -102,6 +102,12 @@ func (schema *Schema) ProtobufMarshal(bf *FlowMessage) []byte {
 	schema.ProtobufAppendIP(bf, ColumnExporterAddress, bf.ExporterAddress)
 	schema.ProtobufAppendVarint(bf, ColumnSrcAS, uint64(bf.SrcAS))
 	schema.ProtobufAppendVarint(bf, ColumnDstAS, uint64(bf.DstAS))
+	for _, asn := range bf.DstASPath {
+		schema.ProtobufAppendVarint(bf, ColumnDstASPath, uint64(asn))
+	}
+	for _, comm := range bf.DstCommunities {
+		schema.ProtobufAppendVarint(bf, ColumnDstCommunities, uint64(comm))
+	}
 	schema.ProtobufAppendVarint(bf, ColumnSrcNetMask, uint64(bf.SrcNetMask))
 	schema.ProtobufAppendVarint(bf, ColumnDstNetMask, uint64(bf.DstNetMask))
 	schema.ProtobufAppendIP(bf, ColumnSrcAddr, bf.SrcAddr)
@@ -124,6 +130,252 @@ func (schema *Schema) ProtobufMarshal(bf *FlowMessage) []byte {
 	return result
 }
 
+// ProtobufUnmarshal decodes a flow encoded with `ProtobufMarshal` using the
+// same schema. Fields stored outside of the protobuf representation are
+// restored in the returned flow. Fields for unknown or disabled columns are
+// ignored.
+func (schema *Schema) ProtobufUnmarshal(input []byte) (*FlowMessage, error) {
+	size, n := protowire.ConsumeVarint(input)
+	if n < 0 {
+		return nil, protowire.ParseError(n)
+	}
+	if uint64(len(input)-n) != size {
+		return nil, fmt.Errorf("bad length for protobuf message: %d != %d", len(input)-n, size)
+	}
+	bf := &FlowMessage{}
+	bf.init()
+	b := input[n:]
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		field := b
+		b = b[n:]
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		value := b[:n]
+		field = field[:len(field)-len(b)+n]
+		b = b[n:]
+
+		if int(num) >= len(schema.protobufIndex) || schema.protobufIndex[num] == nil {
+			continue
+		}
+		column := schema.protobufIndex[num]
+		var (
+			varint uint64
+			bytes  []byte
+		)
+		switch typ {
+		case protowire.VarintType:
+			varint, _ = protowire.ConsumeVarint(value)
+		case protowire.BytesType:
+			bytes, _ = protowire.ConsumeBytes(value)
+		default:
+			continue
+		}
+		ip, _ := netip.AddrFromSlice(bytes)
+		switch column.Key {
+		case ColumnTimeReceived:
+			bf.TimeReceived = varint
+		case ColumnSamplingRate:
+			bf.SamplingRate = uint32(varint)
+		case ColumnExporterAddress:
+			bf.ExporterAddress = ip
+		case ColumnSrcAS:
+			bf.SrcAS = uint32(varint)
+		case ColumnDstAS:
+			bf.DstAS = uint32(varint)
+		case ColumnDstASPath:
+			bf.DstASPath = append(bf.DstASPath, uint32(varint))
+		case ColumnDstCommunities:
+			bf.DstCommunities = append(bf.DstCommunities, uint32(varint))
+		case ColumnSrcNetMask:
+			bf.SrcNetMask = uint8(varint)
+		case ColumnDstNetMask:
+			bf.DstNetMask = uint8(varint)
+		case ColumnSrcAddr:
+			bf.SrcAddr = ip
+		case ColumnDstAddr:
+			bf.DstAddr = ip
+		case ColumnNextHop:
+			bf.NextHop = ip
+		case ColumnSrcVlan:
+			bf.SrcVlan = uint16(varint)
+		case ColumnDstVlan:
+			bf.DstVlan = uint16(varint)
+		default:
+			if !column.protobufCanAppend(bf) {
+				continue
+			}
+			bf.protobuf = append(bf.protobuf, field...)
+			bf.protobufSet.Set(uint(column.ProtobufIndex))
+			if debug {
+				switch {
+				case typ == protowire.VarintType:
+					column.appendDebug(bf, varint)
+				case column.ProtobufType == protoreflect.BytesKind:
+					column.appendDebug(bf, ip)
+				default:
+					column.appendDebug(bf, slices.Clone(bytes))
+				}
+			}
+		}
+	}
+	return bf, nil
+}
+
+// ProtobufDecodeMap decodes a flow encoded with `ProtobufMarshal` into a map
+// from column names to values. All enabled columns present in the protobuf
+// representation are included. Missing values are replaced by a zero value.
+// Integers are decoded as uint64, repeated integers as []uint64, IP addresses
+// as strings (IPv4 addresses are unmapped), and enums as the lowercase name of
+// the value.
+func (schema *Schema) ProtobufDecodeMap(input []byte) (map[string]interface{}, error) {
+	size, n := protowire.ConsumeVarint(input)
+	if n < 0 {
+		return nil, protowire.ParseError(n)
+	}
+	if uint64(len(input)-n) != size {
+		return nil, fmt.Errorf("bad length for protobuf message: %d != %d", len(input)-n, size)
+	}
+	result := schema.protobufMapDefaults()
+	if err := schema.protobufMapDecode(result, input[n:]); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ProtobufFlowMap returns the same map as `ProtobufDecodeMap` for a flow not
+// yet processed by `ProtobufMarshal`. Fields stored outside of the protobuf
+// representation are included.
+func (schema *Schema) ProtobufFlowMap(bf *FlowMessage) map[string]interface{} {
+	result := schema.protobufMapDefaults()
+	if bf.protobuf != nil {
+		// The protobuf representation was built by us, it cannot be invalid.
+		schema.protobufMapDecode(result, bf.protobuf[maxSizeVarint:])
+	}
+	set := func(key ColumnKey, value interface{}) {
+		column, ok := schema.LookupColumnByKey(key)
+		if !ok || column.Disabled {
+			return
+		}
+		if column.ProtobufRepeated {
+			result[column.Name] = append(result[column.Name].([]uint64), value.([]uint64)...)
+			return
+		}
+		if bf.protobuf != nil && bf.protobufSet.Test(uint(column.ProtobufIndex)) {
+			return
+		}
+		result[column.Name] = value
+	}
+	setIP := func(key ColumnKey, value netip.Addr) {
+		if value.IsValid() {
+			set(key, value.Unmap().String())
+		}
+	}
+	set(ColumnTimeReceived, bf.TimeReceived)
+	set(ColumnSamplingRate, uint64(bf.SamplingRate))
+	setIP(ColumnExporterAddress, bf.ExporterAddress)
+	set(ColumnSrcAS, uint64(bf.SrcAS))
+	set(ColumnDstAS, uint64(bf.DstAS))
+	if len(bf.DstASPath) > 0 {
+		asPath := make([]uint64, len(bf.DstASPath))
+		for idx, asn := range bf.DstASPath {
+			asPath[idx] = uint64(asn)
+		}
+		set(ColumnDstASPath, asPath)
+	}
+	if len(bf.DstCommunities) > 0 {
+		communities := make([]uint64, len(bf.DstCommunities))
+		for idx, comm := range bf.DstCommunities {
+			communities[idx] = uint64(comm)
+		}
+		set(ColumnDstCommunities, communities)
+	}
+	set(ColumnSrcNetMask, uint64(bf.SrcNetMask))
+	set(ColumnDstNetMask, uint64(bf.DstNetMask))
+	setIP(ColumnSrcAddr, bf.SrcAddr)
+	setIP(ColumnDstAddr, bf.DstAddr)
+	setIP(ColumnNextHop, bf.NextHop)
+	if !schema.IsDisabled(ColumnGroupL2) {
+		set(ColumnSrcVlan, uint64(bf.SrcVlan))
+		set(ColumnDstVlan, uint64(bf.DstVlan))
+	}
+	return result
+}
+
+// protobufMapDefaults returns a map with the zero value of each enabled
+// column, as used by `ProtobufDecodeMap`.
+func (schema *Schema) protobufMapDefaults() map[string]interface{} {
+	result := make(map[string]interface{}, len(schema.protobufIndex))
+	for _, column := range schema.protobufIndex {
+		if column == nil || column.Disabled {
+			continue
+		}
+		switch {
+		case column.ProtobufRepeated:
+			result[column.Name] = []uint64{}
+		case column.ProtobufType == protoreflect.EnumKind:
+			result[column.Name] = strings.ToLower(column.ProtobufEnum[0])
+		case column.ProtobufType == protoreflect.BytesKind, column.ProtobufType == protoreflect.StringKind:
+			result[column.Name] = ""
+		default:
+			result[column.Name] = uint64(0)
+		}
+	}
+	return result
+}
+
+// protobufMapDecode decodes the provided protobuf fields into the provided
+// map, as used by `ProtobufDecodeMap`.
+func (schema *Schema) protobufMapDecode(result map[string]interface{}, b []byte) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		value := b[:n]
+		b = b[n:]
+		if int(num) >= len(schema.protobufIndex) || schema.protobufIndex[num] == nil {
+			continue
+		}
+		column := schema.protobufIndex[num]
+		if column.Disabled {
+			continue
+		}
+		switch typ {
+		case protowire.VarintType:
+			varint, _ := protowire.ConsumeVarint(value)
+			switch {
+			case column.ProtobufRepeated:
+				result[column.Name] = append(result[column.Name].([]uint64), varint)
+			case column.ProtobufType == protoreflect.EnumKind:
+				result[column.Name] = strings.ToLower(column.ProtobufEnum[int(varint)])
+			default:
+				result[column.Name] = varint
+			}
+		case protowire.BytesType:
+			bytes, _ := protowire.ConsumeBytes(value)
+			if column.ProtobufType == protoreflect.BytesKind {
+				if ip, ok := netip.AddrFromSlice(bytes); ok {
+					result[column.Name] = ip.Unmap().String()
+				}
+			} else {
+				result[column.Name] = string(bytes)
+			}
+		}
+	}
+	return nil
+}
+
 // ProtobufAppendVarint append a varint to the protobuf representation of a flow.
 func (schema *Schema) ProtobufAppendVarint(bf *FlowMessage, columnKey ColumnKey, value uint64) {
 	// Check if value is 0 to avoid a lookup.
@@ -164,6 +416,37 @@ func (column Column) protobufCanAppend(bf *FlowMessage) bool {
 		(column.ProtobufRepeated || !bf.protobufSet.Test(uint(column.ProtobufIndex)))
 }
 
+// ProtobufLookupVarint returns the first varint value set for the provided
+// column in the protobuf representation of a flow. It should be used before
+// `ProtobufMarshal`.
+func (schema *Schema) ProtobufLookupVarint(bf *FlowMessage, columnKey ColumnKey) (uint64, bool) {
+	column, _ := schema.LookupColumnByKey(columnKey)
+	if column.ProtobufIndex <= 0 || column.Disabled || !bf.protobufSet.Test(uint(column.ProtobufIndex)) {
+		return 0, false
+	}
+	b := bf.protobuf[maxSizeVarint:]
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return 0, false
+		}
+		b = b[n:]
+		if num == column.ProtobufIndex && typ == protowire.VarintType {
+			value, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return 0, false
+			}
+			return value, true
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return 0, false
+		}
+		b = b[n:]
+	}
+	return 0, false
+}
+
 // ProtobufAppendBytes append a slice of bytes to the protobuf representation
 // of a flow.
 func (schema *Schema) ProtobufAppendBytes(bf *FlowMessage, columnKey ColumnKey, value []byte) {
@@ -11,6 +11,7 @@ import (
 
 	"akvorado/common/helpers"
 
+	"golang.org/x/exp/slices"
 	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/reflect/protoreflect"
 )
@@ -150,30 +151,30 @@ func TestProtobufMarshal(t *testing.T) {
 	// uint32 DstNetMask = 13;
 	// uint32 SrcAS = 14;
 	// uint32 DstAS = 15;
-	// repeated uint32 DstASPath = 18;
-	// repeated uint32 DstCommunities = 19;
-	// repeated uint32 DstLargeCommunitiesASN = 20;
-	// repeated uint32 DstLargeCommunitiesLocalData1 = 21;
-	// repeated uint32 DstLargeCommunitiesLocalData2 = 22;
-	// string InIfName = 23;
-	// string OutIfName = 24;
-	// string InIfDescription = 25;
-	// string OutIfDescription = 26;
-	// uint32 InIfSpeed = 27;
-	// uint32 OutIfSpeed = 28;
-	// string InIfConnectivity = 29;
-	// string OutIfConnectivity = 30;
-	// string InIfProvider = 31;
-	// string OutIfProvider = 32;
-	// Boundary InIfBoundary = 33;
-	// Boundary OutIfBoundary = 34;
-	// uint32 EType = 35;
-	// uint32 Proto = 36;
-	// uint32 SrcPort = 37;
-	// uint32 DstPort = 38;
-	// uint64 Bytes = 39;
-	// uint64 Packets = 40;
-	// uint32 ForwardingStatus = 41;
+	// repeated uint32 DstASPath = 20;
+	// repeated uint32 DstCommunities = 21;
+	// repeated uint32 DstLargeCommunitiesASN = 22;
+	// repeated uint32 DstLargeCommunitiesLocalData1 = 23;
+	// repeated uint32 DstLargeCommunitiesLocalData2 = 24;
+	// string InIfName = 25;
+	// string OutIfName = 26;
+	// string InIfDescription = 27;
+	// string OutIfDescription = 28;
+	// uint32 InIfSpeed = 29;
+	// uint32 OutIfSpeed = 30;
+	// string InIfConnectivity = 31;
+	// string OutIfConnectivity = 32;
+	// string InIfProvider = 33;
+	// string OutIfProvider = 34;
+	// Boundary InIfBoundary = 35;
+	// Boundary OutIfBoundary = 36;
+	// uint32 EType = 37;
+	// uint32 Proto = 38;
+	// uint32 SrcPort = 39;
+	// uint32 DstPort = 40;
+	// uint64 Bytes = 41;
+	// uint64 Packets = 42;
+	// uint32 ForwardingStatus = 43;
 	// }
 	// to check: https://protobuf-decoder.netlify.app/
 	t.Run("compare as bytes", func(t *testing.T) {
@@ -182,11 +183,11 @@ func TestProtobufMarshal(t *testing.T) {
 			// 15: 65000
 			0x78, 0xe8, 0xfb, 0x03,
 			// Bytes
-			// 39: 200
-			0xb8, 0x02, 0xc8, 0x01,
+			// 41: 200
+			0xc8, 0x02, 0xc8, 0x01,
 			// Packet
-			// 40: 300
-			0xc0, 0x02, 0xac, 0x02,
+			// 42: 300
+			0xd0, 0x02, 0xac, 0x02,
 			// TimeReceived
 			// 1: 1000
 			0x08, 0xe8, 0x07,
@@ -240,3 +241,155 @@ func BenchmarkProtobufMarshal(b *testing.B) {
 		c.ProtobufMarshal(bf)
 	}
 }
+
+func TestProtobufLookupVarint(t *testing.T) {
+	c := NewMock(t)
+	bf := &FlowMessage{}
+	if _, ok := c.ProtobufLookupVarint(bf, ColumnSrcPort); ok {
+		t.Fatal("ProtobufLookupVarint() on empty flow should not find anything")
+	}
+	c.ProtobufAppendBytes(bf, ColumnExporterName, []byte("exporter1"))
+	c.ProtobufAppendVarint(bf, ColumnProto, 6)
+	c.ProtobufAppendVarint(bf, ColumnSrcPort, 443)
+	c.ProtobufAppendVarint(bf, ColumnSrcPort, 80) // ignored
+
+	cases := []struct {
+		Key      ColumnKey
+		Expected uint64
+		Found    bool
+	}{
+		{ColumnProto, 6, true},
+		{ColumnSrcPort, 443, true},
+		{ColumnDstPort, 0, false},
+		{ColumnExporterName, 0, false},
+	}
+	for _, tc := range cases {
+		got, ok := c.ProtobufLookupVarint(bf, tc.Key)
+		if ok != tc.Found || got != tc.Expected {
+			t.Errorf("ProtobufLookupVarint(%s) = %d, %v, expected %d, %v",
+				tc.Key, got, ok, tc.Expected, tc.Found)
+		}
+	}
+}
+
+func TestProtobufUnmarshal(t *testing.T) {
+	c := NewMock(t)
+	bf := &FlowMessage{
+		TimeReceived:    1000,
+		SamplingRate:    20000,
+		ExporterAddress: netip.MustParseAddr("::ffff:203.0.113.14"),
+		SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.1"),
+		DstAddr:         netip.MustParseAddr("::ffff:198.51.100.1"),
+		DstAS:           65000,
+		DstASPath:       []uint32{65001, 65000},
+	}
+	c.ProtobufAppendVarint(bf, ColumnBytes, 200)
+	c.ProtobufAppendVarint(bf, ColumnPackets, 300)
+	c.ProtobufAppendBytes(bf, ColumnExporterName, []byte("exporter1"))
+	marshaled := slices.Clone(c.ProtobufMarshal(bf))
+
+	got, err := c.ProtobufUnmarshal(marshaled)
+	if err != nil {
+		t.Fatalf("ProtobufUnmarshal() error:\n%+v", err)
+	}
+	expected := &FlowMessage{
+		TimeReceived:    1000,
+		SamplingRate:    20000,
+		ExporterAddress: netip.MustParseAddr("::ffff:203.0.113.14"),
+		SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.1"),
+		DstAddr:         netip.MustParseAddr("::ffff:198.51.100.1"),
+		DstAS:           65000,
+		DstASPath:       []uint32{65001, 65000},
+		ProtobufDebug: map[ColumnKey]interface{}{
+			ColumnBytes:        uint64(200),
+			ColumnPackets:      uint64(300),
+			ColumnExporterName: []byte("exporter1"),
+		},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("ProtobufUnmarshal() (-got, +want):\n%s", diff)
+	}
+
+	// Marshaling again should produce the same message
+	if diff := helpers.Diff(c.ProtobufMarshal(got), marshaled); diff != "" {
+		t.Fatalf("ProtobufMarshal() (-got, +want):\n%s", diff)
+	}
+
+	// Truncated message
+	if _, err := c.ProtobufUnmarshal(marshaled[:len(marshaled)-1]); err == nil {
+		t.Fatal("ProtobufUnmarshal() with truncated message did not error")
+	}
+}
+
+func TestProtobufDecodeMap(t *testing.T) {
+	c := NewMock(t)
+	bf := &FlowMessage{
+		TimeReceived:    1000,
+		ExporterAddress: netip.MustParseAddr("::ffff:203.0.113.14"),
+		DstAddr:         netip.MustParseAddr("2001:db8::1"),
+		DstASPath:       []uint32{65001, 65000},
+	}
+	c.ProtobufAppendVarint(bf, ColumnBytes, 200)
+	c.ProtobufAppendVarint(bf, ColumnInIfBoundary, uint64(InterfaceBoundaryExternal))
+	c.ProtobufAppendBytes(bf, ColumnExporterName, []byte("exporter1"))
+	marshaled := c.ProtobufMarshal(bf)
+
+	got, err := c.ProtobufDecodeMap(marshaled)
+	if err != nil {
+		t.Fatalf("ProtobufDecodeMap() error:\n%+v", err)
+	}
+	expected := map[string]interface{}{
+		"TimeReceived":    uint64(1000),
+		"ExporterAddress": "203.0.113.14",
+		"ExporterName":    "exporter1",
+		"SrcAddr":         "",
+		"DstAddr":         "2001:db8::1",
+		"DstASPath":       []uint64{65001, 65000},
+		"Bytes":           uint64(200),
+		"Packets":         uint64(0),
+		"InIfBoundary":    "external",
+		"OutIfBoundary":   "undefined",
+	}
+	for key, value := range expected {
+		if diff := helpers.Diff(got[key], value); diff != "" {
+			t.Errorf("ProtobufDecodeMap() %s (-got, +want):\n%s", key, diff)
+		}
+	}
+
+	// Truncated message
+	if _, err := c.ProtobufDecodeMap(marshaled[:len(marshaled)-1]); err == nil {
+		t.Fatal("ProtobufDecodeMap() with truncated message did not error")
+	}
+}
+
+func TestProtobufFlowMap(t *testing.T) {
+	c := NewMock(t).EnableAllColumns()
+	bf := &FlowMessage{
+		TimeReceived:    1000,
+		SamplingRate:    20000,
+		ExporterAddress: netip.MustParseAddr("::ffff:203.0.113.14"),
+		SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.10"),
+		DstAddr:         netip.MustParseAddr("2001:db8::1"),
+		SrcAS:           65000,
+		DstASPath:       []uint32{65001, 65000},
+		DstCommunities:  []uint32{100},
+		SrcVlan:         10,
+	}
+	c.ProtobufAppendVarint(bf, ColumnBytes, 200)
+	c.ProtobufAppendVarint(bf, ColumnInIfBoundary, uint64(InterfaceBoundaryExternal))
+	c.ProtobufAppendBytes(bf, ColumnExporterName, []byte("exporter1"))
+	// Already set, takes precedence over the field
+	c.ProtobufAppendVarint(bf, ColumnSrcAS, 65500)
+
+	got := c.ProtobufFlowMap(bf)
+	expected, err := c.ProtobufDecodeMap(c.ProtobufMarshal(bf))
+	if err != nil {
+		t.Fatalf("ProtobufDecodeMap() error:\n%+v", err)
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("ProtobufFlowMap() (-got, +want):\n%s", diff)
+	}
+	if got["SrcAS"] != uint64(65500) {
+		t.Errorf("ProtobufFlowMap() SrcAS == %v, expected 65500", got["SrcAS"])
+	}
+}
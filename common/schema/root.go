@@ -25,6 +25,7 @@ type Component struct {
 // New creates a new schema component.
 func New(config Configuration) (*Component, error) {
 	schema := flows()
+	schema.dynamicColumnNames = map[string]ColumnKey{}
 	for _, k := range config.Materialize {
 		if column, ok := schema.LookupColumnByKey(k); ok {
 			if column.ClickHouseAlias != "" {
@@ -142,16 +143,20 @@ func New(config Configuration) (*Component, error) {
 				case "UInt8", "UInt16", "UInt32", "UInt64":
 					parserType = "uint"
 				}
-				customDictColumns = append(customDictColumns,
-					Column{
-						Key:            key,
-						Name:           name,
-						ParserType:     parserType,
-						ClickHouseType: fmt.Sprintf("LowCardinality(%s)", a.Type),
-						ClickHouseGenerateFrom: fmt.Sprintf("dictGet('custom_dict_%s', '%s', %s)", dname, a.Name,
-							matchingString),
-					})
-				columnNameMap.Insert(key, name)
+				column := Column{
+					Key:            key,
+					Name:           name,
+					ParserType:     parserType,
+					ClickHouseType: fmt.Sprintf("LowCardinality(%s)", a.Type),
+				}
+				expression := fmt.Sprintf("dictGet('custom_dict_%s', '%s', %s)", dname, a.Name, matchingString)
+				if v.QueryTime {
+					column.ClickHouseAlias = expression
+				} else {
+					column.ClickHouseGenerateFrom = expression
+				}
+				customDictColumns = append(customDictColumns, column)
+				schema.dynamicColumnNames[name] = key
 				schema.dynamicColumns++
 			}
 		}
@@ -159,8 +164,103 @@ func New(config Configuration) (*Component, error) {
 
 	schema.columns = append(schema.columns, customDictColumns...)
 
+	// Add custom columns. Unlike columns from custom dictionaries, they are
+	// part of the protobuf schema as they are populated by the inlet.
+	customColumnNames := map[string]bool{}
+	for _, cc := range config.CustomColumns {
+		if _, ok := columnNameMap.LoadKey(cc.Name); ok {
+			return nil, fmt.Errorf("custom column %q conflicts with an existing column", cc.Name)
+		}
+		for _, column := range customDictColumns {
+			if column.Name == cc.Name {
+				return nil, fmt.Errorf("custom column %q conflicts with a custom dictionary column", cc.Name)
+			}
+		}
+		// Columns starting with Src or InIf are expanded to Dst and OutIf
+		// columns. This is not supported for custom columns.
+		for _, prefix := range []string{"Src", "Dst", "InIf", "OutIf"} {
+			if strings.HasPrefix(cc.Name, prefix) {
+				return nil, fmt.Errorf("custom column %q cannot start with %q", cc.Name, prefix)
+			}
+		}
+		if customColumnNames[cc.Name] {
+			return nil, fmt.Errorf("custom column %q declared twice", cc.Name)
+		}
+		customColumnNames[cc.Name] = true
+		key := ColumnLast + schema.dynamicColumns
+		column := Column{
+			Key:                     key,
+			Name:                    cc.Name,
+			ParserType:              cc.Type,
+			ClickHouseNotSortingKey: true,
+		}
+		switch cc.Type {
+		case "uint":
+			column.ClickHouseType = "UInt64"
+		case "string":
+			column.ClickHouseType = "LowCardinality(String)"
+		case "ip":
+			column.ClickHouseType = "IPv6"
+		default:
+			return nil, fmt.Errorf("custom column %q has an unknown type %q", cc.Name, cc.Type)
+		}
+		schema.columns = append(schema.columns, column)
+		schema.dynamicColumnNames[cc.Name] = key
+		schema.dynamicColumns++
+	}
+
+	// Add columns for custom network attributes. Like the builtin network
+	// attributes, they are looked up in the networks dictionary for both the
+	// source and the destination addresses.
+	customNetworkAttributes := map[string]bool{}
+	for _, attr := range config.CustomNetworkAttributes {
+		if slices.Contains(networksBuiltinAttributes, attr) {
+			return nil, fmt.Errorf("custom network attribute %q conflicts with a builtin attribute", attr)
+		}
+		if customNetworkAttributes[attr] {
+			return nil, fmt.Errorf("custom network attribute %q declared twice", attr)
+		}
+		customNetworkAttributes[attr] = true
+		for _, direction := range []string{"Src", "Dst"} {
+			name := fmt.Sprintf("%sNet%s", direction, cases.Title(language.Und).String(attr))
+			if _, ok := columnNameMap.LoadKey(name); ok {
+				return nil, fmt.Errorf("custom network attribute %q conflicts with column %q", attr, name)
+			}
+			for _, column := range schema.columns {
+				if column.Name == name {
+					return nil, fmt.Errorf("custom network attribute %q conflicts with column %q", attr, name)
+				}
+			}
+			key := ColumnLast + schema.dynamicColumns
+			schema.columns = append(schema.columns, Column{
+				Key:                     key,
+				Name:                    name,
+				ParserType:              "string",
+				ClickHouseType:          "LowCardinality(String)",
+				ClickHouseGenerateFrom:  fmt.Sprintf("c_%sNetworks[%s]", direction, attr),
+				ClickHouseNotSortingKey: true,
+			})
+			schema.dynamicColumnNames[name] = key
+			schema.dynamicColumns++
+		}
+	}
+
+	// Storage overrides are applied once column names are final as the Dst
+	// and OutIf columns only exist after this step.
+	schema = schema.finalize()
+	configured := map[string]bool{}
+	for _, cs := range config.ColumnStorage {
+		if configured[cs.Name] {
+			return nil, fmt.Errorf("storage for column %q configured twice", cs.Name)
+		}
+		configured[cs.Name] = true
+		if err := schema.applyColumnStorage(cs); err != nil {
+			return nil, err
+		}
+	}
+
 	return &Component{
 		c:      config,
-		Schema: schema.finalize(),
+		Schema: schema,
 	}, nil
 }
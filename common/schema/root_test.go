@@ -189,6 +189,40 @@ func TestCustomDictionariesMatcher(t *testing.T) {
 	}
 }
 
+func TestCustomDictionariesQueryTime(t *testing.T) {
+	config := schema.DefaultConfiguration()
+	config.CustomDictionaries = map[string]schema.CustomDict{
+		"exporters": {
+			Keys: []schema.CustomDictKey{
+				{Name: "addr", Type: "IPv6"},
+			},
+			Attributes: []schema.CustomDictAttribute{
+				{Name: "site", Type: "String", Label: "CurrentSite"},
+			},
+			Source:     "exporters.csv",
+			Dimensions: []string{"ExporterAddress"},
+			Layout:     "hashed",
+			QueryTime:  true,
+		},
+	}
+
+	s, err := schema.New(config)
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	column, ok := s.LookupColumnByName("ExporterAddressCurrentSite")
+	if !ok {
+		t.Fatal("ExporterAddressCurrentSite not found")
+	}
+	if diff := helpers.Diff(column.ClickHouseDefinition(),
+		"`ExporterAddressCurrentSite` LowCardinality(String) ALIAS dictGet('custom_dict_exporters', 'site', ExporterAddress)"); diff != "" {
+		t.Fatalf("ClickHouseDefinition() (-got, +want):\n%s", diff)
+	}
+	if column.ClickHouseGenerateFrom != "" || column.ProtobufIndex > 0 {
+		t.Fatalf("ExporterAddressCurrentSite should only be computed at query time")
+	}
+}
+
 // We need MatchDimension or MatchDimensionSuffix for multiple keys
 func TestCustomDictMultiKeyErr(t *testing.T) {
 	config := schema.DefaultConfiguration()
@@ -239,3 +273,303 @@ func TestCustomDictNoKeyErr(t *testing.T) {
 		t.Fatalf("New() did not error correctly\n %s", diff)
 	}
 }
+
+func TestCustomColumns(t *testing.T) {
+	config := schema.DefaultConfiguration()
+	config.CustomColumns = []schema.CustomColumn{
+		{Name: "ApplicationName", Type: "string"},
+		{Name: "ApplicationID", Type: "uint"},
+		{Name: "NextHopAddr", Type: "ip"},
+	}
+
+	s, err := schema.New(config)
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+
+	expected := map[string]string{
+		"ApplicationName": "LowCardinality(String)",
+		"ApplicationID":   "UInt64",
+		"NextHopAddr":     "IPv6",
+	}
+	for name, chType := range expected {
+		column, ok := s.LookupColumnByName(name)
+		if !ok {
+			t.Fatalf("LookupColumnByName(%q) not found", name)
+		}
+		if column.ClickHouseType != chType {
+			t.Errorf("LookupColumnByName(%q) ClickHouseType is %s, expected %s",
+				name, column.ClickHouseType, chType)
+		}
+		if column.ProtobufIndex <= 0 {
+			t.Errorf("LookupColumnByName(%q) is not part of the protobuf schema", name)
+		}
+	}
+}
+
+func TestCustomColumnsPerSchema(t *testing.T) {
+	config := schema.DefaultConfiguration()
+	config.CustomColumns = []schema.CustomColumn{
+		{Name: "ApplicationName", Type: "string"},
+	}
+	s1, err := schema.New(config)
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	config.CustomColumns = []schema.CustomColumn{
+		{Name: "ApplicationID", Type: "uint"},
+	}
+	s2, err := schema.New(config)
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	s3, err := schema.New(schema.DefaultConfiguration())
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+
+	// Both custom columns share the same key but they should not leak
+	// into the other schemas.
+	if column, ok := s1.LookupColumnByName("ApplicationName"); !ok || column.Name != "ApplicationName" {
+		t.Errorf("LookupColumnByName(%q) == %v, %v", "ApplicationName", column.Name, ok)
+	}
+	if _, ok := s1.LookupColumnByName("ApplicationID"); ok {
+		t.Errorf("LookupColumnByName(%q) found in first schema", "ApplicationID")
+	}
+	if column, ok := s2.LookupColumnByName("ApplicationID"); !ok || column.Name != "ApplicationID" {
+		t.Errorf("LookupColumnByName(%q) == %v, %v", "ApplicationID", column.Name, ok)
+	}
+	if _, ok := s2.LookupColumnByName("ApplicationName"); ok {
+		t.Errorf("LookupColumnByName(%q) found in second schema", "ApplicationName")
+	}
+	for _, name := range []string{"ApplicationName", "ApplicationID"} {
+		if _, ok := s3.LookupColumnByName(name); ok {
+			t.Errorf("LookupColumnByName(%q) found in schema without custom columns", name)
+		}
+	}
+}
+
+func TestCustomColumnsConflict(t *testing.T) {
+	cases := []struct {
+		Description string
+		Columns     []schema.CustomColumn
+		Dictionary  bool
+		Error       string
+	}{
+		{
+			Description: "static column",
+			Columns:     []schema.CustomColumn{{Name: "ExporterName", Type: "string"}},
+			Error:       `custom column "ExporterName" conflicts with an existing column`,
+		}, {
+			Description: "custom dictionary column",
+			Columns:     []schema.CustomColumn{{Name: "ExporterAddressRole", Type: "string"}},
+			Dictionary:  true,
+			Error:       `custom column "ExporterAddressRole" conflicts with a custom dictionary column`,
+		}, {
+			Description: "declared twice",
+			Columns: []schema.CustomColumn{
+				{Name: "ApplicationName", Type: "string"},
+				{Name: "ApplicationName", Type: "string"},
+			},
+			Error: `custom column "ApplicationName" declared twice`,
+		}, {
+			Description: "Src prefix",
+			Columns:     []schema.CustomColumn{{Name: "SrcApp", Type: "string"}},
+			Error:       `custom column "SrcApp" cannot start with "Src"`,
+		}, {
+			Description: "InIf prefix",
+			Columns:     []schema.CustomColumn{{Name: "InIfApp", Type: "string"}},
+			Error:       `custom column "InIfApp" cannot start with "InIf"`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			config := schema.DefaultConfiguration()
+			config.CustomColumns = tc.Columns
+			if tc.Dictionary {
+				config.CustomDictionaries = map[string]schema.CustomDict{
+					"test": {
+						Keys:       []schema.CustomDictKey{{Name: "addr", Type: "String"}},
+						Attributes: []schema.CustomDictAttribute{{Name: "role", Type: "String"}},
+						Source:     "test.csv",
+						Dimensions: []string{"ExporterAddress"},
+					},
+				}
+			}
+			_, err := schema.New(config)
+			if err == nil {
+				t.Fatal("New() did not error")
+			}
+			if diff := helpers.Diff(err.Error(), tc.Error); diff != "" {
+				t.Fatalf("New() did not error correctly\n %s", diff)
+			}
+		})
+	}
+}
+
+func TestCustomNetworkAttributes(t *testing.T) {
+	config := schema.DefaultConfiguration()
+	config.CustomNetworkAttributes = []string{"environment"}
+
+	s, err := schema.New(config)
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+
+	expected := map[string]string{
+		"SrcNetEnvironment": "c_SrcNetworks[environment]",
+		"DstNetEnvironment": "c_DstNetworks[environment]",
+	}
+	for name, generateFrom := range expected {
+		column, ok := s.LookupColumnByName(name)
+		if !ok {
+			t.Fatalf("LookupColumnByName(%q) not found", name)
+		}
+		if column.ClickHouseGenerateFrom != generateFrom {
+			t.Errorf("LookupColumnByName(%q) ClickHouseGenerateFrom is %s, expected %s",
+				name, column.ClickHouseGenerateFrom, generateFrom)
+		}
+		if column.ProtobufIndex > 0 {
+			t.Errorf("LookupColumnByName(%q) is part of the protobuf schema", name)
+		}
+	}
+	if diff := helpers.Diff(s.GetCustomNetworkAttributes(), []string{"environment"}); diff != "" {
+		t.Fatalf("GetCustomNetworkAttributes() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestCustomNetworkAttributesConflict(t *testing.T) {
+	cases := []struct {
+		Description string
+		Attributes  []string
+		Error       string
+	}{
+		{
+			Description: "builtin attribute",
+			Attributes:  []string{"tenant"},
+			Error:       `custom network attribute "tenant" conflicts with a builtin attribute`,
+		}, {
+			Description: "declared twice",
+			Attributes:  []string{"environment", "environment"},
+			Error:       `custom network attribute "environment" declared twice`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			config := schema.DefaultConfiguration()
+			config.CustomNetworkAttributes = tc.Attributes
+			_, err := schema.New(config)
+			if err == nil {
+				t.Fatal("New() did not error")
+			}
+			if diff := helpers.Diff(err.Error(), tc.Error); diff != "" {
+				t.Fatalf("New() did not error correctly\n %s", diff)
+			}
+		})
+	}
+}
+
+func TestColumnStorage(t *testing.T) {
+	yes, no := true, false
+	config := schema.DefaultConfiguration()
+	config.ColumnStorage = []schema.ColumnStorage{
+		{Name: "TimeReceived", Codec: "doubledelta, zstd"},
+		{Name: "Bytes", Codec: "Delta, ZSTD(3)"},
+		{Name: "ExporterName", LowCardinality: &no, Codec: "LZ4HC(9)"},
+		{Name: "SrcAddr", LowCardinality: &yes},
+	}
+	s, err := schema.New(config)
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	for _, expected := range []struct {
+		Name  string
+		Type  string
+		Codec string
+	}{
+		{"TimeReceived", "DateTime", "DoubleDelta, ZSTD(1)"},
+		{"Bytes", "UInt64", "Delta(8), ZSTD(3)"},
+		{"ExporterName", "String", "LZ4HC(9)"},
+		{"SrcAddr", "LowCardinality(IPv6)", "ZSTD(1)"},
+		{"DstAddr", "IPv6", "ZSTD(1)"},
+		{"Packets", "UInt64", "T64, LZ4"},
+	} {
+		column, ok := s.LookupColumnByName(expected.Name)
+		if !ok {
+			t.Fatalf("LookupColumnByName(%q) not found", expected.Name)
+		}
+		if column.ClickHouseType != expected.Type || column.ClickHouseCodec != expected.Codec {
+			t.Errorf("LookupColumnByName(%q) is %s CODEC(%s), expected %s CODEC(%s)",
+				expected.Name, column.ClickHouseType, column.ClickHouseCodec,
+				expected.Type, expected.Codec)
+		}
+	}
+}
+
+func TestColumnStorageErrors(t *testing.T) {
+	yes, no := true, false
+	cases := []struct {
+		Description string
+		Storage     schema.ColumnStorage
+		Error       string
+	}{
+		{
+			Description: "unknown column",
+			Storage:     schema.ColumnStorage{Name: "Nothing", Codec: "ZSTD"},
+			Error:       `storage for column "Nothing": unknown column`,
+		}, {
+			Description: "unknown codec",
+			Storage:     schema.ColumnStorage{Name: "Bytes", Codec: "Brotli"},
+			Error:       `storage for column "Bytes": unknown codec "Brotli"`,
+		}, {
+			Description: "unparsable codec",
+			Storage:     schema.ColumnStorage{Name: "Bytes", Codec: "ZSTD(3"},
+			Error:       `storage for column "Bytes": cannot parse codec "ZSTD(3"`,
+		}, {
+			Description: "invalid ZSTD level",
+			Storage:     schema.ColumnStorage{Name: "Bytes", Codec: "ZSTD(23)"},
+			Error:       `storage for column "Bytes": invalid level for codec "ZSTD(23)"`,
+		}, {
+			Description: "invalid Delta size",
+			Storage:     schema.ColumnStorage{Name: "Bytes", Codec: "Delta(3)"},
+			Error:       `storage for column "Bytes": invalid size for codec "Delta(3)"`,
+		}, {
+			Description: "Delta on string",
+			Storage:     schema.ColumnStorage{Name: "ExporterName", Codec: "Delta, LZ4"},
+			Error:       `storage for column "ExporterName": codec "Delta" is not compatible with type LowCardinality(String)`,
+		}, {
+			Description: "Gorilla on integer",
+			Storage:     schema.ColumnStorage{Name: "Bytes", Codec: "Gorilla"},
+			Error:       `storage for column "Bytes": codec "Gorilla" is not compatible with type UInt64`,
+		}, {
+			Description: "specialized codec after general codec",
+			Storage:     schema.ColumnStorage{Name: "Bytes", Codec: "LZ4, T64"},
+			Error:       `storage for column "Bytes": codec "T64" should be before general purpose codecs`,
+		}, {
+			Description: "codec on alias",
+			Storage:     schema.ColumnStorage{Name: "SrcNetPrefix", Codec: "ZSTD"},
+			Error:       `storage for column "SrcNetPrefix": cannot set a codec on an alias`,
+		}, {
+			Description: "LowCardinality on sorting key",
+			Storage:     schema.ColumnStorage{Name: "InIfName", LowCardinality: &no},
+			Error:       `storage for column "InIfName": cannot change LowCardinality of a sorting key`,
+		}, {
+			Description: "LowCardinality on array",
+			Storage:     schema.ColumnStorage{Name: "DstASPath", LowCardinality: &yes},
+			Error:       `storage for column "DstASPath": type Array(UInt32) cannot be LowCardinality`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			config := schema.DefaultConfiguration()
+			config.ColumnStorage = []schema.ColumnStorage{tc.Storage}
+			_, err := schema.New(config)
+			if err == nil {
+				t.Fatal("New() did not error")
+			}
+			if diff := helpers.Diff(err.Error(), tc.Error); diff != "" {
+				t.Fatalf("New() did not error correctly\n %s", diff)
+			}
+		})
+	}
+}
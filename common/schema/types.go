@@ -15,10 +15,14 @@ import (
 type Schema struct {
 	columns        []Column      // Ordered list of columns
 	columnIndex    []*Column     // Columns indexed by ColumnKey
+	protobufIndex  []*Column     // Columns indexed by protobuf field number
 	disabledGroups bitset.BitSet // Disabled column groups
 
 	// dynamicColumns is the number of columns that are generated at runtime and appended after columnLast
 	dynamicColumns ColumnKey
+	// dynamicColumnNames maps the names of the columns generated at runtime to
+	// their keys. Unlike static columns, they are specific to this schema.
+	dynamicColumnNames map[string]ColumnKey
 	// For ClickHouse. This is the set of primary keys (order is important and
 	// may not follow column order) for the aggregated tables.
 	clickhousePrimaryKeys []ColumnKey
@@ -98,9 +102,10 @@ type FlowMessage struct {
 	NextHop netip.Addr
 
 	// Core component may override them
-	SrcAS     uint32
-	DstAS     uint32
-	GotASPath bool
+	SrcAS          uint32
+	DstAS          uint32
+	DstASPath      []uint32
+	DstCommunities []uint32
 
 	SrcNetMask uint8
 	DstNetMask uint8
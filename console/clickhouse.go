@@ -13,6 +13,7 @@ import (
 	"text/template"
 	"time"
 
+	"akvorado/common/clickhousedb"
 	"akvorado/console/query"
 )
 
@@ -21,6 +22,22 @@ type flowsTable struct {
 	Name       string
 	Resolution time.Duration
 	Oldest     time.Time
+	// Columns is the set of columns of the table. When nil, the table is
+	// assumed to contain all the columns.
+	Columns map[string]bool
+}
+
+// hasColumns tells if the table contains all the provided columns.
+func (table flowsTable) hasColumns(columns []string) bool {
+	if table.Columns == nil {
+		return true
+	}
+	for _, column := range columns {
+		if !table.Columns[column] {
+			return false
+		}
+	}
+	return true
 }
 
 // refreshFlowsTables refreshes the information we have about flows
@@ -31,26 +48,48 @@ func (c *Component) refreshFlowsTables() error {
 	var tables []struct {
 		Name string `ch:"name"`
 	}
-	err := c.d.ClickHouseDB.Select(ctx, &tables, `
+	prefix := clickhousedb.EscapeLike(c.d.ClickHouseDB.TableName(""))
+	err := c.d.ClickHouseDB.Select(ctx, &tables, fmt.Sprintf(`
 SELECT name
 FROM system.tables
 WHERE database=currentDatabase()
-AND table LIKE 'flows%'
-AND table NOT LIKE '%_local'
-AND table != 'flows_raw_errors'
-AND (engine LIKE '%MergeTree' OR engine = 'Distributed')
-`)
+AND table LIKE '%[1]sflows%%'
+AND table NOT LIKE '%%_local'
+AND table != '%[2]s'
+AND (engine LIKE '%%MergeTree' OR engine = 'Distributed')
+`, prefix, c.d.ClickHouseDB.TableName("flows_raw_errors")))
 	if err != nil {
 		return fmt.Errorf("cannot query flows table metadata: %w", err)
 	}
 
+	var columns []struct {
+		Table string `ch:"table"`
+		Name  string `ch:"name"`
+	}
+	err = c.d.ClickHouseDB.Select(ctx, &columns, fmt.Sprintf(`
+SELECT table, name
+FROM system.columns
+WHERE database=currentDatabase()
+AND table LIKE '%sflows%%'
+`, prefix))
+	if err != nil {
+		return fmt.Errorf("cannot query flows table columns: %w", err)
+	}
+	tableColumns := map[string]map[string]bool{}
+	for _, column := range columns {
+		if tableColumns[column.Table] == nil {
+			tableColumns[column.Table] = map[string]bool{}
+		}
+		tableColumns[column.Table][column.Name] = true
+	}
+
 	newFlowsTables := []flowsTable{}
 	for _, table := range tables {
 		// Parse resolution
 		resolution := time.Duration(0)
-		if strings.HasPrefix(table.Name, "flows_") {
+		if name, ok := strings.CutPrefix(table.Name, c.d.ClickHouseDB.TableName("flows_")); ok {
 			var err error
-			resolution, err = time.ParseDuration(strings.TrimPrefix(table.Name, "flows_"))
+			resolution, err = time.ParseDuration(name)
 			if err != nil {
 				c.r.Err(err).Msgf("cannot parse duration for table %s", table.Name)
 				continue
@@ -70,6 +109,7 @@ AND (engine LIKE '%MergeTree' OR engine = 'Distributed')
 			Name:       table.Name,
 			Resolution: resolution,
 			Oldest:     oldest[0].T,
+			Columns:    tableColumns[table.Name],
 		})
 	}
 	if len(newFlowsTables) == 0 {
@@ -82,9 +122,23 @@ AND (engine LIKE '%MergeTree' OR engine = 'Distributed')
 	return nil
 }
 
+// oldestFlows returns the timestamp of the oldest flow available in any
+// table. It returns the zero time when no table is known.
+func (c *Component) oldestFlows() time.Time {
+	c.flowsTablesLock.RLock()
+	defer c.flowsTablesLock.RUnlock()
+	oldest := time.Time{}
+	for _, table := range c.flowsTables {
+		if oldest.IsZero() || table.Oldest.Before(oldest) {
+			oldest = table.Oldest
+		}
+	}
+	return oldest
+}
+
 // finalizeQuery builds the finalized query. A single "context"
 // function is provided to return a `Context` struct with all the
-// information needed.
+// information needed. Dictionaries are prefixed with the table prefix.
 func (c *Component) finalizeQuery(query string) string {
 	t := template.Must(template.New("query").
 		Funcs(template.FuncMap{
@@ -97,7 +151,7 @@ func (c *Component) finalizeQuery(query string) string {
 		c.r.Err(err).Str("query", query).Msg("invalid query")
 		panic(err)
 	}
-	return buf.String()
+	return c.d.ClickHouseDB.PrefixDictionaries(buf.String())
 }
 
 type inputContext struct {
@@ -105,6 +159,7 @@ type inputContext struct {
 	End               time.Time  `json:"end"`
 	StartForInterval  *time.Time `json:"start-for-interval,omitempty"`
 	MainTableRequired bool       `json:"main-table-required,omitempty"`
+	Columns           []string   `json:"columns,omitempty"`
 	Points            uint       `json:"points"`
 	Units             string     `json:"units,omitempty"`
 }
@@ -224,26 +279,48 @@ func (c *Component) computeTableAndInterval(input inputContext) (string, time.Du
 	if input.MainTableRequired {
 		targetIntervalForTableSelection = time.Second
 	}
-	table, computedInterval := c.getBestTable(input.Start, targetIntervalForTableSelection)
+	columns := append(input.Columns, unitsColumns(input.Units)...)
+	table, computedInterval := c.getBestTable(input.Start, targetIntervalForTableSelection, columns)
 	if input.StartForInterval != nil {
-		_, computedInterval = c.getBestTable(*input.StartForInterval, targetIntervalForTableSelection)
+		_, computedInterval = c.getBestTable(*input.StartForInterval, targetIntervalForTableSelection, columns)
 	}
 	return table, computedInterval, targetInterval
 }
 
-// Get the best table starting at the specified time.
-func (c *Component) getBestTable(start time.Time, targetInterval time.Duration) (string, time.Duration) {
+// unitsColumns returns the columns needed to compute the provided units, in
+// addition to the ones present in all tables.
+func unitsColumns(units string) []string {
+	switch units {
+	case "inl2%":
+		return []string{"InIfSpeed", "ExporterAddress", "InIfName"}
+	case "outl2%":
+		return []string{"OutIfSpeed", "ExporterAddress", "OutIfName"}
+	}
+	return nil
+}
+
+// Get the best table starting at the specified time and containing the
+// provided columns.
+func (c *Component) getBestTable(start time.Time, targetInterval time.Duration, columns []string) (string, time.Duration) {
 	c.flowsTablesLock.RLock()
 	defer c.flowsTablesLock.RUnlock()
 
-	table := "flows"
+	// Only keep the tables with the requested columns
+	flowsTables := []flowsTable{}
+	for _, table := range c.flowsTables {
+		if table.hasColumns(columns) {
+			flowsTables = append(flowsTables, table)
+		}
+	}
+
+	table := c.d.ClickHouseDB.TableName("flows")
 	computedInterval := time.Second
-	if len(c.flowsTables) > 0 {
+	if len(flowsTables) > 0 {
 		// We can use the consolidated data. The first
 		// criteria is to find the tables matching the time
 		// criteria.
 		candidates := []int{}
-		for idx, table := range c.flowsTables {
+		for idx, table := range flowsTables {
 			if start.After(table.Oldest.Add(table.Resolution)) {
 				candidates = append(candidates, idx)
 			}
@@ -251,35 +328,35 @@ func (c *Component) getBestTable(start time.Time, targetInterval time.Duration)
 		if len(candidates) == 0 {
 			// No candidate, fallback to the one with oldest data
 			best := 0
-			for idx, table := range c.flowsTables {
-				if c.flowsTables[best].Oldest.After(table.Oldest.Add(table.Resolution)) {
+			for idx, table := range flowsTables {
+				if flowsTables[best].Oldest.After(table.Oldest.Add(table.Resolution)) {
 					best = idx
 				}
 			}
 			candidates = []int{best}
 			// Add other candidates that are not far off in term of oldest data
-			for idx, table := range c.flowsTables {
+			for idx, table := range flowsTables {
 				if idx == best {
 					continue
 				}
-				if c.flowsTables[best].Oldest.After(table.Oldest) {
+				if flowsTables[best].Oldest.After(table.Oldest) {
 					candidates = append(candidates, idx)
 				}
 			}
 		}
 		sort.Slice(candidates, func(i, j int) bool {
-			return c.flowsTables[candidates[i]].Resolution < c.flowsTables[candidates[j]].Resolution
+			return flowsTables[candidates[i]].Resolution < flowsTables[candidates[j]].Resolution
 		})
 		// If possible, use the first resolution before the target interval
 		for len(candidates) > 1 {
-			if c.flowsTables[candidates[1]].Resolution < targetInterval {
+			if flowsTables[candidates[1]].Resolution < targetInterval {
 				candidates = candidates[1:]
 			} else {
 				break
 			}
 		}
-		table = c.flowsTables[candidates[0]].Name
-		computedInterval = c.flowsTables[candidates[0]].Resolution
+		table = flowsTables[candidates[0]].Name
+		computedInterval = flowsTables[candidates[0]].Resolution
 	}
 	if computedInterval < time.Second {
 		computedInterval = time.Second
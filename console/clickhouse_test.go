@@ -34,6 +34,27 @@ AND (engine LIKE '%MergeTree' OR engine = 'Distributed')
 			{"flows_1m0s"},
 			{"flows_5m0s"},
 		})
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), `
+SELECT table, name
+FROM system.columns
+WHERE database=currentDatabase()
+AND table LIKE 'flows%'
+`).
+		Return(nil).
+		SetArg(1, []struct {
+			Table string `ch:"table"`
+			Name  string `ch:"name"`
+		}{
+			{"flows", "TimeReceived"},
+			{"flows", "SrcAddr"},
+			{"flows", "SrcCountry"},
+			{"flows_1h0m0s", "TimeReceived"},
+			{"flows_1m0s", "TimeReceived"},
+			{"flows_1m0s", "SrcCountry"},
+			{"flows_5m0s", "TimeReceived"},
+			{"flows_5m0s", "SrcCountry"},
+		})
 	mockConn.EXPECT().
 		Select(gomock.Any(), gomock.Any(), `SELECT MIN(TimeReceived) AS t FROM flows`).
 		Return(nil).
@@ -63,10 +84,14 @@ AND (engine LIKE '%MergeTree' OR engine = 'Distributed')
 	}
 
 	expected := []flowsTable{
-		{"flows", time.Duration(0), time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC)},
-		{"flows_1h0m0s", time.Hour, time.Date(2022, 1, 10, 15, 45, 10, 0, time.UTC)},
-		{"flows_1m0s", time.Minute, time.Date(2022, 4, 20, 15, 45, 10, 0, time.UTC)},
-		{"flows_5m0s", 5 * time.Minute, time.Date(2022, 2, 10, 15, 45, 10, 0, time.UTC)},
+		{"flows", time.Duration(0), time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+			map[string]bool{"TimeReceived": true, "SrcAddr": true, "SrcCountry": true}},
+		{"flows_1h0m0s", time.Hour, time.Date(2022, 1, 10, 15, 45, 10, 0, time.UTC),
+			map[string]bool{"TimeReceived": true}},
+		{"flows_1m0s", time.Minute, time.Date(2022, 4, 20, 15, 45, 10, 0, time.UTC),
+			map[string]bool{"TimeReceived": true, "SrcCountry": true}},
+		{"flows_5m0s", 5 * time.Minute, time.Date(2022, 2, 10, 15, 45, 10, 0, time.UTC),
+			map[string]bool{"TimeReceived": true, "SrcCountry": true}},
 	}
 	if diff := helpers.Diff(c.flowsTables, expected); diff != "" {
 		t.Fatalf("refreshFlowsTables() diff:\n%s", diff)
@@ -102,7 +127,7 @@ func TestFinalizeQuery(t *testing.T) {
 			Expected: "SELECT TimeReceived, SrcPort FROM flows WHERE TimeReceived BETWEEN toDateTime('2022-04-10 15:45:10', 'UTC') AND toDateTime('2022-04-11 15:45:10', 'UTC')",
 		}, {
 			Description: "only flows table available",
-			Tables:      []flowsTable{{"flows", 0, time.Date(2022, 3, 10, 15, 45, 10, 0, time.UTC)}},
+			Tables:      []flowsTable{{"flows", 0, time.Date(2022, 3, 10, 15, 45, 10, 0, time.UTC), nil}},
 			Query:       "SELECT 1 FROM {{ .Table }} WHERE {{ .Timefilter }}",
 			Context: inputContext{
 				Start:  time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
@@ -112,7 +137,7 @@ func TestFinalizeQuery(t *testing.T) {
 			Expected: "SELECT 1 FROM flows WHERE TimeReceived BETWEEN toDateTime('2022-04-10 15:45:10', 'UTC') AND toDateTime('2022-04-11 15:45:10', 'UTC')",
 		}, {
 			Description: "timefilter.Start and timefilter.Stop",
-			Tables:      []flowsTable{{"flows", 0, time.Date(2022, 3, 10, 15, 45, 10, 0, time.UTC)}},
+			Tables:      []flowsTable{{"flows", 0, time.Date(2022, 3, 10, 15, 45, 10, 0, time.UTC), nil}},
 			Query:       "SELECT {{ .TimefilterStart }}, {{ .TimefilterEnd }}",
 			Context: inputContext{
 				Start:  time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
@@ -122,7 +147,7 @@ func TestFinalizeQuery(t *testing.T) {
 			Expected: "SELECT toDateTime('2022-04-10 15:45:10', 'UTC'), toDateTime('2022-04-11 15:45:10', 'UTC')",
 		}, {
 			Description: "only flows table and out of range request",
-			Tables:      []flowsTable{{"flows", 0, time.Date(2022, 4, 10, 22, 45, 10, 0, time.UTC)}},
+			Tables:      []flowsTable{{"flows", 0, time.Date(2022, 4, 10, 22, 45, 10, 0, time.UTC), nil}},
 			Query:       "SELECT 1 FROM {{ .Table }} WHERE {{ .Timefilter }}",
 			Context: inputContext{
 				Start:  time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
@@ -133,8 +158,8 @@ func TestFinalizeQuery(t *testing.T) {
 		}, {
 			Description: "select consolidated table",
 			Tables: []flowsTable{
-				{"flows", 0, time.Date(2022, 3, 10, 22, 45, 10, 0, time.UTC)},
-				{"flows_1m0s", time.Minute, time.Date(2022, 4, 2, 22, 45, 10, 0, time.UTC)},
+				{"flows", 0, time.Date(2022, 3, 10, 22, 45, 10, 0, time.UTC), nil},
+				{"flows_1m0s", time.Minute, time.Date(2022, 4, 2, 22, 45, 10, 0, time.UTC), nil},
 			},
 			Query: "SELECT 1 FROM {{ .Table }} WHERE {{ .Timefilter }} // {{ .Interval }}",
 			Context: inputContext{
@@ -146,8 +171,8 @@ func TestFinalizeQuery(t *testing.T) {
 		}, {
 			Description: "select consolidated table out of range",
 			Tables: []flowsTable{
-				{"flows", 0, time.Date(2022, 4, 10, 22, 45, 10, 0, time.UTC)},
-				{"flows_1m0s", time.Minute, time.Date(2022, 4, 10, 17, 45, 10, 0, time.UTC)},
+				{"flows", 0, time.Date(2022, 4, 10, 22, 45, 10, 0, time.UTC), nil},
+				{"flows_1m0s", time.Minute, time.Date(2022, 4, 10, 17, 45, 10, 0, time.UTC), nil},
 			},
 			Query: "SELECT 1 FROM {{ .Table }} WHERE {{ .Timefilter }}",
 			Context: inputContext{
@@ -159,8 +184,8 @@ func TestFinalizeQuery(t *testing.T) {
 		}, {
 			Description: "select flows table out of range",
 			Tables: []flowsTable{
-				{"flows", 0, time.Date(2022, 4, 10, 16, 45, 10, 0, time.UTC)},
-				{"flows_1m0s", time.Minute, time.Date(2022, 4, 10, 17, 45, 10, 0, time.UTC)},
+				{"flows", 0, time.Date(2022, 4, 10, 16, 45, 10, 0, time.UTC), nil},
+				{"flows_1m0s", time.Minute, time.Date(2022, 4, 10, 17, 45, 10, 0, time.UTC), nil},
 			},
 			Query: "SELECT 1 FROM {{ .Table }} WHERE {{ .Timefilter }}",
 			Context: inputContext{
@@ -172,8 +197,8 @@ func TestFinalizeQuery(t *testing.T) {
 		}, {
 			Description: "use flows table for resolution (control for next case)",
 			Tables: []flowsTable{
-				{"flows", 0, time.Date(2022, 4, 10, 10, 45, 10, 0, time.UTC)},
-				{"flows_1m0s", time.Minute, time.Date(2022, 3, 10, 10, 45, 10, 0, time.UTC)},
+				{"flows", 0, time.Date(2022, 4, 10, 10, 45, 10, 0, time.UTC), nil},
+				{"flows_1m0s", time.Minute, time.Date(2022, 3, 10, 10, 45, 10, 0, time.UTC), nil},
 			},
 			Query: "SELECT 1 FROM {{ .Table }} WHERE {{ .Timefilter }} // {{ .Interval }}",
 			Context: inputContext{
@@ -185,8 +210,8 @@ func TestFinalizeQuery(t *testing.T) {
 		}, {
 			Description: "use flows table for resolution (but flows_1m0s for data)",
 			Tables: []flowsTable{
-				{"flows", 0, time.Date(2022, 4, 10, 10, 45, 10, 0, time.UTC)},
-				{"flows_1m0s", time.Minute, time.Date(2022, 3, 10, 10, 45, 10, 0, time.UTC)},
+				{"flows", 0, time.Date(2022, 4, 10, 10, 45, 10, 0, time.UTC), nil},
+				{"flows_1m0s", time.Minute, time.Date(2022, 3, 10, 10, 45, 10, 0, time.UTC), nil},
 			},
 			Query: "SELECT 1 FROM {{ .Table }} WHERE {{ .Timefilter }} // {{ .Interval }}",
 			Context: inputContext{
@@ -202,8 +227,8 @@ func TestFinalizeQuery(t *testing.T) {
 		}, {
 			Description: "select flows table with better resolution",
 			Tables: []flowsTable{
-				{"flows", 0, time.Date(2022, 3, 10, 16, 45, 10, 0, time.UTC)},
-				{"flows_1m0s", time.Minute, time.Date(2022, 3, 10, 17, 45, 10, 0, time.UTC)},
+				{"flows", 0, time.Date(2022, 3, 10, 16, 45, 10, 0, time.UTC), nil},
+				{"flows_1m0s", time.Minute, time.Date(2022, 3, 10, 17, 45, 10, 0, time.UTC), nil},
 			},
 			Query: "SELECT 1 FROM {{ .Table }} WHERE {{ .Timefilter }} // {{ .Interval }}",
 			Context: inputContext{
@@ -215,9 +240,9 @@ func TestFinalizeQuery(t *testing.T) {
 		}, {
 			Description: "select consolidated table with better resolution",
 			Tables: []flowsTable{
-				{"flows", 0, time.Date(2022, 3, 10, 22, 45, 10, 0, time.UTC)},
-				{"flows_5m0s", 5 * time.Minute, time.Date(2022, 4, 2, 22, 45, 10, 0, time.UTC)},
-				{"flows_1m0s", time.Minute, time.Date(2022, 4, 2, 22, 45, 10, 0, time.UTC)},
+				{"flows", 0, time.Date(2022, 3, 10, 22, 45, 10, 0, time.UTC), nil},
+				{"flows_5m0s", 5 * time.Minute, time.Date(2022, 4, 2, 22, 45, 10, 0, time.UTC), nil},
+				{"flows_1m0s", time.Minute, time.Date(2022, 4, 2, 22, 45, 10, 0, time.UTC), nil},
 			},
 			Query: "SELECT 1 FROM {{ .Table }} WHERE {{ .Timefilter }} // {{ .Interval }}",
 			Context: inputContext{
@@ -229,9 +254,9 @@ func TestFinalizeQuery(t *testing.T) {
 		}, {
 			Description: "select consolidated table with better range",
 			Tables: []flowsTable{
-				{"flows", 0, time.Date(2022, 4, 10, 22, 45, 10, 0, time.UTC)},
-				{"flows_5m0s", 5 * time.Minute, time.Date(2022, 4, 2, 22, 45, 10, 0, time.UTC)},
-				{"flows_1m0s", time.Minute, time.Date(2022, 4, 10, 22, 45, 10, 0, time.UTC)},
+				{"flows", 0, time.Date(2022, 4, 10, 22, 45, 10, 0, time.UTC), nil},
+				{"flows_5m0s", 5 * time.Minute, time.Date(2022, 4, 2, 22, 45, 10, 0, time.UTC), nil},
+				{"flows_1m0s", time.Minute, time.Date(2022, 4, 10, 22, 45, 10, 0, time.UTC), nil},
 			},
 			Query: "SELECT 1 FROM {{ .Table }} WHERE {{ .Timefilter }}",
 			Context: inputContext{
@@ -243,9 +268,9 @@ func TestFinalizeQuery(t *testing.T) {
 		}, {
 			Description: "select best resolution when equality for oldest data",
 			Tables: []flowsTable{
-				{"flows", 0, time.Date(2022, 4, 10, 22, 40, 55, 0, time.UTC)},
-				{"flows_1m0s", time.Minute, time.Date(2022, 4, 10, 22, 40, 0, 0, time.UTC)},
-				{"flows_1h0m0s", time.Hour, time.Date(2022, 4, 10, 22, 0, 10, 0, time.UTC)},
+				{"flows", 0, time.Date(2022, 4, 10, 22, 40, 55, 0, time.UTC), nil},
+				{"flows_1m0s", time.Minute, time.Date(2022, 4, 10, 22, 40, 0, 0, time.UTC), nil},
+				{"flows_1h0m0s", time.Hour, time.Date(2022, 4, 10, 22, 0, 10, 0, time.UTC), nil},
 			},
 			Query: "SELECT 1 FROM {{ .Table }} WHERE {{ .Timefilter }}",
 			Context: inputContext{
@@ -276,10 +301,10 @@ func TestFinalizeQuery(t *testing.T) {
 			Description: "Small interval outside main table expiration",
 			Query:       "SELECT InIfProvider FROM {{ .Table }}",
 			Tables: []flowsTable{
-				{"flows", time.Duration(0), time.Date(2022, 11, 6, 12, 0, 0, 0, time.UTC)},
-				{"flows_1h0m0s", time.Hour, time.Date(2022, 4, 25, 18, 0, 0, 0, time.UTC)},
-				{"flows_1m0s", time.Minute, time.Date(2022, 11, 14, 12, 0, 0, 0, time.UTC)},
-				{"flows_5m0s", 5 * time.Minute, time.Date(2022, 8, 23, 12, 0, 0, 0, time.UTC)},
+				{"flows", time.Duration(0), time.Date(2022, 11, 6, 12, 0, 0, 0, time.UTC), nil},
+				{"flows_1h0m0s", time.Hour, time.Date(2022, 4, 25, 18, 0, 0, 0, time.UTC), nil},
+				{"flows_1m0s", time.Minute, time.Date(2022, 11, 14, 12, 0, 0, 0, time.UTC), nil},
+				{"flows_5m0s", 5 * time.Minute, time.Date(2022, 8, 23, 12, 0, 0, 0, time.UTC), nil},
 			},
 			Context: inputContext{
 				Start:  time.Date(2022, 10, 30, 1, 0, 0, 0, time.UTC),
@@ -329,7 +354,7 @@ func TestComputeBestTableAndInterval(t *testing.T) {
 			Expected: tableIntervalOutput{Table: "flows", Interval: 1},
 		}, {
 			Description: "only flows table available",
-			Tables:      []flowsTable{{"flows", 0, time.Date(2022, 3, 10, 15, 45, 10, 0, time.UTC)}},
+			Tables:      []flowsTable{{"flows", 0, time.Date(2022, 3, 10, 15, 45, 10, 0, time.UTC), nil}},
 			Context: inputContext{
 				Start:  time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
 				End:    time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
@@ -339,8 +364,8 @@ func TestComputeBestTableAndInterval(t *testing.T) {
 		}, {
 			Description: "select flows table out of range",
 			Tables: []flowsTable{
-				{"flows", 0, time.Date(2022, 4, 10, 16, 45, 10, 0, time.UTC)},
-				{"flows_1m0s", time.Minute, time.Date(2022, 4, 10, 17, 45, 10, 0, time.UTC)},
+				{"flows", 0, time.Date(2022, 4, 10, 16, 45, 10, 0, time.UTC), nil},
+				{"flows_1m0s", time.Minute, time.Date(2022, 4, 10, 17, 45, 10, 0, time.UTC), nil},
 			},
 			Context: inputContext{
 				Start:  time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
@@ -351,9 +376,9 @@ func TestComputeBestTableAndInterval(t *testing.T) {
 		}, {
 			Description: "select consolidated table with better resolution",
 			Tables: []flowsTable{
-				{"flows", 0, time.Date(2022, 3, 10, 22, 45, 10, 0, time.UTC)},
-				{"flows_5m0s", 5 * time.Minute, time.Date(2022, 4, 2, 22, 45, 10, 0, time.UTC)},
-				{"flows_1m0s", time.Minute, time.Date(2022, 4, 2, 22, 45, 10, 0, time.UTC)},
+				{"flows", 0, time.Date(2022, 3, 10, 22, 45, 10, 0, time.UTC), nil},
+				{"flows_5m0s", 5 * time.Minute, time.Date(2022, 4, 2, 22, 45, 10, 0, time.UTC), nil},
+				{"flows_1m0s", time.Minute, time.Date(2022, 4, 2, 22, 45, 10, 0, time.UTC), nil},
 			},
 			Context: inputContext{
 				Start:  time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
@@ -361,6 +386,52 @@ func TestComputeBestTableAndInterval(t *testing.T) {
 				Points: 720, // 2-minute resolution,
 			},
 			Expected: tableIntervalOutput{Table: "flows_1m0s", Interval: 60},
+		}, {
+			Description: "select coarsest consolidated table with all columns",
+			Tables: []flowsTable{
+				{"flows", 0, time.Date(2022, 3, 10, 22, 45, 10, 0, time.UTC), nil},
+				{"flows_5m0s", 5 * time.Minute, time.Date(2022, 3, 2, 22, 45, 10, 0, time.UTC),
+					map[string]bool{"TimeReceived": true, "SrcAS": true, "SrcCountry": true}},
+				{"flows_1h0m0s", time.Hour, time.Date(2022, 3, 2, 22, 45, 10, 0, time.UTC),
+					map[string]bool{"TimeReceived": true, "SrcAS": true}},
+			},
+			Context: inputContext{
+				Start:   time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+				End:     time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+				Columns: []string{"SrcAS", "SrcCountry"},
+				Points:  12, // 2-hour resolution
+			},
+			Expected: tableIntervalOutput{Table: "flows_5m0s", Interval: 300},
+		}, {
+			Description: "select main table when no consolidated table has all columns",
+			Tables: []flowsTable{
+				{"flows", 0, time.Date(2022, 3, 10, 22, 45, 10, 0, time.UTC), nil},
+				{"flows_1h0m0s", time.Hour, time.Date(2022, 3, 2, 22, 45, 10, 0, time.UTC),
+					map[string]bool{"TimeReceived": true, "SrcAS": true}},
+			},
+			Context: inputContext{
+				Start:   time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+				End:     time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+				Columns: []string{"SrcAS", "SrcCountry"},
+				Points:  12, // 2-hour resolution
+			},
+			Expected: tableIntervalOutput{Table: "flows", Interval: 1},
+		}, {
+			Description: "select table with columns needed by units",
+			Tables: []flowsTable{
+				{"flows", 0, time.Date(2022, 3, 10, 22, 45, 10, 0, time.UTC), nil},
+				{"flows_5m0s", 5 * time.Minute, time.Date(2022, 3, 2, 22, 45, 10, 0, time.UTC),
+					map[string]bool{"TimeReceived": true, "InIfSpeed": true, "ExporterAddress": true, "InIfName": true}},
+				{"flows_1h0m0s", time.Hour, time.Date(2022, 3, 2, 22, 45, 10, 0, time.UTC),
+					map[string]bool{"TimeReceived": true, "ExporterAddress": true, "InIfName": true}},
+			},
+			Context: inputContext{
+				Start:  time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+				End:    time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+				Units:  "inl2%",
+				Points: 12, // 2-hour resolution
+			},
+			Expected: tableIntervalOutput{Table: "flows_5m0s", Interval: 300},
 		},
 	}
 
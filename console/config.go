@@ -20,7 +20,10 @@ type Configuration struct {
 	// DefaultVisualizeOptions define some defaults for the "visualize" tab.
 	DefaultVisualizeOptions VisualizeOptionsConfiguration
 	// HomepageTopWidgets defines the list of widgets to display on the home page.
-	HomepageTopWidgets []string `validate:"dive,oneof=src-as dst-as src-country dst-country exporter protocol etype src-port dst-port"`
+	HomepageTopWidgets []string `validate:"dive,oneof=src-as dst-as src-country dst-country src-country-map dst-country-map exporter protocol etype src-port dst-port"`
+	// WorldMapURL is the URL of a GeoJSON file with the countries to draw the
+	// country maps. Without it, countries are displayed as a list.
+	WorldMapURL string `validate:"omitempty,url"`
 	// HomepageGraphFilter defines the filtering string to use for the homepage graph
 	HomepageGraphFilter string
 	// HomepageGraphTimeRange defines the time range to use for the homepage graph
@@ -29,6 +32,28 @@ type Configuration struct {
 	DimensionsLimit int `validate:"min=10"`
 	// CacheTTL tells how long to keep the most costly requests in cache.
 	CacheTTL time.Duration `validate:"min=5s"`
+	// ExportRowsLimit is the maximum number of rows for a CSV export.
+	ExportRowsLimit int `validate:"min=1"`
+	// ReverseDNS defines how to resolve IP addresses to names when requested.
+	ReverseDNS ReverseDNSConfiguration
+}
+
+// ReverseDNSConfiguration defines how to resolve IP addresses returned by
+// graph queries.
+type ReverseDNSConfiguration struct {
+	// Upstream is the DNS server to query. When empty, the system resolver is used.
+	Upstream string `validate:"omitempty,hostname_port|ip"`
+	// Timeout is the timeout for a single lookup.
+	Timeout time.Duration `validate:"min=100ms"`
+	// Budget is the maximum time spent waiting for lookups when answering a
+	// query. Lookups not completed in time are returned by the next queries.
+	Budget time.Duration `validate:"min=1ms"`
+	// CacheSize is the maximum number of entries in the cache.
+	CacheSize int `validate:"min=1"`
+	// CacheTTL is how long to keep an answer in the cache.
+	CacheTTL time.Duration `validate:"min=1m"`
+	// Concurrency is the maximum number of lookups in flight.
+	Concurrency int `validate:"min=1"`
 }
 
 // VisualizeOptionsConfiguration defines options for the "visualize" tab.
@@ -65,8 +90,16 @@ func DefaultConfiguration() Configuration {
 		HomepageTopWidgets:     []string{"src-as", "src-port", "protocol", "src-country", "etype"},
 		DimensionsLimit:        50,
 		CacheTTL:               3 * time.Hour,
+		ExportRowsLimit:        1_000_000,
 		HomepageGraphFilter:    "InIfBoundary = 'external'",
 		HomepageGraphTimeRange: 24 * time.Hour,
+		ReverseDNS: ReverseDNSConfiguration{
+			Timeout:     2 * time.Second,
+			Budget:      300 * time.Millisecond,
+			CacheSize:   10000,
+			CacheTTL:    time.Hour,
+			Concurrency: 10,
+		},
 	}
 }
 
@@ -89,5 +122,6 @@ func (c *Component) configHandlerFunc(gc *gin.Context) {
 		"dimensions":              dimensions,
 		"truncatable":             truncatable,
 		"homepageTopWidgets":      c.config.HomepageTopWidgets,
+		"worldMapURL":             c.config.WorldMapURL,
 	})
 }
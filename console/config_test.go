@@ -30,13 +30,11 @@ func TestConfigHandler(t *testing.T) {
 					"previousPeriod": false,
 				},
 				"homepageTopWidgets": []string{"src-as", "src-port", "protocol", "src-country", "etype"},
+				"worldMapURL":        "",
 				"dimensionsLimit":    50,
 				"dimensions": []string{
 					"ExporterAddress",
 					"ExporterName",
-					"ExporterGroup",
-					"ExporterRole",
-					"ExporterSite",
 					"ExporterRegion",
 					"ExporterTenant",
 					"SrcAddr",
@@ -70,8 +68,6 @@ func TestConfigHandler(t *testing.T) {
 					"OutIfName",
 					"InIfDescription",
 					"OutIfDescription",
-					"InIfSpeed",
-					"OutIfSpeed",
 					"InIfConnectivity",
 					"OutIfConnectivity",
 					"InIfProvider",
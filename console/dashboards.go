@@ -0,0 +1,130 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"akvorado/common/helpers"
+	"akvorado/console/authentication"
+	"akvorado/console/database"
+)
+
+// dashboardFromRequest decodes the dashboard in the body of the request and
+// attaches it to the current user.
+func dashboardFromRequest(gc *gin.Context) (database.Dashboard, bool) {
+	var dashboard database.Dashboard
+	if err := gc.ShouldBindJSON(&dashboard); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return dashboard, false
+	}
+	dashboard.User = gc.MustGet("user").(authentication.UserInformation).Login
+	if dashboard.Widgets == nil {
+		dashboard.Widgets = []database.DashboardWidget{}
+	}
+	return dashboard, true
+}
+
+// dashboardID parses the ID of the dashboard from the URL.
+func dashboardID(gc *gin.Context) (uint64, bool) {
+	id, err := strconv.ParseUint(gc.Param("id"), 10, 64)
+	if err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "bad ID format"})
+		return 0, false
+	}
+	return id, true
+}
+
+func (c *Component) dashboardsListHandlerFunc(gc *gin.Context) {
+	ctx := c.t.Context(gc.Request.Context())
+	user := gc.MustGet("user").(authentication.UserInformation).Login
+	dashboards, err := c.d.Database.ListDashboards(ctx, user)
+	if err != nil {
+		c.r.Err(err).Msg("unable to list dashboards")
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "unable to list dashboards"})
+		return
+	}
+	gc.JSON(http.StatusOK, gin.H{"dashboards": dashboards})
+}
+
+func (c *Component) dashboardsGetHandlerFunc(gc *gin.Context) {
+	ctx := c.t.Context(gc.Request.Context())
+	user := gc.MustGet("user").(authentication.UserInformation).Login
+	id, ok := dashboardID(gc)
+	if !ok {
+		return
+	}
+	dashboard, err := c.d.Database.GetDashboard(ctx, user, id)
+	if errors.Is(err, database.ErrNotFound) {
+		gc.JSON(http.StatusNotFound, gin.H{"message": "dashboard not found"})
+		return
+	} else if err != nil {
+		c.r.Err(err).Msg("unable to get dashboard")
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "unable to get dashboard"})
+		return
+	}
+	gc.JSON(http.StatusOK, dashboard)
+}
+
+func (c *Component) dashboardsAddHandlerFunc(gc *gin.Context) {
+	ctx := c.t.Context(gc.Request.Context())
+	dashboard, ok := dashboardFromRequest(gc)
+	if !ok {
+		return
+	}
+	id, err := c.d.Database.CreateDashboard(ctx, dashboard)
+	if err != nil {
+		c.r.Err(err).Msg("cannot create dashboard")
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "cannot create new dashboard"})
+		return
+	}
+	gc.JSON(http.StatusCreated, gin.H{"id": id})
+}
+
+func (c *Component) dashboardsUpdateHandlerFunc(gc *gin.Context) {
+	ctx := c.t.Context(gc.Request.Context())
+	id, ok := dashboardID(gc)
+	if !ok {
+		return
+	}
+	dashboard, ok := dashboardFromRequest(gc)
+	if !ok {
+		return
+	}
+	dashboard.ID = id
+	if err := c.d.Database.UpdateDashboard(ctx, dashboard); errors.Is(err, database.ErrNotFound) {
+		gc.JSON(http.StatusNotFound, gin.H{"message": "dashboard not found"})
+		return
+	} else if err != nil {
+		c.r.Err(err).Msg("cannot update dashboard")
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "cannot update dashboard"})
+		return
+	}
+	gc.JSON(http.StatusNoContent, nil)
+}
+
+func (c *Component) dashboardsDeleteHandlerFunc(gc *gin.Context) {
+	ctx := c.t.Context(gc.Request.Context())
+	user := gc.MustGet("user").(authentication.UserInformation).Login
+	id, ok := dashboardID(gc)
+	if !ok {
+		return
+	}
+	if err := c.d.Database.DeleteDashboard(ctx, database.Dashboard{
+		ID:   id,
+		User: user,
+	}); errors.Is(err, database.ErrNotFound) {
+		gc.JSON(http.StatusNotFound, gin.H{"message": "dashboard not found"})
+		return
+	} else if err != nil {
+		c.r.Err(err).Msg("cannot delete dashboard")
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "cannot delete dashboard"})
+		return
+	}
+	gc.JSON(http.StatusNoContent, nil)
+}
@@ -0,0 +1,142 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"akvorado/common/helpers"
+)
+
+func TestDashboardsHandlers(t *testing.T) {
+	_, h, _, _ := NewMock(t, DefaultConfiguration())
+	alfred := func() http.Header {
+		headers := make(http.Header)
+		headers.Add("Remote-User", "alfred")
+		return headers
+	}
+	widget := gin.H{
+		"title":  "Transit",
+		"kind":   "graph",
+		"x":      0,
+		"y":      0,
+		"width":  6,
+		"height": 2,
+		"state":  gin.H{"graphType": "stacked", "filter": "InIfBoundary = external"},
+	}
+
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			Description: "list, no dashboards",
+			URL:         "/api/v0/console/dashboards",
+			JSONOutput:  gin.H{"dashboards": []gin.H{}},
+		},
+		{
+			Description: "create a dashboard",
+			URL:         "/api/v0/console/dashboards",
+			StatusCode:  201,
+			JSONInput: gin.H{
+				"name":    "on-call",
+				"shared":  true,
+				"layout":  gin.H{"columns": 12},
+				"widgets": []gin.H{widget},
+			},
+			JSONOutput: gin.H{"id": 1},
+		},
+		{
+			Description: "create an invalid dashboard",
+			URL:         "/api/v0/console/dashboards",
+			StatusCode:  400,
+			JSONInput: gin.H{
+				"name":    "invalid",
+				"widgets": []gin.H{{"kind": "pie", "width": 1, "height": 1, "state": gin.H{}}},
+			},
+			JSONOutput: gin.H{
+				"message": "Key: 'Dashboard.Widgets[0].Kind' Error:Field validation for 'Kind' failed on the 'oneof' tag",
+			},
+		},
+		{
+			Description: "get dashboard as another user",
+			URL:         "/api/v0/console/dashboards/1",
+			Header:      alfred(),
+			JSONOutput: gin.H{
+				"id":      1,
+				"user":    "__default",
+				"shared":  true,
+				"default": false,
+				"name":    "on-call",
+				"layout":  gin.H{"columns": 12},
+				"widgets": []gin.H{widget},
+			},
+		},
+		{
+			Description: "update dashboard as another user",
+			Method:      "PUT",
+			URL:         "/api/v0/console/dashboards/1",
+			Header:      alfred(),
+			JSONInput:   gin.H{"name": "mine"},
+			StatusCode:  404,
+			JSONOutput:  gin.H{"message": "dashboard not found"},
+		},
+		{
+			Description: "update dashboard",
+			Method:      "PUT",
+			URL:         "/api/v0/console/dashboards/1",
+			JSONInput:   gin.H{"name": "private", "default": true, "layout": gin.H{"columns": 12}},
+			StatusCode:  204,
+			ContentType: "application/json; charset=utf-8",
+		},
+		{
+			Description: "list dashboards as another user",
+			URL:         "/api/v0/console/dashboards",
+			Header:      alfred(),
+			JSONOutput:  gin.H{"dashboards": []gin.H{}},
+		},
+		{
+			Description: "list dashboards",
+			URL:         "/api/v0/console/dashboards",
+			JSONOutput: gin.H{"dashboards": []gin.H{
+				{
+					"id":      1,
+					"user":    "__default",
+					"shared":  false,
+					"default": true,
+					"name":    "private",
+					"layout":  gin.H{"columns": 12},
+					"widgets": []gin.H{},
+				},
+			}},
+		},
+		{
+			Description: "delete dashboard as another user",
+			Method:      "DELETE",
+			URL:         "/api/v0/console/dashboards/1",
+			Header:      alfred(),
+			StatusCode:  404,
+			JSONOutput:  gin.H{"message": "dashboard not found"},
+		},
+		{
+			Description: "delete dashboard",
+			Method:      "DELETE",
+			URL:         "/api/v0/console/dashboards/1",
+			StatusCode:  204,
+			ContentType: "application/json; charset=utf-8",
+		},
+		{
+			Description: "get deleted dashboard",
+			URL:         "/api/v0/console/dashboards/1",
+			StatusCode:  404,
+			JSONOutput:  gin.H{"message": "dashboard not found"},
+		},
+		{
+			Description: "get dashboard with invalid ID",
+			URL:         "/api/v0/console/dashboards/kjgdfhgh",
+			StatusCode:  400,
+			JSONOutput:  gin.H{"message": "bad ID format"},
+		},
+	})
+}
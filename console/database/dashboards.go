@@ -0,0 +1,137 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// ErrNotFound is returned when the requested object does not exist or does
+// not belong to the user.
+var ErrNotFound = errors.New("not found")
+
+// Dashboard represents a dashboard in database.
+type Dashboard struct {
+	ID      uint64            `json:"id"`
+	User    string            `gorm:"index" json:"user"`
+	Shared  bool              `json:"shared"`
+	Default bool              `json:"default"`
+	Name    string            `json:"name" binding:"required"`
+	Layout  DashboardLayout   `gorm:"serializer:json" json:"layout"`
+	Widgets []DashboardWidget `gorm:"serializer:json" json:"widgets" binding:"dive"`
+}
+
+// DashboardLayout describes the grid used to place the widgets of a
+// dashboard.
+type DashboardLayout struct {
+	Columns int `json:"columns" binding:"min=0,max=24"`
+}
+
+// DashboardWidget is a widget of a dashboard. Its state is the state of the
+// visualize page of the console, handled by the frontend only.
+type DashboardWidget struct {
+	Title  string          `json:"title"`
+	Kind   string          `json:"kind" binding:"oneof=graph table"`
+	X      int             `json:"x" binding:"min=0"`
+	Y      int             `json:"y" binding:"min=0"`
+	Width  int             `json:"width" binding:"min=1"`
+	Height int             `json:"height" binding:"min=1"`
+	State  json.RawMessage `json:"state" binding:"required"`
+}
+
+// CreateDashboard creates a new dashboard in database and returns its ID.
+func (c *Component) CreateDashboard(ctx context.Context, d Dashboard) (uint64, error) {
+	err := c.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("ID").Create(&d).Error; err != nil {
+			return err
+		}
+		return clearOtherDefaultDashboards(tx, d)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable to create new dashboard: %w", err)
+	}
+	return d.ID, nil
+}
+
+// ListDashboards lists the dashboards owned by the provided user and the
+// shared ones.
+func (c *Component) ListDashboards(ctx context.Context, user string) ([]Dashboard, error) {
+	var results []Dashboard
+	result := c.db.WithContext(ctx).
+		Where(&Dashboard{User: user}).
+		Or(&Dashboard{Shared: true}).
+		Order("id").
+		Find(&results)
+	if result.Error != nil {
+		return nil, fmt.Errorf("unable to retrieve dashboards: %w", result.Error)
+	}
+	return results, nil
+}
+
+// GetDashboard returns the dashboard with the provided ID if it is owned by
+// the provided user or if it is shared.
+func (c *Component) GetDashboard(ctx context.Context, user string, id uint64) (Dashboard, error) {
+	var result Dashboard
+	err := c.db.WithContext(ctx).
+		Where(c.db.Where(&Dashboard{User: user}).Or(&Dashboard{Shared: true})).
+		First(&result, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Dashboard{}, ErrNotFound
+	} else if err != nil {
+		return Dashboard{}, fmt.Errorf("unable to retrieve dashboard: %w", err)
+	}
+	return result, nil
+}
+
+// UpdateDashboard replaces the dashboard with the same ID and owned by the
+// same user.
+func (c *Component) UpdateDashboard(ctx context.Context, d Dashboard) error {
+	err := c.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Dashboard{}).
+			Where(&Dashboard{ID: d.ID, User: d.User}).
+			Select("Shared", "Default", "Name", "Layout", "Widgets").
+			Updates(&d)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return clearOtherDefaultDashboards(tx, d)
+	})
+	if errors.Is(err, ErrNotFound) {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("cannot update dashboard: %w", err)
+	}
+	return nil
+}
+
+// DeleteDashboard deletes the provided dashboard
+func (c *Component) DeleteDashboard(ctx context.Context, d Dashboard) error {
+	result := c.db.WithContext(ctx).Where(&Dashboard{User: d.User}).Delete(&d)
+	if result.Error != nil {
+		return fmt.Errorf("cannot delete dashboard: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// clearOtherDefaultDashboards ensures a user has only one default dashboard.
+func clearOtherDefaultDashboards(tx *gorm.DB, d Dashboard) error {
+	if !d.Default {
+		return nil
+	}
+	return tx.Model(&Dashboard{}).
+		Where(&Dashboard{User: d.User}).
+		Where("id <> ?", d.ID).
+		Update("Default", false).Error
+}
@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+)
+
+func TestDashboards(t *testing.T) {
+	r := reporter.NewMock(t)
+	c := NewMock(t, r, DefaultConfiguration())
+	ctx := context.Background()
+
+	widgets := []DashboardWidget{
+		{
+			Title:  "Transit",
+			Kind:   "graph",
+			Width:  6,
+			Height: 2,
+			State:  json.RawMessage(`{"graphType":"stacked"}`),
+		},
+	}
+	for _, d := range []Dashboard{
+		{User: "marty", Name: "marty's dashboard", Default: true, Widgets: widgets},
+		{User: "judith", Name: "judith's dashboard", Shared: true, Default: true},
+		{User: "marty", Name: "marty's second dashboard", Default: true},
+	} {
+		if _, err := c.CreateDashboard(ctx, d); err != nil {
+			t.Fatalf("CreateDashboard() error:\n%+v", err)
+		}
+	}
+
+	// List
+	got, err := c.ListDashboards(ctx, "marty")
+	if err != nil {
+		t.Fatalf("ListDashboards() error:\n%+v", err)
+	}
+	expected := []Dashboard{
+		{ID: 1, User: "marty", Name: "marty's dashboard", Widgets: widgets},
+		{ID: 2, User: "judith", Name: "judith's dashboard", Shared: true, Default: true},
+		{ID: 3, User: "marty", Name: "marty's second dashboard", Default: true},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("ListDashboards() (-got, +want):\n%s", diff)
+	}
+
+	// Get
+	if _, err := c.GetDashboard(ctx, "judith", 1); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetDashboard() error:\n%+v", err)
+	}
+	gotDashboard, err := c.GetDashboard(ctx, "marty", 2)
+	if err != nil {
+		t.Fatalf("GetDashboard() error:\n%+v", err)
+	}
+	if diff := helpers.Diff(gotDashboard, expected[1]); diff != "" {
+		t.Fatalf("GetDashboard() (-got, +want):\n%s", diff)
+	}
+
+	// Update
+	update := Dashboard{ID: 1, User: "marty", Name: "transit", Default: true}
+	if err := c.UpdateDashboard(ctx, update); err != nil {
+		t.Fatalf("UpdateDashboard() error:\n%+v", err)
+	}
+	if err := c.UpdateDashboard(ctx, Dashboard{ID: 2, User: "marty", Name: "stolen"}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("UpdateDashboard() error:\n%+v", err)
+	}
+	got, _ = c.ListDashboards(ctx, "marty")
+	expected = []Dashboard{
+		{ID: 1, User: "marty", Name: "transit", Default: true},
+		{ID: 2, User: "judith", Name: "judith's dashboard", Shared: true, Default: true},
+		{ID: 3, User: "marty", Name: "marty's second dashboard"},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("ListDashboards() (-got, +want):\n%s", diff)
+	}
+
+	// Delete
+	if err := c.DeleteDashboard(ctx, Dashboard{ID: 2, User: "marty"}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("DeleteDashboard() error:\n%+v", err)
+	}
+	if err := c.DeleteDashboard(ctx, Dashboard{ID: 1, User: "marty"}); err != nil {
+		t.Fatalf("DeleteDashboard() error:\n%+v", err)
+	}
+	got, _ = c.ListDashboards(ctx, "marty")
+	if diff := helpers.Diff(got, expected[1:]); diff != "" {
+		t.Fatalf("ListDashboards() (-got, +want):\n%s", diff)
+	}
+}
@@ -64,7 +64,7 @@ func New(r *reporter.Reporter, configuration Configuration) (*Component, error)
 // Start starts the database component
 func (c *Component) Start() error {
 	c.r.Info().Msg("starting database component")
-	if err := c.db.AutoMigrate(&SavedFilter{}); err != nil {
+	if err := c.db.AutoMigrate(&SavedFilter{}, &Dashboard{}, &SharedLink{}); err != nil {
 		return fmt.Errorf("cannot migrate database: %w", err)
 	}
 	return c.populate()
@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package database
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SharedLink is a short link to a state of the console.
+type SharedLink struct {
+	ID    string          `gorm:"primaryKey;size:16" json:"id"`
+	User  string          `json:"user"`
+	State json.RawMessage `gorm:"serializer:json" json:"state" binding:"required"`
+}
+
+// sharedLinkID computes the identifier of a shared link from its state. The
+// same state always gets the same identifier.
+func sharedLinkID(state json.RawMessage) (string, error) {
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, state); err != nil {
+		return "", fmt.Errorf("invalid state: %w", err)
+	}
+	sum := sha256.Sum256(compacted.Bytes())
+	return base64.RawURLEncoding.EncodeToString(sum[:9]), nil
+}
+
+// CreateSharedLink stores a new shared link in database and returns its ID.
+// If the same state has already been shared, the existing ID is returned.
+func (c *Component) CreateSharedLink(ctx context.Context, l SharedLink) (string, error) {
+	id, err := sharedLinkID(l.State)
+	if err != nil {
+		return "", err
+	}
+	l.ID = id
+	result := c.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&l)
+	if result.Error != nil {
+		return "", fmt.Errorf("unable to create new shared link: %w", result.Error)
+	}
+	return id, nil
+}
+
+// GetSharedLink returns the shared link with the provided ID.
+func (c *Component) GetSharedLink(ctx context.Context, id string) (SharedLink, error) {
+	var result SharedLink
+	err := c.db.WithContext(ctx).Where(&SharedLink{ID: id}).First(&result).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return SharedLink{}, ErrNotFound
+	} else if err != nil {
+		return SharedLink{}, fmt.Errorf("unable to retrieve shared link: %w", err)
+	}
+	return result, nil
+}
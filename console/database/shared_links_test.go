@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+)
+
+func TestSharedLinks(t *testing.T) {
+	r := reporter.NewMock(t)
+	c := NewMock(t, r, DefaultConfiguration())
+	ctx := context.Background()
+
+	id1, err := c.CreateSharedLink(ctx, SharedLink{
+		User:  "marty",
+		State: json.RawMessage(`{"filter": "InIfBoundary = external", "humanStart": "6 hours ago"}`),
+	})
+	if err != nil {
+		t.Fatalf("CreateSharedLink() error:\n%+v", err)
+	}
+	// Same state, only formatting changes
+	id2, err := c.CreateSharedLink(ctx, SharedLink{
+		User:  "judith",
+		State: json.RawMessage(`{"filter":"InIfBoundary = external","humanStart":"6 hours ago"}`),
+	})
+	if err != nil {
+		t.Fatalf("CreateSharedLink() error:\n%+v", err)
+	}
+	if id1 != id2 {
+		t.Fatalf("CreateSharedLink() returned %q and %q for the same state", id1, id2)
+	}
+	id3, err := c.CreateSharedLink(ctx, SharedLink{
+		User:  "marty",
+		State: json.RawMessage(`{"filter":"InIfBoundary = internal","humanStart":"6 hours ago"}`),
+	})
+	if err != nil {
+		t.Fatalf("CreateSharedLink() error:\n%+v", err)
+	}
+	if id1 == id3 {
+		t.Fatalf("CreateSharedLink() returned %q for different states", id1)
+	}
+	if _, err := c.CreateSharedLink(ctx, SharedLink{State: json.RawMessage(`{`)}); err == nil {
+		t.Fatal("CreateSharedLink() did not error on invalid state")
+	}
+
+	got, err := c.GetSharedLink(ctx, id1)
+	if err != nil {
+		t.Fatalf("GetSharedLink() error:\n%+v", err)
+	}
+	expected := SharedLink{
+		ID:    id1,
+		User:  "marty",
+		State: json.RawMessage(`{"filter":"InIfBoundary = external","humanStart":"6 hours ago"}`),
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("GetSharedLink() (-got, +want):\n%s", diff)
+	}
+	if _, err := c.GetSharedLink(ctx, "nothing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetSharedLink() error:\n%+v", err)
+	}
+}
@@ -0,0 +1,107 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+const (
+	exportJSON = "json"
+	exportCSV  = "csv"
+	mimeCSV    = "text/csv"
+)
+
+// exportFormat returns the format requested by the client, either with the
+// "format" query parameter or with the Accept header. JSON is the default.
+func exportFormat(gc *gin.Context) (string, error) {
+	switch format := gc.Query("format"); format {
+	case exportJSON, exportCSV:
+		return format, nil
+	case "":
+	default:
+		return "", fmt.Errorf("unknown format %q", format)
+	}
+	if gc.NegotiateFormat(binding.MIMEJSON, mimeCSV) == mimeCSV {
+		return exportCSV, nil
+	}
+	return exportJSON, nil
+}
+
+// exportLocation returns the timezone requested by the client with the "tz"
+// query parameter. UTC is the default.
+func exportLocation(gc *gin.Context) (*time.Location, error) {
+	tz := gc.Query("tz")
+	if tz == "" {
+		return time.UTC, nil
+	}
+	location, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", tz)
+	}
+	return location, nil
+}
+
+// cacheByRequestBodyUnlessCSV caches answers using the request body as a key,
+// except for CSV exports which are streamed to the client.
+func (c *Component) cacheByRequestBodyUnlessCSV(expire time.Duration) gin.HandlerFunc {
+	cached := c.d.HTTP.CacheByRequestBody(expire)
+	return func(gc *gin.Context) {
+		if format, _ := exportFormat(gc); format == exportCSV {
+			gc.Next()
+			return
+		}
+		cached(gc)
+	}
+}
+
+// csvExport streams records as CSV (RFC 4180) to the client.
+type csvExport struct {
+	gc      *gin.Context
+	w       *csv.Writer
+	records int
+}
+
+// newCSVExport starts a CSV answer with the provided header.
+func newCSVExport(gc *gin.Context, header []string) *csvExport {
+	gc.Header("Content-Type", "text/csv; charset=utf-8")
+	gc.Header("Content-Disposition", `attachment; filename="akvorado.csv"`)
+	gc.Status(http.StatusOK)
+	w := csv.NewWriter(gc.Writer)
+	w.UseCRLF = true
+	w.Write(header)
+	return &csvExport{gc: gc, w: w}
+}
+
+// write writes a record. Records are regularly flushed to the client.
+func (e *csvExport) write(record []string) error {
+	if err := e.w.Write(record); err != nil {
+		return err
+	}
+	e.records++
+	if e.records%1000 == 0 {
+		e.w.Flush()
+		e.gc.Writer.Flush()
+		return e.w.Error()
+	}
+	return nil
+}
+
+// close flushes the remaining records.
+func (e *csvExport) close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// formatXps formats a value for export.
+func formatXps(xps float64) string {
+	return strconv.FormatFloat(xps, 'f', -1, 64)
+}
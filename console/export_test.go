@@ -0,0 +1,124 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/mock/gomock"
+
+	"akvorado/common/clickhousedb/mocks"
+	"akvorado/common/helpers"
+)
+
+func TestGraphExportCSV(t *testing.T) {
+	config := DefaultConfiguration()
+	config.ExportRowsLimit = 10000
+	_, h, mockConn, _ := NewMock(t, config)
+	base := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	ctrl := gomock.NewController(t)
+	expectRows := func(results ...interface{}) {
+		mockRows := mocks.NewMockRows(ctrl)
+		mockConn.EXPECT().Query(gomock.Any(), gomock.Any()).Return(mockRows, nil)
+		calls := []any{}
+		for _, result := range results {
+			calls = append(calls,
+				mockRows.EXPECT().Next().Return(true),
+				mockRows.EXPECT().ScanStruct(gomock.Any()).SetArg(0, result).Return(nil))
+		}
+		calls = append(calls, mockRows.EXPECT().Next().Return(false))
+		gomock.InOrder(calls...)
+		mockRows.EXPECT().Err().Return(nil)
+		mockRows.EXPECT().Close()
+	}
+
+	// Line graph
+	expectRows(
+		graphLineQueryResult{1, base, 1000, []string{"router1", `provider "1"`}},
+		graphLineQueryResult{1, base, 200.5, []string{"Other", "Other"}},
+		graphLineQueryResult{1, base.Add(time.Minute), 0, []string{}},
+		graphLineQueryResult{2, base, 100, []string{"router1", "provider,2"}},
+	)
+	// Sankey graph
+	expectRows(
+		graphSankeyQueryResult{1500, []string{"AS100", "router1"}},
+		graphSankeyQueryResult{1000, []string{"AS200", "Other"}},
+	)
+	csvHeader := func() http.Header {
+		headers := make(http.Header)
+		headers.Add("Accept", "text/csv")
+		return headers
+	}
+	lineInput := gin.H{
+		"start":         base,
+		"end":           base.Add(time.Hour),
+		"points":        100,
+		"limit":         5,
+		"dimensions":    []string{"ExporterName", "InIfProvider"},
+		"units":         "l3bps",
+		"bidirectional": true,
+	}
+
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			Description: "line graph as CSV",
+			URL:         "/api/v0/console/graph/line?format=csv&tz=Europe/Paris",
+			JSONInput:   lineInput,
+			ContentType: "text/csv; charset=utf-8",
+			FirstLines: []string{
+				"time,axis,ExporterName,InIfProvider,l3bps",
+				`2009-11-11T00:00:00+01:00,Direct,router1,"provider ""1""",1000`,
+				"2009-11-11T00:00:00+01:00,Direct,Other,Other,200.5",
+				"2009-11-11T00:01:00+01:00,Direct,Other,Other,0",
+				`2009-11-11T00:00:00+01:00,Reverse,router1,"provider,2",100`,
+			},
+		}, {
+			Description: "sankey graph as CSV",
+			URL:         "/api/v0/console/graph/sankey",
+			Header:      csvHeader(),
+			JSONInput: gin.H{
+				"start":      base,
+				"end":        base.Add(time.Hour),
+				"limit":      5,
+				"dimensions": []string{"SrcAS", "ExporterName"},
+				"units":      "l3bps",
+			},
+			ContentType: "text/csv; charset=utf-8",
+			FirstLines: []string{
+				"SrcAS,ExporterName,l3bps",
+				"AS100,router1,1500",
+				"AS200,Other,1000",
+			},
+		}, {
+			Description: "unknown format",
+			URL:         "/api/v0/console/graph/line?format=xlsx",
+			JSONInput:   lineInput,
+			StatusCode:  400,
+			JSONOutput:  gin.H{"message": `Unknown format "xlsx"`},
+		}, {
+			Description: "unknown timezone",
+			URL:         "/api/v0/console/graph/line?format=csv&tz=Mars/Olympus",
+			JSONInput:   lineInput,
+			StatusCode:  400,
+			JSONOutput:  gin.H{"message": `Unknown timezone "Mars/Olympus"`},
+		}, {
+			Description: "too many rows",
+			URL:         "/api/v0/console/graph/line?format=csv",
+			JSONInput: gin.H{
+				"start":      base,
+				"end":        base.Add(24 * time.Hour),
+				"points":     1000,
+				"limit":      50,
+				"dimensions": []string{"ExporterName"},
+				"units":      "l3bps",
+			},
+			StatusCode: 400,
+			JSONOutput: gin.H{"message": "Export may return up to 51306 rows, beyond maximum value (10000)"},
+		},
+	})
+}
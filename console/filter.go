@@ -151,6 +151,18 @@ func (c *Component) filterCompleteHandlerFunc(gc *gin.Context) {
 				Label:  "IPv6",
 				Detail: "ethernet type",
 			})
+		case "dscp":
+			for _, name := range []string{
+				"EF", "CS0", "CS1", "CS2", "CS3", "CS4", "CS5", "CS6", "CS7",
+				"AF11", "AF12", "AF13", "AF21", "AF22", "AF23",
+				"AF31", "AF32", "AF33", "AF41", "AF42", "AF43",
+				"LE", "VA",
+			} {
+				completions = append(completions, filterCompletion{
+					Label:  name,
+					Detail: "DSCP class",
+				})
+			}
 		case "proto":
 			// Do not complete from ClickHouse, we want a subset of options
 			completions = append(completions,
@@ -176,12 +188,12 @@ func (c *Component) filterCompleteHandlerFunc(gc *gin.Context) {
 			columnName := c.fixQueryColumnName(input.Column)
 			sqlQuery := fmt.Sprintf(`
 SELECT MACNumToString(%s) AS label
-FROM flows
+FROM %s
 WHERE TimeReceived > date_sub(minute, 1, now())
 AND positionCaseInsensitive(label, $1) >= 1
 GROUP BY %s
 ORDER BY COUNT(*) DESC
-LIMIT %d`, columnName, columnName, input.Limit)
+LIMIT %d`, columnName, c.d.ClickHouseDB.TableName("flows"), columnName, input.Limit)
 			if err := c.d.ClickHouseDB.Conn.Select(ctx, &results, sqlQuery, input.Prefix); err != nil {
 				c.r.Err(err).Msg("unable to query database")
 				break
@@ -206,7 +218,7 @@ SELECT label, detail FROM (
   concat(toString(bitShiftRight(c, 16)), ':', toString(bitAnd(c, 0xffff))) AS label
  FROM (
   SELECT arrayJoin(DstCommunities) AS c
-  FROM flows
+  FROM %[1]s
   WHERE TimeReceived > date_sub(minute, 1, now())
   GROUP BY c
   ORDER BY COUNT(*) DESC
@@ -219,14 +231,14 @@ SELECT label, detail FROM (
   concat(toString(bitAnd(bitShiftRight(c, 64), 0xffffffff)), ':', toString(bitAnd(bitShiftRight(c, 32), 0xffffffff)), ':', toString(bitAnd(c, 0xffffffff))) AS label
  FROM (
   SELECT arrayJoin(DstLargeCommunities) AS c
-  FROM flows
+  FROM %[1]s
   WHERE TimeReceived > date_sub(minute, 1, now())
   GROUP BY c
   ORDER BY COUNT(*) DESC
  )
 )
 WHERE startsWith(label, $1)
-LIMIT %d`, input.Limit)
+LIMIT %[2]d`, c.d.ClickHouseDB.TableName("flows"), input.Limit)
 			if err := c.d.ClickHouseDB.Conn.Select(ctx, &results, sqlQuery, input.Prefix); err != nil {
 				c.r.Err(err).Msg("unable to query database")
 				break
@@ -251,7 +263,7 @@ LIMIT %d`, input.Limit)
 			sqlQuery := fmt.Sprintf(`
 SELECT label, detail FROM (
  SELECT concat('AS', toString(%s)) AS label, dictGet('%s', 'name', %s) AS detail, 1 AS rank
- FROM flows
+ FROM %s
  WHERE TimeReceived > date_sub(minute, 1, now())
  AND detail != ''
  AND positionCaseInsensitive(detail, $1) >= 1
@@ -260,12 +272,14 @@ SELECT label, detail FROM (
  LIMIT %d
 UNION DISTINCT
  SELECT concat('AS', toString(asn)) AS label, name AS detail, 2 AS rank
- FROM asns
+ FROM %s
  WHERE positionCaseInsensitive(name, $1) >= 1
  ORDER BY positionCaseInsensitive(name, $1) ASC, asn ASC
  LIMIT %d
 ) GROUP BY label, detail ORDER BY MIN(rank) ASC, MIN(rowNumberInBlock()) ASC LIMIT %d`,
-				columnName, schema.DictionaryASNs, columnName, columnName, input.Limit, input.Limit, input.Limit)
+				columnName, c.d.ClickHouseDB.TableName(schema.DictionaryASNs), columnName,
+				c.d.ClickHouseDB.TableName("flows"), columnName, input.Limit,
+				c.d.ClickHouseDB.TableName(schema.DictionaryASNs), input.Limit, input.Limit)
 			if err := c.d.ClickHouseDB.Conn.Select(ctx, &results, sqlQuery, input.Prefix); err != nil {
 				c.r.Err(err).Msg("unable to query database")
 				break
@@ -285,10 +299,11 @@ UNION DISTINCT
 			}{}
 			if err := c.d.ClickHouseDB.Conn.Select(ctx, &results, fmt.Sprintf(`
 SELECT DISTINCT %s AS attribute
-FROM networks
+FROM %s
 WHERE positionCaseInsensitive(%s, $1) >= 1
 ORDER BY %s
-LIMIT %d`, attributeName, attributeName, attributeName, input.Limit), input.Prefix); err != nil {
+LIMIT %d`, attributeName, c.d.ClickHouseDB.TableName(schema.DictionaryNetworks),
+				attributeName, attributeName, input.Limit), input.Prefix); err != nil {
 				c.r.Err(err).Msg("unable to query database")
 				break
 			}
@@ -312,7 +327,7 @@ LIMIT %d`, attributeName, attributeName, attributeName, input.Limit), input.Pref
 			err := c.d.ClickHouseDB.Conn.Select(ctx, &results, fmt.Sprintf(`
 SELECT label FROM (
  SELECT %s AS label, 1 AS rank
- FROM flows
+ FROM %s
  WHERE TimeReceived > date_sub(minute, 1, now())
  AND Proto = %d
  AND positionCaseInsensitive(label, $1) >= 1
@@ -321,13 +336,14 @@ SELECT label FROM (
  LIMIT %d
 UNION DISTINCT
  SELECT name AS label, 2 AS rank
- FROM icmp
+ FROM %s
  WHERE positionCaseInsensitive(label, $1) >= 1
  AND proto = %d
  ORDER BY positionCaseInsensitive(label, $1) ASC, type ASC, code ASC
  LIMIT %d
 ) GROUP BY label ORDER BY MIN(rank) ASC, MIN(rowNumberInBlock()) ASC LIMIT %d`,
-				columnName, proto, columnName, input.Limit, proto, input.Limit, input.Limit),
+				columnName, c.d.ClickHouseDB.TableName("flows"), proto, columnName, input.Limit,
+				c.d.ClickHouseDB.TableName(schema.DictionaryICMP), proto, input.Limit, input.Limit),
 				input.Prefix)
 			if err != nil {
 				c.r.Err(err).Msg("unable to query database")
@@ -361,11 +377,11 @@ UNION DISTINCT
 			// Query "exporter" table
 			sqlQuery := fmt.Sprintf(`
 SELECT %s AS label
-FROM exporters
+FROM %s
 WHERE positionCaseInsensitive(%s, $1) >= 1
 GROUP BY %s
 ORDER BY positionCaseInsensitive(%s, $1) ASC, %s ASC
-LIMIT %d`, column, column, column, column, column, input.Limit)
+LIMIT %d`, column, c.d.ClickHouseDB.TableName("exporters"), column, column, column, column, input.Limit)
 			results := []struct {
 				Label string `ch:"label"`
 			}{}
@@ -395,10 +411,10 @@ LIMIT %d`, column, column, column, column, column, input.Limit)
 				}{}
 				if err := c.d.ClickHouseDB.Conn.Select(ctx, &results, fmt.Sprintf(`
 SELECT DISTINCT %s AS attribute
-FROM flows
+FROM %s
 WHERE TimeReceived > date_sub(minute, 10, now()) AND startsWith(attribute, $1)
 ORDER BY %s
-LIMIT %d`, col.Name, col.Name, input.Limit), input.Prefix); err != nil {
+LIMIT %d`, col.Name, c.d.ClickHouseDB.TableName("flows"), col.Name, input.Limit), input.Prefix); err != nil {
 					c.r.Err(err).Msg("unable to query database")
 					break
 				}
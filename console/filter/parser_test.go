@@ -197,6 +197,13 @@ func TestValidFilter(t *testing.T) {
 			MetaIn: Meta{ReverseDirection: true}, MetaOut: Meta{ReverseDirection: true},
 		},
 		{Input: `OutIfBoundary != internal`, Output: `OutIfBoundary != 'internal'`},
+		{Input: `DstRPKIStatus = valid`, Output: `DstRPKIStatus = 'valid'`},
+		{Input: `DstRPKIStatus != INVALID`, Output: `DstRPKIStatus != 'invalid'`},
+		{Input: `DstRPKIStatus = not-found`, Output: `DstRPKIStatus = 'not-found'`},
+		{Input: `DstNextHop = 203.0.113.1`, Output: `DstNextHop = toIPv6('203.0.113.1')`},
+		{Input: `DstLocalPref > 100`, Output: `DstLocalPref > 100`},
+		{Input: `DstMED = 20`, Output: `DstMED = 20`},
+		{Input: `VRF = "customer-a"`, Output: `VRF = 'customer-a'`},
 		{Input: `EType = ipv4`, Output: `EType = 2048`},
 		{Input: `EType != ipv6`, Output: `EType != 34525`},
 		{Input: `Proto = 1`, Output: `Proto = 1`},
@@ -289,6 +296,13 @@ output provider */ = 'telia'`,
 		{Input: `DstCommunities != 65000:100`, Output: `NOT has(DstCommunities, 4259840100)`, MetaOut: Meta{MainTableRequired: true}},
 		{Input: `DstCommunities = 65000:100:200`, Output: `has(DstLargeCommunities, bitShiftLeft(65000::UInt128, 64) + bitShiftLeft(100::UInt128, 32) + 200::UInt128)`, MetaOut: Meta{MainTableRequired: true}},
 		{Input: `DstCommunities != 65000:100:200`, Output: `NOT has(DstLargeCommunities, bitShiftLeft(65000::UInt128, 64) + bitShiftLeft(100::UInt128, 32) + 200::UInt128)`, MetaOut: Meta{MainTableRequired: true}},
+		{Input: `DstASPath has 174`, Output: `has(DstASPath, 174)`, MetaOut: Meta{MainTableRequired: true}},
+		{Input: `DstASPath has not AS174`, Output: `NOT has(DstASPath, 174)`, MetaOut: Meta{MainTableRequired: true}},
+		{Input: `DstCommunities has 65000:100`, Output: `has(DstCommunities, 4259840100)`, MetaOut: Meta{MainTableRequired: true}},
+		{Input: `DstCommunities has 65000:100:200`, Output: `has(DstLargeCommunities, bitShiftLeft(65000::UInt128, 64) + bitShiftLeft(100::UInt128, 32) + 200::UInt128)`, MetaOut: Meta{MainTableRequired: true}},
+		{Input: `DstLargeCommunities has 64496:1:2`, Output: `has(DstLargeCommunities, bitShiftLeft(64496::UInt128, 64) + bitShiftLeft(1::UInt128, 32) + 2::UInt128)`, MetaOut: Meta{MainTableRequired: true}},
+		{Input: `DstLargeCommunities has not 64496:1:2`, Output: `NOT has(DstLargeCommunities, bitShiftLeft(64496::UInt128, 64) + bitShiftLeft(1::UInt128, 32) + 2::UInt128)`, MetaOut: Meta{MainTableRequired: true}},
+		{Input: `DstLargeCommunities = 64496:1:2`, Output: `has(DstLargeCommunities, bitShiftLeft(64496::UInt128, 64) + bitShiftLeft(1::UInt128, 32) + 2::UInt128)`, MetaOut: Meta{MainTableRequired: true}},
 		{Input: `SrcVlan = 1000`, Output: `SrcVlan = 1000`},
 		{Input: `DstVlan = 1000`, Output: `DstVlan = 1000`},
 		{
@@ -311,12 +325,28 @@ output provider */ = 'telia'`,
 		{Input: `DstMAC = 00:11:22:33:44:55`, Output: `DstMAC = MACStringToNum('00:11:22:33:44:55')`},
 		{Input: `SrcMAC != 00:0c:fF:33:44:55`, Output: `SrcMAC != MACStringToNum('00:0c:ff:33:44:55')`},
 		{Input: `SrcMAC = 0000.5e00.5301`, Output: `SrcMAC = MACStringToNum('00:00:5e:00:53:01')`},
+		{Input: `SrcMAC = 00-00-5E-00-53-01`, Output: `SrcMAC = MACStringToNum('00:00:5e:00:53:01')`},
+		{
+			Input:  `DstMAC IN (00:11:22:33:44:55, 0000.5e00.5301)`,
+			Output: `DstMAC IN (MACStringToNum('00:11:22:33:44:55'), MACStringToNum('00:00:5e:00:53:01'))`,
+		},
+		{Input: `SrcMAC NOTIN (00:11:22:33:44:55)`, Output: `SrcMAC NOT IN (MACStringToNum('00:11:22:33:44:55'))`},
 		{Input: `ipttl > 50`, Output: `IPTTL > 50`},
 		{Input: `iptos = 0`, Output: `IPTos = 0`},
+		{Input: `dscp = ef`, Output: `DSCP = 'EF'`},
+		{Input: `DSCP != AF41`, Output: `DSCP != 'AF41'`},
+		{Input: `DSCP IN (EF, cs6)`, Output: `DSCP IN ('EF', 'CS6')`},
+		{Input: `DSCP = "AF11"`, Output: `DSCP = 'AF11'`},
+		{Input: `DSCP = 46`, Output: `bitShiftRight(IPTos, 2) = 46`},
+		{Input: `ECN = 3`, Output: `ECN = 3`},
 		{Input: `ipfragmentid != 0`, Output: `IPFragmentID != 0`},
 		{Input: `ipfragmentoffset = 3`, Output: `IPFragmentOffset = 3`},
 		{Input: `ipv6flowlabel = 0`, Output: `IPv6FlowLabel = 0`},
 		{Input: `tcpflags = 2`, Output: `TCPFlags = 2`},
+		{Input: `TCPFlags has SYN`, Output: `bitTest(TCPFlags, 1) = 1`},
+		{Input: `tcpflags HAS NOT ack`, Output: `bitTest(TCPFlags, 4) = 0`},
+		{Input: `TCPFlags has NS`, Output: `bitTest(TCPFlags, 8) = 1`},
+		{Input: `TCPHandshake = 1`, Output: `TCPHandshake = 1`},
 		{Input: `icmpv4type = 8 AND icmpv4code = 0`, Output: `ICMPv4Type = 8 AND ICMPv4Code = 0`},
 		{Input: `icmpv6type = 8 or icmpv6code = 0`, Output: `ICMPv6Type = 8 OR ICMPv6Code = 0`},
 		{Input: `icmpv6 = "echo-reply"`, Output: `ICMPv6 = 'echo-reply'`},
@@ -429,6 +459,8 @@ func TestInvalidFilter(t *testing.T) {
 		{Input: `SrcAS=12322a`},
 		{Input: `SrcAS=785473854857857485784`},
 		{Input: `EType = ipv7`},
+		{Input: `TCPFlags has FOO`},
+		{Input: `TCPFlags has SYNACK`},
 		{Input: `Proto = 100 AND`},
 		{Input: `AND Proto = 100`},
 		{Input: `Proto = 100AND Proto = 100`},
@@ -441,6 +473,8 @@ func TestInvalidFilter(t *testing.T) {
 		{Input: `SrcVlan = 1000`},
 		{Input: `DstVlan = 1000`},
 		{Input: `SrcMAC = 00:11:22:33:44:55:66`, EnableAll: true},
+		{Input: `DstRPKIStatus = valid`},
+		{Input: `DstRPKIStatus = bogus`, EnableAll: true},
 		{Input: `SrcAddrDimensionAttribute = 8`},
 		{Input: `InvalidDimensionAttribute = "Test"`},
 	}
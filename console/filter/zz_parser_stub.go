@@ -0,0 +1,76 @@
+package filter
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"akvorado/common/schema"
+)
+
+type position struct{ line, col, offset int }
+
+type current struct {
+	pos         position
+	text        []byte
+	globalStore map[string]any
+}
+
+type errList []error
+
+func (e errList) Error() string { return "" }
+
+type parserError struct {
+	Inner    error
+	pos      position
+	expected []string
+}
+
+func (p *parserError) Error() string { return "" }
+
+type parser struct{ store map[string]any }
+
+// Option is a parser option.
+type Option func(*parser) Option
+
+// GlobalStore is a stub.
+func GlobalStore(key string, value any) Option {
+	return func(p *parser) Option { p.store[key] = value; return nil }
+}
+
+// Entrypoint is a stub.
+func Entrypoint(ruleName string) Option { return nil }
+
+var stubTokens = regexp.MustCompile(`'(?:[^'\\]|\\.)*'|[A-Za-z][A-Za-z0-9]*|.`)
+
+// Parse is a stub: it passes the expression through, only handling columns.
+func Parse(filename string, b []byte, opts ...Option) (any, error) {
+	p := &parser{store: map[string]any{}}
+	for _, o := range opts {
+		if o != nil {
+			o(p)
+		}
+	}
+	meta, _ := p.store["meta"].(*Meta)
+	if meta == nil || strings.TrimSpace(string(b)) == "" {
+		return nil, errList{&parserError{Inner: errors.New("stub"), pos: position{1, 1, 0}}}
+	}
+	expr := []any{}
+	for _, token := range stubTokens.FindAllString(string(b), -1) {
+		found := false
+		for _, column := range meta.Schema.Columns() {
+			if strings.EqualFold(column.Name, token) {
+				expr = append(expr, column)
+				found = true
+				break
+			}
+		}
+		if !found {
+			expr = append(expr, token)
+		}
+	}
+	c := &current{globalStore: p.store}
+	return strings.Join(c.flattenExpr(expr, meta), ""), nil
+}
+
+var _ = schema.Column{}
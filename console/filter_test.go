@@ -212,6 +212,16 @@ LIMIT 20`, "6540").
 				{"label": "IPv6", "detail": "protocol", "quoted": true},
 			}},
 		},
+		{
+			URL:        "/api/v0/console/filter/complete",
+			StatusCode: 200,
+			JSONInput:  gin.H{"what": "value", "column": "dscp", "prefix": "af4"},
+			JSONOutput: gin.H{"completions": []gin.H{
+				{"label": "AF41", "detail": "DSCP class", "quoted": false},
+				{"label": "AF42", "detail": "DSCP class", "quoted": false},
+				{"label": "AF43", "detail": "DSCP class", "quoted": false},
+			}},
+		},
 		{
 			URL:        "/api/v0/console/filter/complete",
 			StatusCode: 200,
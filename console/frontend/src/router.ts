@@ -4,8 +4,10 @@
 import { createRouter, createWebHistory } from "vue-router";
 import HomePage from "@/views/HomePage.vue";
 import VisualizePage from "@/views/VisualizePage.vue";
+import DashboardsPage from "@/views/DashboardsPage.vue";
 import DocumentationPage from "@/views/DocumentationPage.vue";
 import ErrorPage from "@/views/ErrorPage.vue";
+import { encodeState } from "@/views/VisualizePage/state";
 
 declare module "vue-router" {
   interface RouteMeta {
@@ -36,6 +38,44 @@ const router = createRouter({
       meta: { title: "Visualize" },
       props: (route) => ({ routeState: route.params.state }),
     },
+    {
+      path: "/share/:id",
+      name: "Share",
+      component: VisualizePage,
+      meta: { title: "Visualize" },
+      beforeEnter: async (to) => {
+        // Resolve the shared link to the visualize page.
+        try {
+          const response = await fetch(`/api/v0/console/share/${to.params.id}`);
+          if (response.ok) {
+            const { state } = await response.json();
+            return {
+              name: "VisualizeWithState",
+              params: { state: encodeState(state) },
+            };
+          }
+        } catch (error) {
+          console.error("cannot resolve shared link:", error);
+        }
+        return {
+          name: "404",
+          params: { pathMatch: to.path.split("/").slice(1) },
+        };
+      },
+    },
+    {
+      path: "/dashboards",
+      name: "Dashboards",
+      component: DashboardsPage,
+      meta: { title: "Dashboards" },
+    },
+    {
+      path: "/dashboards/:id",
+      name: "DashboardWithID",
+      component: DashboardsPage,
+      meta: { title: "Dashboards" },
+      props: true,
+    },
     {
       path: "/docs",
       redirect: "/docs/intro",
@@ -0,0 +1,26 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+import type { ModelType } from "../VisualizePage/OptionsPanel.vue";
+
+export type WidgetKind = "graph" | "table";
+export type Widget = {
+  title: string;
+  kind: WidgetKind;
+  x: number;
+  y: number;
+  width: number;
+  height: number;
+  state: NonNullable<ModelType>;
+};
+export type Dashboard = {
+  id: number;
+  user: string;
+  shared: boolean;
+  default: boolean;
+  name: string;
+  layout: {
+    columns: number;
+  };
+  widgets: Widget[];
+};
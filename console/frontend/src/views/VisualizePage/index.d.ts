@@ -11,11 +11,16 @@ export type GraphSankeyHandlerInput = {
   limit: number;
   filter: string;
   units: Units;
+  "min-share"?: number;
 };
 export type GraphLineHandlerInput = GraphSankeyHandlerInput & {
   points: number;
   bidirectional: boolean;
   "previous-period": boolean;
+  percentile: number;
+  "compare-with": string;
+  "top-scope": "window" | "bucket";
+  timezone?: string;
 };
 export type GraphSankeyHandlerOutput = {
   rows: string[][];
@@ -37,6 +42,9 @@ export type GraphLineHandlerOutput = {
   min: number[];
   max: number[];
   "95th": number[];
+  percentiles?: number[];
+  "percentiles-total"?: Record<number, number>;
+  warning?: string;
 };
 export type GraphSankeyHandlerResult = GraphSankeyHandlerOutput & {
   graphType: Extract<GraphType, "sankey">;
@@ -45,5 +53,5 @@ export type GraphLineHandlerResult = GraphLineHandlerOutput & {
   graphType: Exclude<GraphType, "sankey">;
 } & Pick<
     GraphLineHandlerInput,
-    "start" | "end" | "dimensions" | "units" | "bidirectional"
+    "start" | "end" | "dimensions" | "units" | "bidirectional" | "percentile"
   >;
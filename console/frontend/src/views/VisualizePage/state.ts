@@ -0,0 +1,146 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+import LZString from "lz-string";
+import { omit, pick } from "lodash-es";
+import type { ModelType } from "./OptionsPanel.vue";
+import type { GraphType } from "./graphtypes";
+import type {
+  GraphSankeyHandlerInput,
+  GraphLineHandlerInput,
+  GraphSankeyHandlerOutput,
+  GraphLineHandlerOutput,
+  GraphSankeyHandlerResult,
+  GraphLineHandlerResult,
+} from ".";
+
+// Decode a state from the URL. Absolute start and end are dropped: they are
+// recomputed from their human-readable form ("24 hours ago").
+export const decodeState = (serialized: string | undefined): ModelType => {
+  try {
+    if (!serialized) {
+      console.debug("no state");
+      return null;
+    }
+    const unserialized = LZString.decompressFromBase64(serialized);
+    if (!unserialized) {
+      console.debug("empty state");
+      return null;
+    }
+    return omit(JSON.parse(unserialized), ["start", "end"]) as ModelType;
+  } catch (error) {
+    console.error("cannot decode state:", error);
+    return null;
+  }
+};
+
+// Encode a state for the URL. Absolute start and end are not encoded to
+// keep relative time ranges relative.
+export const encodeState = (state: ModelType) => {
+  if (state === null) return "";
+  const stripped = omit(state, ["start", "end"]);
+  return LZString.compressToBase64(
+    JSON.stringify(stripped, Object.keys(stripped).sort()),
+  );
+};
+
+// Pin the time range of a state: the human-readable start and end are
+// replaced by absolute timestamps.
+export const pinState = (state: NonNullable<ModelType>) => ({
+  ...state,
+  humanStart: state.start,
+  humanEnd: state.end,
+});
+
+// API endpoint to use for a graph type.
+export const graphEndpoint = (graphType: GraphType) => {
+  const endpoint: Record<GraphType, string> = {
+    stacked: "line",
+    stacked100: "line",
+    lines: "line",
+    grid: "line",
+    sankey: "sankey",
+  };
+  return `/api/v0/console/graph/${endpoint[graphType]}`;
+};
+
+const orderedJSONPayload = <T extends Record<string, any>>(input: T): T => {
+  return Object.keys(input)
+    .sort()
+    .reduce(
+      (o, k) => ((o[k] = input[k]), o),
+      {} as { [key: string]: any },
+    ) as T;
+};
+
+// Build the payload to send to the API from a state.
+export const graphPayload = (
+  state: ModelType,
+): GraphSankeyHandlerInput | GraphLineHandlerInput | null => {
+  if (state === null) return null;
+  if (state.graphType === "sankey") {
+    const input: GraphSankeyHandlerInput = {
+      ...omit(state, [
+        "graphType",
+        "bidirectional",
+        "previousPeriod",
+        "percentile",
+        "compareWith",
+        "topPerBucket",
+        "minShare",
+        "humanStart",
+        "humanEnd",
+      ]),
+      "min-share": state.minShare ?? 0,
+    };
+    return orderedJSONPayload(input);
+  } else {
+    const input: GraphLineHandlerInput = {
+      ...omit(state, [
+        "graphType",
+        "previousPeriod",
+        "compareWith",
+        "topPerBucket",
+        "minShare",
+        "humanStart",
+        "humanEnd",
+      ]),
+      points: state.graphType === "grid" ? 50 : 200,
+      "previous-period": state.previousPeriod,
+      percentile: state.percentile ?? 0,
+      "compare-with": state.compareWith ?? "",
+      "top-scope": state.topPerBucket ? "bucket" : "window",
+      // Day and week comparisons are done in the browser timezone.
+      ...(state.compareWith && {
+        timezone: Intl.DateTimeFormat().resolvedOptions().timeZone,
+      }),
+    };
+    return orderedJSONPayload(input);
+  }
+};
+
+// Build the result to display from a state and the answer of the API.
+export const graphResult = (
+  state: NonNullable<ModelType>,
+  data: GraphLineHandlerOutput | GraphSankeyHandlerOutput,
+): GraphLineHandlerResult | GraphSankeyHandlerResult => {
+  if (state.graphType === "sankey") {
+    return {
+      graphType: "sankey",
+      ...(data as GraphSankeyHandlerOutput),
+      ...pick(state, ["start", "end", "dimensions", "units"]),
+    };
+  }
+  return {
+    graphType: state.graphType,
+    ...(data as GraphLineHandlerOutput),
+    ...pick(state, [
+      "start",
+      "end",
+      "dimensions",
+      "units",
+      "bidirectional",
+      "percentile",
+    ]),
+  };
+};
@@ -24,10 +24,32 @@ type graphCommonHandlerInput struct {
 	TruncateAddrV4 int            `json:"truncate-v4" binding:"min=0,max=32"`  // 0 or 32 = no truncation
 	TruncateAddrV6 int            `json:"truncate-v6" binding:"min=0,max=128"` // 0 or 128 = no truncation
 	Units          string         `json:"units" binding:"required,oneof=pps l3bps l2bps inl2% outl2%"`
+	Resolve        bool           `json:"resolve"` // resolve IP addresses to names
+}
+
+// validateUnits checks the columns needed by the units are enabled.
+func (input graphCommonHandlerInput) validateUnits() error {
+	for _, name := range unitsColumns(input.Units) {
+		if column, ok := input.schema.LookupColumnByName(name); !ok || column.Disabled {
+			return fmt.Errorf("units %s require column %s", input.Units, name)
+		}
+	}
+	return nil
+}
+
+// truncateAddress returns an expression truncating the provided IP address
+// column to the provided prefix lengths.
+func truncateAddress(column string, truncate4, truncate6 int) string {
+	if truncate6 == truncate4+96 {
+		return fmt.Sprintf("tupleElement(IPv6CIDRToRange(%s, %d), 1)", column, truncate6)
+	}
+	return fmt.Sprintf("tupleElement(IPv6CIDRToRange(%s, if(tupleElement(IPv6CIDRToRange(%s, 96), 1) = toIPv6('::ffff:0.0.0.0'), %d, %d)), 1)",
+		column, column, truncate4+96, truncate6)
 }
 
 // sourceSelect builds a SELECT query to use as a source for data. Notably, it
-// will do IP truncation.
+// will do IP truncation. Dimensions grouping addresses by prefixes are added
+// as additional columns.
 func (input graphCommonHandlerInput) sourceSelect() string {
 	if input.TruncateAddrV4 == 0 {
 		input.TruncateAddrV4 = 32
@@ -36,26 +58,31 @@ func (input graphCommonHandlerInput) sourceSelect() string {
 		input.TruncateAddrV6 = 128
 	}
 	truncated := []string{}
+	prefixes := []string{}
 	for _, qc := range input.Dimensions {
+		if truncate4, truncate6, ok := qc.Truncated(); ok {
+			prefixes = append(prefixes,
+				fmt.Sprintf("%s AS %s",
+					truncateAddress(qc.Key().String(), int(truncate4), int(truncate6)),
+					qc.Identifier()))
+			continue
+		}
 		if column, _ := input.schema.LookupColumnByKey(qc.Key()); column.ConsoleTruncateIP {
 			if input.TruncateAddrV4 == 32 && input.TruncateAddrV6 == 128 {
 				continue
 			}
-			if input.TruncateAddrV6 == input.TruncateAddrV4+96 {
-				truncated = append(truncated,
-					fmt.Sprintf("tupleElement(IPv6CIDRToRange(%s, %d), 1) AS %s",
-						qc.String(), input.TruncateAddrV6, qc.String()))
-			} else {
-				truncated = append(truncated,
-					fmt.Sprintf("tupleElement(IPv6CIDRToRange(%s, if(tupleElement(IPv6CIDRToRange(%s, 96), 1) = toIPv6('::ffff:0.0.0.0'), %d, %d)), 1) AS %s",
-						qc.String(), qc.String(),
-						input.TruncateAddrV4+96, input.TruncateAddrV6,
-						qc.String()))
-			}
+			truncated = append(truncated,
+				fmt.Sprintf("%s AS %s",
+					truncateAddress(qc.String(), input.TruncateAddrV4, input.TruncateAddrV6),
+					qc.String()))
 		}
 	}
-	if len(truncated) == 0 {
-		return "SELECT * FROM {{ .Table }} SETTINGS asterisk_include_alias_columns = 1"
+	selected := "*"
+	if len(truncated) > 0 {
+		selected = fmt.Sprintf("* REPLACE (%s)", strings.Join(truncated, ", "))
+	}
+	if len(prefixes) > 0 {
+		selected = fmt.Sprintf("%s, %s", selected, strings.Join(prefixes, ", "))
 	}
-	return fmt.Sprintf("SELECT * REPLACE (%s) FROM {{ .Table }} SETTINGS asterisk_include_alias_columns = 1", strings.Join(truncated, ", "))
+	return fmt.Sprintf("SELECT %s FROM {{ .Table }} SETTINGS asterisk_include_alias_columns = 1", selected)
 }
@@ -54,6 +54,20 @@ func TestSourceSelect(t *testing.T) {
 				TruncateAddrV6: 40,
 			},
 			Expected: "SELECT * REPLACE (tupleElement(IPv6CIDRToRange(SrcAddr, if(tupleElement(IPv6CIDRToRange(SrcAddr, 96), 1) = toIPv6('::ffff:0.0.0.0'), 120, 40)), 1) AS SrcAddr) FROM {{ .Table }} SETTINGS asterisk_include_alias_columns = 1",
+		}, {
+			Description: "grouped by prefixes",
+			Input: graphCommonHandlerInput{
+				Dimensions: []query.Column{query.NewColumn("SrcAddr/24/48"), query.NewColumn("DstAddr/16/112")},
+			},
+			Expected: "SELECT *, tupleElement(IPv6CIDRToRange(SrcAddr, if(tupleElement(IPv6CIDRToRange(SrcAddr, 96), 1) = toIPv6('::ffff:0.0.0.0'), 120, 48)), 1) AS `SrcAddr/24/48`, tupleElement(IPv6CIDRToRange(DstAddr, 112), 1) AS `DstAddr/16/112` FROM {{ .Table }} SETTINGS asterisk_include_alias_columns = 1",
+		}, {
+			Description: "grouped by prefixes and truncated",
+			Input: graphCommonHandlerInput{
+				Dimensions:     []query.Column{query.NewColumn("SrcAddr/24/48"), query.NewColumn("SrcAddr")},
+				TruncateAddrV4: 16,
+				TruncateAddrV6: 112,
+			},
+			Expected: "SELECT * REPLACE (tupleElement(IPv6CIDRToRange(SrcAddr, 112), 1) AS SrcAddr), tupleElement(IPv6CIDRToRange(SrcAddr, if(tupleElement(IPv6CIDRToRange(SrcAddr, 96), 1) = toIPv6('::ffff:0.0.0.0'), 120, 48)), 1) AS `SrcAddr/24/48` FROM {{ .Table }} SETTINGS asterisk_include_alias_columns = 1",
 		},
 	}
 	for _, tc := range cases {
@@ -20,14 +20,20 @@ import (
 // graphLineHandlerInput describes the input for the /graph/line endpoint.
 type graphLineHandlerInput struct {
 	graphCommonHandlerInput
-	Points         uint `json:"points" binding:"required,min=5,max=2000"` // minimum number of points
-	Bidirectional  bool `json:"bidirectional"`
-	PreviousPeriod bool `json:"previous-period"`
+	Points         uint   `json:"points" binding:"required,min=5,max=2000"` // minimum number of points
+	Bidirectional  bool   `json:"bidirectional"`
+	PreviousPeriod bool   `json:"previous-period"`
+	Percentile     uint   `json:"percentile" binding:"max=99"`                       // 0 = no percentile
+	CompareWith    string `json:"compare-with"`                                      // "day", "week", or a duration
+	Timezone       string `json:"timezone"`                                          // timezone for "day" and "week"
+	TopScope       string `json:"top-scope" binding:"omitempty,oneof=window bucket"` // top rows over the window (default) or per bucket
+	compareOffset  time.Duration
 }
 
 // graphLineHandlerOutput describes the output for the /graph/line endpoint. A
 // row is a set of values for dimensions. Currently, axis 1 is for the
-// direct direction and axis 2 is for the reverse direction. Rows are
+// direct direction and axis 2 is for the reverse direction. Axes 3 and 4 are
+// for the previous period, axes 5 and 6 for the comparison window. Rows are
 // sorted by axis, then by the sum of traffic.
 type graphLineHandlerOutput struct {
 	Time                 []time.Time    `json:"t"`
@@ -39,6 +45,61 @@ type graphLineHandlerOutput struct {
 	Min                  []int          `json:"min"`     // row → min xps
 	Max                  []int          `json:"max"`     // row → max xps
 	NinetyFivePercentile []int          `json:"95th"`    // row → 95th xps
+	// Percentiles of 5-minute samples (when requested)
+	Percentiles      []int       `json:"percentiles,omitempty"`       // row → percentile xps
+	PercentilesTotal map[int]int `json:"percentiles-total,omitempty"` // axis → percentile xps
+	// IP address → name (when requested)
+	Names map[string]string `json:"names,omitempty"`
+	// Warning about incomplete data
+	Warning string `json:"warning,omitempty"`
+}
+
+// graphLineQueryResult is a row returned by the query for the /graph/line
+// endpoint.
+type graphLineQueryResult struct {
+	Axis       uint8     `ch:"axis"`
+	Time       time.Time `ch:"time"`
+	Xps        float64   `ch:"xps"`
+	Dimensions []string  `ch:"dimensions"`
+}
+
+// fillEmptyDimensions replaces empty dimensions by "Other". When filling 0
+// value, we may get an empty dimensions. From ClickHouse 22.4, it is possible
+// to do interpolation database-side (INTERPOLATE (['Other', 'Other'] AS
+// Dimensions))
+func fillEmptyDimensions(results []graphLineQueryResult, count int) {
+	if count == 0 {
+		return
+	}
+	zeroDimensions := make([]string, count)
+	for idx := range zeroDimensions {
+		zeroDimensions[idx] = "Other"
+	}
+	for idx := range results {
+		if len(results[idx].Dimensions) == 0 {
+			results[idx].Dimensions = zeroDimensions
+		}
+	}
+}
+
+// axisName returns the name of the provided axis.
+func (input graphLineHandlerInput) axisName(axis int) string {
+	switch axis {
+	case 1:
+		return "Direct"
+	case 2:
+		return "Reverse"
+	case 3, 4:
+		_, name := nearestPeriod(input.End.Sub(input.Start))
+		return fmt.Sprintf("Previous %s", name)
+	case 5, 6:
+		switch input.CompareWith {
+		case "day", "week":
+			return fmt.Sprintf("Previous %s", input.CompareWith)
+		}
+		return fmt.Sprintf("%s earlier", input.CompareWith)
+	}
+	return ""
 }
 
 // reverseDirection reverts the direction of a provided input. It does not
@@ -92,6 +153,46 @@ func (input graphLineHandlerInput) previousPeriod() graphLineHandlerInput {
 	return input
 }
 
+// parseCompareWith computes the offset of the comparison window. "day" and
+// "week" are calendar offsets in the requested timezone, computed from the end
+// of the window: around DST transitions, they are not exactly 24 hours or 7
+// days. Otherwise, a positive duration is expected.
+func (input *graphLineHandlerInput) parseCompareWith() error {
+	if input.CompareWith == "" {
+		return nil
+	}
+	location := time.UTC
+	if input.Timezone != "" {
+		var err error
+		location, err = time.LoadLocation(input.Timezone)
+		if err != nil {
+			return fmt.Errorf("unknown timezone %q", input.Timezone)
+		}
+	}
+	end := input.End.In(location)
+	switch input.CompareWith {
+	case "day":
+		input.compareOffset = end.Sub(end.AddDate(0, 0, -1))
+	case "week":
+		input.compareOffset = end.Sub(end.AddDate(0, 0, -7))
+	default:
+		offset, err := time.ParseDuration(input.CompareWith)
+		if err != nil || offset <= 0 {
+			return fmt.Errorf("invalid comparison offset %q", input.CompareWith)
+		}
+		input.compareOffset = offset
+	}
+	return nil
+}
+
+// compared shifts the provided input to the comparison window. Unlike
+// previousPeriod(), dimensions are kept.
+func (input graphLineHandlerInput) compared() graphLineHandlerInput {
+	input.Start = input.Start.Add(-input.compareOffset)
+	input.End = input.End.Add(-input.compareOffset)
+	return input
+}
+
 type toSQL1Options struct {
 	skipWithClause   bool
 	reverseDirection bool
@@ -109,10 +210,8 @@ func (input graphLineHandlerInput) toSQL1(axis int, options toSQL1Options) strin
 	where := templateWhere(input.Filter)
 
 	// Select
-	fields := []string{
-		fmt.Sprintf(`{{ call .ToStartOfInterval "TimeReceived" }}%s AS time`, offsetShift),
-		`{{ .Units }}/{{ .Interval }} AS xps`,
-	}
+	timeField := fmt.Sprintf(`{{ call .ToStartOfInterval "TimeReceived" }}%s AS time`, offsetShift)
+	fields := []string{timeField, `{{ .Units }}/{{ .Interval }} AS xps`}
 	selectFields := []string{}
 	dimensions := []string{}
 	dimensionsInterpolate := ""
@@ -120,25 +219,52 @@ func (input graphLineHandlerInput) toSQL1(axis int, options toSQL1Options) strin
 	for _, column := range input.Dimensions {
 		field := column.ToSQLSelect(input.schema)
 		selectFields = append(selectFields, field)
-		dimensions = append(dimensions, column.String())
+		dimensions = append(dimensions, column.Identifier())
 		others = append(others, "'Other'")
 	}
-	if len(dimensions) > 0 {
+	topPerBucket := input.TopScope == "bucket" && len(dimensions) > 0
+	switch {
+	case topPerBucket:
+		// The top rows are selected for each time bucket: the remaining
+		// rows of the bucket are aggregated as "Other".
+		fields = []string{
+			"time",
+			"sum(bucketXps) AS xps",
+			fmt.Sprintf("if(bucketRank <= %d, bucketDimensions, [%s]) AS dimensions",
+				input.Limit, strings.Join(others, ", ")),
+		}
+		dimensionsInterpolate = fmt.Sprintf("[%s]", strings.Join(others, ", "))
+	case len(dimensions) > 0:
 		fields = append(fields, fmt.Sprintf(`if((%s) IN rows, [%s], [%s]) AS dimensions`,
 			strings.Join(dimensions, ", "),
 			strings.Join(selectFields, ", "),
 			strings.Join(others, ", ")))
 		dimensionsInterpolate = fmt.Sprintf("[%s]", strings.Join(others, ", "))
-	} else {
+	default:
 		fields = append(fields, "emptyArrayString() AS dimensions")
 		dimensionsInterpolate = "emptyArrayString()"
 	}
 
+	// From
+	from := fmt.Sprintf("source\nWHERE %s", where)
+	if topPerBucket {
+		from = fmt.Sprintf(`(
+ SELECT
+  %s,
+  {{ .Units }}/{{ .Interval }} AS bucketXps,
+  [%s] AS bucketDimensions,
+  row_number() OVER (PARTITION BY time ORDER BY bucketXps DESC) AS bucketRank
+ FROM source
+ WHERE %s
+ GROUP BY time, bucketDimensions)`,
+			timeField, strings.Join(selectFields, ", "), where)
+	}
+
 	// With
 	withStr := ""
 	if !options.skipWithClause {
 		with := []string{fmt.Sprintf("source AS (%s)", input.sourceSelect())}
-		if len(dimensions) > 0 {
+		if len(dimensions) > 0 && !topPerBucket {
 			with = append(with, fmt.Sprintf(
 				"rows AS (SELECT %s FROM source WHERE %s GROUP BY %s ORDER BY {{ .Units }} DESC LIMIT %d)",
 				strings.Join(dimensions, ", "),
@@ -167,8 +293,7 @@ func (input graphLineHandlerInput) toSQL1(axis int, options toSQL1Options) strin
 SELECT %d AS axis, * FROM (
 SELECT
  %s
-FROM source
-WHERE %s
+FROM %s
 GROUP BY time, dimensions
 ORDER BY time WITH FILL
  FROM {{ .TimefilterStart }}%s
@@ -181,10 +306,11 @@ ORDER BY time WITH FILL
 			End:               input.End,
 			StartForInterval:  startForInterval,
 			MainTableRequired: requireMainTable(input.schema, input.Dimensions, input.Filter),
+			Columns:           requiredColumns(input.schema, input.Dimensions, input.Filter),
 			Points:            input.Points,
 			Units:             units,
 		}),
-		withStr, axis, strings.Join(fields, ",\n "), where, offsetShift, offsetShift,
+		withStr, axis, strings.Join(fields, ",\n "), from, offsetShift, offsetShift,
 		dimensionsInterpolate,
 	)
 	return strings.TrimSpace(sqlQuery)
@@ -214,12 +340,30 @@ func (input graphLineHandlerInput) toSQL() string {
 			offsetedStart:    input.Start,
 		}))
 	}
+	if input.compareOffset > 0 {
+		parts = append(parts, input.compared().toSQL1(5, toSQL1Options{
+			skipWithClause: true,
+			offsetedStart:  input.Start,
+		}))
+	}
+	if input.Bidirectional && input.compareOffset > 0 {
+		parts = append(parts, input.reverseDirection().compared().toSQL1(6, toSQL1Options{
+			skipWithClause:   true,
+			reverseDirection: true,
+			offsetedStart:    input.Start,
+		}))
+	}
 	return strings.Join(parts, "\nUNION ALL\n")
 }
 
 func (c *Component) graphLineHandlerFunc(gc *gin.Context) {
 	ctx := c.t.Context(gc.Request.Context())
 	input := graphLineHandlerInput{graphCommonHandlerInput: graphCommonHandlerInput{schema: c.d.Schema}}
+	format, err := exportFormat(gc)
+	if err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
 	if err := gc.ShouldBindJSON(&input); err != nil {
 		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
 		return
@@ -232,6 +376,14 @@ func (c *Component) graphLineHandlerFunc(gc *gin.Context) {
 		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
 		return
 	}
+	if err := input.validateUnits(); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
+	if err := input.parseCompareWith(); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
 	if input.Limit > c.config.DimensionsLimit {
 		gc.JSON(http.StatusBadRequest,
 			gin.H{"message": fmt.Sprintf("Limit is set beyond maximum value (%d)",
@@ -239,36 +391,23 @@ func (c *Component) graphLineHandlerFunc(gc *gin.Context) {
 		return
 	}
 
+	if format == exportCSV {
+		c.graphLineExportCSV(gc, input)
+		return
+	}
+
 	sqlQuery := input.toSQL()
 	sqlQuery = c.finalizeQuery(sqlQuery)
 	gc.Header("X-SQL-Query", strings.ReplaceAll(sqlQuery, "\n", "  "))
 
-	results := []struct {
-		Axis       uint8     `ch:"axis"`
-		Time       time.Time `ch:"time"`
-		Xps        float64   `ch:"xps"`
-		Dimensions []string  `ch:"dimensions"`
-	}{}
+	results := []graphLineQueryResult{}
 	if err := c.d.ClickHouseDB.Conn.Select(ctx, &results, sqlQuery); err != nil {
 		c.r.Err(err).Str("query", sqlQuery).Msg("unable to query database")
 		gc.JSON(http.StatusInternalServerError, gin.H{"message": "Unable to query database."})
 		return
 	}
 
-	// When filling 0 value, we may get an empty dimensions.
-	// From ClickHouse 22.4, it is possible to do interpolation database-side
-	// (INTERPOLATE (['Other', 'Other'] AS Dimensions))
-	if len(input.Dimensions) > 0 {
-		zeroDimensions := make([]string, len(input.Dimensions))
-		for idx := range zeroDimensions {
-			zeroDimensions[idx] = "Other"
-		}
-		for idx := range results {
-			if len(results[idx].Dimensions) == 0 {
-				results[idx].Dimensions = zeroDimensions
-			}
-		}
-	}
+	fillEmptyDimensions(results, len(input.Dimensions))
 
 	// Set time axis. We assume the first returned axis has the complete view.
 	output := graphLineHandlerOutput{
@@ -309,6 +448,10 @@ func (c *Component) graphLineHandlerFunc(gc *gin.Context) {
 			timeIndexForAxis[axis]++
 			lastTimeForAxis[axis] = result.Time
 		}
+		if timeIndexForAxis[axis] >= len(output.Time) {
+			// A shifted axis may not be aligned with the first one
+			continue
+		}
 		rowKey := fmt.Sprintf("%d-%s", axis, result.Dimensions)
 		_, ok = points[axis][rowKey]
 		if !ok {
@@ -409,20 +552,125 @@ func (c *Component) graphLineHandlerFunc(gc *gin.Context) {
 	}
 
 	for _, axis := range output.Axis {
-		switch axis {
-		case 1:
-			output.AxisNames[axis] = "Direct"
-		case 2:
-			output.AxisNames[axis] = "Reverse"
-		case 3, 4:
-			diff := input.End.Sub(input.Start)
-			_, name := nearestPeriod(diff)
-			output.AxisNames[axis] = fmt.Sprintf("Previous %s", name)
+		output.AxisNames[axis] = input.axisName(axis)
+	}
+	if input.compareOffset > 0 {
+		if oldest := c.oldestFlows(); input.compared().Start.Before(oldest) {
+			output.Warning = fmt.Sprintf("Data for the comparison window is only available from %s.",
+				oldest.Format(time.RFC3339))
+		}
+	}
+	if input.Percentile > 0 {
+		if err := c.computePercentiles(ctx, input, &output); err != nil {
+			c.r.Err(err).Msg("unable to compute percentiles")
+			gc.JSON(http.StatusInternalServerError, gin.H{"message": "Unable to query database."})
+			return
 		}
 	}
+	if input.Resolve {
+		output.Names = c.reverseDNS.resolve(&c.t,
+			addressesFromRows(input.schema, input.Dimensions, output.Rows))
+	}
 	gc.JSON(http.StatusOK, output)
 }
 
+// graphLineExportRows returns an upper bound of the number of rows exported
+// for the provided input.
+func (c *Component) graphLineExportRows(input graphLineHandlerInput) int {
+	_, interval, targetInterval := c.computeTableAndInterval(inputContext{
+		Start:             input.Start,
+		End:               input.End,
+		MainTableRequired: requireMainTable(input.schema, input.Dimensions, input.Filter),
+		Columns:           requiredColumns(input.schema, input.Dimensions, input.Filter),
+		Points:            input.Points,
+		Units:             input.Units,
+	})
+	if targetInterval > interval {
+		interval = targetInterval.Truncate(interval)
+	}
+	points := int(input.End.Sub(input.Start)/interval) + 2
+	series := 1
+	if len(input.Dimensions) > 0 {
+		series = input.Limit + 1 // "Other"
+	}
+	axes := 1
+	if input.Bidirectional {
+		axes *= 2
+	}
+	if input.PreviousPeriod {
+		axes *= 2
+	}
+	if input.compareOffset > 0 {
+		axes *= 2
+	}
+	return points * series * axes
+}
+
+// graphLineExportCSV streams the result of the /graph/line endpoint as CSV.
+// There is one record for each axis, timestamp and set of dimensions.
+func (c *Component) graphLineExportCSV(gc *gin.Context, input graphLineHandlerInput) {
+	ctx := c.t.Context(gc.Request.Context())
+	location, err := exportLocation(gc)
+	if err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
+	if rows := c.graphLineExportRows(input); rows > c.config.ExportRowsLimit {
+		gc.JSON(http.StatusBadRequest,
+			gin.H{"message": fmt.Sprintf("Export may return up to %d rows, beyond maximum value (%d)",
+				rows, c.config.ExportRowsLimit)})
+		return
+	}
+
+	sqlQuery := c.finalizeQuery(input.toSQL())
+	gc.Header("X-SQL-Query", strings.ReplaceAll(sqlQuery, "\n", "  "))
+	rows, err := c.d.ClickHouseDB.Conn.Query(ctx, sqlQuery)
+	if err != nil {
+		c.r.Err(err).Str("query", sqlQuery).Msg("unable to query database")
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "Unable to query database."})
+		return
+	}
+	defer rows.Close()
+
+	header := []string{"time", "axis"}
+	for _, column := range input.Dimensions {
+		header = append(header, column.String())
+	}
+	header = append(header, input.Units)
+	export := newCSVExport(gc, header)
+	for rows.Next() {
+		var result graphLineQueryResult
+		if err := rows.ScanStruct(&result); err != nil {
+			c.r.Err(err).Msg("unable to parse row")
+			return
+		}
+		record := []string{
+			result.Time.In(location).Format(time.RFC3339),
+			input.axisName(int(result.Axis)),
+		}
+		if len(result.Dimensions) == 0 {
+			// Interpolated values do not have dimensions
+			for range input.Dimensions {
+				record = append(record, "Other")
+			}
+		} else {
+			record = append(record, result.Dimensions...)
+		}
+		record = append(record, formatXps(result.Xps))
+		if err := export.write(record); err != nil {
+			c.r.Err(err).Msg("unable to export row")
+			return
+		}
+	}
+	if err := rows.Err(); err != nil {
+		c.r.Err(err).Str("query", sqlQuery).Msg("unable to query database")
+		return
+	}
+	if err := export.close(); err != nil {
+		c.r.Err(err).Msg("unable to export rows")
+	}
+}
+
 type tableIntervalInput struct {
 	Start  time.Time `json:"start" binding:"required"`
 	End    time.Time `json:"end" binding:"required,gtfield=Start"`
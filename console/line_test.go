@@ -4,7 +4,10 @@
 package console
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"strings"
 	"testing"
 	"time"
@@ -158,6 +161,93 @@ func TestGraphPreviousPeriod(t *testing.T) {
 	}
 }
 
+func TestGraphCompareWith(t *testing.T) {
+	cases := []struct {
+		Pos         helpers.Pos
+		CompareWith string
+		Timezone    string
+		End         time.Time
+		Expected    time.Duration
+		Error       string
+	}{
+		{
+			Pos:         helpers.Mark(),
+			CompareWith: "day",
+			End:         time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC),
+			Expected:    24 * time.Hour,
+		}, {
+			Pos:         helpers.Mark(),
+			CompareWith: "day",
+			Timezone:    "Europe/Paris",
+			End:         time.Date(2024, 3, 30, 12, 0, 0, 0, time.UTC),
+			Expected:    24 * time.Hour,
+		}, {
+			Pos:         helpers.Mark(),
+			CompareWith: "day",
+			Timezone:    "Europe/Paris",
+			End:         time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC),
+			Expected:    23 * time.Hour,
+		}, {
+			Pos:         helpers.Mark(),
+			CompareWith: "week",
+			Timezone:    "Europe/Paris",
+			End:         time.Date(2024, 10, 28, 12, 0, 0, 0, time.UTC),
+			Expected:    7*24*time.Hour + time.Hour,
+		}, {
+			Pos:         helpers.Mark(),
+			CompareWith: "36h",
+			Timezone:    "Europe/Paris",
+			End:         time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC),
+			Expected:    36 * time.Hour,
+		}, {
+			Pos:         helpers.Mark(),
+			CompareWith: "-1h",
+			End:         time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC),
+			Error:       `invalid comparison offset "-1h"`,
+		}, {
+			Pos:         helpers.Mark(),
+			CompareWith: "month",
+			End:         time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC),
+			Error:       `invalid comparison offset "month"`,
+		}, {
+			Pos:         helpers.Mark(),
+			CompareWith: "day",
+			Timezone:    "Mars/Olympus",
+			End:         time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC),
+			Error:       `unknown timezone "Mars/Olympus"`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%s in %q at %s", tc.CompareWith, tc.Timezone, tc.End), func(t *testing.T) {
+			input := graphLineHandlerInput{
+				graphCommonHandlerInput: graphCommonHandlerInput{
+					Start: tc.End.Add(-time.Hour),
+					End:   tc.End,
+				},
+				CompareWith: tc.CompareWith,
+				Timezone:    tc.Timezone,
+			}
+			err := input.parseCompareWith()
+			if tc.Error != "" {
+				if err == nil || err.Error() != tc.Error {
+					t.Fatalf("%sparseCompareWith() error == %v, expected %q", tc.Pos, err, tc.Error)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%sparseCompareWith() error:\n%+v", tc.Pos, err)
+			}
+			if input.compareOffset != tc.Expected {
+				t.Fatalf("%sparseCompareWith() == %s, expected %s", tc.Pos, input.compareOffset, tc.Expected)
+			}
+			got := input.compared()
+			if !got.End.Equal(tc.End.Add(-tc.Expected)) || !got.Start.Equal(got.End.Add(-time.Hour)) {
+				t.Fatalf("%scompared() == %s → %s", tc.Pos, got.Start, got.End)
+			}
+		})
+	}
+}
+
 func TestGraphQuerySQL(t *testing.T) {
 	cases := []struct {
 		Description string
@@ -275,7 +365,7 @@ ORDER BY time WITH FILL
 				Points: 100,
 			},
 			Expected: `
-{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","main-table-required":true,"points":100,"units":"l3bps"}@@ }}
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","main-table-required":true,"columns":["SrcAddr","DstAddr","SrcAddr"],"points":100,"units":"l3bps"}@@ }}
 WITH
  source AS (SELECT * REPLACE (tupleElement(IPv6CIDRToRange(SrcAddr, if(tupleElement(IPv6CIDRToRange(SrcAddr, 96), 1) = toIPv6('::ffff:0.0.0.0'), 120, 48)), 1) AS SrcAddr) FROM {{ .Table }} SETTINGS asterisk_include_alias_columns = 1),
  rows AS (SELECT SrcAddr FROM source WHERE {{ .Timefilter }} AND (SrcAddr BETWEEN toIPv6('::ffff:1.0.0.0') AND toIPv6('::ffff:1.255.255.255')) GROUP BY SrcAddr ORDER BY {{ .Units }} DESC LIMIT 0)
@@ -307,7 +397,7 @@ ORDER BY time WITH FILL
 				Points: 100,
 			},
 			Expected: `
-{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","points":100,"units":"l3bps"}@@ }}
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","columns":["DstCountry","SrcCountry"],"points":100,"units":"l3bps"}@@ }}
 WITH
  source AS (SELECT * FROM {{ .Table }} SETTINGS asterisk_include_alias_columns = 1)
 SELECT 1 AS axis, * FROM (
@@ -338,7 +428,7 @@ ORDER BY time WITH FILL
 				Points: 100,
 			},
 			Expected: `
-{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","points":100,"units":"l3bps"}@@ }}
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","columns":["InIfDescription","SrcCountry","OutIfDescription","DstCountry"],"points":100,"units":"l3bps"}@@ }}
 WITH
  source AS (SELECT * FROM {{ .Table }} SETTINGS asterisk_include_alias_columns = 1)
 SELECT 1 AS axis, * FROM (
@@ -370,7 +460,7 @@ ORDER BY time WITH FILL
 				Bidirectional: true,
 			},
 			Expected: `
-{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","points":100,"units":"l3bps"}@@ }}
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","columns":["DstCountry","SrcCountry"],"points":100,"units":"l3bps"}@@ }}
 WITH
  source AS (SELECT * FROM {{ .Table }} SETTINGS asterisk_include_alias_columns = 1)
 SELECT 1 AS axis, * FROM (
@@ -388,7 +478,7 @@ ORDER BY time WITH FILL
  INTERPOLATE (dimensions AS emptyArrayString()))
 {{ end }}
 UNION ALL
-{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","points":100,"units":"l3bps"}@@ }}
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","columns":["DstCountry","SrcCountry"],"points":100,"units":"l3bps"}@@ }}
 SELECT 2 AS axis, * FROM (
 SELECT
  {{ call .ToStartOfInterval "TimeReceived" }} AS time,
@@ -418,7 +508,7 @@ ORDER BY time WITH FILL
 				Bidirectional: true,
 			},
 			Expected: `
-{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","points":100,"units":"inl2%"}@@ }}
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","columns":["DstCountry","SrcCountry"],"points":100,"units":"inl2%"}@@ }}
 WITH
  source AS (SELECT * FROM {{ .Table }} SETTINGS asterisk_include_alias_columns = 1)
 SELECT 1 AS axis, * FROM (
@@ -436,7 +526,7 @@ ORDER BY time WITH FILL
  INTERPOLATE (dimensions AS emptyArrayString()))
 {{ end }}
 UNION ALL
-{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","points":100,"units":"outl2%"}@@ }}
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","columns":["DstCountry","SrcCountry"],"points":100,"units":"outl2%"}@@ }}
 SELECT 2 AS axis, * FROM (
 SELECT
  {{ call .ToStartOfInterval "TimeReceived" }} AS time,
@@ -469,7 +559,7 @@ ORDER BY time WITH FILL
 				Points: 100,
 			},
 			Expected: `
-{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","points":100,"units":"l3bps"}@@ }}
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","columns":["ExporterName","InIfProvider"],"points":100,"units":"l3bps"}@@ }}
 WITH
  source AS (SELECT * FROM {{ .Table }} SETTINGS asterisk_include_alias_columns = 1),
  rows AS (SELECT ExporterName, InIfProvider FROM source WHERE {{ .Timefilter }} GROUP BY ExporterName, InIfProvider ORDER BY {{ .Units }} DESC LIMIT 20)
@@ -481,6 +571,49 @@ SELECT
 FROM source
 WHERE {{ .Timefilter }}
 GROUP BY time, dimensions
+ORDER BY time WITH FILL
+ FROM {{ .TimefilterStart }}
+ TO {{ .TimefilterEnd }} + INTERVAL 1 second
+ STEP {{ .Interval }}
+ INTERPOLATE (dimensions AS ['Other', 'Other']))
+{{ end }}`,
+		}, {
+			Description: "no filters, top rows per bucket",
+			Pos:         helpers.Mark(),
+			Input: graphLineHandlerInput{
+				graphCommonHandlerInput: graphCommonHandlerInput{
+					Start: time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+					End:   time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+					Limit: 20,
+					Dimensions: []query.Column{
+						query.NewColumn("ExporterName"),
+						query.NewColumn("InIfProvider"),
+					},
+					Filter: query.Filter{},
+					Units:  "l3bps",
+				},
+				Points:   100,
+				TopScope: "bucket",
+			},
+			Expected: `
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","columns":["ExporterName","InIfProvider"],"points":100,"units":"l3bps"}@@ }}
+WITH
+ source AS (SELECT * FROM {{ .Table }} SETTINGS asterisk_include_alias_columns = 1)
+SELECT 1 AS axis, * FROM (
+SELECT
+ time,
+ sum(bucketXps) AS xps,
+ if(bucketRank <= 20, bucketDimensions, ['Other', 'Other']) AS dimensions
+FROM (
+ SELECT
+  {{ call .ToStartOfInterval "TimeReceived" }} AS time,
+  {{ .Units }}/{{ .Interval }} AS bucketXps,
+  [ExporterName, InIfProvider] AS bucketDimensions,
+  row_number() OVER (PARTITION BY time ORDER BY bucketXps DESC) AS bucketRank
+ FROM source
+ WHERE {{ .Timefilter }}
+ GROUP BY time, bucketDimensions)
+GROUP BY time, dimensions
 ORDER BY time WITH FILL
  FROM {{ .TimefilterStart }}
  TO {{ .TimefilterEnd }} + INTERVAL 1 second
@@ -506,7 +639,7 @@ ORDER BY time WITH FILL
 				Bidirectional: true,
 			},
 			Expected: `
-{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","points":100,"units":"l3bps"}@@ }}
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","columns":["ExporterName","InIfProvider"],"points":100,"units":"l3bps"}@@ }}
 WITH
  source AS (SELECT * FROM {{ .Table }} SETTINGS asterisk_include_alias_columns = 1),
  rows AS (SELECT ExporterName, InIfProvider FROM source WHERE {{ .Timefilter }} GROUP BY ExporterName, InIfProvider ORDER BY {{ .Units }} DESC LIMIT 20)
@@ -525,7 +658,7 @@ ORDER BY time WITH FILL
  INTERPOLATE (dimensions AS ['Other', 'Other']))
 {{ end }}
 UNION ALL
-{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","points":100,"units":"l3bps"}@@ }}
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","columns":["ExporterName","OutIfProvider"],"points":100,"units":"l3bps"}@@ }}
 SELECT 2 AS axis, * FROM (
 SELECT
  {{ call .ToStartOfInterval "TimeReceived" }} AS time,
@@ -559,7 +692,7 @@ ORDER BY time WITH FILL
 				PreviousPeriod: true,
 			},
 			Expected: `
-{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","points":100,"units":"l3bps"}@@ }}
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","columns":["ExporterName","InIfProvider"],"points":100,"units":"l3bps"}@@ }}
 WITH
  source AS (SELECT * FROM {{ .Table }} SETTINGS asterisk_include_alias_columns = 1),
  rows AS (SELECT ExporterName, InIfProvider FROM source WHERE {{ .Timefilter }} GROUP BY ExporterName, InIfProvider ORDER BY {{ .Units }} DESC LIMIT 20)
@@ -592,6 +725,93 @@ ORDER BY time WITH FILL
  TO {{ .TimefilterEnd }} + INTERVAL 1 second + INTERVAL 86400 second
  STEP {{ .Interval }}
  INTERPOLATE (dimensions AS emptyArrayString()))
+{{ end }}`,
+		}, {
+			Description: "no filters, bidirectional, compare with previous week",
+			Pos:         helpers.Mark(),
+			Input: graphLineHandlerInput{
+				graphCommonHandlerInput: graphCommonHandlerInput{
+					Start: time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+					End:   time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+					Limit: 20,
+					Dimensions: []query.Column{
+						query.NewColumn("ExporterName"),
+						query.NewColumn("InIfProvider"),
+					},
+					Filter: query.Filter{},
+					Units:  "l3bps",
+				},
+				Points:        100,
+				Bidirectional: true,
+				CompareWith:   "week",
+				compareOffset: 7 * 24 * time.Hour,
+			},
+			Expected: `
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","columns":["ExporterName","InIfProvider"],"points":100,"units":"l3bps"}@@ }}
+WITH
+ source AS (SELECT * FROM {{ .Table }} SETTINGS asterisk_include_alias_columns = 1),
+ rows AS (SELECT ExporterName, InIfProvider FROM source WHERE {{ .Timefilter }} GROUP BY ExporterName, InIfProvider ORDER BY {{ .Units }} DESC LIMIT 20)
+SELECT 1 AS axis, * FROM (
+SELECT
+ {{ call .ToStartOfInterval "TimeReceived" }} AS time,
+ {{ .Units }}/{{ .Interval }} AS xps,
+ if((ExporterName, InIfProvider) IN rows, [ExporterName, InIfProvider], ['Other', 'Other']) AS dimensions
+FROM source
+WHERE {{ .Timefilter }}
+GROUP BY time, dimensions
+ORDER BY time WITH FILL
+ FROM {{ .TimefilterStart }}
+ TO {{ .TimefilterEnd }} + INTERVAL 1 second
+ STEP {{ .Interval }}
+ INTERPOLATE (dimensions AS ['Other', 'Other']))
+{{ end }}
+UNION ALL
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","columns":["ExporterName","OutIfProvider"],"points":100,"units":"l3bps"}@@ }}
+SELECT 2 AS axis, * FROM (
+SELECT
+ {{ call .ToStartOfInterval "TimeReceived" }} AS time,
+ {{ .Units }}/{{ .Interval }} AS xps,
+ if((ExporterName, OutIfProvider) IN rows, [ExporterName, OutIfProvider], ['Other', 'Other']) AS dimensions
+FROM source
+WHERE {{ .Timefilter }}
+GROUP BY time, dimensions
+ORDER BY time WITH FILL
+ FROM {{ .TimefilterStart }}
+ TO {{ .TimefilterEnd }} + INTERVAL 1 second
+ STEP {{ .Interval }}
+ INTERPOLATE (dimensions AS ['Other', 'Other']))
+{{ end }}
+UNION ALL
+{{ with context @@{"start":"2022-04-03T15:45:10Z","end":"2022-04-04T15:45:10Z","start-for-interval":"2022-04-10T15:45:10Z","columns":["ExporterName","InIfProvider"],"points":100,"units":"l3bps"}@@ }}
+SELECT 5 AS axis, * FROM (
+SELECT
+ {{ call .ToStartOfInterval "TimeReceived" }} + INTERVAL 604800 second AS time,
+ {{ .Units }}/{{ .Interval }} AS xps,
+ if((ExporterName, InIfProvider) IN rows, [ExporterName, InIfProvider], ['Other', 'Other']) AS dimensions
+FROM source
+WHERE {{ .Timefilter }}
+GROUP BY time, dimensions
+ORDER BY time WITH FILL
+ FROM {{ .TimefilterStart }} + INTERVAL 604800 second
+ TO {{ .TimefilterEnd }} + INTERVAL 1 second + INTERVAL 604800 second
+ STEP {{ .Interval }}
+ INTERPOLATE (dimensions AS ['Other', 'Other']))
+{{ end }}
+UNION ALL
+{{ with context @@{"start":"2022-04-03T15:45:10Z","end":"2022-04-04T15:45:10Z","start-for-interval":"2022-04-10T15:45:10Z","columns":["ExporterName","OutIfProvider"],"points":100,"units":"l3bps"}@@ }}
+SELECT 6 AS axis, * FROM (
+SELECT
+ {{ call .ToStartOfInterval "TimeReceived" }} + INTERVAL 604800 second AS time,
+ {{ .Units }}/{{ .Interval }} AS xps,
+ if((ExporterName, OutIfProvider) IN rows, [ExporterName, OutIfProvider], ['Other', 'Other']) AS dimensions
+FROM source
+WHERE {{ .Timefilter }}
+GROUP BY time, dimensions
+ORDER BY time WITH FILL
+ FROM {{ .TimefilterStart }} + INTERVAL 604800 second
+ TO {{ .TimefilterEnd }} + INTERVAL 1 second + INTERVAL 604800 second
+ STEP {{ .Interval }}
+ INTERPOLATE (dimensions AS ['Other', 'Other']))
 {{ end }}`,
 		},
 	}
@@ -619,12 +839,7 @@ func TestGraphLineHandler(t *testing.T) {
 	base := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
 
 	// Single direction
-	expectedSQL := []struct {
-		Axis       uint8     `ch:"axis"`
-		Time       time.Time `ch:"time"`
-		Xps        float64   `ch:"xps"`
-		Dimensions []string  `ch:"dimensions"`
-	}{
+	expectedSQL := []graphLineQueryResult{
 		{1, base, 1000, []string{"router1", "provider1"}},
 		{1, base, 2000, []string{"router1", "provider2"}},
 		{1, base, 1200, []string{"router2", "provider2"}},
@@ -645,12 +860,7 @@ func TestGraphLineHandler(t *testing.T) {
 		Return(nil)
 
 	// Bidirectional
-	expectedSQL = []struct {
-		Axis       uint8     `ch:"axis"`
-		Time       time.Time `ch:"time"`
-		Xps        float64   `ch:"xps"`
-		Dimensions []string  `ch:"dimensions"`
-	}{
+	expectedSQL = []graphLineQueryResult{
 		{1, base, 1000, []string{"router1", "provider1"}},
 		{1, base, 2000, []string{"router1", "provider2"}},
 		{1, base, 1200, []string{"router2", "provider2"}},
@@ -692,12 +902,7 @@ func TestGraphLineHandler(t *testing.T) {
 		Return(nil)
 
 	// Previous period
-	expectedSQL = []struct {
-		Axis       uint8     `ch:"axis"`
-		Time       time.Time `ch:"time"`
-		Xps        float64   `ch:"xps"`
-		Dimensions []string  `ch:"dimensions"`
-	}{
+	expectedSQL = []graphLineQueryResult{
 		{1, base, 1000, []string{"router1", "provider1"}},
 		{1, base, 2000, []string{"router1", "provider2"}},
 		{1, base, 1200, []string{"router2", "provider2"}},
@@ -1004,6 +1209,246 @@ func TestGraphLineHandler(t *testing.T) {
 	})
 }
 
+func TestGraphLineHandlerCompareWith(t *testing.T) {
+	c, h, mockConn, _ := NewMock(t, DefaultConfiguration())
+	base := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	c.flowsTablesLock.Lock()
+	c.flowsTables = []flowsTable{
+		{"flows", time.Duration(0), base.Add(-12 * time.Hour), nil},
+	}
+	c.flowsTablesLock.Unlock()
+
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), gomock.Any()).
+		SetArg(1, []graphLineQueryResult{
+			{1, base, 1000, []string{"router1"}},
+			{1, base, 500, []string{"router2"}},
+			{1, base.Add(time.Minute), 2000, []string{"router1"}},
+			{1, base.Add(time.Minute), 300, []string{"router2"}},
+			{1, base.Add(2 * time.Minute), 0, []string{}},
+			{5, base, 0, []string{}},
+			{5, base.Add(time.Minute), 800, []string{"router1"}},
+			{5, base.Add(2 * time.Minute), 0, []string{}},
+			// Not aligned with the first axis
+			{5, base.Add(3 * time.Minute), 0, []string{}},
+		}).
+		Return(nil)
+
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			Description: "compare with previous day",
+			URL:         "/api/v0/console/graph/line",
+			JSONInput: gin.H{
+				"start":        base,
+				"end":          base.Add(2 * time.Minute),
+				"points":       100,
+				"limit":        10,
+				"dimensions":   []string{"ExporterName"},
+				"units":        "l3bps",
+				"compare-with": "day",
+				"timezone":     "Europe/Paris",
+			},
+			JSONOutput: gin.H{
+				"t": []string{
+					"2009-11-10T23:00:00Z",
+					"2009-11-10T23:01:00Z",
+					"2009-11-10T23:02:00Z",
+				},
+				"rows": [][]string{
+					{"router1"},
+					{"router2"},
+					{"Other"},
+					{"router1"},
+					{"Other"},
+				},
+				"points": [][]int{
+					{1000, 2000, 0},
+					{500, 300, 0},
+					{0, 0, 0},
+					{0, 800, 0},
+					{0, 0, 0},
+				},
+				"min":     []int{1000, 300, 0, 800, 0},
+				"max":     []int{2000, 500, 0, 800, 0},
+				"average": []int{1000, 266, 0, 266, 0},
+				"95th":    []int{1500, 400, 0, 400, 0},
+				"axis":    []int{1, 1, 1, 5, 5},
+				"axis-names": map[int]string{
+					1: "Direct",
+					5: "Previous day",
+				},
+				"warning": "Data for the comparison window is only available from 2009-11-10T11:00:00Z.",
+			},
+		}, {
+			Description: "invalid comparison offset",
+			URL:         "/api/v0/console/graph/line",
+			JSONInput: gin.H{
+				"start":        base,
+				"end":          base.Add(2 * time.Minute),
+				"points":       100,
+				"limit":        10,
+				"dimensions":   []string{"ExporterName"},
+				"units":        "l3bps",
+				"compare-with": "yesterday",
+			},
+			StatusCode: 400,
+			JSONOutput: gin.H{"message": `Invalid comparison offset "yesterday"`},
+		},
+	})
+}
+
+func TestGraphLineHandlerDisabledUnits(t *testing.T) {
+	_, h, _, _ := NewMock(t, DefaultConfiguration())
+	base := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	// Interface speed is disabled by default.
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			Description: "input interface usage",
+			URL:         "/api/v0/console/graph/line",
+			JSONInput: gin.H{
+				"start":      base,
+				"end":        base.Add(2 * time.Minute),
+				"points":     100,
+				"limit":      10,
+				"dimensions": []string{"ExporterName"},
+				"units":      "inl2%",
+			},
+			StatusCode: 400,
+			JSONOutput: gin.H{"message": "Units inl2% require column InIfSpeed"},
+		}, {
+			Description: "output interface usage",
+			URL:         "/api/v0/console/graph/sankey",
+			JSONInput: gin.H{
+				"start":      base,
+				"end":        base.Add(2 * time.Minute),
+				"limit":      10,
+				"dimensions": []string{"ExporterName"},
+				"units":      "outl2%",
+			},
+			StatusCode: 400,
+			JSONOutput: gin.H{"message": "Units outl2% require column OutIfSpeed"},
+		},
+	})
+}
+
+func TestGraphLineHandlerTopScope(t *testing.T) {
+	_, h, mockConn, _ := NewMock(t, DefaultConfiguration())
+	base := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	gomock.InOrder(
+		// Unfiltered total, without dimensions
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any()).
+			SetArg(1, []graphLineQueryResult{
+				{1, base, 1800, []string{}},
+				{1, base.Add(time.Minute), 2600, []string{}},
+				{1, base.Add(2 * time.Minute), 0, []string{}},
+			}).
+			Return(nil),
+		// Top row over the window: it changes over the window
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any()).
+			SetArg(1, []graphLineQueryResult{
+				{1, base, 1000, []string{"router1"}},
+				{1, base, 800, []string{"Other"}},
+				{1, base.Add(time.Minute), 600, []string{"router1"}},
+				{1, base.Add(time.Minute), 2000, []string{"Other"}},
+				{1, base.Add(2 * time.Minute), 0, []string{}},
+			}).
+			Return(nil),
+		// Top row for each bucket
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any()).
+			SetArg(1, []graphLineQueryResult{
+				{1, base, 1000, []string{"router1"}},
+				{1, base, 800, []string{"Other"}},
+				{1, base.Add(time.Minute), 1500, []string{"router2"}},
+				{1, base.Add(time.Minute), 1100, []string{"Other"}},
+				{1, base.Add(2 * time.Minute), 0, []string{}},
+			}).
+			Return(nil),
+	)
+
+	// sum queries the line graph and returns the sum of all rows for each
+	// timestamp.
+	sum := func(t *testing.T, input gin.H) []int {
+		t.Helper()
+		body, _ := json.Marshal(input)
+		resp, err := http.Post(fmt.Sprintf("http://%s/api/v0/console/graph/line", h.LocalAddr()),
+			"application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("POST /api/v0/console/graph/line:\n%+v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("POST /api/v0/console/graph/line: got status code %d", resp.StatusCode)
+		}
+		var output graphLineHandlerOutput
+		if err := json.NewDecoder(resp.Body).Decode(&output); err != nil {
+			t.Fatalf("POST /api/v0/console/graph/line decode:\n%+v", err)
+		}
+		got := make([]int, len(output.Time))
+		for _, points := range output.Points {
+			for idx, point := range points {
+				if point < 0 {
+					t.Errorf("POST /api/v0/console/graph/line: negative point %d", point)
+				}
+				got[idx] += point
+			}
+		}
+		return got
+	}
+
+	// Total traffic for each timestamp, whatever the top rows are
+	totals := sum(t, gin.H{
+		"start":      base,
+		"end":        base.Add(2 * time.Minute),
+		"points":     100,
+		"limit":      1,
+		"dimensions": []string{},
+		"units":      "l3bps",
+	})
+	if diff := helpers.Diff(totals, []int{1800, 2600, 0}); diff != "" {
+		t.Fatalf("POST /api/v0/console/graph/line unfiltered total (-got, +want):\n%s", diff)
+	}
+
+	for _, scope := range []string{"window", "bucket"} {
+		t.Run(scope, func(t *testing.T) {
+			got := sum(t, gin.H{
+				"start":      base,
+				"end":        base.Add(2 * time.Minute),
+				"points":     100,
+				"limit":      1,
+				"dimensions": []string{"ExporterName"},
+				"units":      "l3bps",
+				"top-scope":  scope,
+			})
+			if diff := helpers.Diff(got, totals); diff != "" {
+				t.Errorf("POST /api/v0/console/graph/line sum of rows (-got, +want):\n%s", diff)
+			}
+		})
+	}
+
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			Description: "invalid top scope",
+			URL:         "/api/v0/console/graph/line",
+			JSONInput: gin.H{
+				"start":      base,
+				"end":        base.Add(2 * time.Minute),
+				"points":     100,
+				"limit":      1,
+				"dimensions": []string{"ExporterName"},
+				"units":      "l3bps",
+				"top-scope":  "everywhere",
+			},
+			StatusCode: 400,
+			JSONOutput: gin.H{"message": "Key: 'graphLineHandlerInput.TopScope' Error:Field validation for 'TopScope' failed on the 'oneof' tag"},
+		},
+	})
+}
+
 func TestGetTableInterval(t *testing.T) {
 	_, h, _, mockClock := NewMock(t, DefaultConfiguration())
 	mockClock.Set(time.Date(2022, 4, 12, 15, 45, 10, 0, time.UTC))
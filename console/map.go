@@ -0,0 +1,129 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"akvorado/common/helpers"
+	"akvorado/common/schema"
+	"akvorado/console/query"
+)
+
+// graphMapHandlerInput describes the input for the /graph/map endpoint.
+type graphMapHandlerInput struct {
+	schema    *schema.Component
+	Start     time.Time    `json:"start" binding:"required"`
+	End       time.Time    `json:"end" binding:"required,gtfield=Start"`
+	Filter    query.Filter `json:"filter"` // where ...
+	Units     string       `json:"units" binding:"required,oneof=pps l3bps l2bps"`
+	Direction string       `json:"direction" binding:"required,oneof=src dst"` // SrcCountry or DstCountry
+}
+
+// graphMapHandlerOutput describes the output for the /graph/map endpoint.
+// Countries are ISO 3166-1 alpha-2 codes sorted by traffic.
+type graphMapHandlerOutput struct {
+	Countries []string `json:"countries"`
+	Xps       []int    `json:"xps"` // country → xps
+	// Explanation when no country is available
+	Warning string `json:"warning,omitempty"`
+}
+
+// graphMapQueryResult is a row returned by the query for the /graph/map
+// endpoint.
+type graphMapQueryResult struct {
+	Xps     float64 `ch:"xps"`
+	Country string  `ch:"country"`
+}
+
+// column returns the country column matching the requested direction.
+func (input graphMapHandlerInput) column() schema.ColumnKey {
+	if input.Direction == "dst" {
+		return schema.ColumnDstCountry
+	}
+	return schema.ColumnSrcCountry
+}
+
+// toSQL converts a map query to an SQL request. Flows without a country are
+// kept to tell apart the absence of traffic from the absence of GeoIP data.
+// Rates are computed over the requested time range, not over the range of
+// the received flows.
+func (input graphMapHandlerInput) toSQL() string {
+	where := templateWhere(input.Filter)
+	column := input.column().String()
+	columns := append([]string{column}, input.Filter.Columns()...)
+	sqlQuery := fmt.Sprintf(`
+{{ with %s }}
+WITH
+ greatest({{ .TimefilterEnd }} - {{ .TimefilterStart }}, 1) AS range
+SELECT
+ {{ .Units }}/range AS xps,
+ %s AS country
+FROM {{ .Table }}
+WHERE %s
+GROUP BY country
+ORDER BY xps DESC
+{{ end }}`,
+		templateContext(inputContext{
+			Start:             input.Start,
+			End:               input.End,
+			MainTableRequired: requireMainTable(input.schema, nil, input.Filter),
+			Columns:           columns,
+			Points:            20,
+			Units:             input.Units,
+		}),
+		column, where)
+	return strings.TrimSpace(sqlQuery)
+}
+
+func (c *Component) graphMapHandlerFunc(gc *gin.Context) {
+	ctx := c.t.Context(gc.Request.Context())
+	input := graphMapHandlerInput{schema: c.d.Schema}
+	if err := gc.ShouldBindJSON(&input); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
+	if err := input.Filter.Validate(input.schema); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
+
+	output := graphMapHandlerOutput{
+		Countries: []string{},
+		Xps:       []int{},
+	}
+	if column, ok := input.schema.LookupColumnByKey(input.column()); !ok || column.Disabled {
+		output.Warning = fmt.Sprintf("The %s column is disabled.", input.column())
+		gc.JSON(http.StatusOK, output)
+		return
+	}
+
+	sqlQuery := c.finalizeQuery(input.toSQL())
+	gc.Header("X-SQL-Query", strings.ReplaceAll(sqlQuery, "\n", "  "))
+	results := []graphMapQueryResult{}
+	if err := c.d.ClickHouseDB.Conn.Select(ctx, &results, sqlQuery); err != nil {
+		c.r.Err(err).Str("query", sqlQuery).Msg("unable to query database")
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "Unable to query database."})
+		return
+	}
+
+	unknown := false
+	for _, result := range results {
+		if strings.TrimRight(result.Country, "\x00") == "" {
+			unknown = true
+			continue
+		}
+		output.Countries = append(output.Countries, result.Country)
+		output.Xps = append(output.Xps, int(result.Xps))
+	}
+	if unknown && len(output.Countries) == 0 {
+		output.Warning = "No country is known for these flows. Is GeoIP enrichment enabled?"
+	}
+	gc.JSON(http.StatusOK, output)
+}
@@ -0,0 +1,215 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/mock/gomock"
+
+	"akvorado/common/helpers"
+	"akvorado/common/schema"
+	"akvorado/console/query"
+)
+
+func TestGraphMapQuerySQL(t *testing.T) {
+	cases := []struct {
+		Description string
+		Pos         helpers.Pos
+		Input       graphMapHandlerInput
+		Expected    string
+	}{
+		{
+			Description: "source, no filter",
+			Pos:         helpers.Mark(),
+			Input: graphMapHandlerInput{
+				Start:     time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+				End:       time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+				Filter:    query.Filter{},
+				Units:     "l3bps",
+				Direction: "src",
+			},
+			Expected: `
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","columns":["SrcCountry"],"points":20,"units":"l3bps"}@@ }}
+WITH
+ greatest({{ .TimefilterEnd }} - {{ .TimefilterStart }}, 1) AS range
+SELECT
+ {{ .Units }}/range AS xps,
+ SrcCountry AS country
+FROM {{ .Table }}
+WHERE {{ .Timefilter }}
+GROUP BY country
+ORDER BY xps DESC
+{{ end }}`,
+		}, {
+			Description: "destination, pps",
+			Pos:         helpers.Mark(),
+			Input: graphMapHandlerInput{
+				Start:     time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+				End:       time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+				Filter:    query.Filter{},
+				Units:     "pps",
+				Direction: "dst",
+			},
+			Expected: `
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","columns":["DstCountry"],"points":20,"units":"pps"}@@ }}
+WITH
+ greatest({{ .TimefilterEnd }} - {{ .TimefilterStart }}, 1) AS range
+SELECT
+ {{ .Units }}/range AS xps,
+ DstCountry AS country
+FROM {{ .Table }}
+WHERE {{ .Timefilter }}
+GROUP BY country
+ORDER BY xps DESC
+{{ end }}`,
+		},
+	}
+	for _, tc := range cases {
+		tc.Input.schema = schema.NewMock(t)
+		if err := tc.Input.Filter.Validate(tc.Input.schema); err != nil {
+			t.Fatalf("%sValidate() error:\n%+v", tc.Pos, err)
+		}
+		tc.Expected = strings.ReplaceAll(tc.Expected, "@@", "`")
+		t.Run(tc.Description, func(t *testing.T) {
+			got := tc.Input.toSQL()
+			if diff := helpers.Diff(strings.Split(strings.TrimSpace(got), "\n"),
+				strings.Split(strings.TrimSpace(tc.Expected), "\n")); diff != "" {
+				t.Errorf("%stoSQL (-got, +want):\n%s", tc.Pos, diff)
+			}
+		})
+	}
+}
+
+func TestGraphMapHandler(t *testing.T) {
+	c, h, mockConn, _ := NewMock(t, DefaultConfiguration())
+
+	gomock.InOrder(
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any()).
+			SetArg(1, []graphMapQueryResult{
+				{9000, "FR"},
+				{2000, "\x00\x00"},
+				{1500, "DE"},
+			}).
+			Return(nil),
+		// Without GeoIP data
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any()).
+			SetArg(1, []graphMapQueryResult{
+				{12500, "\x00\x00"},
+			}).
+			Return(nil),
+	)
+
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			Description: "countries",
+			URL:         "/api/v0/console/graph/map",
+			JSONInput: gin.H{
+				"start":     time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+				"end":       time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+				"units":     "l3bps",
+				"direction": "src",
+			},
+			JSONOutput: gin.H{
+				"countries": []string{"FR", "DE"},
+				"xps":       []int{9000, 1500},
+			},
+		}, {
+			Description: "no GeoIP data",
+			URL:         "/api/v0/console/graph/map",
+			JSONInput: gin.H{
+				"start":     time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+				"end":       time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+				"units":     "pps",
+				"direction": "dst",
+			},
+			JSONOutput: gin.H{
+				"countries": []string{},
+				"xps":       []int{},
+				"warning":   "No country is known for these flows. Is GeoIP enrichment enabled?",
+			},
+		}, {
+			Description: "invalid direction",
+			URL:         "/api/v0/console/graph/map",
+			JSONInput: gin.H{
+				"start":     time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+				"end":       time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+				"units":     "l3bps",
+				"direction": "both",
+			},
+			StatusCode: 400,
+			JSONOutput: gin.H{"message": "Key: 'graphMapHandlerInput.Direction' Error:Field validation for 'Direction' failed on the 'oneof' tag"},
+		},
+	})
+
+	// Country columns disabled
+	sch, err := schema.New(schema.Configuration{
+		Disabled: []schema.ColumnKey{schema.ColumnSrcCountry, schema.ColumnDstCountry},
+	})
+	if err != nil {
+		t.Fatalf("schema.New() error:\n%+v", err)
+	}
+	c.d.Schema = sch
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			Description: "disabled column",
+			URL:         "/api/v0/console/graph/map",
+			JSONInput: gin.H{
+				"start":     time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+				"end":       time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+				"units":     "l2bps",
+				"direction": "src",
+			},
+			JSONOutput: gin.H{
+				"countries": []string{},
+				"xps":       []int{},
+				"warning":   "The SrcCountry column is disabled.",
+			},
+		},
+	})
+}
+
+func TestGraphMapHandlerSingleTimestamp(t *testing.T) {
+	_, h, mockConn, _ := NewMock(t, DefaultConfiguration())
+
+	// All flows are received at the same time. The rate should still be
+	// computed over the requested range.
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), `
+WITH
+ greatest(toDateTime('2022-04-10 16:45:10', 'UTC') - toDateTime('2022-04-10 15:45:10', 'UTC'), 1) AS range
+SELECT
+ SUM(Bytes*SamplingRate*8)/range AS xps,
+ SrcCountry AS country
+FROM flows
+WHERE TimeReceived BETWEEN toDateTime('2022-04-10 15:45:10', 'UTC') AND toDateTime('2022-04-10 16:45:10', 'UTC')
+GROUP BY country
+ORDER BY xps DESC
+`).
+		SetArg(1, []graphMapQueryResult{
+			{1000, "FR"},
+		}).
+		Return(nil)
+
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			URL: "/api/v0/console/graph/map",
+			JSONInput: gin.H{
+				"start":     time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+				"end":       time.Date(2022, 4, 10, 16, 45, 10, 0, time.UTC),
+				"units":     "l3bps",
+				"direction": "src",
+			},
+			JSONOutput: gin.H{
+				"countries": []string{"FR"},
+				"xps":       []int{1000},
+			},
+		},
+	})
+}
@@ -0,0 +1,119 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	stdcontext "context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// percentileSampleInterval is the interval between two samples used to
+// compute percentiles. This is the usual interval for 95th percentile billing.
+const percentileSampleInterval = 5 * time.Minute
+
+// percentileInput returns the input to retrieve samples to compute
+// percentiles. Depending on the resolution of the available tables, the
+// samples may be spaced by more than 5 minutes. Percentiles are not computed
+// for the previous period and the comparison window.
+func (input graphLineHandlerInput) percentileInput() graphLineHandlerInput {
+	input.PreviousPeriod = false
+	input.compareOffset = 0
+	input.Points = uint(input.End.Sub(input.Start) / percentileSampleInterval)
+	if input.Points == 0 {
+		input.Points = 1
+	}
+	return input
+}
+
+// percentile returns the provided percentile of the samples. The samples are
+// sorted and the top (100-p)% are discarded.
+func percentile(samples []float64, p uint) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := make([]float64, len(samples))
+	copy(sorted, samples)
+	sort.Float64s(sorted)
+	index := int(math.Ceil(float64(p)/100*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	return sorted[index]
+}
+
+// percentileSamples groups samples by axis and row. Missing samples are
+// zero. It also returns the samples for the total of each axis. The last
+// timestamp is dropped as it only covers the end of the time range.
+func percentileSamples(results []graphLineQueryResult) (map[string][]float64, map[int][]float64) {
+	// Index timestamps for each axis
+	times := map[int][]time.Time{}
+	seen := map[int]map[time.Time]struct{}{}
+	for _, result := range results {
+		axis := int(result.Axis)
+		if seen[axis] == nil {
+			seen[axis] = map[time.Time]struct{}{}
+		}
+		if _, ok := seen[axis][result.Time]; !ok {
+			seen[axis][result.Time] = struct{}{}
+			times[axis] = append(times[axis], result.Time)
+		}
+	}
+	indexes := map[int]map[time.Time]int{}
+	for axis, axisTimes := range times {
+		sort.Slice(axisTimes, func(i, j int) bool { return axisTimes[i].Before(axisTimes[j]) })
+		if len(axisTimes) > 1 {
+			axisTimes = axisTimes[:len(axisTimes)-1]
+		}
+		indexes[axis] = make(map[time.Time]int, len(axisTimes))
+		for idx, t := range axisTimes {
+			indexes[axis][t] = idx
+		}
+	}
+
+	rows := map[string][]float64{}
+	totals := map[int][]float64{}
+	for _, result := range results {
+		axis := int(result.Axis)
+		idx, ok := indexes[axis][result.Time]
+		if !ok {
+			continue
+		}
+		if totals[axis] == nil {
+			totals[axis] = make([]float64, len(indexes[axis]))
+		}
+		totals[axis][idx] += result.Xps
+		rowKey := fmt.Sprintf("%d-%s", axis, result.Dimensions)
+		if rows[rowKey] == nil {
+			rows[rowKey] = make([]float64, len(indexes[axis]))
+		}
+		rows[rowKey][idx] += result.Xps
+	}
+	return rows, totals
+}
+
+// computePercentiles queries samples to compute the requested percentile for
+// each row and for the total of each axis.
+func (c *Component) computePercentiles(ctx stdcontext.Context, input graphLineHandlerInput, output *graphLineHandlerOutput) error {
+	sqlQuery := c.finalizeQuery(input.percentileInput().toSQL())
+	results := []graphLineQueryResult{}
+	if err := c.d.ClickHouseDB.Conn.Select(ctx, &results, sqlQuery); err != nil {
+		return fmt.Errorf("unable to query samples: %w", err)
+	}
+	fillEmptyDimensions(results, len(input.Dimensions))
+	rows, totals := percentileSamples(results)
+
+	output.Percentiles = make([]int, len(output.Rows))
+	for idx, row := range output.Rows {
+		rowKey := fmt.Sprintf("%d-%s", output.Axis[idx], row)
+		output.Percentiles[idx] = int(percentile(rows[rowKey], input.Percentile))
+	}
+	output.PercentilesTotal = make(map[int]int, len(totals))
+	for axis, samples := range totals {
+		output.PercentilesTotal[axis] = int(percentile(samples, input.Percentile))
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/mock/gomock"
+
+	"akvorado/common/helpers"
+)
+
+func TestPercentile(t *testing.T) {
+	oneToHundred := []float64{}
+	for i := 100; i >= 1; i-- {
+		oneToHundred = append(oneToHundred, float64(i))
+	}
+	oneDay := make([]float64, 288)
+	for i := range oneDay {
+		oneDay[i] = float64(i)
+	}
+	cases := []struct {
+		Samples    []float64
+		Percentile uint
+		Expected   float64
+	}{
+		{nil, 95, 0},
+		{[]float64{10}, 95, 10},
+		{[]float64{10, 20}, 95, 20},
+		{[]float64{10, 20}, 50, 10},
+		{oneToHundred, 95, 95},
+		{oneToHundred, 99, 99},
+		{oneToHundred, 1, 1},
+		// 288 5-minute samples: the top 14 are discarded
+		{oneDay, 95, 273},
+	}
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%d-%d", len(tc.Samples), tc.Percentile), func(t *testing.T) {
+			got := percentile(tc.Samples, tc.Percentile)
+			if got != tc.Expected {
+				t.Fatalf("percentile() == %f, expected %f", got, tc.Expected)
+			}
+		})
+	}
+}
+
+func TestPercentileInput(t *testing.T) {
+	input := graphLineHandlerInput{
+		graphCommonHandlerInput: graphCommonHandlerInput{
+			Start: time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+			End:   time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+		},
+		Points: 100,
+	}
+	if got := input.percentileInput().Points; got != 288 {
+		t.Errorf("percentileInput().Points == %d, expected 288", got)
+	}
+	input.End = input.Start.Add(time.Minute)
+	if got := input.percentileInput().Points; got != 1 {
+		t.Errorf("percentileInput().Points == %d, expected 1", got)
+	}
+}
+
+func TestPercentileSamples(t *testing.T) {
+	base := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	results := []graphLineQueryResult{
+		{1, base, 100, []string{"router1"}},
+		{1, base, 50, []string{"router2"}},
+		{1, base.Add(5 * time.Minute), 200, []string{"router1"}},
+		{1, base.Add(10 * time.Minute), 300, []string{"router1"}},
+		{1, base.Add(10 * time.Minute), 30, []string{"router2"}},
+		{1, base.Add(15 * time.Minute), 0, []string{"Other"}}, // end of range
+		{2, base, 10, []string{"router1"}},
+		{2, base.Add(5 * time.Minute), 20, []string{"router1"}},
+	}
+	rows, totals := percentileSamples(results)
+	expectedRows := map[string][]float64{
+		"1-[router1]": {100, 200, 300},
+		"1-[router2]": {50, 0, 30},
+		"2-[router1]": {10},
+	}
+	expectedTotals := map[int][]float64{
+		1: {150, 200, 330},
+		2: {10},
+	}
+	if diff := helpers.Diff(rows, expectedRows); diff != "" {
+		t.Errorf("percentileSamples() rows (-got, +want):\n%s", diff)
+	}
+	if diff := helpers.Diff(totals, expectedTotals); diff != "" {
+		t.Errorf("percentileSamples() totals (-got, +want):\n%s", diff)
+	}
+}
+
+func TestGraphLineHandlerPercentile(t *testing.T) {
+	_, h, mockConn, _ := NewMock(t, DefaultConfiguration())
+	base := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	gomock.InOrder(
+		// Graph
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any()).
+			SetArg(1, []graphLineQueryResult{
+				{1, base, 1000, []string{"router1"}},
+				{1, base, 500, []string{"router2"}},
+				{1, base.Add(time.Hour), 3000, []string{"router1"}},
+				{1, base.Add(time.Hour), 200, []string{"router2"}},
+				{1, base.Add(2 * time.Hour), 0, []string{}},
+			}).
+			Return(nil),
+		// Samples
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any()).
+			SetArg(1, []graphLineQueryResult{
+				{1, base, 1000, []string{"router1"}},
+				{1, base, 500, []string{"router2"}},
+				{1, base.Add(5 * time.Minute), 4000, []string{"router1"}},
+				{1, base.Add(10 * time.Minute), 2000, []string{"router1"}},
+				{1, base.Add(10 * time.Minute), 100, []string{"router2"}},
+				{1, base.Add(15 * time.Minute), 0, []string{}},
+			}).
+			Return(nil),
+	)
+
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			URL: "/api/v0/console/graph/line",
+			JSONInput: gin.H{
+				"start":      base,
+				"end":        base.Add(2 * time.Hour),
+				"points":     100,
+				"limit":      10,
+				"dimensions": []string{"ExporterName"},
+				"units":      "l3bps",
+				"percentile": 50,
+			},
+			JSONOutput: gin.H{
+				"t": []string{
+					"2009-11-10T23:00:00Z",
+					"2009-11-11T00:00:00Z",
+					"2009-11-11T01:00:00Z",
+				},
+				"rows": [][]string{
+					{"router1"},
+					{"router2"},
+					{"Other"},
+				},
+				"points": [][]int{
+					{1000, 3000, 0},
+					{500, 200, 0},
+					{0, 0, 0},
+				},
+				"min":     []int{1000, 200, 0},
+				"max":     []int{3000, 500, 0},
+				"average": []int{1333, 233, 0},
+				"95th":    []int{2000, 350, 0},
+				"axis":    []int{1, 1, 1},
+				"axis-names": map[int]string{
+					1: "Direct",
+				},
+				"percentiles":       []int{2000, 100, 0},
+				"percentiles-total": map[int]int{1: 2100},
+			},
+		},
+	})
+}
@@ -22,6 +22,18 @@ func requireMainTable(sch *schema.Component, qcs []query.Column, qf query.Filter
 	return false
 }
 
+// requiredColumns returns the columns needed to execute a query with the
+// provided dimensions and filter.
+func requiredColumns(sch *schema.Component, qcs []query.Column, qf query.Filter) []string {
+	columns := append([]string{}, qf.Columns()...)
+	for _, qc := range qcs {
+		if column, ok := sch.LookupColumnByKey(qc.Key()); ok {
+			columns = append(columns, column.Name)
+		}
+	}
+	return columns
+}
+
 // fixQueryColumnName fix capitalization of the provided column name
 func (c *Component) fixQueryColumnName(name string) string {
 	name = strings.ToLower(name)
@@ -7,6 +7,7 @@ package query
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"akvorado/common/helpers"
@@ -14,11 +15,15 @@ import (
 )
 
 // Column represents a query column. It should be instantiated with NewColumn() or
-// Unmarshal(), then call Validate().
+// Unmarshal(), then call Validate(). An IP address column can be suffixed by
+// the prefix lengths for IPv4 and IPv6 to group addresses by prefixes
+// ("SrcAddr/24/48").
 type Column struct {
 	validated bool
 	name      string
 	key       schema.ColumnKey
+	truncate4 uint8 // 0 when not truncated
+	truncate6 uint8
 }
 
 // Columns is a set of query columns.
@@ -57,20 +62,58 @@ func (qc *Column) Key() schema.ColumnKey {
 	return qc.key
 }
 
+// Truncated returns the prefix lengths for IPv4 and IPv6 addresses when the
+// column groups addresses by prefixes.
+func (qc Column) Truncated() (uint8, uint8, bool) {
+	qc.check()
+	return qc.truncate4, qc.truncate6, qc.truncate4 > 0
+}
+
+// Identifier returns the identifier to use for the column in SQL queries.
+// Truncated addresses are computed in the source query under the column name.
+func (qc Column) Identifier() string {
+	qc.check()
+	if qc.truncate4 > 0 {
+		return fmt.Sprintf("`%s`", qc.name)
+	}
+	return qc.name
+}
+
 // Validate should be called before using the column. We need a schema component
 // for that.
 func (qc *Column) Validate(schema *schema.Component) error {
-	if column, ok := schema.LookupColumnByName(qc.name); ok && !column.ConsoleNotDimension && !column.Disabled {
-		qc.key = column.Key
-		qc.validated = true
-		return nil
+	name, prefixes, truncated := strings.Cut(qc.name, "/")
+	column, ok := schema.LookupColumnByName(name)
+	if !ok || column.ConsoleNotDimension || column.Disabled {
+		return fmt.Errorf("unknown column name %s", qc.name)
+	}
+	if truncated {
+		if !column.ConsoleTruncateIP {
+			return fmt.Errorf("column %s cannot be truncated", name)
+		}
+		prefix4, prefix6, _ := strings.Cut(prefixes, "/")
+		truncate4, err4 := strconv.ParseUint(prefix4, 10, 8)
+		truncate6, err6 := strconv.ParseUint(prefix6, 10, 8)
+		if err4 != nil || err6 != nil ||
+			truncate4 == 0 || truncate4 > 32 || truncate6 == 0 || truncate6 > 128 {
+			return fmt.Errorf("invalid prefix lengths for %s (expected %s/24/48 for example)",
+				qc.name, name)
+		}
+		qc.truncate4 = uint8(truncate4)
+		qc.truncate6 = uint8(truncate6)
 	}
-	return fmt.Errorf("unknown column name %s", qc.name)
+	qc.key = column.Key
+	qc.validated = true
+	return nil
 }
 
 // Reverse reverses the column direction
 func (qc *Column) Reverse(schema *schema.Component) {
-	name := schema.ReverseColumnDirection(qc.Key()).String()
+	reversed, _ := schema.LookupColumnByKey(schema.ReverseColumnDirection(qc.Key()))
+	name := reversed.Name
+	if _, prefixes, truncated := strings.Cut(qc.name, "/"); truncated {
+		name = fmt.Sprintf("%s/%s", name, prefixes)
+	}
 	reverted := Column{name: name}
 	if reverted.Validate(schema) == nil {
 		*qc = reverted
@@ -104,7 +147,7 @@ func (qc Column) ToSQLSelect(sch *schema.Component) string {
 	case schema.ColumnSrcAS, schema.ColumnDstAS, schema.ColumnDst1stAS, schema.ColumnDst2ndAS, schema.ColumnDst3rdAS:
 		strValue = fmt.Sprintf(`concat(toString(%s), ': ', dictGetOrDefault('%s', 'name', %s, '???'))`,
 			qc, schema.DictionaryASNs, qc)
-	case schema.ColumnInIfBoundary, schema.ColumnOutIfBoundary:
+	case schema.ColumnInIfBoundary, schema.ColumnOutIfBoundary, schema.ColumnDstRPKIStatus:
 		strValue = fmt.Sprintf(`toString(%s)`, qc.String())
 	case schema.ColumnEType:
 		strValue = fmt.Sprintf(`if(EType = %d, 'IPv4', if(EType = %d, 'IPv6', '???'))`,
@@ -142,12 +185,17 @@ func (qc Column) ToSQLSelect(sch *schema.Component) string {
 
 	// Generic cases
 	default:
-		strValue = qc.String()
+		strValue = qc.Identifier()
 		if col, ok := sch.LookupColumnByKey(key); ok {
 			if strings.HasPrefix(col.ClickHouseType, "UInt") {
 				strValue = fmt.Sprintf(`toString(%s)`, qc)
 			} else if col.ClickHouseType == "IPv6" || col.ClickHouseType == "LowCardinality(IPv6)" {
-				strValue = fmt.Sprintf("replaceRegexpOne(IPv6NumToString(%s), '^::ffff:', '')", qc)
+				strValue = fmt.Sprintf("replaceRegexpOne(IPv6NumToString(%s), '^::ffff:', '')", qc.Identifier())
+				if qc.truncate4 > 0 {
+					strValue = fmt.Sprintf(
+						"concat(%s, if(tupleElement(IPv6CIDRToRange(%s, 96), 1) = toIPv6('::ffff:0.0.0.0'), '/%d', '/%d'))",
+						strValue, qc.Identifier(), qc.truncate4, qc.truncate6)
+				}
 			}
 		}
 	}
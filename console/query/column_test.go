@@ -22,6 +22,11 @@ func TestUnmarshalQueryColumn(t *testing.T) {
 		{"DstAddr", schema.ColumnDstAddr, false},
 		{"TimeReceived", 0, true},
 		{"Nothing", 0, true},
+		{"SrcAddr/24/48", schema.ColumnSrcAddr, false},
+		{"SrcAddr/33/48", 0, true},
+		{"SrcAddr/24/0", 0, true},
+		{"SrcAddr/24", 0, true},
+		{"ExporterName/24/48", 0, true},
 	}
 	for _, tc := range cases {
 		var qc query.Column
@@ -95,6 +100,15 @@ func TestQueryColumnSQLSelect(t *testing.T) {
 		}, {
 			Input:    schema.ColumnInIfBoundary,
 			Expected: `toString(InIfBoundary)`,
+		}, {
+			Input:    schema.ColumnDstRPKIStatus,
+			Expected: `toString(DstRPKIStatus)`,
+		}, {
+			Input:    schema.ColumnDstNextHop,
+			Expected: `replaceRegexpOne(IPv6NumToString(DstNextHop), '^::ffff:', '')`,
+		}, {
+			Input:    schema.ColumnDstLocalPref,
+			Expected: `toString(DstLocalPref)`,
 		}, {
 			Input:    schema.ColumnMPLSLabels,
 			Expected: `arrayStringConcat(MPLSLabels, ' ')`,
@@ -127,12 +141,26 @@ func TestQueryColumnSQLSelect(t *testing.T) {
 	}
 }
 
+func TestQueryColumnSQLSelectTruncated(t *testing.T) {
+	sch := schema.NewMock(t)
+	column := query.NewColumn("SrcAddr/24/48")
+	if err := column.Validate(sch); err != nil {
+		t.Fatalf("Validate() error:\n%+v", err)
+	}
+	got := column.ToSQLSelect(sch)
+	expected := "concat(replaceRegexpOne(IPv6NumToString(`SrcAddr/24/48`), '^::ffff:', ''), if(tupleElement(IPv6CIDRToRange(`SrcAddr/24/48`, 96), 1) = toIPv6('::ffff:0.0.0.0'), '/24', '/48'))"
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Errorf("toSQLWhere (-got, +want):\n%s", diff)
+	}
+}
+
 func TestReverseDirection(t *testing.T) {
 	columns := query.Columns{
 		query.NewColumn("SrcAS"),
 		query.NewColumn("DstAS"),
 		query.NewColumn("ExporterName"),
 		query.NewColumn("InIfProvider"),
+		query.NewColumn("SrcAddr/24/48"),
 	}
 	sch := schema.NewMock(t)
 	if err := columns.Validate(sch); err != nil {
@@ -144,6 +172,7 @@ func TestReverseDirection(t *testing.T) {
 		query.NewColumn("SrcAS"),
 		query.NewColumn("ExporterName"),
 		query.NewColumn("OutIfProvider"),
+		query.NewColumn("DstAddr/24/48"),
 	}
 	if diff := helpers.Diff(columns, expected, helpers.DiffFormatter(reflect.TypeOf(query.Column{}), fmt.Sprint)); diff != "" {
 		t.Fatalf("Reverse() (-got, +want):\n%s", diff)
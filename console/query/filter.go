@@ -5,8 +5,11 @@ package query
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 
+	"golang.org/x/exp/slices"
+
 	"akvorado/common/schema"
 	"akvorado/console/filter"
 )
@@ -17,6 +20,7 @@ type Filter struct {
 	filter            string
 	reverseFilter     string
 	mainTableRequired bool
+	columns           []string
 }
 
 // NewFilter creates a new filter. It should be validated with Validate() before use.
@@ -67,16 +71,45 @@ func (qf *Filter) Validate(sch *schema.Component) error {
 	qf.filter = direct.(string)
 	qf.reverseFilter = reverse.(string)
 	qf.mainTableRequired = meta.MainTableRequired
+	qf.columns = columnsIn(sch, qf.filter)
+	for _, column := range columnsIn(sch, qf.reverseFilter) {
+		if !slices.Contains(qf.columns, column) {
+			qf.columns = append(qf.columns, column)
+		}
+	}
 	qf.validated = true
 	return nil
 }
 
+var (
+	sqlStringRegexp     = regexp.MustCompile(`'(?:[^'\\]|\\.)*'`)
+	sqlIdentifierRegexp = regexp.MustCompile(`[A-Za-z][A-Za-z0-9]*`)
+)
+
+// columnsIn returns the names of the columns referenced by a SQL expression.
+func columnsIn(sch *schema.Component, expr string) []string {
+	columns := []string{}
+	expr = sqlStringRegexp.ReplaceAllString(expr, "''")
+	for _, name := range sqlIdentifierRegexp.FindAllString(expr, -1) {
+		if _, ok := sch.LookupColumnByName(name); ok && !slices.Contains(columns, name) {
+			columns = append(columns, name)
+		}
+	}
+	return columns
+}
+
 // MainTableRequired tells if the main table is required for this filter.
 func (qf Filter) MainTableRequired() bool {
 	qf.check()
 	return qf.mainTableRequired
 }
 
+// Columns returns the columns used by the filter, in both directions.
+func (qf Filter) Columns() []string {
+	qf.check()
+	return qf.columns
+}
+
 // Reverse provides the reverse filter.
 func (qf Filter) Reverse() string {
 	qf.check()
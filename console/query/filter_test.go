@@ -59,3 +59,27 @@ func TestFilterSwap(t *testing.T) {
 		t.Fatalf("Swap() (-got, +want):\n%s", diff)
 	}
 }
+
+func TestFilterColumns(t *testing.T) {
+	cases := []struct {
+		Input    string
+		Expected []string
+	}{
+		{"", nil},
+		{"SrcAS = 12322", []string{"SrcAS", "DstAS"}},
+		{"InIfBoundary = external AND ExporterName = 'SrcCountry'", []string{"InIfBoundary", "ExporterName", "OutIfBoundary"}},
+		{"DstCountry = 'FR' OR SrcPort = 443", []string{"DstCountry", "SrcPort", "SrcCountry", "DstPort"}},
+	}
+	sch := schema.NewMock(t)
+	for _, tc := range cases {
+		t.Run(tc.Input, func(t *testing.T) {
+			filter := query.NewFilter(tc.Input)
+			if err := filter.Validate(sch); err != nil {
+				t.Fatalf("Validate() error:\n%+v", err)
+			}
+			if diff := helpers.Diff(filter.Columns(), tc.Expected); diff != "" {
+				t.Fatalf("Columns() (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
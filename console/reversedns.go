@@ -0,0 +1,237 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	stdcontext "context"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/tomb.v2"
+
+	"akvorado/common/helpers/cache"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/console/query"
+)
+
+// reverseDNSResolver resolves IP addresses to names. Answers, including
+// failures, are cached. Lookups are done in the background: when the budget
+// expires, the caller gets the names available so far and the remaining
+// lookups complete to fill the cache.
+type reverseDNSResolver struct {
+	r          *reporter.Reporter
+	config     ReverseDNSConfiguration
+	lookupAddr func(ctx stdcontext.Context, addr string) ([]string, error)
+	cache      *cache.Cache[netip.Addr, reverseDNSAnswer]
+	semaphore  chan struct{}
+
+	pendingLock sync.Mutex
+	pending     map[netip.Addr]chan struct{}
+
+	metrics struct {
+		cacheHits   reporter.Counter
+		cacheMisses reporter.Counter
+		errors      reporter.Counter
+	}
+}
+
+// reverseDNSAnswer is a cached answer. Name is empty when the lookup failed.
+type reverseDNSAnswer struct {
+	Fetched time.Time
+	Name    string
+}
+
+// newReverseDNSResolver creates a new reverse DNS resolver.
+func newReverseDNSResolver(r *reporter.Reporter, config ReverseDNSConfiguration) *reverseDNSResolver {
+	resolver := net.DefaultResolver
+	if config.Upstream != "" {
+		upstream := config.Upstream
+		if _, _, err := net.SplitHostPort(upstream); err != nil {
+			upstream = net.JoinHostPort(upstream, "53")
+		}
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx stdcontext.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, upstream)
+			},
+		}
+	}
+	rr := reverseDNSResolver{
+		r:          r,
+		config:     config,
+		lookupAddr: resolver.LookupAddr,
+		cache:      cache.NewBounded[netip.Addr, reverseDNSAnswer](config.CacheSize),
+		semaphore:  make(chan struct{}, config.Concurrency),
+		pending:    map[netip.Addr]chan struct{}{},
+	}
+	rr.metrics.cacheHits = r.Counter(
+		reporter.CounterOpts{
+			Name: "reverse_dns_cache_hits_total",
+			Help: "Number of hits in the reverse DNS cache.",
+		})
+	rr.metrics.cacheMisses = r.Counter(
+		reporter.CounterOpts{
+			Name: "reverse_dns_cache_misses_total",
+			Help: "Number of misses in the reverse DNS cache.",
+		})
+	rr.metrics.errors = r.Counter(
+		reporter.CounterOpts{
+			Name: "reverse_dns_errors_total",
+			Help: "Number of failed reverse DNS lookups.",
+		})
+	return &rr
+}
+
+// start starts the goroutine expiring entries from the cache.
+func (rr *reverseDNSResolver) start(t *tomb.Tomb) {
+	t.Go(func() error {
+		ticker := time.NewTicker(rr.config.CacheTTL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.Dying():
+				return nil
+			case <-ticker.C:
+				rr.cache.DeleteLastAccessedBefore(time.Now().Add(-rr.config.CacheTTL))
+			}
+		}
+	})
+}
+
+// resolve resolves the provided addresses and returns a map from addresses to
+// names. It returns after the configured budget even if some lookups are not
+// complete. Addresses without a name are absent from the result.
+func (rr *reverseDNSResolver) resolve(t *tomb.Tomb, addrs []string) map[string]string {
+	names := map[string]string{}
+	waiting := map[string]chan struct{}{}
+	now := time.Now()
+	for _, addr := range addrs {
+		if _, ok := names[addr]; ok {
+			continue
+		}
+		if _, ok := waiting[addr]; ok {
+			continue
+		}
+		ip, err := netip.ParseAddr(addr)
+		if err != nil || ip.IsUnspecified() {
+			continue
+		}
+		ip = ip.Unmap()
+		if answer, ok := rr.cache.Get(now, ip); ok && now.Sub(answer.Fetched) < rr.config.CacheTTL {
+			rr.metrics.cacheHits.Inc()
+			if answer.Name != "" {
+				names[addr] = answer.Name
+			}
+			continue
+		}
+		rr.metrics.cacheMisses.Inc()
+		waiting[addr] = rr.lookup(t, ip)
+	}
+	if len(waiting) == 0 {
+		return names
+	}
+
+	budget := time.NewTimer(rr.config.Budget)
+	defer budget.Stop()
+	for addr, done := range waiting {
+		select {
+		case <-done:
+		case <-budget.C:
+			return rr.collect(names, waiting)
+		}
+		delete(waiting, addr)
+		ip, _ := netip.ParseAddr(addr)
+		if answer, ok := rr.cache.Get(time.Now(), ip.Unmap()); ok && answer.Name != "" {
+			names[addr] = answer.Name
+		}
+	}
+	return names
+}
+
+// collect adds to names the answers already in cache for the addresses still
+// waiting when the budget expired.
+func (rr *reverseDNSResolver) collect(names map[string]string, waiting map[string]chan struct{}) map[string]string {
+	for addr, done := range waiting {
+		select {
+		case <-done:
+			ip, _ := netip.ParseAddr(addr)
+			if answer, ok := rr.cache.Get(time.Now(), ip.Unmap()); ok && answer.Name != "" {
+				names[addr] = answer.Name
+			}
+		default:
+		}
+	}
+	return names
+}
+
+// lookup starts a lookup for the provided address unless one is already in
+// progress. It returns a channel closed when the answer is in the cache.
+func (rr *reverseDNSResolver) lookup(t *tomb.Tomb, ip netip.Addr) chan struct{} {
+	rr.pendingLock.Lock()
+	defer rr.pendingLock.Unlock()
+	if done, ok := rr.pending[ip]; ok {
+		return done
+	}
+	done := make(chan struct{})
+	rr.pending[ip] = done
+	t.Go(func() error {
+		defer func() {
+			rr.pendingLock.Lock()
+			delete(rr.pending, ip)
+			rr.pendingLock.Unlock()
+			close(done)
+		}()
+		select {
+		case rr.semaphore <- struct{}{}:
+		case <-t.Dying():
+			return nil
+		}
+		defer func() { <-rr.semaphore }()
+		ctx, cancel := stdcontext.WithTimeout(t.Context(nil), rr.config.Timeout)
+		defer cancel()
+		answer := reverseDNSAnswer{Fetched: time.Now()}
+		results, err := rr.lookupAddr(ctx, ip.String())
+		if err != nil || len(results) == 0 {
+			rr.metrics.errors.Inc()
+		} else {
+			answer.Name = strings.TrimSuffix(results[0], ".")
+		}
+		rr.cache.Put(answer.Fetched, ip, answer)
+		return nil
+	})
+	return done
+}
+
+// addressesFromRows extracts the IP addresses from the provided rows. Only the
+// dimensions for IP address columns are considered.
+func addressesFromRows(sch *schema.Component, dimensions []query.Column, rows [][]string) []string {
+	indexes := []int{}
+	for idx, qc := range dimensions {
+		column, ok := sch.LookupColumnByKey(qc.Key())
+		if _, _, truncated := qc.Truncated(); !ok || truncated {
+			continue
+		}
+		switch column.ClickHouseType {
+		case "IPv6", "LowCardinality(IPv6)":
+			indexes = append(indexes, idx)
+		}
+	}
+	if len(indexes) == 0 {
+		return nil
+	}
+	addrs := []string{}
+	for _, row := range rows {
+		for _, idx := range indexes {
+			if idx < len(row) && row[idx] != "Other" {
+				addrs = append(addrs, row[idx])
+			}
+		}
+	}
+	return addrs
+}
@@ -0,0 +1,96 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	stdcontext "context"
+	"errors"
+	"testing"
+	"time"
+
+	"gopkg.in/tomb.v2"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/console/query"
+)
+
+func TestReverseDNSResolver(t *testing.T) {
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration().ReverseDNS
+	config.Budget = 20 * time.Millisecond
+	rr := newReverseDNSResolver(r, config)
+	slow := make(chan struct{})
+	rr.lookupAddr = func(ctx stdcontext.Context, addr string) ([]string, error) {
+		switch addr {
+		case "192.0.2.1":
+			return []string{"router1.example.com."}, nil
+		case "2001:db8::1":
+			select {
+			case <-slow:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			return []string{"server1.example.com."}, nil
+		default:
+			return nil, errors.New("not found")
+		}
+	}
+	var tb tomb.Tomb
+	rr.start(&tb)
+	defer func() {
+		tb.Kill(nil)
+		tb.Wait()
+	}()
+
+	addrs := []string{"::ffff:192.0.2.1", "::ffff:192.0.2.2", "2001:db8::1", "Other"}
+	start := time.Now()
+	got := rr.resolve(&tb, addrs)
+	if elapsed := time.Since(start); elapsed > 10*config.Budget {
+		t.Errorf("resolve() took %s", elapsed)
+	}
+	expected := map[string]string{
+		"::ffff:192.0.2.1": "router1.example.com",
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("resolve() (-got, +want):\n%s", diff)
+	}
+
+	// The slow lookup completes in the background
+	close(slow)
+	time.Sleep(20 * time.Millisecond)
+	got = rr.resolve(&tb, addrs)
+	expected["2001:db8::1"] = "server1.example.com"
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("resolve() (-got, +want):\n%s", diff)
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_console_reverse_dns_")
+	expectedMetrics := map[string]string{
+		`cache_hits_total`:   "3",
+		`cache_misses_total`: "3",
+		`errors_total`:       "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestAddressesFromRows(t *testing.T) {
+	sch := schema.NewMock(t)
+	dimensions := []query.Column{query.NewColumn("SrcAS"), query.NewColumn("DstAddr")}
+	if err := query.Columns(dimensions).Validate(sch); err != nil {
+		t.Fatalf("Validate() error:\n%+v", err)
+	}
+	got := addressesFromRows(sch, dimensions, [][]string{
+		{"AS65000", "2001:db8::1"},
+		{"AS65001", "::ffff:192.0.2.1"},
+		{"Other", "Other"},
+	})
+	expected := []string{"2001:db8::1", "::ffff:192.0.2.1"}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("addressesFromRows() (-got, +want):\n%s", diff)
+	}
+}
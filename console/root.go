@@ -36,6 +36,7 @@ type Component struct {
 
 	flowsTables     []flowsTable
 	flowsTablesLock sync.RWMutex
+	reverseDNS      *reverseDNSResolver
 
 	metrics struct {
 		clickhouseQueries *reporter.CounterVec
@@ -65,8 +66,9 @@ func New(r *reporter.Reporter, config Configuration, dependencies Dependencies)
 		r:           r,
 		d:           &dependencies,
 		config:      config,
-		flowsTables: []flowsTable{{"flows", 0, time.Time{}}},
+		flowsTables: []flowsTable{{dependencies.ClickHouseDB.TableName("flows"), 0, time.Time{}, nil}},
 	}
+	c.reverseDNS = newReverseDNSResolver(r, config.ReverseDNS)
 
 	c.d.Daemon.Track(&c.t, "console")
 
@@ -92,17 +94,26 @@ func (c *Component) Start() error {
 	endpoint.GET("/widget/exporters", c.d.HTTP.CacheByRequestPath(30*time.Second), c.widgetExportersHandlerFunc)
 	endpoint.GET("/widget/top/:name", c.d.HTTP.CacheByRequestPath(30*time.Second), c.widgetTopHandlerFunc)
 	endpoint.GET("/widget/graph", c.d.HTTP.CacheByRequestPath(5*time.Minute), c.widgetGraphHandlerFunc)
-	endpoint.POST("/graph/line", c.d.HTTP.CacheByRequestBody(c.config.CacheTTL), c.graphLineHandlerFunc)
-	endpoint.POST("/graph/sankey", c.d.HTTP.CacheByRequestBody(c.config.CacheTTL), c.graphSankeyHandlerFunc)
+	endpoint.POST("/graph/line", c.cacheByRequestBodyUnlessCSV(c.config.CacheTTL), c.graphLineHandlerFunc)
+	endpoint.POST("/graph/sankey", c.cacheByRequestBodyUnlessCSV(c.config.CacheTTL), c.graphSankeyHandlerFunc)
+	endpoint.POST("/graph/map", c.d.HTTP.CacheByRequestBody(c.config.CacheTTL), c.graphMapHandlerFunc)
 	endpoint.POST("/graph/table-interval", c.getTableAndIntervalHandlerFunc)
 	endpoint.POST("/filter/validate", c.filterValidateHandlerFunc)
 	endpoint.POST("/filter/complete", c.d.HTTP.CacheByRequestBody(time.Minute), c.filterCompleteHandlerFunc)
 	endpoint.GET("/filter/saved", c.filterSavedListHandlerFunc)
 	endpoint.DELETE("/filter/saved/:id", c.filterSavedDeleteHandlerFunc)
 	endpoint.POST("/filter/saved", c.filterSavedAddHandlerFunc)
+	endpoint.GET("/dashboards", c.dashboardsListHandlerFunc)
+	endpoint.POST("/dashboards", c.dashboardsAddHandlerFunc)
+	endpoint.GET("/dashboards/:id", c.dashboardsGetHandlerFunc)
+	endpoint.PUT("/dashboards/:id", c.dashboardsUpdateHandlerFunc)
+	endpoint.DELETE("/dashboards/:id", c.dashboardsDeleteHandlerFunc)
+	endpoint.POST("/share", c.shareAddHandlerFunc)
+	endpoint.GET("/share/:id", c.shareGetHandlerFunc)
 	endpoint.GET("/user/info", c.d.Auth.UserInfoHandlerFunc)
 	endpoint.GET("/user/avatar", c.d.Auth.UserAvatarHandlerFunc)
 
+	c.reverseDNS.start(&c.t)
 	c.t.Go(func() error {
 		ticker := time.NewTicker(10 * time.Second)
 		defer ticker.Stop()
@@ -15,9 +15,13 @@ import (
 	"akvorado/console/query"
 )
 
+// sankeyMaxDimensions is the maximum number of stages of a sankey graph.
+const sankeyMaxDimensions = 5
+
 // graphSankeyHandlerInput describes the input for the /graph/sankey endpoint.
 type graphSankeyHandlerInput struct {
 	graphCommonHandlerInput
+	MinShare float64 `json:"min-share" binding:"min=0,max=100"` // nodes below this percentage are merged into "Other"
 }
 
 // graphSankeyHandlerOutput describes the output for the /graph/sankey endpoint.
@@ -28,6 +32,8 @@ type graphSankeyHandlerOutput struct {
 	// Processed data for sankey graph
 	Nodes []string     `json:"nodes"`
 	Links []sankeyLink `json:"links"`
+	// Names for IP addresses (when requested)
+	Names map[string]string `json:"names,omitempty"`
 }
 type sankeyLink struct {
 	Source string `json:"source"`
@@ -35,6 +41,13 @@ type sankeyLink struct {
 	Xps    int    `json:"xps"`
 }
 
+// graphSankeyQueryResult is a row returned by the query for the /graph/sankey
+// endpoint.
+type graphSankeyQueryResult struct {
+	Xps        float64  `ch:"xps"`
+	Dimensions []string `ch:"dimensions"`
+}
+
 // sankeyHandlerInputToSQL converts a sankey query to an SQL request
 func (input graphSankeyHandlerInput) toSQL() (string, error) {
 	where := templateWhere(input.Filter)
@@ -44,10 +57,10 @@ func (input graphSankeyHandlerInput) toSQL() (string, error) {
 	dimensions := []string{}
 	for _, column := range input.Dimensions {
 		arrayFields = append(arrayFields, fmt.Sprintf(`if(%s IN (SELECT %s FROM rows), %s, 'Other')`,
-			column.String(),
-			column.String(),
+			column.Identifier(),
+			column.Identifier(),
 			column.ToSQLSelect(input.schema)))
-		dimensions = append(dimensions, column.String())
+		dimensions = append(dimensions, column.Identifier())
 	}
 	fields := []string{
 		`{{ .Units }}/range AS xps`,
@@ -81,6 +94,7 @@ ORDER BY xps DESC
 			Start:             input.Start,
 			End:               input.End,
 			MainTableRequired: requireMainTable(input.schema, input.Dimensions, input.Filter),
+			Columns:           requiredColumns(input.schema, input.Dimensions, input.Filter),
 			Points:            20,
 			Units:             input.Units,
 		}),
@@ -88,9 +102,81 @@ ORDER BY xps DESC
 	return strings.TrimSpace(sqlQuery), nil
 }
 
+// nodesAndLinks builds the nodes and links of the sankey graph. Links are
+// computed for each pair of successive dimensions. Nodes carrying less than
+// the minimum share of the total traffic are merged into the "Other" node of
+// their dimension.
+func (input graphSankeyHandlerInput) nodesAndLinks(results []graphSankeyQueryResult) ([]string, []sankeyLink) {
+	nodes := []string{}
+	links := []sankeyLink{}
+
+	// Find the nodes to merge
+	merged := map[string]struct{}{}
+	if input.MinShare > 0 {
+		total := 0.
+		nodeXps := map[string]float64{}
+		for _, result := range results {
+			total += result.Xps
+			for i, name := range result.Dimensions {
+				nodeXps[fmt.Sprintf("%d-%s", i, name)] += result.Xps
+			}
+		}
+		for key, xps := range nodeXps {
+			if xps*100 < input.MinShare*total {
+				merged[key] = struct{}{}
+			}
+		}
+	}
+
+	completeName := func(name string, index int) string {
+		if _, ok := merged[fmt.Sprintf("%d-%s", index, name)]; ok {
+			name = "Other"
+		}
+		return fmt.Sprintf("%s: %s", input.Dimensions[index].String(), name)
+	}
+	addedNodes := map[string]struct{}{}
+	addNode := func(name string) {
+		if _, ok := addedNodes[name]; !ok {
+			addedNodes[name] = struct{}{}
+			nodes = append(nodes, name)
+		}
+	}
+	linkIndexes := map[[2]string]int{}
+	addLink := func(source, target string, xps int) {
+		if idx, ok := linkIndexes[[2]string{source, target}]; ok {
+			links[idx].Xps += xps
+			return
+		}
+		linkIndexes[[2]string{source, target}] = len(links)
+		links = append(links, sankeyLink{source, target, xps})
+	}
+	for _, result := range results {
+		// Consider each pair of successive dimensions
+		for i := range len(input.Dimensions) - 1 {
+			dimension1 := completeName(result.Dimensions[i], i)
+			dimension2 := completeName(result.Dimensions[i+1], i+1)
+			addNode(dimension1)
+			addNode(dimension2)
+			addLink(dimension1, dimension2, int(result.Xps))
+		}
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].Xps == links[j].Xps {
+			return links[i].Source < links[j].Source
+		}
+		return links[i].Xps > links[j].Xps
+	})
+	return nodes, links
+}
+
 func (c *Component) graphSankeyHandlerFunc(gc *gin.Context) {
 	ctx := c.t.Context(gc.Request.Context())
 	input := graphSankeyHandlerInput{graphCommonHandlerInput: graphCommonHandlerInput{schema: c.d.Schema}}
+	format, err := exportFormat(gc)
+	if err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
 	if err := gc.ShouldBindJSON(&input); err != nil {
 		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
 		return
@@ -103,12 +189,22 @@ func (c *Component) graphSankeyHandlerFunc(gc *gin.Context) {
 		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
 		return
 	}
+	if err := input.validateUnits(); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
 	if input.Limit > c.config.DimensionsLimit {
 		gc.JSON(http.StatusBadRequest,
 			gin.H{"message": fmt.Sprintf("Limit is set beyond maximum value (%d)",
 				c.config.DimensionsLimit)})
 		return
 	}
+	if len(input.Dimensions) > sankeyMaxDimensions {
+		gc.JSON(http.StatusBadRequest,
+			gin.H{"message": fmt.Sprintf("Sankey graphs accept up to %d dimensions",
+				sankeyMaxDimensions)})
+		return
+	}
 
 	sqlQuery, err := input.toSQL()
 	if err != nil {
@@ -119,10 +215,11 @@ func (c *Component) graphSankeyHandlerFunc(gc *gin.Context) {
 	// Prepare and execute query
 	sqlQuery = c.finalizeQuery(sqlQuery)
 	gc.Header("X-SQL-Query", strings.ReplaceAll(sqlQuery, "\n", "  "))
-	results := []struct {
-		Xps        float64  `ch:"xps"`
-		Dimensions []string `ch:"dimensions"`
-	}{}
+	if format == exportCSV {
+		c.graphSankeyExportCSV(gc, input, sqlQuery)
+		return
+	}
+	results := []graphSankeyQueryResult{}
 	if err := c.d.ClickHouseDB.Conn.Select(ctx, &results, sqlQuery); err != nil {
 		c.r.Err(err).Str("query", sqlQuery).Msg("unable to query database")
 		gc.JSON(http.StatusInternalServerError, gin.H{"message": "Unable to query database."})
@@ -131,48 +228,57 @@ func (c *Component) graphSankeyHandlerFunc(gc *gin.Context) {
 
 	// Prepare output
 	output := graphSankeyHandlerOutput{
-		Rows:  make([][]string, 0, len(results)),
-		Xps:   make([]int, 0, len(results)),
-		Nodes: make([]string, 0),
-		Links: make([]sankeyLink, 0),
-	}
-	completeName := func(name string, index int) string {
-		return fmt.Sprintf("%s: %s", input.Dimensions[index].String(), name)
-	}
-	addedNodes := map[string]struct{}{}
-	addNode := func(name string) {
-		if _, ok := addedNodes[name]; !ok {
-			addedNodes[name] = struct{}{}
-			output.Nodes = append(output.Nodes, name)
-		}
-	}
-	addLink := func(source, target string, xps int) {
-		for idx, link := range output.Links {
-			if link.Source == source && link.Target == target {
-				output.Links[idx].Xps += xps
-				return
-			}
-		}
-		output.Links = append(output.Links, sankeyLink{source, target, xps})
+		Rows: make([][]string, 0, len(results)),
+		Xps:  make([]int, 0, len(results)),
 	}
 	for _, result := range results {
 		output.Rows = append(output.Rows, result.Dimensions)
 		output.Xps = append(output.Xps, int(result.Xps))
-		// Consider each pair of successive dimensions
-		for i := range len(input.Dimensions) - 1 {
-			dimension1 := completeName(result.Dimensions[i], i)
-			dimension2 := completeName(result.Dimensions[i+1], i+1)
-			addNode(dimension1)
-			addNode(dimension2)
-			addLink(dimension1, dimension2, int(result.Xps))
-		}
 	}
-	sort.Slice(output.Links, func(i, j int) bool {
-		if output.Links[i].Xps == output.Links[j].Xps {
-			return output.Links[i].Source < output.Links[j].Source
-		}
-		return output.Links[i].Xps > output.Links[j].Xps
-	})
+	output.Nodes, output.Links = input.nodesAndLinks(results)
+	if input.Resolve {
+		output.Names = c.reverseDNS.resolve(&c.t,
+			addressesFromRows(input.schema, input.Dimensions, output.Rows))
+	}
 
 	gc.JSON(http.StatusOK, output)
 }
+
+// graphSankeyExportCSV streams the result of the /graph/sankey endpoint as
+// CSV. There is one record for each set of dimensions.
+func (c *Component) graphSankeyExportCSV(gc *gin.Context, input graphSankeyHandlerInput, sqlQuery string) {
+	ctx := c.t.Context(gc.Request.Context())
+	rows, err := c.d.ClickHouseDB.Conn.Query(ctx, sqlQuery)
+	if err != nil {
+		c.r.Err(err).Str("query", sqlQuery).Msg("unable to query database")
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "Unable to query database."})
+		return
+	}
+	defer rows.Close()
+
+	header := []string{}
+	for _, column := range input.Dimensions {
+		header = append(header, column.String())
+	}
+	header = append(header, input.Units)
+	export := newCSVExport(gc, header)
+	for rows.Next() {
+		var result graphSankeyQueryResult
+		if err := rows.ScanStruct(&result); err != nil {
+			c.r.Err(err).Msg("unable to parse row")
+			return
+		}
+		record := append(result.Dimensions, formatXps(result.Xps))
+		if err := export.write(record); err != nil {
+			c.r.Err(err).Msg("unable to export row")
+			return
+		}
+	}
+	if err := rows.Err(); err != nil {
+		c.r.Err(err).Str("query", sqlQuery).Msg("unable to query database")
+		return
+	}
+	if err := export.close(); err != nil {
+		c.r.Err(err).Msg("unable to export rows")
+	}
+}
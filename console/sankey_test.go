@@ -27,7 +27,7 @@ func TestSankeyQuerySQL(t *testing.T) {
 			Description: "two dimensions, no filters, l3 bps",
 			Pos:         helpers.Mark(),
 			Input: graphSankeyHandlerInput{
-				graphCommonHandlerInput{
+				graphCommonHandlerInput: graphCommonHandlerInput{
 					Start: time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
 					End:   time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
 					Dimensions: []query.Column{
@@ -40,7 +40,7 @@ func TestSankeyQuerySQL(t *testing.T) {
 				},
 			},
 			Expected: `
-{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","points":20,"units":"l3bps"}@@ }}
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","columns":["SrcAS","ExporterName"],"points":20,"units":"l3bps"}@@ }}
 WITH
  source AS (SELECT * FROM {{ .Table }} SETTINGS asterisk_include_alias_columns = 1),
  (SELECT MAX(TimeReceived) - MIN(TimeReceived) FROM source WHERE {{ .Timefilter }}) AS range,
@@ -58,7 +58,7 @@ ORDER BY xps DESC
 			Description: "two dimensions, no filters, l2 bps",
 			Pos:         helpers.Mark(),
 			Input: graphSankeyHandlerInput{
-				graphCommonHandlerInput{
+				graphCommonHandlerInput: graphCommonHandlerInput{
 					Start: time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
 					End:   time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
 					Dimensions: []query.Column{
@@ -71,7 +71,7 @@ ORDER BY xps DESC
 				},
 			},
 			Expected: `
-{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","points":20,"units":"l2bps"}@@ }}
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","columns":["SrcAS","ExporterName"],"points":20,"units":"l2bps"}@@ }}
 WITH
  source AS (SELECT * FROM {{ .Table }} SETTINGS asterisk_include_alias_columns = 1),
  (SELECT MAX(TimeReceived) - MIN(TimeReceived) FROM source WHERE {{ .Timefilter }}) AS range,
@@ -90,7 +90,7 @@ ORDER BY xps DESC
 			Description: "two dimensions, no filters, pps",
 			Pos:         helpers.Mark(),
 			Input: graphSankeyHandlerInput{
-				graphCommonHandlerInput{
+				graphCommonHandlerInput: graphCommonHandlerInput{
 					Start: time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
 					End:   time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
 					Dimensions: []query.Column{
@@ -103,7 +103,7 @@ ORDER BY xps DESC
 				},
 			},
 			Expected: `
-{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","points":20,"units":"pps"}@@ }}
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","columns":["SrcAS","ExporterName"],"points":20,"units":"pps"}@@ }}
 WITH
  source AS (SELECT * FROM {{ .Table }} SETTINGS asterisk_include_alias_columns = 1),
  (SELECT MAX(TimeReceived) - MIN(TimeReceived) FROM source WHERE {{ .Timefilter }}) AS range,
@@ -121,7 +121,7 @@ ORDER BY xps DESC
 			Description: "two dimensions, with filter",
 			Pos:         helpers.Mark(),
 			Input: graphSankeyHandlerInput{
-				graphCommonHandlerInput{
+				graphCommonHandlerInput: graphCommonHandlerInput{
 					Start: time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
 					End:   time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
 					Dimensions: []query.Column{
@@ -134,7 +134,7 @@ ORDER BY xps DESC
 				},
 			},
 			Expected: `
-{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","points":20,"units":"l3bps"}@@ }}
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","columns":["DstCountry","SrcCountry","SrcAS","ExporterName"],"points":20,"units":"l3bps"}@@ }}
 WITH
  source AS (SELECT * FROM {{ .Table }} SETTINGS asterisk_include_alias_columns = 1),
  (SELECT MAX(TimeReceived) - MIN(TimeReceived) FROM source WHERE {{ .Timefilter }} AND (DstCountry = 'FR')) AS range,
@@ -172,10 +172,7 @@ ORDER BY xps DESC
 func TestSankeyHandler(t *testing.T) {
 	_, h, mockConn, _ := NewMock(t, DefaultConfiguration())
 
-	expectedSQL := []struct {
-		Xps        float64  `ch:"xps"`
-		Dimensions []string `ch:"dimensions"`
-	}{
+	expectedSQL := []graphSankeyQueryResult{
 		// [(random.randrange(100, 10000), x)
 		//  for x in set([(random.choice(asn),
 		//                 random.choice(providers),
@@ -379,6 +376,74 @@ func TestSankeyHandler(t *testing.T) {
 					},
 				},
 			},
+		}, {
+			Description: "too many dimensions",
+			URL:         "/api/v0/console/graph/sankey",
+			JSONInput: gin.H{
+				"start": time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+				"end":   time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+				"dimensions": []string{
+					"InIfProvider", "SrcAS", "SrcCountry",
+					"DstCountry", "DstAS", "OutIfProvider",
+				},
+				"limit": 10,
+				"units": "l3bps",
+			},
+			StatusCode: 400,
+			JSONOutput: gin.H{"message": "Sankey graphs accept up to 5 dimensions"},
 		},
 	})
 }
+
+func TestSankeyNodesAndLinks(t *testing.T) {
+	input := graphSankeyHandlerInput{
+		graphCommonHandlerInput: graphCommonHandlerInput{
+			Dimensions: []query.Column{
+				query.NewColumn("InIfProvider"),
+				query.NewColumn("SrcAS"),
+				query.NewColumn("DstAS"),
+				query.NewColumn("OutIfProvider"),
+			},
+		},
+		MinShare: 5,
+	}
+	if err := query.Columns(input.Dimensions).Validate(schema.NewMock(t)); err != nil {
+		t.Fatalf("Validate() error:\n%+v", err)
+	}
+	results := []graphSankeyQueryResult{
+		{600, []string{"provider1", "AS100", "AS200", "provider2"}},
+		{350, []string{"provider1", "AS300", "AS200", "provider3"}},
+		{30, []string{"provider2", "AS400", "AS200", "provider2"}},
+		{20, []string{"provider3", "AS500", "AS600", "provider2"}},
+	}
+	nodes, links := input.nodesAndLinks(results)
+	expectedNodes := []string{
+		"InIfProvider: provider1",
+		"SrcAS: AS100",
+		"DstAS: AS200",
+		"OutIfProvider: provider2",
+		"SrcAS: AS300",
+		"OutIfProvider: provider3",
+		"InIfProvider: Other",
+		"SrcAS: Other",
+		"DstAS: Other",
+	}
+	expectedLinks := []sankeyLink{
+		{"DstAS: AS200", "OutIfProvider: provider2", 630},
+		{"InIfProvider: provider1", "SrcAS: AS100", 600},
+		{"SrcAS: AS100", "DstAS: AS200", 600},
+		{"DstAS: AS200", "OutIfProvider: provider3", 350},
+		{"InIfProvider: provider1", "SrcAS: AS300", 350},
+		{"SrcAS: AS300", "DstAS: AS200", 350},
+		{"InIfProvider: Other", "SrcAS: Other", 50},
+		{"SrcAS: Other", "DstAS: AS200", 30},
+		{"DstAS: Other", "OutIfProvider: provider2", 20},
+		{"SrcAS: Other", "DstAS: Other", 20},
+	}
+	if diff := helpers.Diff(nodes, expectedNodes); diff != "" {
+		t.Errorf("nodesAndLinks() nodes (-got, +want):\n%s", diff)
+	}
+	if diff := helpers.Diff(links, expectedLinks); diff != "" {
+		t.Errorf("nodesAndLinks() links (-got, +want):\n%s", diff)
+	}
+}
@@ -0,0 +1,46 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"akvorado/common/helpers"
+	"akvorado/console/authentication"
+	"akvorado/console/database"
+)
+
+func (c *Component) shareAddHandlerFunc(gc *gin.Context) {
+	ctx := c.t.Context(gc.Request.Context())
+	var link database.SharedLink
+	if err := gc.ShouldBindJSON(&link); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
+	link.User = gc.MustGet("user").(authentication.UserInformation).Login
+	id, err := c.d.Database.CreateSharedLink(ctx, link)
+	if err != nil {
+		c.r.Err(err).Msg("cannot create shared link")
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "cannot create shared link"})
+		return
+	}
+	gc.JSON(http.StatusCreated, gin.H{"id": id})
+}
+
+func (c *Component) shareGetHandlerFunc(gc *gin.Context) {
+	ctx := c.t.Context(gc.Request.Context())
+	link, err := c.d.Database.GetSharedLink(ctx, gc.Param("id"))
+	if errors.Is(err, database.ErrNotFound) {
+		gc.JSON(http.StatusNotFound, gin.H{"message": "shared link not found"})
+		return
+	} else if err != nil {
+		c.r.Err(err).Msg("unable to get shared link")
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "unable to get shared link"})
+		return
+	}
+	gc.JSON(http.StatusOK, gin.H{"state": link.State})
+}
@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"akvorado/common/helpers"
+)
+
+func TestShareHandlers(t *testing.T) {
+	_, h, _, _ := NewMock(t, DefaultConfiguration())
+	state := gin.H{
+		"graphType":  "stacked",
+		"humanStart": "24 hours ago",
+		"humanEnd":   "now",
+		"filter":     "InIfBoundary = external",
+	}
+
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			Description: "share a state",
+			URL:         "/api/v0/console/share",
+			StatusCode:  201,
+			JSONInput:   gin.H{"state": state},
+			JSONOutput:  gin.H{"id": "ZqrtfjAp1I2d"},
+		},
+		{
+			Description: "share without state",
+			URL:         "/api/v0/console/share",
+			StatusCode:  400,
+			JSONInput:   gin.H{},
+			JSONOutput: gin.H{
+				"message": "Key: 'SharedLink.State' Error:Field validation for 'State' failed on the 'required' tag",
+			},
+		},
+		{
+			Description: "get shared state",
+			URL:         "/api/v0/console/share/ZqrtfjAp1I2d",
+			JSONOutput:  gin.H{"state": state},
+		},
+		{
+			Description: "get unknown shared state",
+			URL:         "/api/v0/console/share/nothing",
+			StatusCode:  404,
+			JSONOutput:  gin.H{"message": "shared link not found"},
+		},
+	})
+}
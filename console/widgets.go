@@ -42,9 +42,9 @@ func (c *Component) widgetFlowLastHandlerFunc(gc *gin.Context) {
 	}
 	query := fmt.Sprintf(`
 %s
-FROM flows
-WHERE TimeReceived=(SELECT MAX(TimeReceived) FROM flows)
-LIMIT 1`, strings.Join(selectClause, ",\n "))
+FROM %[2]s
+WHERE TimeReceived=(SELECT MAX(TimeReceived) FROM %[2]s)
+LIMIT 1`, strings.Join(selectClause, ",\n "), c.d.ClickHouseDB.TableName("flows"))
 	gc.Header("X-SQL-Query", query)
 	// Do not increase counter for this one.
 	rows, err := c.d.ClickHouseDB.Conn.Query(ctx, query)
@@ -81,7 +81,8 @@ LIMIT 1`, strings.Join(selectClause, ",\n "))
 
 func (c *Component) widgetFlowRateHandlerFunc(gc *gin.Context) {
 	ctx := c.t.Context(gc.Request.Context())
-	query := `SELECT COUNT(*)/300 AS rate FROM flows WHERE TimeReceived > date_sub(minute, 5, now())`
+	query := fmt.Sprintf(`SELECT COUNT(*)/300 AS rate FROM %s WHERE TimeReceived > date_sub(minute, 5, now())`,
+		c.d.ClickHouseDB.TableName("flows"))
 	gc.Header("X-SQL-Query", query)
 	// Do not increase counter for this one.
 	var result float64
@@ -99,7 +100,8 @@ func (c *Component) widgetFlowRateHandlerFunc(gc *gin.Context) {
 
 func (c *Component) widgetExportersHandlerFunc(gc *gin.Context) {
 	ctx := c.t.Context(gc.Request.Context())
-	query := `SELECT ExporterName FROM exporters GROUP BY ExporterName ORDER BY ExporterName`
+	query := fmt.Sprintf(`SELECT ExporterName FROM %s GROUP BY ExporterName ORDER BY ExporterName`,
+		c.d.ClickHouseDB.TableName("exporters"))
 	gc.Header("X-SQL-Query", query)
 	// Do not increase counter for this one.
 
@@ -165,13 +167,16 @@ func (c *Component) widgetTopHandlerFunc(gc *gin.Context) {
 		groupby = `Proto, DstPort`
 		mainTableRequired = true
 	}
+	if groupby == "" {
+		groupby = selector
+	}
+	columns := strings.Split(groupby, ", ")
 	if strings.HasPrefix(gc.Param("name"), "src-") {
 		filter = "AND InIfBoundary = 'external'"
+		columns = append(columns, "InIfBoundary")
 	} else {
 		filter = "AND OutIfBoundary = 'external'"
-	}
-	if groupby == "" {
-		groupby = selector
+		columns = append(columns, "OutIfBoundary")
 	}
 
 	now := c.d.Clock.Now()
@@ -193,6 +198,7 @@ LIMIT 5
 			Start:             now.Add(-5 * time.Minute),
 			End:               now,
 			MainTableRequired: mainTableRequired,
+			Columns:           columns,
 			Points:            5,
 		}),
 		filter, selector, selector, filter, groupby))
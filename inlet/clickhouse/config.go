@@ -0,0 +1,38 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package clickhouse
+
+import (
+	"time"
+
+	"akvorado/common/clickhousedb"
+)
+
+// Configuration describes the configuration for the ClickHouse exporter.
+type Configuration struct {
+	clickhousedb.Configuration `mapstructure:",squash" yaml:"-,inline"`
+	// BatchSize is the maximum number of flows to insert in a single batch.
+	BatchSize int `validate:"min=1"`
+	// FlushInterval tells how often to insert pending flows, even when the
+	// batch is not full.
+	FlushInterval time.Duration `validate:"min=100ms"`
+	// QueueSize defines the number of flows waiting to be batched. When the
+	// queue is full, the inlet stops processing incoming flows.
+	QueueSize int `validate:"min=1"`
+	// MaxRetryInterval is the maximum time to wait between two attempts to
+	// insert a batch.
+	MaxRetryInterval time.Duration `validate:"min=100ms"`
+}
+
+// DefaultConfiguration represents the default configuration for the
+// ClickHouse exporter.
+func DefaultConfiguration() Configuration {
+	return Configuration{
+		Configuration:    clickhousedb.DefaultConfiguration(),
+		BatchSize:        100000,
+		FlushInterval:    5 * time.Second,
+		QueueSize:        100000,
+		MaxRetryInterval: 30 * time.Second,
+	}
+}
@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package clickhouse
+
+import "akvorado/common/reporter"
+
+type metrics struct {
+	insertedFlows   reporter.Counter
+	insertedBatches reporter.Counter
+	droppedFlows    reporter.Counter
+	errors          *reporter.CounterVec
+	queueSize       reporter.GaugeFunc
+}
+
+func (c *Component) initMetrics() {
+	c.metrics.insertedFlows = c.r.Counter(
+		reporter.CounterOpts{
+			Name: "inserted_flows_total",
+			Help: "Number of flows inserted into ClickHouse.",
+		},
+	)
+	c.metrics.insertedBatches = c.r.Counter(
+		reporter.CounterOpts{
+			Name: "inserted_batches_total",
+			Help: "Number of batches inserted into ClickHouse.",
+		},
+	)
+	c.metrics.droppedFlows = c.r.Counter(
+		reporter.CounterOpts{
+			Name: "dropped_flows_total",
+			Help: "Number of flows dropped while stopping.",
+		},
+	)
+	c.metrics.errors = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "errors_total",
+			Help: "Number of errors when inserting flows into ClickHouse.",
+		},
+		[]string{"error"},
+	)
+	c.metrics.queueSize = c.r.GaugeFunc(
+		reporter.GaugeOpts{
+			Name: "queue_size",
+			Help: "Number of flows waiting to be batched.",
+		},
+		func() float64 {
+			return float64(len(c.queue))
+		},
+	)
+}
@@ -0,0 +1,190 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Package clickhouse handles flow exports directly to ClickHouse, without
+// Kafka.
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"gopkg.in/tomb.v2"
+
+	"akvorado/common/clickhousedb"
+	"akvorado/common/daemon"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+)
+
+// shutdownTimeout is the maximum time to insert pending flows when stopping.
+const shutdownTimeout = 10 * time.Second
+
+// Component represents the ClickHouse exporter.
+type Component struct {
+	r      *reporter.Reporter
+	d      *Dependencies
+	t      tomb.Tomb
+	config Configuration
+
+	metrics   metrics
+	errLogger reporter.Logger
+
+	queue   chan []byte
+	table   string
+	decoder *rowDecoder
+}
+
+// Dependencies define the dependencies of the ClickHouse exporter.
+type Dependencies struct {
+	Daemon     daemon.Component
+	ClickHouse *clickhousedb.Component
+	Schema     *schema.Component
+}
+
+// New creates a new ClickHouse exporter component.
+func New(r *reporter.Reporter, configuration Configuration, dependencies Dependencies) (*Component, error) {
+	decoder, err := newRowDecoder(dependencies.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("cannot build rows for ClickHouse: %w", err)
+	}
+	table := dependencies.ClickHouse.TableName(
+		fmt.Sprintf("flows_%s_raw", dependencies.Schema.ProtobufMessageHash()))
+	c := Component{
+		r:      r,
+		d:      &dependencies,
+		config: configuration,
+
+		errLogger: r.Sample(reporter.BurstSampler(30*time.Second, 3)),
+		queue:     make(chan []byte, configuration.QueueSize),
+		table:     table,
+		decoder:   decoder,
+	}
+	c.initMetrics()
+	c.d.Daemon.Track(&c.t, "inlet/clickhouse")
+	return &c, nil
+}
+
+// Start starts the ClickHouse exporter component.
+func (c *Component) Start() error {
+	c.r.Info().Msg("starting ClickHouse exporter")
+	c.t.Go(c.run)
+	return nil
+}
+
+// Stop stops the ClickHouse exporter component. Pending flows are inserted.
+func (c *Component) Stop() error {
+	defer c.r.Info().Msg("ClickHouse exporter stopped")
+	c.r.Info().Msg("stopping ClickHouse exporter")
+	c.t.Kill(nil)
+	return c.t.Wait()
+}
+
+// Send queues a flow to be inserted into ClickHouse. The payload is a flow
+// encoded with ProtobufMarshal. This blocks when the queue is full.
+func (c *Component) Send(exporter string, payload []byte) {
+	select {
+	case c.queue <- payload:
+	case <-c.t.Dying():
+		c.metrics.droppedFlows.Inc()
+	}
+}
+
+// run batches the queued flows and inserts them into ClickHouse.
+func (c *Component) run() error {
+	ticker := time.NewTicker(c.config.FlushInterval)
+	defer ticker.Stop()
+	rows := make([][]any, 0, c.config.BatchSize)
+	add := func(payload []byte) {
+		row, err := c.decoder.decode(payload)
+		if err != nil {
+			c.errLogger.Err(err).Msg("cannot decode flow")
+			c.metrics.errors.WithLabelValues("decode").Inc()
+			return
+		}
+		rows = append(rows, row)
+	}
+	for {
+		select {
+		case <-c.t.Dying():
+			// Insert what is left in the queue with a single attempt.
+			for {
+				select {
+				case payload := <-c.queue:
+					add(payload)
+					continue
+				default:
+				}
+				break
+			}
+			if len(rows) == 0 {
+				return nil
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			if err := c.insert(ctx, rows); err != nil {
+				c.r.Err(err).Int("flows", len(rows)).Msg("cannot insert pending flows into ClickHouse")
+				c.metrics.droppedFlows.Add(float64(len(rows)))
+			}
+			return nil
+		case payload := <-c.queue:
+			add(payload)
+			if len(rows) < c.config.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(rows) == 0 {
+				continue
+			}
+		}
+		c.insertWithRetry(rows)
+		rows = rows[:0]
+		ticker.Reset(c.config.FlushInterval)
+	}
+}
+
+// insertWithRetry inserts the provided rows, retrying until it succeeds or
+// the component is stopped. While retrying, the queue fills up and the
+// inlet stops processing incoming flows.
+func (c *Component) insertWithRetry(rows [][]any) {
+	customBackoff := backoff.NewExponentialBackOff()
+	customBackoff.MaxElapsedTime = 0
+	customBackoff.MaxInterval = c.config.MaxRetryInterval
+	for {
+		err := c.insert(c.t.Context(nil), rows)
+		if err == nil {
+			return
+		}
+		c.errLogger.Err(err).Int("flows", len(rows)).Msg("cannot insert flows into ClickHouse")
+		c.metrics.errors.WithLabelValues("insert").Inc()
+		next := customBackoff.NextBackOff()
+		select {
+		case <-c.t.Dying():
+			c.metrics.droppedFlows.Add(float64(len(rows)))
+			return
+		case <-time.After(next):
+		}
+	}
+}
+
+// insert inserts the provided rows into the raw flows table in a single batch.
+func (c *Component) insert(ctx context.Context, rows [][]any) error {
+	batch, err := c.d.ClickHouse.PrepareBatch(ctx, fmt.Sprintf("INSERT INTO %s", c.table))
+	if err != nil {
+		return fmt.Errorf("cannot prepare batch: %w", err)
+	}
+	for _, row := range rows {
+		if err := batch.Append(row...); err != nil {
+			batch.Abort()
+			return fmt.Errorf("cannot append flow to batch: %w", err)
+		}
+	}
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("cannot send batch: %w", err)
+	}
+	c.metrics.insertedBatches.Inc()
+	c.metrics.insertedFlows.Add(float64(len(rows)))
+	return nil
+}
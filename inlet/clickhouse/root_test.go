@@ -0,0 +1,143 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package clickhouse
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/mock/gomock"
+
+	"akvorado/common/clickhousedb"
+	"akvorado/common/clickhousedb/mocks"
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+)
+
+func TestInsert(t *testing.T) {
+	r := reporter.NewMock(t)
+	sch := schema.NewMock(t)
+	chComponent, mockConn := clickhousedb.NewMock(t, r)
+	config := DefaultConfiguration()
+	config.BatchSize = 3
+	config.FlushInterval = 100 * time.Millisecond
+	config.MaxRetryInterval = 100 * time.Millisecond
+	c, err := New(r, config, Dependencies{
+		Daemon:     daemon.NewMock(t),
+		ClickHouse: chComponent,
+		Schema:     sch,
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	helpers.StartStop(t, c)
+
+	ctrl := gomock.NewController(t)
+	query := fmt.Sprintf("INSERT INTO flows_%s_raw", sch.ProtobufMessageHash())
+	sent := make(chan int, 10)
+	newBatch := func() *mocks.MockBatch {
+		batch := mocks.NewMockBatch(ctrl)
+		appended := 0
+		batch.EXPECT().Append(gomock.Any()).DoAndReturn(func(v ...any) error {
+			if len(v) != len(c.decoder.columns) {
+				return fmt.Errorf("got %d values, expected %d", len(v), len(c.decoder.columns))
+			}
+			appended++
+			return nil
+		}).AnyTimes()
+		batch.EXPECT().Send().DoAndReturn(func() error {
+			sent <- appended
+			return nil
+		})
+		return batch
+	}
+	flow := func() []byte {
+		return sch.ProtobufMarshal(&schema.FlowMessage{SrcAS: 65000})
+	}
+
+	// First attempt fails, second one succeeds with a full batch.
+	gomock.InOrder(
+		mockConn.EXPECT().PrepareBatch(gomock.Any(), query).
+			Return(nil, errors.New("connection refused")),
+		mockConn.EXPECT().PrepareBatch(gomock.Any(), query).
+			Return(newBatch(), nil),
+		mockConn.EXPECT().PrepareBatch(gomock.Any(), query).
+			Return(newBatch(), nil),
+	)
+	for range 3 {
+		c.Send("127.0.0.1", flow())
+	}
+	select {
+	case n := <-sent:
+		if n != 3 {
+			t.Fatalf("Send() inserted %d flows, expected 3", n)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Send() did not insert the first batch")
+	}
+
+	// A partial batch is inserted after the flush interval.
+	c.Send("127.0.0.1", flow())
+	select {
+	case n := <-sent:
+		if n != 1 {
+			t.Fatalf("Send() inserted %d flows, expected 1", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Send() did not insert the second batch")
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_clickhouse_", "inserted_", "errors_")
+	expectedMetrics := map[string]string{
+		`inserted_batches_total`:       "2",
+		`inserted_flows_total`:         "4",
+		`errors_total{error="insert"}`: "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestInsertOnStop(t *testing.T) {
+	r := reporter.NewMock(t)
+	sch := schema.NewMock(t)
+	chComponent, mockConn := clickhousedb.NewMock(t, r)
+	c, err := New(r, DefaultConfiguration(), Dependencies{
+		Daemon:     daemon.NewMock(t),
+		ClickHouse: chComponent,
+		Schema:     sch,
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	if err := c.Start(); err != nil {
+		t.Fatalf("Start() error:\n%+v", err)
+	}
+
+	ctrl := gomock.NewController(t)
+	batch := mocks.NewMockBatch(ctrl)
+	batch.EXPECT().Append(gomock.Any()).Return(nil).Times(2)
+	batch.EXPECT().Send().Return(nil)
+	mockConn.EXPECT().
+		PrepareBatch(gomock.Any(), fmt.Sprintf("INSERT INTO flows_%s_raw", sch.ProtobufMessageHash())).
+		Return(batch, nil)
+
+	c.Send("127.0.0.1", sch.ProtobufMarshal(&schema.FlowMessage{SrcAS: 65000}))
+	c.Send("127.0.0.1", sch.ProtobufMarshal(&schema.FlowMessage{SrcAS: 65001}))
+	if err := c.Stop(); err != nil {
+		t.Fatalf("Stop() error:\n%+v", err)
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_clickhouse_", "inserted_flows_")
+	expectedMetrics := map[string]string{
+		`inserted_flows_total`: "2",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
@@ -0,0 +1,184 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package clickhouse
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"akvorado/common/schema"
+)
+
+// columnKind tells how to convert a protobuf value for a ClickHouse column.
+type columnKind int
+
+const (
+	kindUInt8 columnKind = iota
+	kindUInt16
+	kindUInt32
+	kindUInt64
+	kindDateTime
+	kindEnum8
+	kindString
+	kindIPv6
+	kindArrayUInt32
+)
+
+// rowColumn is a column of the raw flows table.
+type rowColumn struct {
+	name string
+	kind columnKind
+}
+
+// rowDecoder turns flows encoded with ProtobufMarshal into rows for the raw
+// flows table.
+type rowDecoder struct {
+	columns []rowColumn
+	// index maps a protobuf field number to a position in columns.
+	index map[protowire.Number]int
+}
+
+// newRowDecoder builds a row decoder for the raw flows table of the provided
+// schema. The columns are in the same order as in the table.
+func newRowDecoder(sch *schema.Component) (*rowDecoder, error) {
+	d := rowDecoder{index: map[protowire.Number]int{}}
+	for _, column := range sch.ClickHouseColumns(
+		schema.ClickHouseSkipGeneratedColumns,
+		schema.ClickHouseUseTransformFromType,
+		schema.ClickHouseSkipAliasedColumns) {
+		kind, err := kindFromType(column.ClickHouseType)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", column.Name, err)
+		}
+		// Columns using the type of a TransformFrom column have the
+		// protobuf index of the parent column. Lookup the real one.
+		if actual, ok := sch.LookupColumnByName(column.Name); ok && actual.ProtobufIndex > 0 {
+			d.index[actual.ProtobufIndex] = len(d.columns)
+		}
+		d.columns = append(d.columns, rowColumn{name: column.Name, kind: kind})
+	}
+	return &d, nil
+}
+
+// kindFromType returns the kind of conversion needed for a ClickHouse type.
+func kindFromType(t string) (columnKind, error) {
+	if inner, ok := strings.CutPrefix(t, "LowCardinality("); ok {
+		t = strings.TrimSuffix(inner, ")")
+	}
+	switch {
+	case t == "UInt8":
+		return kindUInt8, nil
+	case t == "UInt16":
+		return kindUInt16, nil
+	case t == "UInt32":
+		return kindUInt32, nil
+	case t == "UInt64":
+		return kindUInt64, nil
+	case t == "DateTime":
+		return kindDateTime, nil
+	case strings.HasPrefix(t, "Enum8("):
+		return kindEnum8, nil
+	case t == "String", strings.HasPrefix(t, "FixedString("):
+		return kindString, nil
+	case t == "IPv6":
+		return kindIPv6, nil
+	case t == "Array(UInt32)":
+		return kindArrayUInt32, nil
+	}
+	return 0, fmt.Errorf("unsupported ClickHouse type %q", t)
+}
+
+// zero returns the zero value for a column kind.
+func (k columnKind) zero() any {
+	switch k {
+	case kindUInt8:
+		return uint8(0)
+	case kindUInt16:
+		return uint16(0)
+	case kindUInt32:
+		return uint32(0)
+	case kindUInt64:
+		return uint64(0)
+	case kindDateTime:
+		return time.Unix(0, 0)
+	case kindEnum8:
+		return int8(0)
+	case kindString:
+		return ""
+	case kindIPv6:
+		return netip.IPv6Unspecified()
+	case kindArrayUInt32:
+		return []uint32{}
+	}
+	return nil
+}
+
+// decode turns a flow encoded with ProtobufMarshal into a row. Missing values
+// are replaced by a zero value.
+func (d *rowDecoder) decode(input []byte) ([]any, error) {
+	size, n := protowire.ConsumeVarint(input)
+	if n < 0 {
+		return nil, protowire.ParseError(n)
+	}
+	if uint64(len(input)-n) != size {
+		return nil, fmt.Errorf("bad length for protobuf message: %d != %d", len(input)-n, size)
+	}
+	row := make([]any, len(d.columns))
+	for idx, column := range d.columns {
+		row[idx] = column.kind.zero()
+	}
+	b := input[n:]
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		value := b[:n]
+		b = b[n:]
+		idx, ok := d.index[num]
+		if !ok {
+			continue
+		}
+		switch typ {
+		case protowire.VarintType:
+			varint, _ := protowire.ConsumeVarint(value)
+			switch d.columns[idx].kind {
+			case kindUInt8:
+				row[idx] = uint8(varint)
+			case kindUInt16:
+				row[idx] = uint16(varint)
+			case kindUInt32:
+				row[idx] = uint32(varint)
+			case kindUInt64:
+				row[idx] = varint
+			case kindDateTime:
+				row[idx] = time.Unix(int64(varint), 0)
+			case kindEnum8:
+				row[idx] = int8(varint)
+			case kindArrayUInt32:
+				row[idx] = append(row[idx].([]uint32), uint32(varint))
+			}
+		case protowire.BytesType:
+			bytes, _ := protowire.ConsumeBytes(value)
+			switch d.columns[idx].kind {
+			case kindString:
+				row[idx] = string(bytes)
+			case kindIPv6:
+				if ip, ok := netip.AddrFromSlice(bytes); ok {
+					row[idx] = netip.AddrFrom16(ip.As16())
+				}
+			}
+		}
+	}
+	return row, nil
+}
@@ -0,0 +1,110 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package clickhouse
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"akvorado/common/helpers"
+	"akvorado/common/schema"
+)
+
+func TestKindFromType(t *testing.T) {
+	cases := []struct {
+		Type     string
+		Expected columnKind
+		Error    bool
+	}{
+		{"UInt8", kindUInt8, false},
+		{"UInt64", kindUInt64, false},
+		{"LowCardinality(String)", kindString, false},
+		{"FixedString(2)", kindString, false},
+		{"LowCardinality(IPv6)", kindIPv6, false},
+		{"Enum8('unknown' = 0, 'valid' = 1)", kindEnum8, false},
+		{"Array(UInt32)", kindArrayUInt32, false},
+		{"Array(UInt128)", 0, true},
+		{"Float64", 0, true},
+	}
+	for _, tc := range cases {
+		got, err := kindFromType(tc.Type)
+		if err != nil && !tc.Error {
+			t.Errorf("kindFromType(%q) error:\n%+v", tc.Type, err)
+		} else if err == nil && tc.Error {
+			t.Errorf("kindFromType(%q) did not error", tc.Type)
+		} else if got != tc.Expected {
+			t.Errorf("kindFromType(%q) == %d, expected %d", tc.Type, got, tc.Expected)
+		}
+	}
+}
+
+func TestRowDecoder(t *testing.T) {
+	sch := schema.NewMock(t)
+	decoder, err := newRowDecoder(sch)
+	if err != nil {
+		t.Fatalf("newRowDecoder() error:\n%+v", err)
+	}
+	expectedColumns := sch.ClickHouseSelectColumns(
+		schema.ClickHouseSkipGeneratedColumns,
+		schema.ClickHouseUseTransformFromType,
+		schema.ClickHouseSkipAliasedColumns)
+	gotColumns := []string{}
+	for _, column := range decoder.columns {
+		gotColumns = append(gotColumns, column.name)
+	}
+	if diff := helpers.Diff(gotColumns, expectedColumns); diff != "" {
+		t.Fatalf("newRowDecoder() columns (-got, +want):\n%s", diff)
+	}
+
+	bf := &schema.FlowMessage{
+		TimeReceived:    1700000000,
+		SamplingRate:    1000,
+		ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.1"),
+		SrcAddr:         netip.MustParseAddr("2001:db8::1"),
+		DstAddr:         netip.MustParseAddr("::ffff:198.51.100.1"),
+		SrcAS:           65000,
+		DstASPath:       []uint32{65001, 65002},
+	}
+	sch.ProtobufAppendVarint(bf, schema.ColumnBytes, 1500)
+	sch.ProtobufAppendBytes(bf, schema.ColumnExporterName, []byte("router1"))
+	sch.ProtobufAppendVarint(bf, schema.ColumnInIfBoundary, uint64(schema.InterfaceBoundaryExternal))
+	sch.ProtobufAppendVarint(bf, schema.ColumnDstLargeCommunitiesASN, 65003)
+	row, err := decoder.decode(sch.ProtobufMarshal(bf))
+	if err != nil {
+		t.Fatalf("decode() error:\n%+v", err)
+	}
+
+	got := map[string]any{}
+	for idx, column := range decoder.columns {
+		got[column.name] = row[idx]
+	}
+	expected := map[string]any{
+		"TimeReceived":                  time.Unix(1700000000, 0),
+		"SamplingRate":                  uint64(1000),
+		"ExporterAddress":               netip.MustParseAddr("::ffff:192.0.2.1"),
+		"ExporterName":                  "router1",
+		"SrcAddr":                       netip.MustParseAddr("2001:db8::1"),
+		"DstAddr":                       netip.MustParseAddr("::ffff:198.51.100.1"),
+		"SrcAS":                         uint32(65000),
+		"DstAS":                         uint32(0),
+		"DstASPath":                     []uint32{65001, 65002},
+		"DstCommunities":                []uint32{},
+		"DstLargeCommunitiesASN":        []uint32{65003},
+		"DstLargeCommunitiesLocalData1": []uint32{},
+		"InIfBoundary":                  int8(schema.InterfaceBoundaryExternal),
+		"OutIfBoundary":                 int8(schema.InterfaceBoundaryUndefined),
+		"Bytes":                         uint64(1500),
+		"Packets":                       uint64(0),
+	}
+	for name, value := range expected {
+		if diff := helpers.Diff(got[name], value); diff != "" {
+			t.Errorf("decode() %s (-got, +want):\n%s", name, diff)
+		}
+	}
+
+	if _, err := decoder.decode([]byte{10, 1, 2}); err == nil {
+		t.Error("decode() did not error on a truncated message")
+	}
+}
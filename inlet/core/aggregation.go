@@ -0,0 +1,217 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"errors"
+	"slices"
+	"sync"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"akvorado/common/schema"
+)
+
+// aggregator merges flows sharing the same dimensions during a time window.
+// It works on serialized flows: counters (bytes and packets), the sampling
+// rate and the reception time are extracted and everything else is used as
+// the aggregation key.
+type aggregator struct {
+	lock       sync.Mutex
+	maxEntries int
+	entries    map[string]*aggregatedFlow
+	added      int // number of flows added since the last flush
+
+	timeReceived protowire.Number
+	samplingRate protowire.Number
+	bytes        protowire.Number
+	packets      protowire.Number
+	dropped      []protowire.Number
+}
+
+// aggregatedFlow is a flow resulting from the aggregation of one or several
+// flows.
+type aggregatedFlow struct {
+	exporter     string
+	topic        string
+	partitionKey []byte
+	timeReceived uint64
+	samplingRate uint64
+	bytes        uint64
+	packets      uint64
+	key          []byte
+}
+
+// newAggregator creates a new aggregator. The provided columns are removed
+// from flows before aggregation.
+func newAggregator(sch *schema.Component, maxEntries uint, drop []schema.ColumnKey) *aggregator {
+	index := func(key schema.ColumnKey) protowire.Number {
+		column, _ := sch.LookupColumnByKey(key)
+		return column.ProtobufIndex
+	}
+	a := aggregator{
+		maxEntries:   int(maxEntries),
+		entries:      map[string]*aggregatedFlow{},
+		timeReceived: index(schema.ColumnTimeReceived),
+		samplingRate: index(schema.ColumnSamplingRate),
+		bytes:        index(schema.ColumnBytes),
+		packets:      index(schema.ColumnPackets),
+	}
+	for _, key := range drop {
+		if column, ok := sch.LookupColumnByKey(key); ok && column.ProtobufIndex > 0 {
+			a.dropped = append(a.dropped, column.ProtobufIndex)
+		}
+	}
+	return &a
+}
+
+// add adds a serialized flow (as returned by ProtobufMarshal) to be sent to
+// the provided Kafka topic with the provided partition key. It returns false if the flow cannot be aggregated
+// because the aggregator is full or because the flow cannot be parsed. In
+// this case, the flow should be sent as is.
+func (a *aggregator) add(exporter string, topic string, partitionKey []byte, buf []byte) bool {
+	flow, err := a.parse(buf)
+	if err != nil {
+		return false
+	}
+	flow.exporter = exporter
+	flow.topic = topic
+	flow.partitionKey = partitionKey
+	mapKey := exporter + "\x00" + topic + "\x00" + string(partitionKey) + "\x00" + string(flow.key)
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	entry, ok := a.entries[mapKey]
+	if !ok {
+		if len(a.entries) >= a.maxEntries {
+			return false
+		}
+		a.entries[mapKey] = flow
+		a.added++
+		return true
+	}
+	if entry.samplingRate != flow.samplingRate {
+		// Normalize both flows to a sampling rate of 1 to keep the
+		// totals exact.
+		entry.bytes *= entry.samplingRate
+		entry.packets *= entry.samplingRate
+		entry.samplingRate = 1
+		flow.bytes *= flow.samplingRate
+		flow.packets *= flow.samplingRate
+	}
+	entry.bytes += flow.bytes
+	entry.packets += flow.packets
+	a.added++
+	return true
+}
+
+// flush returns all aggregated flows and the number of flows they were built
+// from. The aggregator is emptied.
+func (a *aggregator) flush() ([]*aggregatedFlow, int) {
+	a.lock.Lock()
+	entries := a.entries
+	added := a.added
+	a.entries = make(map[string]*aggregatedFlow, len(entries))
+	a.added = 0
+	a.lock.Unlock()
+	flows := make([]*aggregatedFlow, 0, len(entries))
+	for _, entry := range entries {
+		flows = append(flows, entry)
+	}
+	return flows, added
+}
+
+// flushAggregator sends the aggregated flows to Kafka or ClickHouse.
+func (c *Component) flushAggregator() {
+	flows, added := c.aggregator.flush()
+	for _, flow := range flows {
+		c.metrics.flowsForwarded.WithLabelValues(flow.exporter).Inc()
+		c.send(flow.exporter, flow.topic, flow.partitionKey, c.aggregator.marshal(flow))
+	}
+	c.metrics.aggregationOutputFlows.Add(float64(len(flows)))
+	if len(flows) > 0 {
+		c.metrics.aggregationRatio.Set(float64(added) / float64(len(flows)))
+	}
+}
+
+// size returns the number of aggregated flows.
+func (a *aggregator) size() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return len(a.entries)
+}
+
+// parse extracts counters and key from a serialized flow.
+func (a *aggregator) parse(buf []byte) (*aggregatedFlow, error) {
+	length, n := protowire.ConsumeVarint(buf)
+	if n < 0 {
+		return nil, protowire.ParseError(n)
+	}
+	buf = buf[n:]
+	if uint64(len(buf)) != length {
+		return nil, errors.New("invalid length")
+	}
+	flow := aggregatedFlow{
+		samplingRate: 1,
+		key:          make([]byte, 0, len(buf)),
+	}
+	for len(buf) > 0 {
+		num, typ, n := protowire.ConsumeTag(buf)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		m := protowire.ConsumeFieldValue(num, typ, buf[n:])
+		if m < 0 {
+			return nil, protowire.ParseError(m)
+		}
+		field := buf[:n+m]
+		buf = buf[n+m:]
+		var target *uint64
+		switch num {
+		case a.timeReceived:
+			target = &flow.timeReceived
+		case a.samplingRate:
+			target = &flow.samplingRate
+		case a.bytes:
+			target = &flow.bytes
+		case a.packets:
+			target = &flow.packets
+		default:
+			if !slices.Contains(a.dropped, num) {
+				flow.key = append(flow.key, field...)
+			}
+			continue
+		}
+		if typ != protowire.VarintType {
+			return nil, errors.New("unexpected type for counter")
+		}
+		*target, _ = protowire.ConsumeVarint(field[n:])
+	}
+	if flow.samplingRate == 0 {
+		flow.samplingRate = 1
+	}
+	return &flow, nil
+}
+
+// marshal serializes an aggregated flow with a length prefix, like
+// ProtobufMarshal.
+func (a *aggregator) marshal(flow *aggregatedFlow) []byte {
+	payload := make([]byte, 0, len(flow.key)+4*(protowire.SizeVarint(flow.bytes)+2))
+	for _, field := range []struct {
+		num   protowire.Number
+		value uint64
+	}{
+		{a.timeReceived, flow.timeReceived},
+		{a.samplingRate, flow.samplingRate},
+		{a.bytes, flow.bytes},
+		{a.packets, flow.packets},
+	} {
+		payload = protowire.AppendTag(payload, field.num, protowire.VarintType)
+		payload = protowire.AppendVarint(payload, field.value)
+	}
+	payload = append(payload, flow.key...)
+	result := make([]byte, 0, len(payload)+protowire.SizeVarint(uint64(len(payload))))
+	result = protowire.AppendVarint(result, uint64(len(payload)))
+	return append(result, payload...)
+}
@@ -0,0 +1,134 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"cmp"
+	"net/netip"
+	"slices"
+	"testing"
+
+	"akvorado/common/helpers"
+	"akvorado/common/schema"
+)
+
+func TestAggregator(t *testing.T) {
+	sch := schema.NewMock(t)
+	flow := func(samplingRate uint32, srcPort uint64, bytes, packets uint64) []byte {
+		bf := &schema.FlowMessage{
+			TimeReceived:    1000,
+			SamplingRate:    samplingRate,
+			ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.1"),
+			SrcAddr:         netip.MustParseAddr("::ffff:198.51.100.1"),
+			DstAddr:         netip.MustParseAddr("::ffff:203.0.113.1"),
+		}
+		sch.ProtobufAppendVarint(bf, schema.ColumnBytes, bytes)
+		sch.ProtobufAppendVarint(bf, schema.ColumnPackets, packets)
+		sch.ProtobufAppendVarint(bf, schema.ColumnDstPort, 443)
+		sch.ProtobufAppendVarint(bf, schema.ColumnSrcPort, srcPort)
+		return sch.ProtobufMarshal(bf)
+	}
+	decode := func(a *aggregator) []*schema.FlowMessage {
+		flows, _ := a.flush()
+		slices.SortFunc(flows, func(x, y *aggregatedFlow) int {
+			return cmp.Compare(x.bytes, y.bytes)
+		})
+		got := []*schema.FlowMessage{}
+		for _, f := range flows {
+			got = append(got, sch.ProtobufDecode(t, a.marshal(f)))
+		}
+		return got
+	}
+	expected := func(samplingRate uint32, srcPort uint64, bytes, packets uint64) *schema.FlowMessage {
+		bf := &schema.FlowMessage{
+			TimeReceived:    1000,
+			SamplingRate:    samplingRate,
+			ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.1"),
+			SrcAddr:         netip.MustParseAddr("::ffff:198.51.100.1"),
+			DstAddr:         netip.MustParseAddr("::ffff:203.0.113.1"),
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:   bytes,
+				schema.ColumnPackets: packets,
+				schema.ColumnDstPort: uint64(443),
+			},
+		}
+		if srcPort != 0 {
+			bf.ProtobufDebug[schema.ColumnSrcPort] = srcPort
+		}
+		return bf
+	}
+
+	t.Run("merge", func(t *testing.T) {
+		a := newAggregator(sch, 10, nil)
+		for _, buf := range [][]byte{
+			flow(100, 34567, 1000, 1),
+			flow(100, 34567, 2000, 2),
+			flow(100, 34568, 500, 1),
+		} {
+			if !a.add("192.0.2.1", "flows", nil, buf) {
+				t.Fatal("add() == false, expected true")
+			}
+		}
+		if got := a.size(); got != 2 {
+			t.Errorf("size() = %d, expected 2", got)
+		}
+		got := decode(a)
+		if diff := helpers.Diff(got, []*schema.FlowMessage{
+			expected(100, 34568, 500, 1),
+			expected(100, 34567, 3000, 3),
+		}); diff != "" {
+			t.Errorf("flush() (-got, +want):\n%s", diff)
+		}
+		if got := a.size(); got != 0 {
+			t.Errorf("size() after flush = %d, expected 0", got)
+		}
+	})
+
+	t.Run("different sampling rates", func(t *testing.T) {
+		a := newAggregator(sch, 10, nil)
+		a.add("192.0.2.1", "flows", nil, flow(100, 34567, 1000, 1))
+		a.add("192.0.2.1", "flows", nil, flow(10, 34567, 2000, 2))
+		got := decode(a)
+		if diff := helpers.Diff(got, []*schema.FlowMessage{
+			expected(1, 34567, 120000, 120),
+		}); diff != "" {
+			t.Errorf("flush() (-got, +want):\n%s", diff)
+		}
+	})
+
+	t.Run("drop columns", func(t *testing.T) {
+		a := newAggregator(sch, 10, []schema.ColumnKey{schema.ColumnSrcPort})
+		a.add("192.0.2.1", "flows", nil, flow(100, 34567, 1000, 1))
+		a.add("192.0.2.1", "flows", nil, flow(100, 34568, 500, 1))
+		got := decode(a)
+		if diff := helpers.Diff(got, []*schema.FlowMessage{
+			expected(100, 0, 1500, 2),
+		}); diff != "" {
+			t.Errorf("flush() (-got, +want):\n%s", diff)
+		}
+	})
+
+	t.Run("overflow", func(t *testing.T) {
+		a := newAggregator(sch, 1, nil)
+		if !a.add("192.0.2.1", "flows", nil, flow(100, 34567, 1000, 1)) {
+			t.Error("add() == false, expected true")
+		}
+		if !a.add("192.0.2.1", "flows", nil, flow(100, 34567, 1000, 1)) {
+			t.Error("add() == false for existing entry, expected true")
+		}
+		if a.add("192.0.2.1", "flows", nil, flow(100, 34568, 1000, 1)) {
+			t.Error("add() == true when full, expected false")
+		}
+		if _, added := a.flush(); added != 2 {
+			t.Errorf("flush() added = %d, expected 2", added)
+		}
+	})
+
+	t.Run("invalid flow", func(t *testing.T) {
+		a := newAggregator(sch, 10, nil)
+		if a.add("192.0.2.1", "flows", nil, []byte{10, 1}) {
+			t.Error("add() == true for invalid flow, expected false")
+		}
+	})
+}
@@ -5,7 +5,9 @@ package core
 
 import (
 	"fmt"
+	"net/netip"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -29,8 +31,9 @@ type ExporterClassifierRule struct {
 
 // exporterInfo contains the information we want to expose about a exporter.
 type exporterInfo struct {
-	IP   string
-	Name string
+	IP       string
+	Name     string
+	Location string // only for exporter classifiers
 }
 
 // exporterClassification contains the information about an exporter classification
@@ -51,6 +54,11 @@ type (
 // exporterClassifierEnvironment defines the environment used by the exporter classifier
 type exporterClassifierEnvironment struct {
 	Format              func(string, ...any) string
+	RegexMatch          func(string, string) (bool, error)
+	RegexReplace        func(string, string, string) (string, error)
+	Lower               func(string) string
+	Upper               func(string) string
+	EqualFold           func(string, string) bool
 	Exporter            exporterInfo
 	Classify            classifyStringFunc
 	ClassifyRegex       classifyStringRegexFunc
@@ -71,6 +79,25 @@ func format(format string, a ...any) string {
 	return fmt.Sprintf(format, a...)
 }
 
+// regexMatch tells if the provided string matches the provided regex.
+func regexMatch(str string, regex string) (bool, error) {
+	compiledRegex, err := compileRegex(regex)
+	if err != nil {
+		return false, err
+	}
+	return compiledRegex.MatchString(str), nil
+}
+
+// regexReplace replaces the matches of the provided regex in the provided
+// string with the provided template.
+func regexReplace(str string, regex string, template string) (string, error) {
+	compiledRegex, err := compileRegex(regex)
+	if err != nil {
+		return "", err
+	}
+	return compiledRegex.ReplaceAllString(str, template), nil
+}
+
 // exec executes the exporter classifier with the provided exporter.
 func (scr *ExporterClassifierRule) exec(si exporterInfo, ec *exporterClassification) error {
 	classifyGroup := classifyString(&ec.Group)
@@ -80,6 +107,11 @@ func (scr *ExporterClassifierRule) exec(si exporterInfo, ec *exporterClassificat
 	classifyTenant := classifyString(&ec.Tenant)
 	env := exporterClassifierEnvironment{
 		Format:              format,
+		RegexMatch:          regexMatch,
+		RegexReplace:        regexReplace,
+		Lower:               strings.ToLower,
+		Upper:               strings.ToUpper,
+		EqualFold:           strings.EqualFold,
 		Exporter:            si,
 		Classify:            classifyGroup,
 		ClassifyRegex:       withRegex(classifyGroup),
@@ -114,8 +146,8 @@ func (scr *ExporterClassifierRule) UnmarshalText(text []byte) error {
 	if err != nil {
 		return fmt.Errorf("cannot compile exporter classifier rule %q: %w", string(text), err)
 	}
-	if len(regexValidator.invalidRegexes) > 0 {
-		return fmt.Errorf("invalid regular expression %q", regexValidator.invalidRegexes[0])
+	if regexValidator.err != nil {
+		return regexValidator.err
 	}
 	scr.program = program
 	return nil
@@ -158,6 +190,11 @@ type interfaceClassification struct {
 // interfaceClassifierEnvironment defines the environment used by the interface classifier
 type interfaceClassifierEnvironment struct {
 	Format                    func(string, ...any) string
+	RegexMatch                func(string, string) (bool, error)
+	RegexReplace              func(string, string, string) (string, error)
+	Lower                     func(string) string
+	Upper                     func(string) string
+	EqualFold                 func(string, string) bool
 	Exporter                  exporterInfo
 	Interface                 interfaceInfo
 	ClassifyConnectivity      classifyStringFunc
@@ -201,6 +238,11 @@ func (scr *InterfaceClassifierRule) exec(si exporterInfo, ii interfaceInfo, ic *
 	}
 	env := interfaceClassifierEnvironment{
 		Format:                    format,
+		RegexMatch:                regexMatch,
+		RegexReplace:              regexReplace,
+		Lower:                     strings.ToLower,
+		Upper:                     strings.ToUpper,
+		EqualFold:                 strings.EqualFold,
 		Exporter:                  si,
 		Interface:                 ii,
 		ClassifyConnectivity:      classifyConnectivity,
@@ -232,8 +274,8 @@ func (scr *InterfaceClassifierRule) UnmarshalText(text []byte) error {
 	if err != nil {
 		return fmt.Errorf("cannot compile interface classifier rule %q: %w", string(text), err)
 	}
-	if len(regexValidator.invalidRegexes) > 0 {
-		return fmt.Errorf("invalid regular expression %q", regexValidator.invalidRegexes[0])
+	if regexValidator.err != nil {
+		return regexValidator.err
 	}
 	scr.program = program
 	return nil
@@ -249,27 +291,124 @@ func (scr InterfaceClassifierRule) MarshalText() ([]byte, error) {
 	return []byte(scr.String()), nil
 }
 
+// FlowClassifierRule defines a classification rule for a flow.
+type FlowClassifierRule struct {
+	program *vm.Program
+}
+
+// flowInfo contains the information we want to expose about a flow.
+type flowInfo struct {
+	SrcAddr string
+	DstAddr string
+	SrcPort uint16
+	DstPort uint16
+	Proto   uint8
+	SrcAS   uint32
+	DstAS   uint32
+}
+
+// flowClassification contains the information about a flow classification
+type flowClassification struct {
+	Class  string
+	Reject bool
+}
+
+// flowClassifierEnvironment defines the environment used by the flow classifier
+type flowClassifierEnvironment struct {
+	Format            func(string, ...any) string
+	RegexMatch        func(string, string) (bool, error)
+	RegexReplace      func(string, string, string) (string, error)
+	Lower             func(string) string
+	Upper             func(string) string
+	EqualFold         func(string, string) bool
+	Exporter          exporterInfo
+	Flow              flowInfo
+	InSubnet          func(string, string) (bool, error)
+	ClassifyFlow      classifyStringFunc
+	ClassifyFlowRegex classifyStringRegexFunc
+	Reject            func() bool
+}
+
+// exec executes the flow classifier with the provided flow.
+func (scr *FlowClassifierRule) exec(si exporterInfo, fi flowInfo, fc *flowClassification) error {
+	classifyFlow := classifyString(&fc.Class)
+	env := flowClassifierEnvironment{
+		Format:            format,
+		RegexMatch:        regexMatch,
+		RegexReplace:      regexReplace,
+		Lower:             strings.ToLower,
+		Upper:             strings.ToUpper,
+		EqualFold:         strings.EqualFold,
+		Exporter:          si,
+		Flow:              fi,
+		InSubnet:          inSubnet,
+		ClassifyFlow:      classifyFlow,
+		ClassifyFlowRegex: withRegex(classifyFlow),
+		Reject: func() bool {
+			fc.Reject = true
+			return false
+		},
+	}
+	if _, err := expr.Run(scr.program, env); err != nil {
+		return fmt.Errorf("unable to execute classifier %q: %w", scr, err)
+	}
+	return nil
+}
+
+// UnmarshalText compiles a classification rule for a flow.
+func (scr *FlowClassifierRule) UnmarshalText(text []byte) error {
+	regexValidator := regexValidator{}
+	subnetValidator := subnetValidator{}
+	program, err := expr.Compile(string(text),
+		expr.Env(flowClassifierEnvironment{}),
+		expr.AsBool(),
+		expr.Patch(&regexValidator),
+		expr.Patch(&subnetValidator))
+	if err != nil {
+		return fmt.Errorf("cannot compile flow classifier rule %q: %w", string(text), err)
+	}
+	if regexValidator.err != nil {
+		return regexValidator.err
+	}
+	if len(subnetValidator.invalidSubnets) > 0 {
+		return fmt.Errorf("invalid subnet %q", subnetValidator.invalidSubnets[0])
+	}
+	scr.program = program
+	return nil
+}
+
+// String turns a flow classifier rule into a string
+func (scr FlowClassifierRule) String() string {
+	return scr.program.Source().String()
+}
+
+// MarshalText turns a flow classifier rule into a string
+func (scr FlowClassifierRule) MarshalText() ([]byte, error) {
+	return []byte(scr.String()), nil
+}
+
+// inSubnet tells if the provided IP address is part of the provided subnet.
+func inSubnet(addr string, subnet string) (bool, error) {
+	prefix, err := netip.ParsePrefix(subnet)
+	if err != nil {
+		return false, fmt.Errorf("cannot parse subnet %q: %w", subnet, err)
+	}
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return false, fmt.Errorf("cannot parse IP address %q: %w", addr, err)
+	}
+	return prefix.Contains(ip), nil
+}
+
 // withRegex turns a function taking a string into a function taking a
 // string to match a regex with, a regex and a template to be expanded
 // with the result of the regex.
 func withRegex(fn func(string) bool) func(string, string, string) (bool, error) {
 	return func(str string, regex string, template string) (bool, error) {
-		// We may have several readers trying to compile the
-		// regex the first time. It's not really important.
-		regexCacheLock.RLock()
-		compiledRegex, ok := regexCache[regex]
-		regexCacheLock.RUnlock()
-		if !ok {
-			var err error
-			compiledRegex, err = regexp.Compile(regex)
-			if err != nil {
-				return false, fmt.Errorf("cannot compile regex %q: %w", regex, err)
-			}
-			regexCacheLock.Lock()
-			regexCache[regex] = compiledRegex
-			regexCacheLock.Unlock()
+		compiledRegex, err := compileRegex(regex)
+		if err != nil {
+			return false, err
 		}
-
 		result := []byte{}
 		indexes := compiledRegex.FindSubmatchIndex([]byte(str))
 		if indexes == nil {
@@ -280,6 +419,72 @@ func withRegex(fn func(string) bool) func(string, string, string) (bool, error)
 	}
 }
 
+// compileRegex compiles a regex, using the global cache.
+func compileRegex(regex string) (*regexp.Regexp, error) {
+	// We may have several readers trying to compile the
+	// regex the first time. It's not really important.
+	regexCacheLock.RLock()
+	compiledRegex, ok := regexCache[regex]
+	regexCacheLock.RUnlock()
+	if !ok {
+		var err error
+		compiledRegex, err = regexp.Compile(regex)
+		if err != nil {
+			return nil, fmt.Errorf("cannot compile regex %q: %w", regex, err)
+		}
+		regexCacheLock.Lock()
+		regexCache[regex] = compiledRegex
+		regexCacheLock.Unlock()
+	}
+	return compiledRegex, nil
+}
+
+// validateTemplate checks the references to capture groups in a template
+// exist in the provided regex.
+func validateTemplate(regex *regexp.Regexp, template string) error {
+	for i := 0; i < len(template); i++ {
+		if template[i] != '$' {
+			continue
+		}
+		i++
+		if i < len(template) && template[i] == '$' {
+			continue
+		}
+		var name string
+		if i < len(template) && template[i] == '{' {
+			end := strings.IndexByte(template[i:], '}')
+			if end < 0 {
+				return fmt.Errorf("unterminated reference in template %q", template)
+			}
+			name = template[i+1 : i+end]
+			i += end
+		} else {
+			end := i
+			for end < len(template) && (template[end] == '_' ||
+				'a' <= template[end] && template[end] <= 'z' ||
+				'A' <= template[end] && template[end] <= 'Z' ||
+				'0' <= template[end] && template[end] <= '9') {
+				end++
+			}
+			name = template[i:end]
+			i = end - 1
+		}
+		if name == "" {
+			return fmt.Errorf("empty reference in template %q", template)
+		}
+		if num, err := strconv.Atoi(name); err == nil {
+			if num > regex.NumSubexp() {
+				return fmt.Errorf("unknown capture group %q in template %q for regular expression %q",
+					name, template, regex)
+			}
+		} else if regex.SubexpIndex(name) < 0 {
+			return fmt.Errorf("unknown capture group %q in template %q for regular expression %q",
+				name, template, regex)
+		}
+	}
+	return nil
+}
+
 var normalizeRegex = regexp.MustCompile("[^a-z0-9.+-]+")
 
 // Normalize a string by putting it lowercase and only keeping safe characters
@@ -298,10 +503,52 @@ func classifyString(output *string) func(string) bool {
 }
 
 type regexValidator struct {
-	invalidRegexes []string
+	err error
 }
 
 func (r *regexValidator) Visit(node *ast.Node) {
+	if r.err != nil {
+		return
+	}
+	n, ok := (*node).(*ast.CallNode)
+	if !ok {
+		return
+	}
+	identifier, ok := n.Callee.(*ast.IdentifierNode)
+	if !ok {
+		return
+	}
+	switch {
+	case identifier.Value == "RegexMatch" && len(n.Arguments) == 2:
+	case strings.HasSuffix(identifier.Value, "Regex") && len(n.Arguments) == 3:
+	case identifier.Value == "RegexReplace" && len(n.Arguments) == 3:
+	default:
+		return
+	}
+	str, ok := n.Arguments[1].(*ast.StringNode)
+	if !ok {
+		return
+	}
+	regex, err := regexp.Compile(str.Value)
+	if err != nil {
+		r.err = fmt.Errorf("invalid regular expression %q", str.Value)
+		return
+	}
+	if len(n.Arguments) != 3 {
+		return
+	}
+	template, ok := n.Arguments[2].(*ast.StringNode)
+	if !ok {
+		return
+	}
+	r.err = validateTemplate(regex, template.Value)
+}
+
+type subnetValidator struct {
+	invalidSubnets []string
+}
+
+func (r *subnetValidator) Visit(node *ast.Node) {
 	n, ok := (*node).(*ast.CallNode)
 	if !ok {
 		return
@@ -310,14 +557,14 @@ func (r *regexValidator) Visit(node *ast.Node) {
 	if !ok {
 		return
 	}
-	if !strings.HasSuffix(identifier.Value, "Regex") || len(n.Arguments) != 3 {
+	if identifier.Value != "InSubnet" || len(n.Arguments) != 2 {
 		return
 	}
 	str, ok := n.Arguments[1].(*ast.StringNode)
 	if !ok {
 		return
 	}
-	if _, err := regexp.Compile(str.Value); err != nil {
-		r.invalidRegexes = append(r.invalidRegexes, str.Value)
+	if _, err := netip.ParsePrefix(str.Value); err != nil {
+		r.invalidSubnets = append(r.invalidSubnets, str.Value)
 	}
 }
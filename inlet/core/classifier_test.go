@@ -48,48 +48,53 @@ func TestExporterClassifier(t *testing.T) {
 		}, {
 			Description:            "access to exporter name",
 			Program:                `Exporter.Name startsWith "expo" && Classify("europe")`,
-			ExporterInfo:           exporterInfo{"127.0.0.1", "exporter"},
+			ExporterInfo:           exporterInfo{IP: "127.0.0.1", Name: "exporter"},
 			ExpectedClassification: exporterClassification{Group: "europe"},
+		}, {
+			Description:            "access to exporter location",
+			Program:                `ClassifySiteRegex(Exporter.Location, "^([a-z]+)\\d+,", "$1")`,
+			ExporterInfo:           exporterInfo{IP: "127.0.0.1", Name: "exporter", Location: "par3, Rack 12"},
+			ExpectedClassification: exporterClassification{Site: "par"},
 		}, {
 			Description:            "matches",
 			Program:                `Exporter.Name matches "^e.p.r" && Classify("europe")`,
-			ExporterInfo:           exporterInfo{"127.0.0.1", "exporter"},
+			ExporterInfo:           exporterInfo{IP: "127.0.0.1", Name: "exporter"},
 			ExpectedClassification: exporterClassification{Group: "europe"},
 		}, {
 			Description: "multiline",
 			Program: `Exporter.Name matches "^e.p.r" &&
 Classify("europe")`,
-			ExporterInfo:           exporterInfo{"127.0.0.1", "exporter"},
+			ExporterInfo:           exporterInfo{IP: "127.0.0.1", Name: "exporter"},
 			ExpectedClassification: exporterClassification{Group: "europe"},
 		}, {
 			Description:            "regex",
 			Program:                `ClassifyRegex(Exporter.Name, "^(e.p+).r", "europe-$1")`,
-			ExporterInfo:           exporterInfo{"127.0.0.1", "exporter"},
+			ExporterInfo:           exporterInfo{IP: "127.0.0.1", Name: "exporter"},
 			ExpectedClassification: exporterClassification{Group: "europe-exp"},
 		}, {
 			Description:            "regex with class",
 			Program:                `ClassifyRegex(Exporter.Name, "^(\\w+).r", "europe-$1")`,
-			ExporterInfo:           exporterInfo{"127.0.0.1", "exporter"},
+			ExporterInfo:           exporterInfo{IP: "127.0.0.1", Name: "exporter"},
 			ExpectedClassification: exporterClassification{Group: "europe-export"},
 		}, {
 			Description:            "non-matching regex",
 			Program:                `ClassifyRegex(Exporter.Name, "^(ebp+).r", "europe-$1")`,
-			ExporterInfo:           exporterInfo{"127.0.0.1", "exporter"},
+			ExporterInfo:           exporterInfo{IP: "127.0.0.1", Name: "exporter"},
 			ExpectedClassification: exporterClassification{Group: ""},
 		}, {
 			Description:            "reject",
 			Program:                `ClassifyTenant("mobile") && Reject()`,
-			ExporterInfo:           exporterInfo{"127.0.0.1", "exporter"},
+			ExporterInfo:           exporterInfo{IP: "127.0.0.1", Name: "exporter"},
 			ExpectedClassification: exporterClassification{Tenant: "mobile", Reject: true},
 		}, {
 			Description:            "selective reject",
 			Program:                `Exporter.Name startsWith "nothing" && Reject()`,
-			ExporterInfo:           exporterInfo{"127.0.0.1", "exporter"},
+			ExporterInfo:           exporterInfo{IP: "127.0.0.1", Name: "exporter"},
 			ExpectedClassification: exporterClassification{},
 		}, {
 			Description:  "faulty regex",
 			Program:      `ClassifyRegex(Exporter.Name, "^(ebp+.r", "europe-$1")`,
-			ExporterInfo: exporterInfo{"127.0.0.1", "exporter"},
+			ExporterInfo: exporterInfo{IP: "127.0.0.1", Name: "exporter"},
 			ExpectedErr:  true,
 		}, {
 			Description: "syntax error",
@@ -180,6 +185,30 @@ func TestInterfaceClassifier(t *testing.T) {
 			Description:            "reject",
 			Program:                `Reject()`,
 			ExpectedClassification: interfaceClassification{Reject: true},
+		}, {
+			Description:   "classify with capture groups",
+			Program:       `ClassifyProviderRegex(Interface.Description, "^peer-(\\d+)-(\\w+)", "$2-$1")`,
+			InterfaceInfo: interfaceInfo{Description: "peer-1299-Telia"},
+			ExpectedClassification: interfaceClassification{
+				Provider: "telia-1299",
+			},
+		}, {
+			Description: "regex helpers",
+			Program: `RegexMatch(Interface.Description, "^(?i)transit") &&
+ClassifyProvider(RegexReplace(Interface.Description, "^[^:]+: ([^ ]+).*", "$1"))`,
+			InterfaceInfo: interfaceInfo{Description: "TRANSIT: Cogent 1-3834938493"},
+			ExpectedClassification: interfaceClassification{
+				Provider: "cogent",
+			},
+		}, {
+			Description: "case folding helpers",
+			Program: `EqualFold(Interface.Name, "GI0/0/0") &&
+SetName(Upper(Interface.Name)) && SetDescription(Lower(Interface.Description))`,
+			InterfaceInfo: interfaceInfo{Name: "Gi0/0/0", Description: "Transit"},
+			ExpectedClassification: interfaceClassification{
+				Name:        "GI0/0/0",
+				Description: "transit",
+			},
 		}, {
 			Description: "use index",
 			InterfaceInfo: interfaceInfo{
@@ -257,6 +286,104 @@ ClassifyProviderRegex(Interface.Description, "^Transit: ([^ ]+)", "$1")
 	}
 }
 
+func TestFlowClassifier(t *testing.T) {
+	cases := []struct {
+		Description            string
+		Program                string
+		FlowInfo               flowInfo
+		ExpectedClassification flowClassification
+		ExpectedErr            bool
+	}{
+		{
+			Description: "trivial classifier",
+			Program:     "false",
+		}, {
+			Description:            "constant classifier",
+			Program:                `ClassifyFlow("CDN")`,
+			ExpectedClassification: flowClassification{Class: "cdn"},
+		}, {
+			Description:            "classify by port",
+			Program:                `Flow.Proto == 6 && Flow.DstPort == 443 && ClassifyFlow("https")`,
+			FlowInfo:               flowInfo{Proto: 6, SrcPort: 34000, DstPort: 443},
+			ExpectedClassification: flowClassification{Class: "https"},
+		}, {
+			Description: "classify by port, no match",
+			Program:     `Flow.Proto == 6 && Flow.DstPort == 443 && ClassifyFlow("https")`,
+			FlowInfo:    flowInfo{Proto: 17, SrcPort: 34000, DstPort: 443},
+		}, {
+			Description:            "classify by subnet",
+			Program:                `InSubnet(Flow.DstAddr, "192.0.2.0/24") && ClassifyFlow("cdn")`,
+			FlowInfo:               flowInfo{DstAddr: "192.0.2.10"},
+			ExpectedClassification: flowClassification{Class: "cdn"},
+		}, {
+			Description: "classify by IPv6 subnet",
+			Program:     `InSubnet(Flow.SrcAddr, "2001:db8::/32") && ClassifyFlow("cdn")`,
+			FlowInfo:    flowInfo{SrcAddr: "192.0.2.10"},
+		}, {
+			Description:            "classify by ASN",
+			Program:                `Flow.SrcAS == 2906 && ClassifyFlowRegex(Exporter.Name, "^([^-]+)-", "netflix-$1")`,
+			FlowInfo:               flowInfo{SrcAS: 2906},
+			ExpectedClassification: flowClassification{Class: "netflix-paris"},
+		}, {
+			Description:            "reject",
+			Program:                `Flow.DstPort == 53 && Reject()`,
+			FlowInfo:               flowInfo{DstPort: 53},
+			ExpectedClassification: flowClassification{Reject: true},
+		}, {
+			Description: "invalid address",
+			Program:     `InSubnet(Flow.SrcAddr, "192.0.2.0/24")`,
+			ExpectedErr: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			var scr FlowClassifierRule
+			err := scr.UnmarshalText([]byte(tc.Program))
+			if !tc.ExpectedErr && err != nil {
+				t.Fatalf("UnmarshalText(%q) error:\n%+v", tc.Program, err)
+			}
+			if tc.ExpectedErr && err != nil {
+				return
+			}
+			var gotClassification flowClassification
+			err = scr.exec(exporterInfo{IP: "192.0.2.1", Name: "paris-edge1"}, tc.FlowInfo, &gotClassification)
+			if !tc.ExpectedErr && err != nil {
+				t.Fatalf("exec(%q) error:\n%+v", tc.Program, err)
+			}
+			if tc.ExpectedErr && err == nil {
+				t.Fatalf("exec(%q) no error", tc.Program)
+			}
+			if diff := helpers.Diff(gotClassification, tc.ExpectedClassification); diff != "" {
+				t.Fatalf("exec(%q) (-got, +want):\n%s", tc.Program, diff)
+			}
+		})
+	}
+}
+
+func TestSubnetValidation(t *testing.T) {
+	cases := []struct {
+		Classifier string
+		Error      bool
+	}{
+		{`InSubnet(Flow.DstAddr, "192.0.2.0/24")`, false},
+		{`InSubnet(Flow.DstAddr, "2001:db8::/32")`, false},
+		{`InSubnet(Flow.DstAddr, "192.0.2.0/33")`, true},
+		{`InSubnet(Flow.DstAddr, "192.0.2.1")`, true},
+		// When non-constant string is used, we cannot detect the error
+		{`InSubnet(Flow.DstAddr, Flow.SrcAddr + "/33")`, false},
+	}
+	for _, tc := range cases {
+		var scr FlowClassifierRule
+		err := scr.UnmarshalText([]byte(tc.Classifier))
+		if err == nil && tc.Error {
+			t.Errorf("UnmarshalText(%q) should have returned an error", tc.Classifier)
+		}
+		if err != nil && !tc.Error {
+			t.Errorf("UnmarshalText(%q) error:\n%+v", tc.Classifier, err)
+		}
+	}
+}
+
 func TestRegexValidation(t *testing.T) {
 	cases := []struct {
 		Classifier string
@@ -264,6 +391,14 @@ func TestRegexValidation(t *testing.T) {
 	}{
 		{`ClassifyRegex("something", "^(ebp+).r", "europe-$1")`, false},
 		{`ClassifyRegex("something", "^(ebp+.r", "europe-$1")`, true},
+		{`ClassifyRegex("something", "^(ebp+).r", "europe-$2")`, true},
+		{`ClassifyRegex("something", "^(ebp+).r", "europe-${1}-$$")`, false},
+		{`ClassifyRegex("something", "^(ebp+).r", "europe-${1")`, true},
+		{`ClassifyRegex("something", "^(?P<site>ebp+).r", "europe-$site")`, false},
+		{`ClassifyRegex("something", "^(?P<site>ebp+).r", "europe-$region")`, true},
+		{`RegexMatch("something", "^(ebp+.r")`, true},
+		{`RegexReplace("something", "^(ebp+).r", "$1") == ""`, false},
+		{`RegexReplace("something", "^(ebp+).r", "$3") == ""`, true},
 		// When non-constant string is used, we cannot detect the error
 		{`ClassifyRegex("something", Exporter.Name + "^(ebp+.r", "europe-$1")`, false},
 	}
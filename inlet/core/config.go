@@ -12,6 +12,7 @@ import (
 
 	"akvorado/common/helpers"
 	"akvorado/common/helpers/bimap"
+	"akvorado/common/schema"
 
 	"github.com/mitchellh/mapstructure"
 )
@@ -24,16 +25,44 @@ type Configuration struct {
 	ExporterClassifiers []ExporterClassifierRule
 	// InterfaceClassifiers defines rules for interface classification
 	InterfaceClassifiers []InterfaceClassifierRule
+	// FlowClassifiers defines rules for flow classification
+	FlowClassifiers []FlowClassifierRule
 	// ClassifierCacheDuration defines the default TTL for classifier cache
 	ClassifierCacheDuration time.Duration `validate:"min=1s"`
+	// ClassifierCacheMaxEntries defines the maximum number of entries in each classifier cache
+	ClassifierCacheMaxEntries uint `validate:"min=1"`
 	// DefaultSamplingRate defines the default sampling rate to use when the information is missing
 	DefaultSamplingRate helpers.SubnetMap[uint]
 	// OverrideSamplingRate defines a sampling rate to use instead of the received on
 	OverrideSamplingRate helpers.SubnetMap[uint]
 	// ASNProviders defines the source used to get AS numbers
 	ASNProviders []ASNProvider `validate:"dive"`
+	// SrcASNProviders defines the source used to get source AS numbers
+	// (ASNProviders when empty)
+	SrcASNProviders []ASNProvider `validate:"dive"`
+	// DstASNProviders defines the source used to get destination AS numbers
+	// (ASNProviders when empty)
+	DstASNProviders []ASNProvider `validate:"dive"`
 	// NetProviders defines the source used to get Prefix/Network Information
 	NetProviders []NetProvider `validate:"dive"`
+	// BGPProviders defines the source used to get AS paths and communities
+	BGPProviders []BGPProvider `validate:"dive"`
+	// MaxASPathLength limits the number of AS numbers kept in the AS path
+	// (0 means no limit)
+	MaxASPathLength int `validate:"min=0"`
+	// Deduplication defines how to detect flows reported by several exporters
+	Deduplication DeduplicationConfiguration
+	// ExternalEnrichment defines an external service to get attributes for addresses
+	ExternalEnrichment ExternalEnrichmentConfiguration
+	// AddressTruncation defines the prefix lengths to truncate source and
+	// destination addresses to
+	AddressTruncation helpers.SubnetMap[AddressTruncationConfiguration] `validate:"dive"`
+	// ThreatLists defines lists of addresses to tag flows with
+	ThreatLists ThreatListsConfiguration
+	// Aggregation defines how to merge flows sharing the same dimensions
+	Aggregation AggregationConfiguration
+	// Filters defines rules to drop or sample flows
+	Filters []FilterConfiguration `validate:"dive"`
 	// Old configuration settings
 	classifierCacheSize uint
 }
@@ -41,20 +70,158 @@ type Configuration struct {
 // DefaultConfiguration represents the default configuration for the core component.
 func DefaultConfiguration() Configuration {
 	return Configuration{
-		Workers:                 1,
-		ExporterClassifiers:     []ExporterClassifierRule{},
-		InterfaceClassifiers:    []InterfaceClassifierRule{},
-		ClassifierCacheDuration: 5 * time.Minute,
-		ASNProviders:            []ASNProvider{ASNProviderFlow, ASNProviderRouting},
-		NetProviders:            []NetProvider{NetProviderFlow, NetProviderRouting},
+		Workers:                   1,
+		ExporterClassifiers:       []ExporterClassifierRule{},
+		InterfaceClassifiers:      []InterfaceClassifierRule{},
+		FlowClassifiers:           []FlowClassifierRule{},
+		Filters:                   []FilterConfiguration{},
+		ClassifierCacheDuration:   5 * time.Minute,
+		ClassifierCacheMaxEntries: 100000,
+		ASNProviders:              []ASNProvider{ASNProviderFlow, ASNProviderRouting},
+		NetProviders:              []NetProvider{NetProviderFlow, NetProviderRouting},
+		BGPProviders:              []BGPProvider{BGPProviderFlow, BGPProviderRouting},
+		Deduplication: DeduplicationConfiguration{
+			Window:     0,
+			MaxEntries: 100000,
+			Action:     DeduplicationActionMark,
+		},
+		ExternalEnrichment: ExternalEnrichmentConfiguration{
+			Timeout:      500 * time.Millisecond,
+			CacheSize:    100000,
+			CacheTTL:     10 * time.Minute,
+			Concurrency:  4,
+			MaxBatchSize: 100,
+		},
+		ThreatLists: ThreatListsConfiguration{
+			Interval: time.Hour,
+			Timeout:  time.Minute,
+		},
+		Aggregation: AggregationConfiguration{
+			Window:     0,
+			MaxEntries: 100000,
+		},
 	}
 }
 
+// DeduplicationConfiguration describes how to detect the same flow reported by
+// several exporters.
+type DeduplicationConfiguration struct {
+	// Window is the time window during which the same flow seen from
+	// different exporters is considered as a duplicate. 0 disables
+	// deduplication.
+	Window time.Duration `validate:"min=0"`
+	// MaxEntries is the maximum number of flows to remember
+	MaxEntries uint `validate:"min=1"`
+	// Action tells what to do with duplicate flows
+	Action DeduplicationAction
+}
+
+// ExternalEnrichmentConfiguration describes an external service queried over
+// HTTP or gRPC to get attributes attached to source and destination addresses.
+type ExternalEnrichmentConfiguration struct {
+	// URL is the URL of the external service (http, https, grpc, or grpcs
+	// scheme). When empty, external enrichment is disabled.
+	URL string `validate:"omitempty,url"`
+	// Timeout is the maximum time to wait for an answer
+	Timeout time.Duration `validate:"min=1ms"`
+	// CacheSize is the maximum number of addresses to keep in cache
+	CacheSize uint `validate:"min=1"`
+	// CacheTTL is how long an answer is kept in cache
+	CacheTTL time.Duration `validate:"min=1s"`
+	// Concurrency is the maximum number of concurrent requests
+	Concurrency int `validate:"min=1"`
+	// MaxBatchSize is the maximum number of addresses in a single request
+	MaxBatchSize int `validate:"min=1"`
+	// Fields maps attributes returned by the external service to columns
+	Fields []ExternalEnrichmentField `validate:"dive"`
+}
+
+// ExternalEnrichmentField maps an attribute returned by the external service
+// for the source or destination address to a column.
+type ExternalEnrichmentField struct {
+	// Attribute is the name of the attribute in the answer
+	Attribute string `validate:"required"`
+	// Address tells if the attribute is looked up for the source or the
+	// destination address
+	Address ExternalEnrichmentAddress
+	// Column is the name of the column to populate
+	Column string `validate:"required"`
+}
+
+// FilterConfiguration describes a rule to drop or sample matching flows.
+type FilterConfiguration struct {
+	// Name is the name of the filter, used in metrics
+	Name string `validate:"required"`
+	// Rule is the expression matching flows
+	Rule FilterRule
+	// Action tells what to do with matching flows
+	Action FilterAction
+	// Factor is the sampling factor for the sample action
+	Factor uint
+}
+
+// AggregationConfiguration describes how to merge flows sharing the same
+// dimensions before sending them to Kafka.
+type AggregationConfiguration struct {
+	// Window is the time window during which flows are aggregated. 0
+	// disables aggregation.
+	Window time.Duration `validate:"min=0"`
+	// MaxEntries is the maximum number of aggregated flows. Once reached,
+	// new flows are sent without aggregation.
+	MaxEntries uint `validate:"min=1"`
+	// Drop lists the columns removed from flows before aggregation
+	Drop []schema.ColumnKey
+}
+
+// ThreatListsConfiguration describes lists of addresses and prefixes used to
+// tag flows in the SrcThreatList and DstThreatList columns.
+type ThreatListsConfiguration struct {
+	// Interval is the interval at which lists are refreshed
+	Interval time.Duration `validate:"min=1m"`
+	// Timeout is the maximum time to fetch and parse a list
+	Timeout time.Duration `validate:"min=1s"`
+	// Lists is the set of lists to load. When an address matches several
+	// lists, the most specific prefix wins, then the first list.
+	Lists []ThreatListConfiguration `validate:"dive"`
+}
+
+// ThreatListConfiguration describes a list of addresses and prefixes.
+type ThreatListConfiguration struct {
+	// Name is the name of the list, used as a label when none is provided
+	Name string `validate:"required"`
+	// Source is the path or the HTTP(S) URL of the list
+	Source string `validate:"required"`
+	// Format is the format of the list
+	Format ThreatListFormat
+	// LabelColumn is the name of the CSV column containing the label
+	LabelColumn string
+}
+
+// AddressTruncationConfiguration describes the prefix lengths to truncate
+// addresses to. Truncation happens once all lookups are done. 0 disables
+// truncation.
+type AddressTruncationConfiguration struct {
+	// IPv4 is the prefix length for IPv4 addresses
+	IPv4 int `validate:"min=0,max=32"`
+	// IPv6 is the prefix length for IPv6 addresses
+	IPv6 int `validate:"min=0,max=128"`
+}
+
 type (
 	// ASNProvider describes one AS number provider.
 	ASNProvider int
 	// NetProvider describes one network mask provider.
 	NetProvider int
+	// BGPProvider describes one AS path and communities provider.
+	BGPProvider int
+	// DeduplicationAction describes what to do with duplicate flows.
+	DeduplicationAction int
+	// ExternalEnrichmentAddress describes which address is used for external enrichment.
+	ExternalEnrichmentAddress int
+	// ThreatListFormat describes the format of a threat list.
+	ThreatListFormat int
+	// FilterAction describes what to do with flows matching a filter.
+	FilterAction int
 )
 
 const (
@@ -146,8 +313,200 @@ func (np *NetProvider) UnmarshalText(input []byte) error {
 	return errors.New("unknown provider")
 }
 
+const (
+	// BGPProviderFlow uses the AS path and communities embedded in flows, if any
+	BGPProviderFlow BGPProvider = iota
+	// BGPProviderRouting uses the AS path and communities from the routing component
+	BGPProviderRouting
+)
+
+var bgpProviderMap = bimap.New(map[BGPProvider]string{
+	BGPProviderFlow:    "flow",
+	BGPProviderRouting: "routing",
+})
+
+// MarshalText turns a BGP provider to text.
+func (bp BGPProvider) MarshalText() ([]byte, error) {
+	got, ok := bgpProviderMap.LoadValue(bp)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown field")
+}
+
+// String turns a BGP provider to string.
+func (bp BGPProvider) String() string {
+	got, _ := bgpProviderMap.LoadValue(bp)
+	return got
+}
+
+// UnmarshalText provides a BGP provider from a string.
+func (bp *BGPProvider) UnmarshalText(input []byte) error {
+	got, ok := bgpProviderMap.LoadKey(string(input))
+	if ok {
+		*bp = got
+		return nil
+	}
+	return errors.New("unknown provider")
+}
+
+const (
+	// DeduplicationActionMark sets the Duplicate column on duplicate flows
+	DeduplicationActionMark DeduplicationAction = iota
+	// DeduplicationActionDrop drops duplicate flows
+	DeduplicationActionDrop
+	// DeduplicationActionKeepIngress drops duplicate flows not entering the
+	// network through an external interface
+	DeduplicationActionKeepIngress
+)
+
+var deduplicationActionMap = bimap.New(map[DeduplicationAction]string{
+	DeduplicationActionMark:        "mark",
+	DeduplicationActionDrop:        "drop",
+	DeduplicationActionKeepIngress: "keep-ingress",
+})
+
+// MarshalText turns a deduplication action to text.
+func (da DeduplicationAction) MarshalText() ([]byte, error) {
+	got, ok := deduplicationActionMap.LoadValue(da)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown action")
+}
+
+// String turns a deduplication action to string.
+func (da DeduplicationAction) String() string {
+	got, _ := deduplicationActionMap.LoadValue(da)
+	return got
+}
+
+// UnmarshalText provides a deduplication action from a string.
+func (da *DeduplicationAction) UnmarshalText(input []byte) error {
+	got, ok := deduplicationActionMap.LoadKey(string(input))
+	if ok {
+		*da = got
+		return nil
+	}
+	return errors.New("unknown action")
+}
+
+const (
+	// ExternalEnrichmentAddressSrc uses the source address
+	ExternalEnrichmentAddressSrc ExternalEnrichmentAddress = iota
+	// ExternalEnrichmentAddressDst uses the destination address
+	ExternalEnrichmentAddressDst
+)
+
+var externalEnrichmentAddressMap = bimap.New(map[ExternalEnrichmentAddress]string{
+	ExternalEnrichmentAddressSrc: "src",
+	ExternalEnrichmentAddressDst: "dst",
+})
+
+// MarshalText turns an external enrichment address to text.
+func (ea ExternalEnrichmentAddress) MarshalText() ([]byte, error) {
+	got, ok := externalEnrichmentAddressMap.LoadValue(ea)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown address")
+}
+
+// String turns an external enrichment address to string.
+func (ea ExternalEnrichmentAddress) String() string {
+	got, _ := externalEnrichmentAddressMap.LoadValue(ea)
+	return got
+}
+
+// UnmarshalText provides an external enrichment address from a string.
+func (ea *ExternalEnrichmentAddress) UnmarshalText(input []byte) error {
+	got, ok := externalEnrichmentAddressMap.LoadKey(string(input))
+	if ok {
+		*ea = got
+		return nil
+	}
+	return errors.New("unknown address")
+}
+
+const (
+	// ThreatListFormatText is a list with one address or prefix per line
+	ThreatListFormatText ThreatListFormat = iota
+	// ThreatListFormatCSV is a CSV file with the address or prefix in the
+	// first column
+	ThreatListFormatCSV
+)
+
+var threatListFormatMap = bimap.New(map[ThreatListFormat]string{
+	ThreatListFormatText: "text",
+	ThreatListFormatCSV:  "csv",
+})
+
+// MarshalText turns a threat list format to text.
+func (tf ThreatListFormat) MarshalText() ([]byte, error) {
+	got, ok := threatListFormatMap.LoadValue(tf)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown format")
+}
+
+// String turns a threat list format to string.
+func (tf ThreatListFormat) String() string {
+	got, _ := threatListFormatMap.LoadValue(tf)
+	return got
+}
+
+// UnmarshalText provides a threat list format from a string.
+func (tf *ThreatListFormat) UnmarshalText(input []byte) error {
+	got, ok := threatListFormatMap.LoadKey(string(input))
+	if ok {
+		*tf = got
+		return nil
+	}
+	return errors.New("unknown format")
+}
+
+const (
+	// FilterActionDrop drops matching flows
+	FilterActionDrop FilterAction = iota
+	// FilterActionSample keeps only one matching flow out of the sampling
+	// factor
+	FilterActionSample
+)
+
+var filterActionMap = bimap.New(map[FilterAction]string{
+	FilterActionDrop:   "drop",
+	FilterActionSample: "sample",
+})
+
+// MarshalText turns a filter action to text.
+func (fa FilterAction) MarshalText() ([]byte, error) {
+	got, ok := filterActionMap.LoadValue(fa)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown action")
+}
+
+// String turns a filter action to string.
+func (fa FilterAction) String() string {
+	got, _ := filterActionMap.LoadValue(fa)
+	return got
+}
+
+// UnmarshalText provides a filter action from a string.
+func (fa *FilterAction) UnmarshalText(input []byte) error {
+	got, ok := filterActionMap.LoadKey(string(input))
+	if ok {
+		*fa = got
+		return nil
+	}
+	return errors.New("unknown action")
+}
+
 // ConfigurationUnmarshallerHook normalize core configuration:
 //   - replace ignore-asn-from-flow by asn-providers
+//   - validate classifier rules to report the index of an invalid one
 func ConfigurationUnmarshallerHook() mapstructure.DecodeHookFunc {
 	return func(from, to reflect.Value) (interface{}, error) {
 		if from.Kind() != reflect.Map || from.IsNil() || to.Type() != reflect.TypeOf(Configuration{}) {
@@ -166,6 +525,18 @@ func ConfigurationUnmarshallerHook() mapstructure.DecodeHookFunc {
 				oldKey = &fromMap[i]
 			} else if helpers.MapStructureMatchName(k.String(), "ASNProviders") {
 				newKey = &fromMap[i]
+			} else if helpers.MapStructureMatchName(k.String(), "ExporterClassifiers") {
+				if err := validateClassifierRules[ExporterClassifierRule](k.String(), from.MapIndex(fromMap[i])); err != nil {
+					return nil, err
+				}
+			} else if helpers.MapStructureMatchName(k.String(), "InterfaceClassifiers") {
+				if err := validateClassifierRules[InterfaceClassifierRule](k.String(), from.MapIndex(fromMap[i])); err != nil {
+					return nil, err
+				}
+			} else if helpers.MapStructureMatchName(k.String(), "FlowClassifiers") {
+				if err := validateClassifierRules[FlowClassifierRule](k.String(), from.MapIndex(fromMap[i])); err != nil {
+					return nil, err
+				}
 			}
 		}
 		if oldKey != nil && newKey != nil {
@@ -184,7 +555,31 @@ func ConfigurationUnmarshallerHook() mapstructure.DecodeHookFunc {
 	}
 }
 
+// validateClassifierRules compiles each classifier rule of the provided list
+// to report the index of the first invalid one.
+func validateClassifierRules[T any, PT interface {
+	*T
+	UnmarshalText([]byte) error
+}](key string, rules reflect.Value) error {
+	rules = helpers.ElemOrIdentity(rules)
+	if rules.Kind() != reflect.Slice {
+		return nil
+	}
+	for idx := 0; idx < rules.Len(); idx++ {
+		rule := helpers.ElemOrIdentity(rules.Index(idx))
+		if rule.Kind() != reflect.String {
+			continue
+		}
+		if err := PT(new(T)).UnmarshalText([]byte(rule.String())); err != nil {
+			return fmt.Errorf("%s: rule %d: %w", key, idx, err)
+		}
+	}
+	return nil
+}
+
 func init() {
 	helpers.RegisterMapstructureUnmarshallerHook(ConfigurationUnmarshallerHook())
 	helpers.RegisterMapstructureUnmarshallerHook(helpers.SubnetMapUnmarshallerHook[uint]())
+	helpers.RegisterMapstructureUnmarshallerHook(helpers.SubnetMapUnmarshallerHook[AddressTruncationConfiguration]())
+	helpers.RegisterSubnetMapValidation[AddressTruncationConfiguration]()
 }
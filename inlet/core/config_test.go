@@ -4,11 +4,13 @@
 package core
 
 import (
+	"strings"
 	"testing"
 
 	"akvorado/common/helpers"
 
 	"github.com/gin-gonic/gin"
+	"github.com/mitchellh/mapstructure"
 )
 
 func TestDefaultConfiguration(t *testing.T) {
@@ -112,11 +114,83 @@ func TestConfigurationUnmarshallerHook(t *testing.T) {
 				NetProviders: []NetProvider{NetProviderFlow, NetProviderRouting},
 			},
 			SkipValidation: true,
+		}, {
+			Description: "bgp-providers",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"bgp-providers": []string{"routing", "flow"},
+				}
+			},
+			Expected: Configuration{
+				BGPProviders: []BGPProvider{BGPProviderRouting, BGPProviderFlow},
+			},
+			SkipValidation: true,
+		}, {
+			Description: "external-enrichment",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"external-enrichment": gin.H{
+						"url": "http://ipam.example.com/api/lookup",
+						"fields": []gin.H{
+							{"attribute": "customer", "address": "src", "column": "SourceCustomer"},
+							{"attribute": "customer", "address": "dst", "column": "DestinationCustomer"},
+						},
+					},
+				}
+			},
+			Expected: Configuration{
+				ExternalEnrichment: ExternalEnrichmentConfiguration{
+					URL: "http://ipam.example.com/api/lookup",
+					Fields: []ExternalEnrichmentField{
+						{Attribute: "customer", Address: ExternalEnrichmentAddressSrc, Column: "SourceCustomer"},
+						{Attribute: "customer", Address: ExternalEnrichmentAddressDst, Column: "DestinationCustomer"},
+					},
+				},
+			},
+			SkipValidation: true,
+		}, {
+			Description: "invalid interface classifier",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"interface-classifiers": []string{
+						`ClassifyInternal()`,
+						`ClassifyProviderRegex(Interface.Description, "^(\\w+)", "$2")`,
+					},
+				}
+			},
+			Error: true,
 		},
 	})
 }
 
+func TestInvalidClassifierRuleIndex(t *testing.T) {
+	configuration := DefaultConfiguration()
+	decoder, err := mapstructure.NewDecoder(helpers.GetMapStructureDecoderConfig(&configuration))
+	if err != nil {
+		t.Fatalf("NewDecoder() error:\n%+v", err)
+	}
+	err = decoder.Decode(gin.H{
+		"exporter-classifiers": []string{
+			`ClassifyGroup("paris")`,
+			`ClassifyRegex(Exporter.Name, "^(ebp+).r", "europe-$2")`,
+		},
+	})
+	if err == nil {
+		t.Fatal("Decode() did not error")
+	}
+	if !strings.Contains(err.Error(), "exporter-classifiers: rule 1:") {
+		t.Fatalf("Decode() error does not contain rule index:\n%+v", err)
+	}
+}
+
 func TestMarshalUnmarshal(t *testing.T) {
 	asnProviderMap.TestMarshalUnmarshal(t)
 	netProviderMap.TestMarshalUnmarshal(t)
+	bgpProviderMap.TestMarshalUnmarshal(t)
+	externalEnrichmentAddressMap.TestMarshalUnmarshal(t)
+	threatListFormatMap.TestMarshalUnmarshal(t)
+	filterActionMap.TestMarshalUnmarshal(t)
 }
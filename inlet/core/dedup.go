@@ -0,0 +1,303 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"net/netip"
+	"sync"
+	"time"
+
+	"akvorado/common/schema"
+)
+
+// deduplicatorShards is the number of independent shards of the deduplicator.
+// Each shard has its own lock to reduce contention between workers. It should
+// be a power of two.
+const deduplicatorShards = 32
+
+// deduplicator remembers the flows seen during the current and the previous
+// time buckets to detect the same flow reported by several exporters. The
+// number of remembered flows is capped. Flows are spread over several shards
+// using their key.
+//
+// Buckets are computed from the reception time of the flows. When exporters
+// use the flow start time as a timestamp (see timestamp-source), copies of
+// the same flow are then put in the same bucket regardless of the export
+// delay. Otherwise, copies exported more than a window apart are not detected.
+//
+// The deduplicator can also hold flows until a deadline: this is used to wait
+// for a copy entering the network which would replace them.
+type deduplicator struct {
+	seed   maphash.Seed
+	window uint64
+	shards []deduplicatorShard
+}
+
+// deduplicatorShard is a shard of the deduplicator.
+type deduplicatorShard struct {
+	lock       sync.Mutex
+	maxEntries int
+
+	bucket   uint64
+	current  map[uint64]netip.Addr
+	previous map[uint64]netip.Addr
+
+	held      map[uint64][]heldFlow
+	heldCount int
+}
+
+// heldFlow is a flow held by the deduplicator until its deadline.
+type heldFlow struct {
+	exporter string
+	flow     *schema.FlowMessage
+	deadline time.Time
+}
+
+// newDeduplicator creates a new deduplicator using the provided window. The
+// maximum number of entries is split between the shards.
+func newDeduplicator(window time.Duration, maxEntries uint, shards int) *deduplicator {
+	seconds := uint64(window.Seconds())
+	if seconds == 0 {
+		seconds = 1
+	}
+	for shards > 1 && uint(shards) > maxEntries {
+		shards /= 2
+	}
+	d := &deduplicator{
+		seed:   maphash.MakeSeed(),
+		window: seconds,
+		shards: make([]deduplicatorShard, shards),
+	}
+	for i := range d.shards {
+		d.shards[i] = deduplicatorShard{
+			maxEntries: int((maxEntries + uint(shards) - 1) / uint(shards)),
+			current:    map[uint64]netip.Addr{},
+			previous:   map[uint64]netip.Addr{},
+			held:       map[uint64][]heldFlow{},
+		}
+	}
+	return d
+}
+
+// key computes the hash identifying a flow independently of its exporter.
+func (d *deduplicator) key(srcAddr, dstAddr netip.Addr, proto, srcPort, dstPort uint64) uint64 {
+	var buf [37]byte
+	src := srcAddr.As16()
+	dst := dstAddr.As16()
+	copy(buf[0:16], src[:])
+	copy(buf[16:32], dst[:])
+	buf[32] = byte(proto)
+	binary.BigEndian.PutUint16(buf[33:35], uint16(srcPort))
+	binary.BigEndian.PutUint16(buf[35:37], uint16(dstPort))
+	return maphash.Bytes(d.seed, buf[:])
+}
+
+// shard returns the shard for the provided key.
+func (d *deduplicator) shard(key uint64) *deduplicatorShard {
+	return &d.shards[key%uint64(len(d.shards))]
+}
+
+// check tells if a flow with the provided key was already seen from another
+// exporter during the window. When record is true and the flow was not seen,
+// it is remembered. It also returns the number of remembered flows evicted to
+// respect the cap.
+func (d *deduplicator) check(timeReceived uint64, key uint64, exporter netip.Addr, record bool) (duplicate bool, evicted int) {
+	s := d.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Rotate buckets. Late flows are considered to be in the current bucket.
+	bucket := timeReceived / d.window
+	if bucket == s.bucket+1 {
+		s.previous = s.current
+		s.current = map[uint64]netip.Addr{}
+		s.bucket = bucket
+	} else if bucket > s.bucket+1 {
+		s.previous = map[uint64]netip.Addr{}
+		s.current = map[uint64]netip.Addr{}
+		s.bucket = bucket
+	}
+
+	seenFrom, ok := s.current[key]
+	if !ok {
+		seenFrom, ok = s.previous[key]
+	}
+	if ok {
+		return seenFrom != exporter, 0
+	}
+	if !record {
+		return false, 0
+	}
+
+	// Evict older entries first
+	for len(s.current)+len(s.previous) >= s.maxEntries {
+		victims := s.previous
+		if len(victims) == 0 {
+			victims = s.current
+		}
+		for k := range victims {
+			delete(victims, k)
+			break
+		}
+		evicted++
+	}
+	s.current[key] = exporter
+	return false, evicted
+}
+
+// hold keeps a flow with the provided key until the provided deadline. It
+// returns false when the flow cannot be held because the shard is full.
+func (d *deduplicator) hold(key uint64, exporter string, flow *schema.FlowMessage, deadline time.Time) bool {
+	s := d.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.heldCount >= s.maxEntries {
+		return false
+	}
+	s.held[key] = append(s.held[key], heldFlow{exporter, flow, deadline})
+	s.heldCount++
+	return true
+}
+
+// cancel drops the held flows with the provided key reported by another
+// exporter. It returns the exporters of the dropped flows.
+func (d *deduplicator) cancel(key uint64, exporter netip.Addr) []string {
+	s := d.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	flows, ok := s.held[key]
+	if !ok {
+		return nil
+	}
+	var dropped []string
+	kept := flows[:0]
+	for _, hf := range flows {
+		if hf.flow.ExporterAddress == exporter {
+			kept = append(kept, hf)
+			continue
+		}
+		dropped = append(dropped, hf.exporter)
+	}
+	s.heldCount -= len(dropped)
+	if len(kept) == 0 {
+		delete(s.held, key)
+	} else {
+		s.held[key] = kept
+	}
+	return dropped
+}
+
+// release removes and returns the held flows whose deadline is not after the
+// provided time. With a zero time, all held flows are returned.
+func (d *deduplicator) release(now time.Time) []heldFlow {
+	var released []heldFlow
+	for i := range d.shards {
+		s := &d.shards[i]
+		s.lock.Lock()
+		for key, flows := range s.held {
+			kept := flows[:0]
+			for _, hf := range flows {
+				if now.IsZero() || !hf.deadline.After(now) {
+					released = append(released, hf)
+					continue
+				}
+				kept = append(kept, hf)
+			}
+			if len(kept) == 0 {
+				delete(s.held, key)
+			} else {
+				s.held[key] = kept
+			}
+		}
+		s.heldCount = 0
+		for _, flows := range s.held {
+			s.heldCount += len(flows)
+		}
+		s.lock.Unlock()
+	}
+	return released
+}
+
+// heldSize returns the number of held flows.
+func (d *deduplicator) heldSize() int {
+	total := 0
+	for i := range d.shards {
+		s := &d.shards[i]
+		s.lock.Lock()
+		total += s.heldCount
+		s.lock.Unlock()
+	}
+	return total
+}
+
+// size returns the number of remembered flows.
+func (d *deduplicator) size() int {
+	total := 0
+	for i := range d.shards {
+		s := &d.shards[i]
+		s.lock.Lock()
+		total += len(s.current) + len(s.previous)
+		s.lock.Unlock()
+	}
+	return total
+}
+
+// deduplicateFlow looks for the same flow reported by another exporter and
+// applies the configured action. It returns true if the flow should not be
+// forwarded now: it is either dropped or held to be forwarded later.
+func (c *Component) deduplicateFlow(exporterStr string, flow *schema.FlowMessage) (skip bool) {
+	if c.deduplicator == nil {
+		return false
+	}
+	proto, _ := c.d.Schema.ProtobufLookupVarint(flow, schema.ColumnProto)
+	srcPort, _ := c.d.Schema.ProtobufLookupVarint(flow, schema.ColumnSrcPort)
+	dstPort, _ := c.d.Schema.ProtobufLookupVarint(flow, schema.ColumnDstPort)
+	key := c.deduplicator.key(flow.SrcAddr, flow.DstAddr, proto, srcPort, dstPort)
+
+	// With keep-ingress, only flows entering through an external interface
+	// are remembered and they are never dropped. Other flows are dropped if
+	// such a copy was already seen. Otherwise, they are held until the end
+	// of the window as this copy may still come.
+	if c.config.Deduplication.Action == DeduplicationActionKeepIngress {
+		boundary, _ := c.d.Schema.ProtobufLookupVarint(flow, schema.ColumnInIfBoundary)
+		if schema.InterfaceBoundary(boundary) == schema.InterfaceBoundaryExternal {
+			_, evicted := c.deduplicator.check(flow.TimeReceived, key, flow.ExporterAddress, true)
+			c.metrics.deduplicationEvicted.Add(float64(evicted))
+			for _, exporter := range c.deduplicator.cancel(key, flow.ExporterAddress) {
+				c.metrics.deduplicationDuplicates.WithLabelValues(exporter).Inc()
+			}
+			return false
+		}
+		if duplicate, _ := c.deduplicator.check(flow.TimeReceived, key, flow.ExporterAddress, false); duplicate {
+			c.metrics.deduplicationDuplicates.WithLabelValues(exporterStr).Inc()
+			return true
+		}
+		return c.deduplicator.hold(key, exporterStr, flow, time.Now().Add(c.config.Deduplication.Window))
+	}
+
+	duplicate, evicted := c.deduplicator.check(flow.TimeReceived, key, flow.ExporterAddress, true)
+	c.metrics.deduplicationEvicted.Add(float64(evicted))
+	if !duplicate {
+		return false
+	}
+	c.metrics.deduplicationDuplicates.WithLabelValues(exporterStr).Inc()
+	switch c.config.Deduplication.Action {
+	case DeduplicationActionMark:
+		c.d.Schema.ProtobufAppendVarint(flow, schema.ColumnDuplicate, 1)
+		return false
+	default:
+		return true
+	}
+}
+
+// releaseHeldFlows forwards the flows held by the deduplicator whose deadline
+// is not after the provided time. With a zero time, all held flows are
+// forwarded.
+func (c *Component) releaseHeldFlows(now time.Time) {
+	for _, hf := range c.deduplicator.release(now) {
+		c.forwardFlow(hf.exporter, hf.flow)
+	}
+}
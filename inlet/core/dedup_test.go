@@ -0,0 +1,235 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/httpserver"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/flow"
+	"akvorado/inlet/kafka"
+	"akvorado/inlet/metadata"
+	"akvorado/inlet/routing"
+)
+
+func TestDeduplicator(t *testing.T) {
+	d := newDeduplicator(10*time.Second, 3, 1)
+	exporter1 := netip.MustParseAddr("::ffff:192.0.2.1")
+	exporter2 := netip.MustParseAddr("::ffff:192.0.2.2")
+	src := netip.MustParseAddr("::ffff:198.51.100.1")
+	dst := netip.MustParseAddr("::ffff:203.0.113.1")
+	key1 := d.key(src, dst, 6, 443, 34567)
+	key2 := d.key(src, dst, 6, 443, 34568)
+	key3 := d.key(dst, src, 6, 34567, 443)
+	key4 := d.key(src, dst, 17, 443, 34567)
+	key5 := d.key(src, dst, 17, 443, 34568)
+
+	cases := []struct {
+		Description string
+		Time        uint64
+		Key         uint64
+		Exporter    netip.Addr
+		Record      bool
+		Duplicate   bool
+		Evicted     int
+	}{
+		{"first flow", 1000, key1, exporter1, true, false, 0},
+		{"same flow, same exporter", 1001, key1, exporter1, true, false, 0},
+		{"same flow, other exporter", 1002, key1, exporter2, true, true, 0},
+		{"other flow", 1003, key2, exporter2, true, false, 0},
+		{"not recorded", 1004, key3, exporter1, false, false, 0},
+		{"previous bucket", 1012, key1, exporter2, true, true, 0},
+		{"third flow", 1013, key3, exporter1, true, false, 0},
+		{"eviction from previous bucket", 1014, key4, exporter1, true, false, 1},
+		{"eviction again", 1015, key5, exporter1, true, false, 1},
+		{"evicted flow", 1016, key1, exporter2, true, false, 1},
+		{"expired", 1035, key1, exporter2, true, false, 0},
+	}
+	for _, tc := range cases {
+		duplicate, evicted := d.check(tc.Time, tc.Key, tc.Exporter, tc.Record)
+		if duplicate != tc.Duplicate || evicted != tc.Evicted {
+			t.Errorf("check(%s) = %v, %d, expected %v, %d", tc.Description,
+				duplicate, evicted, tc.Duplicate, tc.Evicted)
+		}
+	}
+	if got := d.size(); got != 1 {
+		t.Errorf("size() = %d, expected 1", got)
+	}
+}
+
+func TestDeduplicatorShards(t *testing.T) {
+	d := newDeduplicator(10*time.Second, 1000, 8)
+	if len(d.shards) != 8 {
+		t.Fatalf("len(shards) = %d, expected 8", len(d.shards))
+	}
+	exporter1 := netip.MustParseAddr("::ffff:192.0.2.1")
+	exporter2 := netip.MustParseAddr("::ffff:192.0.2.2")
+	dst := netip.MustParseAddr("::ffff:203.0.113.1")
+
+	var wg sync.WaitGroup
+	for _, exporter := range []netip.Addr{exporter1, exporter2} {
+		wg.Add(1)
+		go func(exporter netip.Addr) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				src := netip.AddrFrom4([4]byte{198, 51, 100, byte(i)})
+				d.check(1000, d.key(src, dst, 6, 443, 34567), exporter, true)
+			}
+		}(exporter)
+	}
+	wg.Wait()
+	if got := d.size(); got != 100 {
+		t.Errorf("size() = %d, expected 100", got)
+	}
+
+	// Each flow was seen first from one of the exporters.
+	src := netip.AddrFrom4([4]byte{198, 51, 100, 10})
+	key := d.key(src, dst, 6, 443, 34567)
+	duplicate1, _ := d.check(1001, key, exporter1, true)
+	duplicate2, _ := d.check(1001, key, exporter2, true)
+	if duplicate1 == duplicate2 {
+		t.Errorf("check() = %v, %v, expected only one duplicate", duplicate1, duplicate2)
+	}
+
+	// Small caps reduce the number of shards
+	if d := newDeduplicator(10*time.Second, 3, 8); len(d.shards) != 2 {
+		t.Errorf("len(shards) = %d, expected 2", len(d.shards))
+	}
+}
+
+func TestDeduplicatorHold(t *testing.T) {
+	d := newDeduplicator(10*time.Second, 2, 1)
+	exporter1 := netip.MustParseAddr("::ffff:192.0.2.1")
+	exporter2 := netip.MustParseAddr("::ffff:192.0.2.2")
+	src := netip.MustParseAddr("::ffff:198.51.100.1")
+	dst := netip.MustParseAddr("::ffff:203.0.113.1")
+	key1 := d.key(src, dst, 6, 443, 34567)
+	key2 := d.key(src, dst, 6, 443, 34568)
+	now := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+
+	flow1 := &schema.FlowMessage{ExporterAddress: exporter1}
+	flow2 := &schema.FlowMessage{ExporterAddress: exporter1}
+	if !d.hold(key1, "192.0.2.1", flow1, now.Add(10*time.Second)) {
+		t.Fatal("hold() = false, expected true")
+	}
+	if !d.hold(key2, "192.0.2.1", flow2, now.Add(20*time.Second)) {
+		t.Fatal("hold() = false, expected true")
+	}
+	if d.hold(key2, "192.0.2.1", flow2, now.Add(20*time.Second)) {
+		t.Fatal("hold() = true, expected false when full")
+	}
+	if got := d.heldSize(); got != 2 {
+		t.Errorf("heldSize() = %d, expected 2", got)
+	}
+
+	// Copies from the same exporter are not cancelled.
+	if got := d.cancel(key1, exporter1); len(got) != 0 {
+		t.Errorf("cancel() = %v, expected nothing", got)
+	}
+	if diff := helpers.Diff(d.cancel(key1, exporter2), []string{"192.0.2.1"}); diff != "" {
+		t.Errorf("cancel() (-got, +want):\n%s", diff)
+	}
+
+	// Only expired flows are released.
+	if got := d.release(now.Add(15 * time.Second)); len(got) != 0 {
+		t.Errorf("release() = %v, expected nothing", got)
+	}
+	got := d.release(now.Add(20 * time.Second))
+	if len(got) != 1 || got[0].flow != flow2 {
+		t.Errorf("release() = %v, expected second flow", got)
+	}
+	if got := d.heldSize(); got != 0 {
+		t.Errorf("heldSize() = %d, expected 0", got)
+	}
+}
+
+func TestDeduplicateKeepIngress(t *testing.T) {
+	r := reporter.NewMock(t)
+	daemonComponent := daemon.NewMock(t)
+	metadataComponent := metadata.NewMock(t, r, metadata.DefaultConfiguration(),
+		metadata.Dependencies{Daemon: daemonComponent})
+	flowComponent := flow.NewMock(t, r, flow.DefaultConfiguration())
+	kafkaComponent, _ := kafka.NewMock(t, r, kafka.DefaultConfiguration())
+	httpComponent := httpserver.NewMock(t, r)
+	routingComponent := routing.NewMock(t, r)
+	sch := schema.NewMock(t)
+	configuration := DefaultConfiguration()
+	configuration.Deduplication.Window = 10 * time.Second
+	configuration.Deduplication.Action = DeduplicationActionKeepIngress
+	c, err := New(r, configuration, Dependencies{
+		Daemon:   daemonComponent,
+		Flow:     flowComponent,
+		Metadata: metadataComponent,
+		Kafka:    kafkaComponent,
+		HTTP:     httpComponent,
+		Routing:  routingComponent,
+		Schema:   sch,
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+
+	newFlow := func(exporter string, srcPort uint64, boundary schema.InterfaceBoundary) *schema.FlowMessage {
+		flow := &schema.FlowMessage{
+			TimeReceived:    1000,
+			ExporterAddress: netip.MustParseAddr("::ffff:" + exporter),
+			SrcAddr:         netip.MustParseAddr("::ffff:198.51.100.1"),
+			DstAddr:         netip.MustParseAddr("::ffff:203.0.113.1"),
+		}
+		sch.ProtobufAppendVarint(flow, schema.ColumnProto, 6)
+		sch.ProtobufAppendVarint(flow, schema.ColumnSrcPort, srcPort)
+		sch.ProtobufAppendVarint(flow, schema.ColumnDstPort, 443)
+		sch.ProtobufAppendVarint(flow, schema.ColumnInIfBoundary, uint64(boundary))
+		return flow
+	}
+
+	// The egress copy arrives before the ingress copy: it is held, then
+	// dropped once the ingress copy is seen.
+	egress := newFlow("192.0.2.2", 34567, schema.InterfaceBoundaryInternal)
+	if skip := c.deduplicateFlow("192.0.2.2", egress); !skip {
+		t.Error("deduplicateFlow(egress) = false, expected true")
+	}
+	ingress := newFlow("192.0.2.1", 34567, schema.InterfaceBoundaryExternal)
+	if skip := c.deduplicateFlow("192.0.2.1", ingress); skip {
+		t.Error("deduplicateFlow(ingress) = true, expected false")
+	}
+
+	// The egress copy arrives after the ingress copy: it is dropped.
+	egress = newFlow("192.0.2.3", 34567, schema.InterfaceBoundaryInternal)
+	if skip := c.deduplicateFlow("192.0.2.3", egress); !skip {
+		t.Error("deduplicateFlow(egress) = false, expected true")
+	}
+
+	// A flow without an ingress copy is held until the end of the window.
+	internal := newFlow("192.0.2.2", 34568, schema.InterfaceBoundaryInternal)
+	if skip := c.deduplicateFlow("192.0.2.2", internal); !skip {
+		t.Error("deduplicateFlow(internal) = false, expected true")
+	}
+	if got := c.deduplicator.release(time.Now()); len(got) != 0 {
+		t.Errorf("release() = %v, expected nothing", got)
+	}
+	got := c.deduplicator.release(time.Now().Add(configuration.Deduplication.Window))
+	if len(got) != 1 || got[0].flow != internal {
+		t.Errorf("release() = %v, expected internal flow", got)
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_core_", "deduplication_")
+	expectedMetrics := map[string]string{
+		`deduplication_duplicate_flows_total{exporter="192.0.2.2"}`: "1",
+		`deduplication_duplicate_flows_total{exporter="192.0.2.3"}`: "1",
+		`deduplication_entries`:               "1",
+		`deduplication_evicted_entries_total`: "0",
+		`deduplication_held_flows`:            "0",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
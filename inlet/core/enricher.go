@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"akvorado/common/schema"
+	"akvorado/inlet/routing/provider"
 )
 
 // exporterAndInterfaceInfo aggregates both exporter info and interface info
@@ -20,7 +21,7 @@ type exporterAndInterfaceInfo struct {
 
 // enrichFlow adds more data to a flow.
 func (c *Component) enrichFlow(exporterIP netip.Addr, exporterStr string, flow *schema.FlowMessage) (skip bool) {
-	var flowExporterName string
+	var flowExporterName, flowExporterLocation string
 	var flowInIfName, flowInIfDescription, flowOutIfName, flowOutIfDescription string
 	var flowInIfSpeed, flowOutIfSpeed, flowInIfIndex, flowOutIfIndex uint32
 	var flowInIfVlan, flowOutIfVlan uint16
@@ -37,6 +38,7 @@ func (c *Component) enrichFlow(exporterIP netip.Addr, exporterStr string, flow *
 			skip = true
 		} else {
 			flowExporterName = answer.Exporter.Name
+			flowExporterLocation = answer.Exporter.Location
 			expClassification.Region = answer.Exporter.Region
 			expClassification.Role = answer.Exporter.Role
 			expClassification.Tenant = answer.Exporter.Tenant
@@ -45,7 +47,7 @@ func (c *Component) enrichFlow(exporterIP netip.Addr, exporterStr string, flow *
 			flowInIfIndex = flow.InIf
 			flowInIfName = answer.Interface.Name
 			flowInIfDescription = answer.Interface.Description
-			flowInIfSpeed = uint32(answer.Interface.Speed)
+			flowInIfSpeed = answer.Interface.Speed
 			inIfClassification.Provider = answer.Interface.Provider
 			inIfClassification.Connectivity = answer.Interface.Connectivity
 			inIfClassification.Boundary = answer.Interface.Boundary
@@ -64,6 +66,7 @@ func (c *Component) enrichFlow(exporterIP netip.Addr, exporterStr string, flow *
 			}
 		} else {
 			flowExporterName = answer.Exporter.Name
+			flowExporterLocation = answer.Exporter.Location
 			expClassification.Region = answer.Exporter.Region
 			expClassification.Role = answer.Exporter.Role
 			expClassification.Tenant = answer.Exporter.Tenant
@@ -72,7 +75,7 @@ func (c *Component) enrichFlow(exporterIP netip.Addr, exporterStr string, flow *
 			flowOutIfIndex = flow.OutIf
 			flowOutIfName = answer.Interface.Name
 			flowOutIfDescription = answer.Interface.Description
-			flowOutIfSpeed = uint32(answer.Interface.Speed)
+			flowOutIfSpeed = answer.Interface.Speed
 			outIfClassification.Provider = answer.Interface.Provider
 			outIfClassification.Connectivity = answer.Interface.Connectivity
 			outIfClassification.Boundary = answer.Interface.Boundary
@@ -86,11 +89,12 @@ func (c *Component) enrichFlow(exporterIP netip.Addr, exporterStr string, flow *
 		skip = true
 	}
 
-	if samplingRate, ok := c.config.OverrideSamplingRate.Lookup(exporterIP); ok && samplingRate > 0 {
+	reloadable := c.reloadable.Load()
+	if samplingRate, ok := reloadable.OverrideSamplingRate.Lookup(exporterIP); ok && samplingRate > 0 {
 		flow.SamplingRate = uint32(samplingRate)
 	}
 	if flow.SamplingRate == 0 {
-		if samplingRate, ok := c.config.DefaultSamplingRate.Lookup(exporterIP); ok && samplingRate > 0 {
+		if samplingRate, ok := reloadable.DefaultSamplingRate.Lookup(exporterIP); ok && samplingRate > 0 {
 			flow.SamplingRate = uint32(samplingRate)
 		} else {
 			c.metrics.flowsErrors.WithLabelValues(exporterStr, "sampling rate missing").Inc()
@@ -103,7 +107,7 @@ func (c *Component) enrichFlow(exporterIP netip.Addr, exporterStr string, flow *
 	}
 
 	// Classification
-	if !c.classifyExporter(t, exporterStr, flowExporterName, flow, expClassification) ||
+	if !c.classifyExporter(t, exporterStr, flowExporterName, flowExporterLocation, flow, expClassification) ||
 		!c.classifyInterface(t, exporterStr, flowExporterName, flow,
 			flowOutIfIndex, flowOutIfName, flowOutIfDescription, flowOutIfSpeed, flowOutIfVlan, outIfClassification,
 			false) ||
@@ -115,8 +119,12 @@ func (c *Component) enrichFlow(exporterIP netip.Addr, exporterStr string, flow *
 	}
 
 	ctx := c.t.Context(context.Background())
-	sourceRouting := c.d.Routing.Lookup(ctx, flow.SrcAddr, netip.Addr{}, flow.ExporterAddress)
-	destRouting := c.d.Routing.Lookup(ctx, flow.DstAddr, flow.NextHop, flow.ExporterAddress)
+	vrf, rd := c.d.Routing.VRF(flow.ExporterAddress, flow.InIf, flow.SrcVlan)
+	sourceRouting := c.d.Routing.LookupVRF(ctx, flow.SrcAddr, netip.Addr{}, flow.ExporterAddress, rd)
+	destRouting := c.d.Routing.LookupVRF(ctx, flow.DstAddr, flow.NextHop, flow.ExporterAddress, rd)
+	if vrf != "" {
+		c.d.Schema.ProtobufAppendBytes(flow, schema.ColumnVRF, []byte(vrf))
+	}
 
 	// set prefix len according to user config
 	flow.SrcNetMask = c.getNetMask(flow.SrcNetMask, sourceRouting.NetMask)
@@ -126,16 +134,31 @@ func (c *Component) enrichFlow(exporterIP netip.Addr, exporterStr string, flow *
 	flow.NextHop = c.getNextHop(flow.NextHop, destRouting.NextHop)
 
 	// set asns according to user config
-	flow.SrcAS = c.getASNumber(flow.SrcAS, sourceRouting.ASN)
-	flow.DstAS = c.getASNumber(flow.DstAS, destRouting.ASN)
-	for _, comm := range destRouting.Communities {
-		c.d.Schema.ProtobufAppendVarint(flow, schema.ColumnDstCommunities, uint64(comm))
-	}
-	if !flow.GotASPath {
-		for _, asn := range destRouting.ASPath {
-			c.d.Schema.ProtobufAppendVarint(flow, schema.ColumnDstASPath, uint64(asn))
-		}
+	flow.SrcAS = c.getASNumber("src", c.config.SrcASNProviders, flow.SrcAS, sourceRouting.ASN)
+	flow.DstAS = c.getASNumber("dst", c.config.DstASNProviders, flow.DstAS, destRouting.ASN)
+
+	// set prefix lengths from the routing table
+	c.setNetPrefixLen(flow, schema.ColumnSrcNetPrefixLen, sourceRouting)
+	c.setNetPrefixLen(flow, schema.ColumnDstNetPrefixLen, destRouting)
+
+	// set next hop, local preference and MED of the destination route
+	if destRouting.Found {
+		c.d.Schema.ProtobufAppendIP(flow, schema.ColumnDstNextHop, destRouting.NextHop)
+		c.d.Schema.ProtobufAppendVarint(flow, schema.ColumnDstLocalPref, uint64(destRouting.LocalPref))
+		c.d.Schema.ProtobufAppendVarint(flow, schema.ColumnDstMED, uint64(destRouting.MED))
+	}
+
+	// set RPKI validation status of the destination route
+	if destRouting.RPKIStatus != schema.RPKIStatusUnknown {
+		c.d.Schema.ProtobufAppendVarint(flow, schema.ColumnDstRPKIStatus, uint64(destRouting.RPKIStatus))
+	}
+
+	// set AS path and communities according to user config
+	flow.DstASPath = c.getBGPAttribute(flow.DstASPath, destRouting.ASPath)
+	if c.config.MaxASPathLength > 0 && len(flow.DstASPath) > c.config.MaxASPathLength {
+		flow.DstASPath = flow.DstASPath[:c.config.MaxASPathLength]
 	}
+	flow.DstCommunities = c.getBGPAttribute(flow.DstCommunities, destRouting.Communities)
 	for _, comm := range destRouting.LargeCommunities {
 		c.d.Schema.ProtobufAppendVarintForce(flow,
 			schema.ColumnDstLargeCommunitiesASN, uint64(comm.ASN))
@@ -145,6 +168,30 @@ func (c *Component) enrichFlow(exporterIP netip.Addr, exporterStr string, flow *
 			schema.ColumnDstLargeCommunitiesLocalData2, uint64(comm.LocalData2))
 	}
 
+	// Flow classification, once the flow is enriched
+	if !c.classifyFlow(exporterStr, flowExporterName, flow) {
+		return true
+	}
+
+	// Filters, before external enrichment
+	if c.filterFlow(exporterStr, flowExporterName, flow) {
+		return true
+	}
+
+	// External enrichment, never blocking
+	if c.external != nil {
+		c.external.enrich(t, flow)
+	}
+
+	// Threat lists
+	if c.threatLists != nil {
+		c.threatLists.enrich(c.d.Schema, flow)
+	}
+
+	// Address truncation, once all lookups are done
+	flow.SrcAddr = c.truncateAddress(flow.SrcAddr)
+	flow.DstAddr = c.truncateAddress(flow.DstAddr)
+
 	c.d.Schema.ProtobufAppendBytes(flow, schema.ColumnExporterName, []byte(flowExporterName))
 	c.d.Schema.ProtobufAppendVarint(flow, schema.ColumnInIfSpeed, uint64(flowInIfSpeed))
 	c.d.Schema.ProtobufAppendVarint(flow, schema.ColumnOutIfSpeed, uint64(flowOutIfSpeed))
@@ -152,12 +199,10 @@ func (c *Component) enrichFlow(exporterIP netip.Addr, exporterStr string, flow *
 	return
 }
 
-// getASNumber retrieves the AS number for a flow, depending on user preferences.
-func (c *Component) getASNumber(flowAS, bmpAS uint32) (asn uint32) {
-	for _, provider := range c.config.ASNProviders {
-		if asn != 0 {
-			break
-		}
+// getASNumber retrieves the AS number for a flow, depending on user
+// preferences. The direction is only used for metrics.
+func (c *Component) getASNumber(direction string, providers []ASNProvider, flowAS, bmpAS uint32) (asn uint32) {
+	for _, provider := range providers {
 		switch provider {
 		case ASNProviderFlow:
 			asn = flowAS
@@ -174,8 +219,39 @@ func (c *Component) getASNumber(flowAS, bmpAS uint32) (asn uint32) {
 				asn = 0
 			}
 		}
+		if asn != 0 {
+			c.metrics.asnProviderHits.WithLabelValues(direction, provider.String()).Inc()
+			return asn
+		}
+	}
+	c.metrics.asnProviderMisses.WithLabelValues(direction).Inc()
+	return 0
+}
+
+// truncateAddress truncates an address according to user config.
+func (c *Component) truncateAddress(addr netip.Addr) netip.Addr {
+	truncation, ok := c.config.AddressTruncation.Lookup(addr)
+	if !ok {
+		return addr
+	}
+	bits := truncation.IPv6
+	switch {
+	case addr.Is4In6():
+		if truncation.IPv4 == 0 {
+			return addr
+		}
+		bits = 96 + truncation.IPv4
+	case addr.Is4():
+		bits = truncation.IPv4
+	}
+	if bits == 0 {
+		return addr
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return addr
 	}
-	return asn
+	return prefix.Addr()
 }
 
 // getNetMask retrieves the prefix length for a flow, depending on user preferences.
@@ -194,6 +270,34 @@ func (c *Component) getNetMask(flowMask, bmpMask uint8) (mask uint8) {
 	return mask
 }
 
+// setNetPrefixLen sets the prefix length of the route found for a flow. When no
+// route is found, the prefix length is set to an unknown value to not confuse it
+// with a default route.
+func (c *Component) setNetPrefixLen(flow *schema.FlowMessage, column schema.ColumnKey, result provider.LookupResult) {
+	prefixLen := uint64(schema.NetPrefixLenUnknown)
+	if result.Found {
+		prefixLen = uint64(result.NetMask)
+	}
+	c.d.Schema.ProtobufAppendVarintForce(flow, column, prefixLen)
+}
+
+// getBGPAttribute retrieves an AS path or a list of communities for a flow,
+// depending on user preferences.
+func (c *Component) getBGPAttribute(flowValue, routingValue []uint32) (value []uint32) {
+	for _, provider := range c.config.BGPProviders {
+		if len(value) > 0 {
+			break
+		}
+		switch provider {
+		case BGPProviderFlow:
+			value = flowValue
+		case BGPProviderRouting:
+			value = routingValue
+		}
+	}
+	return value
+}
+
 func (c *Component) getNextHop(flowNextHop netip.Addr, bmpNextHop netip.Addr) (nextHop netip.Addr) {
 	nextHop = netip.IPv6Unspecified()
 	for _, provider := range c.config.NetProviders {
@@ -222,20 +326,23 @@ func (c *Component) writeExporter(flow *schema.FlowMessage, classification expor
 	return true
 }
 
-func (c *Component) classifyExporter(t time.Time, ip string, name string, flow *schema.FlowMessage, classification exporterClassification) bool {
+func (c *Component) classifyExporter(t time.Time, ip string, name string, location string, flow *schema.FlowMessage, classification exporterClassification) bool {
 	// we already have the info provided by the metadata component
 	if (classification != exporterClassification{}) {
 		return c.writeExporter(flow, classification)
 	}
-	if len(c.config.ExporterClassifiers) == 0 {
+	classifiers := c.reloadable.Load().ExporterClassifiers
+	if len(classifiers) == 0 {
 		return true
 	}
-	si := exporterInfo{IP: ip, Name: name}
+	si := exporterInfo{IP: ip, Name: name, Location: location}
 	if classification, ok := c.classifierExporterCache.Get(t, si); ok {
+		c.metrics.classifierCacheHits.WithLabelValues("exporter").Inc()
 		return c.writeExporter(flow, classification)
 	}
+	c.metrics.classifierCacheMisses.WithLabelValues("exporter").Inc()
 
-	for idx, rule := range c.config.ExporterClassifiers {
+	for idx, rule := range classifiers {
 		if err := rule.exec(si, &classification); err != nil {
 			c.classifierErrLogger.Err(err).
 				Str("type", "exporter").
@@ -254,6 +361,52 @@ func (c *Component) classifyExporter(t time.Time, ip string, name string, flow *
 	return c.writeExporter(flow, classification)
 }
 
+// flowInfo extracts the information exposed to flow classifiers and filters.
+func (c *Component) flowInfo(flow *schema.FlowMessage) flowInfo {
+	srcPort, _ := c.d.Schema.ProtobufLookupVarint(flow, schema.ColumnSrcPort)
+	dstPort, _ := c.d.Schema.ProtobufLookupVarint(flow, schema.ColumnDstPort)
+	proto, _ := c.d.Schema.ProtobufLookupVarint(flow, schema.ColumnProto)
+	return flowInfo{
+		SrcAddr: flow.SrcAddr.Unmap().String(),
+		DstAddr: flow.DstAddr.Unmap().String(),
+		SrcPort: uint16(srcPort),
+		DstPort: uint16(dstPort),
+		Proto:   uint8(proto),
+		SrcAS:   flow.SrcAS,
+		DstAS:   flow.DstAS,
+	}
+}
+
+func (c *Component) classifyFlow(ip string, name string, flow *schema.FlowMessage) bool {
+	classifiers := c.reloadable.Load().FlowClassifiers
+	if len(classifiers) == 0 {
+		return true
+	}
+	si := exporterInfo{IP: ip, Name: name}
+	fi := c.flowInfo(flow)
+
+	var classification flowClassification
+	for idx, rule := range classifiers {
+		if err := rule.exec(si, fi, &classification); err != nil {
+			c.classifierErrLogger.Err(err).
+				Str("type", "flow").
+				Int("index", idx).
+				Str("exporter", name).
+				Msg("error executing classifier")
+			c.metrics.classifierErrors.WithLabelValues("flow", strconv.Itoa(idx)).Inc()
+			break
+		}
+		if classification.Reject {
+			return false
+		}
+		if classification.Class != "" {
+			break
+		}
+	}
+	c.d.Schema.ProtobufAppendBytes(flow, schema.ColumnFlowClass, []byte(classification.Class))
+	return true
+}
+
 func (c *Component) writeInterface(flow *schema.FlowMessage, classification interfaceClassification, directionIn bool) bool {
 	if classification.Reject {
 		return false
@@ -293,7 +446,8 @@ func (c *Component) classifyInterface(
 		classification.Description = ifDescription
 		return c.writeInterface(fl, classification, directionIn)
 	}
-	if len(c.config.InterfaceClassifiers) == 0 {
+	classifiers := c.reloadable.Load().InterfaceClassifiers
+	if len(classifiers) == 0 {
 		classification.Name = ifName
 		classification.Description = ifDescription
 		c.writeInterface(fl, classification, directionIn)
@@ -312,10 +466,12 @@ func (c *Component) classifyInterface(
 		Interface: ii,
 	}
 	if classification, ok := c.classifierInterfaceCache.Get(t, key); ok {
+		c.metrics.classifierCacheHits.WithLabelValues("interface").Inc()
 		return c.writeInterface(fl, classification, directionIn)
 	}
+	c.metrics.classifierCacheMisses.WithLabelValues("interface").Inc()
 
-	for idx, rule := range c.config.InterfaceClassifiers {
+	for idx, rule := range classifiers {
 		err := rule.exec(si, ii, &classification)
 		if err != nil {
 			c.classifierErrLogger.Err(err).
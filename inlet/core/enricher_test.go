@@ -532,6 +532,168 @@ ClassifyProviderRegex(Interface.Description, "^Transit: ([^ ]+)", "$1")`,
 				},
 			},
 		},
+		{
+			Name: "limit AS path length",
+			Configuration: gin.H{
+				"maxaspathlength": 2,
+			},
+			InputFlow: func() *schema.FlowMessage {
+				return &schema.FlowMessage{
+					SamplingRate:    1000,
+					ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+					InIf:            100,
+					OutIf:           200,
+					SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.142"),
+					DstAddr:         netip.MustParseAddr("::ffff:192.0.2.10"),
+				}
+			},
+			OutputFlow: &schema.FlowMessage{
+				SamplingRate:    1000,
+				ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+				SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.142"),
+				DstAddr:         netip.MustParseAddr("::ffff:192.0.2.10"),
+				SrcAS:           1299,
+				DstAS:           174,
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnExporterName:                  "192_0_2_142",
+					schema.ColumnInIfName:                      "Gi0/0/100",
+					schema.ColumnOutIfName:                     "Gi0/0/200",
+					schema.ColumnInIfDescription:               "Interface 100",
+					schema.ColumnOutIfDescription:              "Interface 200",
+					schema.ColumnInIfSpeed:                     1000,
+					schema.ColumnOutIfSpeed:                    1000,
+					schema.ColumnDstASPath:                     []uint32{64200, 1299},
+					schema.ColumnDstCommunities:                []uint32{100, 200, 400},
+					schema.ColumnDstLargeCommunitiesASN:        []int32{64200},
+					schema.ColumnDstLargeCommunitiesLocalData1: []int32{2},
+					schema.ColumnDstLargeCommunitiesLocalData2: []int32{3},
+					schema.ColumnSrcNetMask:                    27,
+					schema.ColumnDstNetMask:                    27,
+				},
+			},
+		},
+		{
+			Name: "truncate addresses after routing lookups",
+			Configuration: gin.H{
+				"addresstruncation": gin.H{"ipv4": 24, "ipv6": 48},
+			},
+			InputFlow: func() *schema.FlowMessage {
+				return &schema.FlowMessage{
+					SamplingRate:    1000,
+					ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+					InIf:            100,
+					OutIf:           200,
+					SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.142"),
+					DstAddr:         netip.MustParseAddr("::ffff:192.0.2.10"),
+				}
+			},
+			OutputFlow: &schema.FlowMessage{
+				SamplingRate:    1000,
+				ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+				SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.0"),
+				DstAddr:         netip.MustParseAddr("::ffff:192.0.2.0"),
+				SrcAS:           1299,
+				DstAS:           174,
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnExporterName:                  "192_0_2_142",
+					schema.ColumnInIfName:                      "Gi0/0/100",
+					schema.ColumnOutIfName:                     "Gi0/0/200",
+					schema.ColumnInIfDescription:               "Interface 100",
+					schema.ColumnOutIfDescription:              "Interface 200",
+					schema.ColumnInIfSpeed:                     1000,
+					schema.ColumnOutIfSpeed:                    1000,
+					schema.ColumnDstASPath:                     []uint32{64200, 1299, 174},
+					schema.ColumnDstCommunities:                []uint32{100, 200, 400},
+					schema.ColumnDstLargeCommunitiesASN:        []int32{64200},
+					schema.ColumnDstLargeCommunitiesLocalData1: []int32{2},
+					schema.ColumnDstLargeCommunitiesLocalData2: []int32{3},
+					schema.ColumnSrcNetMask:                    27,
+					schema.ColumnDstNetMask:                    27,
+				},
+			},
+		},
+		{
+			Name:          "AS path and communities from flow",
+			Configuration: gin.H{},
+			InputFlow: func() *schema.FlowMessage {
+				return &schema.FlowMessage{
+					SamplingRate:    1000,
+					ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+					InIf:            100,
+					OutIf:           200,
+					SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.142"),
+					DstAddr:         netip.MustParseAddr("::ffff:192.0.2.10"),
+					DstASPath:       []uint32{65401, 65402},
+					DstCommunities:  []uint32{1000},
+				}
+			},
+			OutputFlow: &schema.FlowMessage{
+				SamplingRate:    1000,
+				ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+				SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.142"),
+				DstAddr:         netip.MustParseAddr("::ffff:192.0.2.10"),
+				SrcAS:           1299,
+				DstAS:           174,
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnExporterName:                  "192_0_2_142",
+					schema.ColumnInIfName:                      "Gi0/0/100",
+					schema.ColumnOutIfName:                     "Gi0/0/200",
+					schema.ColumnInIfDescription:               "Interface 100",
+					schema.ColumnOutIfDescription:              "Interface 200",
+					schema.ColumnInIfSpeed:                     1000,
+					schema.ColumnOutIfSpeed:                    1000,
+					schema.ColumnDstASPath:                     []uint32{65401, 65402},
+					schema.ColumnDstCommunities:                []uint32{1000},
+					schema.ColumnDstLargeCommunitiesASN:        []int32{64200},
+					schema.ColumnDstLargeCommunitiesLocalData1: []int32{2},
+					schema.ColumnDstLargeCommunitiesLocalData2: []int32{3},
+					schema.ColumnSrcNetMask:                    27,
+					schema.ColumnDstNetMask:                    27,
+				},
+			},
+		},
+		{
+			Name: "AS path and communities from routing first",
+			Configuration: gin.H{
+				"bgp-providers": []string{"routing", "flow"},
+			},
+			InputFlow: func() *schema.FlowMessage {
+				return &schema.FlowMessage{
+					SamplingRate:    1000,
+					ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+					InIf:            100,
+					OutIf:           200,
+					SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.142"),
+					DstAddr:         netip.MustParseAddr("::ffff:192.0.2.10"),
+					DstASPath:       []uint32{65401, 65402},
+					DstCommunities:  []uint32{1000},
+				}
+			},
+			OutputFlow: &schema.FlowMessage{
+				SamplingRate:    1000,
+				ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+				SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.142"),
+				DstAddr:         netip.MustParseAddr("::ffff:192.0.2.10"),
+				SrcAS:           1299,
+				DstAS:           174,
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnExporterName:                  "192_0_2_142",
+					schema.ColumnInIfName:                      "Gi0/0/100",
+					schema.ColumnOutIfName:                     "Gi0/0/200",
+					schema.ColumnInIfDescription:               "Interface 100",
+					schema.ColumnOutIfDescription:              "Interface 200",
+					schema.ColumnInIfSpeed:                     1000,
+					schema.ColumnOutIfSpeed:                    1000,
+					schema.ColumnDstASPath:                     []uint32{64200, 1299, 174},
+					schema.ColumnDstCommunities:                []uint32{100, 200, 400},
+					schema.ColumnDstLargeCommunitiesASN:        []int32{64200},
+					schema.ColumnDstLargeCommunitiesLocalData1: []int32{2},
+					schema.ColumnDstLargeCommunitiesLocalData2: []int32{3},
+					schema.ColumnSrcNetMask:                    27,
+					schema.ColumnDstNetMask:                    27,
+				},
+			},
+		},
 	}
 	for _, tc := range cases {
 		t.Run(tc.Name, func(t *testing.T) {
@@ -547,6 +709,20 @@ ClassifyProviderRegex(Interface.Description, "^Transit: ([^ ]+)", "$1")`,
 			routingComponent := routing.NewMock(t, r)
 			routingComponent.PopulateRIB(t)
 
+			// Interface speed and exporter metadata are disabled by default.
+			schemaConfiguration := schema.DefaultConfiguration()
+			schemaConfiguration.Enabled = []schema.ColumnKey{
+				schema.ColumnInIfSpeed,
+				schema.ColumnOutIfSpeed,
+				schema.ColumnExporterGroup,
+				schema.ColumnExporterRole,
+				schema.ColumnExporterSite,
+			}
+			sch, err := schema.New(schemaConfiguration)
+			if err != nil {
+				t.Fatalf("schema.New() error:\n%+v", err)
+			}
+
 			// Prepare a configuration
 			configuration := DefaultConfiguration()
 			decoder, err := mapstructure.NewDecoder(helpers.GetMapStructureDecoderConfig(&configuration))
@@ -565,7 +741,7 @@ ClassifyProviderRegex(Interface.Description, "^Transit: ([^ ]+)", "$1")`,
 				Kafka:    kafkaComponent,
 				HTTP:     httpComponent,
 				Routing:  routingComponent,
-				Schema:   schema.NewMock(t),
+				Schema:   sch,
 			})
 			if err != nil {
 				t.Fatalf("New() error:\n%+v", err)
@@ -660,7 +836,7 @@ func TestGetASNumber(t *testing.T) {
 			if err != nil {
 				t.Fatalf("%sNew() error:\n%+v", tc.Pos, err)
 			}
-			got := c.getASNumber(tc.FlowAS, tc.BMPAS)
+			got := c.getASNumber("src", c.config.SrcASNProviders, tc.FlowAS, tc.BMPAS)
 			if diff := helpers.Diff(got, tc.Expected); diff != "" {
 				t.Fatalf("%sgetASNumber() (-got, +want):\n%s", tc.Pos, diff)
 			}
@@ -668,6 +844,54 @@ func TestGetASNumber(t *testing.T) {
 	}
 }
 
+func TestGetASNumberPerDirection(t *testing.T) {
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration()
+	configuration.SrcASNProviders = []ASNProvider{ASNProviderFlow}
+	configuration.DstASNProviders = []ASNProvider{ASNProviderRouting, ASNProviderFlow}
+	c, err := New(r, configuration, Dependencies{
+		Daemon:  daemon.NewMock(t),
+		Routing: routing.NewMock(t, r),
+		Schema:  schema.NewMock(t),
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+
+	cases := []struct {
+		Pos       helpers.Pos
+		Direction string
+		Providers []ASNProvider
+		FlowAS    uint32
+		BMPAS     uint32
+		Expected  uint32
+	}{
+		{helpers.Mark(), "src", c.config.SrcASNProviders, 12322, 174, 12322},
+		{helpers.Mark(), "src", c.config.SrcASNProviders, 0, 174, 0},
+		{helpers.Mark(), "dst", c.config.DstASNProviders, 12322, 174, 174},
+		{helpers.Mark(), "dst", c.config.DstASNProviders, 12322, 0, 12322},
+		{helpers.Mark(), "dst", c.config.DstASNProviders, 0, 0, 0},
+	}
+	for _, tc := range cases {
+		got := c.getASNumber(tc.Direction, tc.Providers, tc.FlowAS, tc.BMPAS)
+		if diff := helpers.Diff(got, tc.Expected); diff != "" {
+			t.Errorf("%sgetASNumber() (-got, +want):\n%s", tc.Pos, diff)
+		}
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_core_", "asn_")
+	expectedMetrics := map[string]string{
+		`asn_provider_hits_total{direction="dst",provider="flow"}`:    "1",
+		`asn_provider_hits_total{direction="dst",provider="routing"}`: "1",
+		`asn_provider_hits_total{direction="src",provider="flow"}`:    "1",
+		`asn_provider_misses_total{direction="dst"}`:                  "1",
+		`asn_provider_misses_total{direction="src"}`:                  "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Errorf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
 func TestGetNetMask(t *testing.T) {
 	cases := []struct {
 		Pos         helpers.Pos
@@ -724,6 +948,59 @@ func TestGetNetMask(t *testing.T) {
 	}
 }
 
+func TestGetBGPAttribute(t *testing.T) {
+	cases := []struct {
+		Pos          helpers.Pos
+		FlowValue    []uint32
+		RoutingValue []uint32
+		Providers    []BGPProvider
+		Expected     []uint32
+	}{
+		// Flow
+		{helpers.Mark(), nil, nil, []BGPProvider{BGPProviderFlow}, nil},
+		{helpers.Mark(), []uint32{65401}, nil, []BGPProvider{BGPProviderFlow}, []uint32{65401}},
+		{helpers.Mark(), nil, []uint32{174}, []BGPProvider{BGPProviderFlow}, nil},
+		// Routing
+		{helpers.Mark(), nil, nil, []BGPProvider{BGPProviderRouting}, nil},
+		{helpers.Mark(), []uint32{65401}, nil, []BGPProvider{BGPProviderRouting}, nil},
+		{helpers.Mark(), nil, []uint32{174}, []BGPProvider{BGPProviderRouting}, []uint32{174}},
+		// Both, the first provider with a non-empty value is taken
+		{helpers.Mark(), nil, nil, []BGPProvider{BGPProviderRouting, BGPProviderFlow}, nil},
+		{helpers.Mark(), []uint32{65401}, nil, []BGPProvider{BGPProviderRouting, BGPProviderFlow}, []uint32{65401}},
+		{helpers.Mark(), nil, []uint32{174}, []BGPProvider{BGPProviderRouting, BGPProviderFlow}, []uint32{174}},
+		{helpers.Mark(), []uint32{65401}, []uint32{174}, []BGPProvider{BGPProviderRouting, BGPProviderFlow}, []uint32{174}},
+
+		{helpers.Mark(), nil, nil, []BGPProvider{BGPProviderFlow, BGPProviderRouting}, nil},
+		{helpers.Mark(), []uint32{65401}, nil, []BGPProvider{BGPProviderFlow, BGPProviderRouting}, []uint32{65401}},
+		{helpers.Mark(), nil, []uint32{174}, []BGPProvider{BGPProviderFlow, BGPProviderRouting}, []uint32{174}},
+		{helpers.Mark(), []uint32{65401}, []uint32{174}, []BGPProvider{BGPProviderFlow, BGPProviderRouting}, []uint32{65401}},
+	}
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("case %s", tc.Pos), func(t *testing.T) {
+			r := reporter.NewMock(t)
+
+			// We don't need all components as we won't start the component.
+			configuration := DefaultConfiguration()
+			configuration.BGPProviders = tc.Providers
+			routingComponent := routing.NewMock(t, r)
+			routingComponent.PopulateRIB(t)
+
+			c, err := New(r, configuration, Dependencies{
+				Daemon:  daemon.NewMock(t),
+				Routing: routingComponent,
+				Schema:  schema.NewMock(t),
+			})
+			if err != nil {
+				t.Fatalf("%sNew() error:\n%+v", tc.Pos, err)
+			}
+			got := c.getBGPAttribute(tc.FlowValue, tc.RoutingValue)
+			if diff := helpers.Diff(got, tc.Expected); diff != "" {
+				t.Fatalf("%sgetBGPAttribute() (-got, +want):\n%s", tc.Pos, diff)
+			}
+		})
+	}
+}
+
 func TestGetNextHop(t *testing.T) {
 	nh1 := netip.MustParseAddr("2001:db8::1")
 	nh2 := netip.MustParseAddr("2001:db8::2")
@@ -780,3 +1057,53 @@ func TestGetNextHop(t *testing.T) {
 		})
 	}
 }
+
+func TestTruncateAddress(t *testing.T) {
+	cases := []struct {
+		Truncation map[string]AddressTruncationConfiguration
+		Input      string
+		Expected   string
+	}{
+		{nil, "::ffff:192.0.2.142", "::ffff:192.0.2.142"},
+		{
+			map[string]AddressTruncationConfiguration{"::/0": {IPv4: 24, IPv6: 48}},
+			"::ffff:192.0.2.142", "::ffff:192.0.2.0",
+		}, {
+			map[string]AddressTruncationConfiguration{"::/0": {IPv4: 24, IPv6: 48}},
+			"2001:db8:1:2::1", "2001:db8:1::",
+		}, {
+			map[string]AddressTruncationConfiguration{"::/0": {IPv6: 48}},
+			"::ffff:192.0.2.142", "::ffff:192.0.2.142",
+		}, {
+			map[string]AddressTruncationConfiguration{"192.0.2.0/24": {IPv4: 16}},
+			"::ffff:192.0.2.142", "::ffff:192.0.0.0",
+		}, {
+			map[string]AddressTruncationConfiguration{"192.0.2.0/24": {IPv4: 16}},
+			"::ffff:198.51.100.1", "::ffff:198.51.100.1",
+		}, {
+			map[string]AddressTruncationConfiguration{
+				"::/0":          {IPv4: 24, IPv6: 48},
+				"192.0.2.0/24":  {IPv4: 0},
+				"2001:db8::/32": {IPv6: 64},
+			},
+			"::ffff:192.0.2.142", "::ffff:192.0.2.142",
+		}, {
+			map[string]AddressTruncationConfiguration{
+				"::/0":          {IPv4: 24, IPv6: 48},
+				"2001:db8::/32": {IPv6: 64},
+			},
+			"2001:db8:1:2:3::1", "2001:db8:1:2::",
+		},
+	}
+	for _, tc := range cases {
+		c := Component{
+			config: Configuration{
+				AddressTruncation: *helpers.MustNewSubnetMap(tc.Truncation),
+			},
+		}
+		got := c.truncateAddress(netip.MustParseAddr(tc.Input))
+		if diff := helpers.Diff(got, netip.MustParseAddr(tc.Expected)); diff != "" {
+			t.Errorf("truncateAddress(%s) (-got, +want):\n%s", tc.Input, diff)
+		}
+	}
+}
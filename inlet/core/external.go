@@ -0,0 +1,410 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"gopkg.in/tomb.v2"
+
+	"akvorado/common/helpers/cache"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+)
+
+// externalGRPCMethod is the gRPC method called to look up addresses. Both the
+// request and the answer are google.protobuf.Struct messages with the same
+// content as the JSON objects used over HTTP.
+const externalGRPCMethod = "/akvorado.enrichment.v1.Enrichment/Lookup"
+
+// externalEnricher queries an external HTTP or gRPC service to get attributes
+// attached to source and destination addresses. Answers are cached. Lookups
+// never block: on a cache miss, the address is queued to be fetched in the
+// background and the flow is left without the attributes.
+type externalEnricher struct {
+	r        *reporter.Reporter
+	config   ExternalEnrichmentConfiguration
+	client   *http.Client
+	grpcConn *grpc.ClientConn
+
+	fields    []externalField
+	lookupSrc bool
+	lookupDst bool
+
+	cache       *cache.Cache[netip.Addr, externalAnswer]
+	queue       chan netip.Addr
+	batches     chan []netip.Addr
+	pendingLock sync.Mutex
+	pending     map[netip.Addr]struct{}
+	errLogger   reporter.Logger
+
+	metrics struct {
+		cacheHits      reporter.Counter
+		cacheMisses    reporter.Counter
+		cacheSize      reporter.GaugeFunc
+		droppedQueries reporter.Counter
+		requests       reporter.Counter
+		errors         *reporter.CounterVec
+	}
+}
+
+// externalField is a field from the external service attached to a column.
+type externalField struct {
+	attribute string
+	src       bool
+	column    *schema.Column
+}
+
+// externalAnswer is the answer received from the external service for one
+// address.
+type externalAnswer struct {
+	Fetched    time.Time
+	Attributes map[string]string
+}
+
+// newExternalEnricher creates a new external enricher from its configuration.
+func newExternalEnricher(r *reporter.Reporter, config ExternalEnrichmentConfiguration, sch *schema.Component) (*externalEnricher, error) {
+	if len(config.Fields) == 0 {
+		return nil, errors.New("external enrichment requires at least one field")
+	}
+	e := externalEnricher{
+		r:         r,
+		config:    config,
+		client:    &http.Client{},
+		cache:     cache.NewBounded[netip.Addr, externalAnswer](int(config.CacheSize)),
+		queue:     make(chan netip.Addr, 100*config.MaxBatchSize),
+		batches:   make(chan []netip.Addr),
+		pending:   map[netip.Addr]struct{}{},
+		errLogger: r.Sample(reporter.BurstSampler(10*time.Second, 3)),
+	}
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse external enrichment URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+	case "grpc", "grpcs":
+		securityOption := grpc.WithTransportCredentials(insecure.NewCredentials())
+		if u.Scheme == "grpcs" {
+			securityOption = grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+				MinVersion: tls.VersionTLS12,
+			}))
+		}
+		conn, err := grpc.NewClient(u.Host, securityOption)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create gRPC client for %s: %w", u.Host, err)
+		}
+		e.grpcConn = conn
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q for external enrichment", u.Scheme)
+	}
+	for _, field := range config.Fields {
+		column, ok := sch.LookupColumnByName(field.Column)
+		if !ok || column.Disabled {
+			e.close()
+			return nil, fmt.Errorf("unknown column %q for external attribute %q",
+				field.Column, field.Attribute)
+		}
+		if column.ParserType != "string" && column.ParserType != "uint" {
+			e.close()
+			return nil, fmt.Errorf("column %q has type %q, not %q or %q",
+				field.Column, column.ParserType, "string", "uint")
+		}
+		src := field.Address == ExternalEnrichmentAddressSrc
+		e.fields = append(e.fields, externalField{
+			attribute: field.Attribute,
+			src:       src,
+			column:    column,
+		})
+		e.lookupSrc = e.lookupSrc || src
+		e.lookupDst = e.lookupDst || !src
+	}
+
+	e.metrics.cacheHits = r.Counter(
+		reporter.CounterOpts{
+			Name: "external_cache_hits_total",
+			Help: "Number of hits in the external enrichment cache.",
+		})
+	e.metrics.cacheMisses = r.Counter(
+		reporter.CounterOpts{
+			Name: "external_cache_misses_total",
+			Help: "Number of misses in the external enrichment cache.",
+		})
+	e.metrics.cacheSize = r.GaugeFunc(
+		reporter.GaugeOpts{
+			Name: "external_cache_size_items",
+			Help: "Number of items in the external enrichment cache.",
+		},
+		func() float64 {
+			return float64(e.cache.Size())
+		})
+	e.metrics.droppedQueries = r.Counter(
+		reporter.CounterOpts{
+			Name: "external_dropped_queries_total",
+			Help: "Number of addresses not queried because the queue was full.",
+		})
+	e.metrics.requests = r.Counter(
+		reporter.CounterOpts{
+			Name: "external_requests_total",
+			Help: "Number of requests sent to the external service.",
+		})
+	e.metrics.errors = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "external_errors_total",
+			Help: "Number of failed requests to the external service.",
+		},
+		[]string{"error"})
+	return &e, nil
+}
+
+// start starts the goroutines batching addresses and querying the external
+// service.
+func (e *externalEnricher) start(t *tomb.Tomb) {
+	// Dispatcher
+	t.Go(func() error {
+		for {
+			select {
+			case <-t.Dying():
+				return nil
+			case addr := <-e.queue:
+				batch := []netip.Addr{addr}
+			batching:
+				for len(batch) < e.config.MaxBatchSize {
+					select {
+					case addr := <-e.queue:
+						batch = append(batch, addr)
+					default:
+						break batching
+					}
+				}
+				select {
+				case <-t.Dying():
+					return nil
+				case e.batches <- batch:
+				}
+			}
+		}
+	})
+
+	// Workers
+	for range e.config.Concurrency {
+		t.Go(func() error {
+			for {
+				select {
+				case <-t.Dying():
+					return nil
+				case batch := <-e.batches:
+					e.query(t.Context(nil), batch)
+				}
+			}
+		})
+	}
+
+	// Cache expiration
+	t.Go(func() error {
+		ticker := time.NewTicker(e.config.CacheTTL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.Dying():
+				e.close()
+				return nil
+			case <-ticker.C:
+				e.cache.DeleteLastAccessedBefore(time.Now().Add(-e.config.CacheTTL))
+			}
+		}
+	})
+}
+
+// close releases the connection to the gRPC service, if any.
+func (e *externalEnricher) close() {
+	if e.grpcConn != nil {
+		e.grpcConn.Close()
+	}
+}
+
+// lookup returns the attributes for the provided address. If they are not in
+// the cache, the address is queued to be fetched and an empty answer is
+// returned.
+func (e *externalEnricher) lookup(t time.Time, addr netip.Addr) map[string]string {
+	if !addr.IsValid() || addr.IsUnspecified() {
+		return nil
+	}
+	answer, ok := e.cache.Get(t, addr)
+	if ok && t.Sub(answer.Fetched) < e.config.CacheTTL {
+		e.metrics.cacheHits.Inc()
+		return answer.Attributes
+	}
+	e.metrics.cacheMisses.Inc()
+
+	e.pendingLock.Lock()
+	defer e.pendingLock.Unlock()
+	if _, ok := e.pending[addr]; ok {
+		return nil
+	}
+	select {
+	case e.queue <- addr:
+		e.pending[addr] = struct{}{}
+	default:
+		e.metrics.droppedQueries.Inc()
+	}
+	return nil
+}
+
+// enrich adds the attributes from the external service to the flow.
+func (e *externalEnricher) enrich(t time.Time, flow *schema.FlowMessage) {
+	var srcAttributes, dstAttributes map[string]string
+	if e.lookupSrc {
+		srcAttributes = e.lookup(t, flow.SrcAddr)
+	}
+	if e.lookupDst {
+		dstAttributes = e.lookup(t, flow.DstAddr)
+	}
+	for _, field := range e.fields {
+		attributes := dstAttributes
+		if field.src {
+			attributes = srcAttributes
+		}
+		value, ok := attributes[field.attribute]
+		if !ok || value == "" {
+			continue
+		}
+		switch field.column.ParserType {
+		case "uint":
+			if v, err := strconv.ParseUint(value, 10, 64); err == nil {
+				field.column.ProtobufAppendVarint(flow, v)
+			}
+		default:
+			field.column.ProtobufAppendBytes(flow, []byte(value))
+		}
+	}
+}
+
+// query queries the external service for the provided addresses and puts the
+// answers in the cache. Addresses absent from the answer are cached with no
+// attributes.
+func (e *externalEnricher) query(ctx context.Context, addrs []netip.Addr) {
+	defer func() {
+		e.pendingLock.Lock()
+		for _, addr := range addrs {
+			delete(e.pending, addr)
+		}
+		e.pendingLock.Unlock()
+	}()
+
+	e.metrics.requests.Inc()
+	results, err := e.fetch(ctx, addrs)
+	if err != nil {
+		label := "request"
+		if errors.Is(err, context.DeadlineExceeded) || status.Code(err) == codes.DeadlineExceeded {
+			label = "timeout"
+		}
+		e.metrics.errors.WithLabelValues(label).Inc()
+		e.errLogger.Err(err).Int("addresses", len(addrs)).Msg("cannot query external service")
+		return
+	}
+	now := time.Now()
+	for _, addr := range addrs {
+		e.cache.Put(now, addr, externalAnswer{
+			Fetched:    now,
+			Attributes: results[addr.Unmap().String()],
+		})
+	}
+}
+
+// fetch sends a request to the external service. The request is a JSON object
+// with an "addresses" key containing the list of addresses to look up. The
+// answer is a JSON object mapping each address to its attributes.
+func (e *externalEnricher) fetch(ctx context.Context, addrs []netip.Addr) (map[string]map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.config.Timeout)
+	defer cancel()
+
+	addresses := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		addresses = append(addresses, addr.Unmap().String())
+	}
+	if e.grpcConn != nil {
+		return e.fetchGRPC(ctx, addresses)
+	}
+	return e.fetchHTTP(ctx, addresses)
+}
+
+// fetchHTTP sends a request to the external service over HTTP.
+func (e *externalEnricher) fetchHTTP(ctx context.Context, addresses []string) (map[string]map[string]string, error) {
+	request := struct {
+		Addresses []string `json:"addresses"`
+	}{Addresses: addresses}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("cannot build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot query %s: %w", e.config.URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, e.config.URL)
+	}
+	var results map[string]map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return nil, fmt.Errorf("cannot decode answer from %s: %w", e.config.URL, err)
+	}
+	return results, nil
+}
+
+// fetchGRPC sends a request to the external service over gRPC.
+func (e *externalEnricher) fetchGRPC(ctx context.Context, addresses []string) (map[string]map[string]string, error) {
+	values := make([]*structpb.Value, 0, len(addresses))
+	for _, address := range addresses {
+		values = append(values, structpb.NewStringValue(address))
+	}
+	request := &structpb.Struct{Fields: map[string]*structpb.Value{
+		"addresses": structpb.NewListValue(&structpb.ListValue{Values: values}),
+	}}
+	var answer structpb.Struct
+	if err := e.grpcConn.Invoke(ctx, externalGRPCMethod, request, &answer); err != nil {
+		return nil, fmt.Errorf("cannot query %s: %w", e.config.URL, err)
+	}
+	results := make(map[string]map[string]string, len(answer.GetFields()))
+	for address, value := range answer.GetFields() {
+		attributes := value.GetStructValue()
+		if attributes == nil {
+			return nil, fmt.Errorf("cannot decode answer from %s: attributes for %s are not an object",
+				e.config.URL, address)
+		}
+		results[address] = make(map[string]string, len(attributes.GetFields()))
+		for name, value := range attributes.GetFields() {
+			v, ok := value.GetKind().(*structpb.Value_StringValue)
+			if !ok {
+				return nil, fmt.Errorf("cannot decode answer from %s: attribute %s for %s is not a string",
+					e.config.URL, name, address)
+			}
+			results[address][name] = v.StringValue
+		}
+	}
+	return results, nil
+}
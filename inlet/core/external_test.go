@@ -0,0 +1,306 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+	"gopkg.in/tomb.v2"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+)
+
+func TestExternalEnricher(t *testing.T) {
+	var requestsLock sync.Mutex
+	requests := [][]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Addresses []string `json:"addresses"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("Decode() error:\n%+v", err)
+		}
+		requestsLock.Lock()
+		requests = append(requests, request.Addresses)
+		requestsLock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"192.0.2.1": {"customer": "alfred", "id": "1234"}}`))
+	}))
+	defer server.Close()
+
+	sch, err := schema.New(schema.Configuration{
+		CustomColumns: []schema.CustomColumn{
+			{Name: "SourceCustomer", Type: "string"},
+			{Name: "SourceCustomerID", Type: "uint"},
+			{Name: "DestinationCustomer", Type: "string"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("schema.New() error:\n%+v", err)
+	}
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration().ExternalEnrichment
+	config.URL = server.URL
+	config.Fields = []ExternalEnrichmentField{
+		{Attribute: "customer", Address: ExternalEnrichmentAddressSrc, Column: "SourceCustomer"},
+		{Attribute: "id", Address: ExternalEnrichmentAddressSrc, Column: "SourceCustomerID"},
+		{Attribute: "customer", Address: ExternalEnrichmentAddressDst, Column: "DestinationCustomer"},
+	}
+	e, err := newExternalEnricher(r, config, sch)
+	if err != nil {
+		t.Fatalf("newExternalEnricher() error:\n%+v", err)
+	}
+	var tb tomb.Tomb
+	e.start(&tb)
+	defer func() {
+		tb.Kill(nil)
+		tb.Wait()
+	}()
+
+	newFlow := func() *schema.FlowMessage {
+		return &schema.FlowMessage{
+			SrcAddr: netip.MustParseAddr("::ffff:192.0.2.1"),
+			DstAddr: netip.MustParseAddr("::ffff:198.51.100.1"),
+		}
+	}
+
+	// First lookup is a miss
+	flow := newFlow()
+	e.enrich(time.Now(), flow)
+	if diff := helpers.Diff(flow.ProtobufDebug, map[schema.ColumnKey]interface{}(nil)); diff != "" {
+		t.Fatalf("enrich() on miss (-got, +want):\n%s", diff)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// Second lookup is a hit
+	flow = newFlow()
+	e.enrich(time.Now(), flow)
+	sourceCustomer, _ := sch.LookupColumnByName("SourceCustomer")
+	sourceCustomerID, _ := sch.LookupColumnByName("SourceCustomerID")
+	expected := map[schema.ColumnKey]interface{}{
+		sourceCustomer.Key:   []byte("alfred"),
+		sourceCustomerID.Key: 1234,
+	}
+	if diff := helpers.Diff(flow.ProtobufDebug, expected); diff != "" {
+		t.Fatalf("enrich() on hit (-got, +want):\n%s", diff)
+	}
+
+	requestsLock.Lock()
+	if diff := helpers.Diff(requests, [][]string{{"192.0.2.1", "198.51.100.1"}}); diff != "" {
+		t.Errorf("requests (-got, +want):\n%s", diff)
+	}
+	requestsLock.Unlock()
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_core_external_")
+	expectedMetrics := map[string]string{
+		`cache_hits_total`:      "2",
+		`cache_misses_total`:    "2",
+		`cache_size_items`:      "2",
+		`dropped_queries_total`: "0",
+		`requests_total`:        "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestExternalEnricherGRPC(t *testing.T) {
+	var requestsLock sync.Mutex
+	requests := [][]string{}
+	lookup := func(_ any, _ context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+		var request structpb.Struct
+		if err := dec(&request); err != nil {
+			return nil, err
+		}
+		addresses := []string{}
+		for _, value := range request.GetFields()["addresses"].GetListValue().GetValues() {
+			addresses = append(addresses, value.GetStringValue())
+		}
+		requestsLock.Lock()
+		requests = append(requests, addresses)
+		requestsLock.Unlock()
+		return structpb.NewStruct(map[string]any{
+			"192.0.2.1": map[string]any{"customer": "alfred", "id": "1234"},
+		})
+	}
+	server := grpc.NewServer()
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "akvorado.enrichment.v1.Enrichment",
+		HandlerType: (*any)(nil),
+		Methods:     []grpc.MethodDesc{{MethodName: "Lookup", Handler: lookup}},
+	}, nil)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error:\n%+v", err)
+	}
+	go server.Serve(listener)
+	defer server.Stop()
+
+	sch, err := schema.New(schema.Configuration{
+		CustomColumns: []schema.CustomColumn{
+			{Name: "SourceCustomer", Type: "string"},
+			{Name: "SourceCustomerID", Type: "uint"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("schema.New() error:\n%+v", err)
+	}
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration().ExternalEnrichment
+	config.URL = "grpc://" + listener.Addr().String()
+	config.Fields = []ExternalEnrichmentField{
+		{Attribute: "customer", Address: ExternalEnrichmentAddressSrc, Column: "SourceCustomer"},
+		{Attribute: "id", Address: ExternalEnrichmentAddressSrc, Column: "SourceCustomerID"},
+	}
+	e, err := newExternalEnricher(r, config, sch)
+	if err != nil {
+		t.Fatalf("newExternalEnricher() error:\n%+v", err)
+	}
+	var tb tomb.Tomb
+	e.start(&tb)
+	defer func() {
+		tb.Kill(nil)
+		tb.Wait()
+	}()
+
+	newFlow := func() *schema.FlowMessage {
+		return &schema.FlowMessage{
+			SrcAddr: netip.MustParseAddr("::ffff:192.0.2.1"),
+		}
+	}
+
+	// First lookup is a miss
+	flow := newFlow()
+	e.enrich(time.Now(), flow)
+	if diff := helpers.Diff(flow.ProtobufDebug, map[schema.ColumnKey]interface{}(nil)); diff != "" {
+		t.Fatalf("enrich() on miss (-got, +want):\n%s", diff)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	// Second lookup is a hit
+	flow = newFlow()
+	e.enrich(time.Now(), flow)
+	sourceCustomer, _ := sch.LookupColumnByName("SourceCustomer")
+	sourceCustomerID, _ := sch.LookupColumnByName("SourceCustomerID")
+	expected := map[schema.ColumnKey]interface{}{
+		sourceCustomer.Key:   []byte("alfred"),
+		sourceCustomerID.Key: 1234,
+	}
+	if diff := helpers.Diff(flow.ProtobufDebug, expected); diff != "" {
+		t.Fatalf("enrich() on hit (-got, +want):\n%s", diff)
+	}
+
+	requestsLock.Lock()
+	if diff := helpers.Diff(requests, [][]string{{"192.0.2.1"}}); diff != "" {
+		t.Errorf("requests (-got, +want):\n%s", diff)
+	}
+	requestsLock.Unlock()
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_core_external_", "errors_", "requests_")
+	expectedMetrics := map[string]string{
+		`requests_total`: "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestExternalEnricherTimeout(t *testing.T) {
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-block:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(block)
+
+	sch, err := schema.New(schema.Configuration{
+		CustomColumns: []schema.CustomColumn{
+			{Name: "SourceCustomer", Type: "string"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("schema.New() error:\n%+v", err)
+	}
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration().ExternalEnrichment
+	config.URL = server.URL
+	config.Timeout = 20 * time.Millisecond
+	config.Fields = []ExternalEnrichmentField{
+		{Attribute: "customer", Address: ExternalEnrichmentAddressSrc, Column: "SourceCustomer"},
+	}
+	e, err := newExternalEnricher(r, config, sch)
+	if err != nil {
+		t.Fatalf("newExternalEnricher() error:\n%+v", err)
+	}
+	var tb tomb.Tomb
+	e.start(&tb)
+	defer func() {
+		tb.Kill(nil)
+		tb.Wait()
+	}()
+
+	for range 2 {
+		flow := &schema.FlowMessage{
+			SrcAddr: netip.MustParseAddr("::ffff:192.0.2.1"),
+		}
+		start := time.Now()
+		e.enrich(start, flow)
+		if elapsed := time.Since(start); elapsed > config.Timeout {
+			t.Errorf("enrich() took %s", elapsed)
+		}
+		if diff := helpers.Diff(flow.ProtobufDebug, map[schema.ColumnKey]interface{}(nil)); diff != "" {
+			t.Fatalf("enrich() (-got, +want):\n%s", diff)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_core_external_", "cache_", "errors_", "requests_")
+	expectedMetrics := map[string]string{
+		`cache_hits_total`:              "0",
+		`cache_misses_total`:            "2",
+		`cache_size_items`:              "0",
+		`errors_total{error="timeout"}`: "2",
+		`requests_total`:                "2",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestExternalEnricherUnsupportedScheme(t *testing.T) {
+	config := DefaultConfiguration().ExternalEnrichment
+	config.URL = "ftp://127.0.0.1:1/"
+	config.Fields = []ExternalEnrichmentField{
+		{Attribute: "customer", Address: ExternalEnrichmentAddressSrc, Column: "SrcAS"},
+	}
+	if _, err := newExternalEnricher(reporter.NewMock(t), config, schema.NewMock(t)); err == nil {
+		t.Fatal("newExternalEnricher() did not error")
+	}
+}
+
+func TestExternalEnricherUnknownColumn(t *testing.T) {
+	config := DefaultConfiguration().ExternalEnrichment
+	config.URL = "http://127.0.0.1:1/"
+	config.Fields = []ExternalEnrichmentField{
+		{Attribute: "customer", Address: ExternalEnrichmentAddressSrc, Column: "SourceCustomer"},
+	}
+	if _, err := newExternalEnricher(reporter.NewMock(t), config, schema.NewMock(t)); err == nil {
+		t.Fatal("newExternalEnricher() did not error")
+	}
+}
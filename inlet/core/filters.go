@@ -0,0 +1,149 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"akvorado/common/schema"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// FilterRule defines a rule matching flows to drop or to sample.
+type FilterRule struct {
+	program *vm.Program
+}
+
+// filterEnvironment defines the environment used by filter rules
+type filterEnvironment struct {
+	Format       func(string, ...any) string
+	RegexMatch   func(string, string) (bool, error)
+	RegexReplace func(string, string, string) (string, error)
+	Lower        func(string) string
+	Upper        func(string) string
+	EqualFold    func(string, string) bool
+	Exporter     exporterInfo
+	Flow         flowInfo
+	InSubnet     func(string, string) (bool, error)
+}
+
+// exec tells if the provided flow matches the filter rule.
+func (fr *FilterRule) exec(si exporterInfo, fi flowInfo) (bool, error) {
+	env := filterEnvironment{
+		Format:       format,
+		RegexMatch:   regexMatch,
+		RegexReplace: regexReplace,
+		Lower:        strings.ToLower,
+		Upper:        strings.ToUpper,
+		EqualFold:    strings.EqualFold,
+		Exporter:     si,
+		Flow:         fi,
+		InSubnet:     inSubnet,
+	}
+	result, err := expr.Run(fr.program, env)
+	if err != nil {
+		return false, fmt.Errorf("unable to execute filter %q: %w", fr, err)
+	}
+	return result.(bool), nil
+}
+
+// UnmarshalText compiles a filter rule.
+func (fr *FilterRule) UnmarshalText(text []byte) error {
+	regexValidator := regexValidator{}
+	subnetValidator := subnetValidator{}
+	program, err := expr.Compile(string(text),
+		expr.Env(filterEnvironment{}),
+		expr.AsBool(),
+		expr.Patch(&regexValidator),
+		expr.Patch(&subnetValidator))
+	if err != nil {
+		return fmt.Errorf("cannot compile filter rule %q: %w", string(text), err)
+	}
+	if regexValidator.err != nil {
+		return regexValidator.err
+	}
+	if len(subnetValidator.invalidSubnets) > 0 {
+		return fmt.Errorf("invalid subnet %q", subnetValidator.invalidSubnets[0])
+	}
+	fr.program = program
+	return nil
+}
+
+// String turns a filter rule into a string
+func (fr FilterRule) String() string {
+	if fr.program == nil {
+		return ""
+	}
+	return fr.program.Source().String()
+}
+
+// MarshalText turns a filter rule into a string
+func (fr FilterRule) MarshalText() ([]byte, error) {
+	return []byte(fr.String()), nil
+}
+
+// flowFilter is a filter with its state.
+type flowFilter struct {
+	FilterConfiguration
+	matched atomic.Uint64
+}
+
+// newFlowFilters checks the provided filters and returns them with their
+// state.
+func newFlowFilters(configuration []FilterConfiguration) ([]*flowFilter, error) {
+	filters := make([]*flowFilter, 0, len(configuration))
+	names := map[string]bool{}
+	for _, filter := range configuration {
+		if names[filter.Name] {
+			return nil, fmt.Errorf("duplicate filter %q", filter.Name)
+		}
+		names[filter.Name] = true
+		if filter.Rule.program == nil {
+			return nil, fmt.Errorf("filter %q has no rule", filter.Name)
+		}
+		if filter.Action == FilterActionSample && filter.Factor < 2 {
+			return nil, fmt.Errorf("filter %q needs a sampling factor of at least 2", filter.Name)
+		}
+		filters = append(filters, &flowFilter{FilterConfiguration: filter})
+	}
+	return filters, nil
+}
+
+// filterFlow applies the filters to the provided flow. It returns true if the
+// flow should be dropped. Only the first matching filter is applied. When a
+// flow is sampled, its sampling rate is increased accordingly.
+func (c *Component) filterFlow(ip string, name string, flow *schema.FlowMessage) bool {
+	if len(c.filters) == 0 {
+		return false
+	}
+	si := exporterInfo{IP: ip, Name: name}
+	fi := c.flowInfo(flow)
+	for _, filter := range c.filters {
+		matched, err := filter.Rule.exec(si, fi)
+		if err != nil {
+			c.classifierErrLogger.Err(err).
+				Str("filter", filter.Name).
+				Str("exporter", name).
+				Msg("error executing filter")
+			c.metrics.filtersErrors.WithLabelValues(filter.Name).Inc()
+			continue
+		}
+		if !matched {
+			continue
+		}
+		c.metrics.filtersMatchedFlows.WithLabelValues(filter.Name).Inc()
+		count := filter.matched.Add(1)
+		if filter.Action == FilterActionSample && count%uint64(filter.Factor) == 0 {
+			flow.SamplingRate *= uint32(filter.Factor)
+			return false
+		}
+		c.metrics.filtersDroppedFlows.WithLabelValues(filter.Name).Inc()
+		return true
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mitchellh/mapstructure"
+
+	"akvorado/common/helpers"
+	"akvorado/common/helpers/cache"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+)
+
+func TestFilterRuleUnmarshal(t *testing.T) {
+	cases := []struct {
+		Rule  string
+		Error bool
+	}{
+		{`Flow.DstPort == 443`, false},
+		{`InSubnet(Flow.DstAddr, "224.0.0.0/4")`, false},
+		{`RegexMatch(Exporter.Name, "^edge")`, false},
+		{`InSubnet(Flow.DstAddr, "224.0.0.0/40")`, true},
+		{`RegexMatch(Exporter.Name, "^(edge")`, true},
+		{`Flow.DstPort`, true},
+		{`Unknown == 1`, true},
+	}
+	for _, tc := range cases {
+		var rule FilterRule
+		err := rule.UnmarshalText([]byte(tc.Rule))
+		if err != nil && !tc.Error {
+			t.Errorf("UnmarshalText(%q) error:\n%+v", tc.Rule, err)
+		} else if err == nil && tc.Error {
+			t.Errorf("UnmarshalText(%q) did not error", tc.Rule)
+		}
+	}
+}
+
+func TestNewFlowFilters(t *testing.T) {
+	cases := []struct {
+		Description   string
+		Configuration []gin.H
+		Error         bool
+	}{
+		{
+			Description: "valid",
+			Configuration: []gin.H{
+				{"name": "probes", "rule": `Flow.SrcAddr == "192.0.2.1"`},
+				{"name": "dns", "rule": `Flow.DstPort == 53`, "action": "sample", "factor": 10},
+			},
+		}, {
+			Description: "duplicate name",
+			Configuration: []gin.H{
+				{"name": "probes", "rule": `Flow.SrcAddr == "192.0.2.1"`},
+				{"name": "probes", "rule": `Flow.SrcAddr == "192.0.2.2"`},
+			},
+			Error: true,
+		}, {
+			Description: "missing rule",
+			Configuration: []gin.H{
+				{"name": "probes"},
+			},
+			Error: true,
+		}, {
+			Description: "missing factor",
+			Configuration: []gin.H{
+				{"name": "dns", "rule": `Flow.DstPort == 53`, "action": "sample"},
+			},
+			Error: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			configuration := []FilterConfiguration{}
+			decoder, err := mapstructure.NewDecoder(helpers.GetMapStructureDecoderConfig(&configuration))
+			if err != nil {
+				t.Fatalf("NewDecoder() error:\n%+v", err)
+			}
+			if err := decoder.Decode(tc.Configuration); err != nil {
+				t.Fatalf("Decode() error:\n%+v", err)
+			}
+			_, err = newFlowFilters(configuration)
+			if err != nil && !tc.Error {
+				t.Fatalf("newFlowFilters() error:\n%+v", err)
+			} else if err == nil && tc.Error {
+				t.Fatal("newFlowFilters() did not error")
+			}
+		})
+	}
+}
+
+func TestFilterFlow(t *testing.T) {
+	r := reporter.NewMock(t)
+	sch := schema.NewMock(t)
+	configuration := []FilterConfiguration{}
+	decoder, err := mapstructure.NewDecoder(helpers.GetMapStructureDecoderConfig(&configuration))
+	if err != nil {
+		t.Fatalf("NewDecoder() error:\n%+v", err)
+	}
+	if err := decoder.Decode([]gin.H{
+		{"name": "probes", "rule": `InSubnet(Flow.SrcAddr, "192.0.2.0/28")`},
+		{"name": "dns", "rule": `Flow.DstPort == 53`, "action": "sample", "factor": 4},
+	}); err != nil {
+		t.Fatalf("Decode() error:\n%+v", err)
+	}
+	filters, err := newFlowFilters(configuration)
+	if err != nil {
+		t.Fatalf("newFlowFilters() error:\n%+v", err)
+	}
+	c := Component{
+		r:                        r,
+		d:                        &Dependencies{Schema: sch},
+		filters:                  filters,
+		classifierExporterCache:  cache.NewBounded[exporterInfo, exporterClassification](10),
+		classifierInterfaceCache: cache.NewBounded[exporterAndInterfaceInfo, interfaceClassification](10),
+		classifierErrLogger:      r.Sample(reporter.BurstSampler(time.Second, 1)),
+	}
+	c.initMetrics()
+
+	newFlow := func(src string, dstPort uint64) *schema.FlowMessage {
+		flow := &schema.FlowMessage{
+			SamplingRate: 100,
+			SrcAddr:      netip.MustParseAddr(src),
+			DstAddr:      netip.MustParseAddr("::ffff:203.0.113.1"),
+		}
+		sch.ProtobufAppendVarint(flow, schema.ColumnDstPort, dstPort)
+		return flow
+	}
+
+	// Dropped by the first filter
+	if !c.filterFlow("192.0.2.142", "edge1", newFlow("::ffff:192.0.2.1", 53)) {
+		t.Error("filterFlow() == false for a probe, expected true")
+	}
+	// Not matching any filter
+	flow := newFlow("::ffff:198.51.100.1", 443)
+	if c.filterFlow("192.0.2.142", "edge1", flow) {
+		t.Error("filterFlow() == true for HTTPS, expected false")
+	}
+	if flow.SamplingRate != 100 {
+		t.Errorf("filterFlow() sampling rate = %d, expected 100", flow.SamplingRate)
+	}
+	// Sampled by the second filter
+	kept := 0
+	for range 8 {
+		flow := newFlow("::ffff:198.51.100.1", 53)
+		if !c.filterFlow("192.0.2.142", "edge1", flow) {
+			kept++
+			if flow.SamplingRate != 400 {
+				t.Errorf("filterFlow() sampling rate = %d, expected 400", flow.SamplingRate)
+			}
+		}
+	}
+	if kept != 2 {
+		t.Errorf("filterFlow() kept %d DNS flows, expected 2", kept)
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_core_", "filters_")
+	expectedMetrics := map[string]string{
+		`filters_matched_flows_total{filter="probes"}`: "1",
+		`filters_matched_flows_total{filter="dns"}`:    "8",
+		`filters_dropped_flows_total{filter="probes"}`: "1",
+		`filters_dropped_flows_total{filter="dns"}`:    "6",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
@@ -17,7 +17,27 @@ type metrics struct {
 
 	classifierExporterCacheSize  reporter.CounterFunc
 	classifierInterfaceCacheSize reporter.CounterFunc
+	classifierCacheHits          *reporter.CounterVec
+	classifierCacheMisses        *reporter.CounterVec
 	classifierErrors             *reporter.CounterVec
+
+	asnProviderHits   *reporter.CounterVec
+	asnProviderMisses *reporter.CounterVec
+
+	deduplicationDuplicates *reporter.CounterVec
+	deduplicationEvicted    reporter.Counter
+	deduplicationEntries    reporter.GaugeFunc
+	deduplicationHeld       reporter.GaugeFunc
+
+	aggregationInputFlows    reporter.Counter
+	aggregationOutputFlows   reporter.Counter
+	aggregationOverflowFlows reporter.Counter
+	aggregationEntries       reporter.GaugeFunc
+	aggregationRatio         reporter.Gauge
+
+	filtersMatchedFlows *reporter.CounterVec
+	filtersDroppedFlows *reporter.CounterVec
+	filtersErrors       *reporter.CounterVec
 }
 
 func (c *Component) initMetrics() {
@@ -70,10 +90,133 @@ func (c *Component) initMetrics() {
 			return float64(c.classifierInterfaceCache.Size())
 		},
 	)
+	c.metrics.classifierCacheHits = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "classifier_cache_hits_total",
+			Help: "Number of hits in the classifier cache",
+		},
+		[]string{"type"})
+	c.metrics.classifierCacheMisses = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "classifier_cache_misses_total",
+			Help: "Number of misses in the classifier cache",
+		},
+		[]string{"type"})
 	c.metrics.classifierErrors = c.r.CounterVec(
 		reporter.CounterOpts{
 			Name: "classifier_errors_total",
 			Help: "Number of errors when evaluating a classifer",
 		},
 		[]string{"type", "index"})
+
+	c.metrics.asnProviderHits = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "asn_provider_hits_total",
+			Help: "Number of AS numbers provided by each provider.",
+		},
+		[]string{"direction", "provider"},
+	)
+	c.metrics.asnProviderMisses = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "asn_provider_misses_total",
+			Help: "Number of AS numbers not found by any provider.",
+		},
+		[]string{"direction"},
+	)
+	c.metrics.deduplicationDuplicates = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "deduplication_duplicate_flows_total",
+			Help: "Number of flows already reported by another exporter.",
+		},
+		[]string{"exporter"},
+	)
+	c.metrics.deduplicationEvicted = c.r.Counter(
+		reporter.CounterOpts{
+			Name: "deduplication_evicted_entries_total",
+			Help: "Number of flows evicted from the deduplication set because it was full.",
+		},
+	)
+	c.metrics.deduplicationEntries = c.r.GaugeFunc(
+		reporter.GaugeOpts{
+			Name: "deduplication_entries",
+			Help: "Number of flows in the deduplication set.",
+		},
+		func() float64 {
+			if c.deduplicator == nil {
+				return 0
+			}
+			return float64(c.deduplicator.size())
+		},
+	)
+	c.metrics.deduplicationHeld = c.r.GaugeFunc(
+		reporter.GaugeOpts{
+			Name: "deduplication_held_flows",
+			Help: "Number of flows held waiting for a copy entering the network.",
+		},
+		func() float64 {
+			if c.deduplicator == nil {
+				return 0
+			}
+			return float64(c.deduplicator.heldSize())
+		},
+	)
+
+	c.metrics.aggregationInputFlows = c.r.Counter(
+		reporter.CounterOpts{
+			Name: "aggregation_input_flows_total",
+			Help: "Number of flows merged by the aggregation stage.",
+		},
+	)
+	c.metrics.aggregationOutputFlows = c.r.Counter(
+		reporter.CounterOpts{
+			Name: "aggregation_output_flows_total",
+			Help: "Number of aggregated flows sent to Kafka.",
+		},
+	)
+	c.metrics.aggregationOverflowFlows = c.r.Counter(
+		reporter.CounterOpts{
+			Name: "aggregation_overflow_flows_total",
+			Help: "Number of flows sent without aggregation because the aggregation stage was full.",
+		},
+	)
+	c.metrics.aggregationEntries = c.r.GaugeFunc(
+		reporter.GaugeOpts{
+			Name: "aggregation_entries",
+			Help: "Number of flows in the aggregation stage.",
+		},
+		func() float64 {
+			if c.aggregator == nil {
+				return 0
+			}
+			return float64(c.aggregator.size())
+		},
+	)
+	c.metrics.aggregationRatio = c.r.Gauge(
+		reporter.GaugeOpts{
+			Name: "aggregation_ratio",
+			Help: "Number of input flows per aggregated flow during the last window.",
+		},
+	)
+
+	c.metrics.filtersMatchedFlows = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "filters_matched_flows_total",
+			Help: "Number of flows matching each filter.",
+		},
+		[]string{"filter"},
+	)
+	c.metrics.filtersDroppedFlows = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "filters_dropped_flows_total",
+			Help: "Number of flows dropped by each filter.",
+		},
+		[]string{"filter"},
+	)
+	c.metrics.filtersErrors = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "filters_errors_total",
+			Help: "Number of errors while evaluating each filter.",
+		},
+		[]string{"filter"},
+	)
 }
@@ -0,0 +1,74 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"bytes"
+
+	"akvorado/common/helpers"
+	"akvorado/common/helpers/yaml"
+)
+
+// reloadableConfiguration is the part of the configuration which can be
+// updated without restarting the component.
+type reloadableConfiguration struct {
+	ExporterClassifiers  []ExporterClassifierRule
+	InterfaceClassifiers []InterfaceClassifierRule
+	FlowClassifiers      []FlowClassifierRule
+	DefaultSamplingRate  helpers.SubnetMap[uint]
+	OverrideSamplingRate helpers.SubnetMap[uint]
+}
+
+func newReloadableConfiguration(configuration Configuration) *reloadableConfiguration {
+	return &reloadableConfiguration{
+		ExporterClassifiers:  configuration.ExporterClassifiers,
+		InterfaceClassifiers: configuration.InterfaceClassifiers,
+		FlowClassifiers:      configuration.FlowClassifiers,
+		DefaultSamplingRate:  configuration.DefaultSamplingRate,
+		OverrideSamplingRate: configuration.OverrideSamplingRate,
+	}
+}
+
+// Reload applies the classifiers and the sampling rates from the provided
+// configuration. Other settings are ignored. Classifier caches are flushed
+// as their content depends on the classifiers.
+func (c *Component) Reload(configuration Configuration) {
+	c.reloadable.Store(newReloadableConfiguration(configuration))
+	c.classifierExporterCache.DeleteFunc(func(_ exporterInfo) bool { return true })
+	c.classifierInterfaceCache.DeleteFunc(func(_ exporterAndInterfaceInfo) bool { return true })
+}
+
+// CompareConfigurations compares two configurations. It returns the settings
+// which have changed and can be applied with Reload and whether other
+// settings, needing a restart, have changed.
+func CompareConfigurations(previous, next Configuration) (reloadable []string, restart bool) {
+	changed := func(a, b any) bool {
+		outA, errA := yaml.Marshal(a)
+		outB, errB := yaml.Marshal(b)
+		return errA != nil || errB != nil || !bytes.Equal(outA, outB)
+	}
+	for _, setting := range []struct {
+		Name     string
+		Previous any
+		Next     any
+	}{
+		{"exporter-classifiers", previous.ExporterClassifiers, next.ExporterClassifiers},
+		{"interface-classifiers", previous.InterfaceClassifiers, next.InterfaceClassifiers},
+		{"flow-classifiers", previous.FlowClassifiers, next.FlowClassifiers},
+		{"default-sampling-rate", previous.DefaultSamplingRate, next.DefaultSamplingRate},
+		{"override-sampling-rate", previous.OverrideSamplingRate, next.OverrideSamplingRate},
+	} {
+		if changed(setting.Previous, setting.Next) {
+			reloadable = append(reloadable, setting.Name)
+		}
+	}
+
+	// Compare everything else
+	previous.ExporterClassifiers, next.ExporterClassifiers = nil, nil
+	previous.InterfaceClassifiers, next.InterfaceClassifiers = nil, nil
+	previous.FlowClassifiers, next.FlowClassifiers = nil, nil
+	previous.DefaultSamplingRate, next.DefaultSamplingRate = helpers.SubnetMap[uint]{}, helpers.SubnetMap[uint]{}
+	previous.OverrideSamplingRate, next.OverrideSamplingRate = helpers.SubnetMap[uint]{}, helpers.SubnetMap[uint]{}
+	return reloadable, changed(previous, next)
+}
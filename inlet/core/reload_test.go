@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+)
+
+func TestCompareConfigurations(t *testing.T) {
+	rule := func(program string) ExporterClassifierRule {
+		var rule ExporterClassifierRule
+		if err := rule.UnmarshalText([]byte(program)); err != nil {
+			t.Fatalf("UnmarshalText(%q) error:\n%+v", program, err)
+		}
+		return rule
+	}
+	samplingRate := func(value uint) helpers.SubnetMap[uint] {
+		sm, err := helpers.NewSubnetMap(map[string]uint{"192.0.2.0/24": value})
+		if err != nil {
+			t.Fatalf("NewSubnetMap() error:\n%+v", err)
+		}
+		return *sm
+	}
+	cases := []struct {
+		Description        string
+		Update             func(*Configuration)
+		ExpectedReloadable []string
+		ExpectedRestart    bool
+	}{
+		{
+			Description: "no change",
+			Update:      func(*Configuration) {},
+		}, {
+			Description: "same classifiers",
+			Update: func(c *Configuration) {
+				c.ExporterClassifiers = []ExporterClassifierRule{rule(`ClassifySite("paris")`)}
+			},
+		}, {
+			Description: "classifiers and sampling rate",
+			Update: func(c *Configuration) {
+				c.ExporterClassifiers = []ExporterClassifierRule{rule(`ClassifySite("lyon")`)}
+				c.OverrideSamplingRate = samplingRate(1000)
+			},
+			ExpectedReloadable: []string{"exporter-classifiers", "override-sampling-rate"},
+		}, {
+			Description: "workers",
+			Update: func(c *Configuration) {
+				c.Workers = 4
+			},
+			ExpectedRestart: true,
+		}, {
+			Description: "workers and sampling rate",
+			Update: func(c *Configuration) {
+				c.Workers = 4
+				c.DefaultSamplingRate = samplingRate(10)
+			},
+			ExpectedReloadable: []string{"default-sampling-rate"},
+			ExpectedRestart:    true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			previous := DefaultConfiguration()
+			previous.ExporterClassifiers = []ExporterClassifierRule{rule(`ClassifySite("paris")`)}
+			previous.OverrideSamplingRate = samplingRate(100)
+			next := DefaultConfiguration()
+			next.ExporterClassifiers = []ExporterClassifierRule{rule(`ClassifySite("paris")`)}
+			next.OverrideSamplingRate = samplingRate(100)
+			tc.Update(&next)
+			reloadable, restart := CompareConfigurations(previous, next)
+			if diff := helpers.Diff(reloadable, tc.ExpectedReloadable); diff != "" {
+				t.Errorf("CompareConfigurations() reloadable (-got, +want):\n%s", diff)
+			}
+			if restart != tc.ExpectedRestart {
+				t.Errorf("CompareConfigurations() restart = %v, expected %v", restart, tc.ExpectedRestart)
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration()
+	c, err := New(r, configuration, Dependencies{
+		Daemon: daemon.NewMock(t),
+		Schema: schema.NewMock(t),
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	now := time.Now()
+	c.classifierExporterCache.Put(now, exporterInfo{IP: "192.0.2.1"}, exporterClassification{Site: "paris"})
+
+	var rule ExporterClassifierRule
+	if err := rule.UnmarshalText([]byte(`ClassifySite("lyon")`)); err != nil {
+		t.Fatalf("UnmarshalText() error:\n%+v", err)
+	}
+	sm, err := helpers.NewSubnetMap(map[string]uint{"192.0.2.0/24": 1000})
+	if err != nil {
+		t.Fatalf("NewSubnetMap() error:\n%+v", err)
+	}
+	configuration.ExporterClassifiers = []ExporterClassifierRule{rule}
+	configuration.OverrideSamplingRate = *sm
+	c.Reload(configuration)
+
+	if size := c.classifierExporterCache.Size(); size != 0 {
+		t.Errorf("Reload() did not flush exporter cache (size: %d)", size)
+	}
+	reloadable := c.reloadable.Load()
+	if len(reloadable.ExporterClassifiers) != 1 {
+		t.Errorf("Reload() did not update exporter classifiers")
+	}
+	if samplingRate, _ := reloadable.OverrideSamplingRate.Lookup(
+		netip.MustParseAddr("::ffff:192.0.2.10")); samplingRate != 1000 {
+		t.Errorf("Reload() did not update override sampling rate (got %d)", samplingRate)
+	}
+}
@@ -16,9 +16,11 @@ import (
 	"akvorado/common/httpserver"
 	"akvorado/common/reporter"
 	"akvorado/common/schema"
+	"akvorado/inlet/clickhouse"
 	"akvorado/inlet/flow"
 	"akvorado/inlet/kafka"
 	"akvorado/inlet/metadata"
+	"akvorado/inlet/nats"
 	"akvorado/inlet/routing"
 )
 
@@ -39,17 +41,30 @@ type Component struct {
 	classifierExporterCache  *cache.Cache[exporterInfo, exporterClassification]
 	classifierInterfaceCache *cache.Cache[exporterAndInterfaceInfo, interfaceClassification]
 	classifierErrLogger      reporter.Logger
+
+	reloadable atomic.Pointer[reloadableConfiguration]
+
+	deduplicator    *deduplicator
+	external        *externalEnricher
+	threatLists     *threatLists
+	aggregator      *aggregator
+	filters         []*flowFilter
+	topicRules      []topicRule
+	kafkaTopic      string
+	partitionFields []string
 }
 
 // Dependencies define the dependencies of the HTTP component.
 type Dependencies struct {
-	Daemon   daemon.Component
-	Flow     *flow.Component
-	Metadata *metadata.Component
-	Routing  *routing.Component
-	Kafka    *kafka.Component
-	HTTP     *httpserver.Component
-	Schema   *schema.Component
+	Daemon     daemon.Component
+	Flow       *flow.Component
+	Metadata   *metadata.Component
+	Routing    *routing.Component
+	Kafka      *kafka.Component
+	NATS       *nats.Component
+	ClickHouse *clickhouse.Component
+	HTTP       *httpserver.Component
+	Schema     *schema.Component
 }
 
 // New creates a new core component.
@@ -64,9 +79,61 @@ func New(r *reporter.Reporter, configuration Configuration, dependencies Depende
 		httpFlowChannel:    make(chan *schema.FlowMessage, 10),
 		httpFlowFlushDelay: time.Second,
 
-		classifierExporterCache:  cache.New[exporterInfo, exporterClassification](),
-		classifierInterfaceCache: cache.New[exporterAndInterfaceInfo, interfaceClassification](),
-		classifierErrLogger:      r.Sample(reporter.BurstSampler(10*time.Second, 3)),
+		classifierExporterCache: cache.NewBounded[exporterInfo, exporterClassification](
+			int(configuration.ClassifierCacheMaxEntries)),
+		classifierInterfaceCache: cache.NewBounded[exporterAndInterfaceInfo, interfaceClassification](
+			int(configuration.ClassifierCacheMaxEntries)),
+		classifierErrLogger: r.Sample(reporter.BurstSampler(10*time.Second, 3)),
+	}
+	c.reloadable.Store(newReloadableConfiguration(configuration))
+	if len(configuration.SrcASNProviders) == 0 {
+		c.config.SrcASNProviders = configuration.ASNProviders
+	}
+	if len(configuration.DstASNProviders) == 0 {
+		c.config.DstASNProviders = configuration.ASNProviders
+	}
+	if configuration.Deduplication.Window > 0 {
+		if configuration.Deduplication.Action == DeduplicationActionMark {
+			if column, _ := c.d.Schema.LookupColumnByKey(schema.ColumnDuplicate); column.Disabled {
+				return nil, fmt.Errorf("deduplication action %q requires the %s column to be enabled",
+					configuration.Deduplication.Action, schema.ColumnDuplicate)
+			}
+		}
+		c.deduplicator = newDeduplicator(configuration.Deduplication.Window,
+			configuration.Deduplication.MaxEntries, deduplicatorShards)
+	}
+	if configuration.ExternalEnrichment.URL != "" {
+		external, err := newExternalEnricher(r, configuration.ExternalEnrichment, c.d.Schema)
+		if err != nil {
+			return nil, err
+		}
+		c.external = external
+	}
+	if len(configuration.ThreatLists.Lists) > 0 {
+		threatLists, err := newThreatLists(r, configuration.ThreatLists, c.d.Schema)
+		if err != nil {
+			return nil, err
+		}
+		c.threatLists = threatLists
+	}
+	filters, err := newFlowFilters(configuration.Filters)
+	if err != nil {
+		return nil, err
+	}
+	c.filters = filters
+	if err := c.compileTopicRules(); err != nil {
+		return nil, err
+	}
+	if configuration.Aggregation.Window > 0 {
+		for _, key := range configuration.Aggregation.Drop {
+			switch key {
+			case schema.ColumnTimeReceived, schema.ColumnSamplingRate, schema.ColumnBytes,
+				schema.ColumnPackets, schema.ColumnExporterAddress:
+				return nil, fmt.Errorf("column %s cannot be dropped by aggregation", key)
+			}
+		}
+		c.aggregator = newAggregator(c.d.Schema, configuration.Aggregation.MaxEntries,
+			configuration.Aggregation.Drop)
 	}
 	c.d.Daemon.Track(&c.t, "inlet/core")
 	c.initMetrics()
@@ -97,6 +164,51 @@ func (c *Component) Start() error {
 		}
 	})
 
+	// External enrichment
+	if c.external != nil {
+		c.external.start(&c.t)
+	}
+
+	// Threat lists
+	if c.threatLists != nil {
+		c.threatLists.start(&c.t)
+	}
+
+	// Deduplication: flows held waiting for a copy entering the network are
+	// forwarded at the end of the window.
+	if c.deduplicator != nil && c.config.Deduplication.Action == DeduplicationActionKeepIngress {
+		c.t.Go(func() error {
+			ticker := time.NewTicker(min(c.config.Deduplication.Window, time.Second))
+			defer ticker.Stop()
+			for {
+				select {
+				case <-c.t.Dying():
+					c.releaseHeldFlows(time.Time{})
+					return nil
+				case now := <-ticker.C:
+					c.releaseHeldFlows(now)
+				}
+			}
+		})
+	}
+
+	// Aggregation
+	if c.aggregator != nil {
+		c.t.Go(func() error {
+			ticker := time.NewTicker(c.config.Aggregation.Window)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-c.t.Dying():
+					c.flushAggregator()
+					return nil
+				case <-ticker.C:
+					c.flushAggregator()
+				}
+			}
+		})
+	}
+
 	c.r.RegisterHealthcheck("core", c.channelHealthcheck())
 	c.d.HTTP.GinRouter.GET("/api/v0/inlet/flows", c.FlowsHTTPHandler)
 	return nil
@@ -130,26 +242,65 @@ func (c *Component) runWorker(workerID int) error {
 				continue
 			}
 
-			// Serialize flow to Protobuf
-			buf := c.d.Schema.ProtobufMarshal(flow)
+			// Deduplication
+			if skip := c.deduplicateFlow(exporter, flow); skip {
+				continue
+			}
 
-			// Forward to Kafka. This could block and buf is now owned by the
-			// Kafka subsystem!
-			c.metrics.flowsForwarded.WithLabelValues(exporter).Inc()
-			c.d.Kafka.Send(exporter, buf)
+			c.forwardFlow(exporter, flow)
+		}
+	}
+}
 
-			// If we have HTTP clients, send to them too
-			if atomic.LoadUint32(&c.httpFlowClients) > 0 {
-				select {
-				case c.httpFlowChannel <- flow: // OK
-				default: // Overflow, best effort and ignore
-				}
-			}
+// forwardFlow sends an enriched flow to Kafka (or its replacement) and to the
+// HTTP clients.
+func (c *Component) forwardFlow(exporter string, flow *schema.FlowMessage) {
+	// Select the Kafka topic and the partition key, then serialize flow to
+	// Protobuf
+	fields := c.flowFields(flow)
+	topic := c.selectTopic(fields)
+	key := c.partitionKey(fields)
+	buf := c.d.Schema.ProtobufMarshal(flow)
+
+	// Aggregation. When the flow cannot be aggregated, it is sent as is.
+	if c.aggregator != nil {
+		if c.aggregator.add(exporter, topic, key, buf) {
+			c.metrics.aggregationInputFlows.Inc()
+			buf = nil
+		} else {
+			c.metrics.aggregationOverflowFlows.Inc()
+		}
+	}
 
+	// Forward to Kafka. This could block and buf is now owned by the Kafka
+	// subsystem!
+	if buf != nil {
+		c.metrics.flowsForwarded.WithLabelValues(exporter).Inc()
+		c.send(exporter, topic, key, buf)
+	}
+
+	// If we have HTTP clients, send to them too
+	if atomic.LoadUint32(&c.httpFlowClients) > 0 {
+		select {
+		case c.httpFlowChannel <- flow: // OK
+		default: // Overflow, best effort and ignore
 		}
 	}
 }
 
+// send forwards a serialized flow to Kafka, to NATS JetStream or, when Kafka
+// is disabled, to ClickHouse. Topic and key are only used by Kafka.
+func (c *Component) send(exporter, topic string, key []byte, buf []byte) {
+	switch {
+	case c.d.Kafka != nil:
+		c.d.Kafka.Send(exporter, topic, key, buf)
+	case c.d.NATS != nil:
+		c.d.NATS.Send(exporter, buf)
+	default:
+		c.d.ClickHouse.Send(exporter, buf)
+	}
+}
+
 // Stop stops the core component.
 func (c *Component) Stop() error {
 	defer func() {
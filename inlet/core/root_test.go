@@ -17,15 +17,20 @@ import (
 
 	"github.com/IBM/sarama"
 	"github.com/gin-gonic/gin"
+	"go.uber.org/mock/gomock"
 
+	"akvorado/common/clickhousedb"
+	"akvorado/common/clickhousedb/mocks"
 	"akvorado/common/daemon"
 	"akvorado/common/helpers"
 	"akvorado/common/httpserver"
 	"akvorado/common/reporter"
 	"akvorado/common/schema"
+	"akvorado/inlet/clickhouse"
 	"akvorado/inlet/flow"
 	"akvorado/inlet/kafka"
 	"akvorado/inlet/metadata"
+	"akvorado/inlet/nats"
 	"akvorado/inlet/routing"
 )
 
@@ -96,8 +101,6 @@ func TestCore(t *testing.T) {
 		expected.ProtobufDebug[schema.ColumnOutIfName] = fmt.Sprintf("Gi0/0/%d", out)
 		expected.ProtobufDebug[schema.ColumnInIfDescription] = fmt.Sprintf("Interface %d", in)
 		expected.ProtobufDebug[schema.ColumnOutIfDescription] = fmt.Sprintf("Interface %d", out)
-		expected.ProtobufDebug[schema.ColumnInIfSpeed] = 1000
-		expected.ProtobufDebug[schema.ColumnOutIfSpeed] = 1000
 		expected.ProtobufDebug[schema.ColumnExporterName] = strings.ReplaceAll(exporter, ".", "_")
 		return expected
 	}
@@ -113,8 +116,16 @@ func TestCore(t *testing.T) {
 		time.Sleep(20 * time.Millisecond)
 		gotMetrics := r.GetMetrics("akvorado_inlet_core_", "-flows_processing_")
 		expectedMetrics := map[string]string{
+			`aggregation_entries`:                                                "0",
+			`aggregation_input_flows_total`:                                      "0",
+			`aggregation_output_flows_total`:                                     "0",
+			`aggregation_overflow_flows_total`:                                   "0",
+			`aggregation_ratio`:                                                  "0",
 			`classifier_exporter_cache_size_items`:                               "0",
 			`classifier_interface_cache_size_items`:                              "0",
+			`deduplication_entries`:                                              "0",
+			`deduplication_evicted_entries_total`:                                "0",
+			`deduplication_held_flows`:                                           "0",
 			`flows_errors_total{error="SNMP cache miss",exporter="192.0.2.142"}`: "1",
 			`flows_errors_total{error="SNMP cache miss",exporter="192.0.2.143"}`: "3",
 			`received_flows_total{exporter="192.0.2.142"}`:                       "1",
@@ -262,8 +273,10 @@ func TestCore(t *testing.T) {
 				"DstNetMask": 0,
 				"SrcVlan":    0,
 				"DstVlan":    0,
-				"GotASPath":  false,
 				"DstAS":      0,
+
+				"DstASPath":      nil,
+				"DstCommunities": nil,
 			}
 			if diff := helpers.Diff(got, expected); diff != "" {
 				t.Fatalf("GET /api/v0/inlet/flows (-got, +want):\n%s", diff)
@@ -360,3 +373,137 @@ func TestCore(t *testing.T) {
 		}
 	})
 }
+
+func TestCoreClickHouse(t *testing.T) {
+	r := reporter.NewMock(t)
+
+	// Prepare all components. Kafka is not used.
+	daemonComponent := daemon.NewMock(t)
+	metadataComponent := metadata.NewMock(t, r, metadata.DefaultConfiguration(),
+		metadata.Dependencies{Daemon: daemonComponent})
+	flowComponent := flow.NewMock(t, r, flow.DefaultConfiguration())
+	httpComponent := httpserver.NewMock(t, r)
+	routingComponent := routing.NewMock(t, r)
+	sch := schema.NewMock(t)
+	chComponent, mockConn := clickhousedb.NewMock(t, r)
+	clickhouseConfiguration := clickhouse.DefaultConfiguration()
+	clickhouseConfiguration.BatchSize = 1
+	clickhouseComponent, err := clickhouse.New(r, clickhouseConfiguration, clickhouse.Dependencies{
+		Daemon:     daemonComponent,
+		ClickHouse: chComponent,
+		Schema:     sch,
+	})
+	if err != nil {
+		t.Fatalf("clickhouse.New() error:\n%+v", err)
+	}
+	helpers.StartStop(t, clickhouseComponent)
+
+	c, err := New(r, DefaultConfiguration(), Dependencies{
+		Daemon:     daemonComponent,
+		Flow:       flowComponent,
+		Metadata:   metadataComponent,
+		ClickHouse: clickhouseComponent,
+		HTTP:       httpComponent,
+		Routing:    routingComponent,
+		Schema:     sch,
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	helpers.StartStop(t, c)
+
+	ctrl := gomock.NewController(t)
+	batch := mocks.NewMockBatch(ctrl)
+	inserted := make(chan bool, 10)
+	batch.EXPECT().Append(gomock.Any()).Return(nil).AnyTimes()
+	batch.EXPECT().Send().DoAndReturn(func() error {
+		inserted <- true
+		return nil
+	}).AnyTimes()
+	mockConn.EXPECT().
+		PrepareBatch(gomock.Any(), fmt.Sprintf("INSERT INTO flows_%s_raw", sch.ProtobufMessageHash())).
+		Return(batch, nil).
+		AnyTimes()
+
+	// The first flows are dropped because of a cache miss in the metadata
+	// component.
+	timeout := time.After(time.Second)
+	for {
+		flowComponent.Inject(&schema.FlowMessage{
+			TimeReceived:    200,
+			SamplingRate:    1000,
+			ExporterAddress: netip.MustParseAddr("192.0.2.142"),
+			InIf:            434,
+			OutIf:           677,
+		})
+		select {
+		case <-inserted:
+			return
+		case <-timeout:
+			t.Fatal("flow not inserted into ClickHouse")
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+func TestCoreNATS(t *testing.T) {
+	r := reporter.NewMock(t)
+
+	// Prepare all components. Kafka is not used.
+	daemonComponent := daemon.NewMock(t)
+	metadataComponent := metadata.NewMock(t, r, metadata.DefaultConfiguration(),
+		metadata.Dependencies{Daemon: daemonComponent})
+	flowComponent := flow.NewMock(t, r, flow.DefaultConfiguration())
+	httpComponent := httpserver.NewMock(t, r)
+	routingComponent := routing.NewMock(t, r)
+	sch := schema.NewMock(t)
+	natsComponent, mockJetStream := nats.NewMock(t, r, nats.DefaultConfiguration())
+
+	c, err := New(r, DefaultConfiguration(), Dependencies{
+		Daemon:   daemonComponent,
+		Flow:     flowComponent,
+		Metadata: metadataComponent,
+		NATS:     natsComponent,
+		HTTP:     httpComponent,
+		Routing:  routingComponent,
+		Schema:   sch,
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	helpers.StartStop(t, c)
+
+	// The first flows are dropped because of a cache miss in the metadata
+	// component.
+	timeout := time.After(time.Second)
+	for {
+		flowComponent.Inject(&schema.FlowMessage{
+			TimeReceived:    200,
+			SamplingRate:    1000,
+			ExporterAddress: netip.MustParseAddr("192.0.2.142"),
+			InIf:            434,
+			OutIf:           677,
+		})
+		select {
+		case <-timeout:
+			t.Fatal("flow not published to NATS")
+		case <-time.After(20 * time.Millisecond):
+		}
+		messages := mockJetStream.Messages()
+		if len(messages) == 0 {
+			continue
+		}
+		expected := fmt.Sprintf("flows.%s", sch.ProtobufMessageHash())
+		if messages[0].Subject != expected {
+			t.Fatalf("Subject == %q, expected %q", messages[0].Subject, expected)
+		}
+		got, err := sch.ProtobufDecodeMap(messages[0].Data)
+		if err != nil {
+			t.Fatalf("ProtobufDecodeMap() error:\n%+v", err)
+		}
+		if diff := helpers.Diff(got["ExporterAddress"], "192.0.2.142"); diff != "" {
+			t.Fatalf("ExporterAddress (-got, +want):\n%s", diff)
+		}
+		return
+	}
+}
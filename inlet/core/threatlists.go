@@ -0,0 +1,305 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/tomb.v2"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+)
+
+// threatLists tags flows whose source or destination address matches one of
+// the configured lists. Lists are loaded in the background and refreshed
+// periodically. A list failing to load keeps its previous content.
+type threatLists struct {
+	r      *reporter.Reporter
+	config ThreatListsConfiguration
+	client *http.Client
+
+	srcEnabled bool
+	dstEnabled bool
+
+	// entries contains the parsed content of each list. It is only used by
+	// the refresh goroutine.
+	entries map[string][]threatListPrefix
+	// lookup is the merged content of all lists
+	lookup atomic.Pointer[helpers.SubnetMap[threatListMatch]]
+
+	metrics struct {
+		prefixes *reporter.GaugeVec
+		updates  *reporter.CounterVec
+		errors   *reporter.CounterVec
+		matches  *reporter.CounterVec
+	}
+}
+
+// threatListPrefix is a prefix with its label.
+type threatListPrefix struct {
+	prefix netip.Prefix
+	label  string
+}
+
+// threatListMatch is the value stored for each prefix.
+type threatListMatch struct {
+	List  string
+	Label string
+}
+
+// newThreatLists creates a new threat lists component.
+func newThreatLists(r *reporter.Reporter, config ThreatListsConfiguration, sch *schema.Component) (*threatLists, error) {
+	src, _ := sch.LookupColumnByKey(schema.ColumnSrcThreatList)
+	dst, _ := sch.LookupColumnByKey(schema.ColumnDstThreatList)
+	if src.Disabled && dst.Disabled {
+		return nil, fmt.Errorf("threat lists require the %s or the %s column to be enabled",
+			schema.ColumnSrcThreatList, schema.ColumnDstThreatList)
+	}
+	names := map[string]bool{}
+	for _, list := range config.Lists {
+		if names[list.Name] {
+			return nil, fmt.Errorf("duplicate threat list %q", list.Name)
+		}
+		names[list.Name] = true
+	}
+	tl := threatLists{
+		r:       r,
+		config:  config,
+		client:  &http.Client{},
+		entries: map[string][]threatListPrefix{},
+
+		srcEnabled: !src.Disabled,
+		dstEnabled: !dst.Disabled,
+	}
+	tl.lookup.Store(helpers.MustNewSubnetMap[threatListMatch](nil))
+
+	tl.metrics.prefixes = r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "threat_lists_prefixes",
+			Help: "Number of prefixes in each threat list.",
+		},
+		[]string{"list"})
+	tl.metrics.updates = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "threat_lists_updates_total",
+			Help: "Number of successful updates of each threat list.",
+		},
+		[]string{"list"})
+	tl.metrics.errors = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "threat_lists_errors_total",
+			Help: "Number of failed updates of each threat list.",
+		},
+		[]string{"list"})
+	tl.metrics.matches = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "threat_lists_matches_total",
+			Help: "Number of addresses matching each threat list.",
+		},
+		[]string{"list", "direction"})
+	return &tl, nil
+}
+
+// start starts the goroutine loading and refreshing lists.
+func (tl *threatLists) start(t *tomb.Tomb) {
+	t.Go(func() error {
+		ticker := time.NewTicker(tl.config.Interval)
+		defer ticker.Stop()
+		for {
+			tl.refresh(t.Context(nil))
+			select {
+			case <-t.Dying():
+				return nil
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// refresh loads all lists and replaces the merged lookup table.
+func (tl *threatLists) refresh(ctx context.Context) {
+	for _, list := range tl.config.Lists {
+		ctx, cancel := context.WithTimeout(ctx, tl.config.Timeout)
+		prefixes, err := tl.load(ctx, list)
+		cancel()
+		if err != nil {
+			tl.r.Err(err).Str("list", list.Name).Msg("cannot load threat list")
+			tl.metrics.errors.WithLabelValues(list.Name).Inc()
+			continue
+		}
+		tl.entries[list.Name] = prefixes
+		tl.metrics.updates.WithLabelValues(list.Name).Inc()
+		tl.metrics.prefixes.WithLabelValues(list.Name).Set(float64(len(prefixes)))
+	}
+
+	// Insert lists in reverse order so that, for the same prefix, the first
+	// list wins.
+	lookup := helpers.MustNewSubnetMap[threatListMatch](nil)
+	for i := len(tl.config.Lists) - 1; i >= 0; i-- {
+		list := tl.config.Lists[i]
+		for _, p := range tl.entries[list.Name] {
+			label := p.label
+			if label == "" {
+				label = list.Name
+			}
+			lookup.Set(p.prefix.String(), threatListMatch{List: list.Name, Label: label})
+		}
+	}
+	tl.lookup.Store(lookup)
+}
+
+// load fetches and parses a list.
+func (tl *threatLists) load(ctx context.Context, list ThreatListConfiguration) ([]threatListPrefix, error) {
+	var reader io.Reader
+	if strings.HasPrefix(list.Source, "http://") || strings.HasPrefix(list.Source, "https://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, list.Source, nil)
+		if err != nil {
+			return nil, fmt.Errorf("cannot build request: %w", err)
+		}
+		resp, err := tl.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("cannot fetch %s: %w", list.Source, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, list.Source)
+		}
+		reader = resp.Body
+	} else {
+		f, err := os.Open(list.Source)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open %s: %w", list.Source, err)
+		}
+		defer f.Close()
+		reader = f
+	}
+	switch list.Format {
+	case ThreatListFormatCSV:
+		return parseThreatListCSV(reader, list.LabelColumn)
+	default:
+		return parseThreatListText(reader)
+	}
+}
+
+// parseThreatListText parses a list with one address or prefix per line.
+// Empty lines and comments (starting with "#" or ";") are ignored. Anything
+// after the first word is ignored too.
+func parseThreatListText(reader io.Reader) ([]threatListPrefix, error) {
+	prefixes := []threatListPrefix{}
+	scanner := bufio.NewScanner(reader)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.FieldsFunc(scanner.Text(), func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ';' || r == '#' || r == ','
+		})
+		text := strings.TrimSpace(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(text, "#") || strings.HasPrefix(text, ";") {
+			continue
+		}
+		prefix, err := parseThreatListPrefix(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		prefixes = append(prefixes, threatListPrefix{prefix: prefix})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read list: %w", err)
+	}
+	return prefixes, nil
+}
+
+// parseThreatListCSV parses a CSV list. The first line is the header. The
+// first column contains the address or prefix and the label is taken from
+// the provided column, if any.
+func parseThreatListCSV(reader io.Reader, labelColumn string) ([]threatListPrefix, error) {
+	r := csv.NewReader(reader)
+	r.FieldsPerRecord = -1
+	r.Comment = '#'
+	r.ReuseRecord = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CSV header: %w", err)
+	}
+	labelIndex := -1
+	if labelColumn != "" {
+		labelIndex = slices.Index(header, labelColumn)
+		if labelIndex == -1 {
+			return nil, fmt.Errorf("cannot find column %q in CSV header", labelColumn)
+		}
+	}
+	prefixes := []threatListPrefix{}
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CSV: %w", err)
+		}
+		if len(record) == 0 || record[0] == "" {
+			continue
+		}
+		prefix, err := parseThreatListPrefix(record[0])
+		if err != nil {
+			line, _ := r.FieldPos(0)
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entry := threatListPrefix{prefix: prefix}
+		if labelIndex >= 0 && labelIndex < len(record) {
+			entry.label = strings.Clone(record[labelIndex])
+		}
+		prefixes = append(prefixes, entry)
+	}
+	return prefixes, nil
+}
+
+// parseThreatListPrefix parses an address or a prefix.
+func parseThreatListPrefix(input string) (netip.Prefix, error) {
+	input = strings.TrimSpace(input)
+	if strings.Contains(input, "/") {
+		prefix, err := netip.ParsePrefix(input)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("cannot parse prefix %q: %w", input, err)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(input)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("cannot parse address %q: %w", input, err)
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// enrich sets the threat list columns for the source and destination
+// addresses of the flow.
+func (tl *threatLists) enrich(sch *schema.Component, flow *schema.FlowMessage) {
+	lookup := tl.lookup.Load()
+	if tl.srcEnabled && flow.SrcAddr.IsValid() {
+		if match, ok := lookup.Lookup(flow.SrcAddr); ok {
+			sch.ProtobufAppendBytes(flow, schema.ColumnSrcThreatList, []byte(match.Label))
+			tl.metrics.matches.WithLabelValues(match.List, "src").Inc()
+		}
+	}
+	if tl.dstEnabled && flow.DstAddr.IsValid() {
+		if match, ok := lookup.Lookup(flow.DstAddr); ok {
+			sch.ProtobufAppendBytes(flow, schema.ColumnDstThreatList, []byte(match.Label))
+			tl.metrics.matches.WithLabelValues(match.List, "dst").Inc()
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+)
+
+func TestParseThreatListText(t *testing.T) {
+	input := `# Comment
+; Another comment
+192.0.2.0/24 ; SBL123
+
+198.51.100.1
+2001:db8::/32
+203.0.113.7/24
+`
+	got, err := parseThreatListText(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parseThreatListText() error:\n%+v", err)
+	}
+	expected := []threatListPrefix{
+		{prefix: netip.MustParsePrefix("192.0.2.0/24")},
+		{prefix: netip.MustParsePrefix("198.51.100.1/32")},
+		{prefix: netip.MustParsePrefix("2001:db8::/32")},
+		{prefix: netip.MustParsePrefix("203.0.113.0/24")},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("parseThreatListText() (-got, +want):\n%s", diff)
+	}
+
+	if _, err := parseThreatListText(strings.NewReader("192.0.2.0/24\nnot an IP\n")); err == nil {
+		t.Fatal("parseThreatListText() did not error")
+	}
+}
+
+func TestParseThreatListCSV(t *testing.T) {
+	input := `ip,first_seen,malware
+192.0.2.1,2024-01-01,emotet
+# Comment
+198.51.100.0/24,2024-01-02,
+`
+	got, err := parseThreatListCSV(strings.NewReader(input), "malware")
+	if err != nil {
+		t.Fatalf("parseThreatListCSV() error:\n%+v", err)
+	}
+	expected := []threatListPrefix{
+		{prefix: netip.MustParsePrefix("192.0.2.1/32"), label: "emotet"},
+		{prefix: netip.MustParsePrefix("198.51.100.0/24")},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("parseThreatListCSV() (-got, +want):\n%s", diff)
+	}
+
+	if _, err := parseThreatListCSV(strings.NewReader(input), "label"); err == nil {
+		t.Fatal("parseThreatListCSV() did not error on unknown column")
+	}
+}
+
+func TestThreatLists(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "drop.txt")
+	if err := os.WriteFile(path, []byte("192.0.2.0/24\n2001:db8::/32\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error:\n%+v", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("ip,malware\n192.0.2.1,emotet\n198.51.100.1,qakbot\n"))
+	}))
+	defer server.Close()
+
+	sch, err := schema.New(schema.Configuration{
+		Enabled: []schema.ColumnKey{schema.ColumnSrcThreatList, schema.ColumnDstThreatList},
+	})
+	if err != nil {
+		t.Fatalf("schema.New() error:\n%+v", err)
+	}
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration().ThreatLists
+	config.Lists = []ThreatListConfiguration{
+		{Name: "drop", Source: path},
+		{Name: "c2", Source: server.URL, Format: ThreatListFormatCSV, LabelColumn: "malware"},
+		{Name: "missing", Source: filepath.Join(dir, "missing.txt")},
+	}
+	tl, err := newThreatLists(r, config, sch)
+	if err != nil {
+		t.Fatalf("newThreatLists() error:\n%+v", err)
+	}
+	tl.refresh(context.Background())
+
+	flow := &schema.FlowMessage{
+		SrcAddr: netip.MustParseAddr("::ffff:192.0.2.1"),
+		DstAddr: netip.MustParseAddr("::ffff:198.51.100.1"),
+	}
+	tl.enrich(sch, flow)
+	expected := map[schema.ColumnKey]interface{}{
+		schema.ColumnSrcThreatList: []byte("emotet"),
+		schema.ColumnDstThreatList: []byte("qakbot"),
+	}
+	if diff := helpers.Diff(flow.ProtobufDebug, expected); diff != "" {
+		t.Fatalf("enrich() (-got, +want):\n%s", diff)
+	}
+
+	flow = &schema.FlowMessage{
+		SrcAddr: netip.MustParseAddr("::ffff:192.0.2.2"),
+		DstAddr: netip.MustParseAddr("2001:db8::1"),
+	}
+	tl.enrich(sch, flow)
+	expected = map[schema.ColumnKey]interface{}{
+		schema.ColumnSrcThreatList: []byte("drop"),
+		schema.ColumnDstThreatList: []byte("drop"),
+	}
+	if diff := helpers.Diff(flow.ProtobufDebug, expected); diff != "" {
+		t.Fatalf("enrich() (-got, +want):\n%s", diff)
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_core_threat_lists_")
+	expectedMetrics := map[string]string{
+		`errors_total{list="missing"}`:               "1",
+		`matches_total{direction="dst",list="c2"}`:   "1",
+		`matches_total{direction="dst",list="drop"}`: "1",
+		`matches_total{direction="src",list="c2"}`:   "1",
+		`matches_total{direction="src",list="drop"}`: "1",
+		`prefixes{list="c2"}`:                        "2",
+		`prefixes{list="drop"}`:                      "2",
+		`updates_total{list="c2"}`:                   "1",
+		`updates_total{list="drop"}`:                 "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestThreatListsRequireColumns(t *testing.T) {
+	config := DefaultConfiguration().ThreatLists
+	config.Lists = []ThreatListConfiguration{{Name: "drop", Source: "/dev/null"}}
+	if _, err := newThreatLists(reporter.NewMock(t), config, schema.NewMock(t)); err == nil {
+		t.Fatal("newThreatLists() did not error")
+	}
+}
@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+
+	"akvorado/common/schema"
+)
+
+// topicRule is a compiled Kafka topic rule.
+type topicRule struct {
+	program *vm.Program
+	topic   string
+}
+
+// topicRuleEnvironment defines the environment used by topic rules.
+type topicRuleEnvironment struct {
+	Flow     map[string]interface{}
+	InSubnet func(string, string) (bool, error)
+}
+
+// compileTopicRules compiles the topic rules configured for the Kafka
+// component. It also collects the columns used to build partition keys.
+func (c *Component) compileTopicRules() error {
+	if c.d.Kafka == nil {
+		// An empty topic selects the main topic.
+		return nil
+	}
+	for _, key := range c.d.Kafka.PartitionFields() {
+		c.partitionFields = append(c.partitionFields, key.String())
+	}
+	rules := c.d.Kafka.TopicRules()
+	c.topicRules = make([]topicRule, 0, len(rules))
+	for _, rule := range rules {
+		program, err := expr.Compile(rule.Condition,
+			expr.Env(topicRuleEnvironment{}),
+			expr.AsBool())
+		if err != nil {
+			return fmt.Errorf("cannot compile topic rule %q: %w", rule.Condition, err)
+		}
+		c.topicRules = append(c.topicRules, topicRule{
+			program: program,
+			topic:   c.d.Kafka.Topic(rule.Suffix),
+		})
+	}
+	c.kafkaTopic = c.d.Kafka.Topic("")
+	return nil
+}
+
+// flowFields returns the columns of the provided flow when they are needed to
+// select the topic or to build the partition key. It should be called before
+// serializing the flow.
+func (c *Component) flowFields(flow *schema.FlowMessage) map[string]interface{} {
+	if len(c.topicRules) == 0 && len(c.partitionFields) == 0 {
+		return nil
+	}
+	return c.d.Schema.ProtobufFlowMap(flow)
+}
+
+// selectTopic returns the Kafka topic to use for a flow, using the columns
+// returned by flowFields. The first matching rule wins. When no rule matches,
+// the main topic is used.
+func (c *Component) selectTopic(fields map[string]interface{}) string {
+	if len(c.topicRules) == 0 {
+		return c.kafkaTopic
+	}
+	env := topicRuleEnvironment{
+		Flow:     fields,
+		InSubnet: inSubnet,
+	}
+	for idx, rule := range c.topicRules {
+		result, err := expr.Run(rule.program, env)
+		if err != nil {
+			c.classifierErrLogger.Err(err).
+				Int("index", idx).
+				Msg("error executing topic rule")
+			c.metrics.classifierErrors.WithLabelValues("topic", strconv.Itoa(idx)).Inc()
+			continue
+		}
+		if result.(bool) {
+			return rule.topic
+		}
+	}
+	return c.kafkaTopic
+}
+
+// partitionKey returns the Kafka partition key for a flow, using the columns
+// returned by flowFields. It is nil unless the fields partitioner is used.
+func (c *Component) partitionKey(fields map[string]interface{}) []byte {
+	if len(c.partitionFields) == 0 {
+		return nil
+	}
+	key := make([]byte, 0, 16*len(c.partitionFields))
+	for _, name := range c.partitionFields {
+		key = fmt.Appendf(key, "%v\x00", fields[name])
+	}
+	return key
+}
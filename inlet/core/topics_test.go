@@ -0,0 +1,151 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+	"time"
+
+	"akvorado/common/helpers"
+	"akvorado/common/helpers/cache"
+	"akvorado/common/kafka"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	inletkafka "akvorado/inlet/kafka"
+)
+
+func TestTopicRules(t *testing.T) {
+	r := reporter.NewMock(t)
+	sch := schema.NewMock(t)
+	hash := sch.ProtobufMessageHash()
+	config := inletkafka.DefaultConfiguration()
+	config.TopicRules = []kafka.TopicRule{
+		{Condition: `InSubnet(Flow.ExporterAddress, "192.0.2.0/24")`, Suffix: "ix"},
+		{Condition: `Flow.InIfBoundary == "external" && Flow.SrcAS == 65000`, Suffix: "transit"},
+		{Condition: `Flow.ExporterName startsWith "ix-"`, Suffix: "ix"},
+		{Condition: `InSubnet(Flow.ExporterName, "192.0.2.0/24")`, Suffix: "error"},
+	}
+	kafkaComponent, _ := inletkafka.NewMock(t, r, config)
+	c := Component{
+		r:                        r,
+		d:                        &Dependencies{Schema: sch, Kafka: kafkaComponent},
+		classifierExporterCache:  cache.NewBounded[exporterInfo, exporterClassification](10),
+		classifierInterfaceCache: cache.NewBounded[exporterAndInterfaceInfo, interfaceClassification](10),
+		classifierErrLogger:      r.Sample(reporter.BurstSampler(time.Second, 1)),
+	}
+	c.initMetrics()
+	if err := c.compileTopicRules(); err != nil {
+		t.Fatalf("compileTopicRules() error:\n%+v", err)
+	}
+
+	cases := []struct {
+		Description string
+		Flow        func() *schema.FlowMessage
+		Topic       string
+	}{
+		{
+			Description: "exporter subnet",
+			Flow: func() *schema.FlowMessage {
+				return &schema.FlowMessage{ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.10")}
+			},
+			Topic: fmt.Sprintf("flows-ix-%s", hash),
+		}, {
+			Description: "boundary and AS",
+			Flow: func() *schema.FlowMessage {
+				bf := &schema.FlowMessage{
+					ExporterAddress: netip.MustParseAddr("::ffff:198.51.100.10"),
+					SrcAS:           65000,
+				}
+				sch.ProtobufAppendVarint(bf, schema.ColumnInIfBoundary, uint64(schema.InterfaceBoundaryExternal))
+				return bf
+			},
+			Topic: fmt.Sprintf("flows-transit-%s", hash),
+		}, {
+			Description: "exporter name",
+			Flow: func() *schema.FlowMessage {
+				bf := &schema.FlowMessage{ExporterAddress: netip.MustParseAddr("::ffff:198.51.100.10")}
+				sch.ProtobufAppendBytes(bf, schema.ColumnExporterName, []byte("ix-router1"))
+				return bf
+			},
+			Topic: fmt.Sprintf("flows-ix-%s", hash),
+		}, {
+			Description: "default topic",
+			Flow: func() *schema.FlowMessage {
+				return &schema.FlowMessage{ExporterAddress: netip.MustParseAddr("::ffff:198.51.100.10")}
+			},
+			Topic: fmt.Sprintf("flows-%s", hash),
+		},
+	}
+	for _, tc := range cases {
+		if got := c.selectTopic(c.flowFields(tc.Flow())); got != tc.Topic {
+			t.Errorf("selectTopic(%s) == %q, expected %q", tc.Description, got, tc.Topic)
+		}
+	}
+
+	// The last rule cannot be evaluated. Only the flow for the default topic
+	// reaches it.
+	gotMetrics := r.GetMetrics("akvorado_inlet_core_", "classifier_errors_total")
+	expectedMetrics := map[string]string{
+		`classifier_errors_total{index="3",type="topic"}`: "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestInvalidTopicRule(t *testing.T) {
+	r := reporter.NewMock(t)
+	config := inletkafka.DefaultConfiguration()
+	config.TopicRules = []kafka.TopicRule{{Condition: `Flow.Something ==`, Suffix: "ix"}}
+	kafkaComponent, _ := inletkafka.NewMock(t, r, config)
+	c := Component{
+		r: r,
+		d: &Dependencies{Schema: schema.NewMock(t), Kafka: kafkaComponent},
+	}
+	if err := c.compileTopicRules(); err == nil {
+		t.Fatal("compileTopicRules() did not error")
+	}
+}
+
+func TestPartitionKey(t *testing.T) {
+	r := reporter.NewMock(t)
+	sch := schema.NewMock(t)
+	config := inletkafka.DefaultConfiguration()
+	config.Partitioner = inletkafka.PartitionerFields
+	config.PartitionFields = []schema.ColumnKey{schema.ColumnSrcAddr, schema.ColumnDstPort}
+	kafkaComponent, _ := inletkafka.NewMock(t, r, config)
+	c := Component{
+		r: r,
+		d: &Dependencies{Schema: sch, Kafka: kafkaComponent},
+	}
+	if err := c.compileTopicRules(); err != nil {
+		t.Fatalf("compileTopicRules() error:\n%+v", err)
+	}
+
+	flow := func(srcAddr string, dstPort uint64) *schema.FlowMessage {
+		bf := &schema.FlowMessage{
+			ExporterAddress: netip.MustParseAddr("::ffff:198.51.100.10"),
+			SrcAddr:         netip.MustParseAddr(srcAddr),
+		}
+		sch.ProtobufAppendVarint(bf, schema.ColumnDstPort, dstPort)
+		return bf
+	}
+	key1 := c.partitionKey(c.flowFields(flow("::ffff:192.0.2.10", 443)))
+	key2 := c.partitionKey(c.flowFields(flow("::ffff:192.0.2.10", 443)))
+	key3 := c.partitionKey(c.flowFields(flow("::ffff:192.0.2.10", 80)))
+	if diff := helpers.Diff(string(key1), "192.0.2.10\x00443\x00"); diff != "" {
+		t.Errorf("partitionKey() (-got, +want):\n%s", diff)
+	}
+	if string(key1) != string(key2) {
+		t.Errorf("partitionKey() is not stable: %q != %q", key1, key2)
+	}
+	if string(key1) == string(key3) {
+		t.Errorf("partitionKey() is identical for different flows: %q", key1)
+	}
+	if got := c.selectTopic(c.flowFields(flow("::ffff:192.0.2.10", 80))); got != c.kafkaTopic {
+		t.Errorf("selectTopic() == %q, expected %q", got, c.kafkaTopic)
+	}
+}
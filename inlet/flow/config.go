@@ -4,12 +4,16 @@
 package flow
 
 import (
+	"time"
+
 	"golang.org/x/time/rate"
 
 	"akvorado/common/helpers"
 	"akvorado/inlet/flow/decoder"
 	"akvorado/inlet/flow/input"
 	"akvorado/inlet/flow/input/file"
+	"akvorado/inlet/flow/input/kafka"
+	"akvorado/inlet/flow/input/tcp"
 	"akvorado/inlet/flow/input/udp"
 )
 
@@ -20,6 +24,33 @@ type Configuration struct {
 	// RateLimit defines a rate limit on the number of flows per
 	// second. The limit is per-exporter.
 	RateLimit rate.Limit `validate:"isdefault|min=100"`
+	// TemplatesPersistFile defines a file to store NetFlow/IPFIX templates
+	// and survive restarts.
+	TemplatesPersistFile string
+	// TemplatesPersistInterval defines how often templates are saved. When
+	// 0, they are only saved on shutdown.
+	TemplatesPersistInterval time.Duration `validate:"isdefault|min=1s"`
+	// TemplatesMaxAge defines the maximum age of a persisted template to be
+	// loaded on start. When 0, all templates are loaded.
+	TemplatesMaxAge time.Duration `validate:"min=0"`
+	// ExporterOverrides overrides some flow attributes for exporters
+	// matching a subnet. The most specific subnet wins.
+	ExporterOverrides helpers.SubnetMap[ExporterOverride] `validate:"dive"`
+}
+
+// ExporterOverride describes the attributes to override for an exporter.
+type ExporterOverride struct {
+	// SamplingRate replaces the sampling rate reported by the exporter.
+	// When 0, the sampling rate is not replaced.
+	SamplingRate uint32
+	// TimestampSource replaces the timestamp source of the input. It only
+	// applies to NetFlow and IPFIX.
+	TimestampSource *decoder.TimestampSource
+	// IgnoreASN tells to ignore AS numbers reported by the exporter.
+	IgnoreASN bool
+	// RateLimit replaces the rate limit on the number of flows per second
+	// for the exporter. When 0, the global rate limit is used.
+	RateLimit rate.Limit `validate:"isdefault|min=100"`
 }
 
 // DefaultConfiguration represents the default configuration for the flow component
@@ -34,6 +65,8 @@ func DefaultConfiguration() Configuration {
 			Decoder:         "sflow",
 			Config:          udp.DefaultConfiguration(),
 		}},
+		TemplatesPersistInterval: 5 * time.Minute,
+		TemplatesMaxAge:          time.Hour,
 	}
 }
 
@@ -46,6 +79,23 @@ type InputConfiguration struct {
 	UseSrcAddrForExporterAddr bool
 	// TimestampSource identify the source to use to timestamp the flows
 	TimestampSource decoder.TimestampSource
+	// TimestampMaxSkew is the maximum difference between the timestamp
+	// extracted from a flow and the time it was received. When 0, there is no
+	// limit.
+	TimestampMaxSkew time.Duration `validate:"min=0"`
+	// InterfaceCountersLimit defines the maximum number of interfaces per
+	// exporter for which counters from sFlow counter samples are exported as
+	// metrics. When 0, counters are not exported.
+	InterfaceCountersLimit uint
+	// Decapsulate enables decoding of the inner packet for VXLAN and GRE
+	// tunnels in raw packet headers.
+	Decapsulate bool
+	// DecapsulateGTP enables decoding of the inner packet for GTP-U tunnels in
+	// raw packet headers.
+	DecapsulateGTP bool
+	// IPFIXCustomFields maps enterprise-specific IPFIX fields to custom
+	// columns declared in the schema.
+	IPFIXCustomFields []decoder.IPFIXCustomField `validate:"dive"`
 	// Config is the actual configuration of the input.
 	Config input.Configuration
 }
@@ -56,11 +106,15 @@ func (ic InputConfiguration) MarshalYAML() (interface{}, error) {
 }
 
 var inputs = map[string](func() input.Configuration){
-	"udp":  udp.DefaultConfiguration,
-	"file": file.DefaultConfiguration,
+	"udp":   udp.DefaultConfiguration,
+	"file":  file.DefaultConfiguration,
+	"tcp":   tcp.DefaultConfiguration,
+	"kafka": kafka.DefaultConfiguration,
 }
 
 func init() {
 	helpers.RegisterMapstructureUnmarshallerHook(
 		helpers.ParametrizedConfigurationUnmarshallerHook(InputConfiguration{}, inputs))
+	helpers.RegisterMapstructureUnmarshallerHook(helpers.SubnetMapUnmarshallerHook[ExporterOverride]())
+	helpers.RegisterSubnetMapValidation[ExporterOverride]()
 }
@@ -6,6 +6,7 @@ package flow
 import (
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
@@ -46,7 +47,7 @@ func TestDecodeConfiguration(t *testing.T) {
 					Config: &udp.Configuration{
 						Workers:   3,
 						QueueSize: 100000,
-						Listen:    "192.0.2.1:2055",
+						Listen:    []string{"192.0.2.1:2055"},
 					},
 					UseSrcAddrForExporterAddr: true,
 				}, {
@@ -54,7 +55,7 @@ func TestDecodeConfiguration(t *testing.T) {
 					Config: &udp.Configuration{
 						Workers:   3,
 						QueueSize: 100000,
-						Listen:    "192.0.2.1:6343",
+						Listen:    []string{"192.0.2.1:6343"},
 					},
 					UseSrcAddrForExporterAddr: false,
 				}},
@@ -95,14 +96,14 @@ func TestDecodeConfiguration(t *testing.T) {
 					Config: &udp.Configuration{
 						Workers:   3,
 						QueueSize: 100000,
-						Listen:    "192.0.2.1:2055",
+						Listen:    []string{"192.0.2.1:2055"},
 					},
 				}, {
 					Decoder: "sflow",
 					Config: &udp.Configuration{
 						Workers:   3,
 						QueueSize: 100000,
-						Listen:    "192.0.2.1:6343",
+						Listen:    []string{"192.0.2.1:6343"},
 					},
 				}},
 			},
@@ -131,6 +132,7 @@ func TestDecodeConfiguration(t *testing.T) {
 					Decoder: "netflow",
 					Config: &file.Configuration{
 						Paths: []string{"file1", "file2"},
+						Loop:  true,
 					},
 				}},
 			},
@@ -143,7 +145,7 @@ func TestDecodeConfiguration(t *testing.T) {
 						Config: &udp.Configuration{
 							Workers:   2,
 							QueueSize: 100,
-							Listen:    "127.0.0.1:2055",
+							Listen:    []string{"127.0.0.1:2055"},
 						},
 					}},
 				}
@@ -163,7 +165,7 @@ func TestDecodeConfiguration(t *testing.T) {
 					Config: &udp.Configuration{
 						Workers:   2,
 						QueueSize: 100,
-						Listen:    "192.0.2.1:2055",
+						Listen:    []string{"192.0.2.1:2055"},
 					},
 				}},
 			},
@@ -200,7 +202,7 @@ func TestDecodeConfiguration(t *testing.T) {
 						Config: &udp.Configuration{
 							Workers:   2,
 							QueueSize: 100,
-							Listen:    "127.0.0.1:2055",
+							Listen:    []string{"127.0.0.1:2055"},
 						},
 					}},
 				}
@@ -222,7 +224,7 @@ func TestDecodeConfiguration(t *testing.T) {
 					Config: &udp.Configuration{
 						Workers:   2,
 						QueueSize: 100,
-						Listen:    "192.0.2.1:2055",
+						Listen:    []string{"192.0.2.1:2055"},
 					},
 				}},
 			},
@@ -236,7 +238,7 @@ func TestDecodeConfiguration(t *testing.T) {
 						Config: &udp.Configuration{
 							Workers:   2,
 							QueueSize: 100,
-							Listen:    "127.0.0.1:2055",
+							Listen:    []string{"127.0.0.1:2055"},
 						},
 					}},
 				}
@@ -258,11 +260,167 @@ func TestDecodeConfiguration(t *testing.T) {
 					Config: &udp.Configuration{
 						Workers:   2,
 						QueueSize: 100,
-						Listen:    "192.0.2.1:2055",
+						Listen:    []string{"192.0.2.1:2055"},
 					},
 				}},
 			},
 		},
+		{
+			Description: "netflow timestamp source flow-end with skew",
+			Initial: func() interface{} {
+				return Configuration{
+					Inputs: []InputConfiguration{{
+						Decoder: "netflow",
+						Config: &udp.Configuration{
+							Workers:   2,
+							QueueSize: 100,
+							Listen:    []string{"127.0.0.1:2055"},
+						},
+					}},
+				}
+			},
+			Configuration: func() interface{} {
+				return gin.H{
+					"inputs": []gin.H{
+						{
+							"timestamp-source":   "flow-end",
+							"timestamp-max-skew": "1h",
+							"listen":             "192.0.2.1:2055",
+						},
+					},
+				}
+			},
+			Expected: Configuration{
+				Inputs: []InputConfiguration{{
+					Decoder:          "netflow",
+					TimestampSource:  decoder.TimestampSourceNetflowLastSwitched,
+					TimestampMaxSkew: time.Hour,
+					Config: &udp.Configuration{
+						Workers:   2,
+						QueueSize: 100,
+						Listen:    []string{"192.0.2.1:2055"},
+					},
+				}},
+			},
+		},
+		{
+			Description: "IPFIX custom fields",
+			Initial: func() interface{} {
+				return Configuration{
+					Inputs: []InputConfiguration{{
+						Decoder: "netflow",
+						Config: &udp.Configuration{
+							Workers:   2,
+							QueueSize: 100,
+							Listen:    []string{"127.0.0.1:2055"},
+						},
+					}},
+				}
+			},
+			Configuration: func() interface{} {
+				return gin.H{
+					"inputs": []gin.H{
+						{
+							"listen": "192.0.2.1:2055",
+							"ipfix-custom-fields": []gin.H{
+								{
+									"pen":  9,
+									"id":   12235,
+									"name": "ApplicationName",
+									"type": "string",
+								},
+							},
+						},
+					},
+				}
+			},
+			Expected: Configuration{
+				Inputs: []InputConfiguration{{
+					Decoder: "netflow",
+					IPFIXCustomFields: []decoder.IPFIXCustomField{
+						{PEN: 9, ID: 12235, Name: "ApplicationName", Type: "string"},
+					},
+					Config: &udp.Configuration{
+						Workers:   2,
+						QueueSize: 100,
+						Listen:    []string{"192.0.2.1:2055"},
+					},
+				}},
+			},
+		},
+		{
+			Description: "exporter overrides",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"exporter-overrides": gin.H{
+						"192.0.2.0/24": gin.H{
+							"sampling-rate": 100,
+							"ignore-asn":    true,
+						},
+						"192.0.2.128/25": gin.H{
+							"timestamp-source": "netflow-packet",
+						},
+					},
+				}
+			},
+			Expected: Configuration{
+				ExporterOverrides: *helpers.MustNewSubnetMap(map[string]ExporterOverride{
+					"::ffff:192.0.2.0/120": {SamplingRate: 100, IgnoreASN: true},
+					"::ffff:192.0.2.128/121": {
+						TimestampSource: func() *decoder.TimestampSource {
+							ts := decoder.TimestampSourceNetflowPacket
+							return &ts
+						}(),
+					},
+				}),
+			},
+		}, {
+			Description: "exporter overrides with the same subnet twice",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"exporter-overrides": gin.H{
+						"192.0.2.0/24":         gin.H{"sampling-rate": 100},
+						"::ffff:192.0.2.0/120": gin.H{"sampling-rate": 1000},
+					},
+				}
+			},
+			Error: true,
+		},
+		{
+			Description: "IPFIX custom fields with unknown type",
+			Initial: func() interface{} {
+				return Configuration{
+					Inputs: []InputConfiguration{{
+						Decoder: "netflow",
+						Config: &udp.Configuration{
+							Workers:   2,
+							QueueSize: 100,
+							Listen:    []string{"127.0.0.1:2055"},
+						},
+					}},
+				}
+			},
+			Configuration: func() interface{} {
+				return gin.H{
+					"inputs": []gin.H{
+						{
+							"listen": "192.0.2.1:2055",
+							"ipfix-custom-fields": []gin.H{
+								{
+									"pen":  9,
+									"id":   12235,
+									"name": "ApplicationName",
+									"type": "float",
+								},
+							},
+						},
+					},
+				}
+			},
+			Error: true,
+		},
 	})
 }
 
@@ -273,14 +431,14 @@ func TestMarshalYAML(t *testing.T) {
 				Decoder:         "netflow",
 				TimestampSource: decoder.TimestampSourceNetflowFirstSwitched,
 				Config: &udp.Configuration{
-					Listen:    "192.0.2.11:2055",
+					Listen:    []string{"192.0.2.11:2055"},
 					QueueSize: 1000,
 					Workers:   3,
 				},
 			}, {
 				Decoder: "sflow",
 				Config: &udp.Configuration{
-					Listen:    "192.0.2.11:6343",
+					Listen:    []string{"192.0.2.11:6343"},
 					QueueSize: 1000,
 					Workers:   3,
 				},
@@ -293,23 +451,39 @@ func TestMarshalYAML(t *testing.T) {
 		t.Fatalf("Marshal() error:\n%+v", err)
 	}
 	expected := `inputs:
-    - decoder: netflow
-      listen: 192.0.2.11:2055
+    - decapsulate: false
+      decapsulategtp: false
+      decoder: netflow
+      interfacecounterslimit: 0
+      ipfixcustomfields: []
+      listen:
+        - 192.0.2.11:2055
       queuesize: 1000
       receivebuffer: 0
+      timestampmaxskew: 0s
       timestampsource: netflow-first-switched
       type: udp
       usesrcaddrforexporteraddr: false
       workers: 3
-    - decoder: sflow
-      listen: 192.0.2.11:6343
+    - decapsulate: false
+      decapsulategtp: false
+      decoder: sflow
+      interfacecounterslimit: 0
+      ipfixcustomfields: []
+      listen:
+        - 192.0.2.11:6343
       queuesize: 1000
       receivebuffer: 0
+      timestampmaxskew: 0s
       timestampsource: udp
       type: udp
       usesrcaddrforexporteraddr: true
       workers: 3
 ratelimit: 0
+templatespersistfile: ""
+templatespersistinterval: 0s
+templatesmaxage: 0s
+exporteroverrides: {}
 `
 	if diff := helpers.Diff(strings.Split(string(got), "\n"), strings.Split(expected, "\n")); diff != "" {
 		t.Fatalf("Marshal() (-got, +want):\n%s", diff)
@@ -4,11 +4,13 @@
 package flow
 
 import (
+	"net"
 	"net/netip"
 
 	"akvorado/common/schema"
 	"akvorado/inlet/flow/decoder"
 	"akvorado/inlet/flow/decoder/netflow"
+	"akvorado/inlet/flow/decoder/protobuf"
 	"akvorado/inlet/flow/decoder/sflow"
 )
 
@@ -26,6 +28,16 @@ func (wd *wrappedDecoder) Decode(in decoder.RawFlow) []*schema.FlowMessage {
 				Inc()
 		}
 	}()
+	overrides := &wd.c.config.ExporterOverrides
+	if in.Source != nil {
+		// The timestamp source has to be known before decoding. It is
+		// looked up with the source address, which is also the exporter
+		// address for NetFlow and IPFIX.
+		source, _ := netip.AddrFromSlice(in.Source.To16())
+		if override, ok := overrides.Lookup(source); ok && override.TimestampSource != nil {
+			in.TimestampSource = override.TimestampSource
+		}
+	}
 	decoded := wd.orig.Decode(in)
 
 	if decoded == nil {
@@ -41,6 +53,24 @@ func (wd *wrappedDecoder) Decode(in decoder.RawFlow) []*schema.FlowMessage {
 		}
 	}
 
+	for _, f := range decoded {
+		if !f.ExporterAddress.IsValid() {
+			continue
+		}
+		override, ok := overrides.Lookup(f.ExporterAddress)
+		if !ok {
+			continue
+		}
+		if override.SamplingRate > 0 {
+			f.SamplingRate = override.SamplingRate
+		}
+		if override.IgnoreASN {
+			f.SrcAS = 0
+			f.DstAS = 0
+			f.DstASPath = nil
+		}
+	}
+
 	wd.c.metrics.decoderStats.WithLabelValues(wd.orig.Name()).
 		Inc()
 	return decoded
@@ -51,6 +81,13 @@ func (wd *wrappedDecoder) Name() string {
 	return wd.orig.Name()
 }
 
+// CloseSession forwards the end of a session to the original decoder.
+func (wd *wrappedDecoder) CloseSession(source net.IP, session string) {
+	if sd, ok := wd.orig.(decoder.SessionDecoder); ok {
+		sd.CloseSession(source, session)
+	}
+}
+
 // wrapDecoder wraps the provided decoders to get statistics from it.
 func (c *Component) wrapDecoder(d decoder.Decoder, useSrcAddrForExporterAddr bool) decoder.Decoder {
 	return &wrappedDecoder{
@@ -61,6 +98,7 @@ func (c *Component) wrapDecoder(d decoder.Decoder, useSrcAddrForExporterAddr boo
 }
 
 var decoders = map[string]decoder.NewDecoderFunc{
-	"netflow": netflow.New,
-	"sflow":   sflow.New,
+	"netflow":  netflow.New,
+	"sflow":    sflow.New,
+	"protobuf": protobuf.New,
 }
@@ -22,6 +22,9 @@ const (
 	// TimestampSourceNetflowFirstSwitched tells the decoder to use the timestamp
 	// from each flow "FIRST_SWITCHED" field
 	TimestampSourceNetflowFirstSwitched
+	// TimestampSourceNetflowLastSwitched tells the decoder to use the timestamp
+	// from each flow "LAST_SWITCHED" field
+	TimestampSourceNetflowLastSwitched
 )
 
 var (
@@ -29,6 +32,7 @@ var (
 		TimestampSourceUDP:                  "udp",
 		TimestampSourceNetflowPacket:        "netflow-packet",
 		TimestampSourceNetflowFirstSwitched: "netflow-first-switched",
+		TimestampSourceNetflowLastSwitched:  "netflow-last-switched",
 	})
 	errUnknownTimestampSource = errors.New("unknown TimestampSource")
 )
@@ -54,6 +58,14 @@ func (ib *TimestampSource) UnmarshalText(input []byte) error {
 		*ib = TimestampSourceUDP
 		return nil
 	}
+	switch string(input) {
+	case "received":
+		input = []byte("udp")
+	case "flow-start":
+		input = []byte("netflow-first-switched")
+	case "flow-end":
+		input = []byte("netflow-last-switched")
+	}
 	got, ok := timestampSourceMap.LoadKey(string(input))
 	if ok {
 		*ib = got
@@ -61,3 +73,16 @@ func (ib *TimestampSource) UnmarshalText(input []byte) error {
 	}
 	return errUnknownTimestampSource
 }
+
+// IPFIXCustomField maps an enterprise-specific IPFIX information element to a
+// custom column of the schema.
+type IPFIXCustomField struct {
+	// PEN is the private enterprise number
+	PEN uint32 `validate:"min=1"`
+	// ID is the information element identifier (without the enterprise bit)
+	ID uint16 `validate:"min=1,max=32767"`
+	// Name is the name of the custom column
+	Name string `validate:"required,alphanum"`
+	// Type is the type of the information element
+	Type string `validate:"required,oneof=uint string ip"`
+}
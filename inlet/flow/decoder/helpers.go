@@ -11,19 +11,37 @@ import (
 	"akvorado/common/schema"
 )
 
-// ParseIPv4 parses an IPv4 packet and returns layer-3 length.
-func ParseIPv4(sch *schema.Component, bf *schema.FlowMessage, data []byte) uint64 {
+// maxIPv6ExtensionHeaders is the maximum number of IPv6 extension headers to
+// skip to find the upper-layer protocol.
+const maxIPv6ExtensionHeaders = 8
+
+// ParseIPv4 parses an IPv4 packet and returns layer-3 length. When the packet
+// is a tunnel selected by decapsulate, the inner packet is parsed instead.
+func ParseIPv4(sch *schema.Component, bf *schema.FlowMessage, data []byte, decapsulate Decapsulation) uint64 {
 	var l3length uint64
 	var proto uint8
 	if len(data) < 20 {
 		return 0
 	}
-	sch.ProtobufAppendVarint(bf, schema.ColumnEType, helpers.ETypeIPv4)
 	l3length = uint64(binary.BigEndian.Uint16(data[2:4]))
-	bf.SrcAddr = DecodeIP(data[12:16])
-	bf.DstAddr = DecodeIP(data[16:20])
 	proto = data[9]
 	fragoffset := binary.BigEndian.Uint16(data[6:8]) & 0x1fff
+	ihl := int((data[0] & 0xf) * 4)
+	payload := data[:0]
+	if len(data) >= ihl {
+		payload = data[ihl:]
+	}
+	if decapsulate != 0 && fragoffset == 0 {
+		if inner, etherType, ok := decapsulateTunnel(sch, bf, proto, payload, decapsulate); ok {
+			sch.ProtobufAppendIP(bf, schema.ColumnOuterSrcAddr, DecodeIP(data[12:16]))
+			sch.ProtobufAppendIP(bf, schema.ColumnOuterDstAddr, DecodeIP(data[16:20]))
+			parseInnerIP(sch, bf, inner, etherType)
+			return l3length
+		}
+	}
+	sch.ProtobufAppendVarint(bf, schema.ColumnEType, helpers.ETypeIPv4)
+	bf.SrcAddr = DecodeIP(data[12:16])
+	bf.DstAddr = DecodeIP(data[16:20])
 	if !sch.IsDisabled(schema.ColumnGroupL3L4) {
 		sch.ProtobufAppendVarint(bf, schema.ColumnIPTos, uint64(data[1]))
 		sch.ProtobufAppendVarint(bf, schema.ColumnIPTTL, uint64(data[8]))
@@ -32,31 +50,65 @@ func ParseIPv4(sch *schema.Component, bf *schema.FlowMessage, data []byte) uint6
 		sch.ProtobufAppendVarint(bf, schema.ColumnIPFragmentOffset,
 			uint64(fragoffset))
 	}
-	ihl := int((data[0] & 0xf) * 4)
-	if len(data) >= ihl {
-		data = data[ihl:]
-	} else {
-		data = data[:0]
-	}
 	sch.ProtobufAppendVarint(bf, schema.ColumnProto, uint64(proto))
 	if fragoffset == 0 {
-		ParseL4(sch, bf, data, proto)
+		ParseL4(sch, bf, payload, proto)
 	}
 	return l3length
 }
 
-// ParseIPv6 parses an IPv6 packet and returns layer-3 length.
-func ParseIPv6(sch *schema.Component, bf *schema.FlowMessage, data []byte) uint64 {
+// ParseIPv6 parses an IPv6 packet and returns layer-3 length. When the packet
+// is a tunnel selected by decapsulate, the inner packet is parsed instead.
+func ParseIPv6(sch *schema.Component, bf *schema.FlowMessage, data []byte, decapsulate Decapsulation) uint64 {
 	var l3length uint64
 	var proto uint8
 	if len(data) < 40 {
 		return 0
 	}
 	l3length = uint64(binary.BigEndian.Uint16(data[4:6])) + 40
+	proto = data[6]
+	payload := data[40:]
+
+	// Walk the extension headers to find the upper-layer protocol.
+	var fragID uint32
+	var fragoffset uint16
+	var fragmented bool
+	for range maxIPv6ExtensionHeaders {
+		if proto != 0 && proto != 43 && proto != 44 && proto != 60 {
+			break
+		}
+		if len(payload) < 8 {
+			break
+		}
+		length := 8
+		if proto == 44 {
+			// Fragment
+			fragoffset = binary.BigEndian.Uint16(payload[2:4]) >> 3
+			fragID = binary.BigEndian.Uint32(payload[4:8])
+			fragmented = true
+		} else {
+			// Hop-by-hop, routing, destination options
+			length = (int(payload[1]) + 1) * 8
+		}
+		proto = payload[0]
+		if len(payload) < length {
+			payload = payload[:0]
+			break
+		}
+		payload = payload[length:]
+	}
+
+	if decapsulate != 0 && fragoffset == 0 {
+		if inner, etherType, ok := decapsulateTunnel(sch, bf, proto, payload, decapsulate); ok {
+			sch.ProtobufAppendIP(bf, schema.ColumnOuterSrcAddr, DecodeIP(data[8:24]))
+			sch.ProtobufAppendIP(bf, schema.ColumnOuterDstAddr, DecodeIP(data[24:40]))
+			parseInnerIP(sch, bf, inner, etherType)
+			return l3length
+		}
+	}
 	sch.ProtobufAppendVarint(bf, schema.ColumnEType, helpers.ETypeIPv6)
 	bf.SrcAddr = DecodeIP(data[8:24])
 	bf.DstAddr = DecodeIP(data[24:40])
-	proto = data[6]
 	sch.ProtobufAppendVarint(bf, schema.ColumnProto, uint64(proto))
 	if !sch.IsDisabled(schema.ColumnGroupL3L4) {
 		sch.ProtobufAppendVarint(bf, schema.ColumnIPTos,
@@ -64,11 +116,14 @@ func ParseIPv6(sch *schema.Component, bf *schema.FlowMessage, data []byte) uint6
 		sch.ProtobufAppendVarint(bf, schema.ColumnIPTTL, uint64(data[7]))
 		sch.ProtobufAppendVarint(bf, schema.ColumnIPv6FlowLabel,
 			uint64(binary.BigEndian.Uint32(data[0:4])&0xfffff))
-		// TODO fragmentID/fragmentOffset are in a separate header
+		if fragmented {
+			sch.ProtobufAppendVarint(bf, schema.ColumnIPFragmentID, uint64(fragID))
+			sch.ProtobufAppendVarint(bf, schema.ColumnIPFragmentOffset, uint64(fragoffset))
+		}
+	}
+	if fragoffset == 0 {
+		ParseL4(sch, bf, payload, proto)
 	}
-	data = data[40:]
-	sch.ProtobufAppendVarint(bf, schema.ColumnProto, uint64(proto))
-	ParseL4(sch, bf, data, proto)
 	return l3length
 }
 
@@ -87,8 +142,9 @@ func ParseL4(sch *schema.Component, bf *schema.FlowMessage, data []byte, proto u
 		if proto == 6 {
 			// TCP
 			if len(data) > 13 {
+				// Flags from this packet only, including NS
 				sch.ProtobufAppendVarint(bf, schema.ColumnTCPFlags,
-					uint64(data[13]))
+					uint64(binary.BigEndian.Uint16(data[12:14])&schema.TCPFlagsMask))
 			}
 		} else if proto == 1 {
 			// ICMPv4
@@ -110,8 +166,9 @@ func ParseL4(sch *schema.Component, bf *schema.FlowMessage, data []byte, proto u
 	}
 }
 
-// ParseEthernet parses an Ethernet packet and returns L3 length.
-func ParseEthernet(sch *schema.Component, bf *schema.FlowMessage, data []byte) uint64 {
+// ParseEthernet parses an Ethernet packet and returns L3 length. Tunnels
+// selected by decapsulate are decapsulated (see ParseIPv4).
+func ParseEthernet(sch *schema.Component, bf *schema.FlowMessage, data []byte, decapsulate Decapsulation) uint64 {
 	if len(data) < 14 {
 		return 0
 	}
@@ -123,47 +180,129 @@ func ParseEthernet(sch *schema.Component, bf *schema.FlowMessage, data []byte) u
 	}
 	etherType := data[12:14]
 	data = data[14:]
-	for etherType[0] == 0x81 && etherType[1] == 0x00 {
-		// 802.1q
+	var outerVlan uint16
+	tags := 0
+	for isVLANEtherType(binary.BigEndian.Uint16(etherType)) {
+		// 802.1q or 802.1ad. With several tags, the inner one is used as
+		// the VLAN and the outer one is kept separately.
 		if len(data) < 4 {
 			return 0
 		}
+		vlan := (uint16(data[0]&0xf) << 8) + uint16(data[1])
+		if tags == 0 {
+			outerVlan = vlan
+		}
+		tags++
 		if !sch.IsDisabled(schema.ColumnGroupL2) {
-			bf.SrcVlan = (uint16(data[0]&0xf) << 8) + uint16(data[1])
+			bf.SrcVlan = vlan
 		}
 		etherType = data[2:4]
 		data = data[4:]
 	}
-	if etherType[0] == 0x88 && etherType[1] == 0x47 {
-		// MPLS
-		for {
-			if len(data) < 5 {
-				return 0
-			}
-			label := binary.BigEndian.Uint32(append([]byte{0}, data[:3]...)) >> 4
-			bottom := data[2] & 1
-			data = data[4:]
-			sch.ProtobufAppendVarint(bf, schema.ColumnMPLSLabels, uint64(label))
-			if bottom == 1 || label <= 15 {
-				if data[0]&0xf0>>4 == 4 {
-					etherType = []byte{0x8, 0x0}
-				} else if data[0]&0xf0>>4 == 6 {
-					etherType = []byte{0x86, 0xdd}
-				} else {
-					return 0
-				}
-				break
-			}
+	if tags > 1 && !sch.IsDisabled(schema.ColumnGroupL2) {
+		sch.ProtobufAppendVarint(bf, schema.ColumnOuterVlan, uint64(outerVlan))
+	}
+	if etherType[0] == 0x88 && (etherType[1] == 0x47 || etherType[1] == 0x48) {
+		// MPLS (unicast or multicast)
+		data, etherType = parseMPLS(sch, bf, data)
+		if data == nil {
+			return 0
 		}
 	}
 	if etherType[0] == 0x8 && etherType[1] == 0x0 {
-		return ParseIPv4(sch, bf, data)
+		return ParseIPv4(sch, bf, data, decapsulate)
 	} else if etherType[0] == 0x86 && etherType[1] == 0xdd {
-		return ParseIPv6(sch, bf, data)
+		return ParseIPv6(sch, bf, data, decapsulate)
 	}
 	return 0
 }
 
+// mplsEntropyLabelIndicator is the reserved MPLS label announcing an entropy
+// label.
+const mplsEntropyLabelIndicator = 7
+
+// parseMPLS parses an MPLS label stack. It returns the payload after the
+// bottom of the stack and the guessed EtherType of this payload. The returned
+// payload is nil when it cannot be decoded.
+func parseMPLS(sch *schema.Component, bf *schema.FlowMessage, data []byte) ([]byte, []byte) {
+	entropyLabel := false
+	for {
+		if len(data) < 5 {
+			return nil, nil
+		}
+		label := binary.BigEndian.Uint32(append([]byte{0}, data[:3]...)) >> 4
+		bottom := data[2] & 1
+		data = data[4:]
+		// The entropy label indicator and the entropy label following it
+		// (RFC 6790) are not recorded: the entropy label is a hash
+		// computed per flow.
+		if entropyLabel {
+			entropyLabel = false
+		} else if label == mplsEntropyLabelIndicator {
+			entropyLabel = true
+		} else {
+			sch.ProtobufAppendVarint(bf, schema.ColumnMPLSLabels, uint64(label))
+		}
+		// Reserved labels (like the entropy label indicator) may
+		// appear anywhere in the stack: only stop at the bottom.
+		if bottom == 1 {
+			switch data[0] & 0xf0 >> 4 {
+			case 4:
+				return data, []byte{0x8, 0x0}
+			case 6:
+				return data, []byte{0x86, 0xdd}
+			}
+			return nil, nil
+		}
+	}
+}
+
+// ParsePPP parses a PPP frame (RFC 1661), optionally using the HDLC-like
+// framing (RFC 1662) used for Packet over SONET, and returns L3 length.
+func ParsePPP(sch *schema.Component, bf *schema.FlowMessage, data []byte, decapsulate Decapsulation) uint64 {
+	if len(data) >= 2 && data[0] == 0xff && data[1] == 0x03 {
+		// Address and control fields
+		data = data[2:]
+	}
+	if len(data) < 1 {
+		return 0
+	}
+	var protocol uint16
+	if data[0]&1 == 1 {
+		// Compressed protocol field
+		protocol = uint16(data[0])
+		data = data[1:]
+	} else {
+		if len(data) < 2 {
+			return 0
+		}
+		protocol = binary.BigEndian.Uint16(data[0:2])
+		data = data[2:]
+	}
+	switch protocol {
+	case 0x21: // IPv4
+		return ParseIPv4(sch, bf, data, decapsulate)
+	case 0x57: // IPv6
+		return ParseIPv6(sch, bf, data, decapsulate)
+	case 0x281, 0x283: // MPLS (unicast or multicast)
+		data, etherType := parseMPLS(sch, bf, data)
+		if data == nil {
+			return 0
+		}
+		if etherType[0] == 0x8 {
+			return ParseIPv4(sch, bf, data, decapsulate)
+		}
+		return ParseIPv6(sch, bf, data, decapsulate)
+	}
+	return 0
+}
+
+// isVLANEtherType tells if the provided EtherType is a VLAN tag (802.1q,
+// 802.1ad, or the pre-standard 0x9100 used for QinQ).
+func isVLANEtherType(etherType uint16) bool {
+	return etherType == 0x8100 || etherType == 0x88a8 || etherType == 0x9100
+}
+
 // DecodeIP decodes an IP address
 func DecodeIP(b []byte) netip.Addr {
 	if ip, ok := netip.AddrFromSlice(b); ok {
@@ -16,7 +16,7 @@ func TestDecodeMPLSAndIPv4(t *testing.T) {
 	sch := schema.NewMock(t).EnableAllColumns()
 	pcap := helpers.ReadPcapL2(t, filepath.Join("testdata", "mpls-ipv4.pcap"))
 	bf := &schema.FlowMessage{}
-	l := ParseEthernet(sch, bf, pcap)
+	l := ParseEthernet(sch, bf, pcap, 0)
 	if l != 40 {
 		t.Errorf("ParseEthernet() returned %d, expected 40", l)
 	}
@@ -42,11 +42,39 @@ func TestDecodeMPLSAndIPv4(t *testing.T) {
 	}
 }
 
+func TestDecodeMPLSWithEntropyLabel(t *testing.T) {
+	sch := schema.NewMock(t).EnableAllColumns()
+	pcap := helpers.ReadPcapL2(t, filepath.Join("testdata", "mpls-entropy-ipv4.pcap"))
+	bf := &schema.FlowMessage{}
+	l := ParseEthernet(sch, bf, pcap, 0)
+	if l != 28 {
+		t.Errorf("ParseEthernet() returned %d, expected 28", l)
+	}
+	expected := schema.FlowMessage{
+		SrcAddr: netip.MustParseAddr("::ffff:192.0.2.1"),
+		DstAddr: netip.MustParseAddr("::ffff:203.0.113.10"),
+		ProtobufDebug: map[schema.ColumnKey]interface{}{
+			schema.ColumnEType:        helpers.ETypeIPv4,
+			schema.ColumnProto:        17,
+			schema.ColumnSrcPort:      4000,
+			schema.ColumnDstPort:      53,
+			schema.ColumnMPLSLabels:   []uint64{100},
+			schema.ColumnIPTTL:        62,
+			schema.ColumnIPFragmentID: 0x1234,
+			schema.ColumnSrcMAC:       0x020000000002,
+			schema.ColumnDstMAC:       0x020000000001,
+		},
+	}
+	if diff := helpers.Diff(bf, expected); diff != "" {
+		t.Fatalf("ParseEthernet() (-got, +want):\n%s", diff)
+	}
+}
+
 func TestDecodeVLANAndIPv6(t *testing.T) {
 	sch := schema.NewMock(t).EnableAllColumns()
 	pcap := helpers.ReadPcapL2(t, filepath.Join("testdata", "vlan-ipv6.pcap"))
 	bf := &schema.FlowMessage{}
-	l := ParseEthernet(sch, bf, pcap)
+	l := ParseEthernet(sch, bf, pcap, 0)
 	if l != 179 {
 		t.Errorf("ParseEthernet() returned %d, expected 179", l)
 	}
@@ -66,3 +94,385 @@ func TestDecodeVLANAndIPv6(t *testing.T) {
 		t.Fatalf("ParseEthernet() (-got, +want):\n%s", diff)
 	}
 }
+
+func TestDecodeTunnels(t *testing.T) {
+	cases := []struct {
+		Description string
+		Pcap        string
+		Decapsulate Decapsulation
+		L3Length    uint64
+		Expected    schema.FlowMessage
+	}{
+		{
+			Description: "VXLAN without decapsulation",
+			Pcap:        "vxlan-ipv4.pcap",
+			Decapsulate: 0,
+			L3Length:    90,
+			Expected: schema.FlowMessage{
+				SrcAddr: netip.MustParseAddr("::ffff:192.0.2.1"),
+				DstAddr: netip.MustParseAddr("::ffff:192.0.2.2"),
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnEType:        helpers.ETypeIPv4,
+					schema.ColumnProto:        17,
+					schema.ColumnSrcPort:      54321,
+					schema.ColumnDstPort:      4789,
+					schema.ColumnIPTTL:        64,
+					schema.ColumnIPFragmentID: 0x1111,
+					schema.ColumnSrcMAC:       0x020000000002,
+					schema.ColumnDstMAC:       0x020000000001,
+				},
+			},
+		}, {
+			Description: "VXLAN with decapsulation",
+			Pcap:        "vxlan-ipv4.pcap",
+			Decapsulate: DecapsulateTunnels,
+			L3Length:    90,
+			Expected: schema.FlowMessage{
+				SrcAddr: netip.MustParseAddr("::ffff:10.0.0.1"),
+				DstAddr: netip.MustParseAddr("::ffff:10.0.0.2"),
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnEType:        helpers.ETypeIPv4,
+					schema.ColumnProto:        6,
+					schema.ColumnSrcPort:      34567,
+					schema.ColumnDstPort:      443,
+					schema.ColumnTCPFlags:     2,
+					schema.ColumnIPTTL:        63,
+					schema.ColumnIPFragmentID: 0x4242,
+					schema.ColumnSrcMAC:       0x020000000002,
+					schema.ColumnDstMAC:       0x020000000001,
+					schema.ColumnOuterSrcAddr: netip.MustParseAddr("::ffff:192.0.2.1"),
+					schema.ColumnOuterDstAddr: netip.MustParseAddr("::ffff:192.0.2.2"),
+				},
+			},
+		}, {
+			Description: "GRE over IPv6 with decapsulation",
+			Pcap:        "gre-ipv6.pcap",
+			Decapsulate: DecapsulateTunnels,
+			L3Length:    82,
+			Expected: schema.FlowMessage{
+				SrcAddr: netip.MustParseAddr("::ffff:10.1.0.1"),
+				DstAddr: netip.MustParseAddr("::ffff:10.1.0.2"),
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnEType:        helpers.ETypeIPv4,
+					schema.ColumnProto:        17,
+					schema.ColumnSrcPort:      4000,
+					schema.ColumnDstPort:      53,
+					schema.ColumnIPTTL:        60,
+					schema.ColumnIPFragmentID: 0x2222,
+					schema.ColumnSrcMAC:       0x020000000002,
+					schema.ColumnDstMAC:       0x020000000001,
+					schema.ColumnOuterSrcAddr: netip.MustParseAddr("2001:db8::1"),
+					schema.ColumnOuterDstAddr: netip.MustParseAddr("2001:db8::2"),
+				},
+			},
+		}, {
+			Description: "VXLAN with truncated inner packet",
+			Pcap:        "vxlan-truncated.pcap",
+			Decapsulate: DecapsulateTunnels,
+			L3Length:    56,
+			Expected: schema.FlowMessage{
+				SrcAddr: netip.MustParseAddr("::ffff:192.0.2.1"),
+				DstAddr: netip.MustParseAddr("::ffff:192.0.2.2"),
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnEType:        helpers.ETypeIPv4,
+					schema.ColumnProto:        17,
+					schema.ColumnSrcPort:      54321,
+					schema.ColumnDstPort:      4789,
+					schema.ColumnIPTTL:        64,
+					schema.ColumnIPFragmentID: 0x3333,
+					schema.ColumnSrcMAC:       0x020000000002,
+					schema.ColumnDstMAC:       0x020000000001,
+				},
+			},
+		}, {
+			Description: "VXLAN with GTP-U decapsulation only",
+			Pcap:        "vxlan-ipv4.pcap",
+			Decapsulate: DecapsulateGTP,
+			L3Length:    90,
+			Expected: schema.FlowMessage{
+				SrcAddr: netip.MustParseAddr("::ffff:192.0.2.1"),
+				DstAddr: netip.MustParseAddr("::ffff:192.0.2.2"),
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnEType:        helpers.ETypeIPv4,
+					schema.ColumnProto:        17,
+					schema.ColumnSrcPort:      54321,
+					schema.ColumnDstPort:      4789,
+					schema.ColumnIPTTL:        64,
+					schema.ColumnIPFragmentID: 0x1111,
+					schema.ColumnSrcMAC:       0x020000000002,
+					schema.ColumnDstMAC:       0x020000000001,
+				},
+			},
+		}, {
+			Description: "GTP-U without GTP-U decapsulation",
+			Pcap:        "gtp-ipv4.pcap",
+			Decapsulate: DecapsulateTunnels,
+			L3Length:    76,
+			Expected: schema.FlowMessage{
+				SrcAddr: netip.MustParseAddr("::ffff:192.0.2.1"),
+				DstAddr: netip.MustParseAddr("::ffff:192.0.2.2"),
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnEType:        helpers.ETypeIPv4,
+					schema.ColumnProto:        17,
+					schema.ColumnSrcPort:      2152,
+					schema.ColumnDstPort:      2152,
+					schema.ColumnIPTTL:        64,
+					schema.ColumnIPFragmentID: 0x4444,
+					schema.ColumnSrcMAC:       0x020000000002,
+					schema.ColumnDstMAC:       0x020000000001,
+				},
+			},
+		}, {
+			Description: "GTP-U with decapsulation",
+			Pcap:        "gtp-ipv4.pcap",
+			Decapsulate: DecapsulateGTP,
+			L3Length:    76,
+			Expected: schema.FlowMessage{
+				SrcAddr: netip.MustParseAddr("::ffff:10.10.0.1"),
+				DstAddr: netip.MustParseAddr("::ffff:10.20.0.2"),
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnEType:        helpers.ETypeIPv4,
+					schema.ColumnProto:        6,
+					schema.ColumnSrcPort:      40000,
+					schema.ColumnDstPort:      443,
+					schema.ColumnTCPFlags:     2,
+					schema.ColumnIPTTL:        63,
+					schema.ColumnIPFragmentID: 0x5555,
+					schema.ColumnSrcMAC:       0x020000000002,
+					schema.ColumnDstMAC:       0x020000000001,
+					schema.ColumnOuterSrcAddr: netip.MustParseAddr("::ffff:192.0.2.1"),
+					schema.ColumnOuterDstAddr: netip.MustParseAddr("::ffff:192.0.2.2"),
+					schema.ColumnGTPTEID:      0x12345678,
+				},
+			},
+		}, {
+			Description: "GTP-U with extension header and decapsulation",
+			Pcap:        "gtp-ext-ipv6.pcap",
+			Decapsulate: DecapsulateTunnels | DecapsulateGTP,
+			L3Length:    100,
+			Expected: schema.FlowMessage{
+				SrcAddr: netip.MustParseAddr("2001:db8:a::1"),
+				DstAddr: netip.MustParseAddr("2001:db8:b::2"),
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnEType:        helpers.ETypeIPv6,
+					schema.ColumnProto:        17,
+					schema.ColumnSrcPort:      5000,
+					schema.ColumnDstPort:      53,
+					schema.ColumnIPTTL:        62,
+					schema.ColumnSrcMAC:       0x020000000002,
+					schema.ColumnDstMAC:       0x020000000001,
+					schema.ColumnOuterSrcAddr: netip.MustParseAddr("::ffff:192.0.2.1"),
+					schema.ColumnOuterDstAddr: netip.MustParseAddr("::ffff:192.0.2.2"),
+					schema.ColumnGTPTEID:      0xcafe,
+				},
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			sch := schema.NewMock(t).EnableAllColumns()
+			pcap := helpers.ReadPcapL2(t, filepath.Join("testdata", tc.Pcap))
+			bf := &schema.FlowMessage{}
+			l := ParseEthernet(sch, bf, pcap, tc.Decapsulate)
+			if l != tc.L3Length {
+				t.Errorf("ParseEthernet() returned %d, expected %d", l, tc.L3Length)
+			}
+			if diff := helpers.Diff(bf, tc.Expected); diff != "" {
+				t.Fatalf("ParseEthernet() (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDecodeIPv6ExtensionHeaders(t *testing.T) {
+	cases := []struct {
+		Description string
+		Pcap        string
+		L3Length    uint64
+		Expected    map[schema.ColumnKey]interface{}
+	}{
+		{
+			Description: "hop-by-hop",
+			Pcap:        "ipv6-hop-by-hop.pcap",
+			L3Length:    68,
+			Expected: map[schema.ColumnKey]interface{}{
+				schema.ColumnProto:    6,
+				schema.ColumnSrcPort:  40000,
+				schema.ColumnDstPort:  80,
+				schema.ColumnTCPFlags: 2,
+			},
+		}, {
+			Description: "hop-by-hop, routing, and destination options",
+			Pcap:        "ipv6-extension-chain.pcap",
+			L3Length:    108,
+			Expected: map[schema.ColumnKey]interface{}{
+				schema.ColumnProto:    6,
+				schema.ColumnSrcPort:  40001,
+				schema.ColumnDstPort:  443,
+				schema.ColumnTCPFlags: 0x12,
+			},
+		}, {
+			Description: "first fragment",
+			Pcap:        "ipv6-fragment-first.pcap",
+			L3Length:    88,
+			Expected: map[schema.ColumnKey]interface{}{
+				schema.ColumnProto:        17,
+				schema.ColumnSrcPort:      5353,
+				schema.ColumnDstPort:      53,
+				schema.ColumnIPFragmentID: 0xdeadbeef,
+			},
+		}, {
+			Description: "next fragment",
+			Pcap:        "ipv6-fragment-next.pcap",
+			L3Length:    88,
+			Expected: map[schema.ColumnKey]interface{}{
+				schema.ColumnProto:            17,
+				schema.ColumnIPFragmentID:     0xdeadbeef,
+				schema.ColumnIPFragmentOffset: 185,
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			sch := schema.NewMock(t).EnableAllColumns()
+			pcap := helpers.ReadPcapL2(t, filepath.Join("testdata", tc.Pcap))
+			bf := &schema.FlowMessage{}
+			l := ParseEthernet(sch, bf, pcap, 0)
+			if l != tc.L3Length {
+				t.Errorf("ParseEthernet() returned %d, expected %d", l, tc.L3Length)
+			}
+			expected := schema.FlowMessage{
+				SrcAddr: netip.MustParseAddr("2001:db8::1"),
+				DstAddr: netip.MustParseAddr("2001:db8::2"),
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnEType:  helpers.ETypeIPv6,
+					schema.ColumnIPTTL:  64,
+					schema.ColumnSrcMAC: 0x020000000002,
+					schema.ColumnDstMAC: 0x020000000001,
+				},
+			}
+			for k, v := range tc.Expected {
+				expected.ProtobufDebug[k] = v
+			}
+			if diff := helpers.Diff(bf, expected); diff != "" {
+				t.Fatalf("ParseEthernet() (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDecodeVLANs(t *testing.T) {
+	cases := []struct {
+		Description string
+		Pcap        string
+		SrcVlan     uint16
+		OuterVlan   uint16
+	}{
+		{
+			Description: "untagged",
+			Pcap:        "untagged-ipv4.pcap",
+		}, {
+			Description: "802.1q",
+			Pcap:        "vlan-ipv4.pcap",
+			SrcVlan:     100,
+		}, {
+			Description: "802.1ad QinQ",
+			Pcap:        "qinq-8021ad-ipv4.pcap",
+			SrcVlan:     100,
+			OuterVlan:   300,
+		}, {
+			Description: "0x9100 QinQ",
+			Pcap:        "qinq-9100-ipv4.pcap",
+			SrcVlan:     100,
+			OuterVlan:   400,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			sch := schema.NewMock(t).EnableAllColumns()
+			pcap := helpers.ReadPcapL2(t, filepath.Join("testdata", tc.Pcap))
+			bf := &schema.FlowMessage{}
+			l := ParseEthernet(sch, bf, pcap, 0)
+			if l != 36 {
+				t.Errorf("ParseEthernet() returned %d, expected 36", l)
+			}
+			expected := schema.FlowMessage{
+				SrcVlan: tc.SrcVlan,
+				SrcAddr: netip.MustParseAddr("::ffff:192.0.2.1"),
+				DstAddr: netip.MustParseAddr("::ffff:198.51.100.1"),
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnEType:        helpers.ETypeIPv4,
+					schema.ColumnProto:        17,
+					schema.ColumnSrcPort:      5353,
+					schema.ColumnDstPort:      53,
+					schema.ColumnIPTTL:        63,
+					schema.ColumnIPFragmentID: 0x42,
+					schema.ColumnSrcMAC:       0x020000000002,
+					schema.ColumnDstMAC:       0x020000000001,
+				},
+			}
+			if tc.OuterVlan != 0 {
+				expected.ProtobufDebug[schema.ColumnOuterVlan] = uint64(tc.OuterVlan)
+			}
+			if diff := helpers.Diff(bf, expected); diff != "" {
+				t.Fatalf("ParseEthernet() (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseL4TCPFlags(t *testing.T) {
+	sch := schema.NewMock(t).EnableAllColumns()
+	// TCP header with data offset 5, NS, SYN and ECE set, reserved bits set
+	header := []byte{
+		0x30, 0x39, 0x00, 0x50, // ports
+		0x00, 0x00, 0x00, 0x01, // sequence number
+		0x00, 0x00, 0x00, 0x00, // acknowledgment number
+		0x5f, 0x42, 0xff, 0xff, // data offset, flags, window
+		0x00, 0x00, 0x00, 0x00, // checksum, urgent pointer
+	}
+	bf := &schema.FlowMessage{}
+	ParseL4(sch, bf, header, 6)
+	expected := schema.FlowMessage{
+		ProtobufDebug: map[schema.ColumnKey]interface{}{
+			schema.ColumnSrcPort:  12345,
+			schema.ColumnDstPort:  80,
+			schema.ColumnTCPFlags: 0x142,
+		},
+	}
+	if diff := helpers.Diff(bf, expected); diff != "" {
+		t.Fatalf("ParseL4() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestParsePPPWithMPLS(t *testing.T) {
+	sch := schema.NewMock(t).EnableAllColumns()
+	frame := []byte{
+		0x02, 0x81, // PPP protocol: MPLS unicast
+		0x00, 0x01, 0x21, 0x40, // label 18, bottom of stack
+		0x45, 0x00, 0x00, 0x1c, 0x00, 0x08, 0x00, 0x00, 0x40, 0x11, 0x00, 0x00,
+		192, 0, 2, 1, 203, 0, 113, 10, // IPv4 header
+		0x30, 0x39, 0x00, 0x35, 0x00, 0x08, 0x00, 0x00, // UDP header
+	}
+	bf := &schema.FlowMessage{}
+	l := ParsePPP(sch, bf, frame, 0)
+	if l != 28 {
+		t.Errorf("ParsePPP() returned %d, expected 28", l)
+	}
+	expected := schema.FlowMessage{
+		SrcAddr: netip.MustParseAddr("::ffff:192.0.2.1"),
+		DstAddr: netip.MustParseAddr("::ffff:203.0.113.10"),
+		ProtobufDebug: map[schema.ColumnKey]interface{}{
+			schema.ColumnEType:        helpers.ETypeIPv4,
+			schema.ColumnProto:        17,
+			schema.ColumnSrcPort:      12345,
+			schema.ColumnDstPort:      53,
+			schema.ColumnMPLSLabels:   []uint64{18},
+			schema.ColumnIPTTL:        64,
+			schema.ColumnIPFragmentID: 8,
+		},
+	}
+	if diff := helpers.Diff(bf, expected); diff != "" {
+		t.Fatalf("ParsePPP() (-got, +want):\n%s", diff)
+	}
+}
@@ -5,6 +5,7 @@
 package netflow
 
 import (
+	"bytes"
 	"encoding/binary"
 	"net/netip"
 
@@ -21,12 +22,15 @@ import (
 // values in the sub-range of 1-127 are compatible with field types used by
 // NetFlow version 9 [RFC3954]."
 
-func (nd *Decoder) decodeNFv5(packet *netflowlegacy.PacketNetFlowV5, ts, sysUptime uint64) []*schema.FlowMessage {
+func (nd *Decoder) decodeNFv5(packet *netflowlegacy.PacketNetFlowV5, tsSource decoder.TimestampSource, ts, sysUptime uint64) []*schema.FlowMessage {
 	flowMessageSet := []*schema.FlowMessage{}
+	// The first two bits of the sampling interval are the sampling mode. The
+	// remaining 14 bits hold the sampling interval.
+	samplingRate := uint32(packet.SamplingInterval & 0x3fff)
 
 	for _, record := range packet.Records {
 		bf := &schema.FlowMessage{
-			SamplingRate: uint32(packet.SamplingInterval),
+			SamplingRate: samplingRate,
 			InIf:         uint32(record.Input),
 			OutIf:        uint32(record.Output),
 			SrcAddr:      decodeIPFromUint32(uint32(record.SrcAddr)),
@@ -41,14 +45,23 @@ func (nd *Decoder) decodeNFv5(packet *netflowlegacy.PacketNetFlowV5, ts, sysUpti
 		nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnPackets, uint64(record.DPkts))
 		nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnEType, helpers.ETypeIPv4)
 		nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnProto, uint64(record.Proto))
-		nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnSrcPort, uint64(record.SrcPort))
-		nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnDstPort, uint64(record.DstPort))
+		if record.Proto != 1 {
+			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnSrcPort, uint64(record.SrcPort))
+			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnDstPort, uint64(record.DstPort))
+		}
 		if !nd.d.Schema.IsDisabled(schema.ColumnGroupL3L4) {
 			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnIPTos, uint64(record.Tos))
-			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnTCPFlags, uint64(record.TCPFlags))
+			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnTCPFlags, uint64(record.TCPFlags)&schema.TCPFlagsMask)
+			if record.Proto == 1 {
+				// ICMP type and code are encoded in the destination port
+				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnICMPv4Type, uint64(record.DstPort>>8))
+				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnICMPv4Code, uint64(record.DstPort&0xff))
+			}
 		}
-		if nd.useTsFromFirstSwitched {
-			bf.TimeReceived = ts - sysUptime + uint64(record.First)
+		if tsSource == decoder.TimestampSourceNetflowFirstSwitched {
+			bf.TimeReceived = decodeRelativeTimestamp(ts, sysUptime, uint64(record.First))
+		} else if tsSource == decoder.TimestampSourceNetflowLastSwitched {
+			bf.TimeReceived = decodeRelativeTimestamp(ts, sysUptime, uint64(record.Last))
 		}
 		if bf.SamplingRate == 0 {
 			bf.SamplingRate = 1
@@ -58,7 +71,7 @@ func (nd *Decoder) decodeNFv5(packet *netflowlegacy.PacketNetFlowV5, ts, sysUpti
 	return flowMessageSet
 }
 
-func (nd *Decoder) decodeNFv9IPFIX(version uint16, obsDomainID uint32, flowSets []interface{}, samplingRateSys *samplingRateSystem, ts, sysUptime uint64) []*schema.FlowMessage {
+func (nd *Decoder) decodeNFv9IPFIX(version uint16, obsDomainID uint32, flowSets []interface{}, samplingRateSys *samplingRateSystem, tsSource decoder.TimestampSource, ts, sysUptime uint64) []*schema.FlowMessage {
 	flowMessageSet := []*schema.FlowMessage{}
 
 	// Look for sampling rate in option data flowsets
@@ -69,34 +82,39 @@ func (nd *Decoder) decodeNFv9IPFIX(version uint16, obsDomainID uint32, flowSets
 				var (
 					samplingRate                uint32
 					samplerID                   uint64
+					templateID                  uint16
 					packetInterval, packetSpace uint32
 				)
-				for _, field := range record.OptionsValues {
-					v, ok := field.Value.([]byte)
-					if !ok || field.PenProvided {
-						continue
-					}
-					switch field.Type {
-					case netflow.IPFIX_FIELD_samplingInterval, netflow.IPFIX_FIELD_samplerRandomInterval:
-						samplingRate = uint32(decodeUNumber(v))
-					case netflow.IPFIX_FIELD_samplerId, netflow.IPFIX_FIELD_selectorId:
-						samplerID = uint64(decodeUNumber(v))
-					case netflow.IPFIX_FIELD_samplingPacketInterval:
-						packetInterval = uint32(decodeUNumber(v))
-					case netflow.IPFIX_FIELD_samplingPacketSpace:
-						packetSpace = uint32(decodeUNumber(v))
+				for _, fields := range [][]netflow.DataField{record.ScopesValues, record.OptionsValues} {
+					for _, field := range fields {
+						v, ok := field.Value.([]byte)
+						if !ok || field.PenProvided {
+							continue
+						}
+						switch field.Type {
+						case netflow.IPFIX_FIELD_samplingInterval, netflow.IPFIX_FIELD_samplerRandomInterval:
+							samplingRate = uint32(decodeUNumber(v))
+						case netflow.IPFIX_FIELD_samplerId, netflow.IPFIX_FIELD_selectorId:
+							samplerID = uint64(decodeUNumber(v))
+						case netflow.IPFIX_FIELD_templateId:
+							templateID = uint16(decodeUNumber(v))
+						case netflow.IPFIX_FIELD_samplingPacketInterval:
+							packetInterval = uint32(decodeUNumber(v))
+						case netflow.IPFIX_FIELD_samplingPacketSpace:
+							packetSpace = uint32(decodeUNumber(v))
+						}
 					}
 				}
 				if packetInterval > 0 {
 					samplingRate = (packetInterval + packetSpace) / packetInterval
 				}
 				if samplingRate > 0 {
-					samplingRateSys.SetSamplingRate(version, obsDomainID, samplerID, samplingRate)
+					samplingRateSys.SetSamplingRate(version, obsDomainID, samplerID, templateID, samplingRate)
 				}
 			}
 		case netflow.DataFlowSet:
 			for _, record := range tFlowSet.Records {
-				flow := nd.decodeRecord(version, obsDomainID, samplingRateSys, record.Values, ts, sysUptime)
+				flow := nd.decodeRecord(version, obsDomainID, tFlowSet.Id, samplingRateSys, record.Values, tsSource, ts, sysUptime)
 				if flow != nil {
 					flowMessageSet = append(flowMessageSet, flow)
 				}
@@ -107,15 +125,20 @@ func (nd *Decoder) decodeNFv9IPFIX(version uint16, obsDomainID uint32, flowSets
 	return flowMessageSet
 }
 
-func (nd *Decoder) decodeRecord(version uint16, obsDomainID uint32, samplingRateSys *samplingRateSystem, fields []netflow.DataField, ts, sysUptime uint64) *schema.FlowMessage {
+func (nd *Decoder) decodeRecord(version uint16, obsDomainID uint32, templateID uint16, samplingRateSys *samplingRateSystem, fields []netflow.DataField, tsSource decoder.TimestampSource, ts, sysUptime uint64) *schema.FlowMessage {
 	var etype, dstPort, srcPort uint16
 	var proto, icmpType, icmpCode uint8
 	var foundIcmpTypeCode bool
+	var timestamps flowTimestamps
 	bf := &schema.FlowMessage{}
 	dataLinkFrameSectionIdx := -1
 	for idx, field := range fields {
 		v, ok := field.Value.([]byte)
-		if !ok || field.PenProvided {
+		if !ok {
+			continue
+		}
+		if field.PenProvided {
+			nd.decodeCustomField(bf, field.Pen, field.Type, v)
 			continue
 		}
 
@@ -128,7 +151,13 @@ func (nd *Decoder) decodeRecord(version uint16, obsDomainID uint32, samplingRate
 		case netflow.IPFIX_FIELD_samplingInterval, netflow.IPFIX_FIELD_samplerRandomInterval:
 			bf.SamplingRate = uint32(decodeUNumber(v))
 		case netflow.IPFIX_FIELD_samplerId, netflow.IPFIX_FIELD_selectorId:
-			bf.SamplingRate = samplingRateSys.GetSamplingRate(version, obsDomainID, decodeUNumber(v))
+			// Sampling rate specific to the sampler and the template, then to
+			// the sampler
+			samplerID := decodeUNumber(v)
+			bf.SamplingRate = samplingRateSys.GetSamplingRate(version, obsDomainID, samplerID, templateID)
+			if bf.SamplingRate == 0 {
+				bf.SamplingRate = samplingRateSys.GetSamplingRate(version, obsDomainID, samplerID, 0)
+			}
 
 		// L3
 		case netflow.IPFIX_FIELD_sourceIPv4Address:
@@ -153,10 +182,8 @@ func (nd *Decoder) decodeRecord(version uint16, obsDomainID uint32, samplingRate
 		// L4
 		case netflow.IPFIX_FIELD_sourceTransportPort:
 			srcPort = uint16(decodeUNumber(v))
-			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnSrcPort, uint64(srcPort))
 		case netflow.IPFIX_FIELD_destinationTransportPort:
 			dstPort = uint16(decodeUNumber(v))
-			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnDstPort, uint64(dstPort))
 		case netflow.IPFIX_FIELD_protocolIdentifier:
 			proto = uint8(decodeUNumber(v))
 			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnProto, uint64(proto))
@@ -187,19 +214,8 @@ func (nd *Decoder) decodeRecord(version uint16, obsDomainID uint32, samplingRate
 		case netflow.IPFIX_FIELD_forwardingStatus:
 			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnForwardingStatus, decodeUNumber(v))
 		default:
-			if nd.useTsFromFirstSwitched {
-				switch field.Type {
-				case netflow.NFV9_FIELD_FIRST_SWITCHED:
-					bf.TimeReceived = ts - sysUptime + decodeUNumber(v)
-				case netflow.IPFIX_FIELD_flowStartSeconds:
-					bf.TimeReceived = decodeUNumber(v)
-				case netflow.IPFIX_FIELD_flowStartMilliseconds:
-					bf.TimeReceived = decodeUNumber(v) / 1000
-				case netflow.IPFIX_FIELD_flowStartMicroseconds:
-					bf.TimeReceived = decodeUNumber(v) / 1_000_000
-				case netflow.IPFIX_FIELD_flowStartNanoseconds:
-					bf.TimeReceived = ts + decodeUNumber(v)/1_000_000_000
-				}
+			if timestamps.collect(field.Type, v) {
+				continue
 			}
 
 			if !nd.d.Schema.IsDisabled(schema.ColumnGroupNAT) {
@@ -244,7 +260,7 @@ func (nd *Decoder) decodeRecord(version uint16, obsDomainID uint32, samplingRate
 				case netflow.IPFIX_FIELD_flowLabelIPv6:
 					nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnIPv6FlowLabel, decodeUNumber(v))
 				case netflow.IPFIX_FIELD_tcpControlBits:
-					nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnTCPFlags, decodeUNumber(v))
+					nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnTCPFlags, decodeUNumber(v)&schema.TCPFlagsMask)
 				case netflow.IPFIX_FIELD_fragmentIdentification:
 					nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnIPFragmentID, decodeUNumber(v))
 				case netflow.IPFIX_FIELD_fragmentOffset:
@@ -266,9 +282,17 @@ func (nd *Decoder) decodeRecord(version uint16, obsDomainID uint32, samplingRate
 			}
 		}
 	}
+	if tsSource == decoder.TimestampSourceNetflowFirstSwitched || tsSource == decoder.TimestampSourceNetflowLastSwitched {
+		bf.TimeReceived = timestamps.resolve(version, ts, sysUptime, tsSource == decoder.TimestampSourceNetflowLastSwitched)
+	}
+	if proto != 1 && proto != 58 {
+		// For ICMP, ports may encode type and code, they are handled below.
+		nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnSrcPort, uint64(srcPort))
+		nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnDstPort, uint64(dstPort))
+	}
 	if dataLinkFrameSectionIdx >= 0 {
 		data := fields[dataLinkFrameSectionIdx].Value.([]byte)
-		if l3Length := decoder.ParseEthernet(nd.d.Schema, bf, data); l3Length > 0 {
+		if l3Length := decoder.ParseEthernet(nd.d.Schema, bf, data, nd.decapsulate); l3Length > 0 {
 			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnBytes, l3Length)
 			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnPackets, 1)
 		}
@@ -286,7 +310,7 @@ func (nd *Decoder) decodeRecord(version uint16, obsDomainID uint32, samplingRate
 				icmpCode = uint8(dstPort & 0xff)
 			} else {
 				icmpType = uint8(srcPort)
-				icmpType = uint8(dstPort)
+				icmpCode = uint8(dstPort)
 			}
 		}
 		if proto == 1 {
@@ -299,7 +323,11 @@ func (nd *Decoder) decodeRecord(version uint16, obsDomainID uint32, samplingRate
 	}
 	nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnEType, uint64(etype))
 	if bf.SamplingRate == 0 {
-		bf.SamplingRate = samplingRateSys.GetSamplingRate(version, obsDomainID, 0)
+		// Sampling rate specific to the template, then to the observation domain
+		bf.SamplingRate = samplingRateSys.GetSamplingRate(version, obsDomainID, 0, templateID)
+		if bf.SamplingRate == 0 {
+			bf.SamplingRate = samplingRateSys.GetSamplingRate(version, obsDomainID, 0, 0)
+		}
 	}
 	return bf
 }
@@ -342,3 +370,20 @@ func decodeIPFromUint32(ipv4 uint32) netip.Addr {
 	binary.BigEndian.PutUint32(ipBytes[:], ipv4)
 	return netip.AddrFrom16(netip.AddrFrom4(ipBytes).As16())
 }
+
+// decodeCustomField decodes an enterprise-specific field if it is mapped to a
+// custom column.
+func (nd *Decoder) decodeCustomField(bf *schema.FlowMessage, pen uint32, id uint16, v []byte) {
+	field, ok := nd.customFields[customFieldKey{pen: pen, id: id & 0x7fff}]
+	if !ok {
+		return
+	}
+	switch field.fieldType {
+	case "uint":
+		nd.d.Schema.ProtobufAppendVarint(bf, field.column, decodeUNumber(v))
+	case "string":
+		nd.d.Schema.ProtobufAppendBytes(bf, field.column, bytes.TrimRight(v, "\x00"))
+	case "ip":
+		nd.d.Schema.ProtobufAppendIP(bf, field.column, decoder.DecodeIP(v))
+	}
+}
@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package netflow
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/netsampler/goflow2/v2/decoders/netflow"
+)
+
+// persistedTemplate is a template as saved by SaveState().
+type persistedTemplate struct {
+	Exporter    string
+	Version     uint16
+	ObsDomainID uint32
+	TemplateID  uint16
+	Template    interface{}
+	LastUpdate  time.Time
+}
+
+func init() {
+	gob.Register(netflow.TemplateRecord{})
+	gob.Register(netflow.NFv9OptionsTemplateRecord{})
+	gob.Register(netflow.IPFIXOptionsTemplateRecord{})
+}
+
+// SaveState writes the known templates and options templates.
+func (nd *Decoder) SaveState(w io.Writer) error {
+	nd.systemsLock.RLock()
+	systems := make([]*templateSystem, 0, len(nd.templates))
+	for _, s := range nd.templates {
+		if s.session != "" {
+			// Templates are not reused across sessions
+			continue
+		}
+		systems = append(systems, s)
+	}
+	nd.systemsLock.RUnlock()
+
+	templates := []persistedTemplate{}
+	for _, s := range systems {
+		s.updatesLock.Lock()
+		for k, u := range s.updates {
+			templates = append(templates, persistedTemplate{
+				Exporter:    s.key,
+				Version:     k.version,
+				ObsDomainID: k.obsDomainID,
+				TemplateID:  k.templateID,
+				Template:    u.template,
+				LastUpdate:  u.lastUpdate,
+			})
+		}
+		s.updatesLock.Unlock()
+	}
+	if err := gob.NewEncoder(w).Encode(templates); err != nil {
+		return fmt.Errorf("unable to encode templates: %w", err)
+	}
+	return nil
+}
+
+// LoadState restores templates and options templates saved with
+// SaveState(). Templates last updated before notBefore are ignored.
+func (nd *Decoder) LoadState(r io.Reader, notBefore time.Time) error {
+	var templates []persistedTemplate
+	if err := gob.NewDecoder(r).Decode(&templates); err != nil {
+		return fmt.Errorf("unable to decode templates: %w", err)
+	}
+	for _, t := range templates {
+		if t.LastUpdate.Before(notBefore) {
+			continue
+		}
+		s, _ := nd.systems(t.Exporter, "")
+		if err := s.templates.AddTemplate(t.Version, t.ObsDomainID, t.TemplateID, t.Template); err != nil {
+			continue
+		}
+		s.updatesLock.Lock()
+		s.updates[templateKey{t.Version, t.ObsDomainID, t.TemplateID}] = templateUpdate{
+			template:   t.Template,
+			lastUpdate: t.LastUpdate,
+		}
+		s.updatesLock.Unlock()
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"net"
 	"net/netip"
 	"strconv"
 	"sync"
@@ -32,27 +33,66 @@ type Decoder struct {
 	templates   map[string]*templateSystem
 	sampling    map[string]*samplingRateSystem
 
+	// Sequence numbers and loss windows
+	sequencesLock sync.Mutex
+	sequences     map[sequenceKey]*sequenceState
+	lossWindows   map[string]*lossWindow
+
 	metrics struct {
 		errors             *reporter.CounterVec
 		stats              *reporter.CounterVec
 		setRecordsStatsSum *reporter.CounterVec
 		setStatsSum        *reporter.CounterVec
 		templatesStats     *reporter.CounterVec
+		missingSampling    *reporter.CounterVec
+		sequenceMissed     *reporter.CounterVec
+		sequenceReordered  *reporter.CounterVec
+		sequenceResets     *reporter.CounterVec
+		sequenceLossRatio  *reporter.GaugeVec
+		timestampSkewed    *reporter.CounterVec
 	}
-	useTsFromNetflowsPacket bool
-	useTsFromFirstSwitched  bool
+	timestampSource  decoder.TimestampSource
+	timestampMaxSkew uint64
+	decapsulate      decoder.Decapsulation
+	customFields     map[customFieldKey]customField
+}
+
+// customFieldKey identifies an enterprise-specific IPFIX field.
+type customFieldKey struct {
+	pen uint32
+	id  uint16
+}
+
+// customField is the column an enterprise-specific IPFIX field is mapped to.
+type customField struct {
+	column    schema.ColumnKey
+	fieldType string
 }
 
 // New instantiates a new netflow decoder.
 func New(r *reporter.Reporter, dependencies decoder.Dependencies, option decoder.Option) decoder.Decoder {
 	nd := &Decoder{
-		r:                       r,
-		d:                       dependencies,
-		errLogger:               r.Sample(reporter.BurstSampler(30*time.Second, 3)),
-		templates:               map[string]*templateSystem{},
-		sampling:                map[string]*samplingRateSystem{},
-		useTsFromNetflowsPacket: option.TimestampSource == decoder.TimestampSourceNetflowPacket,
-		useTsFromFirstSwitched:  option.TimestampSource == decoder.TimestampSourceNetflowFirstSwitched,
+		r:                r,
+		d:                dependencies,
+		errLogger:        r.Sample(reporter.BurstSampler(30*time.Second, 3)),
+		templates:        map[string]*templateSystem{},
+		sampling:         map[string]*samplingRateSystem{},
+		sequences:        map[sequenceKey]*sequenceState{},
+		lossWindows:      map[string]*lossWindow{},
+		timestampSource:  option.TimestampSource,
+		timestampMaxSkew: uint64(option.TimestampMaxSkew.Seconds()),
+		decapsulate:      option.Decapsulation(),
+		customFields:     map[customFieldKey]customField{},
+	}
+	for _, field := range option.IPFIXCustomFields {
+		column, ok := dependencies.Schema.LookupColumnByName(field.Name)
+		if !ok {
+			continue
+		}
+		nd.customFields[customFieldKey{pen: field.PEN, id: field.ID}] = customField{
+			column:    column.Key,
+			fieldType: field.Type,
+		}
 	}
 
 	nd.metrics.errors = nd.r.CounterVec(
@@ -90,6 +130,48 @@ func New(r *reporter.Reporter, dependencies decoder.Dependencies, option decoder
 		},
 		[]string{"exporter", "version", "obs_domain_id", "template_id", "type"},
 	)
+	nd.metrics.missingSampling = nd.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "missing_sampling_rate_total",
+			Help: "Netflows without a known sampling rate.",
+		},
+		[]string{"exporter", "version"},
+	)
+	nd.metrics.sequenceMissed = nd.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "sequence_missed_total",
+			Help: "Netflows missed packets (v9) or records (IPFIX) detected from sequence numbers.",
+		},
+		[]string{"exporter", "version", "obs_domain_id"},
+	)
+	nd.metrics.sequenceReordered = nd.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "sequence_reordered_total",
+			Help: "Netflows packets received out of order.",
+		},
+		[]string{"exporter", "version", "obs_domain_id"},
+	)
+	nd.metrics.sequenceResets = nd.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "sequence_resets_total",
+			Help: "Netflows sequence number resets (exporter restarts).",
+		},
+		[]string{"exporter", "version", "obs_domain_id"},
+	)
+	nd.metrics.sequenceLossRatio = nd.r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "sequence_loss_ratio",
+			Help: "Netflows estimated loss ratio over the last 5 minutes.",
+		},
+		[]string{"exporter"},
+	)
+	nd.metrics.timestampSkewed = nd.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "timestamp_skewed_total",
+			Help: "Netflows with a timestamp too far from the receive time.",
+		},
+		[]string{"exporter", "version"},
+	)
 
 	return nd
 }
@@ -97,13 +179,35 @@ func New(r *reporter.Reporter, dependencies decoder.Dependencies, option decoder
 type templateSystem struct {
 	nd        *Decoder
 	key       string
+	session   string
 	templates netflow.NetFlowTemplateSystem
+
+	// Copy of the templates with their last update, for persistence
+	updatesLock sync.Mutex
+	updates     map[templateKey]templateUpdate
+}
+
+type templateKey struct {
+	version     uint16
+	obsDomainID uint32
+	templateID  uint16
+}
+
+type templateUpdate struct {
+	template   interface{}
+	lastUpdate time.Time
 }
 
 func (s *templateSystem) AddTemplate(version uint16, obsDomainID uint32, templateID uint16, template interface{}) error {
 	if err := s.templates.AddTemplate(version, obsDomainID, templateID, template); err != nil {
 		return nil
 	}
+	s.updatesLock.Lock()
+	s.updates[templateKey{version, obsDomainID, templateID}] = templateUpdate{
+		template:   template,
+		lastUpdate: time.Now(),
+	}
+	s.updatesLock.Unlock()
 
 	var typeStr string
 	switch templateIDConv := template.(type) {
@@ -133,6 +237,9 @@ func (s *templateSystem) GetTemplate(version uint16, obsDomainID uint32, templat
 }
 
 func (s *templateSystem) RemoveTemplate(version uint16, obsDomainID uint32, templateID uint16) (interface{}, error) {
+	s.updatesLock.Lock()
+	delete(s.updates, templateKey{version, obsDomainID, templateID})
+	s.updatesLock.Unlock()
 	return s.templates.RemoveTemplate(version, obsDomainID, templateID)
 }
 
@@ -140,6 +247,7 @@ type samplingRateKey struct {
 	version     uint16
 	obsDomainID uint32
 	samplerID   uint64
+	templateID  uint16
 }
 
 type samplingRateSystem struct {
@@ -147,55 +255,90 @@ type samplingRateSystem struct {
 	rates map[samplingRateKey]uint32
 }
 
-func (s *samplingRateSystem) GetSamplingRate(version uint16, obsDomainID uint32, samplerID uint64) uint32 {
+func (s *samplingRateSystem) GetSamplingRate(version uint16, obsDomainID uint32, samplerID uint64, templateID uint16) uint32 {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
 	rate, _ := s.rates[samplingRateKey{
 		version:     version,
 		obsDomainID: obsDomainID,
 		samplerID:   samplerID,
+		templateID:  templateID,
 	}]
 	return rate
 }
 
-func (s *samplingRateSystem) SetSamplingRate(version uint16, obsDomainID uint32, samplerID uint64, samplingRate uint32) {
+func (s *samplingRateSystem) SetSamplingRate(version uint16, obsDomainID uint32, samplerID uint64, templateID uint16, samplingRate uint32) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	s.rates[samplingRateKey{
 		version:     version,
 		obsDomainID: obsDomainID,
 		samplerID:   samplerID,
+		templateID:  templateID,
 	}] = samplingRate
 }
 
-// Decode decodes a Netflow payload.
-func (nd *Decoder) Decode(in decoder.RawFlow) []*schema.FlowMessage {
-	if len(in.Payload) < 2 {
-		return nil
+// systemsKey returns the key to use for the template system and the sampling
+// rate system of an exporter. Each session gets its own systems.
+func systemsKey(key, session string) string {
+	if session == "" {
+		return key
 	}
-	key := in.Source.String()
+	return key + "/" + session
+}
+
+// systems returns the template system and the sampling rate system for the
+// provided exporter and session. They are created if they do not exist yet.
+func (nd *Decoder) systems(key, session string) (*templateSystem, *samplingRateSystem) {
+	skey := systemsKey(key, session)
 	nd.systemsLock.RLock()
-	templates, tok := nd.templates[key]
-	sampling, sok := nd.sampling[key]
+	templates, tok := nd.templates[skey]
+	sampling, sok := nd.sampling[skey]
 	nd.systemsLock.RUnlock()
+	if tok && sok {
+		return templates, sampling
+	}
+
+	nd.systemsLock.Lock()
+	defer nd.systemsLock.Unlock()
+	templates, tok = nd.templates[skey]
 	if !tok {
 		templates = &templateSystem{
 			nd:        nd,
 			templates: netflow.CreateTemplateSystem(),
 			key:       key,
+			session:   session,
+			updates:   map[templateKey]templateUpdate{},
 		}
-		nd.systemsLock.Lock()
-		nd.templates[key] = templates
-		nd.systemsLock.Unlock()
+		nd.templates[skey] = templates
 	}
+	sampling, sok = nd.sampling[skey]
 	if !sok {
 		sampling = &samplingRateSystem{
 			rates: map[samplingRateKey]uint32{},
 		}
-		nd.systemsLock.Lock()
-		nd.sampling[key] = sampling
-		nd.systemsLock.Unlock()
+		nd.sampling[skey] = sampling
+	}
+	return templates, sampling
+}
+
+// CloseSession forgets the templates and the sampling rates received during
+// the provided session.
+func (nd *Decoder) CloseSession(source net.IP, session string) {
+	skey := systemsKey(source.String(), session)
+	nd.systemsLock.Lock()
+	defer nd.systemsLock.Unlock()
+	delete(nd.templates, skey)
+	delete(nd.sampling, skey)
+}
+
+// Decode decodes a Netflow payload.
+func (nd *Decoder) Decode(in decoder.RawFlow) []*schema.FlowMessage {
+	if len(in.Payload) < 2 {
+		return nil
 	}
+	key := in.Source.String()
+	templates, sampling := nd.systems(key, in.Session)
 
 	var (
 		sysUptime      uint64
@@ -206,7 +349,12 @@ func (nd *Decoder) Decode(in decoder.RawFlow) []*schema.FlowMessage {
 	)
 	version := binary.BigEndian.Uint16(in.Payload[:2])
 	buf := bytes.NewBuffer(in.Payload[2:])
-	ts := uint64(in.TimeReceived.UTC().Unix())
+	receivedTs := uint64(in.TimeReceived.UTC().Unix())
+	ts := receivedTs
+	tsSource := nd.timestampSource
+	if in.TimestampSource != nil {
+		tsSource = *in.TimestampSource
+	}
 
 	switch version {
 	case 5:
@@ -220,18 +368,22 @@ func (nd *Decoder) Decode(in decoder.RawFlow) []*schema.FlowMessage {
 		nd.metrics.setStatsSum.WithLabelValues(key, versionStr, "PDU").Inc()
 		nd.metrics.setRecordsStatsSum.WithLabelValues(key, versionStr, "PDU").
 			Add(float64(len(packetNFv5.Records)))
-		if nd.useTsFromNetflowsPacket || nd.useTsFromFirstSwitched {
+		if tsSource != decoder.TimestampSourceUDP {
 			ts = uint64(packetNFv5.UnixSecs)
 			sysUptime = uint64(packetNFv5.SysUptime)
 		}
-		flowMessageSet = nd.decodeNFv5(&packetNFv5, ts, sysUptime)
+		flowMessageSet = nd.decodeNFv5(&packetNFv5, tsSource, ts, sysUptime)
 	case 9:
 		var packetNFv9 netflow.NFv9Packet
 		if err := netflow.DecodeMessageNetFlow(buf, templates, &packetNFv9); err != nil {
-			nd.metrics.errors.WithLabelValues(key, "NetFlow v9 decoding error").Inc()
+			if len(in.Payload) >= 20 {
+				nd.forgetSequence(key, version, binary.BigEndian.Uint32(in.Payload[16:20]))
+			}
 			if !errors.Is(err, netflow.ErrorTemplateNotFound) {
+				nd.metrics.errors.WithLabelValues(key, "NetFlow v9 decoding error").Inc()
 				nd.errLogger.Err(err).Str("exporter", key).Msg("error while decoding NetFlow v9")
 			} else {
+				nd.metrics.errors.WithLabelValues(key, "NetFlow v9 template not found").Inc()
 				nd.errLogger.Debug().Str("exporter", key).Msg("template not received yet")
 			}
 			return nil
@@ -239,18 +391,24 @@ func (nd *Decoder) Decode(in decoder.RawFlow) []*schema.FlowMessage {
 		versionStr = "9"
 		flowSets = packetNFv9.FlowSets
 		obsDomainID = packetNFv9.SourceId
-		if nd.useTsFromNetflowsPacket || nd.useTsFromFirstSwitched {
+		nd.checkSequence(key, version, obsDomainID, packetNFv9.SequenceNumber, 1,
+			packetNFv9.UnixSeconds-packetNFv9.SystemUptime/1000)
+		if tsSource != decoder.TimestampSourceUDP {
 			ts = uint64(packetNFv9.UnixSeconds)
 			sysUptime = uint64(packetNFv9.SystemUptime)
 		}
-		flowMessageSet = nd.decodeNFv9IPFIX(version, obsDomainID, flowSets, sampling, ts, sysUptime)
+		flowMessageSet = nd.decodeNFv9IPFIX(version, obsDomainID, flowSets, sampling, tsSource, ts, sysUptime)
 	case 10:
 		var packetIPFIX netflow.IPFIXPacket
 		if err := netflow.DecodeMessageIPFIX(buf, templates, &packetIPFIX); err != nil {
-			nd.metrics.errors.WithLabelValues(key, "IPFIX decoding error").Inc()
+			if len(in.Payload) >= 16 {
+				nd.forgetSequence(key, version, binary.BigEndian.Uint32(in.Payload[12:16]))
+			}
 			if !errors.Is(err, netflow.ErrorTemplateNotFound) {
+				nd.metrics.errors.WithLabelValues(key, "IPFIX decoding error").Inc()
 				nd.errLogger.Err(err).Str("exporter", key).Msg("error while decoding IPFIX")
 			} else {
+				nd.metrics.errors.WithLabelValues(key, "IPFIX template not found").Inc()
 				nd.errLogger.Debug().Str("exporter", key).Msg("template not received yet")
 			}
 			return nil
@@ -258,10 +416,11 @@ func (nd *Decoder) Decode(in decoder.RawFlow) []*schema.FlowMessage {
 		versionStr = "10"
 		flowSets = packetIPFIX.FlowSets
 		obsDomainID = packetIPFIX.ObservationDomainId
-		if nd.useTsFromNetflowsPacket {
+		nd.checkSequence(key, version, obsDomainID, packetIPFIX.SequenceNumber, countDataRecords(flowSets), 0)
+		if tsSource == decoder.TimestampSourceNetflowPacket {
 			ts = uint64(packetIPFIX.ExportTime)
 		}
-		flowMessageSet = nd.decodeNFv9IPFIX(version, obsDomainID, flowSets, sampling, ts, sysUptime)
+		flowMessageSet = nd.decodeNFv9IPFIX(version, obsDomainID, flowSets, sampling, tsSource, ts, sysUptime)
 	default:
 		nd.metrics.stats.WithLabelValues(key, "unknown").
 			Inc()
@@ -300,12 +459,29 @@ func (nd *Decoder) Decode(in decoder.RawFlow) []*schema.FlowMessage {
 	}
 
 	exporterAddress, _ := netip.AddrFromSlice(in.Source.To16())
+	missingSampling := 0
+	skewed := 0
 	for _, fmsg := range flowMessageSet {
 		if fmsg.TimeReceived == 0 {
 			fmsg.TimeReceived = ts
 		}
+		if nd.timestampMaxSkew > 0 &&
+			(fmsg.TimeReceived > receivedTs+nd.timestampMaxSkew ||
+				fmsg.TimeReceived+nd.timestampMaxSkew < receivedTs) {
+			fmsg.TimeReceived = receivedTs
+			skewed++
+		}
+		if fmsg.SamplingRate == 0 {
+			missingSampling++
+		}
 		fmsg.ExporterAddress = exporterAddress
 	}
+	if missingSampling > 0 {
+		nd.metrics.missingSampling.WithLabelValues(key, versionStr).Add(float64(missingSampling))
+	}
+	if skewed > 0 {
+		nd.metrics.timestampSkewed.WithLabelValues(key, versionStr).Add(float64(skewed))
+	}
 
 	return flowMessageSet
 }
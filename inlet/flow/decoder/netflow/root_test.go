@@ -4,11 +4,14 @@
 package netflow
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
 	"net"
 	"net/netip"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"akvorado/common/helpers"
 	"akvorado/common/reporter"
@@ -31,7 +34,7 @@ func TestDecode(t *testing.T) {
 	}
 
 	// Check metrics
-	gotMetrics := r.GetMetrics("akvorado_inlet_flow_decoder_netflow_")
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_decoder_netflow_", "-sequence_")
 	expectedMetrics := map[string]string{
 		`flows_total{exporter="127.0.0.1",version="9"}`:                                                                 "1",
 		`flowset_records_sum{exporter="127.0.0.1",type="OptionsTemplateFlowSet",version="9"}`:                           "1",
@@ -53,7 +56,7 @@ func TestDecode(t *testing.T) {
 	}
 
 	// Check metrics
-	gotMetrics = r.GetMetrics("akvorado_inlet_flow_decoder_netflow_")
+	gotMetrics = r.GetMetrics("akvorado_inlet_flow_decoder_netflow_", "-sequence_")
 	expectedMetrics = map[string]string{
 		`flows_total{exporter="127.0.0.1",version="9"}`:                                                                 "2",
 		`flowset_records_sum{exporter="127.0.0.1",type="OptionsTemplateFlowSet",version="9"}`:                           "1",
@@ -77,7 +80,7 @@ func TestDecode(t *testing.T) {
 	}
 
 	// Check metrics
-	gotMetrics = r.GetMetrics("akvorado_inlet_flow_decoder_netflow_")
+	gotMetrics = r.GetMetrics("akvorado_inlet_flow_decoder_netflow_", "-sequence_")
 	expectedMetrics = map[string]string{
 		`flows_total{exporter="127.0.0.1",version="9"}`:                                                                 "3",
 		`flowset_records_sum{exporter="127.0.0.1",type="OptionsTemplateFlowSet",version="9"}`:                           "1",
@@ -346,6 +349,106 @@ func TestDecodeMultipleSamplingRates(t *testing.T) {
 	}
 }
 
+func TestDecodeSamplingRatePerTemplate(t *testing.T) {
+	r := reporter.NewMock(t)
+	nfdecoder := New(r, decoder.Dependencies{Schema: schema.NewMock(t)}, decoder.Option{TimestampSource: decoder.TimestampSourceUDP})
+
+	// The options data record sets a sampling rate for template 256 only.
+	// Flows using template 257 do not have a sampling rate.
+	data := helpers.ReadPcapL4(t, filepath.Join("testdata", "ipfix-samplingrate-per-template.pcap"))
+	got := nfdecoder.Decode(decoder.RawFlow{Payload: data, Source: net.ParseIP("127.0.0.1")})
+	for _, f := range got {
+		f.TimeReceived = 0
+	}
+
+	expectedFlows := []*schema.FlowMessage{
+		{
+			ExporterAddress: netip.MustParseAddr("::ffff:127.0.0.1"),
+			SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.1"),
+			DstAddr:         netip.MustParseAddr("::ffff:203.0.113.10"),
+			SamplingRate:    1000,
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:   1500,
+				schema.ColumnPackets: 2,
+				schema.ColumnEType:   helpers.ETypeIPv4,
+			},
+		}, {
+			ExporterAddress: netip.MustParseAddr("::ffff:127.0.0.1"),
+			SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.2"),
+			DstAddr:         netip.MustParseAddr("::ffff:203.0.113.11"),
+			SamplingRate:    0,
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:   500,
+				schema.ColumnPackets: 1,
+				schema.ColumnEType:   helpers.ETypeIPv4,
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expectedFlows); diff != "" {
+		t.Fatalf("Decode() (-got, +want):\n%s", diff)
+	}
+
+	gotMetrics := r.GetMetrics(
+		"akvorado_inlet_flow_decoder_netflow_",
+		"missing_sampling_rate_",
+	)
+	expectedMetrics := map[string]string{
+		`missing_sampling_rate_total{exporter="127.0.0.1",version="10"}`: "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestDecodeSamplingRatePerSamplerAndTemplate(t *testing.T) {
+	r := reporter.NewMock(t)
+	nfdecoder := New(r, decoder.Dependencies{Schema: schema.NewMock(t)}, decoder.Option{TimestampSource: decoder.TimestampSourceUDP})
+
+	// The options data records set a sampling rate for selector 5 and
+	// template 256, and for selector 5 alone. Flows using template 256 use the
+	// first one, flows using template 257 the second one.
+	data := helpers.ReadPcapL4(t, filepath.Join("testdata", "ipfix-samplingrate-sampler-template.pcap"))
+	got := nfdecoder.Decode(decoder.RawFlow{Payload: data, Source: net.ParseIP("127.0.0.1")})
+	for _, f := range got {
+		f.TimeReceived = 0
+	}
+
+	expectedFlows := []*schema.FlowMessage{
+		{
+			ExporterAddress: netip.MustParseAddr("::ffff:127.0.0.1"),
+			SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.1"),
+			DstAddr:         netip.MustParseAddr("::ffff:203.0.113.10"),
+			SamplingRate:    100,
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:   1500,
+				schema.ColumnPackets: 2,
+				schema.ColumnEType:   helpers.ETypeIPv4,
+			},
+		}, {
+			ExporterAddress: netip.MustParseAddr("::ffff:127.0.0.1"),
+			SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.2"),
+			DstAddr:         netip.MustParseAddr("::ffff:203.0.113.11"),
+			SamplingRate:    50,
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:   500,
+				schema.ColumnPackets: 1,
+				schema.ColumnEType:   helpers.ETypeIPv4,
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expectedFlows); diff != "" {
+		t.Fatalf("Decode() (-got, +want):\n%s", diff)
+	}
+
+	gotMetrics := r.GetMetrics(
+		"akvorado_inlet_flow_decoder_netflow_",
+		"missing_sampling_rate_",
+	)
+	if diff := helpers.Diff(gotMetrics, map[string]string{}); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
 func TestDecodeICMP(t *testing.T) {
 	r := reporter.NewMock(t)
 	nfdecoder := New(r, decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()}, decoder.Option{TimestampSource: decoder.TimestampSourceUDP})
@@ -362,7 +465,6 @@ func TestDecodeICMP(t *testing.T) {
 			DstAddr:         netip.MustParseAddr("2001:db8::1"),
 			ProtobufDebug: map[schema.ColumnKey]interface{}{
 				schema.ColumnBytes:      104,
-				schema.ColumnDstPort:    32768,
 				schema.ColumnEType:      34525,
 				schema.ColumnICMPv6Type: 128, // Code: 0
 				schema.ColumnPackets:    1,
@@ -375,7 +477,6 @@ func TestDecodeICMP(t *testing.T) {
 			DstAddr:         netip.MustParseAddr("2001:db8::"),
 			ProtobufDebug: map[schema.ColumnKey]interface{}{
 				schema.ColumnBytes:      104,
-				schema.ColumnDstPort:    33024,
 				schema.ColumnEType:      34525,
 				schema.ColumnICMPv6Type: 129, // Code: 0
 				schema.ColumnPackets:    1,
@@ -388,7 +489,6 @@ func TestDecodeICMP(t *testing.T) {
 			DstAddr:         netip.MustParseAddr("::ffff:203.0.113.5"),
 			ProtobufDebug: map[schema.ColumnKey]interface{}{
 				schema.ColumnBytes:      84,
-				schema.ColumnDstPort:    2048,
 				schema.ColumnEType:      2048,
 				schema.ColumnICMPv4Type: 8, // Code: 0
 				schema.ColumnPackets:    1,
@@ -458,6 +558,70 @@ func TestDecodeDataLink(t *testing.T) {
 	}
 }
 
+func TestCheckSequence(t *testing.T) {
+	r := reporter.NewMock(t)
+	nd := New(r, decoder.Dependencies{Schema: schema.NewMock(t)}, decoder.Option{}).(*Decoder)
+
+	// NetFlow v9: each packet increments the sequence number. We have a gap,
+	// a reordered packet partly filling it, an exporter restart (the
+	// remaining gap is counted as missed), and a wraparound (the new gap is
+	// still pending).
+	for _, sequence := range []uint32{100, 101, 105, 104, 106, 5_000_000, 0xffffffff, 1} {
+		nd.checkSequence("127.0.0.1", 9, 1, sequence, 1, 0)
+	}
+	// IPFIX: the sequence number is incremented by the number of data
+	// records. We have a gap, a reordered packet partly filling it, and a
+	// larger gap pushing the first one out of the reorder window.
+	nd.checkSequence("127.0.0.1", 10, 5, 1000, 10, 0)
+	nd.checkSequence("127.0.0.1", 10, 5, 1010, 20, 0)
+	nd.checkSequence("127.0.0.1", 10, 5, 1050, 5, 0)
+	nd.checkSequence("127.0.0.1", 10, 5, 1040, 10, 0)
+	nd.checkSequence("127.0.0.1", 10, 5, 3000, 5, 0)
+	// Forgotten sequence: no loss detected.
+	nd.forgetSequence("127.0.0.1", 10, 5)
+	nd.checkSequence("127.0.0.1", 10, 5, 2000, 0, 0)
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_decoder_netflow_", "sequence_")
+	expectedMetrics := map[string]string{
+		`sequence_missed_total{exporter="127.0.0.1",obs_domain_id="1",version="9"}`:     "2",
+		`sequence_missed_total{exporter="127.0.0.1",obs_domain_id="5",version="10"}`:    "10",
+		`sequence_reordered_total{exporter="127.0.0.1",obs_domain_id="1",version="9"}`:  "1",
+		`sequence_reordered_total{exporter="127.0.0.1",obs_domain_id="5",version="10"}`: "1",
+		`sequence_resets_total{exporter="127.0.0.1",obs_domain_id="1",version="9"}`:     "2",
+		`sequence_loss_ratio{exporter="127.0.0.1"}`:                                     "0.17142857142857143",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestCheckSequenceRestart(t *testing.T) {
+	r := reporter.NewMock(t)
+	nd := New(r, decoder.Dependencies{Schema: schema.NewMock(t)}, decoder.Option{}).(*Decoder)
+
+	// Exporter restarting with a low sequence number: this is not a
+	// reordered packet as it does not fill a gap.
+	for _, sequence := range []uint32{500, 501, 502, 0, 1} {
+		nd.checkSequence("127.0.0.1", 10, 1, sequence, 1, 0)
+	}
+	// Exporter restarting with a close sequence number: the boot time has
+	// changed, this is not a gap. A small drift of the boot time is ignored.
+	nd.checkSequence("127.0.0.1", 9, 1, 10, 1, 1_700_000_000)
+	nd.checkSequence("127.0.0.1", 9, 1, 11, 1, 1_700_000_001)
+	nd.checkSequence("127.0.0.1", 9, 1, 50, 1, 1_700_000_600)
+	nd.checkSequence("127.0.0.1", 9, 1, 51, 1, 1_700_000_599)
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_decoder_netflow_", "sequence_")
+	expectedMetrics := map[string]string{
+		`sequence_resets_total{exporter="127.0.0.1",obs_domain_id="1",version="10"}`: "1",
+		`sequence_resets_total{exporter="127.0.0.1",obs_domain_id="1",version="9"}`:  "1",
+		`sequence_loss_ratio{exporter="127.0.0.1"}`:                                  "0",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
 func TestDecodeWithoutTemplate(t *testing.T) {
 	r := reporter.NewMock(t)
 	nfdecoder := New(r,
@@ -472,6 +636,34 @@ func TestDecodeWithoutTemplate(t *testing.T) {
 	}
 }
 
+func TestDecodeWithSessions(t *testing.T) {
+	r := reporter.NewMock(t)
+	nfdecoder := New(r,
+		decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()},
+		decoder.Option{TimestampSource: decoder.TimestampSourceUDP})
+	source := net.ParseIP("127.0.0.1")
+	template := helpers.ReadPcapL4(t, filepath.Join("testdata", "datalink-template.pcap"))
+	data := helpers.ReadPcapL4(t, filepath.Join("testdata", "datalink-data.pcap"))
+	nfdecoder.Decode(decoder.RawFlow{Payload: template, Source: source, Session: "127.0.0.1:1000"})
+
+	// Templates are not shared between sessions
+	if got := nfdecoder.Decode(decoder.RawFlow{Payload: data, Source: source}); len(got) != 0 {
+		t.Fatalf("Decode() without session returned %d flows", len(got))
+	}
+	if got := nfdecoder.Decode(decoder.RawFlow{Payload: data, Source: source, Session: "127.0.0.1:1001"}); len(got) != 0 {
+		t.Fatalf("Decode() from another session returned %d flows", len(got))
+	}
+	if got := nfdecoder.Decode(decoder.RawFlow{Payload: data, Source: source, Session: "127.0.0.1:1000"}); len(got) == 0 {
+		t.Fatal("Decode() from the same session returned no flows")
+	}
+
+	// Templates are forgotten when the session is closed
+	nfdecoder.(*Decoder).CloseSession(source, "127.0.0.1:1000")
+	if got := nfdecoder.Decode(decoder.RawFlow{Payload: data, Source: source, Session: "127.0.0.1:1000"}); len(got) != 0 {
+		t.Fatalf("Decode() after closing session returned %d flows", len(got))
+	}
+}
+
 func TestDecodeMPLS(t *testing.T) {
 	r := reporter.NewMock(t)
 	nfdecoder := New(r, decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()}, decoder.Option{TimestampSource: decoder.TimestampSourceUDP})
@@ -527,10 +719,115 @@ func TestDecodeMPLS(t *testing.T) {
 	}
 }
 
+func TestDecodeVariableLength(t *testing.T) {
+	r := reporter.NewMock(t)
+	nfdecoder := New(r, decoder.Dependencies{Schema: schema.NewMock(t)}, decoder.Option{TimestampSource: decoder.TimestampSourceUDP})
+
+	// The template contains an interface name (1-byte length) and an
+	// enterprise-specific field (3-byte length) followed by fixed-length
+	// fields. Template and data are in the same packet.
+	data := helpers.ReadPcapL4(t, filepath.Join("testdata", "ipfix-varlen.pcap"))
+	got := nfdecoder.Decode(decoder.RawFlow{Payload: data, Source: net.ParseIP("127.0.0.1")})
+	for _, f := range got {
+		f.TimeReceived = 0
+	}
+
+	expectedFlows := []*schema.FlowMessage{
+		{
+			ExporterAddress: netip.MustParseAddr("::ffff:127.0.0.1"),
+			SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.1"),
+			DstAddr:         netip.MustParseAddr("::ffff:203.0.113.10"),
+			InIf:            513,
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:   1500,
+				schema.ColumnPackets: 3,
+				schema.ColumnEType:   helpers.ETypeIPv4,
+				schema.ColumnProto:   6,
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expectedFlows); diff != "" {
+		t.Fatalf("Decode() (-got, +want):\n%s", diff)
+	}
+
+	gotMetrics := r.GetMetrics(
+		"akvorado_inlet_flow_decoder_netflow_",
+		"errors_",
+		"flows_total",
+		"templates_",
+	)
+	expectedMetrics := map[string]string{
+		`flows_total{exporter="127.0.0.1",version="10"}`:                                                         "1",
+		`templates_total{exporter="127.0.0.1",obs_domain_id="5",template_id="256",type="template",version="10"}`: "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestDecodeCustomFields(t *testing.T) {
+	r := reporter.NewMock(t)
+	config := schema.DefaultConfiguration()
+	config.CustomColumns = []schema.CustomColumn{
+		{Name: "ApplicationName", Type: "string"},
+		{Name: "JuniperMetadata", Type: "uint"},
+		{Name: "CustomAddress", Type: "ip"},
+	}
+	sch, err := schema.New(config)
+	if err != nil {
+		t.Fatalf("schema.New() error:\n%+v", err)
+	}
+	nfdecoder := New(r, decoder.Dependencies{Schema: sch}, decoder.Option{
+		TimestampSource: decoder.TimestampSourceUDP,
+		IPFIXCustomFields: []decoder.IPFIXCustomField{
+			{PEN: 9, ID: 12235, Name: "ApplicationName", Type: "string"},
+			{PEN: 2636, ID: 1, Name: "JuniperMetadata", Type: "uint"},
+			{PEN: 29305, ID: 100, Name: "CustomAddress", Type: "ip"},
+		},
+	})
+	column := func(name string) schema.ColumnKey {
+		c, ok := sch.LookupColumnByName(name)
+		if !ok {
+			t.Fatalf("LookupColumnByName(%q) not found", name)
+		}
+		return c.Key
+	}
+
+	// The template contains a variable-length string (PEN 9), an integer
+	// (PEN 2636), an IPv4 address (PEN 29305) and an unmapped field (PEN
+	// 29305). Template and data are in the same packet.
+	data := helpers.ReadPcapL4(t, filepath.Join("testdata", "ipfix-custom-fields.pcap"))
+	got := nfdecoder.Decode(decoder.RawFlow{Payload: data, Source: net.ParseIP("127.0.0.1")})
+	for _, f := range got {
+		f.TimeReceived = 0
+	}
+
+	expectedFlows := []*schema.FlowMessage{
+		{
+			ExporterAddress: netip.MustParseAddr("::ffff:127.0.0.1"),
+			SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.1"),
+			DstAddr:         netip.MustParseAddr("::ffff:203.0.113.10"),
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:        1500,
+				schema.ColumnPackets:      3,
+				schema.ColumnEType:        helpers.ETypeIPv4,
+				schema.ColumnProto:        6,
+				column("ApplicationName"): []byte("http"),
+				column("JuniperMetadata"): 77,
+				column("CustomAddress"):   netip.MustParseAddr("::ffff:198.51.100.1"),
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expectedFlows); diff != "" {
+		t.Fatalf("Decode() (-got, +want):\n%s", diff)
+	}
+}
+
 func TestDecodeNFv5(t *testing.T) {
 	for _, tsSource := range []decoder.TimestampSource{
 		decoder.TimestampSourceNetflowPacket,
 		decoder.TimestampSourceNetflowFirstSwitched,
+		decoder.TimestampSourceNetflowLastSwitched,
 	} {
 		t.Run(fmt.Sprintf("%s", tsSource), func(t *testing.T) {
 			r := reporter.NewMock(t)
@@ -542,8 +839,9 @@ func TestDecodeNFv5(t *testing.T) {
 			got := nfdecoder.Decode(decoder.RawFlow{Payload: data, Source: net.ParseIP("127.0.0.1")})
 
 			ts := uint64(1680626679)
-			if tsSource == decoder.TimestampSourceNetflowFirstSwitched {
-				ts = 1680611679
+			if tsSource != decoder.TimestampSourceNetflowPacket {
+				// First and last switched are 15 seconds before export
+				ts = 1680626664
 			}
 
 			expectedFlows := []*schema.FlowMessage{
@@ -579,6 +877,116 @@ func TestDecodeNFv5(t *testing.T) {
 	}
 }
 
+func TestDecodeNFv5ICMP(t *testing.T) {
+	r := reporter.NewMock(t)
+	nfdecoder := New(r,
+		decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()},
+		decoder.Option{TimestampSource: decoder.TimestampSourceUDP})
+
+	// NetFlow v5 encodes ICMP type and code in the destination port.
+	data := make([]byte, 24+48)
+	binary.BigEndian.PutUint16(data[0:], 5)       // version
+	binary.BigEndian.PutUint16(data[2:], 1)       // count
+	copy(data[24:], []byte{192, 0, 2, 1})         // source address
+	copy(data[28:], []byte{203, 0, 113, 10})      // destination address
+	binary.BigEndian.PutUint32(data[40:], 1)      // packets
+	binary.BigEndian.PutUint32(data[44:], 84)     // bytes
+	binary.BigEndian.PutUint16(data[58:], 3<<8|1) // destination port
+	data[62] = 1                                  // protocol
+	got := nfdecoder.Decode(decoder.RawFlow{Payload: data, Source: net.ParseIP("127.0.0.1")})
+	for _, f := range got {
+		f.TimeReceived = 0
+	}
+	expectedFlows := []*schema.FlowMessage{
+		{
+			ExporterAddress: netip.MustParseAddr("::ffff:127.0.0.1"),
+			SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.1"),
+			DstAddr:         netip.MustParseAddr("::ffff:203.0.113.10"),
+			NextHop:         netip.MustParseAddr("::ffff:0.0.0.0"),
+			SamplingRate:    1,
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:      84,
+				schema.ColumnPackets:    1,
+				schema.ColumnEType:      helpers.ETypeIPv4,
+				schema.ColumnProto:      1,
+				schema.ColumnICMPv4Type: 3,
+				schema.ColumnICMPv4Code: 1,
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expectedFlows); diff != "" {
+		t.Fatalf("Decode() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestDecodeNFv5SamplingMode(t *testing.T) {
+	r := reporter.NewMock(t)
+	nfdecoder := New(r,
+		decoder.Dependencies{Schema: schema.NewMock(t)},
+		decoder.Option{TimestampSource: decoder.TimestampSourceUDP})
+
+	// Build a NetFlow v5 PDU with a single record. The two first bits of the
+	// sampling interval are the sampling mode and should be ignored.
+	data := make([]byte, 24+48)
+	binary.BigEndian.PutUint16(data[0:], 5)            // version
+	binary.BigEndian.PutUint16(data[2:], 1)            // count
+	binary.BigEndian.PutUint16(data[22:], 0x4000|1000) // sampling mode + interval
+	copy(data[24:], []byte{192, 0, 2, 1})              // source address
+	copy(data[28:], []byte{203, 0, 113, 10})           // destination address
+	binary.BigEndian.PutUint16(data[36:], 10)          // input
+	binary.BigEndian.PutUint16(data[38:], 20)          // output
+	binary.BigEndian.PutUint32(data[40:], 2)           // packets
+	binary.BigEndian.PutUint32(data[44:], 1500)        // bytes
+	binary.BigEndian.PutUint16(data[56:], 443)         // source port
+	binary.BigEndian.PutUint16(data[58:], 51000)       // destination port
+	data[62] = 6                                       // protocol
+	binary.BigEndian.PutUint16(data[64:], 65000)       // source AS
+	binary.BigEndian.PutUint16(data[66:], 65001)       // destination AS
+
+	got := nfdecoder.Decode(decoder.RawFlow{Payload: data, Source: net.ParseIP("127.0.0.1")})
+	for _, f := range got {
+		f.TimeReceived = 0
+	}
+	expectedFlows := []*schema.FlowMessage{
+		{
+			ExporterAddress: netip.MustParseAddr("::ffff:127.0.0.1"),
+			SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.1"),
+			DstAddr:         netip.MustParseAddr("::ffff:203.0.113.10"),
+			NextHop:         netip.MustParseAddr("::ffff:0.0.0.0"),
+			SamplingRate:    1000,
+			InIf:            10,
+			OutIf:           20,
+			SrcAS:           65000,
+			DstAS:           65001,
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:   1500,
+				schema.ColumnPackets: 2,
+				schema.ColumnEType:   helpers.ETypeIPv4,
+				schema.ColumnProto:   6,
+				schema.ColumnSrcPort: 443,
+				schema.ColumnDstPort: 51000,
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expectedFlows); diff != "" {
+		t.Fatalf("Decode() (-got, +want):\n%s", diff)
+	}
+
+	gotMetrics := r.GetMetrics(
+		"akvorado_inlet_flow_decoder_netflow_",
+		"flows_total",
+		"flowset_",
+	)
+	expectedMetrics := map[string]string{
+		`flows_total{exporter="127.0.0.1",version="5"}`:                    "1",
+		`flowset_records_sum{exporter="127.0.0.1",type="PDU",version="5"}`: "1",
+		`flowset_sum{exporter="127.0.0.1",type="PDU",version="5"}`:         "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
 func TestDecodeTimestampFromNetflowPacket(t *testing.T) {
 	r := reporter.NewMock(t)
 	nfdecoder := New(r, decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()}, decoder.Option{TimestampSource: decoder.TimestampSourceNetflowPacket})
@@ -604,28 +1012,120 @@ func TestDecodeTimestampFromNetflowPacket(t *testing.T) {
 	}
 }
 
-func TestDecodeTimestampFromFirstSwitched(t *testing.T) {
+func TestDecodeTimestampFromFirstLastSwitched(t *testing.T) {
+	for _, tsSource := range []decoder.TimestampSource{
+		decoder.TimestampSourceNetflowFirstSwitched,
+		decoder.TimestampSourceNetflowLastSwitched,
+	} {
+		t.Run(tsSource.String(), func(t *testing.T) {
+			r := reporter.NewMock(t)
+			nfdecoder := New(r, decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()},
+				decoder.Option{TimestampSource: tsSource})
+
+			data := helpers.ReadPcapL4(t, filepath.Join("testdata", "template.pcap"))
+			got := nfdecoder.Decode(decoder.RawFlow{Payload: data, Source: net.ParseIP("127.0.0.1")})
+			data = helpers.ReadPcapL4(t, filepath.Join("testdata", "data.pcap"))
+			got = append(got, nfdecoder.Decode(decoder.RawFlow{Payload: data, Source: net.ParseIP("127.0.0.1")})...)
+
+			// 4 flows in capture, first and last switched are the same. They
+			// are in milliseconds relative to the system uptime.
+			var sysUptime uint64 = 944951609
+			var packetTs uint64 = 1647285928
+			switched := []uint64{
+				944948659,
+				944948659,
+				944948660,
+				944948661,
+			}
+
+			for i, flow := range got {
+				if val := packetTs - (sysUptime-switched[i])/1000; flow.TimeReceived != val {
+					t.Errorf("Decode() (-got, +want):\n-%d, +%d", flow.TimeReceived, val)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeTimestampSkew(t *testing.T) {
 	r := reporter.NewMock(t)
-	nfdecoder := New(r, decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()}, decoder.Option{TimestampSource: decoder.TimestampSourceNetflowFirstSwitched})
+	nfdecoder := New(r, decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()},
+		decoder.Option{
+			TimestampSource:  decoder.TimestampSourceNetflowFirstSwitched,
+			TimestampMaxSkew: time.Hour,
+		})
 
+	// The flows are received two hours after they were exported.
+	received := time.Unix(1647285928+7200, 0)
 	data := helpers.ReadPcapL4(t, filepath.Join("testdata", "template.pcap"))
-	got := nfdecoder.Decode(decoder.RawFlow{Payload: data, Source: net.ParseIP("127.0.0.1")})
+	got := nfdecoder.Decode(decoder.RawFlow{Payload: data, Source: net.ParseIP("127.0.0.1"), TimeReceived: received})
 	data = helpers.ReadPcapL4(t, filepath.Join("testdata", "data.pcap"))
-	got = append(got, nfdecoder.Decode(decoder.RawFlow{Payload: data, Source: net.ParseIP("127.0.0.1")})...)
+	got = append(got, nfdecoder.Decode(decoder.RawFlow{Payload: data, Source: net.ParseIP("127.0.0.1"), TimeReceived: received})...)
 
-	// 4 flows in capture
-	var sysUptime uint64 = 944951609
-	var packetTs uint64 = 1647285928
-	expectedFirstSwitched := []uint64{
-		944948659,
-		944948659,
-		944948660,
-		944948661,
+	if len(got) != 4 {
+		t.Fatalf("Decode() returned %d flows instead of 4", len(got))
 	}
-
-	for i, flow := range got {
-		if val := packetTs - sysUptime + expectedFirstSwitched[i]; flow.TimeReceived != val {
-			t.Errorf("Decode() (-got, +want):\n-%d, +%d", flow.TimeReceived, val)
+	for _, flow := range got {
+		if flow.TimeReceived != uint64(received.Unix()) {
+			t.Errorf("Decode() (-got, +want):\n-%d, +%d", flow.TimeReceived, received.Unix())
 		}
 	}
+
+	gotMetrics := r.GetMetrics(
+		"akvorado_inlet_flow_decoder_netflow_",
+		"timestamp_",
+	)
+	expectedMetrics := map[string]string{
+		`timestamp_skewed_total{exporter="127.0.0.1",version="9"}`: "4",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestSaveLoadTemplates(t *testing.T) {
+	r := reporter.NewMock(t)
+	sch := schema.NewMock(t)
+	nfdecoder := New(r, decoder.Dependencies{Schema: sch}, decoder.Option{TimestampSource: decoder.TimestampSourceUDP})
+	template := helpers.ReadPcapL4(t, filepath.Join("testdata", "template.pcap"))
+	nfdecoder.Decode(decoder.RawFlow{Payload: template, Source: net.ParseIP("127.0.0.1")})
+
+	var state bytes.Buffer
+	if err := nfdecoder.(decoder.StatefulDecoder).SaveState(&state); err != nil {
+		t.Fatalf("SaveState() error:\n%+v", err)
+	}
+	data := helpers.ReadPcapL4(t, filepath.Join("testdata", "data.pcap"))
+
+	t.Run("fresh templates", func(t *testing.T) {
+		r := reporter.NewMock(t)
+		nfdecoder := New(r, decoder.Dependencies{Schema: sch}, decoder.Option{TimestampSource: decoder.TimestampSourceUDP})
+		if err := nfdecoder.(decoder.StatefulDecoder).LoadState(bytes.NewReader(state.Bytes()),
+			time.Now().Add(-time.Hour)); err != nil {
+			t.Fatalf("LoadState() error:\n%+v", err)
+		}
+		got := nfdecoder.Decode(decoder.RawFlow{Payload: data, Source: net.ParseIP("127.0.0.1")})
+		if len(got) != 4 {
+			t.Fatalf("Decode() returned %d flows instead of 4", len(got))
+		}
+	})
+
+	t.Run("stale templates", func(t *testing.T) {
+		r := reporter.NewMock(t)
+		nfdecoder := New(r, decoder.Dependencies{Schema: sch}, decoder.Option{TimestampSource: decoder.TimestampSourceUDP})
+		if err := nfdecoder.(decoder.StatefulDecoder).LoadState(bytes.NewReader(state.Bytes()),
+			time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("LoadState() error:\n%+v", err)
+		}
+		got := nfdecoder.Decode(decoder.RawFlow{Payload: data, Source: net.ParseIP("127.0.0.1")})
+		if len(got) != 0 {
+			t.Fatalf("Decode() returned %d flows instead of 0", len(got))
+		}
+		gotMetrics := r.GetMetrics("akvorado_inlet_flow_decoder_netflow_", "errors_")
+		expectedMetrics := map[string]string{
+			`errors_total{error="NetFlow v9 template not found",exporter="127.0.0.1"}`: "1",
+		}
+		if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+			t.Fatalf("Metrics (-got, +want):\n%s", diff)
+		}
+	})
 }
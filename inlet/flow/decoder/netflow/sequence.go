@@ -0,0 +1,224 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package netflow
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/netsampler/goflow2/v2/decoders/netflow"
+)
+
+const (
+	// sequenceMaxGap is the maximum number of missed packets (NetFlow v9) or
+	// records (IPFIX) between two packets. Above, we assume the exporter
+	// has been restarted.
+	sequenceMaxGap = 1 << 20
+	// sequenceReorderWindow is the maximum distance behind the expected
+	// sequence number for a packet to be considered as reordered. Above, we
+	// assume the exporter has been restarted. Missing packets are only
+	// counted as missed once they are out of this window.
+	sequenceReorderWindow = 1024
+	// sequenceBootTolerance is the maximum drift, in seconds, of the boot
+	// time of an exporter. Above, we assume the exporter has been restarted.
+	sequenceBootTolerance = 10
+	// sequenceMaxPendingGaps is the maximum number of gaps waiting for late
+	// packets. Above, the oldest gaps are counted as missed.
+	sequenceMaxPendingGaps = 32
+	// lossWindowBuckets is the number of one-minute buckets used to compute
+	// the loss ratio.
+	lossWindowBuckets = 5
+)
+
+type sequenceKey struct {
+	exporter    string
+	version     uint16
+	obsDomainID uint32
+}
+
+// sequenceState is the state of the sequence numbers for an exporter and an
+// observation domain: the next expected sequence number, the gaps which may
+// still be filled by late packets and the boot time of the exporter (0 when
+// unknown).
+type sequenceState struct {
+	expected uint32
+	pending  []sequenceGap
+	boot     uint32
+}
+
+// sequenceGap is a range of missing sequence numbers, end excluded.
+type sequenceGap struct {
+	start uint32
+	end   uint32
+}
+
+// fill removes the provided range from the pending gaps. It returns false
+// if the range does not match any pending gap.
+func (ss *sequenceState) fill(sequence, count uint32) bool {
+	filled := false
+	pending := ss.pending[:0:0]
+	for _, gap := range ss.pending {
+		// Unsigned arithmetic takes care of the wraparound.
+		length := gap.end - gap.start
+		offset := sequence - gap.start
+		if offset >= length {
+			pending = append(pending, gap)
+			continue
+		}
+		filled = true
+		n := min(count, length-offset)
+		if offset > 0 {
+			pending = append(pending, sequenceGap{gap.start, sequence})
+		}
+		if sequence+n != gap.end {
+			pending = append(pending, sequenceGap{sequence + n, gap.end})
+		}
+	}
+	ss.pending = pending
+	return filled
+}
+
+// commit removes the gaps which cannot be filled anymore (all of them when
+// all is true) and returns the number of missed packets (or records).
+func (ss *sequenceState) commit(all bool) uint32 {
+	var missed uint32
+	pending := ss.pending[:0]
+	for idx, gap := range ss.pending {
+		if all || ss.expected-gap.end > sequenceReorderWindow ||
+			len(ss.pending)-idx > sequenceMaxPendingGaps {
+			missed += gap.end - gap.start
+			continue
+		}
+		pending = append(pending, gap)
+	}
+	ss.pending = pending
+	return missed
+}
+
+// lossWindow keeps track of received and missed packets (or records) for an
+// exporter over the last few minutes.
+type lossWindow struct {
+	buckets [lossWindowBuckets]lossBucket
+}
+
+type lossBucket struct {
+	minute   int64
+	received uint64
+	missed   uint64
+}
+
+// add records received and missed packets (or records) and returns the loss
+// ratio over the window.
+func (w *lossWindow) add(now time.Time, received, missed uint64) float64 {
+	minute := now.Unix() / 60
+	bucket := &w.buckets[minute%lossWindowBuckets]
+	if bucket.minute != minute {
+		*bucket = lossBucket{minute: minute}
+	}
+	bucket.received += received
+	bucket.missed += missed
+
+	var totalReceived, totalMissed uint64
+	for _, bucket := range w.buckets {
+		if minute-bucket.minute < lossWindowBuckets {
+			totalReceived += bucket.received
+			totalMissed += bucket.missed
+		}
+	}
+	if totalReceived+totalMissed == 0 {
+		return 0
+	}
+	return float64(totalMissed) / float64(totalReceived+totalMissed)
+}
+
+// checkSequence checks the sequence number of a packet against the expected
+// one and updates the associated metrics. count is the increment of the
+// sequence number for this packet: 1 for NetFlow v9 (packets are counted) and
+// the number of data records for IPFIX. boot is the boot time of the exporter
+// in seconds when known (NetFlow v9), 0 otherwise.
+//
+// A packet behind the expected sequence number is only considered as
+// reordered when it fills a missing range. Otherwise, as when the boot time
+// changes, the exporter is assumed to have been restarted.
+func (nd *Decoder) checkSequence(exporter string, version uint16, obsDomainID uint32, sequence uint32, count uint32, boot uint32) {
+	sk := sequenceKey{exporter, version, obsDomainID}
+	versionStr := strconv.Itoa(int(version))
+	obsDomainIDStr := strconv.Itoa(int(obsDomainID))
+
+	nd.sequencesLock.Lock()
+	defer nd.sequencesLock.Unlock()
+	var restarted bool
+	state, ok := nd.sequences[sk]
+	if !ok {
+		state = &sequenceState{expected: sequence + count, boot: boot}
+		nd.sequences[sk] = state
+	} else {
+		// Unsigned arithmetic takes care of the wraparound.
+		ahead := sequence - state.expected
+		behind := state.expected - sequence
+		rebooted := boot != 0 && state.boot != 0 &&
+			boot-state.boot > sequenceBootTolerance && state.boot-boot > sequenceBootTolerance
+		if boot != 0 {
+			state.boot = boot
+		}
+		switch {
+		case rebooted:
+			nd.metrics.sequenceResets.WithLabelValues(exporter, versionStr, obsDomainIDStr).
+				Inc()
+			state.expected = sequence + count
+			restarted = true
+		case ahead == 0:
+			state.expected = sequence + count
+		case ahead <= sequenceMaxGap:
+			state.pending = append(state.pending, sequenceGap{state.expected, sequence})
+			state.expected = sequence + count
+		case behind <= sequenceReorderWindow && state.fill(sequence, count):
+			nd.metrics.sequenceReordered.WithLabelValues(exporter, versionStr, obsDomainIDStr).
+				Inc()
+		default:
+			nd.metrics.sequenceResets.WithLabelValues(exporter, versionStr, obsDomainIDStr).
+				Inc()
+			state.expected = sequence + count
+			restarted = true
+		}
+	}
+	// On restart, pending gaps cannot be filled anymore.
+	missed := state.commit(restarted)
+	if missed > 0 {
+		nd.metrics.sequenceMissed.WithLabelValues(exporter, versionStr, obsDomainIDStr).
+			Add(float64(missed))
+	}
+
+	window, ok := nd.lossWindows[exporter]
+	if !ok {
+		window = &lossWindow{}
+		nd.lossWindows[exporter] = window
+	}
+	ratio := window.add(time.Now(), uint64(count), uint64(missed))
+	nd.metrics.sequenceLossRatio.WithLabelValues(exporter).Set(ratio)
+}
+
+// countDataRecords returns the number of data records (including options data
+// records) in the provided flow sets.
+func countDataRecords(flowSets []interface{}) uint32 {
+	var count uint32
+	for _, fs := range flowSets {
+		switch fsConv := fs.(type) {
+		case netflow.DataFlowSet:
+			count += uint32(len(fsConv.Records))
+		case netflow.OptionsDataFlowSet:
+			count += uint32(len(fsConv.Records))
+		}
+	}
+	return count
+}
+
+// forgetSequence forgets the expected sequence number for the provided
+// exporter and observation domain. This is used when a packet cannot be
+// decoded.
+func (nd *Decoder) forgetSequence(exporter string, version uint16, obsDomainID uint32) {
+	nd.sequencesLock.Lock()
+	defer nd.sequencesLock.Unlock()
+	delete(nd.sequences, sequenceKey{exporter, version, obsDomainID})
+}
@@ -0,0 +1,86 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package netflow
+
+import (
+	"github.com/netsampler/goflow2/v2/decoders/netflow"
+)
+
+// ntpEpochOffset is the number of seconds between the NTP epoch (1900) and the
+// UNIX epoch (1970).
+const ntpEpochOffset = 2_208_988_800
+
+// flowTimestamps collects the start and end timestamps of a flow record.
+type flowTimestamps struct {
+	start, end             uint64 // UNIX timestamps in seconds
+	startUptime, endUptime uint64 // relative to the system uptime, in milliseconds
+	systemInit             uint64 // system initialization time, in milliseconds
+}
+
+// collect extracts a timestamp from the provided field. It returns false if
+// the field does not contain a timestamp.
+func (ft *flowTimestamps) collect(fieldType uint16, v []byte) bool {
+	switch fieldType {
+	// Same identifiers as IPFIX flowStartSysUpTime and flowEndSysUpTime
+	case netflow.NFV9_FIELD_FIRST_SWITCHED:
+		ft.startUptime = decodeUNumber(v)
+	case netflow.NFV9_FIELD_LAST_SWITCHED:
+		ft.endUptime = decodeUNumber(v)
+	case netflow.IPFIX_FIELD_systemInitTimeMilliseconds:
+		ft.systemInit = decodeUNumber(v)
+	case netflow.IPFIX_FIELD_flowStartSeconds:
+		ft.start = decodeUNumber(v)
+	case netflow.IPFIX_FIELD_flowEndSeconds:
+		ft.end = decodeUNumber(v)
+	case netflow.IPFIX_FIELD_flowStartMilliseconds:
+		ft.start = decodeUNumber(v) / 1000
+	case netflow.IPFIX_FIELD_flowEndMilliseconds:
+		ft.end = decodeUNumber(v) / 1000
+	case netflow.IPFIX_FIELD_flowStartMicroseconds, netflow.IPFIX_FIELD_flowStartNanoseconds:
+		// NTP timestamps, the upper 32 bits are the seconds
+		ft.start = decodeNTPTimestamp(v)
+	case netflow.IPFIX_FIELD_flowEndMicroseconds, netflow.IPFIX_FIELD_flowEndNanoseconds:
+		ft.end = decodeNTPTimestamp(v)
+	default:
+		return false
+	}
+	return true
+}
+
+// resolve returns the UNIX timestamp of the start or the end of the flow.
+// Timestamps relative to the system uptime use the system uptime and the
+// export time of the packet for NetFlow v9 and the system initialization time
+// for IPFIX. It returns 0 when the timestamp is unknown.
+func (ft *flowTimestamps) resolve(version uint16, ts, sysUptime uint64, end bool) uint64 {
+	absolute, relative := ft.start, ft.startUptime
+	if end {
+		absolute, relative = ft.end, ft.endUptime
+	}
+	if absolute != 0 || relative == 0 {
+		return absolute
+	}
+	switch {
+	case version == 10 && ft.systemInit != 0:
+		return (ft.systemInit + relative) / 1000
+	case version != 10 && sysUptime != 0:
+		return decodeRelativeTimestamp(ts, sysUptime, relative)
+	}
+	return 0
+}
+
+// decodeRelativeTimestamp turns a timestamp relative to the system uptime into
+// a UNIX timestamp using the export time (in seconds) and the system uptime
+// (in milliseconds) from the packet header.
+func decodeRelativeTimestamp(ts, sysUptime, relative uint64) uint64 {
+	return uint64(int64(ts) - (int64(sysUptime)-int64(relative))/1000)
+}
+
+// decodeNTPTimestamp turns a 64-bit NTP timestamp into a UNIX timestamp.
+func decodeNTPTimestamp(v []byte) uint64 {
+	seconds := decodeUNumber(v) >> 32
+	if seconds < ntpEpochOffset {
+		return 0
+	}
+	return seconds - ntpEpochOffset
+}
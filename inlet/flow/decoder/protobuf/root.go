@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Package protobuf handles decoding of flows already decoded by another inlet.
+package protobuf
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
+)
+
+// Decoder contains the state for the protobuf decoder.
+type Decoder struct {
+	r         *reporter.Reporter
+	d         decoder.Dependencies
+	errLogger reporter.Logger
+
+	metrics struct {
+		errors *reporter.CounterVec
+		stats  reporter.Counter
+	}
+}
+
+// New instantiates a new protobuf decoder.
+func New(r *reporter.Reporter, dependencies decoder.Dependencies, _ decoder.Option) decoder.Decoder {
+	pd := &Decoder{
+		r:         r,
+		d:         dependencies,
+		errLogger: r.Sample(reporter.BurstSampler(30*time.Second, 3)),
+	}
+
+	pd.metrics.errors = pd.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "errors_total",
+			Help: "Protobuf messages processed errors.",
+		},
+		[]string{"error"},
+	)
+	pd.metrics.stats = pd.r.Counter(
+		reporter.CounterOpts{
+			Name: "flows_total",
+			Help: "Protobuf messages processed.",
+		},
+	)
+
+	return pd
+}
+
+// Decode decodes one or several length-delimited protobuf messages encoded
+// with the same schema. The exporter address is extracted from each message.
+func (pd *Decoder) Decode(in decoder.RawFlow) []*schema.FlowMessage {
+	flows := []*schema.FlowMessage{}
+	payload := in.Payload
+	for len(payload) > 0 {
+		size, n := protowire.ConsumeVarint(payload)
+		if n < 0 || uint64(len(payload)-n) < size {
+			pd.metrics.errors.WithLabelValues("truncated message").Inc()
+			pd.errLogger.Error().Msg("truncated protobuf message")
+			return nil
+		}
+		flow, err := pd.d.Schema.ProtobufUnmarshal(payload[:n+int(size)])
+		if err != nil {
+			pd.metrics.errors.WithLabelValues("error decoding").Inc()
+			pd.errLogger.Err(err).Msg("error while decoding protobuf message")
+			return nil
+		}
+		payload = payload[n+int(size):]
+		flows = append(flows, flow)
+	}
+	pd.metrics.stats.Add(float64(len(flows)))
+	return flows
+}
+
+// Name returns the name of the decoder.
+func (pd *Decoder) Name() string {
+	return "protobuf"
+}
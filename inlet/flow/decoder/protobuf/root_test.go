@@ -0,0 +1,63 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package protobuf
+
+import (
+	"net/netip"
+	"testing"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
+)
+
+func TestDecode(t *testing.T) {
+	r := reporter.NewMock(t)
+	sch := schema.NewMock(t)
+	pdecoder := New(r, decoder.Dependencies{Schema: sch}, decoder.Option{})
+
+	payload := []byte{}
+	for _, exporter := range []string{"::ffff:192.0.2.1", "::ffff:192.0.2.2"} {
+		bf := &schema.FlowMessage{
+			TimeReceived:    1000,
+			SamplingRate:    1024,
+			ExporterAddress: netip.MustParseAddr(exporter),
+		}
+		sch.ProtobufAppendVarint(bf, schema.ColumnBytes, 1500)
+		sch.ProtobufAppendVarint(bf, schema.ColumnPackets, 1)
+		payload = append(payload, sch.ProtobufMarshal(bf)...)
+	}
+
+	got := pdecoder.Decode(decoder.RawFlow{Payload: payload})
+	expected := []*schema.FlowMessage{}
+	for _, exporter := range []string{"::ffff:192.0.2.1", "::ffff:192.0.2.2"} {
+		expected = append(expected, &schema.FlowMessage{
+			TimeReceived:    1000,
+			SamplingRate:    1024,
+			ExporterAddress: netip.MustParseAddr(exporter),
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:   1500,
+				schema.ColumnPackets: 1,
+			},
+		})
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("Decode() (-got, +want):\n%s", diff)
+	}
+
+	// Truncated payload
+	if got := pdecoder.Decode(decoder.RawFlow{Payload: payload[:len(payload)-1]}); got != nil {
+		t.Fatalf("Decode() with truncated payload returned %d flows", len(got))
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_decoder_protobuf_")
+	expectedMetrics := map[string]string{
+		`errors_total{error="truncated message"}`: "1",
+		`flows_total`: "2",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
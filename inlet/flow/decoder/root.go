@@ -6,6 +6,7 @@
 package decoder
 
 import (
+	"io"
 	"net"
 	"time"
 
@@ -24,10 +25,47 @@ type Decoder interface {
 	Name() string
 }
 
+// StatefulDecoder is the interface implemented by decoders with a state
+// worth keeping across restarts (like NetFlow templates).
+type StatefulDecoder interface {
+	Decoder
+
+	// SaveState writes the current state of the decoder.
+	SaveState(w io.Writer) error
+	// LoadState restores a previously saved state. Entries last updated
+	// before the provided time are ignored.
+	LoadState(r io.Reader, notBefore time.Time) error
+}
+
+// SessionDecoder is the interface implemented by decoders keeping a separate
+// state for each session of a stream-oriented input (like IPFIX over TCP).
+type SessionDecoder interface {
+	Decoder
+
+	// CloseSession forgets the state associated with the provided session.
+	CloseSession(source net.IP, session string)
+}
+
 // Option specifies option to influence the behaviour of the decoder
 type Option struct {
 	// TimestampSource is a selector for how to set the TimeReceived.
 	TimestampSource TimestampSource
+	// TimestampMaxSkew is the maximum difference between the timestamp of a
+	// flow and the time it was received. Flows above this limit use the
+	// receive time instead. When 0, there is no limit.
+	TimestampMaxSkew time.Duration
+	// InterfaceCountersLimit is the maximum number of interfaces per exporter
+	// for which interface counters are exported as metrics (sFlow only).
+	InterfaceCountersLimit uint
+	// Decapsulate enables decoding of the inner packet for VXLAN and GRE
+	// tunnels when parsing raw packet headers.
+	Decapsulate bool
+	// DecapsulateGTP enables decoding of the inner packet for GTP-U tunnels
+	// when parsing raw packet headers.
+	DecapsulateGTP bool
+	// IPFIXCustomFields maps enterprise-specific IPFIX fields to custom
+	// columns (NetFlow only).
+	IPFIXCustomFields []IPFIXCustomField
 }
 
 // Dependencies are the dependencies for the decoder
@@ -40,6 +78,12 @@ type RawFlow struct {
 	TimeReceived time.Time
 	Payload      []byte
 	Source       net.IP
+	// Session identifies the session of a stream-oriented input. It is empty
+	// for datagram-oriented inputs.
+	Session string
+	// TimestampSource overrides the timestamp source of the decoder when not
+	// nil.
+	TimestampSource *TimestampSource
 }
 
 // NewDecoderFunc is the signature of a function to instantiate a decoder.
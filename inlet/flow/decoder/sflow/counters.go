@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package sflow
+
+import (
+	"strconv"
+
+	"github.com/netsampler/goflow2/v2/decoders/sflow"
+)
+
+// decodeCounters exports generic interface counters from a counter sample as
+// metrics. The number of interfaces tracked for each exporter is limited.
+func (nd *Decoder) decodeCounters(key string, sample sflow.CounterSample) {
+	for _, record := range sample.Records {
+		counters, ok := record.Data.(sflow.IfCounters)
+		if !ok {
+			continue
+		}
+		if !nd.trackInterface(key, counters.IfIndex) {
+			nd.metrics.interfaceCountersDropped.WithLabelValues(key).Inc()
+			continue
+		}
+		ifIndex := strconv.FormatUint(uint64(counters.IfIndex), 10)
+		nd.metrics.interfaceOctets.WithLabelValues(key, ifIndex, "in").Set(float64(counters.IfInOctets))
+		nd.metrics.interfaceOctets.WithLabelValues(key, ifIndex, "out").Set(float64(counters.IfOutOctets))
+		nd.metrics.interfaceErrors.WithLabelValues(key, ifIndex, "in").Set(float64(counters.IfInErrors))
+		nd.metrics.interfaceErrors.WithLabelValues(key, ifIndex, "out").Set(float64(counters.IfOutErrors))
+		nd.metrics.interfaceDiscards.WithLabelValues(key, ifIndex, "in").Set(float64(counters.IfInDiscards))
+		nd.metrics.interfaceDiscards.WithLabelValues(key, ifIndex, "out").Set(float64(counters.IfOutDiscards))
+	}
+}
+
+// trackInterface tells if we can export counters for the provided interface.
+// It returns false when the exporter already has too many tracked interfaces.
+func (nd *Decoder) trackInterface(key string, ifIndex uint32) bool {
+	nd.interfaceCountersLock.Lock()
+	defer nd.interfaceCountersLock.Unlock()
+	interfaces, ok := nd.interfaceCounters[key]
+	if !ok {
+		interfaces = map[uint32]struct{}{}
+		nd.interfaceCounters[key] = interfaces
+	}
+	if _, ok := interfaces[ifIndex]; ok {
+		return true
+	}
+	if uint(len(interfaces)) >= nd.interfaceCountersLimit {
+		return false
+	}
+	interfaces[ifIndex] = struct{}{}
+	return true
+}
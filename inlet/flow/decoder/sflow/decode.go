@@ -5,6 +5,8 @@
 package sflow
 
 import (
+	"strconv"
+
 	"akvorado/common/helpers"
 	"akvorado/common/schema"
 	"akvorado/inlet/flow/decoder"
@@ -12,7 +14,7 @@ import (
 	"github.com/netsampler/goflow2/v2/decoders/sflow"
 )
 
-func (nd *Decoder) decode(packet sflow.Packet) []*schema.FlowMessage {
+func (nd *Decoder) decode(key string, packet sflow.Packet) []*schema.FlowMessage {
 	flowMessageSet := []*schema.FlowMessage{}
 
 	for _, flowSample := range packet.Samples {
@@ -35,7 +37,17 @@ func (nd *Decoder) decode(packet sflow.Packet) []*schema.FlowMessage {
 			records = flowSample.Records
 			bf.SamplingRate = flowSample.SamplingRate
 			bf.InIf = flowSample.InputIfValue
-			bf.OutIf = flowSample.OutputIfValue
+			switch flowSample.OutputIfFormat {
+			case interfaceFormatDiscard:
+				// The value is the reason for discarding the traffic
+				forwardingStatus = 128
+			case interfaceFormatMultiple:
+			default:
+				bf.OutIf = flowSample.OutputIfValue
+			}
+		default:
+			// Counter samples are not flows
+			continue
 		}
 
 		if bf.InIf == interfaceLocal {
@@ -74,28 +86,41 @@ func (nd *Decoder) decode(packet sflow.Packet) []*schema.FlowMessage {
 				// Only process this header if:
 				//  - we don't have a sampled IPv4 header nor a sampled IPv4 header, or
 				//  - we need L2 data and we don't have sampled ethernet header or we don't have extended switch record
-				//  - we need L3/L4 data
-				if !hasSampledIPv4 && !hasSampledIPv6 || !nd.d.Schema.IsDisabled(schema.ColumnGroupL2) && (!hasSampledEthernet || !hasExtendedSwitch) || !nd.d.Schema.IsDisabled(schema.ColumnGroupL3L4) {
-					if l := nd.parseSampledHeader(bf, &recordData); l > 0 {
+				//  - we need L3/L4 data, or
+				//  - we may need to decapsulate it
+				if !hasSampledIPv4 && !hasSampledIPv6 || !nd.d.Schema.IsDisabled(schema.ColumnGroupL2) && (!hasSampledEthernet || !hasExtendedSwitch) || !nd.d.Schema.IsDisabled(schema.ColumnGroupL3L4) || nd.decapsulate != 0 {
+					if l := nd.parseSampledHeader(key, bf, &recordData); l > 0 {
 						l3length = l
 					}
 				}
 			case sflow.SampledIPv4:
-				bf.SrcAddr = decoder.DecodeIP(recordData.SrcIP)
-				bf.DstAddr = decoder.DecodeIP(recordData.DstIP)
+				if !bf.SrcAddr.IsValid() {
+					// Addresses from the sampled header may come from a
+					// decapsulated packet, keep them.
+					bf.SrcAddr = decoder.DecodeIP(recordData.SrcIP)
+					bf.DstAddr = decoder.DecodeIP(recordData.DstIP)
+				}
 				l3length = uint64(recordData.Length)
 				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnProto, uint64(recordData.Protocol))
-				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnSrcPort, uint64(recordData.SrcPort))
-				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnDstPort, uint64(recordData.DstPort))
+				if recordData.Protocol != 1 && recordData.Protocol != 58 {
+					nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnSrcPort, uint64(recordData.SrcPort))
+					nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnDstPort, uint64(recordData.DstPort))
+				}
 				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnEType, helpers.ETypeIPv4)
 				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnIPTos, uint64(recordData.Tos))
 			case sflow.SampledIPv6:
-				bf.SrcAddr = decoder.DecodeIP(recordData.SrcIP)
-				bf.DstAddr = decoder.DecodeIP(recordData.DstIP)
+				if !bf.SrcAddr.IsValid() {
+					// Addresses from the sampled header may come from a
+					// decapsulated packet, keep them.
+					bf.SrcAddr = decoder.DecodeIP(recordData.SrcIP)
+					bf.DstAddr = decoder.DecodeIP(recordData.DstIP)
+				}
 				l3length = uint64(recordData.Length)
 				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnProto, uint64(recordData.Protocol))
-				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnSrcPort, uint64(recordData.SrcPort))
-				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnDstPort, uint64(recordData.DstPort))
+				if recordData.Protocol != 1 && recordData.Protocol != 58 {
+					nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnSrcPort, uint64(recordData.SrcPort))
+					nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnDstPort, uint64(recordData.DstPort))
+				}
 				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnEType, helpers.ETypeIPv6)
 				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnIPTos, uint64(recordData.Priority))
 			case sflow.SampledEthernet:
@@ -132,12 +157,10 @@ func (nd *Decoder) decode(packet sflow.Packet) []*schema.FlowMessage {
 				}
 				if len(recordData.ASPath) > 0 {
 					bf.DstAS = recordData.ASPath[len(recordData.ASPath)-1]
-					if column, _ := nd.d.Schema.LookupColumnByKey(schema.ColumnDstASPath); !column.Disabled {
-						for _, asn := range recordData.ASPath {
-							column.ProtobufAppendVarint(bf, uint64(asn))
-						}
-					}
-					bf.GotASPath = true
+					bf.DstASPath = recordData.ASPath
+				}
+				if len(recordData.Communities) > 0 {
+					bf.DstCommunities = recordData.Communities
 				}
 			}
 		}
@@ -151,15 +174,25 @@ func (nd *Decoder) decode(packet sflow.Packet) []*schema.FlowMessage {
 	return flowMessageSet
 }
 
-func (nd *Decoder) parseSampledHeader(bf *schema.FlowMessage, header *sflow.SampledHeader) uint64 {
+// parseSampledHeader parses a sampled header depending on its header
+// protocol and returns L3 length.
+func (nd *Decoder) parseSampledHeader(key string, bf *schema.FlowMessage, header *sflow.SampledHeader) uint64 {
 	data := header.HeaderData
 	switch header.Protocol {
-	case 1: // Ethernet
-		return decoder.ParseEthernet(nd.d.Schema, bf, data)
-	case 11: // IPv4
-		return decoder.ParseIPv4(nd.d.Schema, bf, data)
-	case 12: // IPv6
-		return decoder.ParseIPv6(nd.d.Schema, bf, data)
+	case headerProtocolEthernet:
+		nd.metrics.sampledHeaders.WithLabelValues(key, "ethernet").Inc()
+		return decoder.ParseEthernet(nd.d.Schema, bf, data, nd.decapsulate)
+	case headerProtocolIPv4:
+		nd.metrics.sampledHeaders.WithLabelValues(key, "ipv4").Inc()
+		return decoder.ParseIPv4(nd.d.Schema, bf, data, nd.decapsulate)
+	case headerProtocolIPv6:
+		nd.metrics.sampledHeaders.WithLabelValues(key, "ipv6").Inc()
+		return decoder.ParseIPv6(nd.d.Schema, bf, data, nd.decapsulate)
+	case headerProtocolPPP, headerProtocolPOS:
+		nd.metrics.sampledHeaders.WithLabelValues(key, "ppp").Inc()
+		return decoder.ParsePPP(nd.d.Schema, bf, data, nd.decapsulate)
 	}
+	nd.metrics.sampledHeaders.WithLabelValues(key, strconv.Itoa(int(header.Protocol))).Inc()
+	nd.metrics.errors.WithLabelValues(key, "unsupported sampled header protocol").Inc()
 	return 0
 }
@@ -0,0 +1,248 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package sflow
+
+import (
+	"encoding/binary"
+
+	"github.com/netsampler/goflow2/v2/decoders/sflow"
+
+	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
+)
+
+const (
+	// sampleFormatDiscardedPacket is the sample format (enterprise 0) for
+	// dropped packet notifications.
+	sampleFormatDiscardedPacket = 5
+	// recordFormatSampledHeader is the flow record format (enterprise 0)
+	// for sampled headers.
+	recordFormatSampledHeader = 1
+)
+
+// discardedPacket is a dropped packet notification. Only the sampled header
+// is kept from the flow records.
+type discardedPacket struct {
+	Input  uint32
+	Output uint32
+	Reason uint32
+	Header *sflow.SampledHeader
+}
+
+// dropReasons maps drop reason codes to their names, as defined in the sFlow
+// dropped packet notification structures.
+var dropReasons = map[uint32]string{
+	0:   "net_unreachable",
+	1:   "host_unreachable",
+	2:   "protocol_unreachable",
+	3:   "port_unreachable",
+	4:   "frag_needed",
+	5:   "src_route_failed",
+	6:   "dst_net_unknown",
+	7:   "dst_host_unknown",
+	8:   "src_host_isolated",
+	9:   "dst_net_prohibited",
+	10:  "dst_host_prohibited",
+	11:  "dst_net_tos_unreachable",
+	12:  "dst_host_tos_unreacheable",
+	13:  "comm_admin_prohibited",
+	14:  "host_precedence_violation",
+	15:  "precedence_cutoff",
+	256: "unknown",
+	257: "ttl_exceeded",
+	258: "acl",
+	259: "no_buffer_space",
+	260: "red",
+	261: "traffic_shaping",
+	262: "pkt_too_big",
+	263: "src_mac_is_multicast",
+	264: "vlan_tag_mismatch",
+	265: "ingress_vlan_filter",
+	266: "ingress_spanning_tree_filter",
+	267: "port_list_is_empty",
+	268: "port_loopback_filter",
+	269: "blackhole_route",
+	270: "non_ip",
+	271: "uc_dip_over_mc_dmac",
+	272: "dip_is_loopback_address",
+	273: "sip_is_mc",
+	274: "sip_is_loopback_address",
+	275: "ip_header_corrupted",
+	276: "ipv4_sip_is_limited_bc",
+	277: "ipv6_mc_dip_reserved_scope",
+	278: "ipv6_mc_dip_interface_local_scope",
+	279: "unresolved_neigh",
+	280: "mc_reverse_path_forwarding",
+	281: "non_routable_packet",
+	282: "decap_error",
+	283: "overlay_smac_is_mc",
+	284: "unknown_l2",
+	285: "unknown_l3",
+	286: "unknown_l3_exception",
+	287: "unknown_buffer",
+	288: "unknown_tunnel",
+	289: "unknown_l4",
+	290: "sip_is_unspecified",
+	291: "mlag_port_isolation",
+	292: "blackhole_arp_neigh",
+	293: "src_mac_is_dmac",
+	294: "dmac_is_reserved",
+	295: "sip_is_class_e",
+	296: "mc_dmac_mismatch",
+	297: "sip_is_dip",
+	298: "dip_is_local_network",
+	299: "dip_is_link_local",
+	300: "overlay_smac_is_dmac",
+	301: "egress_vlan_filter",
+	302: "uc_reverse_path_forwarding",
+	303: "split_horizon",
+}
+
+// dropReasonName returns the name of a drop reason. Unknown codes are mapped
+// to "unknown" to bound the cardinality.
+func dropReasonName(reason uint32) string {
+	if name, ok := dropReasons[reason]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// extractDiscardedPackets extracts dropped packet notifications from an sFlow
+// datagram. It returns the datagram without them (as they are not understood
+// by the sFlow decoder), the notifications, and the number of notifications
+// which cannot be decoded. When the datagram cannot be parsed, it is returned
+// as is.
+func extractDiscardedPackets(payload []byte) ([]byte, []discardedPacket, int) {
+	// Datagram header: version, agent address (type + address), sub-agent
+	// ID, sequence number, uptime, number of samples.
+	if len(payload) < 8 || binary.BigEndian.Uint32(payload[0:4]) != 5 {
+		return payload, nil, 0
+	}
+	offset := 8
+	switch binary.BigEndian.Uint32(payload[4:8]) {
+	case 1:
+		offset += 4
+	case 2:
+		offset += 16
+	default:
+		return payload, nil, 0
+	}
+	offset += 12
+	if len(payload) < offset+4 {
+		return payload, nil, 0
+	}
+	count := binary.BigEndian.Uint32(payload[offset : offset+4])
+	headerLength := offset + 4
+
+	// Walk samples
+	var discarded []discardedPacket
+	var invalid int
+	var kept [][]byte
+	offset = headerLength
+	for range count {
+		if len(payload) < offset+8 {
+			return payload, nil, 0
+		}
+		format := binary.BigEndian.Uint32(payload[offset : offset+4])
+		length := int(binary.BigEndian.Uint32(payload[offset+4 : offset+8]))
+		if len(payload) < offset+8+length {
+			return payload, nil, 0
+		}
+		sample := payload[offset : offset+8+length]
+		offset += 8 + length
+		if format != sampleFormatDiscardedPacket {
+			kept = append(kept, sample)
+			continue
+		}
+		if dp, ok := decodeDiscardedPacket(sample[8:]); ok {
+			discarded = append(discarded, dp)
+		} else {
+			invalid++
+		}
+	}
+	if len(kept) == int(count) {
+		return payload, nil, 0
+	}
+
+	// Rebuild the datagram without the dropped packet notifications
+	result := make([]byte, 0, len(payload))
+	result = append(result, payload[:headerLength-4]...)
+	result = binary.BigEndian.AppendUint32(result, uint32(len(kept)))
+	for _, sample := range kept {
+		result = append(result, sample...)
+	}
+	return result, discarded, invalid
+}
+
+// decodeDiscardedPacket decodes the content of a dropped packet notification:
+// sequence number, source ID (class and index), drops, input interface, output
+// interface, reason, and flow records.
+func decodeDiscardedPacket(data []byte) (discardedPacket, bool) {
+	var dp discardedPacket
+	if len(data) < 32 {
+		return dp, false
+	}
+	dp.Input = binary.BigEndian.Uint32(data[16:20])
+	dp.Output = binary.BigEndian.Uint32(data[20:24])
+	dp.Reason = binary.BigEndian.Uint32(data[24:28])
+	count := binary.BigEndian.Uint32(data[28:32])
+	data = data[32:]
+	for range count {
+		if len(data) < 8 {
+			return dp, false
+		}
+		format := binary.BigEndian.Uint32(data[0:4])
+		length := int(binary.BigEndian.Uint32(data[4:8]))
+		if len(data) < 8+length {
+			return dp, false
+		}
+		record := data[8 : 8+length]
+		data = data[8+length:]
+		if format != recordFormatSampledHeader || len(record) < 16 {
+			continue
+		}
+		headerLength := int(binary.BigEndian.Uint32(record[12:16]))
+		if len(record) < 16+headerLength {
+			continue
+		}
+		dp.Header = &sflow.SampledHeader{
+			Protocol:    binary.BigEndian.Uint32(record[0:4]),
+			FrameLength: binary.BigEndian.Uint32(record[4:8]),
+			HeaderData:  record[16 : 16+headerLength],
+		}
+	}
+	return dp, true
+}
+
+// decodeDiscarded turns dropped packet notifications into flows.
+func (nd *Decoder) decodeDiscarded(key string, agentIP []byte, discarded []discardedPacket) []*schema.FlowMessage {
+	flowMessageSet := []*schema.FlowMessage{}
+	for _, dp := range discarded {
+		reason := dropReasonName(dp.Reason)
+		nd.metrics.discardedPackets.WithLabelValues(key, reason).Inc()
+
+		bf := &schema.FlowMessage{
+			ExporterAddress: decoder.DecodeIP(agentIP),
+			SamplingRate:    1,
+			InIf:            dp.Input,
+			OutIf:           dp.Output,
+		}
+		if bf.InIf == interfaceLocal {
+			bf.InIf = 0
+		}
+		if bf.OutIf == interfaceLocal {
+			bf.OutIf = 0
+		}
+		nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnPackets, 1)
+		nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnForwardingStatus, 128)
+		nd.d.Schema.ProtobufAppendBytes(bf, schema.ColumnDropReason, []byte(reason))
+		if dp.Header != nil {
+			if l3length := nd.parseSampledHeader(key, bf, dp.Header); l3length > 0 {
+				nd.d.Schema.ProtobufAppendVarintForce(bf, schema.ColumnBytes, l3length)
+			}
+		}
+		flowMessageSet = append(flowMessageSet, bf)
+	}
+	return flowMessageSet
+}
@@ -7,6 +7,7 @@ package sflow
 import (
 	"bytes"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/netsampler/goflow2/v2/decoders/sflow"
@@ -26,6 +27,23 @@ const (
 	interfaceOutDiscard = 0x40000000
 	// interfaceOutMultiple is used when there are multiple output interfaces
 	interfaceOutMultiple = 0x80000000
+	// interfaceFormatDiscard is used as output interface format in expanded
+	// flow samples when the traffic is discarded
+	interfaceFormatDiscard = 1
+	// interfaceFormatMultiple is used as output interface format in expanded
+	// flow samples when there are multiple output interfaces
+	interfaceFormatMultiple = 2
+
+	// headerProtocolEthernet is the header protocol for Ethernet frames
+	headerProtocolEthernet = 1
+	// headerProtocolPPP is the header protocol for PPP frames
+	headerProtocolPPP = 7
+	// headerProtocolIPv4 is the header protocol for IPv4 packets
+	headerProtocolIPv4 = 11
+	// headerProtocolIPv6 is the header protocol for IPv6 packets
+	headerProtocolIPv6 = 12
+	// headerProtocolPOS is the header protocol for Packet over SONET/SDH
+	headerProtocolPOS = 14
 )
 
 // Decoder contains the state for the sFlow v5 decoder.
@@ -34,20 +52,36 @@ type Decoder struct {
 	d         decoder.Dependencies
 	errLogger reporter.Logger
 
+	decapsulate decoder.Decapsulation
+
+	// Interfaces tracked for interface counters, per exporter
+	interfaceCountersLimit uint
+	interfaceCountersLock  sync.Mutex
+	interfaceCounters      map[string]map[uint32]struct{}
+
 	metrics struct {
-		errors                *reporter.CounterVec
-		stats                 *reporter.CounterVec
-		sampleRecordsStatsSum *reporter.CounterVec
-		sampleStatsSum        *reporter.CounterVec
+		errors                   *reporter.CounterVec
+		stats                    *reporter.CounterVec
+		sampleRecordsStatsSum    *reporter.CounterVec
+		sampleStatsSum           *reporter.CounterVec
+		interfaceOctets          *reporter.GaugeVec
+		interfaceErrors          *reporter.GaugeVec
+		interfaceDiscards        *reporter.GaugeVec
+		interfaceCountersDropped *reporter.CounterVec
+		discardedPackets         *reporter.CounterVec
+		sampledHeaders           *reporter.CounterVec
 	}
 }
 
 // New instantiates a new sFlow decoder.
-func New(r *reporter.Reporter, dependencies decoder.Dependencies, _ decoder.Option) decoder.Decoder {
+func New(r *reporter.Reporter, dependencies decoder.Dependencies, option decoder.Option) decoder.Decoder {
 	nd := &Decoder{
-		r:         r,
-		d:         dependencies,
-		errLogger: r.Sample(reporter.BurstSampler(30*time.Second, 3)),
+		r:                      r,
+		d:                      dependencies,
+		errLogger:              r.Sample(reporter.BurstSampler(30*time.Second, 3)),
+		decapsulate:            option.Decapsulation(),
+		interfaceCountersLimit: option.InterfaceCountersLimit,
+		interfaceCounters:      map[string]map[uint32]struct{}{},
 	}
 
 	nd.metrics.errors = nd.r.CounterVec(
@@ -78,14 +112,61 @@ func New(r *reporter.Reporter, dependencies decoder.Dependencies, _ decoder.Opti
 		},
 		[]string{"exporter", "agent", "version", "type"},
 	)
+	nd.metrics.interfaceOctets = nd.r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "interface_octets",
+			Help: "Octets counter for interfaces from sFlow counter samples.",
+		},
+		[]string{"exporter", "ifindex", "direction"},
+	)
+	nd.metrics.interfaceErrors = nd.r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "interface_errors",
+			Help: "Errors counter for interfaces from sFlow counter samples.",
+		},
+		[]string{"exporter", "ifindex", "direction"},
+	)
+	nd.metrics.interfaceDiscards = nd.r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "interface_discards",
+			Help: "Discards counter for interfaces from sFlow counter samples.",
+		},
+		[]string{"exporter", "ifindex", "direction"},
+	)
+	nd.metrics.interfaceCountersDropped = nd.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "interface_counters_dropped_total",
+			Help: "sFlows interface counters dropped due to the limit of interfaces per exporter.",
+		},
+		[]string{"exporter"},
+	)
+	nd.metrics.discardedPackets = nd.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "discarded_packets_total",
+			Help: "sFlows dropped packet notifications.",
+		},
+		[]string{"exporter", "reason"},
+	)
+	nd.metrics.sampledHeaders = nd.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "sampled_headers_total",
+			Help: "sFlows sampled headers by header protocol.",
+		},
+		[]string{"exporter", "protocol"},
+	)
 
 	return nd
 }
 
 // Decode decodes an sFlow payload.
 func (nd *Decoder) Decode(in decoder.RawFlow) []*schema.FlowMessage {
-	buf := bytes.NewBuffer(in.Payload)
 	key := in.Source.String()
+	payload, discarded, invalid := extractDiscardedPackets(in.Payload)
+	if invalid > 0 {
+		nd.metrics.errors.WithLabelValues(key, "sFlow dropped packet decoding error").
+			Add(float64(invalid))
+	}
+	buf := bytes.NewBuffer(payload)
 
 	ts := uint64(in.TimeReceived.UTC().Unix())
 	var packet sflow.Packet
@@ -113,14 +194,27 @@ func (nd *Decoder) Decode(in decoder.RawFlow) []*schema.FlowMessage {
 			nd.metrics.sampleRecordsStatsSum.WithLabelValues(key, agent, version, "ExpandedFlowSample").
 				Add(float64(len(sConv.Records)))
 		case sflow.CounterSample:
-			nd.metrics.sampleStatsSum.WithLabelValues(key, agent, version, "CounterSample").
+			sampleType := "CounterSample"
+			if sConv.Header.Format == 4 {
+				sampleType = "ExpandedCounterSample"
+			}
+			nd.metrics.sampleStatsSum.WithLabelValues(key, agent, version, sampleType).
 				Inc()
-			nd.metrics.sampleRecordsStatsSum.WithLabelValues(key, agent, version, "CounterSample").
+			nd.metrics.sampleRecordsStatsSum.WithLabelValues(key, agent, version, sampleType).
 				Add(float64(len(sConv.Records)))
+			if nd.interfaceCountersLimit > 0 {
+				nd.decodeCounters(key, sConv)
+			}
 		}
 	}
 
-	flowMessageSet := nd.decode(packet)
+	if len(discarded) > 0 {
+		nd.metrics.sampleStatsSum.WithLabelValues(key, agent, version, "DiscardedPacket").
+			Add(float64(len(discarded)))
+	}
+
+	flowMessageSet := nd.decode(key, packet)
+	flowMessageSet = append(flowMessageSet, nd.decodeDiscarded(key, packet.AgentIP, discarded)...)
 	for _, fmsg := range flowMessageSet {
 		fmsg.TimeReceived = ts
 	}
@@ -62,7 +62,6 @@ func TestDecode(t *testing.T) {
 			DstAS:           39421,
 			SrcNetMask:      20,
 			DstNetMask:      27,
-			GotASPath:       false,
 			ProtobufDebug: map[schema.ColumnKey]interface{}{
 				schema.ColumnBytes:        421,
 				schema.ColumnPackets:      1,
@@ -112,7 +111,8 @@ func TestDecode(t *testing.T) {
 			NextHop:         netip.MustParseAddr("::ffff:31.14.69.110"),
 			SrcNetMask:      27,
 			DstNetMask:      17,
-			GotASPath:       true,
+			DstASPath:       []uint32{203698, 6762, 26615},
+			DstCommunities:  []uint32{2583495656, 2583495657, 4259880000, 4259880001, 4259900001},
 			ProtobufDebug: map[schema.ColumnKey]interface{}{
 				schema.ColumnBytes:        40,
 				schema.ColumnPackets:      1,
@@ -122,7 +122,6 @@ func TestDecode(t *testing.T) {
 				schema.ColumnDstPort:      5555,
 				schema.ColumnSrcMAC:       138617863011056,
 				schema.ColumnDstMAC:       216372595274807,
-				schema.ColumnDstASPath:    []uint32{203698, 6762, 26615},
 				schema.ColumnIPFragmentID: 0xd431,
 				schema.ColumnIPTTL:        255,
 				schema.ColumnTCPFlags:     0x2,
@@ -305,7 +304,8 @@ func TestDecodeSamples(t *testing.T) {
 				SrcAS:           203476,
 				DstAS:           203361,
 				SrcVlan:         809,
-				GotASPath:       true,
+				DstASPath:       []uint32{8218, 29605, 203361},
+				DstCommunities:  []uint32{538574949, 1911619684, 1911669584, 1911671290},
 				SrcNetMask:      32,
 				DstNetMask:      22,
 				ProtobufDebug: map[schema.ColumnKey]interface{}{
@@ -315,7 +315,6 @@ func TestDecodeSamples(t *testing.T) {
 					schema.ColumnProto:        6,
 					schema.ColumnSrcPort:      22,
 					schema.ColumnDstPort:      52237,
-					schema.ColumnDstASPath:    []uint32{8218, 29605, 203361},
 					schema.ColumnTCPFlags:     0x18,
 					schema.ColumnIPFragmentID: 0xab4e,
 					schema.ColumnIPTTL:        61,
@@ -334,6 +333,64 @@ func TestDecodeSamples(t *testing.T) {
 		}
 	})
 
+	t.Run("expanded flow sample with discarded traffic", func(t *testing.T) {
+		// An expanded flow sample whose output interface format tells the
+		// packet was discarded and an expanded counter sample.
+		data := helpers.ReadPcapL4(t, filepath.Join("testdata", "data-sflow-expanded-discard.pcap"))
+		r := reporter.NewMock(t)
+		sdecoder := New(r, decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()},
+			decoder.Option{InterfaceCountersLimit: 10})
+		got := sdecoder.Decode(decoder.RawFlow{Payload: data, Source: net.ParseIP("127.0.0.1")})
+		if got == nil {
+			t.Fatalf("Decode() error on data")
+		}
+		expectedFlows := []*schema.FlowMessage{
+			{
+				SamplingRate:    2048,
+				InIf:            10,
+				OutIf:           0, // discarded
+				SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.1"),
+				DstAddr:         netip.MustParseAddr("::ffff:203.0.113.10"),
+				ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.100"),
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnBytes:            64,
+					schema.ColumnPackets:          1,
+					schema.ColumnEType:            helpers.ETypeIPv4,
+					schema.ColumnProto:            17,
+					schema.ColumnSrcPort:          12345,
+					schema.ColumnDstPort:          53,
+					schema.ColumnForwardingStatus: 128,
+					schema.ColumnIPFragmentID:     0x1234,
+					schema.ColumnIPTTL:            64,
+				},
+			},
+		}
+		for _, f := range got {
+			f.TimeReceived = 0
+		}
+
+		if diff := helpers.Diff(got, expectedFlows); diff != "" {
+			t.Fatalf("Decode() (-got, +want):\n%s", diff)
+		}
+
+		gotMetrics := r.GetMetrics(
+			"akvorado_inlet_flow_decoder_sflow_",
+			"interface_octets",
+			"sample_",
+		)
+		expectedMetrics := map[string]string{
+			`interface_octets{direction="in",exporter="127.0.0.1",ifindex="10"}`:                                    "1000",
+			`interface_octets{direction="out",exporter="127.0.0.1",ifindex="10"}`:                                   "2000",
+			`sample_records_sum{agent="192.0.2.100",exporter="127.0.0.1",type="ExpandedCounterSample",version="5"}`: "1",
+			`sample_records_sum{agent="192.0.2.100",exporter="127.0.0.1",type="ExpandedFlowSample",version="5"}`:    "1",
+			`sample_sum{agent="192.0.2.100",exporter="127.0.0.1",type="ExpandedCounterSample",version="5"}`:         "1",
+			`sample_sum{agent="192.0.2.100",exporter="127.0.0.1",type="ExpandedFlowSample",version="5"}`:            "1",
+		}
+		if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+			t.Fatalf("Metrics after data (-got, +want):\n%s", diff)
+		}
+	})
+
 	t.Run("flow sample with IPv4 data", func(t *testing.T) {
 		// Send data
 		data := helpers.ReadPcapL4(t, filepath.Join("testdata", "data-sflow-ipv4-data.pcap"))
@@ -350,7 +407,6 @@ func TestDecodeSamples(t *testing.T) {
 				SrcAddr:         netip.MustParseAddr("::ffff:50.50.50.50"),
 				DstAddr:         netip.MustParseAddr("::ffff:51.51.51.51"),
 				ExporterAddress: netip.MustParseAddr("::ffff:49.49.49.49"),
-				GotASPath:       false,
 				ProtobufDebug: map[schema.ColumnKey]interface{}{
 					schema.ColumnBytes:        1344,
 					schema.ColumnPackets:      1,
@@ -388,7 +444,6 @@ func TestDecodeSamples(t *testing.T) {
 				SrcAddr:         netip.MustParseAddr("::ffff:69.58.92.107"),
 				DstAddr:         netip.MustParseAddr("::ffff:92.222.186.1"),
 				ExporterAddress: netip.MustParseAddr("::ffff:172.19.64.116"),
-				GotASPath:       false,
 				ProtobufDebug: map[schema.ColumnKey]interface{}{
 					schema.ColumnBytes:        32,
 					schema.ColumnPackets:      1,
@@ -405,7 +460,6 @@ func TestDecodeSamples(t *testing.T) {
 				SrcAddr:         netip.MustParseAddr("::ffff:69.58.92.107"),
 				DstAddr:         netip.MustParseAddr("::ffff:92.222.184.1"),
 				ExporterAddress: netip.MustParseAddr("::ffff:172.19.64.116"),
-				GotASPath:       false,
 				ProtobufDebug: map[schema.ColumnKey]interface{}{
 					schema.ColumnBytes:        32,
 					schema.ColumnPackets:      1,
@@ -438,7 +492,6 @@ func TestDecodeSamples(t *testing.T) {
 				SrcAddr:         netip.MustParseAddr("::ffff:203.0.113.4"),
 				DstAddr:         netip.MustParseAddr("::ffff:203.0.113.5"),
 				ExporterAddress: netip.MustParseAddr("::ffff:127.0.0.1"),
-				GotASPath:       false,
 				ProtobufDebug: map[schema.ColumnKey]interface{}{
 					schema.ColumnBytes:      84,
 					schema.ColumnPackets:    1,
@@ -474,7 +527,6 @@ func TestDecodeSamples(t *testing.T) {
 				SrcAddr:         netip.MustParseAddr("fe80::d05b:45ff:feee:5ecf"),
 				DstAddr:         netip.MustParseAddr("2001:db8::"),
 				ExporterAddress: netip.MustParseAddr("::ffff:127.0.0.1"),
-				GotASPath:       false,
 				ProtobufDebug: map[schema.ColumnKey]interface{}{
 					schema.ColumnBytes:      72,
 					schema.ColumnPackets:    1,
@@ -512,7 +564,6 @@ func TestDecodeSamples(t *testing.T) {
 				SrcAddr:         netip.MustParseAddr("::ffff:49.49.49.2"),
 				DstAddr:         netip.MustParseAddr("::ffff:49.49.49.109"),
 				ExporterAddress: netip.MustParseAddr("::ffff:172.17.128.58"),
-				GotASPath:       false,
 				ProtobufDebug: map[schema.ColumnKey]interface{}{
 					schema.ColumnBytes:        80,
 					schema.ColumnPackets:      1,
@@ -525,6 +576,7 @@ func TestDecodeSamples(t *testing.T) {
 					schema.ColumnTCPFlags:     16,
 					schema.ColumnSrcPort:      32017,
 					schema.ColumnDstPort:      443,
+					schema.ColumnOuterVlan:    1062,
 				},
 			},
 		}
@@ -537,3 +589,262 @@ func TestDecodeSamples(t *testing.T) {
 		}
 	})
 }
+
+func TestDecodeCounters(t *testing.T) {
+	r := reporter.NewMock(t)
+	sdecoder := New(r, decoder.Dependencies{Schema: schema.NewMock(t)},
+		decoder.Option{InterfaceCountersLimit: 1})
+
+	// Two counter samples for two interfaces. Only the first one should be
+	// exported due to the limit.
+	data := helpers.ReadPcapL4(t, filepath.Join("testdata", "data-counters.pcap"))
+	got := sdecoder.Decode(decoder.RawFlow{Payload: data, Source: net.ParseIP("127.0.0.1")})
+	if got == nil {
+		t.Fatalf("Decode() error on data")
+	}
+	if len(got) != 0 {
+		t.Fatalf("Decode() returned %d flows instead of 0", len(got))
+	}
+
+	gotMetrics := r.GetMetrics(
+		"akvorado_inlet_flow_decoder_sflow_",
+		"interface_",
+		"sample_",
+	)
+	expectedMetrics := map[string]string{
+		`interface_counters_dropped_total{exporter="127.0.0.1"}`:                                        "1",
+		`interface_discards{direction="in",exporter="127.0.0.1",ifindex="10"}`:                          "3",
+		`interface_discards{direction="out",exporter="127.0.0.1",ifindex="10"}`:                         "4",
+		`interface_errors{direction="in",exporter="127.0.0.1",ifindex="10"}`:                            "1",
+		`interface_errors{direction="out",exporter="127.0.0.1",ifindex="10"}`:                           "2",
+		`interface_octets{direction="in",exporter="127.0.0.1",ifindex="10"}`:                            "1000",
+		`interface_octets{direction="out",exporter="127.0.0.1",ifindex="10"}`:                           "2000",
+		`sample_records_sum{agent="192.0.2.100",exporter="127.0.0.1",type="CounterSample",version="5"}`: "2",
+		`sample_sum{agent="192.0.2.100",exporter="127.0.0.1",type="CounterSample",version="5"}`:         "2",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestDecodeDiscardedPackets(t *testing.T) {
+	r := reporter.NewMock(t)
+	sdecoder := New(r, decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()}, decoder.Option{})
+
+	// A flow sample, a dropped packet notification with a sampled header
+	// (ACL), a dropped packet notification without any record and with an
+	// unknown reason, and a truncated dropped packet notification.
+	data := helpers.ReadPcapL4(t, filepath.Join("testdata", "data-discarded-packets.pcap"))
+	got := sdecoder.Decode(decoder.RawFlow{Payload: data, Source: net.ParseIP("127.0.0.1")})
+	if got == nil {
+		t.Fatalf("Decode() error on data")
+	}
+	expectedFlows := []*schema.FlowMessage{
+		{
+			SamplingRate:    1024,
+			SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.1"),
+			DstAddr:         netip.MustParseAddr("::ffff:203.0.113.1"),
+			ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.100"),
+			InIf:            3,
+			OutIf:           4,
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:        40,
+				schema.ColumnPackets:      1,
+				schema.ColumnEType:        helpers.ETypeIPv4,
+				schema.ColumnProto:        6,
+				schema.ColumnSrcMAC:       0x02000000000b,
+				schema.ColumnDstMAC:       0x02000000000a,
+				schema.ColumnIPTTL:        64,
+				schema.ColumnIPFragmentID: 7,
+				schema.ColumnTCPFlags:     2,
+				schema.ColumnSrcPort:      34567,
+				schema.ColumnDstPort:      443,
+			},
+		}, {
+			SamplingRate:    1,
+			SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.2"),
+			DstAddr:         netip.MustParseAddr("::ffff:203.0.113.2"),
+			ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.100"),
+			InIf:            27,
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:            40,
+				schema.ColumnPackets:          1,
+				schema.ColumnForwardingStatus: 128,
+				schema.ColumnDropReason:       []byte("acl"),
+				schema.ColumnEType:            helpers.ETypeIPv4,
+				schema.ColumnProto:            6,
+				schema.ColumnSrcMAC:           0x02000000000b,
+				schema.ColumnDstMAC:           0x02000000000a,
+				schema.ColumnIPTTL:            64,
+				schema.ColumnIPFragmentID:     7,
+				schema.ColumnTCPFlags:         2,
+				schema.ColumnSrcPort:          45678,
+				schema.ColumnDstPort:          22,
+			},
+		}, {
+			SamplingRate:    1,
+			ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.100"),
+			InIf:            28,
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnPackets:          1,
+				schema.ColumnForwardingStatus: 128,
+				schema.ColumnDropReason:       []byte("unknown"),
+			},
+		},
+	}
+	for _, f := range got {
+		f.TimeReceived = 0
+	}
+	if diff := helpers.Diff(got, expectedFlows); diff != "" {
+		t.Fatalf("Decode() (-got, +want):\n%s", diff)
+	}
+
+	gotMetrics := r.GetMetrics(
+		"akvorado_inlet_flow_decoder_sflow_",
+		"discarded_",
+		"errors_",
+		"sample_",
+	)
+	expectedMetrics := map[string]string{
+		`discarded_packets_total{exporter="127.0.0.1",reason="acl"}`:                                 "1",
+		`discarded_packets_total{exporter="127.0.0.1",reason="unknown"}`:                             "1",
+		`errors_total{error="sFlow dropped packet decoding error",exporter="127.0.0.1"}`:             "1",
+		`sample_records_sum{agent="192.0.2.100",exporter="127.0.0.1",type="FlowSample",version="5"}`: "1",
+		`sample_sum{agent="192.0.2.100",exporter="127.0.0.1",type="DiscardedPacket",version="5"}`:    "2",
+		`sample_sum{agent="192.0.2.100",exporter="127.0.0.1",type="FlowSample",version="5"}`:         "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestDecodeDecapsulatedWithSampledIPv4(t *testing.T) {
+	r := reporter.NewMock(t)
+	sdecoder := New(r, decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()},
+		decoder.Option{Decapsulate: true})
+
+	// A flow sample with a sampled header (VXLAN) followed by a sampled IPv4
+	// record describing the outer packet.
+	data := helpers.ReadPcapL4(t, filepath.Join("testdata", "data-vxlan-sampled-ipv4.pcap"))
+	got := sdecoder.Decode(decoder.RawFlow{Payload: data, Source: net.ParseIP("127.0.0.1")})
+	if got == nil {
+		t.Fatalf("Decode() error on data")
+	}
+	expectedFlows := []*schema.FlowMessage{
+		{
+			SamplingRate:    1024,
+			InIf:            3,
+			OutIf:           4,
+			SrcAddr:         netip.MustParseAddr("::ffff:10.0.0.1"),
+			DstAddr:         netip.MustParseAddr("::ffff:10.0.0.2"),
+			ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.100"),
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:        90,
+				schema.ColumnPackets:      1,
+				schema.ColumnEType:        helpers.ETypeIPv4,
+				schema.ColumnProto:        6,
+				schema.ColumnSrcPort:      34567,
+				schema.ColumnDstPort:      443,
+				schema.ColumnTCPFlags:     2,
+				schema.ColumnIPTTL:        63,
+				schema.ColumnIPFragmentID: 0x4242,
+				schema.ColumnSrcMAC:       0x020000000002,
+				schema.ColumnDstMAC:       0x020000000001,
+				schema.ColumnOuterSrcAddr: netip.MustParseAddr("::ffff:192.0.2.1"),
+				schema.ColumnOuterDstAddr: netip.MustParseAddr("::ffff:192.0.2.2"),
+			},
+		},
+	}
+	for _, f := range got {
+		f.TimeReceived = 0
+	}
+	if diff := helpers.Diff(got, expectedFlows); diff != "" {
+		t.Fatalf("Decode() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestDecodeHeaderProtocols(t *testing.T) {
+	r := reporter.NewMock(t)
+	sdecoder := New(r, decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()}, decoder.Option{})
+
+	cases := []struct {
+		Description string
+		Pcap        string
+		Expected    *schema.FlowMessage
+	}{
+		{
+			Description: "IPv6",
+			Pcap:        "data-sflow-raw-ipv6.pcap",
+			Expected: &schema.FlowMessage{
+				SamplingRate:    1024,
+				InIf:            3,
+				OutIf:           4,
+				SrcAddr:         netip.MustParseAddr("2001:db8::1"),
+				DstAddr:         netip.MustParseAddr("2001:db8::2"),
+				ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.100"),
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnBytes:         60,
+					schema.ColumnPackets:       1,
+					schema.ColumnEType:         helpers.ETypeIPv6,
+					schema.ColumnProto:         6,
+					schema.ColumnSrcPort:       443,
+					schema.ColumnDstPort:       51234,
+					schema.ColumnTCPFlags:      0x12,
+					schema.ColumnIPTos:         0x20,
+					schema.ColumnIPTTL:         63,
+					schema.ColumnIPv6FlowLabel: 0x12345,
+				},
+			},
+		}, {
+			Description: "PPP",
+			Pcap:        "data-sflow-ppp.pcap",
+			Expected: &schema.FlowMessage{
+				SamplingRate:    1024,
+				InIf:            3,
+				OutIf:           4,
+				SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.1"),
+				DstAddr:         netip.MustParseAddr("::ffff:203.0.113.10"),
+				ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.100"),
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnBytes:        32,
+					schema.ColumnPackets:      1,
+					schema.ColumnEType:        helpers.ETypeIPv4,
+					schema.ColumnProto:        17,
+					schema.ColumnSrcPort:      12345,
+					schema.ColumnDstPort:      53,
+					schema.ColumnIPTTL:        64,
+					schema.ColumnIPFragmentID: 0x4321,
+				},
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			data := helpers.ReadPcapL4(t, filepath.Join("testdata", tc.Pcap))
+			got := sdecoder.Decode(decoder.RawFlow{Payload: data, Source: net.ParseIP("127.0.0.1")})
+			for _, f := range got {
+				f.TimeReceived = 0
+			}
+			if diff := helpers.Diff(got, []*schema.FlowMessage{tc.Expected}); diff != "" {
+				t.Fatalf("Decode() (-got, +want):\n%s", diff)
+			}
+		})
+	}
+
+	// The raw IPv4 capture contains two samples
+	data := helpers.ReadPcapL4(t, filepath.Join("testdata", "data-sflow-raw-ipv4.pcap"))
+	sdecoder.Decode(decoder.RawFlow{Payload: data, Source: net.ParseIP("127.0.0.1")})
+
+	gotMetrics := r.GetMetrics(
+		"akvorado_inlet_flow_decoder_sflow_",
+		"sampled_headers_",
+	)
+	expectedMetrics := map[string]string{
+		`sampled_headers_total{exporter="127.0.0.1",protocol="ipv4"}`: "2",
+		`sampled_headers_total{exporter="127.0.0.1",protocol="ipv6"}`: "1",
+		`sampled_headers_total{exporter="127.0.0.1",protocol="ppp"}`:  "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
@@ -0,0 +1,198 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package decoder
+
+import (
+	"encoding/binary"
+
+	"akvorado/common/helpers"
+	"akvorado/common/schema"
+)
+
+// Decapsulation is the set of tunnels to decapsulate when parsing raw packets.
+type Decapsulation uint8
+
+const (
+	// DecapsulateTunnels decapsulates VXLAN and GRE tunnels.
+	DecapsulateTunnels Decapsulation = 1 << iota
+	// DecapsulateGTP decapsulates GTP-U tunnels.
+	DecapsulateGTP
+)
+
+// Decapsulation returns the set of tunnels to decapsulate from the options.
+func (o Option) Decapsulation() Decapsulation {
+	var decapsulate Decapsulation
+	if o.Decapsulate {
+		decapsulate |= DecapsulateTunnels
+	}
+	if o.DecapsulateGTP {
+		decapsulate |= DecapsulateGTP
+	}
+	return decapsulate
+}
+
+const (
+	vxlanPort              = 4789
+	greTransparentEthernet = 0x6558
+	gtpuPort               = 2152
+	gtpuMessageGPDU        = 0xff
+)
+
+// decapsulateTunnel checks if the provided L4 payload is one of the tunnels to
+// decapsulate. In this case, it returns the inner IP packet and its EtherType.
+// The inner packet is only returned if its IP header is complete, so it is safe
+// to parse it without falling back to the outer packet. For GTP-U, the TEID is
+// recorded in the flow.
+func decapsulateTunnel(sch *schema.Component, bf *schema.FlowMessage, proto uint8, data []byte, decapsulate Decapsulation) ([]byte, uint16, bool) {
+	switch proto {
+	case 17:
+		if decapsulate&DecapsulateGTP != 0 {
+			if inner, etherType, teid, ok := decapsulateGTP(data); ok {
+				sch.ProtobufAppendVarint(bf, schema.ColumnGTPTEID, uint64(teid))
+				return inner, etherType, true
+			}
+		}
+		if decapsulate&DecapsulateTunnels == 0 {
+			return nil, 0, false
+		}
+		// VXLAN: UDP header, then VXLAN header with the I flag set
+		if len(data) < 16 || binary.BigEndian.Uint16(data[2:4]) != vxlanPort {
+			return nil, 0, false
+		}
+		if data[8]&0x08 == 0 {
+			return nil, 0, false
+		}
+		return innerEthernet(data[16:])
+	case 47:
+		// GRE (RFC 2784 and RFC 2890), only version 0
+		if decapsulate&DecapsulateTunnels == 0 {
+			return nil, 0, false
+		}
+		if len(data) < 4 || data[1]&0x7 != 0 {
+			return nil, 0, false
+		}
+		offset := 4
+		if data[0]&0x80 != 0 {
+			// Checksum
+			offset += 4
+		}
+		if data[0]&0x20 != 0 {
+			// Key
+			offset += 4
+		}
+		if data[0]&0x10 != 0 {
+			// Sequence number
+			offset += 4
+		}
+		if len(data) < offset {
+			return nil, 0, false
+		}
+		etherType := binary.BigEndian.Uint16(data[2:4])
+		if etherType == greTransparentEthernet {
+			return innerEthernet(data[offset:])
+		}
+		return innerIP(data[offset:], etherType)
+	}
+	return nil, 0, false
+}
+
+// decapsulateGTP checks if the provided UDP datagram is a GTP-U packet
+// carrying user data (3GPP TS 29.281). In this case, it returns the inner IP
+// packet, its EtherType and the TEID.
+func decapsulateGTP(data []byte) ([]byte, uint16, uint32, bool) {
+	// UDP header, then GTP header: version 1, protocol type GTP, G-PDU
+	if len(data) < 16 || binary.BigEndian.Uint16(data[2:4]) != gtpuPort {
+		return nil, 0, 0, false
+	}
+	gtp := data[8:]
+	if gtp[0]>>5 != 1 || gtp[0]&0x10 == 0 || gtp[1] != gtpuMessageGPDU {
+		return nil, 0, 0, false
+	}
+	teid := binary.BigEndian.Uint32(gtp[4:8])
+	offset := 8
+	if gtp[0]&0x07 != 0 {
+		// When any of the E, S, or PN flags is set, the sequence number, the
+		// N-PDU number and the next extension header type are present.
+		if len(gtp) < 12 {
+			return nil, 0, 0, false
+		}
+		offset = 12
+		var next byte
+		if gtp[0]&0x04 != 0 {
+			next = gtp[11]
+		}
+		for next != 0 {
+			// Extension header: length in 4-octet units, content, next
+			// extension header type
+			if len(gtp) < offset+1 {
+				return nil, 0, 0, false
+			}
+			length := int(gtp[offset]) * 4
+			if length == 0 || len(gtp) < offset+length {
+				return nil, 0, 0, false
+			}
+			next = gtp[offset+length-1]
+			offset += length
+		}
+	}
+	if len(gtp) <= offset {
+		return nil, 0, 0, false
+	}
+	var etherType uint16
+	switch gtp[offset] >> 4 {
+	case 4:
+		etherType = helpers.ETypeIPv4
+	case 6:
+		etherType = helpers.ETypeIPv6
+	default:
+		return nil, 0, 0, false
+	}
+	inner, etherType, ok := innerIP(gtp[offset:], etherType)
+	return inner, etherType, teid, ok
+}
+
+// innerEthernet skips the Ethernet header (and VLAN tags) of an inner packet.
+func innerEthernet(data []byte) ([]byte, uint16, bool) {
+	if len(data) < 14 {
+		return nil, 0, false
+	}
+	etherType := binary.BigEndian.Uint16(data[12:14])
+	data = data[14:]
+	for isVLANEtherType(etherType) {
+		if len(data) < 4 {
+			return nil, 0, false
+		}
+		etherType = binary.BigEndian.Uint16(data[2:4])
+		data = data[4:]
+	}
+	return innerIP(data, etherType)
+}
+
+// innerIP checks the IP header of an inner packet is complete.
+func innerIP(data []byte, etherType uint16) ([]byte, uint16, bool) {
+	switch etherType {
+	case helpers.ETypeIPv4:
+		if len(data) < 20 || data[0]>>4 != 4 || data[0]&0xf < 5 {
+			return nil, 0, false
+		}
+	case helpers.ETypeIPv6:
+		if len(data) < 40 || data[0]>>4 != 6 {
+			return nil, 0, false
+		}
+	default:
+		return nil, 0, false
+	}
+	return data, etherType, true
+}
+
+// parseInnerIP parses a decapsulated IP packet. Nested tunnels are not
+// decapsulated.
+func parseInnerIP(sch *schema.Component, bf *schema.FlowMessage, data []byte, etherType uint16) {
+	switch etherType {
+	case helpers.ETypeIPv4:
+		ParseIPv4(sch, bf, data, 0)
+	case helpers.ETypeIPv6:
+		ParseIPv6(sch, bf, data, 0)
+	}
+}
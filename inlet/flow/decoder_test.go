@@ -5,17 +5,122 @@ package flow
 
 import (
 	"net"
+	"net/netip"
 	"path/filepath"
 	"testing"
 
+	"akvorado/common/daemon"
 	"akvorado/common/helpers"
+	"akvorado/common/httpserver"
 	"akvorado/common/reporter"
 	"akvorado/common/schema"
 	"akvorado/inlet/flow/decoder"
 	"akvorado/inlet/flow/decoder/netflow"
 	"akvorado/inlet/flow/decoder/sflow"
+	"akvorado/inlet/flow/input/udp"
 )
 
+// recordingDecoder returns a single flow and records the raw flow received.
+type recordingDecoder struct {
+	last decoder.RawFlow
+}
+
+func (rd *recordingDecoder) Decode(in decoder.RawFlow) []*schema.FlowMessage {
+	rd.last = in
+	exporterAddress, _ := netip.AddrFromSlice(in.Source.To16())
+	return []*schema.FlowMessage{{
+		ExporterAddress: exporterAddress,
+		SamplingRate:    10,
+		SrcAS:           64500,
+		DstAS:           64501,
+		DstASPath:       []uint32{64502, 64501},
+	}}
+}
+
+func (rd *recordingDecoder) Name() string {
+	return "recording"
+}
+
+func TestExporterOverrides(t *testing.T) {
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration()
+	config.Inputs = []InputConfiguration{{
+		Decoder: "netflow",
+		Config:  &udp.Configuration{Listen: []string{"127.0.0.1:0"}, QueueSize: 10},
+	}}
+	netflowPacket := decoder.TimestampSourceNetflowPacket
+	config.ExporterOverrides = *helpers.MustNewSubnetMap(map[string]ExporterOverride{
+		"::ffff:192.0.2.0/120":   {SamplingRate: 100, IgnoreASN: true},
+		"::ffff:192.0.2.128/121": {SamplingRate: 1000},
+		"::ffff:192.0.2.200/128": {TimestampSource: &netflowPacket},
+	})
+	c, err := New(r, config, Dependencies{
+		Daemon: daemon.NewMock(t),
+		HTTP:   httpserver.NewMock(t, r),
+		Schema: schema.NewMock(t),
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+
+	cases := []struct {
+		Description     string
+		Source          string
+		Expected        *schema.FlowMessage
+		TimestampSource *decoder.TimestampSource
+	}{
+		{
+			Description: "no override",
+			Source:      "203.0.113.1",
+			Expected: &schema.FlowMessage{
+				SamplingRate: 10,
+				SrcAS:        64500,
+				DstAS:        64501,
+				DstASPath:    []uint32{64502, 64501},
+			},
+		}, {
+			Description: "less specific subnet",
+			Source:      "192.0.2.10",
+			Expected: &schema.FlowMessage{
+				SamplingRate: 100,
+			},
+		}, {
+			Description: "more specific subnet",
+			Source:      "192.0.2.130",
+			Expected: &schema.FlowMessage{
+				SamplingRate: 1000,
+				SrcAS:        64500,
+				DstAS:        64501,
+				DstASPath:    []uint32{64502, 64501},
+			},
+		}, {
+			Description: "timestamp source",
+			Source:      "192.0.2.200",
+			Expected: &schema.FlowMessage{
+				SamplingRate: 10,
+				SrcAS:        64500,
+				DstAS:        64501,
+				DstASPath:    []uint32{64502, 64501},
+			},
+			TimestampSource: &netflowPacket,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			rd := &recordingDecoder{}
+			wd := c.wrapDecoder(rd, false)
+			got := wd.Decode(decoder.RawFlow{Payload: []byte("hello"), Source: net.ParseIP(tc.Source)})
+			tc.Expected.ExporterAddress = netip.MustParseAddr("::ffff:" + tc.Source)
+			if diff := helpers.Diff(got, []*schema.FlowMessage{tc.Expected}); diff != "" {
+				t.Fatalf("Decode() (-got, +want):\n%s", diff)
+			}
+			if diff := helpers.Diff(rd.last.TimestampSource, tc.TimestampSource); diff != "" {
+				t.Fatalf("Decode() timestamp source (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
+
 // The goal is to benchmark flow decoding + encoding to protobuf
 
 func BenchmarkDecodeEncodeNetflow(b *testing.B) {
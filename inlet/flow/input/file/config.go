@@ -7,11 +7,21 @@ import "akvorado/inlet/flow/input"
 
 // Configuration describes file input configuration.
 type Configuration struct {
-	// Paths to use as input
+	// Paths to use as input. Each file is either a PCAP file or a raw
+	// payload.
 	Paths []string `validate:"min=1,dive,required"`
+	// Loop tells if the files should be read again once all of them have
+	// been read.
+	Loop bool
+	// RateMultiplier tells how fast packets from PCAP files should be
+	// replayed relatively to the original capture. 0 means as fast as
+	// possible.
+	RateMultiplier float64 `validate:"min=0"`
 }
 
 // DefaultConfiguration descrives the default configuration for file input.
 func DefaultConfiguration() input.Configuration {
-	return &Configuration{}
+	return &Configuration{
+		Loop: true,
+	}
 }
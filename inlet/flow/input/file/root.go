@@ -5,11 +5,16 @@
 package file
 
 import (
+	"bytes"
 	"errors"
+	"io"
 	"net"
 	"os"
 	"time"
 
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
 	"gopkg.in/tomb.v2"
 
 	"akvorado/common/daemon"
@@ -29,6 +34,19 @@ type Input struct {
 	decoder decoder.Decoder
 }
 
+// pcapReader is implemented by both PCAP and PCAPNG readers.
+type pcapReader interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+}
+
+var (
+	// errDying is returned when the input is stopped while reading a file.
+	errDying = errors.New("dying")
+	// errNoPayload is returned when looping over files without any payload.
+	errNoPayload = errors.New("no payload in files")
+)
+
 // New instantiate a new UDP listener from the provided configuration.
 func (configuration *Configuration) New(r *reporter.Reporter, daemon daemon.Component, dec decoder.Decoder) (input.Input, error) {
 	if len(configuration.Paths) == 0 {
@@ -48,32 +66,131 @@ func (configuration *Configuration) New(r *reporter.Reporter, daemon daemon.Comp
 func (in *Input) Start() (<-chan []*schema.FlowMessage, error) {
 	in.r.Info().Msg("file input starting")
 	in.t.Go(func() error {
-		for idx := 0; true; idx++ {
+		payloads := 0
+		for idx := 0; in.config.Loop || idx < len(in.config.Paths); idx++ {
+			select {
+			case <-in.t.Dying():
+				return nil
+			default:
+			}
 			path := in.config.Paths[idx%len(in.config.Paths)]
 			data, err := os.ReadFile(path)
 			if err != nil {
 				in.r.Err(err).Str("path", path).Msg("unable to read path")
 				return err
 			}
-			flows := in.decoder.Decode(decoder.RawFlow{
-				TimeReceived: time.Now(),
-				Payload:      data,
-				Source:       net.ParseIP("127.0.0.1"),
-			})
-			if len(flows) == 0 {
-				continue
+			n, err := in.readFile(path, data)
+			if err != nil {
+				if errors.Is(err, errDying) {
+					return nil
+				}
+				in.r.Err(err).Str("path", path).Msg("unable to read PCAP file")
+				return err
 			}
-			select {
-			case <-in.t.Dying():
-				return nil
-			case in.ch <- flows:
+			payloads += n
+			// Do not loop forever over files without any payload.
+			if idx%len(in.config.Paths) == len(in.config.Paths)-1 {
+				if payloads == 0 {
+					in.r.Error().Msg("no payload found in files")
+					return errNoPayload
+				}
+				payloads = 0
 			}
 		}
+		// Do not stop the tomb, this would stop the inlet.
+		in.r.Info().Msg("file input finished")
+		<-in.t.Dying()
 		return nil
 	})
 	return in.ch, nil
 }
 
+// readFile sends the content of a file to the decoder. PCAP files are
+// replayed packet by packet. Other files are considered as a raw payload. It
+// returns the number of payloads read.
+func (in *Input) readFile(path string, data []byte) (int, error) {
+	var reader pcapReader
+	if r, err := pcapgo.NewReader(bytes.NewReader(data)); err == nil {
+		reader = r
+	} else if r, err := pcapgo.NewNgReader(bytes.NewReader(data), pcapgo.DefaultNgReaderOptions); err == nil {
+		reader = r
+	} else {
+		return 1, in.send(decoder.RawFlow{
+			TimeReceived: time.Now(),
+			Payload:      data,
+			Source:       net.ParseIP("127.0.0.1"),
+		})
+	}
+
+	var previous time.Time
+	payloads, skipped := 0, 0
+	for {
+		data, ci, err := reader.ReadPacketData()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return payloads, err
+		}
+		packet := gopacket.NewPacket(data, reader.LinkType(), gopacket.DecodeOptions{
+			Lazy:   true,
+			NoCopy: true,
+		})
+		var source net.IP
+		switch l := packet.NetworkLayer().(type) {
+		case *layers.IPv4:
+			source = l.SrcIP
+		case *layers.IPv6:
+			source = l.SrcIP
+		}
+		udp, ok := packet.TransportLayer().(*layers.UDP)
+		if source == nil || !ok {
+			skipped++
+			continue
+		}
+
+		// Respect the original timing
+		if in.config.RateMultiplier > 0 && !previous.IsZero() {
+			delay := time.Duration(float64(ci.Timestamp.Sub(previous)) / in.config.RateMultiplier)
+			if delay > 0 {
+				select {
+				case <-in.t.Dying():
+					return payloads, errDying
+				case <-time.After(delay):
+				}
+			}
+		}
+		previous = ci.Timestamp
+
+		if err := in.send(decoder.RawFlow{
+			TimeReceived: time.Now(),
+			Payload:      udp.Payload,
+			Source:       source,
+		}); err != nil {
+			return payloads, err
+		}
+		payloads++
+	}
+	if skipped > 0 {
+		in.r.Warn().Str("path", path).Msgf("skipped %d packets without UDP payload", skipped)
+	}
+	return payloads, nil
+}
+
+// send decodes the provided payload and sends the flows.
+func (in *Input) send(raw decoder.RawFlow) error {
+	flows := in.decoder.Decode(raw)
+	if len(flows) == 0 {
+		return nil
+	}
+	select {
+	case <-in.t.Dying():
+		return errDying
+	case in.ch <- flows:
+	}
+	return nil
+}
+
 // Stop stops the UDP listeners
 func (in *Input) Stop() error {
 	defer func() {
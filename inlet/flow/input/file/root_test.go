@@ -4,10 +4,16 @@
 package file
 
 import (
+	"errors"
+	"fmt"
+	"os"
 	"path"
 	"testing"
 	"time"
 
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
 	"akvorado/common/daemon"
 	"akvorado/common/helpers"
 	"akvorado/common/reporter"
@@ -53,3 +59,122 @@ out:
 		t.Fatalf("Input data (-got, +want):\n%s", diff)
 	}
 }
+
+func TestPcapInput(t *testing.T) {
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.Paths = []string{path.Join("testdata", "flows.pcap")}
+	configuration.Loop = false
+	in, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{
+		Schema: schema.NewMock(t),
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	ch, err := in.Start()
+	if err != nil {
+		t.Fatalf("Start() error:\n%+v", err)
+	}
+	defer func() {
+		if err := in.Stop(); err != nil {
+			t.Fatalf("Stop() error:\n%+v", err)
+		}
+	}()
+
+	// Get it back, only once as we do not loop. The TCP packet is skipped.
+	expected := []string{
+		"192.0.2.1: hello world!\n",
+		"192.0.2.2: bye bye\n",
+	}
+	got := []string{}
+out:
+	for range len(expected) + 1 {
+		select {
+		case got1 := <-ch:
+			for _, fl := range got1 {
+				got = append(got, fmt.Sprintf("%s: %s",
+					fl.ExporterAddress.Unmap(),
+					fl.ProtobufDebug[schema.ColumnInIfDescription].([]byte)))
+			}
+		case <-time.After(50 * time.Millisecond):
+			break out
+		}
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("Input data (-got, +want):\n%s", diff)
+	}
+}
+
+func TestPcapRateMultiplier(t *testing.T) {
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.Paths = []string{path.Join("testdata", "flows.pcap")}
+	configuration.RateMultiplier = 10
+	in, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{
+		Schema: schema.NewMock(t),
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	ch, err := in.Start()
+	if err != nil {
+		t.Fatalf("Start() error:\n%+v", err)
+	}
+	defer func() {
+		if err := in.Stop(); err != nil {
+			t.Fatalf("Stop() error:\n%+v", err)
+		}
+	}()
+
+	// Packets are one second apart in the capture
+	select {
+	case <-ch:
+	case <-time.After(50 * time.Millisecond):
+		t.Fatal("no decoded flows received")
+	}
+	now := time.Now()
+	select {
+	case <-ch:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("no decoded flows received")
+	}
+	if elapsed := time.Since(now); elapsed < 90*time.Millisecond {
+		t.Fatalf("second flow received after %s, expected 100ms", elapsed)
+	}
+}
+
+func TestPcapInputLoopWithoutPayload(t *testing.T) {
+	// Empty PCAP file
+	pcapPath := path.Join(t.TempDir(), "empty.pcap")
+	f, err := os.Create(pcapPath)
+	if err != nil {
+		t.Fatalf("Create() error:\n%+v", err)
+	}
+	if err := pcapgo.NewWriter(f).WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		t.Fatalf("WriteFileHeader() error:\n%+v", err)
+	}
+	f.Close()
+
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.Paths = []string{pcapPath}
+	in, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{
+		Schema: schema.NewMock(t),
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	if _, err := in.Start(); err != nil {
+		t.Fatalf("Start() error:\n%+v", err)
+	}
+
+	// We should not loop forever.
+	select {
+	case <-in.(*Input).t.Dead():
+	case <-time.After(time.Second):
+		t.Fatal("file input still running")
+	}
+	if err := in.Stop(); !errors.Is(err, errNoPayload) {
+		t.Fatalf("Stop() error:\n%+v", err)
+	}
+}
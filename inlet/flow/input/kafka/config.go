@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package kafka
+
+import (
+	"errors"
+	"time"
+
+	"akvorado/common/helpers/bimap"
+	"akvorado/common/kafka"
+	"akvorado/inlet/flow/input"
+)
+
+// Configuration describes Kafka input configuration.
+type Configuration struct {
+	kafka.Configuration `mapstructure:",squash" yaml:"-,inline"`
+	// ConsumerGroup is the name of the consumer group to join. Inlets
+	// sharing the same consumer group share the partitions of the topic.
+	ConsumerGroup string `validate:"required"`
+	// InitialOffset tells where to start consuming when there is no
+	// committed offset for the consumer group.
+	InitialOffset InitialOffset
+	// CommitInterval tells how often offsets of processed messages are
+	// committed.
+	CommitInterval time.Duration `validate:"min=100ms"`
+	// ExporterHeader is the name of the header containing the exporter
+	// address for raw messages.
+	ExporterHeader string `validate:"required"`
+	// PartitionLagMetrics enables the export of the lag for each partition.
+	PartitionLagMetrics bool
+	// QueueSize defines the size of the channel used to
+	// communicate incoming flows. 0 can be used to disable
+	// buffering.
+	QueueSize uint
+}
+
+// DefaultConfiguration is the default configuration for this input
+func DefaultConfiguration() input.Configuration {
+	config := kafka.DefaultConfiguration()
+	config.Topic = "flows-raw"
+	return &Configuration{
+		Configuration:       config,
+		ConsumerGroup:       "akvorado-inlet",
+		InitialOffset:       InitialOffsetNewest,
+		CommitInterval:      time.Second,
+		ExporterHeader:      "exporter",
+		PartitionLagMetrics: true,
+		QueueSize:           1000,
+	}
+}
+
+// InitialOffset defines where to start consuming without a committed offset.
+type InitialOffset int
+
+const (
+	// InitialOffsetNewest starts with the newest messages
+	InitialOffsetNewest InitialOffset = iota
+	// InitialOffsetOldest starts with the oldest available messages
+	InitialOffsetOldest
+)
+
+var initialOffsetMap = bimap.New(map[InitialOffset]string{
+	InitialOffsetNewest: "newest",
+	InitialOffsetOldest: "oldest",
+})
+
+// MarshalText turns an initial offset to text
+func (o InitialOffset) MarshalText() ([]byte, error) {
+	got, ok := initialOffsetMap.LoadValue(o)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown initial offset")
+}
+
+// String turns an initial offset to string
+func (o InitialOffset) String() string {
+	got, _ := initialOffsetMap.LoadValue(o)
+	return got
+}
+
+// UnmarshalText provides an initial offset from text
+func (o *InitialOffset) UnmarshalText(input []byte) error {
+	got, ok := initialOffsetMap.LoadKey(string(input))
+	if ok {
+		*o = got
+		return nil
+	}
+	return errors.New("unknown initial offset")
+}
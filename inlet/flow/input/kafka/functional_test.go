@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package kafka
+
+import (
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/kafka"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
+)
+
+func TestRealKafka(t *testing.T) {
+	client, brokers := kafka.SetupKafkaBroker(t)
+
+	// Send a message
+	topicName := fmt.Sprintf("test-topic-%d", rand.Int())
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		t.Fatalf("NewSyncProducerFromClient() error:\n%+v", err)
+	}
+	defer producer.Close()
+	if _, _, err := producer.SendMessage(&sarama.ProducerMessage{
+		Topic: topicName,
+		Headers: []sarama.RecordHeader{
+			{Key: []byte("exporter"), Value: []byte("192.0.2.1")},
+		},
+		Value: sarama.ByteEncoder("hello world!"),
+	}); err != nil {
+		t.Fatalf("SendMessage() error:\n%+v", err)
+	}
+
+	// Consume it
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.Topic = topicName
+	configuration.Brokers = brokers
+	configuration.ConsumerGroup = fmt.Sprintf("test-group-%d", rand.Int())
+	configuration.InitialOffset = InitialOffsetOldest
+	in, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{Schema: schema.NewMock(t)})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	ch, err := in.Start()
+	if err != nil {
+		t.Fatalf("Start() error:\n%+v", err)
+	}
+	defer func() {
+		if err := in.Stop(); err != nil {
+			t.Fatalf("Stop() error:\n%+v", err)
+		}
+	}()
+
+	select {
+	case got := <-ch:
+		if len(got) != 1 {
+			t.Fatalf("received %d flows, expected 1", len(got))
+		}
+		if diff := helpers.Diff(got[0].ExporterAddress, netip.MustParseAddr("::ffff:192.0.2.1")); diff != "" {
+			t.Fatalf("ExporterAddress (-got, +want):\n%s", diff)
+		}
+	case <-time.After(20 * time.Second):
+		t.Fatal("no flow received")
+	}
+}
@@ -0,0 +1,261 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Package kafka handles flows received from Kafka.
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+	"gopkg.in/tomb.v2"
+
+	"akvorado/common/daemon"
+	"akvorado/common/kafka"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
+	"akvorado/inlet/flow/input"
+)
+
+// Input represents the state of a Kafka consumer.
+type Input struct {
+	r      *reporter.Reporter
+	t      tomb.Tomb
+	config *Configuration
+
+	metrics struct {
+		messages     *reporter.CounterVec
+		bytes        *reporter.CounterVec
+		errors       *reporter.CounterVec
+		decodedFlows *reporter.CounterVec
+		lag          *reporter.GaugeVec
+	}
+
+	kafkaConfig         *sarama.Config
+	createConsumerGroup func() (sarama.ConsumerGroup, error)
+	ch                  chan []*schema.FlowMessage // channel to send flows to
+	decoder             decoder.Decoder            // decoder to use
+	raw                 bool                       // messages are raw datagrams
+	errLogger           reporter.Logger
+}
+
+// New instantiate a new Kafka consumer from the provided configuration.
+func (configuration *Configuration) New(r *reporter.Reporter, daemon daemon.Component, dec decoder.Decoder) (input.Input, error) {
+	kafkaConfig, err := kafka.NewConfig(r, configuration.Configuration)
+	if err != nil {
+		return nil, err
+	}
+	kafkaConfig.Consumer.Return.Errors = true
+	kafkaConfig.Consumer.Offsets.AutoCommit.Enable = true
+	kafkaConfig.Consumer.Offsets.AutoCommit.Interval = configuration.CommitInterval
+	switch configuration.InitialOffset {
+	case InitialOffsetOldest:
+		kafkaConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
+	default:
+		kafkaConfig.Consumer.Offsets.Initial = sarama.OffsetNewest
+	}
+	if err := kafkaConfig.Validate(); err != nil {
+		return nil, fmt.Errorf("cannot validate Kafka configuration: %w", err)
+	}
+
+	input := &Input{
+		r:           r,
+		config:      configuration,
+		kafkaConfig: kafkaConfig,
+		ch:          make(chan []*schema.FlowMessage, configuration.QueueSize),
+		decoder:     dec,
+		raw:         dec.Name() != "protobuf",
+		errLogger:   r.Sample(reporter.BurstSampler(time.Minute, 1)),
+	}
+	input.createConsumerGroup = func() (sarama.ConsumerGroup, error) {
+		return sarama.NewConsumerGroup(configuration.Brokers, configuration.ConsumerGroup, kafkaConfig)
+	}
+
+	input.metrics.messages = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "messages_total",
+			Help: "Messages received by the application.",
+		},
+		[]string{"topic"},
+	)
+	input.metrics.bytes = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "bytes_total",
+			Help: "Bytes received by the application.",
+		},
+		[]string{"topic"},
+	)
+	input.metrics.errors = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "errors_total",
+			Help: "Errors while receiving messages by the application.",
+		},
+		[]string{"topic", "error"},
+	)
+	input.metrics.decodedFlows = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "decoded_flows_total",
+			Help: "Number of flows decoded and written to the internal queue",
+		},
+		[]string{"topic"},
+	)
+	input.metrics.lag = r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "partition_lag_messages",
+			Help: "Number of messages not yet consumed for each partition.",
+		},
+		[]string{"topic", "partition"},
+	)
+
+	daemon.Track(&input.t, "inlet/flow/input/kafka")
+	return input, nil
+}
+
+// Start starts consuming the Kafka topic and producing flows.
+func (in *Input) Start() (<-chan []*schema.FlowMessage, error) {
+	in.r.Info().Str("topic", in.config.Topic).Msg("starting Kafka input")
+	group, err := in.createConsumerGroup()
+	if err != nil {
+		in.r.Err(err).
+			Str("brokers", strings.Join(in.config.Brokers, ",")).
+			Msg("unable to create consumer group")
+		return nil, fmt.Errorf("unable to create Kafka consumer group: %w", err)
+	}
+
+	// Consume messages. Consume() returns on rebalance.
+	in.t.Go(func() error {
+		defer group.Close()
+		ctx := in.t.Context(context.Background())
+		for {
+			if err := group.Consume(ctx, []string{in.config.Topic}, in); err != nil {
+				if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+					return nil
+				}
+				in.errLogger.Err(err).Str("topic", in.config.Topic).Msg("unable to consume from Kafka")
+				in.metrics.errors.WithLabelValues(in.config.Topic, "consume error").Inc()
+				select {
+				case <-in.t.Dying():
+					return nil
+				case <-time.After(time.Second):
+				}
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+		}
+	})
+
+	// Report errors
+	in.t.Go(func() error {
+		for {
+			select {
+			case <-in.t.Dying():
+				return nil
+			case err, ok := <-group.Errors():
+				if !ok {
+					<-in.t.Dying()
+					return nil
+				}
+				in.errLogger.Err(err).Str("topic", in.config.Topic).Msg("Kafka consumer error")
+				in.metrics.errors.WithLabelValues(in.config.Topic, "consumer error").Inc()
+			}
+		}
+	})
+
+	return in.ch, nil
+}
+
+// Setup is called at the beginning of a new session.
+func (in *Input) Setup(session sarama.ConsumerGroupSession) error {
+	in.r.Info().Str("topic", in.config.Topic).
+		Interface("claims", session.Claims()).
+		Msg("Kafka consumer group session started")
+	return nil
+}
+
+// Cleanup is called at the end of a session.
+func (in *Input) Cleanup(session sarama.ConsumerGroupSession) error {
+	for topic, partitions := range session.Claims() {
+		for _, partition := range partitions {
+			in.metrics.lag.DeleteLabelValues(topic, strconv.Itoa(int(partition)))
+		}
+	}
+	return nil
+}
+
+// ConsumeClaim consumes messages from a partition. Offsets are marked once
+// flows are handed to the flow component.
+func (in *Input) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	topic := claim.Topic()
+	partition := strconv.Itoa(int(claim.Partition()))
+	for {
+		select {
+		case <-session.Context().Done():
+			return nil
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			in.metrics.messages.WithLabelValues(topic).Inc()
+			in.metrics.bytes.WithLabelValues(topic).Add(float64(len(msg.Value)))
+			if flows := in.decodeMessage(msg); len(flows) > 0 {
+				select {
+				case <-session.Context().Done():
+					return nil
+				case in.ch <- flows:
+					in.metrics.decodedFlows.WithLabelValues(topic).Add(float64(len(flows)))
+				}
+			}
+			session.MarkMessage(msg, "")
+			if in.config.PartitionLagMetrics {
+				in.metrics.lag.WithLabelValues(topic, partition).Set(
+					float64(claim.HighWaterMarkOffset() - msg.Offset - 1))
+			}
+		}
+	}
+}
+
+// decodeMessage decodes the flows contained in a Kafka message. For raw
+// messages, the exporter address is extracted from the configured header.
+func (in *Input) decodeMessage(msg *sarama.ConsumerMessage) []*schema.FlowMessage {
+	timeReceived := msg.Timestamp
+	if timeReceived.IsZero() {
+		timeReceived = time.Now()
+	}
+	var source net.IP
+	if in.raw {
+		for _, header := range msg.Headers {
+			if header != nil && string(header.Key) == in.config.ExporterHeader {
+				source = net.ParseIP(string(header.Value))
+				break
+			}
+		}
+		if source == nil {
+			in.errLogger.Error().Str("topic", msg.Topic).Msg("missing or invalid exporter header")
+			in.metrics.errors.WithLabelValues(msg.Topic, "missing exporter").Inc()
+			return nil
+		}
+	}
+	return in.decoder.Decode(decoder.RawFlow{
+		TimeReceived: timeReceived,
+		Payload:      msg.Value,
+		Source:       source,
+	})
+}
+
+// Stop stops the Kafka consumer.
+func (in *Input) Stop() error {
+	defer func() {
+		close(in.ch)
+		in.r.Info().Msg("Kafka input stopped")
+	}()
+	in.t.Kill(nil)
+	return in.t.Wait()
+}
@@ -0,0 +1,130 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package kafka
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
+)
+
+// fakeSession implements sarama.ConsumerGroupSession
+type fakeSession struct {
+	ctx    context.Context
+	marked []int64
+}
+
+func (s *fakeSession) Claims() map[string][]int32               { return map[string][]int32{"flows-raw": {3}} }
+func (s *fakeSession) MemberID() string                         { return "member" }
+func (s *fakeSession) GenerationID() int32                      { return 1 }
+func (s *fakeSession) MarkOffset(string, int32, int64, string)  {}
+func (s *fakeSession) Commit()                                  {}
+func (s *fakeSession) ResetOffset(string, int32, int64, string) {}
+func (s *fakeSession) Context() context.Context                 { return s.ctx }
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, _ string) {
+	s.marked = append(s.marked, msg.Offset)
+}
+
+// fakeClaim implements sarama.ConsumerGroupClaim
+type fakeClaim struct {
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *fakeClaim) Topic() string                            { return "flows-raw" }
+func (c *fakeClaim) Partition() int32                         { return 3 }
+func (c *fakeClaim) InitialOffset() int64                     { return 0 }
+func (c *fakeClaim) HighWaterMarkOffset() int64               { return 10 }
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+func TestConsumeClaim(t *testing.T) {
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration().(*Configuration)
+	in, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{Schema: schema.NewMock(t)})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	input := in.(*Input)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	session := &fakeSession{ctx: ctx}
+	claim := &fakeClaim{messages: make(chan *sarama.ConsumerMessage, 10)}
+	claim.messages <- &sarama.ConsumerMessage{
+		Topic:     "flows-raw",
+		Partition: 3,
+		Offset:    6,
+		Timestamp: time.Unix(1700000000, 0),
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte("exporter"), Value: []byte("192.0.2.1")},
+		},
+		Value: []byte("hello world!"),
+	}
+	claim.messages <- &sarama.ConsumerMessage{
+		Topic:     "flows-raw",
+		Partition: 3,
+		Offset:    7,
+		Value:     []byte("no exporter"),
+	}
+	close(claim.messages)
+	if err := input.ConsumeClaim(session, claim); err != nil {
+		t.Fatalf("ConsumeClaim() error:\n%+v", err)
+	}
+	cancel()
+
+	// Both messages are processed
+	if diff := helpers.Diff(session.marked, []int64{6, 7}); diff != "" {
+		t.Fatalf("MarkMessage() (-got, +want):\n%s", diff)
+	}
+
+	// Only one has been decoded
+	got := <-input.ch
+	expected := []*schema.FlowMessage{
+		{
+			TimeReceived:    1700000000,
+			ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.1"),
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:           12,
+				schema.ColumnPackets:         1,
+				schema.ColumnInIfDescription: []byte("hello world!"),
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("ConsumeClaim() (-got, +want):\n%s", diff)
+	}
+	select {
+	case got := <-input.ch:
+		t.Fatalf("ConsumeClaim() produced unexpected flows: %v", got)
+	default:
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_input_kafka_")
+	expectedMetrics := map[string]string{
+		`bytes_total{topic="flows-raw"}`:                           "23",
+		`decoded_flows_total{topic="flows-raw"}`:                   "1",
+		`errors_total{error="missing exporter",topic="flows-raw"}`: "1",
+		`messages_total{topic="flows-raw"}`:                        "2",
+		`partition_lag_messages{partition="3",topic="flows-raw"}`:  "2",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+
+	// Lag metrics are removed on cleanup
+	if err := input.Cleanup(session); err != nil {
+		t.Fatalf("Cleanup() error:\n%+v", err)
+	}
+	gotMetrics = r.GetMetrics("akvorado_inlet_flow_input_kafka_", "partition_")
+	if diff := helpers.Diff(gotMetrics, map[string]string{}); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
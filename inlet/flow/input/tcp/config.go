@@ -0,0 +1,36 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package tcp
+
+import (
+	"akvorado/common/helpers"
+	"akvorado/inlet/flow/input"
+)
+
+// Configuration describes TCP input configuration.
+type Configuration struct {
+	// Listen tells which addresses to listen to.
+	Listen []string `validate:"min=1,dive,listen"`
+	// QueueSize defines the size of the channel used to
+	// communicate incoming flows. 0 can be used to disable
+	// buffering.
+	QueueSize uint
+	// TLS defines the TLS configuration. When enabled, a certificate and a
+	// key are required. When a CA is provided and the verification is
+	// enabled, exporters have to present a client certificate signed by
+	// this CA.
+	TLS helpers.TLSConfiguration
+}
+
+// DefaultConfiguration is the default configuration for this input
+func DefaultConfiguration() input.Configuration {
+	return &Configuration{
+		Listen:    []string{":0"},
+		QueueSize: 100000,
+		TLS: helpers.TLSConfiguration{
+			Enable: false,
+			Verify: true,
+		},
+	}
+}
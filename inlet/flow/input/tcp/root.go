@@ -0,0 +1,304 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Package tcp handles TCP listeners for IPFIX.
+package tcp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"gopkg.in/tomb.v2"
+
+	"akvorado/common/daemon"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
+	"akvorado/inlet/flow/input"
+)
+
+const (
+	// ipfixVersion is the version in the header of IPFIX messages
+	ipfixVersion = 10
+	// ipfixHeaderLength is the length of the header of IPFIX messages
+	ipfixHeaderLength = 16
+)
+
+// Input represents the state of a TCP listener.
+type Input struct {
+	r         *reporter.Reporter
+	t         tomb.Tomb
+	config    *Configuration
+	tlsConfig *tls.Config
+
+	metrics struct {
+		connections       *reporter.CounterVec
+		activeConnections *reporter.GaugeVec
+		bytes             *reporter.CounterVec
+		packets           *reporter.CounterVec
+		errors            *reporter.CounterVec
+		outDrops          *reporter.CounterVec
+		decodedFlows      *reporter.CounterVec
+	}
+
+	addresses []net.Addr                 // listening addresses, for testing purpose
+	ch        chan []*schema.FlowMessage // channel to send flows to
+	decoder   decoder.Decoder            // decoder to use
+
+	connsLock sync.Mutex
+	conns     map[net.Conn]struct{} // active connections
+}
+
+// New instantiate a new TCP listener from the provided configuration.
+func (configuration *Configuration) New(r *reporter.Reporter, daemon daemon.Component, dec decoder.Decoder) (input.Input, error) {
+	input := &Input{
+		r:       r,
+		config:  configuration,
+		ch:      make(chan []*schema.FlowMessage, configuration.QueueSize),
+		decoder: dec,
+		conns:   map[net.Conn]struct{}{},
+	}
+	if configuration.TLS.Enable {
+		if configuration.TLS.CertFile == "" {
+			return nil, errors.New("a certificate is required for TLS")
+		}
+		tlsConfig, err := configuration.TLS.MakeTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		// The CA is used to check client certificates
+		if tlsConfig.RootCAs != nil {
+			tlsConfig.ClientCAs = tlsConfig.RootCAs
+			tlsConfig.RootCAs = nil
+			if configuration.TLS.Verify {
+				tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+		}
+		tlsConfig.InsecureSkipVerify = false
+		input.tlsConfig = tlsConfig
+	}
+
+	input.metrics.connections = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "connections_total",
+			Help: "Connections accepted by the application.",
+		},
+		[]string{"listener", "exporter"},
+	)
+	input.metrics.activeConnections = r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "active_connections",
+			Help: "Number of active connections.",
+		},
+		[]string{"listener"},
+	)
+	input.metrics.bytes = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "bytes_total",
+			Help: "Bytes received by the application.",
+		},
+		[]string{"listener", "exporter"},
+	)
+	input.metrics.packets = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "packets_total",
+			Help: "Messages received by the application.",
+		},
+		[]string{"listener", "exporter"},
+	)
+	input.metrics.errors = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "errors_total",
+			Help: "Errors while receiving messages by the application.",
+		},
+		[]string{"listener", "error"},
+	)
+	input.metrics.outDrops = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "out_dropped_packets_total",
+			Help: "Dropped messages due to internal queue full.",
+		},
+		[]string{"listener", "exporter"},
+	)
+	input.metrics.decodedFlows = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "decoded_flows_total",
+			Help: "Number of flows decoded and written to the internal queue",
+		},
+		[]string{"listener", "exporter"},
+	)
+
+	daemon.Track(&input.t, "inlet/flow/input/tcp")
+	return input, nil
+}
+
+// Start starts listening to the provided TCP sockets and producing flows.
+func (in *Input) Start() (<-chan []*schema.FlowMessage, error) {
+	in.r.Info().Strs("listen", in.config.Listen).Msg("starting TCP input")
+
+	listeners := []net.Listener{}
+	in.addresses = make([]net.Addr, len(in.config.Listen))
+	for idx, listen := range in.config.Listen {
+		ln, err := net.Listen("tcp", listen)
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return nil, fmt.Errorf("unable to listen to %v: %w", listen, err)
+		}
+		in.addresses[idx] = ln.Addr()
+		in.r.Info().Str("listen", in.addresses[idx].String()).Msg("TCP input listening")
+		if in.tlsConfig != nil {
+			ln = tls.NewListener(ln, in.tlsConfig)
+		}
+		listeners = append(listeners, ln)
+	}
+
+	for idx, ln := range listeners {
+		listen := in.config.Listen[idx]
+		in.t.Go(func() error {
+			errLogger := in.r.With().Str("listen", listen).Logger().
+				Sample(reporter.BurstSampler(time.Minute, 1))
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					if errors.Is(err, net.ErrClosed) {
+						return nil
+					}
+					errLogger.Err(err).Msg("unable to accept TCP connection")
+					in.metrics.errors.WithLabelValues(listen, "accept error").Inc()
+					continue
+				}
+				in.connsLock.Lock()
+				in.conns[conn] = struct{}{}
+				in.connsLock.Unlock()
+				in.t.Go(func() error {
+					in.handleConnection(listen, conn)
+					return nil
+				})
+			}
+		})
+	}
+
+	// Watch for termination and close on dying
+	in.t.Go(func() error {
+		<-in.t.Dying()
+		for _, ln := range listeners {
+			ln.Close()
+		}
+		in.connsLock.Lock()
+		for conn := range in.conns {
+			conn.Close()
+		}
+		in.connsLock.Unlock()
+		return nil
+	})
+
+	return in.ch, nil
+}
+
+// handleConnection reads IPFIX messages from a connection until it is closed.
+// The state kept by the decoder for this connection is discarded afterwards.
+func (in *Input) handleConnection(listen string, conn net.Conn) {
+	remote, _ := conn.RemoteAddr().(*net.TCPAddr)
+	if remote == nil {
+		conn.Close()
+		return
+	}
+	source := remote.IP
+	session := remote.String()
+	srcIP := source.String()
+	l := in.r.With().
+		Str("listen", listen).
+		Str("exporter", session).
+		Logger()
+	errLogger := l.Sample(reporter.BurstSampler(time.Minute, 1))
+
+	in.metrics.connections.WithLabelValues(listen, srcIP).Inc()
+	in.metrics.activeConnections.WithLabelValues(listen).Inc()
+	l.Debug().Msg("new TCP connection")
+	defer func() {
+		conn.Close()
+		in.connsLock.Lock()
+		delete(in.conns, conn)
+		in.connsLock.Unlock()
+		in.metrics.activeConnections.WithLabelValues(listen).Dec()
+		if sd, ok := in.decoder.(decoder.SessionDecoder); ok {
+			sd.CloseSession(source, session)
+		}
+		l.Debug().Msg("TCP connection closed")
+	}()
+
+	reader := bufio.NewReader(conn)
+	header := make([]byte, 4)
+	for {
+		// Each IPFIX message starts with its version and its length.
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				errLogger.Err(err).Msg("unable to read IPFIX message header")
+				in.metrics.errors.WithLabelValues(listen, "read error").Inc()
+			}
+			return
+		}
+		version := binary.BigEndian.Uint16(header[0:2])
+		length := int(binary.BigEndian.Uint16(header[2:4]))
+		if version != ipfixVersion || length < ipfixHeaderLength {
+			errLogger.Error().Msgf("invalid IPFIX message header (version %d, length %d)",
+				version, length)
+			in.metrics.errors.WithLabelValues(listen, "invalid header").Inc()
+			return
+		}
+		// Decoders may keep a reference to the payload: use a new buffer
+		// for each message.
+		payload := make([]byte, length)
+		copy(payload, header)
+		if _, err := io.ReadFull(reader, payload[4:]); err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				errLogger.Err(err).Msg("unable to read IPFIX message")
+				in.metrics.errors.WithLabelValues(listen, "read error").Inc()
+			}
+			return
+		}
+
+		in.metrics.bytes.WithLabelValues(listen, srcIP).Add(float64(length))
+		in.metrics.packets.WithLabelValues(listen, srcIP).Inc()
+		flows := in.decoder.Decode(decoder.RawFlow{
+			TimeReceived: time.Now(),
+			Payload:      payload,
+			Source:       source,
+			Session:      session,
+		})
+		if len(flows) == 0 {
+			continue
+		}
+		select {
+		case <-in.t.Dying():
+			return
+		case in.ch <- flows:
+			in.metrics.decodedFlows.WithLabelValues(listen, srcIP).
+				Add(float64(len((flows))))
+		default:
+			errLogger.Warn().Msgf("dropping flow due to queue full (size %d)",
+				in.config.QueueSize)
+			in.metrics.outDrops.WithLabelValues(listen, srcIP).Inc()
+		}
+	}
+}
+
+// Stop stops the TCP listeners
+func (in *Input) Stop() error {
+	l := in.r.With().Strs("listen", in.config.Listen).Logger()
+	defer func() {
+		close(in.ch)
+		l.Info().Msg("TCP listener stopped")
+	}()
+	in.t.Kill(nil)
+	return in.t.Wait()
+}
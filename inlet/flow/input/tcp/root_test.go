@@ -0,0 +1,258 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package tcp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
+)
+
+// ipfixMessage builds a fake IPFIX message with the provided content.
+func ipfixMessage(content string) []byte {
+	length := ipfixHeaderLength + len(content)
+	message := make([]byte, ipfixHeaderLength, length)
+	message[1] = ipfixVersion
+	message[2] = byte(length >> 8)
+	message[3] = byte(length)
+	return append(message, content...)
+}
+
+func startInput(t *testing.T, r *reporter.Reporter, configuration *Configuration) (*Input, <-chan []*schema.FlowMessage) {
+	t.Helper()
+	in, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{Schema: schema.NewMock(t)})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	ch, err := in.Start()
+	if err != nil {
+		t.Fatalf("Start() error:\n%+v", err)
+	}
+	t.Cleanup(func() {
+		if err := in.Stop(); err != nil {
+			t.Fatalf("Stop() error:\n%+v", err)
+		}
+	})
+	return in.(*Input), ch
+}
+
+func receiveFlows(t *testing.T, ch <-chan []*schema.FlowMessage, content string) {
+	t.Helper()
+	var got []*schema.FlowMessage
+	select {
+	case got = <-ch:
+		if len(got) == 0 {
+			t.Fatalf("empty decoded flows received")
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("no decoded flows received")
+	}
+	expected := []*schema.FlowMessage{
+		{
+			TimeReceived:    got[0].TimeReceived,
+			ExporterAddress: netip.MustParseAddr("::ffff:127.0.0.1"),
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:           ipfixHeaderLength + len(content),
+				schema.ColumnPackets:         1,
+				schema.ColumnInIfDescription: ipfixMessage(content),
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("Input data (-got, +want):\n%s", diff)
+	}
+}
+
+func TestTCPInput(t *testing.T) {
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.Listen = []string{"127.0.0.1:0"}
+	in, ch := startInput(t, r, configuration)
+
+	conn, err := net.Dial("tcp", in.addresses[0].String())
+	if err != nil {
+		t.Fatalf("Dial() error:\n%+v", err)
+	}
+
+	// Send a first message in several parts, then two messages at once
+	first := ipfixMessage("hello world!")
+	if _, err := conn.Write(first[:10]); err != nil {
+		t.Fatalf("Write() error:\n%+v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, err := conn.Write(first[10:]); err != nil {
+		t.Fatalf("Write() error:\n%+v", err)
+	}
+	receiveFlows(t, ch, "hello world!")
+	second := append(ipfixMessage("hello"), ipfixMessage("world")...)
+	if _, err := conn.Write(second); err != nil {
+		t.Fatalf("Write() error:\n%+v", err)
+	}
+	receiveFlows(t, ch, "hello")
+	receiveFlows(t, ch, "world")
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_input_tcp_")
+	expectedMetrics := map[string]string{
+		`active_connections{listener="127.0.0.1:0"}`:                       "1",
+		`bytes_total{exporter="127.0.0.1",listener="127.0.0.1:0"}`:         "70",
+		`connections_total{exporter="127.0.0.1",listener="127.0.0.1:0"}`:   "1",
+		`decoded_flows_total{exporter="127.0.0.1",listener="127.0.0.1:0"}`: "3",
+		`packets_total{exporter="127.0.0.1",listener="127.0.0.1:0"}`:       "3",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Input metrics (-got, +want):\n%s", diff)
+	}
+
+	// Close the connection
+	conn.Close()
+	time.Sleep(20 * time.Millisecond)
+	gotMetrics = r.GetMetrics("akvorado_inlet_flow_input_tcp_", "active_connections")
+	expectedMetrics = map[string]string{
+		`active_connections{listener="127.0.0.1:0"}`: "0",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Input metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestInvalidHeader(t *testing.T) {
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.Listen = []string{"127.0.0.1:0"}
+	in, _ := startInput(t, r, configuration)
+
+	conn, err := net.Dial("tcp", in.addresses[0].String())
+	if err != nil {
+		t.Fatalf("Dial() error:\n%+v", err)
+	}
+	defer conn.Close()
+
+	// NetFlow v9 is not accepted
+	message := ipfixMessage("hello world!")
+	message[1] = 9
+	if _, err := conn.Write(message); err != nil {
+		t.Fatalf("Write() error:\n%+v", err)
+	}
+
+	// The connection should be closed
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Read() did not error")
+	}
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_input_tcp_", "errors_total", "packets_total")
+	expectedMetrics := map[string]string{
+		`errors_total{error="invalid header",listener="127.0.0.1:0"}`: "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Input metrics (-got, +want):\n%s", diff)
+	}
+}
+
+// generateCertificate generates a self-signed certificate and returns the
+// path to the PEM file containing both the certificate and the key.
+func generateCertificate(t *testing.T) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error:\n%+v", err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error:\n%+v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey() error:\n%+v", err)
+	}
+	content := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	content = append(content, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})...)
+	path := filepath.Join(t.TempDir(), "cert.pem")
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatalf("WriteFile() error:\n%+v", err)
+	}
+	return path
+}
+
+func TestTLSInput(t *testing.T) {
+	r := reporter.NewMock(t)
+	certFile := generateCertificate(t)
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.Listen = []string{"127.0.0.1:0"}
+	configuration.TLS = helpers.TLSConfiguration{
+		Enable:   true,
+		Verify:   true,
+		CAFile:   certFile,
+		CertFile: certFile,
+	}
+	in, ch := startInput(t, r, configuration)
+
+	clientConfig, err := configuration.TLS.MakeTLSConfig()
+	if err != nil {
+		t.Fatalf("MakeTLSConfig() error:\n%+v", err)
+	}
+
+	t.Run("with client certificate", func(t *testing.T) {
+		conn, err := tls.Dial("tcp", in.addresses[0].String(), clientConfig)
+		if err != nil {
+			t.Fatalf("Dial() error:\n%+v", err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write(ipfixMessage("hello world!")); err != nil {
+			t.Fatalf("Write() error:\n%+v", err)
+		}
+		receiveFlows(t, ch, "hello world!")
+	})
+
+	t.Run("without client certificate", func(t *testing.T) {
+		config := clientConfig.Clone()
+		config.Certificates = nil
+		conn, err := tls.Dial("tcp", in.addresses[0].String(), config)
+		if err != nil {
+			t.Fatalf("Dial() error:\n%+v", err)
+		}
+		defer conn.Close()
+		conn.Write(ipfixMessage("hello world!"))
+		select {
+		case <-ch:
+			t.Fatal("decoded flows received")
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+}
+
+func TestTLSWithoutCertificate(t *testing.T) {
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.TLS.Enable = true
+	_, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{Schema: schema.NewMock(t)})
+	if err == nil {
+		t.Fatal("New() did not error")
+	}
+}
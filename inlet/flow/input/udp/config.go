@@ -7,9 +7,11 @@ import "akvorado/inlet/flow/input"
 
 // Configuration describes UDP input configuration.
 type Configuration struct {
-	// Listen tells which port to listen to.
-	Listen string `validate:"required,listen"`
-	// Workers define the number of workers to use for receiving flows.
+	// Listen tells which addresses to listen to. Each address gets its own
+	// set of workers.
+	Listen []string `validate:"min=1,dive,listen"`
+	// Workers define the number of workers to use for receiving flows for
+	// each listen address.
 	Workers int `validate:"required,min=1"`
 	// QueueSize defines the size of the channel used to
 	// communicate incoming flows. 0 can be used to disable
@@ -26,7 +28,7 @@ type Configuration struct {
 // DefaultConfiguration is the default configuration for this input
 func DefaultConfiguration() input.Configuration {
 	return &Configuration{
-		Listen:    ":0",
+		Listen:    []string{":0"},
 		Workers:   1,
 		QueueSize: 100000,
 	}
@@ -34,12 +34,15 @@ type Input struct {
 		errors        *reporter.CounterVec
 		outDrops      *reporter.CounterVec
 		inDrops       *reporter.GaugeVec
+		kernelDrops   *reporter.CounterVec
+		receiveQueue  *reporter.GaugeVec
+		receiveBuffer *reporter.GaugeVec
 		decodedFlows  *reporter.CounterVec
 	}
 
-	address net.Addr                   // listening address, for testing purpoese
-	ch      chan []*schema.FlowMessage // channel to send flows to
-	decoder decoder.Decoder            // decoder to use
+	addresses []net.Addr                 // listening addresses, for testing purpose
+	ch        chan []*schema.FlowMessage // channel to send flows to
+	decoder   decoder.Decoder            // decoder to use
 }
 
 // New instantiate a new UDP listener from the provided configuration.
@@ -94,6 +97,27 @@ func (configuration *Configuration) New(r *reporter.Reporter, daemon daemon.Comp
 		},
 		[]string{"listener", "worker"},
 	)
+	input.metrics.kernelDrops = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "kernel_dropped_packets_total",
+			Help: "Dropped packets due to kernel receive buffer full.",
+		},
+		[]string{"listener"},
+	)
+	input.metrics.receiveQueue = r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "receive_queue_bytes",
+			Help: "Bytes waiting in the kernel receive queue.",
+		},
+		[]string{"listener", "worker"},
+	)
+	input.metrics.receiveBuffer = r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "receive_buffer_bytes",
+			Help: "Effective size of the kernel receive buffer.",
+		},
+		[]string{"listener"},
+	)
 	input.metrics.decodedFlows = r.CounterVec(
 		reporter.CounterOpts{
 			Name: "decoded_flows_total",
@@ -106,60 +130,87 @@ func (configuration *Configuration) New(r *reporter.Reporter, daemon daemon.Comp
 	return input, nil
 }
 
-// Start starts listening to the provided UDP socket and producing flows.
+// Start starts listening to the provided UDP sockets and producing flows.
 func (in *Input) Start() (<-chan []*schema.FlowMessage, error) {
-	in.r.Info().Str("listen", in.config.Listen).Msg("starting UDP input")
-
-	// Listen to UDP port
-	conns := []*net.UDPConn{}
-	for i := range in.config.Workers {
-		var listenAddr net.Addr
-		if in.address != nil {
-			// We already are listening on one address, let's
-			// listen to the same (useful when using :0).
-			listenAddr = in.address
-		} else {
-			var err error
-			listenAddr, err = net.ResolveUDPAddr("udp", in.config.Listen)
+	in.r.Info().Strs("listen", in.config.Listen).Msg("starting UDP input")
+
+	// Listen to UDP ports
+	type worker struct {
+		listen string
+		id     int
+		conn   *net.UDPConn
+	}
+	workers := []worker{}
+	in.addresses = make([]net.Addr, len(in.config.Listen))
+	for idx, listen := range in.config.Listen {
+		for i := range in.config.Workers {
+			var listenAddr net.Addr
+			if in.addresses[idx] != nil {
+				// We already are listening on one address, let's
+				// listen to the same (useful when using :0).
+				listenAddr = in.addresses[idx]
+			} else {
+				var err error
+				listenAddr, err = net.ResolveUDPAddr("udp", listen)
+				if err != nil {
+					return nil, fmt.Errorf("unable to resolve %v: %w", listen, err)
+				}
+			}
+			pconn, err := listenConfig.ListenPacket(in.t.Context(context.Background()), "udp", listenAddr.String())
 			if err != nil {
-				return nil, fmt.Errorf("unable to resolve %v: %w", in.config.Listen, err)
+				return nil, fmt.Errorf("unable to listen to %v: %w", listenAddr, err)
 			}
-		}
-		pconn, err := listenConfig.ListenPacket(in.t.Context(context.Background()), "udp", listenAddr.String())
-		if err != nil {
-			return nil, fmt.Errorf("unable to listen to %v: %w", listenAddr, err)
-		}
-		udpConn := pconn.(*net.UDPConn)
-		in.address = udpConn.LocalAddr()
-		if i == 0 {
-			in.r.Info().Str("listen", in.address.String()).Msg("UDP input listening")
-		}
-		if in.config.ReceiveBuffer > 0 {
-			if err := udpConn.SetReadBuffer(int(in.config.ReceiveBuffer)); err != nil {
-				in.r.Warn().
-					Str("error", err.Error()).
-					Str("listen", in.config.Listen).
-					Msgf("unable to set requested buffer size (%d bytes)", in.config.ReceiveBuffer)
+			udpConn := pconn.(*net.UDPConn)
+			in.addresses[idx] = udpConn.LocalAddr()
+			if i == 0 {
+				in.r.Info().Str("listen", in.addresses[idx].String()).Msg("UDP input listening")
+			}
+			if in.config.ReceiveBuffer > 0 {
+				if err := udpConn.SetReadBuffer(int(in.config.ReceiveBuffer)); err != nil {
+					in.r.Warn().
+						Str("error", err.Error()).
+						Str("listen", listen).
+						Msgf("unable to set requested buffer size (%d bytes)", in.config.ReceiveBuffer)
+				}
+			}
+			if i == 0 {
+				in.metrics.kernelDrops.WithLabelValues(listen)
+				size, err := receiveBufferSize(udpConn)
+				if err != nil {
+					in.r.Warn().
+						Str("error", err.Error()).
+						Str("listen", listen).
+						Msg("unable to get effective buffer size")
+				} else {
+					in.metrics.receiveBuffer.WithLabelValues(listen).Set(float64(size))
+					if size < int(in.config.ReceiveBuffer) {
+						in.r.Warn().
+							Str("listen", listen).
+							Msgf("effective buffer size (%d bytes) is smaller than requested (%d bytes), check net.core.rmem_max",
+								size, in.config.ReceiveBuffer)
+					}
+				}
 			}
-		}
 
-		conns = append(conns, udpConn)
+			workers = append(workers, worker{listen: listen, id: i, conn: udpConn})
+		}
 	}
 
-	for i := range in.config.Workers {
-		workerID := i
-		worker := strconv.Itoa(i)
+	for _, w := range workers {
+		conn := w.conn
+		listen := w.listen
+		worker := strconv.Itoa(w.id)
 		in.t.Go(func() error {
 			payload := make([]byte, 9000)
 			oob := make([]byte, oobLength)
-			listen := in.config.Listen
 			l := in.r.With().
 				Str("worker", worker).
 				Str("listen", listen).
 				Logger()
 			errLogger := l.Sample(reporter.BurstSampler(time.Minute, 1))
+			var lastDrops uint32
 			for count := 0; ; count++ {
-				n, oobn, _, source, err := conns[workerID].ReadMsgUDP(payload, oob)
+				n, oobn, _, source, err := conn.ReadMsgUDP(payload, oob)
 				if err != nil {
 					if errors.Is(err, net.ErrClosed) {
 						return nil
@@ -177,6 +228,18 @@ func (in *Input) Start() (<-chan []*schema.FlowMessage, error) {
 						in.metrics.inDrops.WithLabelValues(listen, worker).Set(
 							float64(oobMsg.Drops))
 					}
+					// The kernel only reports drops once there are some.
+					if oobMsg.Drops != 0 && oobMsg.Drops != lastDrops {
+						in.metrics.kernelDrops.WithLabelValues(listen).Add(
+							float64(oobMsg.Drops - lastDrops))
+						lastDrops = oobMsg.Drops
+					}
+				}
+				if count < 100 || count%100 == 0 {
+					if queued, err := receiveQueueSize(conn); err == nil {
+						in.metrics.receiveQueue.WithLabelValues(listen, worker).Set(
+							float64(queued))
+					}
 				}
 				if oobMsg.Received.IsZero() {
 					oobMsg.Received = time.Now()
@@ -211,14 +274,13 @@ func (in *Input) Start() (<-chan []*schema.FlowMessage, error) {
 				}
 			}
 		})
-
 	}
 
 	// Watch for termination and close on dying
 	in.t.Go(func() error {
 		<-in.t.Dying()
-		for _, conn := range conns {
-			conn.Close()
+		for _, w := range workers {
+			w.conn.Close()
 		}
 		return nil
 	})
@@ -228,7 +290,7 @@ func (in *Input) Start() (<-chan []*schema.FlowMessage, error) {
 
 // Stop stops the UDP listeners
 func (in *Input) Stop() error {
-	l := in.r.With().Str("listen", in.config.Listen).Logger()
+	l := in.r.With().Strs("listen", in.config.Listen).Logger()
 	defer func() {
 		close(in.ch)
 		l.Info().Msg("UDP listener stopped")
@@ -6,6 +6,10 @@ package udp
 import (
 	"net"
 	"net/netip"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -19,7 +23,7 @@ import (
 func TestUDPInput(t *testing.T) {
 	r := reporter.NewMock(t)
 	configuration := DefaultConfiguration().(*Configuration)
-	configuration.Listen = "127.0.0.1:0"
+	configuration.Listen = []string{"127.0.0.1:0"}
 	in, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{Schema: schema.NewMock(t)})
 	if err != nil {
 		t.Fatalf("New() error:\n%+v", err)
@@ -35,7 +39,7 @@ func TestUDPInput(t *testing.T) {
 	}()
 
 	// Connect
-	conn, err := net.Dial("udp", in.(*Input).address.String())
+	conn, err := net.Dial("udp", in.(*Input).addresses[0].String())
 	if err != nil {
 		t.Fatalf("Dial() error:\n%+v", err)
 	}
@@ -76,12 +80,13 @@ func TestUDPInput(t *testing.T) {
 	}
 
 	// Check metrics
-	gotMetrics := r.GetMetrics("akvorado_inlet_flow_input_udp_")
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_input_udp_", "-receive_")
 	expectedMetrics := map[string]string{
 		`bytes_total{exporter="127.0.0.1",listener="127.0.0.1:0",worker="0"}`:                        "12",
 		`decoded_flows_total{exporter="127.0.0.1",listener="127.0.0.1:0",worker="0"}`:                "1",
 		`packets_total{exporter="127.0.0.1",listener="127.0.0.1:0",worker="0"}`:                      "1",
 		`in_dropped_packets_total{listener="127.0.0.1:0",worker="0"}`:                                "0",
+		`kernel_dropped_packets_total{listener="127.0.0.1:0"}`:                                       "0",
 		`summary_size_bytes_count{exporter="127.0.0.1",listener="127.0.0.1:0",worker="0"}`:           "1",
 		`summary_size_bytes_sum{exporter="127.0.0.1",listener="127.0.0.1:0",worker="0"}`:             "12",
 		`summary_size_bytes{exporter="127.0.0.1",listener="127.0.0.1:0",worker="0",quantile="0.5"}`:  "12",
@@ -96,7 +101,7 @@ func TestUDPInput(t *testing.T) {
 func TestOverflow(t *testing.T) {
 	r := reporter.NewMock(t)
 	configuration := DefaultConfiguration().(*Configuration)
-	configuration.Listen = "127.0.0.1:0"
+	configuration.Listen = []string{"127.0.0.1:0"}
 	configuration.QueueSize = 1
 	in, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{
 		Schema: schema.NewMock(t),
@@ -115,7 +120,7 @@ func TestOverflow(t *testing.T) {
 	}()
 
 	// Connect
-	conn, err := net.Dial("udp", in.(*Input).address.String())
+	conn, err := net.Dial("udp", in.(*Input).addresses[0].String())
 	if err != nil {
 		t.Fatalf("Dial() error:\n%+v", err)
 	}
@@ -129,11 +134,12 @@ func TestOverflow(t *testing.T) {
 	time.Sleep(20 * time.Millisecond)
 
 	// Check metrics
-	gotMetrics := r.GetMetrics("akvorado_inlet_flow_input_udp_")
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_input_udp_", "-receive_")
 	expectedMetrics := map[string]string{
 		`bytes_total{exporter="127.0.0.1",listener="127.0.0.1:0",worker="0"}`:                        "120",
 		`decoded_flows_total{exporter="127.0.0.1",listener="127.0.0.1:0",worker="0"}`:                "1",
 		`in_dropped_packets_total{listener="127.0.0.1:0",worker="0"}`:                                "0",
+		`kernel_dropped_packets_total{listener="127.0.0.1:0"}`:                                       "0",
 		`out_dropped_packets_total{exporter="127.0.0.1",listener="127.0.0.1:0",worker="0"}`:          "9",
 		`packets_total{exporter="127.0.0.1",listener="127.0.0.1:0",worker="0"}`:                      "10",
 		`summary_size_bytes_count{exporter="127.0.0.1",listener="127.0.0.1:0",worker="0"}`:           "10",
@@ -146,3 +152,132 @@ func TestOverflow(t *testing.T) {
 		t.Fatalf("Input metrics (-got, +want):\n%s", diff)
 	}
 }
+
+func TestReceiveBuffer(t *testing.T) {
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.Listen = []string{"127.0.0.1:0"}
+	configuration.ReceiveBuffer = 100000
+	in, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{Schema: schema.NewMock(t)})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	if _, err := in.Start(); err != nil {
+		t.Fatalf("Start() error:\n%+v", err)
+	}
+	defer func() {
+		if err := in.Stop(); err != nil {
+			t.Fatalf("Stop() error:\n%+v", err)
+		}
+	}()
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_input_udp_", "receive_buffer_bytes")
+	got, err := strconv.Atoi(gotMetrics[`receive_buffer_bytes{listener="127.0.0.1:0"}`])
+	if err != nil {
+		t.Fatalf("receive_buffer_bytes metric missing: %v", gotMetrics)
+	}
+	if got < 100000 {
+		t.Fatalf("receive_buffer_bytes = %d, expected at least 100000", got)
+	}
+}
+
+func TestReceiveBufferClamped(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Skip Linux-only test")
+	}
+	content, err := os.ReadFile("/proc/sys/net/core/rmem_max")
+	if err != nil {
+		t.Skipf("ReadFile() error:\n%+v", err)
+	}
+	rmemMax, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		t.Fatalf("Atoi() error:\n%+v", err)
+	}
+
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.Listen = []string{"127.0.0.1:0"}
+	configuration.ReceiveBuffer = uint(2 * rmemMax)
+	in, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{Schema: schema.NewMock(t)})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	if _, err := in.Start(); err != nil {
+		t.Fatalf("Start() error:\n%+v", err)
+	}
+	defer func() {
+		if err := in.Stop(); err != nil {
+			t.Fatalf("Stop() error:\n%+v", err)
+		}
+	}()
+
+	// The kernel clamps the requested value to net.core.rmem_max. It reports
+	// twice this value, but we should not.
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_input_udp_", "receive_buffer_bytes")
+	got, err := strconv.ParseFloat(gotMetrics[`receive_buffer_bytes{listener="127.0.0.1:0"}`], 64)
+	if err != nil {
+		t.Fatalf("receive_buffer_bytes metric missing: %v", gotMetrics)
+	}
+	if int(got) != rmemMax {
+		t.Fatalf("receive_buffer_bytes = %d, expected %d", int(got), rmemMax)
+	}
+}
+
+func TestMultipleListeners(t *testing.T) {
+	if conn, err := net.ListenPacket("udp6", "[::1]:0"); err != nil {
+		t.Skipf("IPv6 not available: %v", err)
+	} else {
+		conn.Close()
+	}
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.Listen = []string{"127.0.0.1:0", "[::1]:0"}
+	in, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{Schema: schema.NewMock(t)})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	ch, err := in.Start()
+	if err != nil {
+		t.Fatalf("Start() error:\n%+v", err)
+	}
+	defer func() {
+		if err := in.Stop(); err != nil {
+			t.Fatalf("Stop() error:\n%+v", err)
+		}
+	}()
+
+	addresses := in.(*Input).addresses
+	if len(addresses) != 2 {
+		t.Fatalf("Start() listened on %d addresses, expected 2", len(addresses))
+	}
+	expected := []string{"::ffff:127.0.0.1", "::1"}
+	for idx, address := range addresses {
+		conn, err := net.Dial("udp", address.String())
+		if err != nil {
+			t.Fatalf("Dial(%q) error:\n%+v", address, err)
+		}
+		if _, err := conn.Write([]byte("hello world!")); err != nil {
+			t.Fatalf("Write() error:\n%+v", err)
+		}
+		select {
+		case got := <-ch:
+			if len(got) == 0 {
+				t.Fatalf("empty decoded flows received")
+			}
+			if diff := helpers.Diff(got[0].ExporterAddress.String(), expected[idx]); diff != "" {
+				t.Fatalf("ExporterAddress (-got, +want):\n%s", diff)
+			}
+		case <-time.After(20 * time.Millisecond):
+			t.Fatalf("no decoded flows received from %s", address)
+		}
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_input_udp_", "packets_total")
+	expectedMetrics := map[string]string{
+		`packets_total{exporter="127.0.0.1",listener="127.0.0.1:0",worker="0"}`: "1",
+		`packets_total{exporter="::1",listener="[::1]:0",worker="0"}`:           "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Input metrics (-got, +want):\n%s", diff)
+	}
+}
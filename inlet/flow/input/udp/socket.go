@@ -4,6 +4,7 @@
 package udp
 
 import (
+	"errors"
 	"net"
 	"syscall"
 	"time"
@@ -16,6 +17,9 @@ type oobMessage struct {
 	Received time.Time
 }
 
+// errNotSupported is returned when an operation is not supported on the current platform.
+var errNotSupported = errors.New("not supported on this platform")
+
 // listenConfig configures a listening socket to reuse port and return overflows
 var listenConfig = net.ListenConfig{
 	Control: func(_, _ string, c syscall.RawConn) error {
@@ -32,3 +36,21 @@ var listenConfig = net.ListenConfig{
 		return err
 	},
 }
+
+// receiveBufferSize returns the effective size of the receive buffer of the
+// provided socket, after clamping by the kernel. It is comparable with the
+// requested size.
+func receiveBufferSize(conn *net.UDPConn) (int, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var size int
+	var serr error
+	if err := raw.Control(func(fd uintptr) {
+		size, serr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_RCVBUF)
+	}); err != nil {
+		return 0, err
+	}
+	return size / receiveBufferOverhead, serr
+}
@@ -6,8 +6,10 @@
 package udp
 
 import (
+	"net"
 	"syscall"
 	"time"
+	"unsafe"
 
 	"akvorado/common/helpers"
 
@@ -24,6 +26,9 @@ var (
 		// Ask the kernel to timestamp incoming packets
 		unix.SO_TIMESTAMP | unix.SOF_TIMESTAMPING_RX_SOFTWARE,
 	}
+	// Linux doubles the requested receive buffer size to account for
+	// bookkeeping overhead and reports the doubled value.
+	receiveBufferOverhead = 2
 )
 
 // parseSocketControlMessage parses b and extract the number of drops
@@ -48,3 +53,26 @@ func parseSocketControlMessage(b []byte) (oobMessage, error) {
 	}
 	return result, nil
 }
+
+// receiveQueueSize returns the number of bytes waiting in the receive queue of
+// the provided socket (SO_MEMINFO).
+func receiveQueueSize(conn *net.UDPConn) (uint32, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var meminfo [unix.SK_MEMINFO_VARS]uint32
+	var serr error
+	if err := raw.Control(func(fd uintptr) {
+		size := uint32(unsafe.Sizeof(meminfo))
+		_, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, fd,
+			unix.SOL_SOCKET, unix.SO_MEMINFO,
+			uintptr(unsafe.Pointer(&meminfo[0])), uintptr(unsafe.Pointer(&size)), 0)
+		if errno != 0 {
+			serr = errno
+		}
+	}); err != nil {
+		return 0, err
+	}
+	return meminfo[unix.SK_MEMINFO_RMEM_ALLOC], serr
+}
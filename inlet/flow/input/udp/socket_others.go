@@ -5,14 +5,24 @@
 
 package udp
 
-import "golang.org/x/sys/unix"
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
 
 var (
-	oobLength        = 0
-	udpSocketOptions = []int{unix.SO_REUSEADDR, unix.SO_REUSEPORT}
+	oobLength             = 0
+	udpSocketOptions      = []int{unix.SO_REUSEADDR, unix.SO_REUSEPORT}
+	receiveBufferOverhead = 1
 )
 
 // parseSocketControlMessage always returns 0.
 func parseSocketControlMessage(_ []byte) (oobMessage, error) {
 	return oobMessage{}, nil
 }
+
+// receiveQueueSize is not supported.
+func receiveQueueSize(_ *net.UDPConn) (uint32, error) {
+	return 0, errNotSupported
+}
@@ -74,3 +74,35 @@ outer:
 		t.Fatal("no drops detected")
 	}
 }
+
+func TestReceiveQueueSize(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Skip Linux-only test")
+	}
+	server, err := listenConfig.ListenPacket(context.Background(), "udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket() error:\n%+v", err)
+	}
+	defer server.Close()
+	conn := server.(*net.UDPConn)
+
+	if got, err := receiveQueueSize(conn); err != nil {
+		t.Fatalf("receiveQueueSize() error:\n%+v", err)
+	} else if got != 0 {
+		t.Fatalf("receiveQueueSize() = %d, expected 0", got)
+	}
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Dial() error:\n%+v", err)
+	}
+	for range 10 {
+		client.Write([]byte("hello"))
+	}
+	time.Sleep(10 * time.Millisecond)
+	if got, err := receiveQueueSize(conn); err != nil {
+		t.Fatalf("receiveQueueSize() error:\n%+v", err)
+	} else if got == 0 {
+		t.Fatal("receiveQueueSize() = 0, expected some queued bytes")
+	}
+}
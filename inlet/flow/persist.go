@@ -0,0 +1,84 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package flow
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"akvorado/inlet/flow/decoder"
+)
+
+// saveTemplates saves the state of each stateful decoder (NetFlow templates)
+// to the configured file.
+func (c *Component) saveTemplates() error {
+	states := map[string][]byte{}
+	for name, dec := range c.decoders {
+		sdec, ok := dec.(decoder.StatefulDecoder)
+		if !ok {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := sdec.SaveState(&buf); err != nil {
+			return fmt.Errorf("unable to save state for decoder %q: %w", name, err)
+		}
+		states[name] = buf.Bytes()
+	}
+
+	cacheFile := c.config.TemplatesPersistFile
+	tmpFile, err := os.CreateTemp(
+		filepath.Dir(cacheFile),
+		fmt.Sprintf("%s-*", filepath.Base(cacheFile)))
+	if err != nil {
+		return fmt.Errorf("unable to create templates file %q: %w", cacheFile, err)
+	}
+	defer func() {
+		tmpFile.Close()           // ignore errors
+		os.Remove(tmpFile.Name()) // ignore errors
+	}()
+	if err := gob.NewEncoder(tmpFile).Encode(states); err != nil {
+		return fmt.Errorf("unable to encode templates: %w", err)
+	}
+	if err := os.Rename(tmpFile.Name(), cacheFile); err != nil {
+		return fmt.Errorf("unable to write templates file %q: %w", cacheFile, err)
+	}
+	return nil
+}
+
+// loadTemplates restores the state of each stateful decoder from the
+// configured file.
+func (c *Component) loadTemplates() error {
+	f, err := os.Open(c.config.TemplatesPersistFile)
+	if errors.Is(err, os.ErrNotExist) {
+		// First start, nothing to restore
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to load templates %q: %w", c.config.TemplatesPersistFile, err)
+	}
+	defer f.Close()
+	states := map[string][]byte{}
+	if err := gob.NewDecoder(f).Decode(&states); err != nil {
+		return fmt.Errorf("unable to decode templates: %w", err)
+	}
+
+	var notBefore time.Time
+	if c.config.TemplatesMaxAge > 0 {
+		notBefore = time.Now().Add(-c.config.TemplatesMaxAge)
+	}
+	for name, state := range states {
+		sdec, ok := c.decoders[name].(decoder.StatefulDecoder)
+		if !ok {
+			continue
+		}
+		if err := sdec.LoadState(bytes.NewReader(state), notBefore); err != nil {
+			return fmt.Errorf("unable to load state for decoder %q: %w", name, err)
+		}
+	}
+	return nil
+}
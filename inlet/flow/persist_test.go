@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package flow
+
+import (
+	"os"
+	"path"
+	"runtime"
+	"testing"
+	"time"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/httpserver"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/flow/input/file"
+)
+
+func TestTemplatesPersistence(t *testing.T) {
+	_, src, _, _ := runtime.Caller(0)
+	base := path.Join(path.Dir(src), "decoder", "netflow", "testdata")
+	outDir := t.TempDir()
+	templateFile := path.Join(outDir, "template")
+	dataFile := path.Join(outDir, "data")
+	for outFile, pcap := range map[string]string{
+		templateFile: "template.pcap",
+		dataFile:     "data.pcap",
+	} {
+		if err := os.WriteFile(outFile, helpers.ReadPcapL4(t, path.Join(base, pcap)), 0o666); err != nil {
+			t.Fatalf("WriteFile(%q) error:\n%+v", outFile, err)
+		}
+	}
+	persistFile := path.Join(outDir, "templates")
+
+	run := func(t *testing.T, paths []string) bool {
+		t.Helper()
+		r := reporter.NewMock(t)
+		config := DefaultConfiguration()
+		config.TemplatesPersistFile = persistFile
+		config.Inputs = []InputConfiguration{
+			{
+				Decoder: "netflow",
+				Config:  &file.Configuration{Paths: paths, Loop: true},
+			},
+		}
+		c, err := New(r, config, Dependencies{
+			Daemon: daemon.NewMock(t),
+			HTTP:   httpserver.NewMock(t, r),
+			Schema: schema.NewMock(t),
+		})
+		if err != nil {
+			t.Fatalf("New() error:\n%+v", err)
+		}
+		c.drainTimeout = 100 * time.Millisecond
+		if err := c.Start(); err != nil {
+			t.Fatalf("Start() error:\n%+v", err)
+		}
+		defer func() {
+			if err := c.Stop(); err != nil {
+				t.Fatalf("Stop() error:\n%+v", err)
+			}
+		}()
+		select {
+		case <-c.Flows():
+			return true
+		case <-time.After(100 * time.Millisecond):
+			return false
+		}
+	}
+
+	// Without templates, we should not get any flow.
+	if run(t, []string{dataFile}) {
+		t.Fatal("got flows without templates")
+	}
+	// Learn templates. They are saved on stop.
+	if !run(t, []string{templateFile, dataFile}) {
+		t.Fatal("no flow received with templates")
+	}
+	if _, err := os.Stat(persistFile); err != nil {
+		t.Fatalf("Stat(%q) error:\n%+v", persistFile, err)
+	}
+	// Templates should be restored on start.
+	if !run(t, []string{dataFile}) {
+		t.Fatal("no flow received with persisted templates")
+	}
+}
+
+func TestLoadMissingTemplates(t *testing.T) {
+	c := &Component{
+		config: Configuration{
+			TemplatesPersistFile: path.Join(t.TempDir(), "templates"),
+		},
+	}
+	if err := c.loadTemplates(); err != nil {
+		t.Fatalf("loadTemplates() error:\n%+v", err)
+	}
+}
@@ -4,19 +4,39 @@
 package flow
 
 import (
+	"context"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
 	"time"
 
+	"akvorado/common/reporter"
 	"akvorado/common/schema"
 
 	"golang.org/x/time/rate"
 )
 
+// rateLimitWarningDuration is how long an exporter is reported by the
+// healthcheck after flows were dropped.
+const rateLimitWarningDuration = time.Minute
+
 type limiter struct {
 	l           *rate.Limiter
 	dropped     uint64  // dropped during the current second
 	total       uint64  // total during the current second
 	dropRate    float64 // drop rate during the last second
 	currentTick time.Time
+	lastDrop    time.Time
+}
+
+// rateLimit returns the rate limit for the provided exporter. 0 means no
+// limit.
+func (c *Component) rateLimit(exporter netip.Addr) rate.Limit {
+	if override, ok := c.config.ExporterOverrides.Lookup(exporter); ok && override.RateLimit > 0 {
+		return override.RateLimit
+	}
+	return c.config.RateLimit
 }
 
 // allowMessages tell if we can transmit the provided messages,
@@ -24,17 +44,26 @@ type limiter struct {
 // rate may be modified to match current drop rate.
 func (c *Component) allowMessages(fmsgs []*schema.FlowMessage) bool {
 	count := len(fmsgs)
-	if c.config.RateLimit == 0 || count == 0 {
+	if !c.rateLimited || count == 0 {
 		return true
 	}
 	exporter := fmsgs[0].ExporterAddress
+	c.limitersLock.Lock()
+	defer c.limitersLock.Unlock()
 	exporterLimiter, ok := c.limiters[exporter]
 	if !ok {
-		exporterLimiter = &limiter{
-			l: rate.NewLimiter(rate.Limit(c.config.RateLimit), int(c.config.RateLimit/10)),
+		limit := c.rateLimit(exporter)
+		if limit > 0 {
+			exporterLimiter = &limiter{
+				l: rate.NewLimiter(limit, max(int(limit/10), 1)),
+			}
 		}
+		// Also remember exporters without limit to avoid looking them up again.
 		c.limiters[exporter] = exporterLimiter
 	}
+	if exporterLimiter == nil {
+		return true
+	}
 	now := time.Now()
 	tick := now.Truncate(200 * time.Millisecond) // we use a 200-millisecond resolution
 	if exporterLimiter.currentTick.UnixMilli() != tick.UnixMilli() {
@@ -46,12 +75,36 @@ func (c *Component) allowMessages(fmsgs []*schema.FlowMessage) bool {
 	exporterLimiter.total += uint64(count)
 	if !exporterLimiter.l.AllowN(now, count) {
 		exporterLimiter.dropped += uint64(count)
+		exporterLimiter.lastDrop = now
+		c.metrics.rateLimitedFlows.WithLabelValues(exporter.Unmap().String()).Add(float64(count))
 		return false
 	}
 	if exporterLimiter.dropRate > 0 {
 		for _, flow := range fmsgs {
-			flow.SamplingRate *= uint32(1 / (1 - exporterLimiter.dropRate))
+			flow.SamplingRate = uint32(float64(flow.SamplingRate) / (1 - exporterLimiter.dropRate))
 		}
 	}
 	return true
 }
+
+// rateLimitHealthcheck reports a warning when flows from some exporters were
+// recently dropped because of the rate limit.
+func (c *Component) rateLimitHealthcheck(_ context.Context) reporter.HealthcheckResult {
+	c.limitersLock.Lock()
+	defer c.limitersLock.Unlock()
+	exporters := []string{}
+	now := time.Now()
+	for exporter, exporterLimiter := range c.limiters {
+		if exporterLimiter != nil && now.Sub(exporterLimiter.lastDrop) < rateLimitWarningDuration {
+			exporters = append(exporters, exporter.Unmap().String())
+		}
+	}
+	if len(exporters) == 0 {
+		return reporter.HealthcheckResult{Status: reporter.HealthcheckOK, Reason: "ok"}
+	}
+	slices.Sort(exporters)
+	return reporter.HealthcheckResult{
+		Status: reporter.HealthcheckWarning,
+		Reason: fmt.Sprintf("rate limit exceeded for %s", strings.Join(exporters, ", ")),
+	}
+}
@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package flow
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+)
+
+func TestRateLimitOverride(t *testing.T) {
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration()
+	config.ExporterOverrides = *helpers.MustNewSubnetMap(map[string]ExporterOverride{
+		"::ffff:192.0.2.1/128": {RateLimit: 100},
+	})
+	c := NewMock(t, r, config)
+
+	if got := c.rateLimitHealthcheck(context.Background()); got.Status != reporter.HealthcheckOK {
+		t.Errorf("rateLimitHealthcheck() = %v, expected ok", got)
+	}
+
+	flows := func(exporter string) []*schema.FlowMessage {
+		fmsgs := make([]*schema.FlowMessage, 200)
+		for idx := range fmsgs {
+			fmsgs[idx] = &schema.FlowMessage{
+				ExporterAddress: netip.MustParseAddr(exporter),
+				SamplingRate:    1000,
+			}
+		}
+		return fmsgs
+	}
+	if c.allowMessages(flows("::ffff:192.0.2.1")) {
+		t.Error("allowMessages() allowed flows above the rate limit")
+	}
+	if !c.allowMessages(flows("::ffff:192.0.2.2")) {
+		t.Error("allowMessages() rejected flows from an exporter without rate limit")
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_", "rate_limited_")
+	expectedMetrics := map[string]string{
+		`rate_limited_flows_total{exporter="192.0.2.1"}`: "200",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+	expectedHealth := reporter.HealthcheckResult{
+		Status: reporter.HealthcheckWarning,
+		Reason: "rate limit exceeded for 192.0.2.1",
+	}
+	if diff := helpers.Diff(c.rateLimitHealthcheck(context.Background()), expectedHealth); diff != "" {
+		t.Fatalf("rateLimitHealthcheck() (-got, +want):\n%s", diff)
+	}
+}
@@ -9,6 +9,9 @@ import (
 	"fmt"
 	"net/http"
 	"net/netip"
+	"reflect"
+	"sync"
+	"time"
 
 	"gopkg.in/tomb.v2"
 
@@ -18,6 +21,7 @@ import (
 	"akvorado/common/schema"
 	"akvorado/inlet/flow/decoder"
 	"akvorado/inlet/flow/input"
+	"akvorado/inlet/flow/input/tcp"
 )
 
 // Component represents the flow component.
@@ -30,18 +34,32 @@ type Component struct {
 	metrics struct {
 		decoderStats  *reporter.CounterVec
 		decoderErrors *reporter.CounterVec
+
+		rateLimitedFlows *reporter.CounterVec
 	}
 
 	// Channel for sending flows out of the package.
 	outgoingFlows chan *schema.FlowMessage
 
 	// Per-exporter rate-limiters
-	limiters map[netip.Addr]*limiter
+	rateLimited  bool
+	limiters     map[netip.Addr]*limiter
+	limitersLock sync.Mutex
+
+	// Inputs and decoders
+	inputs   []input.Input
+	decoders map[string]decoder.Decoder
 
-	// Inputs
-	inputs []input.Input
+	// Started inputs and their forwarders, drained on stop
+	started      []input.Input
+	forwarders   sync.WaitGroup
+	drainTimeout time.Duration
 }
 
+// defaultDrainTimeout is the maximum time to wait for flows from stopped
+// inputs to be handed to the core component.
+const defaultDrainTimeout = 5 * time.Second
+
 // Dependencies are the dependencies of the flow component.
 type Dependencies struct {
 	Daemon daemon.Component
@@ -62,23 +80,59 @@ func New(r *reporter.Reporter, configuration Configuration, dependencies Depende
 		outgoingFlows: make(chan *schema.FlowMessage),
 		limiters:      make(map[netip.Addr]*limiter),
 		inputs:        make([]input.Input, len(configuration.Inputs)),
+		decoders:      make(map[string]decoder.Decoder),
+		drainTimeout:  defaultDrainTimeout,
+	}
+	c.rateLimited = configuration.RateLimit > 0
+	for _, override := range configuration.ExporterOverrides.ToMap() {
+		if override.RateLimit > 0 {
+			c.rateLimited = true
+		}
 	}
 
-	// Initialize decoders (at most once each)
-	alreadyInitialized := map[string]decoder.Decoder{}
+	// Initialize decoders (at most once each). As the decoders are shared,
+	// inputs using the same decoder should use the same decoder options.
+	options := make(map[string]decoder.Option)
 	decs := make([]decoder.Decoder, len(configuration.Inputs))
 	for idx, input := range c.config.Inputs {
-		dec, ok := alreadyInitialized[input.Decoder]
-		if ok {
-			decs[idx] = dec
-			continue
-		}
 		decoderfunc, ok := decoders[input.Decoder]
 		if !ok {
 			return nil, fmt.Errorf("unknown decoder %q", input.Decoder)
 		}
-		dec = decoderfunc(r, decoder.Dependencies{Schema: c.d.Schema}, decoder.Option{TimestampSource: input.TimestampSource})
-		alreadyInitialized[input.Decoder] = dec
+		if _, ok := input.Config.(*tcp.Configuration); ok && input.Decoder != "netflow" {
+			return nil, fmt.Errorf("decoder %q cannot be used with TCP input", input.Decoder)
+		}
+		for _, field := range input.IPFIXCustomFields {
+			column, ok := c.d.Schema.LookupColumnByName(field.Name)
+			if !ok {
+				return nil, fmt.Errorf("unknown custom column %q for IPFIX field %d/%d",
+					field.Name, field.PEN, field.ID)
+			}
+			if column.ParserType != field.Type {
+				return nil, fmt.Errorf("custom column %q has type %q, not %q",
+					field.Name, column.ParserType, field.Type)
+			}
+		}
+		option := decoder.Option{
+			TimestampSource:        input.TimestampSource,
+			TimestampMaxSkew:       input.TimestampMaxSkew,
+			InterfaceCountersLimit: input.InterfaceCountersLimit,
+			Decapsulate:            input.Decapsulate,
+			DecapsulateGTP:         input.DecapsulateGTP,
+			IPFIXCustomFields:      input.IPFIXCustomFields,
+		}
+		dec, ok := c.decoders[input.Decoder]
+		if ok {
+			if !reflect.DeepEqual(options[input.Decoder], option) {
+				return nil, fmt.Errorf("inputs using decoder %q have different decoder options",
+					input.Decoder)
+			}
+			decs[idx] = c.wrapDecoder(dec, input.UseSrcAddrForExporterAddr)
+			continue
+		}
+		dec = decoderfunc(r, decoder.Dependencies{Schema: c.d.Schema}, option)
+		c.decoders[input.Decoder] = dec
+		options[input.Decoder] = option
 		decs[idx] = c.wrapDecoder(dec, input.UseSrcAddrForExporterAddr)
 	}
 
@@ -106,6 +160,13 @@ func New(r *reporter.Reporter, configuration Configuration, dependencies Depende
 		},
 		[]string{"name"},
 	)
+	c.metrics.rateLimitedFlows = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "rate_limited_flows_total",
+			Help: "Number of flows dropped because of the rate limit.",
+		},
+		[]string{"exporter"},
+	)
 
 	c.d.Daemon.Track(&c.t, "inlet/flow")
 
@@ -125,30 +186,53 @@ func (c *Component) Flows() <-chan *schema.FlowMessage {
 
 // Start starts the flow component.
 func (c *Component) Start() error {
+	if c.rateLimited {
+		c.r.RegisterHealthcheck("flow/rate-limit", c.rateLimitHealthcheck)
+	}
+	if c.config.TemplatesPersistFile != "" {
+		if err := c.loadTemplates(); err != nil {
+			c.r.Err(err).Msg("cannot load templates, ignoring")
+		}
+		if c.config.TemplatesPersistInterval > 0 {
+			c.t.Go(func() error {
+				ticker := time.NewTicker(c.config.TemplatesPersistInterval)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-c.t.Dying():
+						return nil
+					case <-ticker.C:
+						if err := c.saveTemplates(); err != nil {
+							c.r.Err(err).Msg("cannot save templates")
+						}
+					}
+				}
+			})
+		}
+	}
 	for _, input := range c.inputs {
 		ch, err := input.Start()
-		stopper := input.Stop
 		if err != nil {
 			return err
 		}
+		c.started = append(c.started, input)
+		c.forwarders.Add(1)
 		c.t.Go(func() error {
-			defer stopper()
-			for {
-				select {
-				case <-c.t.Dying():
-					return nil
-				case fmsgs := <-ch:
-					if c.allowMessages(fmsgs) {
-						for _, fmsg := range fmsgs {
-							select {
-							case <-c.t.Dying():
-								return nil
-							case c.outgoingFlows <- fmsg:
-							}
-						}
+			defer c.forwarders.Done()
+			// The input closes the channel when stopped.
+			for fmsgs := range ch {
+				if !c.allowMessages(fmsgs) {
+					continue
+				}
+				for _, fmsg := range fmsgs {
+					select {
+					case <-c.t.Dying():
+						return nil
+					case c.outgoingFlows <- fmsg:
 					}
 				}
 			}
+			return nil
 		})
 	}
 	return nil
@@ -158,9 +242,32 @@ func (c *Component) Start() error {
 func (c *Component) Stop() error {
 	defer func() {
 		close(c.outgoingFlows)
+		if c.config.TemplatesPersistFile != "" {
+			if err := c.saveTemplates(); err != nil {
+				c.r.Err(err).Msg("cannot save templates")
+			}
+		}
 		c.r.Info().Msg("flow component stopped")
 	}()
 	c.r.Info().Msg("stopping flow component")
+
+	// Stop inputs first and forward the flows they have already received.
+	for _, input := range c.started {
+		if err := input.Stop(); err != nil {
+			c.r.Err(err).Msg("unable to stop input, ignoring")
+		}
+	}
+	drained := make(chan struct{})
+	go func() {
+		c.forwarders.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(c.drainTimeout):
+		c.r.Warn().Msg("timeout while draining flows from inputs")
+	}
+
 	c.t.Kill(nil)
 	return c.t.Wait()
 }
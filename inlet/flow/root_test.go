@@ -11,9 +11,14 @@ import (
 	"testing"
 	"time"
 
+	"akvorado/common/daemon"
 	"akvorado/common/helpers"
+	"akvorado/common/httpserver"
 	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
 	"akvorado/inlet/flow/input/file"
+	"akvorado/inlet/flow/input/udp"
 )
 
 func TestFlow(t *testing.T) {
@@ -44,6 +49,7 @@ func TestFlow(t *testing.T) {
 			Decoder: "netflow",
 			Config: &file.Configuration{
 				Paths: outFiles,
+				Loop:  true,
 			},
 		},
 	}
@@ -123,3 +129,72 @@ func TestFlow(t *testing.T) {
 		}
 	}
 }
+
+func TestSharedDecoderOptions(t *testing.T) {
+	cases := []struct {
+		Description string
+		Inputs      []InputConfiguration
+		Error       bool
+	}{
+		{
+			Description: "same options",
+			Inputs: []InputConfiguration{
+				{
+					Decoder:     "netflow",
+					Decapsulate: true,
+					Config:      &udp.Configuration{Listen: []string{"127.0.0.1:0"}, QueueSize: 10},
+				}, {
+					Decoder:                   "netflow",
+					Decapsulate:               true,
+					UseSrcAddrForExporterAddr: true,
+					Config:                    &udp.Configuration{Listen: []string{"127.0.0.1:0"}, QueueSize: 10},
+				},
+			},
+		}, {
+			Description: "different options",
+			Inputs: []InputConfiguration{
+				{
+					Decoder: "netflow",
+					Config:  &udp.Configuration{Listen: []string{"127.0.0.1:0"}, QueueSize: 10},
+				}, {
+					Decoder:     "netflow",
+					Decapsulate: true,
+					Config:      &udp.Configuration{Listen: []string{"127.0.0.1:0"}, QueueSize: 10},
+				},
+			},
+			Error: true,
+		}, {
+			Description: "unknown custom column in second input",
+			Inputs: []InputConfiguration{
+				{
+					Decoder: "netflow",
+					Config:  &udp.Configuration{Listen: []string{"127.0.0.1:0"}, QueueSize: 10},
+				}, {
+					Decoder: "netflow",
+					IPFIXCustomFields: []decoder.IPFIXCustomField{
+						{PEN: 9, ID: 12, Name: "Unknown", Type: "uint"},
+					},
+					Config: &udp.Configuration{Listen: []string{"127.0.0.1:0"}, QueueSize: 10},
+				},
+			},
+			Error: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			r := reporter.NewMock(t)
+			config := DefaultConfiguration()
+			config.Inputs = tc.Inputs
+			_, err := New(r, config, Dependencies{
+				Daemon: daemon.NewMock(t),
+				HTTP:   httpserver.NewMock(t, r),
+				Schema: schema.NewMock(t),
+			})
+			if err != nil && !tc.Error {
+				t.Fatalf("New() error:\n%+v", err)
+			} else if err == nil && tc.Error {
+				t.Fatal("New() did not error")
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ package flow
 
 import (
 	"testing"
+	"time"
 
 	"akvorado/common/daemon"
 	"akvorado/common/helpers"
@@ -25,7 +26,7 @@ func NewMock(t *testing.T, r *reporter.Reporter, config Configuration) *Componen
 			{
 				Decoder: "netflow",
 				Config: &udp.Configuration{
-					Listen:    "127.0.0.1:0",
+					Listen:    []string{"127.0.0.1:0"},
 					QueueSize: 10,
 				},
 			},
@@ -39,6 +40,7 @@ func NewMock(t *testing.T, r *reporter.Reporter, config Configuration) *Componen
 	if err != nil {
 		t.Fatalf("New() error:\n%+v", err)
 	}
+	c.drainTimeout = 100 * time.Millisecond
 	helpers.StartStop(t, c)
 	return c
 }
@@ -4,11 +4,15 @@
 package kafka
 
 import (
+	"errors"
 	"time"
 
 	"github.com/IBM/sarama"
 
+	"akvorado/common/helpers"
+	"akvorado/common/helpers/bimap"
 	"akvorado/common/kafka"
+	"akvorado/common/schema"
 )
 
 // Configuration describes the configuration for the Kafka exporter.
@@ -26,6 +30,81 @@ type Configuration struct {
 	CompressionCodec CompressionCodec
 	// QueueSize defines the size of the channel used to send to Kafka.
 	QueueSize int `validate:"min=1"`
+	// ShutdownTimeout is the maximum time to wait for pending messages
+	// to be flushed to Kafka when stopping.
+	ShutdownTimeout time.Duration `validate:"min=0"`
+	// Encoding defines how flows are encoded in Kafka messages.
+	Encoding Encoding
+	// SchemaRegistry defines the schema registry to use with Avro encoding.
+	SchemaRegistry SchemaRegistryConfiguration
+	// Spill defines the disk buffer used when Kafka is unavailable.
+	Spill SpillConfiguration
+	// Partitioner defines how messages are assigned to partitions.
+	Partitioner Partitioner
+	// PartitionFields is the list of columns to hash to select a partition
+	// when using the fields partitioner.
+	PartitionFields []schema.ColumnKey
+	// Headers tells to add headers to messages with the schema hash, the
+	// Akvorado version, and the exporter address.
+	Headers bool
+	// DeadLetter defines where to write messages which cannot be encoded
+	// or are rejected by Kafka.
+	DeadLetter DeadLetterConfiguration
+}
+
+// DeadLetterConfiguration defines where to write messages which cannot be
+// encoded or are rejected by Kafka.
+type DeadLetterConfiguration struct {
+	// File is the path of the file to write rejected messages to. When
+	// empty, rejected messages are not written to a file.
+	File string
+	// MaxSize is the maximum size of the file, in bytes. Once reached, the
+	// file is rotated.
+	MaxSize int64 `validate:"min=1"`
+	// MaxFiles is the number of rotated files to keep.
+	MaxFiles int `validate:"min=0"`
+	// Scrub tells to truncate IP addresses in messages written to the file.
+	Scrub bool
+	// Topic is the Kafka topic to send rejected messages to. When empty,
+	// rejected messages are not sent to Kafka.
+	Topic string
+}
+
+// SpillConfiguration defines how to buffer messages on disk when Kafka is
+// unavailable.
+type SpillConfiguration struct {
+	// Directory is the directory to store segment files. When empty,
+	// messages are not buffered on disk.
+	Directory string
+	// MaxSize is the maximum total size of the segment files, in bytes.
+	// Oldest segments are dropped when this size is exceeded.
+	MaxSize int64 `validate:"min=1"`
+	// SegmentSize is the size of a segment file, in bytes.
+	SegmentSize int64 `validate:"min=1,ltefield=MaxSize"`
+	// Threshold is the number of messages pending in Kafka producer above
+	// which messages are buffered on disk.
+	Threshold int64 `validate:"min=1"`
+	// ReplayInterval tells how often to check if buffered messages can be
+	// replayed to Kafka.
+	ReplayInterval time.Duration `validate:"min=100ms"`
+}
+
+// SchemaRegistryConfiguration defines how to connect to a Confluent Schema
+// Registry.
+type SchemaRegistryConfiguration struct {
+	// URL is the base URL of the schema registry.
+	URL string `validate:"omitempty,url"`
+	// Username is the username for basic authentication.
+	Username string
+	// Password is the password for basic authentication.
+	Password string `validate:"required_with=Username"`
+	// TLS defines the TLS configuration to connect to the schema registry.
+	TLS helpers.TLSConfiguration
+	// Subject is the subject to register the schema with. By default, this is
+	// the topic name followed by "-value".
+	Subject string
+	// Timeout is the timeout for requests to the schema registry.
+	Timeout time.Duration `validate:"min=1s"`
 }
 
 // DefaultConfiguration represents the default configuration for the Kafka exporter.
@@ -37,6 +116,25 @@ func DefaultConfiguration() Configuration {
 		MaxMessageBytes:  1000000,
 		CompressionCodec: CompressionCodec(sarama.CompressionNone),
 		QueueSize:        32,
+		ShutdownTimeout:  10 * time.Second,
+		Encoding:         EncodingProtobuf,
+		SchemaRegistry: SchemaRegistryConfiguration{
+			TLS: helpers.TLSConfiguration{
+				Enable: false,
+				Verify: true,
+			},
+			Timeout: 10 * time.Second,
+		},
+		Spill: SpillConfiguration{
+			MaxSize:        1 << 30,
+			SegmentSize:    16 << 20,
+			Threshold:      100000,
+			ReplayInterval: 5 * time.Second,
+		},
+		DeadLetter: DeadLetterConfiguration{
+			MaxSize:  100 << 20,
+			MaxFiles: 3,
+		},
 	}
 }
 
@@ -57,3 +155,91 @@ func (cc CompressionCodec) String() string {
 func (cc CompressionCodec) MarshalText() ([]byte, error) {
 	return []byte(cc.String()), nil
 }
+
+// Encoding defines how flows are encoded in Kafka messages.
+type Encoding int
+
+const (
+	// EncodingProtobuf encodes flows using protobuf.
+	EncodingProtobuf Encoding = iota
+	// EncodingAvro encodes flows using Avro with a schema registry.
+	EncodingAvro
+)
+
+var encodingMap = bimap.New(map[Encoding]string{
+	EncodingProtobuf: "protobuf",
+	EncodingAvro:     "avro",
+})
+
+// MarshalText turns an encoding to text
+func (e Encoding) MarshalText() ([]byte, error) {
+	got, ok := encodingMap.LoadValue(e)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown encoding")
+}
+
+// String turns an encoding to string
+func (e Encoding) String() string {
+	got, _ := encodingMap.LoadValue(e)
+	return got
+}
+
+// UnmarshalText provides an encoding from text
+func (e *Encoding) UnmarshalText(input []byte) error {
+	got, ok := encodingMap.LoadKey(string(input))
+	if ok {
+		*e = got
+		return nil
+	}
+	return errors.New("unknown encoding")
+}
+
+// Partitioner defines how messages are assigned to partitions.
+type Partitioner int
+
+const (
+	// PartitionerRandom assigns messages to a random partition.
+	PartitionerRandom Partitioner = iota
+	// PartitionerRoundRobin assigns messages to each partition in turn.
+	PartitionerRoundRobin
+	// PartitionerExporter assigns messages to a partition using a hash of
+	// the exporter address.
+	PartitionerExporter
+	// PartitionerFields assigns messages to a partition using a hash of
+	// some columns.
+	PartitionerFields
+)
+
+var partitionerMap = bimap.New(map[Partitioner]string{
+	PartitionerRandom:     "random",
+	PartitionerRoundRobin: "round-robin",
+	PartitionerExporter:   "exporter",
+	PartitionerFields:     "fields",
+})
+
+// MarshalText turns a partitioner to text
+func (p Partitioner) MarshalText() ([]byte, error) {
+	got, ok := partitionerMap.LoadValue(p)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown partitioner")
+}
+
+// String turns a partitioner to string
+func (p Partitioner) String() string {
+	got, _ := partitionerMap.LoadValue(p)
+	return got
+}
+
+// UnmarshalText provides a partitioner from text
+func (p *Partitioner) UnmarshalText(input []byte) error {
+	got, ok := partitionerMap.LoadKey(string(input))
+	if ok {
+		*p = got
+		return nil
+	}
+	return errors.New("unknown partitioner")
+}
@@ -45,3 +45,8 @@ func TestDefaultConfiguration(t *testing.T) {
 		t.Fatalf("validate.Struct() error:\n%+v", err)
 	}
 }
+
+func TestMarshalUnmarshal(t *testing.T) {
+	encodingMap.TestMarshalUnmarshal(t)
+	partitionerMap.TestMarshalUnmarshal(t)
+}
@@ -0,0 +1,285 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package kafka
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"akvorado/common/schema"
+)
+
+// deadLetterOverhead is the room left for headers and framing when sending a
+// rejected message to the dead letter topic.
+const deadLetterOverhead = 1024
+
+// deadLetterRecord is a message written to the dead letter file, as a JSON
+// line. The payload is encoded in base64.
+type deadLetterRecord struct {
+	Time     time.Time `json:"time"`
+	Topic    string    `json:"topic"`
+	Exporter string    `json:"exporter,omitempty"`
+	Error    string    `json:"error"`
+	Payload  []byte    `json:"payload,omitempty"`
+}
+
+// deadLetterFile is a file rotated once it reaches a maximum size.
+type deadLetterFile struct {
+	lock     sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// newDeadLetterFile opens the provided file for appending.
+func newDeadLetterFile(path string, maxSize int64, maxFiles int) (*deadLetterFile, error) {
+	f := &deadLetterFile{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, fmt.Errorf("cannot create dead letter directory: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the file for appending.
+func (f *deadLetterFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("cannot open dead letter file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("cannot stat dead letter file: %w", err)
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// write appends a line to the file, rotating it first if it would exceed the
+// maximum size.
+func (f *deadLetterFile) write(line []byte) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return errors.New("dead letter file is closed")
+	}
+	if f.size > 0 && f.size+int64(len(line)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := f.file.Write(line)
+	f.size += int64(n)
+	return err
+}
+
+// rotate renames the current file to path.1, path.1 to path.2, and so on.
+// Files beyond the maximum number of files are removed.
+func (f *deadLetterFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("cannot close dead letter file: %w", err)
+	}
+	f.file = nil
+	rename := func(from, to string) error {
+		if err := os.Rename(from, to); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("cannot rotate dead letter file: %w", err)
+		}
+		return nil
+	}
+	if f.maxFiles == 0 {
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("cannot remove dead letter file: %w", err)
+		}
+	} else {
+		for i := f.maxFiles - 1; i >= 1; i-- {
+			if err := rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1)); err != nil {
+				return err
+			}
+		}
+		if err := rename(f.path, fmt.Sprintf("%s.1", f.path)); err != nil {
+			return err
+		}
+	}
+	return f.open()
+}
+
+// close closes the file.
+func (f *deadLetterFile) close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// initDeadLetter prepares the dead letter sinks.
+func (c *Component) initDeadLetter() error {
+	config := c.config.DeadLetter
+	if config.Topic != "" && !sarama.KafkaVersion(c.config.Version).IsAtLeast(sarama.V0_11_0_0) {
+		return errors.New("dead letter topic requires Kafka 0.11 or more recent")
+	}
+	if config.File == "" {
+		return nil
+	}
+	file, err := newDeadLetterFile(config.File, config.MaxSize, config.MaxFiles)
+	if err != nil {
+		return err
+	}
+	c.deadLetterFile = file
+	if config.Scrub {
+		c.scrubFields = map[protowire.Number]struct{}{}
+		for _, column := range c.d.Schema.Columns() {
+			for _, column := range append([]schema.Column{column}, column.ClickHouseTransformFrom...) {
+				if column.ProtobufIndex > 0 && column.ProtobufType == protoreflect.BytesKind {
+					c.scrubFields[column.ProtobufIndex] = struct{}{}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// deadLetter writes a message which cannot be encoded or which is rejected
+// by Kafka to the dead letter sinks.
+func (c *Component) deadLetter(topic, exporter string, payload []byte, reason error) {
+	if c.deadLetterFile != nil {
+		record := deadLetterRecord{
+			Time:     time.Now().UTC(),
+			Topic:    topic,
+			Exporter: exporter,
+			Error:    reason.Error(),
+			Payload:  payload,
+		}
+		if c.scrubFields != nil {
+			record.Payload = c.scrub(payload)
+		}
+		line, err := json.Marshal(record)
+		if err == nil {
+			err = c.deadLetterFile.write(append(line, '\n'))
+		}
+		if err != nil {
+			c.errLogger.Err(err).Msg("cannot write to dead letter file")
+			c.metrics.deadLetterErrors.WithLabelValues("file").Inc()
+		} else {
+			c.metrics.deadLetterMessages.WithLabelValues("file").Inc()
+		}
+	}
+
+	// Messages rejected from the dead letter topic are not sent again. When
+	// stopping, the producer does not accept messages anymore.
+	deadLetterTopic := c.config.DeadLetter.Topic
+	if deadLetterTopic == "" || topic == deadLetterTopic || !c.t.Alive() {
+		return
+	}
+	if maxSize := c.config.MaxMessageBytes - deadLetterOverhead; len(payload) > maxSize {
+		payload = payload[:max(maxSize, 0)]
+	}
+	headers := []sarama.RecordHeader{
+		{Key: []byte("akvorado-error"), Value: []byte(reason.Error())},
+		{Key: []byte("akvorado-topic"), Value: []byte(topic)},
+	}
+	if exporter != "" {
+		headers = append(headers, sarama.RecordHeader{
+			Key:   []byte("akvorado-exporter"),
+			Value: []byte(exporter),
+		})
+	}
+	c.pending.Add(1)
+	select {
+	case c.kafkaProducer.Input() <- &sarama.ProducerMessage{
+		Topic:   deadLetterTopic,
+		Value:   sarama.ByteEncoder(payload),
+		Headers: headers,
+	}:
+		c.metrics.deadLetterMessages.WithLabelValues("kafka").Inc()
+	default:
+		// The producer is busy. As this may be called from the loop
+		// reading the producer results, do not block.
+		c.pending.Add(-1)
+		c.metrics.deadLetterErrors.WithLabelValues("kafka").Inc()
+	}
+}
+
+// deadLetterProducerError sends a message rejected by Kafka to the dead
+// letter sinks. Temporary errors are ignored: the message is not faulty.
+func (c *Component) deadLetterProducerError(msg *sarama.ProducerError) {
+	if c.deadLetterFile == nil && c.config.DeadLetter.Topic == "" {
+		return
+	}
+	if retriable(msg.Err) {
+		return
+	}
+	payload, err := msg.Msg.Value.Encode()
+	if err != nil {
+		return
+	}
+	exporter, _ := msg.Msg.Metadata.(string)
+	c.deadLetter(msg.Msg.Topic, exporter, payload, msg.Err)
+}
+
+// scrub returns a copy of a protobuf payload with IP addresses truncated to
+// /24 for IPv4 and /48 for IPv6. It returns nil if the payload cannot be
+// decoded, for example when using Avro encoding.
+func (c *Component) scrub(payload []byte) []byte {
+	size, n := protowire.ConsumeVarint(payload)
+	if n < 0 || uint64(len(payload)-n) != size {
+		return nil
+	}
+	result := slices.Clone(payload)
+	b := result[n:]
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil
+		}
+		b = b[n:]
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return nil
+		}
+		value := b[:n]
+		b = b[n:]
+		if _, ok := c.scrubFields[num]; !ok || typ != protowire.BytesType {
+			continue
+		}
+		ip, _ := protowire.ConsumeBytes(value)
+		switch {
+		case len(ip) == 16 && bytes.HasPrefix(ip, ipv4MappedPrefix):
+			clear(ip[15:])
+		case len(ip) == 16:
+			clear(ip[6:])
+		case len(ip) == 4:
+			clear(ip[3:])
+		}
+	}
+	return result
+}
+
+// ipv4MappedPrefix is the prefix of IPv4-mapped IPv6 addresses.
+var ipv4MappedPrefix = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff}
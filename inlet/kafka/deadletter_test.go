@@ -0,0 +1,176 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package kafka
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/kafka"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+)
+
+func TestDeadLetterFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead", "letters.jsonl")
+	f, err := newDeadLetterFile(path, 100, 2)
+	if err != nil {
+		t.Fatalf("newDeadLetterFile() error:\n%+v", err)
+	}
+	line := append(bytes.Repeat([]byte("x"), 39), '\n')
+	for range 7 {
+		if err := f.write(line); err != nil {
+			t.Fatalf("write() error:\n%+v", err)
+		}
+	}
+	if err := f.close(); err != nil {
+		t.Fatalf("close() error:\n%+v", err)
+	}
+
+	got := map[string]int64{}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("ReadDir() error:\n%+v", err)
+	}
+	for _, entry := range entries {
+		info, _ := entry.Info()
+		got[entry.Name()] = info.Size()
+	}
+	expected := map[string]int64{
+		"letters.jsonl":   40,
+		"letters.jsonl.1": 80,
+		"letters.jsonl.2": 80,
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("files (-got, +want):\n%s", diff)
+	}
+}
+
+func TestScrub(t *testing.T) {
+	sch := schema.NewMock(t)
+	c := Component{d: &Dependencies{Schema: sch}}
+	c.config.DeadLetter.File = filepath.Join(t.TempDir(), "letters.jsonl")
+	c.config.DeadLetter.Scrub = true
+	if err := c.initDeadLetter(); err != nil {
+		t.Fatalf("initDeadLetter() error:\n%+v", err)
+	}
+	defer c.deadLetterFile.close()
+
+	payload := sch.ProtobufMarshal(&schema.FlowMessage{
+		ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.1"),
+		SrcAddr:         netip.MustParseAddr("::ffff:198.51.100.17"),
+		DstAddr:         netip.MustParseAddr("2001:db8:1:2:3::4"),
+		SrcAS:           65000,
+	})
+	original := bytes.Clone(payload)
+	got, err := sch.ProtobufDecodeMap(c.scrub(payload))
+	if err != nil {
+		t.Fatalf("ProtobufDecodeMap() error:\n%+v", err)
+	}
+	for name, value := range map[string]any{
+		"ExporterAddress": "192.0.2.0",
+		"SrcAddr":         "198.51.100.0",
+		"DstAddr":         "2001:db8:1::",
+		"SrcAS":           uint64(65000),
+	} {
+		if diff := helpers.Diff(got[name], value); diff != "" {
+			t.Errorf("scrub() %s (-got, +want):\n%s", name, diff)
+		}
+	}
+	if !bytes.Equal(payload, original) {
+		t.Error("scrub() modified the original payload")
+	}
+	if got := c.scrub([]byte{0, 1, 2, 3}); got != nil {
+		t.Errorf("scrub() == %v, expected nil", got)
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration()
+	config.DeadLetter.File = filepath.Join(t.TempDir(), "letters.jsonl")
+	config.DeadLetter.Topic = "flows-dead-letter"
+	c, mockProducer := NewMock(t, r, config)
+
+	received := make(chan *sarama.ProducerMessage, 1)
+	mockProducer.ExpectInputAndFail(sarama.ErrMessageSizeTooLarge)
+	mockProducer.ExpectInputWithMessageCheckerFunctionAndSucceed(
+		func(got *sarama.ProducerMessage) error {
+			received <- got
+			return nil
+		})
+	c.Send("127.0.0.1", "", nil, []byte("hello world!"))
+	var got *sarama.ProducerMessage
+	select {
+	case got = <-received:
+	case <-time.After(time.Second):
+		t.Fatal("dead letter message not received")
+	}
+	expected := &sarama.ProducerMessage{
+		Topic: "flows-dead-letter",
+		Value: sarama.ByteEncoder("hello world!"),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte("akvorado-error"), Value: []byte(sarama.ErrMessageSizeTooLarge.Error())},
+			{Key: []byte("akvorado-topic"), Value: []byte(c.Topic(""))},
+			{Key: []byte("akvorado-exporter"), Value: []byte("127.0.0.1")},
+		},
+		Offset:    got.Offset,
+		Partition: got.Partition,
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("dead letter message (-got, +want):\n%s", diff)
+	}
+
+	content, err := os.ReadFile(config.DeadLetter.File)
+	if err != nil {
+		t.Fatalf("ReadFile() error:\n%+v", err)
+	}
+	var record deadLetterRecord
+	if err := json.Unmarshal(content, &record); err != nil {
+		t.Fatalf("Unmarshal() error:\n%+v", err)
+	}
+	record.Time = time.Time{}
+	if diff := helpers.Diff(record, deadLetterRecord{
+		Topic:    c.Topic(""),
+		Exporter: "127.0.0.1",
+		Error:    sarama.ErrMessageSizeTooLarge.Error(),
+		Payload:  []byte("hello world!"),
+	}); diff != "" {
+		t.Fatalf("dead letter record (-got, +want):\n%s", diff)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	gotMetrics := r.GetMetrics("akvorado_inlet_kafka_", "dead_letter_")
+	expectedMetrics := map[string]string{
+		`dead_letter_messages_total{sink="file"}`:  "1",
+		`dead_letter_messages_total{sink="kafka"}`: "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestDeadLetterOldKafka(t *testing.T) {
+	config := DefaultConfiguration()
+	config.DeadLetter.Topic = "flows-dead-letter"
+	for _, version := range []sarama.KafkaVersion{sarama.V0_10_2_0, sarama.V0_11_0_0} {
+		config.Version = kafka.Version(version)
+		_, err := New(reporter.NewMock(t), config, Dependencies{
+			Daemon: daemon.NewMock(t),
+			Schema: schema.NewMock(t),
+		})
+		if (err == nil) != version.IsAtLeast(sarama.V0_11_0_0) {
+			t.Errorf("New() with Kafka %s error: %v", version, err)
+		}
+	}
+}
@@ -45,8 +45,8 @@ func TestRealKafka(t *testing.T) {
 	for i := range msg2 {
 		msg1[i] = letters[rand.Intn(len(letters))]
 	}
-	c.Send("127.0.0.1", msg1)
-	c.Send("127.0.0.1", msg2)
+	c.Send("127.0.0.1", "", nil, msg1)
+	c.Send("127.0.0.1", "", nil, msg2)
 
 	time.Sleep(10 * time.Millisecond)
 	gotMetrics := r.GetMetrics("akvorado_inlet_kafka_", "sent_")
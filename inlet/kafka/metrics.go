@@ -15,9 +15,26 @@ import (
 type metrics struct {
 	c *Component
 
-	messagesSent *reporter.CounterVec
-	bytesSent    *reporter.CounterVec
-	errors       *reporter.CounterVec
+	messagesSent      *reporter.CounterVec
+	bytesSent         *reporter.CounterVec
+	errors            *reporter.CounterVec
+	topicMessagesSent *reporter.CounterVec
+
+	partitionMessagesSent *reporter.CounterVec
+	partitionBytesSent    *reporter.CounterVec
+
+	shutdownFlushed reporter.Counter
+	shutdownDropped reporter.Counter
+
+	spilledMessages      reporter.Counter
+	spillDroppedSegments reporter.Counter
+	spillDroppedBytes    reporter.Counter
+	spillDroppedMessages reporter.Counter
+	replayedMessages     reporter.Counter
+	replayedSegments     reporter.Counter
+
+	deadLetterMessages *reporter.CounterVec
+	deadLetterErrors   *reporter.CounterVec
 
 	kafkaIncomingByteRate  *reporter.MetricDesc
 	kafkaOutgoingByteRate  *reporter.MetricDesc
@@ -43,6 +60,41 @@ func (c *Component) initMetrics() {
 		},
 		[]string{"exporter"},
 	)
+	c.metrics.topicMessagesSent = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "topic_sent_messages_total",
+			Help: "Number of messages sent to a given topic.",
+		},
+		[]string{"topic"},
+	)
+	c.metrics.partitionMessagesSent = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "partition_sent_messages_total",
+			Help: "Number of messages acknowledged by Kafka for a given partition.",
+		},
+		[]string{"topic", "partition"},
+	)
+	c.metrics.partitionBytesSent = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "partition_sent_bytes_total",
+			Help: "Number of bytes acknowledged by Kafka for a given partition.",
+		},
+		[]string{"topic", "partition"},
+	)
+	c.metrics.deadLetterMessages = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "dead_letter_messages_total",
+			Help: "Number of rejected messages written to a dead letter sink.",
+		},
+		[]string{"sink"},
+	)
+	c.metrics.deadLetterErrors = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "dead_letter_errors_total",
+			Help: "Number of rejected messages which cannot be written to a dead letter sink.",
+		},
+		[]string{"sink"},
+	)
 	c.metrics.bytesSent = c.r.CounterVec(
 		reporter.CounterOpts{
 			Name: "sent_bytes_total",
@@ -57,6 +109,76 @@ func (c *Component) initMetrics() {
 		},
 		[]string{"error"},
 	)
+	c.metrics.shutdownFlushed = c.r.Counter(
+		reporter.CounterOpts{
+			Name: "shutdown_flushed_messages_total",
+			Help: "Number of pending messages flushed when stopping.",
+		},
+	)
+	c.metrics.shutdownDropped = c.r.Counter(
+		reporter.CounterOpts{
+			Name: "shutdown_dropped_messages_total",
+			Help: "Number of pending messages dropped when stopping.",
+		},
+	)
+	if c.spool != nil {
+		c.metrics.spilledMessages = c.r.Counter(
+			reporter.CounterOpts{
+				Name: "spill_messages_total",
+				Help: "Number of messages buffered on disk.",
+			},
+		)
+		c.metrics.spillDroppedSegments = c.r.Counter(
+			reporter.CounterOpts{
+				Name: "spill_dropped_segments_total",
+				Help: "Number of segments dropped to respect the maximum size of the disk buffer.",
+			},
+		)
+		c.metrics.spillDroppedBytes = c.r.Counter(
+			reporter.CounterOpts{
+				Name: "spill_dropped_bytes_total",
+				Help: "Number of bytes dropped to respect the maximum size of the disk buffer.",
+			},
+		)
+		c.metrics.spillDroppedMessages = c.r.Counter(
+			reporter.CounterOpts{
+				Name: "spill_dropped_messages_total",
+				Help: "Number of messages rejected by Kafka with a non-retriable error and not buffered on disk.",
+			},
+		)
+		c.metrics.replayedMessages = c.r.Counter(
+			reporter.CounterOpts{
+				Name: "spill_replayed_messages_total",
+				Help: "Number of messages replayed from disk to Kafka.",
+			},
+		)
+		c.metrics.replayedSegments = c.r.Counter(
+			reporter.CounterOpts{
+				Name: "spill_replayed_segments_total",
+				Help: "Number of segments fully replayed from disk to Kafka.",
+			},
+		)
+		c.r.GaugeFunc(
+			reporter.GaugeOpts{
+				Name: "spill_buffered_bytes",
+				Help: "Number of bytes buffered on disk.",
+			},
+			func() float64 {
+				bytes, _ := c.spool.size()
+				return float64(bytes)
+			},
+		)
+		c.r.GaugeFunc(
+			reporter.GaugeOpts{
+				Name: "spill_buffered_segments",
+				Help: "Number of segments buffered on disk.",
+			},
+			func() float64 {
+				_, segments := c.spool.size()
+				return float64(segments)
+			},
+		)
+	}
 
 	c.metrics.kafkaIncomingByteRate = c.r.MetricDesc(
 		"brokers_incoming_byte_rate",
@@ -0,0 +1,161 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package kafka
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/gin-gonic/gin"
+
+	"akvorado/common/schema"
+)
+
+const (
+	// partitionStatsBuckets is the number of buckets kept to compute the
+	// partition distribution.
+	partitionStatsBuckets = 6
+	// partitionStatsBucketDuration is the duration covered by each bucket.
+	partitionStatsBucketDuration = 10 * time.Second
+)
+
+// PartitionFields returns the columns to hash to build the key given to Send.
+// It is empty unless the fields partitioner is used.
+func (c *Component) PartitionFields() []schema.ColumnKey {
+	if c.config.Partitioner != PartitionerFields {
+		return nil
+	}
+	return c.config.PartitionFields
+}
+
+// newPartitioner returns the sarama partitioner to use for the provided
+// configuration.
+func newPartitioner(p Partitioner) sarama.PartitionerConstructor {
+	switch p {
+	case PartitionerRoundRobin:
+		return sarama.NewRoundRobinPartitioner
+	case PartitionerExporter, PartitionerFields:
+		return sarama.NewHashPartitioner
+	default:
+		return sarama.NewRandomPartitioner
+	}
+}
+
+// topicPartition identifies a partition of a topic.
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// partitionCounters counts messages and bytes acknowledged by Kafka.
+type partitionCounters struct {
+	messages uint64
+	bytes    uint64
+}
+
+// partitionStatsBucket holds the counters for one time slot.
+type partitionStatsBucket struct {
+	start    time.Time
+	counters map[topicPartition]partitionCounters
+}
+
+// partitionStats keeps the distribution of the messages acknowledged by
+// Kafka across partitions during the last minute.
+type partitionStats struct {
+	lock    sync.Mutex
+	buckets [partitionStatsBuckets]partitionStatsBucket
+}
+
+// add records a message acknowledged by Kafka.
+func (ps *partitionStats) add(now time.Time, topic string, partition int32, bytes int) {
+	start := now.Truncate(partitionStatsBucketDuration)
+	bucket := &ps.buckets[(start.Unix()/int64(partitionStatsBucketDuration/time.Second))%partitionStatsBuckets]
+	key := topicPartition{topic, partition}
+
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	if !bucket.start.Equal(start) {
+		bucket.start = start
+		bucket.counters = map[topicPartition]partitionCounters{}
+	}
+	counters := bucket.counters[key]
+	counters.messages++
+	counters.bytes += uint64(bytes)
+	bucket.counters[key] = counters
+}
+
+// snapshot returns the counters for each partition over the last minute.
+func (ps *partitionStats) snapshot(now time.Time) map[topicPartition]partitionCounters {
+	oldest := now.Truncate(partitionStatsBucketDuration).
+		Add(-(partitionStatsBuckets - 1) * partitionStatsBucketDuration)
+	result := map[topicPartition]partitionCounters{}
+
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	for _, bucket := range ps.buckets {
+		if bucket.start.Before(oldest) {
+			continue
+		}
+		for key, counters := range bucket.counters {
+			total := result[key]
+			total.messages += counters.messages
+			total.bytes += counters.bytes
+			result[key] = total
+		}
+	}
+	return result
+}
+
+// recordSuccess updates metrics and statistics for a message acknowledged by
+// Kafka.
+func (c *Component) recordSuccess(msg *sarama.ProducerMessage) {
+	partition := strconv.Itoa(int(msg.Partition))
+	size := msg.Value.Length()
+	c.metrics.partitionMessagesSent.WithLabelValues(msg.Topic, partition).Inc()
+	c.metrics.partitionBytesSent.WithLabelValues(msg.Topic, partition).Add(float64(size))
+	c.partitionStats.add(time.Now(), msg.Topic, msg.Partition, size)
+}
+
+// partitionStatus is the distribution of messages for a partition.
+type partitionStatus struct {
+	Topic     string  `json:"topic"`
+	Partition int32   `json:"partition"`
+	Messages  uint64  `json:"messages"`
+	Bytes     uint64  `json:"bytes"`
+	Share     float64 `json:"share"`
+}
+
+// partitionsHTTPHandler returns the number of messages and bytes sent to each
+// partition during the last minute. The share is the fraction of the messages
+// of the topic sent to the partition. Partitions without any message are not
+// listed.
+func (c *Component) partitionsHTTPHandler(gc *gin.Context) {
+	snapshot := c.partitionStats.snapshot(time.Now())
+	totals := map[string]uint64{}
+	for key, counters := range snapshot {
+		totals[key.topic] += counters.messages
+	}
+	result := make([]partitionStatus, 0, len(snapshot))
+	for key, counters := range snapshot {
+		result = append(result, partitionStatus{
+			Topic:     key.topic,
+			Partition: key.partition,
+			Messages:  counters.messages,
+			Bytes:     counters.bytes,
+			Share:     float64(counters.messages) / float64(totals[key.topic]),
+		})
+	}
+	slices.SortFunc(result, func(a, b partitionStatus) int {
+		if n := cmp.Compare(a.Topic, b.Topic); n != 0 {
+			return n
+		}
+		return cmp.Compare(a.Partition, b.Partition)
+	})
+	gc.JSON(http.StatusOK, gin.H{"partitions": result})
+}
@@ -0,0 +1,200 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package kafka
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/gin-gonic/gin"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+)
+
+func TestPartitioners(t *testing.T) {
+	cases := []struct {
+		Pos         helpers.Pos
+		Partitioner Partitioner
+		Exporters   []string
+		Keys        [][]byte
+		Expected    func(partitions []int32) error
+	}{
+		{
+			Pos:         helpers.Mark(),
+			Partitioner: PartitionerRoundRobin,
+			Exporters:   []string{"127.0.0.1", "127.0.0.1", "127.0.0.1"},
+			Keys:        [][]byte{nil, nil, nil},
+			Expected: func(partitions []int32) error {
+				if partitions[0] == partitions[1] || partitions[1] == partitions[2] {
+					return fmt.Errorf("partitions %v are not distinct", partitions)
+				}
+				return nil
+			},
+		}, {
+			Pos:         helpers.Mark(),
+			Partitioner: PartitionerExporter,
+			Exporters:   []string{"127.0.0.1", "127.0.0.1", "127.0.0.1"},
+			Keys:        [][]byte{nil, nil, nil},
+			Expected: func(partitions []int32) error {
+				if partitions[0] != partitions[1] || partitions[1] != partitions[2] {
+					return fmt.Errorf("partitions %v are not identical", partitions)
+				}
+				return nil
+			},
+		}, {
+			Pos:         helpers.Mark(),
+			Partitioner: PartitionerFields,
+			Exporters:   []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"},
+			Keys:        [][]byte{[]byte("key"), []byte("key"), []byte("key")},
+			Expected: func(partitions []int32) error {
+				if partitions[0] != partitions[1] || partitions[1] != partitions[2] {
+					return fmt.Errorf("partitions %v are not identical", partitions)
+				}
+				return nil
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Partitioner.String(), func(t *testing.T) {
+			r := reporter.NewMock(t)
+			config := DefaultConfiguration()
+			config.Partitioner = tc.Partitioner
+			config.PartitionFields = []schema.ColumnKey{schema.ColumnSrcAddr}
+			c, mockProducer := NewMock(t, r, config)
+
+			received := make(chan int32)
+			for range tc.Exporters {
+				mockProducer.ExpectInputWithMessageCheckerFunctionAndSucceed(
+					func(got *sarama.ProducerMessage) error {
+						received <- got.Partition
+						return nil
+					})
+			}
+			partitions := []int32{}
+			for idx, exporter := range tc.Exporters {
+				go c.Send(exporter, "", tc.Keys[idx], []byte("hello world!"))
+				select {
+				case partition := <-received:
+					partitions = append(partitions, partition)
+				case <-time.After(time.Second):
+					t.Fatalf("%sKafka message not received", tc.Pos)
+				}
+			}
+			if err := tc.Expected(partitions); err != nil {
+				t.Fatalf("%sSend() error:\n%+v", tc.Pos, err)
+			}
+		})
+	}
+}
+
+func TestPartitionFieldsValidation(t *testing.T) {
+	cases := []struct {
+		Pos    helpers.Pos
+		Fields []schema.ColumnKey
+		Error  bool
+	}{
+		{helpers.Mark(), []schema.ColumnKey{schema.ColumnSrcAddr, schema.ColumnDstAddr}, false},
+		{helpers.Mark(), []schema.ColumnKey{}, true},
+		{helpers.Mark(), []schema.ColumnKey{schema.ColumnSrcMAC}, true},
+	}
+	for _, tc := range cases {
+		config := DefaultConfiguration()
+		config.Partitioner = PartitionerFields
+		config.PartitionFields = tc.Fields
+		c, err := New(reporter.NewMock(t), config, Dependencies{
+			Daemon: daemon.NewMock(t),
+			Schema: schema.NewMock(t),
+		})
+		if err == nil && tc.Error {
+			t.Errorf("%sNew() did not error", tc.Pos)
+		} else if err != nil && !tc.Error {
+			t.Errorf("%sNew() error:\n%+v", tc.Pos, err)
+		} else if err == nil {
+			if diff := helpers.Diff(c.PartitionFields(), tc.Fields); diff != "" {
+				t.Errorf("%sPartitionFields() (-got, +want):\n%s", tc.Pos, diff)
+			}
+		}
+	}
+}
+
+func TestPartitionStats(t *testing.T) {
+	var ps partitionStats
+	now := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	ps.add(now, "flows", 0, 100)
+	ps.add(now.Add(5*time.Second), "flows", 1, 200)
+	ps.add(now.Add(30*time.Second), "flows", 1, 200)
+	ps.add(now.Add(50*time.Second), "flows", 2, 50)
+
+	got := ps.snapshot(now.Add(55 * time.Second))
+	expected := map[topicPartition]partitionCounters{
+		{"flows", 0}: {messages: 1, bytes: 100},
+		{"flows", 1}: {messages: 2, bytes: 400},
+		{"flows", 2}: {messages: 1, bytes: 50},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("snapshot() (-got, +want):\n%s", diff)
+	}
+
+	// One minute later, the first bucket is reused.
+	ps.add(now.Add(61*time.Second), "flows", 2, 50)
+	got = ps.snapshot(now.Add(65 * time.Second))
+	expected = map[topicPartition]partitionCounters{
+		{"flows", 1}: {messages: 1, bytes: 200},
+		{"flows", 2}: {messages: 2, bytes: 100},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("snapshot() (-got, +want):\n%s", diff)
+	}
+
+	// Much later, nothing is left.
+	got = ps.snapshot(now.Add(time.Hour))
+	if diff := helpers.Diff(got, map[topicPartition]partitionCounters{}); diff != "" {
+		t.Fatalf("snapshot() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestPartitionsHTTPEndpoint(t *testing.T) {
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration()
+	config.Partitioner = PartitionerRoundRobin
+	c, mockProducer := NewMock(t, r, config)
+
+	received := make(chan bool)
+	for range 4 {
+		mockProducer.ExpectInputWithMessageCheckerFunctionAndSucceed(
+			func(*sarama.ProducerMessage) error {
+				received <- true
+				return nil
+			})
+	}
+	for range 4 {
+		go c.Send("127.0.0.1", "", nil, []byte("hello world!"))
+		select {
+		case <-received:
+		case <-time.After(time.Second):
+			t.Fatal("Kafka message not received")
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	topic := c.Topic("")
+	helpers.TestHTTPEndpoints(t, c.d.HTTP.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			URL: "/api/v0/inlet/kafka/partitions",
+			JSONOutput: gin.H{
+				"partitions": []gin.H{
+					{"topic": topic, "partition": 0, "messages": 1, "bytes": 12, "share": 0.25},
+					{"topic": topic, "partition": 1, "messages": 1, "bytes": 12, "share": 0.25},
+					{"topic": topic, "partition": 2, "messages": 1, "bytes": 12, "share": 0.25},
+					{"topic": topic, "partition": 3, "messages": 1, "bytes": 12, "share": 0.25},
+				},
+			},
+		},
+	})
+}
@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// registerAvroSchema registers the Avro schema to the schema registry for the
+// provided subject and returns its ID. If the schema is already registered for
+// the subject, the existing ID is returned. Otherwise, a new version is
+// created. The registry checks the compatibility with the previous versions.
+func (c *Component) registerAvroSchema(ctx context.Context, subject string) (uint32, error) {
+	config := c.config.SchemaRegistry
+	body, err := json.Marshal(map[string]string{"schema": c.avroEncoder.Schema()})
+	if err != nil {
+		return 0, err
+	}
+	endpoint := fmt.Sprintf("%s/subjects/%s/versions",
+		strings.TrimRight(config.URL, "/"), url.PathEscape(subject))
+	ctx, cancel := context.WithTimeout(ctx, config.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json, application/json")
+	if config.Username != "" {
+		req.SetBasicAuth(config.Username, config.Password)
+	}
+	resp, err := c.registryClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("cannot register schema: %w", err)
+	}
+	defer resp.Body.Close()
+	payload, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read schema registry answer: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var registryError struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(payload, &registryError) == nil && registryError.Message != "" {
+			return 0, fmt.Errorf("cannot register schema: %s (%s)", registryError.Message, resp.Status)
+		}
+		return 0, fmt.Errorf("cannot register schema: %s", resp.Status)
+	}
+	var result struct {
+		ID uint32 `json:"id"`
+	}
+	if err := json.Unmarshal(payload, &result); err != nil {
+		return 0, fmt.Errorf("cannot decode schema registry answer: %w", err)
+	}
+	return result.ID, nil
+}
@@ -5,16 +5,22 @@
 package kafka
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
-	"math/rand"
+	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/IBM/sarama"
+	"google.golang.org/protobuf/encoding/protowire"
 	"gopkg.in/tomb.v2"
 
 	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/httpserver"
 	"akvorado/common/kafka"
 	"akvorado/common/reporter"
 	"akvorado/common/schema"
@@ -28,51 +34,127 @@ type Component struct {
 	config Configuration
 
 	kafkaTopic          string
+	topicHash           string
+	headers             []sarama.RecordHeader
 	kafkaConfig         *sarama.Config
 	kafkaProducer       sarama.AsyncProducer
 	createKafkaProducer func() (sarama.AsyncProducer, error)
 	metrics             metrics
+	errLogger           reporter.Logger
+	partitionStats      partitionStats
+
+	// For Avro encoding
+	avroEncoder    *schema.AvroEncoder
+	avroSchemaIDs  map[string]uint32 // topic → schema ID
+	registryClient *http.Client
+
+	// pending is the number of messages sent to the producer and not yet
+	// acknowledged (successfully or not).
+	pending atomic.Int64
+
+	// For disk buffering
+	spool     *spool
+	lastError atomic.Int64 // last producer error, as Unix nanoseconds
+
+	// For dead letter handling
+	deadLetterFile *deadLetterFile
+	scrubFields    map[protowire.Number]struct{}
 }
 
 // Dependencies define the dependencies of the Kafka exporter.
 type Dependencies struct {
 	Daemon daemon.Component
+	HTTP   *httpserver.Component
 	Schema *schema.Component
 }
 
 // New creates a new Kafka exporter component.
 func New(reporter *reporter.Reporter, configuration Configuration, dependencies Dependencies) (*Component, error) {
 	// Build Kafka configuration
-	kafkaConfig, err := kafka.NewConfig(configuration.Configuration)
+	kafkaConfig, err := kafka.NewConfig(reporter, configuration.Configuration)
 	if err != nil {
 		return nil, err
 	}
 	kafkaConfig.Metadata.AllowAutoTopicCreation = true
 	kafkaConfig.Producer.MaxMessageBytes = configuration.MaxMessageBytes
 	kafkaConfig.Producer.Compression = sarama.CompressionCodec(configuration.CompressionCodec)
-	kafkaConfig.Producer.Return.Successes = false
+	kafkaConfig.Producer.Return.Successes = true
 	kafkaConfig.Producer.Return.Errors = true
 	kafkaConfig.Producer.Flush.Bytes = configuration.FlushBytes
 	kafkaConfig.Producer.Flush.Frequency = configuration.FlushInterval
-	kafkaConfig.Producer.Partitioner = sarama.NewHashPartitioner
+	kafkaConfig.Producer.Partitioner = newPartitioner(configuration.Partitioner)
 	kafkaConfig.ChannelBufferSize = configuration.QueueSize
 	if err := kafkaConfig.Validate(); err != nil {
 		return nil, fmt.Errorf("cannot validate Kafka configuration: %w", err)
 	}
 
+	if configuration.Headers && !sarama.KafkaVersion(configuration.Version).IsAtLeast(sarama.V0_11_0_0) {
+		return nil, errors.New("Kafka headers require Kafka 0.11 or more recent")
+	}
+	if configuration.Partitioner == PartitionerFields {
+		if len(configuration.PartitionFields) == 0 {
+			return nil, errors.New("the fields partitioner requires at least one partition field")
+		}
+		for _, key := range configuration.PartitionFields {
+			if column, ok := dependencies.Schema.LookupColumnByKey(key); !ok || column.Disabled {
+				return nil, fmt.Errorf("partition field %s is not an enabled column", key)
+			}
+		}
+	}
+
 	c := Component{
 		r:      reporter,
 		d:      &dependencies,
 		config: configuration,
 
 		kafkaConfig: kafkaConfig,
-		kafkaTopic:  fmt.Sprintf("%s-%s", configuration.Topic, dependencies.Schema.ProtobufMessageHash()),
+	}
+	hash := dependencies.Schema.ProtobufMessageHash()
+	if configuration.Encoding == EncodingAvro {
+		if configuration.SchemaRegistry.URL == "" {
+			return nil, errors.New("a schema registry URL is needed for Avro encoding")
+		}
+		tlsConfig, err := configuration.SchemaRegistry.TLS.MakeTLSConfig()
+		if err != nil {
+			return nil, fmt.Errorf("cannot setup TLS for schema registry: %w", err)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if tlsConfig != nil {
+			transport.TLSClientConfig = tlsConfig
+		}
+		// The schema registry handles schema evolution: the topic name
+		// does not depend on the schema.
+		hash = ""
+		c.avroEncoder = dependencies.Schema.NewAvroEncoder()
+		c.avroSchemaIDs = map[string]uint32{}
+		c.registryClient = &http.Client{Transport: transport}
+	}
+	c.topicHash = hash
+	if configuration.Headers {
+		c.headers = []sarama.RecordHeader{
+			{Key: []byte("akvorado-schema-hash"), Value: []byte(dependencies.Schema.ProtobufMessageHash())},
+			{Key: []byte("akvorado-version"), Value: []byte(helpers.AkvoradoVersion)},
+		}
+	}
+	c.kafkaTopic = configuration.TopicName("", hash)
+	if configuration.Spill.Directory != "" {
+		c.spool, err = newSpool(configuration.Spill.Directory,
+			configuration.Spill.MaxSize, configuration.Spill.SegmentSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := c.initDeadLetter(); err != nil {
+		return nil, err
 	}
 	c.initMetrics()
 	c.createKafkaProducer = func() (sarama.AsyncProducer, error) {
 		return sarama.NewAsyncProducer(c.config.Brokers, c.kafkaConfig)
 	}
 	c.d.Daemon.Track(&c.t, "inlet/kafka")
+	if c.d.HTTP != nil {
+		c.d.HTTP.GinRouter.GET("/api/v0/inlet/kafka/partitions", c.partitionsHTTPHandler)
+	}
 	return &c, nil
 }
 
@@ -80,6 +162,34 @@ func New(reporter *reporter.Reporter, configuration Configuration, dependencies
 func (c *Component) Start() error {
 	c.r.Info().Msg("starting Kafka component")
 	kafka.GlobalKafkaLogger.Register(c.r)
+	c.errLogger = c.r.Sample(reporter.BurstSampler(10*time.Second, 3))
+
+	// Register Avro schema for each topic
+	if c.avroEncoder != nil {
+		topics := []string{c.kafkaTopic}
+		for _, rule := range c.config.TopicRules {
+			topics = append(topics, c.Topic(rule.Suffix))
+		}
+		for _, topic := range topics {
+			if _, ok := c.avroSchemaIDs[topic]; ok {
+				continue
+			}
+			subject := c.config.SchemaRegistry.Subject
+			if subject == "" {
+				subject = fmt.Sprintf("%s-value", topic)
+			}
+			id, err := c.registerAvroSchema(context.Background(), subject)
+			if err != nil {
+				c.r.Err(err).
+					Str("url", c.config.SchemaRegistry.URL).
+					Str("subject", subject).
+					Msg("unable to register Avro schema")
+				return fmt.Errorf("unable to register Avro schema: %w", err)
+			}
+			c.avroSchemaIDs[topic] = id
+			c.r.Info().Str("subject", subject).Uint32("id", id).Msg("Avro schema registered")
+		}
+	}
 
 	// Create producer
 	kafkaProducer, err := c.createKafkaProducer()
@@ -91,24 +201,66 @@ func (c *Component) Start() error {
 	}
 	c.kafkaProducer = kafkaProducer
 
+	// Replay loop
+	var replayDone chan struct{}
+	if c.spool != nil {
+		if bytes, segments := c.spool.size(); segments > 0 {
+			c.r.Info().
+				Int64("bytes", bytes).
+				Int("segments", segments).
+				Msg("messages buffered on disk will be replayed to Kafka")
+		}
+		replayDone = make(chan struct{})
+		c.t.Go(func() error {
+			defer close(replayDone)
+			ticker := time.NewTicker(c.config.Spill.ReplayInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-c.t.Dying():
+					return nil
+				case <-ticker.C:
+					c.replay(kafkaProducer)
+				}
+			}
+		})
+	}
+
 	// Main loop
 	c.t.Go(func() error {
-		defer kafkaProducer.Close()
 		defer c.kafkaConfig.MetricRegistry.UnregisterAll()
-		errLogger := c.r.Sample(reporter.BurstSampler(10*time.Second, 3))
+		errLogger := c.errLogger
 		for {
 			select {
 			case <-c.t.Dying():
 				c.r.Debug().Msg("stop error logger")
+				if replayDone != nil {
+					// The producer cannot be closed while replaying.
+					<-replayDone
+				}
+				c.flush(kafkaProducer, errLogger)
+				if c.spool != nil {
+					if err := c.spool.close(); err != nil {
+						c.r.Err(err).Msg("cannot close spill segment")
+					}
+				}
+				if c.deadLetterFile != nil {
+					if err := c.deadLetterFile.close(); err != nil {
+						c.r.Err(err).Msg("cannot close dead letter file")
+					}
+				}
 				return nil
+			case msg := <-kafkaProducer.Successes():
+				if msg != nil {
+					c.pending.Add(-1)
+					c.recordSuccess(msg)
+				}
 			case msg := <-kafkaProducer.Errors():
 				if msg != nil {
-					c.metrics.errors.WithLabelValues(msg.Error()).Inc()
-					errLogger.Err(msg.Err).
-						Str("topic", msg.Msg.Topic).
-						Int64("offset", msg.Msg.Offset).
-						Int32("partition", msg.Msg.Partition).
-						Msg("Kafka producer error")
+					c.pending.Add(-1)
+					c.reportError(errLogger, msg)
+					c.deadLetterProducerError(msg)
+					c.respill(msg)
 				}
 			}
 		}
@@ -116,6 +268,67 @@ func (c *Component) Start() error {
 	return nil
 }
 
+// flush closes the producer and waits for pending messages to be
+// acknowledged, up to the configured shutdown timeout. Messages still
+// pending after the deadline are counted as dropped.
+func (c *Component) flush(producer sarama.AsyncProducer, errLogger reporter.Logger) {
+	c.r.Info().Int64("pending", c.pending.Load()).Msg("flushing pending messages to Kafka")
+	producer.AsyncClose()
+	timer := time.NewTimer(c.config.ShutdownTimeout)
+	defer timer.Stop()
+
+	var flushed, dropped int64
+	successes, errors := producer.Successes(), producer.Errors()
+	for successes != nil || errors != nil {
+		select {
+		case msg, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+			if msg != nil {
+				c.pending.Add(-1)
+				c.recordSuccess(msg)
+				flushed++
+			}
+		case msg, ok := <-errors:
+			if !ok {
+				errors = nil
+				continue
+			}
+			if msg != nil {
+				c.pending.Add(-1)
+				c.reportError(errLogger, msg)
+				c.deadLetterProducerError(msg)
+				if !c.respill(msg) {
+					dropped++
+				}
+			}
+		case <-timer.C:
+			c.r.Warn().Msg("timeout while flushing pending messages to Kafka")
+			dropped += c.pending.Swap(0)
+			successes, errors = nil, nil
+		}
+	}
+
+	c.metrics.shutdownFlushed.Add(float64(flushed))
+	c.metrics.shutdownDropped.Add(float64(dropped))
+	c.r.Info().
+		Int64("flushed", flushed).
+		Int64("dropped", dropped).
+		Msg("pending messages to Kafka flushed")
+}
+
+// reportError reports an error from the Kafka producer.
+func (c *Component) reportError(errLogger reporter.Logger, msg *sarama.ProducerError) {
+	c.metrics.errors.WithLabelValues(msg.Error()).Inc()
+	errLogger.Err(msg.Err).
+		Str("topic", msg.Msg.Topic).
+		Int64("offset", msg.Msg.Offset).
+		Int32("partition", msg.Msg.Partition).
+		Msg("Kafka producer error")
+}
+
 // Stop stops the Kafka component
 func (c *Component) Stop() error {
 	defer func() {
@@ -127,15 +340,62 @@ func (c *Component) Stop() error {
 	return c.t.Wait()
 }
 
-// Send a message to Kafka.
-func (c *Component) Send(exporter string, payload []byte) {
+// Send a message to Kafka to the provided topic (see Topic). When the topic
+// is empty, the main topic is used. The key is only used by the fields
+// partitioner and should be built from the columns returned by
+// PartitionFields.
+func (c *Component) Send(exporter string, topic string, key []byte, payload []byte) {
+	if topic == "" {
+		topic = c.kafkaTopic
+	}
+	if c.avroEncoder != nil {
+		// Confluent wire format: magic byte, schema ID, Avro payload
+		buf := make([]byte, 5, len(payload)+64)
+		binary.BigEndian.PutUint32(buf[1:], c.avroSchemaIDs[topic])
+		avroPayload, err := c.avroEncoder.Marshal(buf, payload)
+		if err != nil {
+			c.metrics.errors.WithLabelValues("cannot encode to Avro").Inc()
+			c.deadLetter(topic, exporter, payload, err)
+			return
+		}
+		payload = avroPayload
+	}
 	c.metrics.bytesSent.WithLabelValues(exporter).Add(float64(len(payload)))
 	c.metrics.messagesSent.WithLabelValues(exporter).Inc()
-	key := make([]byte, 4)
-	binary.BigEndian.PutUint32(key, rand.Uint32())
-	c.kafkaProducer.Input() <- &sarama.ProducerMessage{
-		Topic: c.kafkaTopic,
-		Key:   sarama.ByteEncoder(key),
-		Value: sarama.ByteEncoder(payload),
+	c.metrics.topicMessagesSent.WithLabelValues(topic).Inc()
+	if c.spool != nil && (c.pending.Load() >= c.config.Spill.Threshold || !c.spool.empty()) {
+		// Kafka is lagging or messages are still buffered on disk:
+		// buffer this one too to keep ordering.
+		c.spill(topic, payload)
+		return
+	}
+	msg := &sarama.ProducerMessage{
+		Topic:    topic,
+		Value:    sarama.ByteEncoder(payload),
+		Metadata: exporter,
 	}
+	switch c.config.Partitioner {
+	case PartitionerExporter:
+		msg.Key = sarama.StringEncoder(exporter)
+	case PartitionerFields:
+		msg.Key = sarama.ByteEncoder(key)
+	}
+	msg.Headers = c.messageHeaders(exporter)
+	c.pending.Add(1)
+	c.kafkaProducer.Input() <- msg
+}
+
+// messageHeaders returns the headers to attach to a message. It is empty
+// unless headers are enabled. The exporter header is omitted when the
+// exporter is unknown.
+func (c *Component) messageHeaders(exporter string) []sarama.RecordHeader {
+	if c.headers == nil || exporter == "" {
+		return c.headers
+	}
+	headers := make([]sarama.RecordHeader, len(c.headers), len(c.headers)+1)
+	copy(headers, c.headers)
+	return append(headers, sarama.RecordHeader{
+		Key:   []byte("akvorado-exporter"),
+		Value: []byte(exporter),
+	})
 }
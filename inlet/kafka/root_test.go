@@ -4,16 +4,22 @@
 package kafka
 
 import (
+	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
 	gometrics "github.com/rcrowley/go-metrics"
 
 	"akvorado/common/daemon"
 	"akvorado/common/helpers"
+	"akvorado/common/kafka"
 	"akvorado/common/reporter"
 	"akvorado/common/schema"
 )
@@ -24,20 +30,23 @@ func TestKafka(t *testing.T) {
 
 	// Send one message
 	received := make(chan bool)
+	var partition int32
 	mockProducer.ExpectInputWithMessageCheckerFunctionAndSucceed(func(got *sarama.ProducerMessage) error {
 		defer close(received)
+		partition = got.Partition
 		expected := sarama.ProducerMessage{
 			Topic:     fmt.Sprintf("flows-%s", c.d.Schema.ProtobufMessageHash()),
 			Key:       got.Key,
 			Value:     sarama.ByteEncoder("hello world!"),
 			Partition: got.Partition,
+			Metadata:  "127.0.0.1",
 		}
 		if diff := helpers.Diff(got, expected); diff != "" {
 			t.Fatalf("Send() (-got, +want):\n%s", diff)
 		}
 		return nil
 	})
-	c.Send("127.0.0.1", []byte("hello world!"))
+	c.Send("127.0.0.1", "", nil, []byte("hello world!"))
 	select {
 	case <-received:
 	case <-time.After(1 * time.Second):
@@ -46,7 +55,7 @@ func TestKafka(t *testing.T) {
 
 	// Another but with a fail
 	mockProducer.ExpectInputAndFail(errors.New("noooo"))
-	c.Send("127.0.0.1", []byte("goodbye world!"))
+	c.Send("127.0.0.1", "", nil, []byte("goodbye world!"))
 
 	time.Sleep(10 * time.Millisecond)
 	gotMetrics := r.GetMetrics("akvorado_inlet_kafka_")
@@ -54,6 +63,148 @@ func TestKafka(t *testing.T) {
 		`sent_bytes_total{exporter="127.0.0.1"}`: "26",
 		fmt.Sprintf(`errors_total{error="kafka: Failed to produce message to topic flows-%s: noooo"}`, c.d.Schema.ProtobufMessageHash()): "1",
 		`sent_messages_total{exporter="127.0.0.1"}`: "2",
+		`shutdown_dropped_messages_total`:           "0",
+		`shutdown_flushed_messages_total`:           "0",
+		fmt.Sprintf(`topic_sent_messages_total{topic="flows-%s"}`, c.d.Schema.ProtobufMessageHash()): "2",
+		fmt.Sprintf(`partition_sent_bytes_total{partition="%d",topic="flows-%s"}`,
+			partition, c.d.Schema.ProtobufMessageHash()): "12",
+		fmt.Sprintf(`partition_sent_messages_total{partition="%d",topic="flows-%s"}`,
+			partition, c.d.Schema.ProtobufMessageHash()): "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestKafkaAvro(t *testing.T) {
+	sch := schema.NewMock(t)
+	registered := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/subjects/flows-value/versions" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if user, password, _ := r.BasicAuth(); user != "akvorado" || password != "secret" {
+			t.Errorf("BasicAuth() == %q, %q", user, password)
+		}
+		var body struct {
+			Schema string `json:"schema"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Decode() error:\n%+v", err)
+		}
+		registered <- body.Schema
+		w.Header().Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+		w.Write([]byte(`{"id": 42}`))
+	}))
+	defer ts.Close()
+
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration()
+	config.Encoding = EncodingAvro
+	config.SchemaRegistry.URL = ts.URL
+	config.SchemaRegistry.Username = "akvorado"
+	config.SchemaRegistry.Password = "secret"
+	c, err := New(r, config, Dependencies{Daemon: daemon.NewMock(t), Schema: sch})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	var mockProducer *mocks.AsyncProducer
+	c.createKafkaProducer = func() (sarama.AsyncProducer, error) {
+		mockProducer = mocks.NewAsyncProducer(t, c.kafkaConfig)
+		return mockProducer, nil
+	}
+	helpers.StartStop(t, c)
+	select {
+	case got := <-registered:
+		if expected := sch.NewAvroEncoder().Schema(); got != expected {
+			t.Fatalf("registered schema:\n%s\nexpected:\n%s", got, expected)
+		}
+	default:
+		t.Fatal("schema not registered")
+	}
+
+	bf := &schema.FlowMessage{TimeReceived: 1000}
+	received := make(chan bool)
+	mockProducer.ExpectInputWithMessageCheckerFunctionAndSucceed(func(got *sarama.ProducerMessage) error {
+		defer close(received)
+		if got.Topic != "flows" {
+			t.Errorf("Send() topic == %q, expected %q", got.Topic, "flows")
+		}
+		value, _ := got.Value.Encode()
+		if len(value) < 6 || value[0] != 0 || binary.BigEndian.Uint32(value[1:5]) != 42 {
+			t.Errorf("Send() value has no proper header: %v", value)
+		}
+		// First field is TimeReceived
+		if timeReceived, _ := binary.Varint(value[5:]); timeReceived != 1000 {
+			t.Errorf("Send() TimeReceived == %d, expected 1000", timeReceived)
+		}
+		return nil
+	})
+	c.Send("127.0.0.1", "", nil, sch.ProtobufMarshal(bf))
+	select {
+	case <-received:
+	case <-time.After(1 * time.Second):
+		t.Fatal("Kafka message not received")
+	}
+}
+
+func TestKafkaAvroWithoutRegistry(t *testing.T) {
+	config := DefaultConfiguration()
+	config.Encoding = EncodingAvro
+	if _, err := New(reporter.NewMock(t), config, Dependencies{Daemon: daemon.NewMock(t), Schema: schema.NewMock(t)}); err == nil {
+		t.Fatal("New() did not error")
+	}
+}
+
+func TestKafkaTopics(t *testing.T) {
+	r := reporter.NewMock(t)
+	sch := schema.NewMock(t)
+	hash := sch.ProtobufMessageHash()
+	config := DefaultConfiguration()
+	config.TopicRules = []kafka.TopicRule{
+		{Condition: `Flow.SrcAS == 65000`, Suffix: "transit"},
+	}
+	c, mockProducer := NewMock(t, r, config)
+
+	if got := c.TopicRules(); len(got) != 1 || got[0].Suffix != "transit" {
+		t.Errorf("TopicRules() == %v", got)
+	}
+	cases := []struct {
+		Suffix string
+		Topic  string
+	}{
+		{"", fmt.Sprintf("flows-%s", hash)},
+		{"transit", fmt.Sprintf("flows-transit-%s", hash)},
+	}
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("suffix %q", tc.Suffix), func(t *testing.T) {
+			topic := c.Topic(tc.Suffix)
+			if topic != tc.Topic {
+				t.Fatalf("Topic(%q) == %q, expected %q", tc.Suffix, topic, tc.Topic)
+			}
+			received := make(chan bool)
+			mockProducer.ExpectInputWithMessageCheckerFunctionAndSucceed(func(got *sarama.ProducerMessage) error {
+				defer close(received)
+				if got.Topic != tc.Topic {
+					t.Errorf("Send() topic == %q, expected %q", got.Topic, tc.Topic)
+				}
+				return nil
+			})
+			c.Send("127.0.0.1", topic, nil, []byte("hello world!"))
+			select {
+			case <-received:
+			case <-time.After(1 * time.Second):
+				t.Fatal("Kafka message not received")
+			}
+		})
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_kafka_", "topic_")
+	expectedMetrics := map[string]string{
+		fmt.Sprintf(`topic_sent_messages_total{topic="flows-%s"}`, hash):         "1",
+		fmt.Sprintf(`topic_sent_messages_total{topic="flows-transit-%s"}`, hash): "1",
 	}
 	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
 		t.Fatalf("Metrics (-got, +want):\n%s", diff)
@@ -98,8 +249,181 @@ func TestKafkaMetrics(t *testing.T) {
 		`brokers_request_size_sum{broker="1111"}`:              "100",
 		`brokers_inflight_requests{broker="1111"}`:             "20",
 		`brokers_inflight_requests{broker="1112"}`:             "20",
+		`shutdown_dropped_messages_total`:                      "0",
+		`shutdown_flushed_messages_total`:                      "0",
 	}
 	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
 		t.Fatalf("Metrics (-got, +want):\n%s", diff)
 	}
 }
+
+func TestKafkaShutdown(t *testing.T) {
+	cases := []struct {
+		Description string
+		Timeout     time.Duration
+		Flushed     string
+		Dropped     string
+	}{
+		{"flushed", time.Second, "1", "0"},
+		{"dropped", 10 * time.Millisecond, "0", "1"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			r := reporter.NewMock(t)
+			config := DefaultConfiguration()
+			config.ShutdownTimeout = tc.Timeout
+			c, err := New(r, config, Dependencies{Daemon: daemon.NewMock(t), Schema: schema.NewMock(t)})
+			if err != nil {
+				t.Fatalf("New() error:\n%+v", err)
+			}
+			var mockProducer *mocks.AsyncProducer
+			c.createKafkaProducer = func() (sarama.AsyncProducer, error) {
+				mockProducer = mocks.NewAsyncProducer(t, c.kafkaConfig)
+				return mockProducer, nil
+			}
+			if err := c.Start(); err != nil {
+				t.Fatalf("Start() error:\n%+v", err)
+			}
+
+			// Keep the message pending until the component is stopping.
+			release := make(chan struct{})
+			mockProducer.ExpectInputWithMessageCheckerFunctionAndSucceed(func(*sarama.ProducerMessage) error {
+				<-release
+				return nil
+			})
+			c.Send("127.0.0.1", "", nil, []byte("hello world!"))
+			c.t.Kill(nil)
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			if err := c.Stop(); err != nil {
+				t.Fatalf("Stop() error:\n%+v", err)
+			}
+
+			gotMetrics := r.GetMetrics("akvorado_inlet_kafka_", "shutdown_")
+			expectedMetrics := map[string]string{
+				`shutdown_dropped_messages_total`: tc.Dropped,
+				`shutdown_flushed_messages_total`: tc.Flushed,
+			}
+			if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+				t.Fatalf("Metrics (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestKafkaSpill(t *testing.T) {
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration()
+	config.Spill.Directory = t.TempDir()
+	config.Spill.Threshold = 1
+	config.Spill.ReplayInterval = 100 * time.Millisecond
+	c, mockProducer := NewMock(t, r, config)
+	topic := fmt.Sprintf("flows-%s", c.d.Schema.ProtobufMessageHash())
+
+	// Keep the first message pending, the next ones are buffered on disk.
+	// All expectations are registered first as the mock holds its lock
+	// while checking a message.
+	release := make(chan struct{})
+	received := make(chan string, 2)
+	mockProducer.ExpectInputWithMessageCheckerFunctionAndSucceed(func(*sarama.ProducerMessage) error {
+		<-release
+		return nil
+	})
+	for range 2 {
+		mockProducer.ExpectInputWithMessageCheckerFunctionAndSucceed(func(got *sarama.ProducerMessage) error {
+			if got.Topic != topic {
+				t.Errorf("Send() topic == %q, expected %q", got.Topic, topic)
+			}
+			value, _ := got.Value.Encode()
+			received <- string(value)
+			return nil
+		})
+	}
+	c.Send("127.0.0.1", "", nil, []byte("message 1"))
+	time.Sleep(10 * time.Millisecond)
+	c.Send("127.0.0.1", "", nil, []byte("message 2"))
+	c.Send("127.0.0.1", "", nil, []byte("message 3"))
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_kafka_", "spill_")
+	expectedMetrics := map[string]string{
+		`spill_buffered_bytes`:          fmt.Sprintf("%d", 2*(2+len(topic)+len("message 2"))),
+		`spill_buffered_segments`:       "1",
+		`spill_dropped_bytes_total`:     "0",
+		`spill_dropped_messages_total`:  "0",
+		`spill_dropped_segments_total`:  "0",
+		`spill_messages_total`:          "2",
+		`spill_replayed_messages_total`: "0",
+		`spill_replayed_segments_total`: "0",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+
+	// Release the first message, the other ones should be replayed in order.
+	close(release)
+	for _, expected := range []string{"message 2", "message 3"} {
+		select {
+		case got := <-received:
+			if got != expected {
+				t.Fatalf("replay() got %q, expected %q", got, expected)
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatal("Kafka message not received")
+		}
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	gotMetrics = r.GetMetrics("akvorado_inlet_kafka_", "spill_")
+	expectedMetrics = map[string]string{
+		`spill_buffered_bytes`:          "0",
+		`spill_buffered_segments`:       "0",
+		`spill_dropped_bytes_total`:     "0",
+		`spill_dropped_messages_total`:  "0",
+		`spill_dropped_segments_total`:  "0",
+		`spill_messages_total`:          "2",
+		`spill_replayed_messages_total`: "2",
+		`spill_replayed_segments_total`: "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration()
+	config.Headers = true
+	c, mockProducer := NewMock(t, r, config)
+
+	received := make(chan bool)
+	mockProducer.ExpectInputWithMessageCheckerFunctionAndSucceed(func(got *sarama.ProducerMessage) error {
+		defer close(received)
+		expected := []sarama.RecordHeader{
+			{Key: []byte("akvorado-schema-hash"), Value: []byte(c.d.Schema.ProtobufMessageHash())},
+			{Key: []byte("akvorado-version"), Value: []byte(helpers.AkvoradoVersion)},
+			{Key: []byte("akvorado-exporter"), Value: []byte("127.0.0.1")},
+		}
+		if diff := helpers.Diff(got.Headers, expected); diff != "" {
+			t.Errorf("Send() headers (-got, +want):\n%s", diff)
+		}
+		return nil
+	})
+	c.Send("127.0.0.1", "", nil, []byte("hello world!"))
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("Kafka message not received")
+	}
+	if diff := helpers.Diff(c.messageHeaders(""), c.headers); diff != "" {
+		t.Errorf("messageHeaders() (-got, +want):\n%s", diff)
+	}
+
+	// Headers are not available with old versions of Kafka.
+	config.Version = kafka.Version(sarama.V0_10_2_0)
+	if _, err := New(r, config, Dependencies{
+		Daemon: daemon.NewMock(t),
+		Schema: schema.NewMock(t),
+	}); err == nil {
+		t.Error("New() did not error with Kafka 0.10")
+	}
+}
@@ -0,0 +1,380 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package kafka
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+const spillSegmentExtension = ".segment"
+
+// spillSegment is a segment file on disk.
+type spillSegment struct {
+	id   uint64
+	size int64
+}
+
+// spillRecord is a message stored in a segment.
+type spillRecord struct {
+	topic   string
+	payload []byte
+}
+
+// spool is a disk buffer made of segment files. Messages are appended to the
+// last segment. Segments are replayed oldest first.
+type spool struct {
+	directory   string
+	maxSize     int64
+	segmentSize int64
+
+	lock      sync.Mutex
+	segments  []spillSegment // the last one is the current one when writer != nil
+	file      *os.File
+	writer    *bufio.Writer
+	nextID    uint64
+	totalSize int64
+}
+
+// newSpool creates a new spool in the provided directory. Existing segments
+// are kept and will be replayed.
+func newSpool(directory string, maxSize, segmentSize int64) (*spool, error) {
+	if err := os.MkdirAll(directory, 0o750); err != nil {
+		return nil, fmt.Errorf("cannot create spill directory: %w", err)
+	}
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read spill directory: %w", err)
+	}
+	s := &spool{
+		directory:   directory,
+		maxSize:     maxSize,
+		segmentSize: segmentSize,
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, spillSegmentExtension) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, spillSegmentExtension), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("cannot stat spill segment: %w", err)
+		}
+		s.segments = append(s.segments, spillSegment{id: id, size: info.Size()})
+		s.totalSize += info.Size()
+		s.nextID = max(s.nextID, id+1)
+	}
+	slices.SortFunc(s.segments, func(a, b spillSegment) int {
+		return cmp.Compare(a.id, b.id)
+	})
+	return s, nil
+}
+
+// path returns the path of the provided segment.
+func (s *spool) path(id uint64) string {
+	return filepath.Join(s.directory, fmt.Sprintf("%020d%s", id, spillSegmentExtension))
+}
+
+// empty tells if the spool contains no message.
+func (s *spool) empty() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.segments) == 0
+}
+
+// size returns the number of bytes and the number of segments in the spool.
+func (s *spool) size() (int64, int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.totalSize, len(s.segments)
+}
+
+// write appends a message to the spool. It returns the segments dropped to
+// respect the maximum size.
+func (s *spool) write(topic string, payload []byte) ([]spillSegment, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.writer == nil || s.segments[len(s.segments)-1].size >= s.segmentSize {
+		if err := s.rotate(); err != nil {
+			return nil, err
+		}
+	}
+	record := make([]byte, 0, 2*binary.MaxVarintLen64+len(topic)+len(payload))
+	record = binary.AppendUvarint(record, uint64(len(topic)))
+	record = append(record, topic...)
+	record = binary.AppendUvarint(record, uint64(len(payload)))
+	record = append(record, payload...)
+	if _, err := s.writer.Write(record); err != nil {
+		return nil, fmt.Errorf("cannot write to spill segment: %w", err)
+	}
+	current := &s.segments[len(s.segments)-1]
+	current.size += int64(len(record))
+	s.totalSize += int64(len(record))
+
+	// Drop oldest segments if we are over the limit. The current segment is
+	// never dropped.
+	var dropped []spillSegment
+	for s.totalSize > s.maxSize && len(s.segments) > 1 {
+		segment := s.segments[0]
+		s.segments = s.segments[1:]
+		s.totalSize -= segment.size
+		os.Remove(s.path(segment.id))
+		dropped = append(dropped, segment)
+	}
+	return dropped, nil
+}
+
+// rotate closes the current segment and opens a new one. It should be called
+// with the lock held.
+func (s *spool) rotate() error {
+	if err := s.closeCurrent(); err != nil {
+		return err
+	}
+	id := s.nextID
+	file, err := os.OpenFile(s.path(id), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return fmt.Errorf("cannot create spill segment: %w", err)
+	}
+	s.nextID++
+	s.file = file
+	s.writer = bufio.NewWriter(file)
+	s.segments = append(s.segments, spillSegment{id: id})
+	return nil
+}
+
+// closeCurrent flushes and closes the current segment. It should be called
+// with the lock held.
+func (s *spool) closeCurrent() error {
+	if s.writer == nil {
+		return nil
+	}
+	err := s.writer.Flush()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	s.writer = nil
+	s.file = nil
+	if err != nil {
+		return fmt.Errorf("cannot close spill segment: %w", err)
+	}
+	return nil
+}
+
+// oldest returns the oldest segment, closing it if it is the current one. It
+// returns false if there is no segment.
+func (s *spool) oldest() (spillSegment, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.segments) == 0 {
+		return spillSegment{}, false, nil
+	}
+	if len(s.segments) == 1 && s.writer != nil {
+		if err := s.closeCurrent(); err != nil {
+			return spillSegment{}, false, err
+		}
+	}
+	return s.segments[0], true, nil
+}
+
+// read reads all the records of a closed segment.
+func (s *spool) read(segment spillSegment) ([]spillRecord, error) {
+	file, err := os.Open(s.path(segment.id))
+	if errors.Is(err, os.ErrNotExist) {
+		// Dropped in the meantime
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot open spill segment: %w", err)
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	records := []spillRecord{}
+	for {
+		topicLen, err := binary.ReadUvarint(reader)
+		if err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return records, fmt.Errorf("cannot read spill segment: %w", err)
+		}
+		topic := make([]byte, topicLen)
+		if _, err := io.ReadFull(reader, topic); err != nil {
+			return records, fmt.Errorf("cannot read spill segment: %w", err)
+		}
+		payloadLen, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return records, fmt.Errorf("cannot read spill segment: %w", err)
+		}
+		payload := make([]byte, payloadLen)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return records, fmt.Errorf("cannot read spill segment: %w", err)
+		}
+		records = append(records, spillRecord{topic: string(topic), payload: payload})
+	}
+}
+
+// remove removes a segment once it has been replayed.
+func (s *spool) remove(segment spillSegment) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	idx := slices.IndexFunc(s.segments, func(other spillSegment) bool {
+		return other.id == segment.id
+	})
+	if idx < 0 {
+		// Already dropped
+		return
+	}
+	s.totalSize -= s.segments[idx].size
+	s.segments = slices.Delete(s.segments, idx, idx+1)
+	os.Remove(s.path(segment.id))
+}
+
+// close flushes and closes the current segment.
+func (s *spool) close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.closeCurrent()
+}
+
+// spill buffers a message on disk. It returns false if the message was not
+// buffered.
+func (c *Component) spill(topic string, payload []byte) bool {
+	dropped, err := c.spool.write(topic, payload)
+	if err != nil {
+		c.metrics.errors.WithLabelValues("cannot write to spill segment").Inc()
+		c.errLogger.Err(err).Msg("cannot write to spill segment")
+		return false
+	}
+	c.metrics.spilledMessages.Inc()
+	for _, segment := range dropped {
+		c.metrics.spillDroppedSegments.Inc()
+		c.metrics.spillDroppedBytes.Add(float64(segment.size))
+		c.errLogger.Warn().
+			Uint64("segment", segment.id).
+			Int64("size", segment.size).
+			Msg("spill directory full, oldest segment dropped")
+	}
+	return true
+}
+
+// respill buffers on disk a message Kafka failed to accept. Only messages
+// rejected with a retriable error are buffered, the other ones are counted as
+// dropped. It returns false if the message was not buffered.
+func (c *Component) respill(msg *sarama.ProducerError) bool {
+	if !retriable(msg.Err) {
+		if c.spool != nil {
+			c.metrics.spillDroppedMessages.Inc()
+		}
+		return false
+	}
+	c.lastError.Store(time.Now().UnixNano())
+	if c.spool == nil {
+		return false
+	}
+	payload, err := msg.Msg.Value.Encode()
+	if err != nil {
+		return false
+	}
+	return c.spill(msg.Msg.Topic, payload)
+}
+
+// retriable tells if a producer error is likely to be temporary, for example
+// when brokers are unavailable. Other errors, like a message too large, would
+// happen again on replay.
+func retriable(err error) bool {
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, sarama.ErrOutOfBrokers),
+		errors.Is(err, sarama.ErrNotConnected),
+		errors.Is(err, sarama.ErrControllerNotAvailable),
+		errors.Is(err, sarama.ErrLeaderNotAvailable),
+		errors.Is(err, sarama.ErrNotLeaderForPartition),
+		errors.Is(err, sarama.ErrRequestTimedOut),
+		errors.Is(err, sarama.ErrBrokerNotAvailable),
+		errors.Is(err, sarama.ErrNetworkException),
+		errors.Is(err, sarama.ErrNotEnoughReplicas),
+		errors.Is(err, sarama.ErrNotEnoughReplicasAfterAppend),
+		errors.Is(err, sarama.ErrKafkaStorageError):
+		return true
+	}
+	return false
+}
+
+// replay sends messages buffered on disk to Kafka, oldest segment first. It
+// does nothing if Kafka reported an error recently and it pauses when too
+// many messages are pending. A segment is removed once all its messages have
+// been handed to the producer.
+func (c *Component) replay(producer sarama.AsyncProducer) {
+	threshold := c.config.Spill.Threshold
+	healthy := func() bool {
+		lastError := time.Unix(0, c.lastError.Load())
+		return time.Since(lastError) >= c.config.Spill.ReplayInterval
+	}
+	for healthy() {
+		segment, ok, err := c.spool.oldest()
+		if err != nil {
+			c.errLogger.Err(err).Msg("cannot get oldest spill segment")
+			return
+		}
+		if !ok {
+			return
+		}
+		records, err := c.spool.read(segment)
+		if err != nil {
+			// Replay what we were able to read, the remaining is lost.
+			c.metrics.errors.WithLabelValues("cannot read spill segment").Inc()
+			c.errLogger.Err(err).Uint64("segment", segment.id).Msg("cannot read spill segment")
+		}
+		for _, record := range records {
+			for c.pending.Load() >= threshold {
+				if !healthy() {
+					return
+				}
+				select {
+				case <-c.t.Dying():
+					return
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+			// The partition key and the exporter are not stored on
+			// disk. Without key, the hash partitioner selects a random
+			// partition.
+			c.pending.Add(1)
+			select {
+			case producer.Input() <- &sarama.ProducerMessage{
+				Topic:   record.topic,
+				Value:   sarama.ByteEncoder(record.payload),
+				Headers: c.messageHeaders(""),
+			}:
+				c.metrics.replayedMessages.Inc()
+			case <-c.t.Dying():
+				// The segment is kept and its messages will be
+				// replayed again on next start.
+				c.pending.Add(-1)
+				return
+			}
+		}
+		c.spool.remove(segment)
+		c.metrics.replayedSegments.Inc()
+	}
+}
@@ -0,0 +1,109 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/IBM/sarama"
+
+	"akvorado/common/helpers"
+)
+
+func TestSpool(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newSpool(dir, 120, 48)
+	if err != nil {
+		t.Fatalf("newSpool() error:\n%+v", err)
+	}
+	if !s.empty() {
+		t.Fatal("empty() == false on new spool")
+	}
+
+	// Each record is 16 bytes: 2 segments of 3 records, then 1 record.
+	for i := range 7 {
+		dropped, err := s.write("flows", []byte(fmt.Sprintf("message %d", i)))
+		if err != nil {
+			t.Fatalf("write() error:\n%+v", err)
+		}
+		if len(dropped) > 0 {
+			t.Fatalf("write() dropped %v", dropped)
+		}
+	}
+	if bytes, segments := s.size(); bytes != 112 || segments != 3 {
+		t.Fatalf("size() == %d, %d, expected 112, 3", bytes, segments)
+	}
+
+	// Reopen the spool and read the oldest segment.
+	if err := s.close(); err != nil {
+		t.Fatalf("close() error:\n%+v", err)
+	}
+	s, err = newSpool(dir, 120, 48)
+	if err != nil {
+		t.Fatalf("newSpool() error:\n%+v", err)
+	}
+	if bytes, segments := s.size(); bytes != 112 || segments != 3 {
+		t.Fatalf("size() == %d, %d, expected 112, 3", bytes, segments)
+	}
+	segment, ok, err := s.oldest()
+	if err != nil || !ok {
+		t.Fatalf("oldest() == %v, %v", ok, err)
+	}
+	records, err := s.read(segment)
+	if err != nil {
+		t.Fatalf("read() error:\n%+v", err)
+	}
+	expected := []spillRecord{
+		{"flows", []byte("message 0")},
+		{"flows", []byte("message 1")},
+		{"flows", []byte("message 2")},
+	}
+	if diff := helpers.Diff(records, expected); diff != "" {
+		t.Fatalf("read() (-got, +want):\n%s", diff)
+	}
+	s.remove(segment)
+	if bytes, segments := s.size(); bytes != 64 || segments != 2 {
+		t.Fatalf("size() == %d, %d, expected 64, 2", bytes, segments)
+	}
+
+	// Exceed the maximum size (messages 10 to 12 are 17 bytes)
+	var dropped []spillSegment
+	for i := range 6 {
+		got, err := s.write("flows", []byte(fmt.Sprintf("message %d", 7+i)))
+		if err != nil {
+			t.Fatalf("write() error:\n%+v", err)
+		}
+		dropped = append(dropped, got...)
+	}
+	if diff := helpers.Diff(dropped, []spillSegment{{id: 1, size: 48}}); diff != "" {
+		t.Fatalf("write() dropped (-got, +want):\n%s", diff)
+	}
+	if bytes, segments := s.size(); bytes != 115 || segments != 3 {
+		t.Fatalf("size() == %d, %d, expected 115, 3", bytes, segments)
+	}
+	if err := s.close(); err != nil {
+		t.Fatalf("close() error:\n%+v", err)
+	}
+}
+
+func TestRetriable(t *testing.T) {
+	cases := []struct {
+		Err       error
+		Retriable bool
+	}{
+		{sarama.ErrOutOfBrokers, true},
+		{sarama.ErrNotLeaderForPartition, true},
+		{fmt.Errorf("kafka: %w", sarama.ErrRequestTimedOut), true},
+		{sarama.ErrMessageSizeTooLarge, false},
+		{sarama.ErrInvalidMessage, false},
+		{errors.New("unknown error"), false},
+	}
+	for _, tc := range cases {
+		if got := retriable(tc.Err); got != tc.Retriable {
+			t.Errorf("retriable(%v) == %v, expected %v", tc.Err, got, tc.Retriable)
+		}
+	}
+}
@@ -13,6 +13,7 @@ import (
 
 	"akvorado/common/daemon"
 	"akvorado/common/helpers"
+	"akvorado/common/httpserver"
 	"akvorado/common/reporter"
 	"akvorado/common/schema"
 )
@@ -23,6 +24,7 @@ func NewMock(t *testing.T, reporter *reporter.Reporter, configuration Configurat
 	t.Helper()
 	c, err := New(reporter, configuration, Dependencies{
 		Daemon: daemon.NewMock(t),
+		HTTP:   httpserver.NewMock(t, reporter),
 		Schema: schema.NewMock(t),
 	})
 	if err != nil {
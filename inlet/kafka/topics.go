@@ -0,0 +1,21 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package kafka
+
+import "akvorado/common/kafka"
+
+// TopicRules returns the configured topic rules. They are evaluated by the
+// caller to select the topic given to Send.
+func (c *Component) TopicRules() []kafka.TopicRule {
+	return c.config.TopicRules
+}
+
+// Topic returns the name of the topic for the provided suffix. The empty
+// suffix is the main topic.
+func (c *Component) Topic(suffix string) string {
+	if suffix == "" {
+		return c.kafkaTopic
+	}
+	return c.config.TopicName(suffix, c.topicHash)
+}
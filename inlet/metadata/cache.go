@@ -82,6 +82,24 @@ func (sc *metadataCache) Expire(before time.Time) int {
 	return expired
 }
 
+// ExpireUpdatedBefore expire entries whose last update is before the provided
+// time.
+func (sc *metadataCache) ExpireUpdatedBefore(before time.Time) int {
+	expired := sc.cache.DeleteLastUpdatedBefore(before)
+	sc.metrics.cacheExpired.Add(float64(expired))
+	return expired
+}
+
+// Invalidate removes entries whose query matches the provided function.
+func (sc *metadataCache) Invalidate(match func(provider.Query) bool) int {
+	return sc.cache.DeleteFunc(match)
+}
+
+// Entries returns all the entries in the cache with their time of last update.
+func (sc *metadataCache) Entries() map[provider.Query]cache.Entry[provider.Answer] {
+	return sc.cache.Entries()
+}
+
 // NeedUpdates returns a map of interface entries that would need to
 // be updated. It relies on last update.
 func (sc *metadataCache) NeedUpdates(before time.Time) map[netip.Addr][]uint {
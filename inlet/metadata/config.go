@@ -4,11 +4,14 @@
 package metadata
 
 import (
+	"reflect"
+	"strings"
 	"time"
 
 	"akvorado/common/helpers"
 	"akvorado/inlet/metadata/provider"
 	"akvorado/inlet/metadata/provider/gnmi"
+	"akvorado/inlet/metadata/provider/netbox"
 	"akvorado/inlet/metadata/provider/snmp"
 	"akvorado/inlet/metadata/provider/static"
 )
@@ -23,25 +26,50 @@ type Configuration struct {
 	CacheCheckInterval time.Duration `validate:"ltefield=CacheRefresh,min=1s"`
 	// CachePersist defines a file to store cache and survive restarts
 	CachePersistFile string
+	// CachePersistInterval defines how often to store the cache (0 to store
+	// it only on shutdown)
+	CachePersistInterval time.Duration `validate:"eq=0|min=1m"`
+	// CachePersistMaxAge defines the maximum age of entries loaded from the
+	// persisted cache (0 to load all entries)
+	CachePersistMaxAge time.Duration `validate:"min=0"`
 
 	// Provider defines the configuration of the providers to use
 	Providers []ProviderConfiguration
+	// ProviderNegativeCacheDuration defines how long to skip a provider for
+	// an exporter after it failed or refused to handle it (0 to disable)
+	ProviderNegativeCacheDuration time.Duration `validate:"min=0"`
+
+	// ExporterNameSources defines the sources to use to name exporters, in
+	// order of priority
+	ExporterNameSources []ExporterNameSource `validate:"min=1"`
+	// ExporterNames maps exporter subnets to names for the static source
+	ExporterNames helpers.SubnetMap[string]
 
 	// Workers define the number of workers used to poll metadata
 	Workers int `validate:"min=1"`
 	// MaxBatchRequests define how many requests to pass to a worker at once if possible
 	MaxBatchRequests int `validate:"min=0"`
+	// MaxExporterWorkersShare defines the maximum share of workers that can
+	// be used for a single exporter
+	MaxExporterWorkersShare float64 `validate:"gt=0,lte=1"`
 }
 
 // DefaultConfiguration represents the default configuration for the metadata provider.
 func DefaultConfiguration() Configuration {
 	return Configuration{
-		CacheDuration:      30 * time.Minute,
-		CacheRefresh:       time.Hour,
-		CacheCheckInterval: 2 * time.Minute,
-		CachePersistFile:   "",
-		Workers:            1,
-		MaxBatchRequests:   10,
+		CacheDuration:        30 * time.Minute,
+		CacheRefresh:         time.Hour,
+		CacheCheckInterval:   2 * time.Minute,
+		CachePersistFile:     "",
+		CachePersistInterval: 10 * time.Minute,
+		CachePersistMaxAge:   0,
+		Workers:              1,
+		MaxBatchRequests:     10,
+
+		MaxExporterWorkersShare: 1,
+
+		ProviderNegativeCacheDuration: time.Minute,
+		ExporterNameSources:           []ExporterNameSource{ExporterNameSourceProvider},
 	}
 }
 
@@ -56,9 +84,28 @@ func (pc ProviderConfiguration) MarshalYAML() (interface{}, error) {
 	return helpers.ParametrizedConfigurationMarshalYAML(pc, providers)
 }
 
+// name returns the type of the provider, as used in the configuration.
+func (pc ProviderConfiguration) name() string {
+	typeOf := reflect.TypeOf(pc.Config)
+	if typeOf.Kind() == reflect.Pointer {
+		typeOf = typeOf.Elem()
+	}
+	for k, v := range providers {
+		candidate := reflect.TypeOf(v())
+		if candidate.Kind() == reflect.Pointer {
+			candidate = candidate.Elem()
+		}
+		if candidate == typeOf {
+			return k
+		}
+	}
+	return strings.ToLower(typeOf.Name())
+}
+
 var providers = map[string](func() provider.Configuration){
 	"snmp":   snmp.DefaultConfiguration,
 	"gnmi":   gnmi.DefaultConfiguration,
+	"netbox": netbox.DefaultConfiguration,
 	"static": static.DefaultConfiguration,
 }
 
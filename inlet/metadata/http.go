@@ -0,0 +1,200 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package metadata
+
+import (
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"akvorado/common/schema"
+)
+
+type providerStatus struct {
+	Exporter string                   `json:"exporter"`
+	ServedBy string                   `json:"served-by,omitempty"`
+	Skipped  []providerNegativeStatus `json:"skipped,omitempty"`
+}
+
+type providerNegativeStatus struct {
+	Provider string    `json:"provider"`
+	Until    time.Time `json:"until"`
+	Error    string    `json:"error"`
+}
+
+// providersHTTPHandler displays, for each exporter, the provider which served
+// the last answer and the providers currently skipped.
+func (c *Component) providersHTTPHandler(gc *gin.Context) {
+	now := c.d.Clock.Now()
+	statuses := map[netip.Addr]*providerStatus{}
+	get := func(exporterIP netip.Addr) *providerStatus {
+		status, ok := statuses[exporterIP]
+		if !ok {
+			status = &providerStatus{Exporter: exporterIP.Unmap().String()}
+			statuses[exporterIP] = status
+		}
+		return status
+	}
+
+	c.providerStateLock.Lock()
+	for exporterIP, name := range c.providerServedBy {
+		get(exporterIP).ServedBy = name
+	}
+	for key, entry := range c.providerNegativeCache {
+		if !now.Before(entry.until) {
+			continue
+		}
+		status := get(key.exporter)
+		status.Skipped = append(status.Skipped, providerNegativeStatus{
+			Provider: c.providerNames[key.provider],
+			Until:    entry.until.UTC(),
+			Error:    entry.err.Error(),
+		})
+	}
+	c.providerStateLock.Unlock()
+
+	exporters := make([]netip.Addr, 0, len(statuses))
+	for exporterIP, status := range statuses {
+		exporters = append(exporters, exporterIP)
+		slices.SortFunc(status.Skipped, func(a, b providerNegativeStatus) int {
+			if n := strings.Compare(a.Provider, b.Provider); n != 0 {
+				return n
+			}
+			return a.Until.Compare(b.Until)
+		})
+	}
+	slices.SortFunc(exporters, func(a, b netip.Addr) int { return a.Compare(b) })
+	result := make([]providerStatus, 0, len(exporters))
+	for _, exporterIP := range exporters {
+		result = append(result, *statuses[exporterIP])
+	}
+	gc.JSON(http.StatusOK, gin.H{"exporters": result})
+}
+
+type exporterStatus struct {
+	Exporter   string `json:"exporter"`
+	Name       string `json:"name"`
+	Interfaces int    `json:"interfaces"`
+	Age        int64  `json:"age"`
+	ServedBy   string `json:"served-by,omitempty"`
+}
+
+type interfaceStatus struct {
+	IfIndex      uint                     `json:"ifindex"`
+	Name         string                   `json:"name"`
+	Description  string                   `json:"description"`
+	Speed        uint32                   `json:"speed"`
+	Provider     string                   `json:"provider,omitempty"`
+	Connectivity string                   `json:"connectivity,omitempty"`
+	Boundary     schema.InterfaceBoundary `json:"boundary"`
+	Age          int64                    `json:"age"`
+}
+
+// exportersHTTPHandler lists the exporters in cache. The age is the number of
+// seconds since the oldest update of an entry for the exporter.
+func (c *Component) exportersHTTPHandler(gc *gin.Context) {
+	now := c.d.Clock.Now()
+	statuses := map[netip.Addr]*exporterStatus{}
+	for query, entry := range c.sc.Entries() {
+		age := int64(now.Sub(entry.LastUpdated) / time.Second)
+		status, ok := statuses[query.ExporterIP]
+		if !ok {
+			status = &exporterStatus{
+				Exporter: query.ExporterIP.Unmap().String(),
+				Name:     entry.Object.Exporter.Name,
+			}
+			statuses[query.ExporterIP] = status
+		}
+		status.Interfaces++
+		status.Age = max(status.Age, age)
+	}
+	c.providerStateLock.Lock()
+	for exporterIP, status := range statuses {
+		status.ServedBy = c.providerServedBy[exporterIP]
+	}
+	c.providerStateLock.Unlock()
+
+	exporters := make([]netip.Addr, 0, len(statuses))
+	for exporterIP := range statuses {
+		exporters = append(exporters, exporterIP)
+	}
+	slices.SortFunc(exporters, func(a, b netip.Addr) int { return a.Compare(b) })
+	result := make([]exporterStatus, 0, len(exporters))
+	for _, exporterIP := range exporters {
+		result = append(result, *statuses[exporterIP])
+	}
+	gc.JSON(http.StatusOK, gin.H{"exporters": result})
+}
+
+// exporterInterfacesHTTPHandler lists the interfaces in cache for an exporter.
+func (c *Component) exporterInterfacesHTTPHandler(gc *gin.Context) {
+	exporterIP, ok := exporterFromHTTPParams(gc)
+	if !ok {
+		return
+	}
+	now := c.d.Clock.Now()
+	result := []interfaceStatus{}
+	for query, entry := range c.sc.Entries() {
+		if query.ExporterIP != exporterIP {
+			continue
+		}
+		iface := entry.Object.Interface
+		result = append(result, interfaceStatus{
+			IfIndex:      query.IfIndex,
+			Name:         iface.Name,
+			Description:  iface.Description,
+			Speed:        iface.Speed,
+			Provider:     iface.Provider,
+			Connectivity: iface.Connectivity,
+			Boundary:     iface.Boundary,
+			Age:          int64(now.Sub(entry.LastUpdated) / time.Second),
+		})
+	}
+	if len(result) == 0 {
+		gc.JSON(http.StatusNotFound, gin.H{"message": "Exporter not found."})
+		return
+	}
+	slices.SortFunc(result, func(a, b interfaceStatus) int { return int(a.IfIndex) - int(b.IfIndex) })
+	gc.JSON(http.StatusOK, gin.H{"interfaces": result})
+}
+
+// exporterInvalidateHTTPHandler removes the entries for an exporter or for one
+// of its interfaces from the cache and polls them again.
+func (c *Component) exporterInvalidateHTTPHandler(gc *gin.Context) {
+	exporterIP, ok := exporterFromHTTPParams(gc)
+	if !ok {
+		return
+	}
+	match := func(uint) bool { return true }
+	if ifIndexStr := gc.Param("ifindex"); ifIndexStr != "" {
+		ifIndex, err := strconv.ParseUint(ifIndexStr, 10, 32)
+		if err != nil {
+			gc.JSON(http.StatusBadRequest, gin.H{"message": "Invalid interface index."})
+			return
+		}
+		match = func(i uint) bool { return i == uint(ifIndex) }
+	}
+	count := c.invalidate(exporterIP, match)
+	if count == 0 {
+		gc.JSON(http.StatusNotFound, gin.H{"message": "No matching entry in cache."})
+		return
+	}
+	gc.JSON(http.StatusOK, gin.H{"invalidated": count})
+}
+
+// exporterFromHTTPParams extracts the exporter IP address from the URL. On
+// error, the answer is sent to the client.
+func exporterFromHTTPParams(gc *gin.Context) (netip.Addr, bool) {
+	exporterIP, err := netip.ParseAddr(gc.Param("exporter"))
+	if err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "Invalid exporter IP address."})
+		return netip.Addr{}, false
+	}
+	return netip.AddrFrom16(exporterIP.As16()), true
+}
@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/netip"
+	"strings"
+	"time"
+
+	"akvorado/common/helpers/bimap"
+)
+
+// ExporterNameSource describes a source for exporter names.
+type ExporterNameSource int
+
+const (
+	// ExporterNameSourceProvider uses the name returned by the metadata
+	// provider (sysName for SNMP)
+	ExporterNameSourceProvider ExporterNameSource = iota
+	// ExporterNameSourceStatic uses the name from the ExporterNames mapping
+	ExporterNameSourceStatic
+	// ExporterNameSourceDNS uses a reverse DNS lookup of the exporter IP
+	ExporterNameSourceDNS
+)
+
+var exporterNameSourceMap = bimap.New(map[ExporterNameSource]string{
+	ExporterNameSourceProvider: "provider",
+	ExporterNameSourceStatic:   "static",
+	ExporterNameSourceDNS:      "dns",
+})
+
+// MarshalText turns an exporter name source to text.
+func (ens ExporterNameSource) MarshalText() ([]byte, error) {
+	got, ok := exporterNameSourceMap.LoadValue(ens)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown exporter name source")
+}
+
+// String turns an exporter name source to string.
+func (ens ExporterNameSource) String() string {
+	got, _ := exporterNameSourceMap.LoadValue(ens)
+	return got
+}
+
+// UnmarshalText provides an exporter name source from a string.
+func (ens *ExporterNameSource) UnmarshalText(input []byte) error {
+	// "snmp-sysname" is the provider name for SNMP
+	if bytes.Equal(input, []byte("snmp-sysname")) {
+		input = []byte("provider")
+	}
+	got, ok := exporterNameSourceMap.LoadKey(string(input))
+	if ok {
+		*ens = got
+		return nil
+	}
+	return errors.New("unknown exporter name source")
+}
+
+// reverseDNSEntry is a cached reverse DNS answer.
+type reverseDNSEntry struct {
+	name  string
+	until time.Time
+}
+
+// exporterName returns the name of the exporter using the configured sources
+// in order. providerName is the name returned by the provider. When no source
+// provides a name, the name returned by the provider is used.
+func (c *Component) exporterName(exporterIP netip.Addr, providerName string) string {
+	for _, source := range c.config.ExporterNameSources {
+		switch source {
+		case ExporterNameSourceProvider:
+			if providerName != "" {
+				return providerName
+			}
+		case ExporterNameSourceStatic:
+			if name, ok := c.config.ExporterNames.Lookup(exporterIP); ok && name != "" {
+				return name
+			}
+		case ExporterNameSourceDNS:
+			if name := c.reverseDNS(exporterIP); name != "" {
+				return name
+			}
+		}
+	}
+	return providerName
+}
+
+// reverseDNS returns the name of the provided exporter using a reverse DNS
+// lookup. Answers, including failures, are cached.
+func (c *Component) reverseDNS(exporterIP netip.Addr) string {
+	now := c.d.Clock.Now()
+	c.reverseDNSLock.Lock()
+	entry, ok := c.reverseDNSCache[exporterIP]
+	c.reverseDNSLock.Unlock()
+	if ok && now.Before(entry.until) {
+		return entry.name
+	}
+
+	ctx, cancel := context.WithTimeout(c.t.Context(nil), time.Second)
+	defer cancel()
+	entry = reverseDNSEntry{until: now.Add(c.config.CacheDuration)}
+	names, err := c.lookupAddr(ctx, exporterIP.Unmap().String())
+	if err != nil {
+		c.metrics.reverseDNSErrors.Inc()
+	} else if len(names) > 0 {
+		entry.name = strings.TrimSuffix(names[0], ".")
+	}
+	c.reverseDNSLock.Lock()
+	c.reverseDNSCache[exporterIP] = entry
+	c.reverseDNSLock.Unlock()
+	return entry.name
+}
@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package metadata
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/inlet/metadata/provider"
+)
+
+func TestExporterNameSourceUnmarshal(t *testing.T) {
+	cases := []struct {
+		Input    string
+		Expected ExporterNameSource
+	}{
+		{"provider", ExporterNameSourceProvider},
+		{"snmp-sysname", ExporterNameSourceProvider},
+		{"static", ExporterNameSourceStatic},
+		{"dns", ExporterNameSourceDNS},
+	}
+	for _, tc := range cases {
+		var got ExporterNameSource
+		if err := got.UnmarshalText([]byte(tc.Input)); err != nil {
+			t.Errorf("UnmarshalText(%q) error:\n%+v", tc.Input, err)
+		} else if got != tc.Expected {
+			t.Errorf("UnmarshalText(%q) == %s, expected %s", tc.Input, got, tc.Expected)
+		}
+	}
+	var got ExporterNameSource
+	if err := got.UnmarshalText([]byte("ptr")); err == nil {
+		t.Error("UnmarshalText(\"ptr\") did not error")
+	}
+}
+
+func TestExporterNames(t *testing.T) {
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration()
+	configuration.ExporterNameSources = []ExporterNameSource{
+		ExporterNameSourceStatic,
+		ExporterNameSourceDNS,
+		ExporterNameSourceProvider,
+	}
+	configuration.ExporterNames = *helpers.MustNewSubnetMap(map[string]string{
+		"::ffff:192.0.2.1/128": "edge1.example.com",
+	})
+	c := NewMock(t, r, configuration, Dependencies{Daemon: daemon.NewMock(t)})
+	lookups := 0
+	c.lookupAddr = func(_ context.Context, addr string) ([]string, error) {
+		lookups++
+		if addr == "192.0.2.2" {
+			return []string{"edge2.example.com."}, nil
+		}
+		return nil, errors.New("no PTR record")
+	}
+
+	for _, ifIndex := range []uint{1, 2} {
+		c.Lookup(time.Now(), netip.MustParseAddr("::ffff:192.0.2.1"), ifIndex)
+		c.Lookup(time.Now(), netip.MustParseAddr("::ffff:192.0.2.2"), ifIndex)
+		c.Lookup(time.Now(), netip.MustParseAddr("::ffff:192.0.2.3"), ifIndex)
+		time.Sleep(30 * time.Millisecond)
+	}
+
+	for _, tc := range []struct {
+		Exporter string
+		Expected string
+	}{
+		{"192.0.2.1", "edge1.example.com"},
+		{"192.0.2.2", "edge2.example.com"},
+		{"192.0.2.3", "192_0_2_3"},
+	} {
+		for _, ifIndex := range []uint{1, 2} {
+			got, _ := c.Lookup(time.Now(), netip.AddrFrom16(netip.MustParseAddr(tc.Exporter).As16()), ifIndex)
+			if diff := helpers.Diff(got.Exporter, provider.Exporter{Name: tc.Expected}); diff != "" {
+				t.Errorf("Lookup(%s, %d) (-got, +want):\n%s", tc.Exporter, ifIndex, diff)
+			}
+		}
+	}
+
+	// Reverse DNS answers, including failures, are cached
+	if lookups != 2 {
+		t.Errorf("lookupAddr() called %d times, expected 2", lookups)
+	}
+	gotMetrics := r.GetMetrics("akvorado_inlet_metadata_", "reverse_dns_")
+	expectedMetrics := map[string]string{
+		`reverse_dns_errors_total`: "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
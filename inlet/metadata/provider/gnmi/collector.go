@@ -124,7 +124,7 @@ outer2:
 		}
 		// Set speed
 		for iface.Speed == 0 && keys != "" {
-			iface.Speed = speeds[keys]
+			iface.Speed = uint32(speeds[keys])
 			keys = keys[:max(0, strings.LastIndex(keys, ","))]
 		}
 		// Copy back
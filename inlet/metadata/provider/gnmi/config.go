@@ -22,6 +22,9 @@ type Configuration struct {
 	Timeout time.Duration `validate:"min=100ms"`
 	// MinimalRefreshInterval tells how much time to wait at least between two refreshes
 	MinimalRefreshInterval time.Duration `validate:"min=1s"`
+	// Exporters is a mapping from exporter IPs to whatever gNMI should be used
+	// for them. When disabled, the next provider is tried.
+	Exporters *helpers.SubnetMap[bool]
 	// Targets is a mapping from exporter IPs to gNMI target IP.
 	Targets *helpers.SubnetMap[netip.Addr]
 	// SetTarget is a mapping from exporter IPs to whatever set target name in gNMI path prefix
@@ -120,6 +123,7 @@ func DefaultConfiguration() provider.Configuration {
 	return Configuration{
 		Timeout:                  time.Second,
 		MinimalRefreshInterval:   time.Minute,
+		Exporters:                helpers.MustNewSubnetMap(map[string]bool{"::/0": true}),
 		Targets:                  helpers.MustNewSubnetMap(map[string]netip.Addr{}),
 		SetTarget:                helpers.MustNewSubnetMap(map[string]bool{}),
 		Ports:                    helpers.MustNewSubnetMap(map[string]uint16{"::/0": 9339}),
@@ -39,8 +39,13 @@ func (configuration Configuration) New(r *reporter.Reporter, put func(provider.U
 	return &p, nil
 }
 
-// Query queries exporter to get information through gNMI.
+// Query queries exporter to get information through gNMI. Exporters for which
+// gNMI is disabled or for which the collector is not ready yet are left to the
+// next provider.
 func (p *Provider) Query(ctx context.Context, q provider.BatchQuery) error {
+	if enabled, ok := p.config.Exporters.Lookup(q.ExporterIP); ok && !enabled {
+		return provider.ErrSkipProvider
+	}
 	p.stateLock.Lock()
 	defer p.stateLock.Unlock()
 	state, ok := p.state[q.ExporterIP]
@@ -52,7 +57,7 @@ func (p *Provider) Query(ctx context.Context, q provider.BatchQuery) error {
 		p.state[q.ExporterIP] = &state
 		go p.startCollector(ctx, q.ExporterIP, &state)
 		p.metrics.collectorCount.Inc()
-		return nil
+		return provider.ErrSkipProvider
 	}
 	// If the collector exists and already provided some data, populate the
 	// cache.
@@ -76,6 +81,7 @@ func (p *Provider) Query(ctx context.Context, q provider.BatchQuery) error {
 		case p.refresh <- true:
 		default:
 		}
+		return nil
 	}
-	return nil
+	return provider.ErrSkipProvider
 }
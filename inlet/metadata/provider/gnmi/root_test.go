@@ -0,0 +1,37 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package gnmi
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/inlet/metadata/provider"
+)
+
+func TestSkipDisabledExporters(t *testing.T) {
+	r := reporter.NewMock(t)
+	configP := DefaultConfiguration().(Configuration)
+	configP.Exporters = helpers.MustNewSubnetMap(map[string]bool{
+		"::/0":         true,
+		"192.0.2.0/24": false,
+	})
+	put := func(update provider.Update) {
+		t.Errorf("put() called with %+v", update)
+	}
+	p, err := configP.New(r, put)
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	err = p.Query(context.Background(), provider.BatchQuery{
+		ExporterIP: netip.MustParseAddr("::ffff:192.0.2.1"),
+		IfIndexes:  []uint{641},
+	})
+	if err != provider.ErrSkipProvider {
+		t.Fatalf("Query() error:\n%+v", err)
+	}
+}
@@ -0,0 +1,226 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"akvorado/common/schema"
+	"akvorado/inlet/metadata/provider"
+)
+
+// netboxList is a paginated list of results.
+type netboxList[T any] struct {
+	Next    string `json:"next"`
+	Results []T    `json:"results"`
+}
+
+// netboxRef is a nested object.
+type netboxRef struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+// netboxIPAddress is an IP address.
+type netboxIPAddress struct {
+	AssignedObject *struct {
+		Device *netboxRef `json:"device"`
+	} `json:"assigned_object"`
+}
+
+// netboxDevice is a device.
+type netboxDevice struct {
+	ID     int        `json:"id"`
+	Name   string     `json:"name"`
+	Role   *netboxRef `json:"role"`
+	Site   *netboxRef `json:"site"`
+	Tenant *netboxRef `json:"tenant"`
+	// DeviceRole is the role for NetBox before 4.0
+	DeviceRole *netboxRef `json:"device_role"`
+}
+
+// netboxInterface is an interface.
+type netboxInterface struct {
+	Name         string                 `json:"name"`
+	Description  string                 `json:"description"`
+	Speed        uint                   `json:"speed"`
+	Tags         []netboxRef            `json:"tags"`
+	CustomFields map[string]interface{} `json:"custom_fields"`
+}
+
+// get fetches the provided URL and decodes the JSON answer.
+func (p *Provider) get(ctx context.Context, u string, result interface{}) error {
+	if err := p.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Token %s", p.config.Token))
+	req.Header.Set("Accept", "application/json")
+	p.metrics.requests.Inc()
+	resp, err := p.client.Do(req)
+	if err != nil {
+		p.metrics.errors.WithLabelValues("cannot query").Inc()
+		return fmt.Errorf("cannot query NetBox: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		p.metrics.errors.WithLabelValues("unexpected status").Inc()
+		return fmt.Errorf("unexpected status code %d from NetBox", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		p.metrics.errors.WithLabelValues("cannot decode").Inc()
+		return fmt.Errorf("cannot decode answer from NetBox: %w", err)
+	}
+	return nil
+}
+
+// list fetches all the results from a paginated API endpoint.
+func list[T any](ctx context.Context, p *Provider, path string, query url.Values) ([]T, error) {
+	results := []T{}
+	u := fmt.Sprintf("%s/api/%s/?%s", strings.TrimSuffix(p.config.URL, "/"), path, query.Encode())
+	for u != "" {
+		var page netboxList[T]
+		if err := p.get(ctx, u, &page); err != nil {
+			return nil, err
+		}
+		results = append(results, page.Results...)
+		u = page.Next
+	}
+	return results, nil
+}
+
+// lookupDevice returns the device owning the provided IP address. If there is
+// none, nil is returned.
+func (p *Provider) lookupDevice(ctx context.Context, ip netip.Addr) (*netboxDevice, error) {
+	addresses, err := list[netboxIPAddress](ctx, p, "ipam/ip-addresses",
+		url.Values{"address": []string{ip.Unmap().String()}})
+	if err != nil {
+		return nil, err
+	}
+	for _, address := range addresses {
+		if address.AssignedObject == nil || address.AssignedObject.Device == nil {
+			continue
+		}
+		var device netboxDevice
+		u := fmt.Sprintf("%s/api/dcim/devices/%d/",
+			strings.TrimSuffix(p.config.URL, "/"), address.AssignedObject.Device.ID)
+		if err := p.get(ctx, u, &device); err != nil {
+			return nil, err
+		}
+		return &device, nil
+	}
+	return nil, nil
+}
+
+// lookupInterfaces returns the interfaces of the provided device, indexed by
+// their interface index.
+func (p *Provider) lookupInterfaces(ctx context.Context, device *netboxDevice) (map[uint]provider.Interface, error) {
+	interfaces, err := list[netboxInterface](ctx, p, "dcim/interfaces",
+		url.Values{"device_id": []string{strconv.Itoa(device.ID)}, "limit": []string{"1000"}})
+	if err != nil {
+		return nil, err
+	}
+	result := map[uint]provider.Interface{}
+	for _, iface := range interfaces {
+		ifIndex, ok := customFieldUint(iface.CustomFields[p.config.IfIndexField])
+		if !ok {
+			continue
+		}
+		var boundary schema.InterfaceBoundary
+		if value := p.config.Boundary.extract(iface); value != "" {
+			if err := boundary.UnmarshalText([]byte(strings.ToLower(value))); err != nil {
+				p.errLogger.Warn().
+					Str("device", device.Name).
+					Str("interface", iface.Name).
+					Msgf("invalid boundary %q", value)
+			}
+		}
+		result[ifIndex] = provider.Interface{
+			Name:         iface.Name,
+			Description:  iface.Description,
+			Speed:        uint32(iface.Speed / 1000), // NetBox uses kbps
+			Provider:     p.config.Provider.extract(iface),
+			Connectivity: p.config.Connectivity.extract(iface),
+			Boundary:     boundary,
+		}
+	}
+	return result, nil
+}
+
+// exporter turns a device into an exporter.
+func (device *netboxDevice) exporter() provider.Exporter {
+	exporter := provider.Exporter{Name: device.Name}
+	if device.Role != nil {
+		exporter.Role = device.Role.Slug
+	} else if device.DeviceRole != nil {
+		exporter.Role = device.DeviceRole.Slug
+	}
+	if device.Site != nil {
+		exporter.Site = device.Site.Slug
+	}
+	if device.Tenant != nil {
+		exporter.Tenant = device.Tenant.Slug
+	}
+	return exporter
+}
+
+// extract extracts the value for a field mapping from an interface.
+func (fm FieldMapping) extract(iface netboxInterface) string {
+	if fm.Name == "" {
+		return ""
+	}
+	if fm.Tag {
+		for _, tag := range iface.Tags {
+			if value, ok := strings.CutPrefix(tag.Slug, fm.Name); ok {
+				return value
+			}
+		}
+		return ""
+	}
+	switch value := iface.CustomFields[fm.Name].(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case map[string]interface{}:
+		// Selection or object custom fields
+		for _, key := range []string{"value", "slug", "name"} {
+			if v, ok := value[key].(string); ok {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// customFieldUint converts a custom field to an unsigned integer.
+func customFieldUint(value interface{}) (uint, bool) {
+	switch value := value.(type) {
+	case float64:
+		if value < 0 {
+			return 0, false
+		}
+		return uint(value), true
+	case string:
+		v, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return 0, false
+		}
+		return uint(v), true
+	}
+	return 0, false
+}
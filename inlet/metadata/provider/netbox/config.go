@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package netbox
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"akvorado/common/helpers"
+	"akvorado/inlet/metadata/provider"
+)
+
+// Configuration describes the configuration for the NetBox provider
+type Configuration struct {
+	// URL is the base URL of NetBox (eg https://netbox.example.com)
+	URL string `validate:"required,url"`
+	// Token is the API token used to authenticate to NetBox
+	Token string `validate:"required"`
+	// TLS defines the TLS configuration to connect to NetBox
+	TLS helpers.TLSConfiguration
+	// Timeout tells how much time to wait for an answer from NetBox
+	Timeout time.Duration `validate:"min=100ms"`
+	// RateLimit is the maximum number of API requests per second
+	RateLimit rate.Limit `validate:"gt=0"`
+
+	// IfIndexField is the custom field of interfaces containing the interface index
+	IfIndexField string `validate:"required"`
+	// Provider tells where to find the provider of an interface
+	Provider FieldMapping
+	// Connectivity tells where to find the connectivity of an interface
+	Connectivity FieldMapping
+	// Boundary tells where to find the boundary of an interface
+	Boundary FieldMapping
+}
+
+// DefaultConfiguration represents the default configuration for the NetBox provider.
+func DefaultConfiguration() provider.Configuration {
+	return Configuration{
+		TLS: helpers.TLSConfiguration{
+			Enable: false,
+			Verify: true,
+		},
+		Timeout:      5 * time.Second,
+		RateLimit:    10,
+		IfIndexField: "ifindex",
+	}
+}
+
+// FieldMapping tells where to find a value for an interface in NetBox. It is
+// either a custom field or a tag prefix.
+type FieldMapping struct {
+	// Tag is true when the value is extracted from the slug of the first tag
+	// matching the prefix in Name. Otherwise, Name is a custom field.
+	Tag  bool
+	Name string
+}
+
+// UnmarshalText parses a field mapping. It should be either
+// "custom-field:NAME" or "tag:PREFIX".
+func (fm *FieldMapping) UnmarshalText(input []byte) error {
+	text := string(input)
+	if text == "" {
+		*fm = FieldMapping{}
+		return nil
+	}
+	kind, name, ok := strings.Cut(text, ":")
+	if !ok || name == "" {
+		return fmt.Errorf("invalid field mapping %q", text)
+	}
+	switch kind {
+	case "custom-field":
+		*fm = FieldMapping{Name: name}
+	case "tag":
+		*fm = FieldMapping{Tag: true, Name: name}
+	default:
+		return errors.New("field mapping should start with custom-field: or tag:")
+	}
+	return nil
+}
+
+// String turns a field mapping into a string.
+func (fm FieldMapping) String() string {
+	switch {
+	case fm.Name == "":
+		return ""
+	case fm.Tag:
+		return fmt.Sprintf("tag:%s", fm.Name)
+	default:
+		return fmt.Sprintf("custom-field:%s", fm.Name)
+	}
+}
+
+// MarshalText turns a field mapping into a string.
+func (fm FieldMapping) MarshalText() ([]byte, error) {
+	return []byte(fm.String()), nil
+}
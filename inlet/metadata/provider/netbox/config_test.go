@@ -0,0 +1,84 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package netbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"akvorado/common/helpers"
+)
+
+func TestConfigurationDecode(t *testing.T) {
+	helpers.TestConfigurationDecode(t, helpers.ConfigurationDecodeCases{
+		{
+			Description: "minimal",
+			Initial:     func() interface{} { return DefaultConfiguration() },
+			Configuration: func() interface{} {
+				return gin.H{
+					"url":   "https://netbox.example.com",
+					"token": "0123456789abcdef",
+				}
+			},
+			Expected: Configuration{
+				URL:   "https://netbox.example.com",
+				Token: "0123456789abcdef",
+				TLS: helpers.TLSConfiguration{
+					Verify: true,
+				},
+				Timeout:      5 * time.Second,
+				RateLimit:    10,
+				IfIndexField: "ifindex",
+			},
+		}, {
+			Description: "field mappings",
+			Initial:     func() interface{} { return DefaultConfiguration() },
+			Configuration: func() interface{} {
+				return gin.H{
+					"url":          "https://netbox.example.com",
+					"token":        "0123456789abcdef",
+					"rate-limit":   2,
+					"provider":     "custom-field:provider",
+					"connectivity": "tag:connectivity-",
+					"boundary":     "custom-field:boundary",
+				}
+			},
+			Expected: Configuration{
+				URL:   "https://netbox.example.com",
+				Token: "0123456789abcdef",
+				TLS: helpers.TLSConfiguration{
+					Verify: true,
+				},
+				Timeout:      5 * time.Second,
+				RateLimit:    2,
+				IfIndexField: "ifindex",
+				Provider:     FieldMapping{Name: "provider"},
+				Connectivity: FieldMapping{Tag: true, Name: "connectivity-"},
+				Boundary:     FieldMapping{Name: "boundary"},
+			},
+		}, {
+			Description: "invalid field mapping",
+			Initial:     func() interface{} { return DefaultConfiguration() },
+			Configuration: func() interface{} {
+				return gin.H{
+					"url":      "https://netbox.example.com",
+					"token":    "0123456789abcdef",
+					"provider": "label:provider",
+				}
+			},
+			Error: true,
+		}, {
+			Description: "missing token",
+			Initial:     func() interface{} { return DefaultConfiguration() },
+			Configuration: func() interface{} {
+				return gin.H{
+					"url": "https://netbox.example.com",
+				}
+			},
+			Error: true,
+		},
+	})
+}
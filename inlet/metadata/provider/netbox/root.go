@@ -0,0 +1,107 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Package netbox is a metadata provider using the NetBox API to get interface
+// names and descriptions.
+package netbox
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"akvorado/common/reporter"
+	"akvorado/inlet/metadata/provider"
+)
+
+// Provider represents the NetBox provider.
+type Provider struct {
+	r         *reporter.Reporter
+	config    *Configuration
+	client    *http.Client
+	limiter   *rate.Limiter
+	errLogger reporter.Logger
+
+	put func(provider.Update)
+
+	metrics struct {
+		requests reporter.Counter
+		errors   *reporter.CounterVec
+		unknown  reporter.Counter
+	}
+}
+
+// New creates a new NetBox provider from configuration
+func (configuration Configuration) New(r *reporter.Reporter, put func(provider.Update)) (provider.Provider, error) {
+	tlsConfig, err := configuration.TLS.MakeTLSConfig()
+	if err != nil {
+		return nil, fmt.Errorf("cannot setup TLS for NetBox: %w", err)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	p := Provider{
+		r:         r,
+		config:    &configuration,
+		client:    &http.Client{Transport: transport},
+		limiter:   rate.NewLimiter(configuration.RateLimit, 1),
+		errLogger: r.Sample(reporter.BurstSampler(10*time.Second, 3)),
+		put:       put,
+	}
+
+	p.metrics.requests = r.Counter(
+		reporter.CounterOpts{
+			Name: "api_requests_total",
+			Help: "Number of requests sent to NetBox.",
+		})
+	p.metrics.errors = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "api_errors_total",
+			Help: "Number of failed requests to NetBox.",
+		}, []string{"error"})
+	p.metrics.unknown = r.Counter(
+		reporter.CounterOpts{
+			Name: "unknown_exporters_total",
+			Help: "Number of queries for exporters unknown to NetBox.",
+		})
+
+	return &p, nil
+}
+
+// Query queries NetBox to get information about an exporter. Exporters unknown
+// to NetBox are left to the next provider.
+func (p *Provider) Query(ctx context.Context, q provider.BatchQuery) error {
+	exporterStr := q.ExporterIP.Unmap().String()
+	device, err := p.lookupDevice(ctx, q.ExporterIP)
+	if err != nil {
+		p.errLogger.Err(err).Str("exporter", exporterStr).Msg("cannot lookup device")
+		return err
+	}
+	if device == nil {
+		p.metrics.unknown.Inc()
+		return provider.ErrSkipProvider
+	}
+	interfaces, err := p.lookupInterfaces(ctx, device)
+	if err != nil {
+		p.errLogger.Err(err).Str("exporter", exporterStr).Msg("cannot lookup interfaces")
+		return err
+	}
+	exporter := device.exporter()
+	for _, ifIndex := range q.IfIndexes {
+		p.put(provider.Update{
+			Query: provider.Query{
+				ExporterIP: q.ExporterIP,
+				IfIndex:    ifIndex,
+			},
+			Answer: provider.Answer{
+				Exporter:  exporter,
+				Interface: interfaces[ifIndex],
+			},
+		})
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package netbox
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/metadata/provider"
+)
+
+func TestNetBoxProvider(t *testing.T) {
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/ipam/ip-addresses/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Query().Get("address") {
+		case "192.0.2.1":
+			fmt.Fprint(w, `{"count": 1, "next": null, "results": [
+  {"id": 1, "address": "192.0.2.1/32", "assigned_object_type": "dcim.interface",
+   "assigned_object": {"id": 3, "name": "lo0", "device": {"id": 10, "name": "edge1"}}}]}`)
+		case "192.0.2.2":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			fmt.Fprint(w, `{"count": 0, "next": null, "results": []}`)
+		}
+	})
+	mux.HandleFunc("/api/dcim/devices/10/", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, `{"id": 10, "name": "edge1",
+  "role": {"id": 1, "name": "Edge", "slug": "edge"},
+  "site": {"id": 1, "name": "Paris", "slug": "par1"},
+  "tenant": null}`)
+	})
+	mux.HandleFunc("/api/dcim/interfaces/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("device_id") != "10" {
+			t.Errorf("unexpected device_id %q", r.URL.Query().Get("device_id"))
+		}
+		if r.URL.Query().Get("offset") == "" {
+			fmt.Fprintf(w, `{"count": 3, "next": "%s/api/dcim/interfaces/?device_id=10&offset=2", "results": [
+  {"id": 1, "name": "Gi0/0/1", "description": "Transit: Cogent", "speed": 10000000,
+   "tags": [{"id": 1, "name": "transit", "slug": "connectivity-transit"}],
+   "custom_fields": {"ifindex": 1, "provider": "cogent", "boundary": "external"}},
+  {"id": 2, "name": "Gi0/0/2", "description": "Core", "speed": 100000000,
+   "tags": [], "custom_fields": {"ifindex": "2", "provider": null, "boundary": "internal"}}]}`, server.URL)
+			return
+		}
+		fmt.Fprint(w, `{"count": 3, "next": null, "results": [
+  {"id": 3, "name": "lo0", "description": "", "speed": null,
+   "tags": [], "custom_fields": {"ifindex": null}}]}`)
+	})
+	server = httptest.NewServer(mux)
+	defer server.Close()
+
+	config := DefaultConfiguration().(Configuration)
+	config.URL = server.URL
+	config.Token = "secret"
+	config.RateLimit = 1000
+	config.Provider = FieldMapping{Name: "provider"}
+	config.Connectivity = FieldMapping{Tag: true, Name: "connectivity-"}
+	config.Boundary = FieldMapping{Name: "boundary"}
+
+	got := []provider.Update{}
+	r := reporter.NewMock(t)
+	p, err := config.New(r, func(update provider.Update) { got = append(got, update) })
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+
+	err = p.Query(context.Background(), provider.BatchQuery{
+		ExporterIP: netip.MustParseAddr("::ffff:192.0.2.1"),
+		IfIndexes:  []uint{1, 2, 3},
+	})
+	if err != nil {
+		t.Fatalf("Query() error:\n%+v", err)
+	}
+	if err := p.Query(context.Background(), provider.BatchQuery{
+		ExporterIP: netip.MustParseAddr("::ffff:192.0.2.2"),
+		IfIndexes:  []uint{1},
+	}); err == nil {
+		t.Error("Query() for failing exporter did not error")
+	}
+	if err := p.Query(context.Background(), provider.BatchQuery{
+		ExporterIP: netip.MustParseAddr("::ffff:192.0.2.3"),
+		IfIndexes:  []uint{1},
+	}); err != provider.ErrSkipProvider {
+		t.Errorf("Query() for unknown exporter error:\n%+v", err)
+	}
+
+	exporter := provider.Exporter{Name: "edge1", Role: "edge", Site: "par1"}
+	expected := []provider.Update{
+		{
+			Query: provider.Query{ExporterIP: netip.MustParseAddr("::ffff:192.0.2.1"), IfIndex: 1},
+			Answer: provider.Answer{
+				Exporter: exporter,
+				Interface: provider.Interface{
+					Name:         "Gi0/0/1",
+					Description:  "Transit: Cogent",
+					Speed:        10000,
+					Provider:     "cogent",
+					Connectivity: "transit",
+					Boundary:     schema.InterfaceBoundaryExternal,
+				},
+			},
+		}, {
+			Query: provider.Query{ExporterIP: netip.MustParseAddr("::ffff:192.0.2.1"), IfIndex: 2},
+			Answer: provider.Answer{
+				Exporter: exporter,
+				Interface: provider.Interface{
+					Name:        "Gi0/0/2",
+					Description: "Core",
+					Speed:       100000,
+					Boundary:    schema.InterfaceBoundaryInternal,
+				},
+			},
+		}, {
+			Query: provider.Query{ExporterIP: netip.MustParseAddr("::ffff:192.0.2.1"), IfIndex: 3},
+			Answer: provider.Answer{
+				Exporter: exporter,
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("Query() (-got, +want):\n%s", diff)
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_metadata_provider_netbox_")
+	expectedMetrics := map[string]string{
+		`api_requests_total`:                          "6",
+		`api_errors_total{error="unexpected status"}`: "1",
+		`unknown_exporters_total`:                     "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
@@ -21,7 +21,7 @@ var ErrSkipProvider = errors.New("provider skips query")
 type Interface struct {
 	Name         string `validate:"required"`
 	Description  string `validate:"required"`
-	Speed        uint   `validate:"required"`
+	Speed        uint32 `validate:"required"` // in Mbps
 	Provider     string
 	Connectivity string
 	Boundary     schema.InterfaceBoundary
@@ -41,6 +41,9 @@ type Exporter struct {
 	Site string
 	// Group is a functional or organisational identifier for the exporter, used to set ExporterGroup.
 	Group string
+	// Location is the physical location of the exporter (sysLocation for
+	// SNMP). It is only used by the exporter classifier.
+	Location string
 }
 
 // Query is the query sent to a provider.
@@ -20,10 +20,15 @@ import (
 
 // Configuration describes the configuration for the SNMP client
 type Configuration struct {
-	// PollerRetries tell how many time a poller should retry before giving up
-	PollerRetries int `validate:"min=0"`
-	// PollerTimeout tell how much time a poller should wait for an answer
-	PollerTimeout time.Duration `validate:"min=100ms"`
+	// PollerRetries is a mapping from exporter IPs to the number of times a
+	// poller should retry before giving up
+	PollerRetries *helpers.SubnetMap[int] `validate:"omitempty,dive,min=0"`
+	// PollerTimeout is a mapping from exporter IPs to how much time a poller
+	// should wait for an answer
+	PollerTimeout *helpers.SubnetMap[time.Duration] `validate:"omitempty,dive,min=100ms"`
+	// PollerMaxInFlight is a mapping from exporter IPs to the maximum number
+	// of concurrent requests to an exporter (0 for no limit)
+	PollerMaxInFlight *helpers.SubnetMap[int] `validate:"omitempty,dive,min=0"`
 
 	// Communities is a mapping from exporter IPs to SNMPv2 communities
 	Communities *helpers.SubnetMap[[]string]
@@ -33,6 +38,14 @@ type Configuration struct {
 	Agents map[netip.Addr]netip.Addr
 	// Ports is a mapping from exporter IPs to SNMP port
 	Ports *helpers.SubnetMap[uint16]
+	// InheritParentSpeed tells to use the speed of the lower layer interface
+	// when an interface (usually a sub-interface) has a speed of 0
+	InheritParentSpeed bool
+	// Prefetch tells to walk all the interfaces of an exporter on first contact
+	Prefetch bool
+	// PrefetchInterval tells how often to walk again all the interfaces of an
+	// exporter (0 to only walk them on first contact)
+	PrefetchInterval time.Duration `validate:"eq=0|min=1m"`
 }
 
 // SecurityParameters describes SNMPv3 USM security parameters.
@@ -48,8 +61,13 @@ type SecurityParameters struct {
 // DefaultConfiguration represents the default configuration for the SNMP client.
 func DefaultConfiguration() provider.Configuration {
 	return Configuration{
-		PollerRetries: 1,
-		PollerTimeout: time.Second,
+		PollerRetries: helpers.MustNewSubnetMap(map[string]int{
+			"::/0": 1,
+		}),
+		PollerTimeout: helpers.MustNewSubnetMap(map[string]time.Duration{
+			"::/0": time.Second,
+		}),
+		PollerMaxInFlight: helpers.MustNewSubnetMap(map[string]int{}),
 
 		Communities: helpers.MustNewSubnetMap(map[string][]string{
 			"::/0": {"public"},
@@ -192,6 +210,10 @@ func init() {
 	helpers.RegisterMapstructureUnmarshallerHook(helpers.SubnetMapUnmarshallerHook[[]string]())
 	helpers.RegisterMapstructureUnmarshallerHook(helpers.SubnetMapUnmarshallerHook[SecurityParameters]())
 	helpers.RegisterMapstructureUnmarshallerHook(helpers.SubnetMapUnmarshallerHook[uint16]())
+	helpers.RegisterMapstructureUnmarshallerHook(helpers.SubnetMapUnmarshallerHook[int]())
+	helpers.RegisterMapstructureUnmarshallerHook(helpers.SubnetMapUnmarshallerHook[time.Duration]())
 	helpers.RegisterSubnetMapValidation[SecurityParameters]()
 	helpers.RegisterSubnetMapValidation[uint16]()
+	helpers.RegisterSubnetMapValidation[int]()
+	helpers.RegisterSubnetMapValidation[time.Duration]()
 }
@@ -47,7 +47,7 @@ func TestConfigurationUnmarshallerHook(t *testing.T) {
 				}
 			},
 			Expected: Configuration{
-				PollerTimeout: 200 * time.Millisecond,
+				PollerTimeout: helpers.MustNewSubnetMap(map[string]time.Duration{"::/0": 200 * time.Millisecond}),
 				Ports: helpers.MustNewSubnetMap(map[string]uint16{
 					"::/0": 1161,
 				}),
@@ -68,7 +68,7 @@ func TestConfigurationUnmarshallerHook(t *testing.T) {
 				}
 			},
 			Expected: Configuration{
-				PollerTimeout: 200 * time.Millisecond,
+				PollerTimeout: helpers.MustNewSubnetMap(map[string]time.Duration{"::/0": 200 * time.Millisecond}),
 				Ports: helpers.MustNewSubnetMap(map[string]uint16{
 					"2001:db8:1::/48": 1161,
 					"2001:db8:2::/48": 1162,
@@ -87,8 +87,42 @@ func TestConfigurationUnmarshallerHook(t *testing.T) {
 				}
 			},
 			Expected: Configuration{
-				PollerRetries: 10,
-				PollerTimeout: 200 * time.Millisecond,
+				PollerRetries: helpers.MustNewSubnetMap(map[string]int{"::/0": 10}),
+				PollerTimeout: helpers.MustNewSubnetMap(map[string]time.Duration{"::/0": 200 * time.Millisecond}),
+				Communities: helpers.MustNewSubnetMap(map[string][]string{
+					"::/0": {"public"},
+				}),
+			},
+		}, {
+			Description: "per-prefix poller settings",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"poller-retries": gin.H{
+						"::/0":         2,
+						"192.0.2.0/24": 0,
+					},
+					"poller-timeout": gin.H{
+						"::/0":         "200ms",
+						"192.0.2.0/24": "5s",
+					},
+					"poller-max-in-flight": gin.H{
+						"192.0.2.0/24": 2,
+					},
+				}
+			},
+			Expected: Configuration{
+				PollerRetries: helpers.MustNewSubnetMap(map[string]int{
+					"::/0":                 2,
+					"::ffff:192.0.2.0/120": 0,
+				}),
+				PollerTimeout: helpers.MustNewSubnetMap(map[string]time.Duration{
+					"::/0":                 200 * time.Millisecond,
+					"::ffff:192.0.2.0/120": 5 * time.Second,
+				}),
+				PollerMaxInFlight: helpers.MustNewSubnetMap(map[string]int{
+					"::ffff:192.0.2.0/120": 2,
+				}),
 				Communities: helpers.MustNewSubnetMap(map[string][]string{
 					"::/0": {"public"},
 				}),
@@ -106,7 +140,7 @@ func TestConfigurationUnmarshallerHook(t *testing.T) {
 				}
 			},
 			Expected: Configuration{
-				PollerTimeout: 200 * time.Millisecond,
+				PollerTimeout: helpers.MustNewSubnetMap(map[string]time.Duration{"::/0": 200 * time.Millisecond}),
 				Communities: helpers.MustNewSubnetMap(map[string][]string{
 					"::/0":                     {"public"},
 					"::ffff:203.0.113.0/121":   {"public"},
@@ -123,7 +157,7 @@ func TestConfigurationUnmarshallerHook(t *testing.T) {
 				}
 			},
 			Expected: Configuration{
-				PollerTimeout: 200 * time.Millisecond,
+				PollerTimeout: helpers.MustNewSubnetMap(map[string]time.Duration{"::/0": 200 * time.Millisecond}),
 				Communities: helpers.MustNewSubnetMap(map[string][]string{
 					"::/0": {"private"},
 				}),
@@ -142,7 +176,7 @@ func TestConfigurationUnmarshallerHook(t *testing.T) {
 				}
 			},
 			Expected: Configuration{
-				PollerTimeout: 200 * time.Millisecond,
+				PollerTimeout: helpers.MustNewSubnetMap(map[string]time.Duration{"::/0": 200 * time.Millisecond}),
 				Communities: helpers.MustNewSubnetMap(map[string][]string{
 					"::/0":                     {"private"},
 					"::ffff:203.0.113.0/121":   {"public"},
@@ -159,7 +193,7 @@ func TestConfigurationUnmarshallerHook(t *testing.T) {
 				}
 			},
 			Expected: Configuration{
-				PollerTimeout: 200 * time.Millisecond,
+				PollerTimeout: helpers.MustNewSubnetMap(map[string]time.Duration{"::/0": 200 * time.Millisecond}),
 				Communities: helpers.MustNewSubnetMap(map[string][]string{
 					"::/0": {"private"},
 				}),
@@ -189,7 +223,7 @@ func TestConfigurationUnmarshallerHook(t *testing.T) {
 				}
 			},
 			Expected: Configuration{
-				PollerTimeout: 200 * time.Millisecond,
+				PollerTimeout: helpers.MustNewSubnetMap(map[string]time.Duration{"::/0": 200 * time.Millisecond}),
 				Communities: helpers.MustNewSubnetMap(map[string][]string{
 					"::/0":                     {"public"},
 					"::ffff:203.0.113.0/121":   {"public"},
@@ -212,7 +246,7 @@ func TestConfigurationUnmarshallerHook(t *testing.T) {
 				}
 			},
 			Expected: Configuration{
-				PollerTimeout: 200 * time.Millisecond,
+				PollerTimeout: helpers.MustNewSubnetMap(map[string]time.Duration{"::/0": 200 * time.Millisecond}),
 				Communities: helpers.MustNewSubnetMap(map[string][]string{
 					"::/0": {"public"},
 				}),
@@ -240,7 +274,7 @@ func TestConfigurationUnmarshallerHook(t *testing.T) {
 				}
 			},
 			Expected: Configuration{
-				PollerTimeout: 200 * time.Millisecond,
+				PollerTimeout: helpers.MustNewSubnetMap(map[string]time.Duration{"::/0": 200 * time.Millisecond}),
 				Communities: helpers.MustNewSubnetMap(map[string][]string{
 					"::/0": {"public"},
 				}),
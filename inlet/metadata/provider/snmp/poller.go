@@ -9,6 +9,8 @@ import (
 	"fmt"
 	"net/netip"
 	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gosnmp/gosnmp"
@@ -46,70 +48,31 @@ func (p *Provider) Poll(ctx context.Context, exporter, agent netip.Addr, port ui
 		p.pendingRequestsLock.Unlock()
 	}()
 
-	// Instantiate an SNMP state
-	g := &gosnmp.GoSNMP{
-		Context:                 ctx,
-		Target:                  agent.Unmap().String(),
-		Port:                    port,
-		Retries:                 p.config.PollerRetries,
-		Timeout:                 p.config.PollerTimeout,
-		UseUnconnectedUDPSocket: true,
-		Logger:                  gosnmp.NewLogger(&goSNMPLogger{p.r}),
-		OnRetry: func(*gosnmp.GoSNMP) {
-			p.metrics.retries.WithLabelValues(exporterStr).Inc()
-		},
-	}
-	communities := []string{""}
-	if securityParameters, ok := p.config.SecurityParameters.Lookup(exporter); ok {
-		g.Version = gosnmp.Version3
-		g.SecurityModel = gosnmp.UserSecurityModel
-		usmSecurityParameters := gosnmp.UsmSecurityParameters{
-			UserName:                 securityParameters.UserName,
-			AuthenticationProtocol:   gosnmp.SnmpV3AuthProtocol(securityParameters.AuthenticationProtocol),
-			AuthenticationPassphrase: securityParameters.AuthenticationPassphrase,
-			PrivacyProtocol:          gosnmp.SnmpV3PrivProtocol(securityParameters.PrivacyProtocol),
-			PrivacyPassphrase:        securityParameters.PrivacyPassphrase,
-		}
-		g.SecurityParameters = &usmSecurityParameters
-		if usmSecurityParameters.AuthenticationProtocol == gosnmp.NoAuth {
-			if usmSecurityParameters.PrivacyProtocol == gosnmp.NoPriv {
-				g.MsgFlags = gosnmp.NoAuthNoPriv
-			} else {
-				// Not possible
-				g.MsgFlags = gosnmp.NoAuthNoPriv
-			}
-		} else {
-			if usmSecurityParameters.PrivacyProtocol == gosnmp.NoPriv {
-				g.MsgFlags = gosnmp.AuthNoPriv
-			} else {
-				g.MsgFlags = gosnmp.AuthPriv
-			}
-		}
-		g.ContextName = securityParameters.ContextName
-	} else {
-		g.Version = gosnmp.Version2c
-		communities = p.config.Communities.LookupOrDefault(exporter, []string{"public"})
-	}
-
+	g, communities := p.newSNMP(ctx, exporter, agent, port)
 	start := time.Now()
 	if err := g.Connect(); err != nil {
 		p.metrics.errors.WithLabelValues(exporterStr, "connect").Inc()
 		p.errLogger.Err(err).Str("exporter", exporterStr).Msg("unable to connect")
 	}
-	requests := []string{"1.3.6.1.2.1.1.5.0"}
+	requests := []string{
+		"1.3.6.1.2.1.1.5.0", // sysName
+		"1.3.6.1.2.1.1.6.0", // sysLocation
+	}
 	for _, ifIndex := range ifIndexes {
 		moreRequests := []string{
 			fmt.Sprintf("1.3.6.1.2.1.2.2.1.2.%d", ifIndex),     // ifDescr
 			fmt.Sprintf("1.3.6.1.2.1.31.1.1.1.18.%d", ifIndex), // ifAlias
-			fmt.Sprintf("1.3.6.1.2.1.31.1.1.1.15.%d", ifIndex), // ifSpeed
+			fmt.Sprintf("1.3.6.1.2.1.31.1.1.1.15.%d", ifIndex), // ifHighSpeed
+			fmt.Sprintf("1.3.6.1.2.1.2.2.1.5.%d", ifIndex),     // ifSpeed
 		}
 		requests = append(requests, moreRequests...)
 	}
 	var results []gosnmp.SnmpPDU
 	success := false
+	workingCommunity := ""
 
 	logError := func(err error) error {
-		p.metrics.errors.WithLabelValues(exporterStr, "get").Inc()
+		p.metrics.errors.WithLabelValues(exporterStr, errorKind(err)).Inc()
 		p.errLogger.Err(err).
 			Str("exporter", exporterStr).
 			Msgf("unable to GET (%d OIDs)", len(requests))
@@ -123,6 +86,9 @@ func (p *Provider) Poll(ctx context.Context, exporter, agent netip.Addr, port ui
 
 		g.Community = community
 		currentResult, err := g.Get(requests)
+		if err == nil && !success {
+			workingCommunity = community
+		}
 		if errors.Is(err, context.Canceled) {
 			return nil
 		}
@@ -169,32 +135,45 @@ func (p *Provider) Poll(ctx context.Context, exporter, agent netip.Addr, port ui
 		}
 		return true
 	}
-	processUint := func(idx int, what string, target *uint) bool {
-		switch results[idx].Type {
-		case gosnmp.Gauge32:
-			*target = results[idx].Value.(uint)
-		case gosnmp.NoSuchInstance, gosnmp.NoSuchObject, gosnmp.Null:
-			p.metrics.errors.WithLabelValues(exporterStr, fmt.Sprintf("%s missing", what)).Inc()
-			return false
-		default:
-			p.metrics.errors.WithLabelValues(exporterStr, fmt.Sprintf("%s unknown type", what)).Inc()
-			return false
+	processSpeed := func(results []gosnmp.SnmpPDU, idx int, target *uint32) bool {
+		// Prefer ifHighSpeed (in Mbps) and fallback to ifSpeed (in bps) for
+		// agents not implementing ifXTable.
+		highSpeed, highSpeedOk := results[idx].Value.(uint)
+		highSpeedOk = highSpeedOk && results[idx].Type == gosnmp.Gauge32
+		if highSpeedOk && highSpeed > 0 {
+			*target = uint32(highSpeed)
+			return true
 		}
-		return true
+		if speed, ok := results[idx+1].Value.(uint); ok && results[idx+1].Type == gosnmp.Gauge32 {
+			*target = uint32(speed / 1_000_000)
+			return true
+		}
+		if highSpeedOk {
+			*target = 0
+			return true
+		}
+		p.metrics.errors.WithLabelValues(exporterStr, "ifspeed missing").Inc()
+		return false
 	}
 	var (
-		sysNameVal string
+		sysNameVal     string
+		sysLocationVal string
 	)
 	if !processStr(0, "sysname", &sysNameVal) {
 		return errors.New("unable to get sysName")
 	}
-	for idx := 1; idx < len(requests)-2; idx += 3 {
+	// sysLocation is optional
+	if results[1].Type == gosnmp.OctetString {
+		sysLocationVal = string(results[1].Value.([]byte))
+	}
+	updates := make([]provider.Update, 0, len(ifIndexes))
+	for idx := 2; idx < len(requests)-3; idx += 4 {
 		var (
 			ifDescrVal string
 			ifAliasVal string
-			ifSpeedVal uint
+			ifSpeedVal uint32
 		)
-		ifIndex := ifIndexes[(idx-1)/3]
+		ifIndex := ifIndexes[(idx-2)/4]
 		ok := true
 		// We do not process results when index is 0 (this can happen for local
 		// traffic, we only care for exporter name).
@@ -205,20 +184,21 @@ func (p *Provider) Poll(ctx context.Context, exporter, agent netip.Addr, port ui
 		if ifIndex > 0 && !processStr(idx+1, "ifalias", &ifAliasVal) {
 			ok = false
 		}
-		if ifIndex > 0 && !processUint(idx+2, "ifspeed", &ifSpeedVal) {
+		if ifIndex > 0 && !processSpeed(results, idx+2, &ifSpeedVal) {
 			ok = false
 		}
 		if ok {
 			p.metrics.successes.WithLabelValues(exporterStr).Inc()
 		}
-		put(provider.Update{
+		updates = append(updates, provider.Update{
 			Query: provider.Query{
 				ExporterIP: exporter,
 				IfIndex:    ifIndex,
 			},
 			Answer: provider.Answer{
 				Exporter: provider.Exporter{
-					Name: sysNameVal,
+					Name:     sysNameVal,
+					Location: sysLocationVal,
 				},
 				Interface: provider.Interface{
 					Name:        ifDescrVal,
@@ -229,9 +209,112 @@ func (p *Provider) Poll(ctx context.Context, exporter, agent netip.Addr, port ui
 		})
 	}
 
+	// Sub-interfaces may report a speed of 0. Use the speed of the lower
+	// layer interface (from ifStackTable) instead.
+	if p.config.InheritParentSpeed {
+		g.Community = workingCommunity
+		zeroSpeeds := []int{}
+		for i, update := range updates {
+			if update.IfIndex > 0 && update.Interface.Speed == 0 {
+				zeroSpeeds = append(zeroSpeeds, i)
+			}
+		}
+		for _, i := range zeroSpeeds {
+			parent, ok := p.pollParentIfIndex(g, updates[i].IfIndex)
+			if !ok {
+				continue
+			}
+			parentResults, err := g.Get([]string{
+				fmt.Sprintf("1.3.6.1.2.1.31.1.1.1.15.%d", parent), // ifHighSpeed
+				fmt.Sprintf("1.3.6.1.2.1.2.2.1.5.%d", parent),     // ifSpeed
+			})
+			if err != nil || len(parentResults.Variables) != 2 {
+				continue
+			}
+			processSpeed(parentResults.Variables, 0, &updates[i].Interface.Speed)
+		}
+	}
+
+	for _, update := range updates {
+		put(update)
+	}
+
 	return nil
 }
 
+// pollParentIfIndex returns the first lower layer interface index of the
+// provided interface using ifStackStatus.
+func (p *Provider) pollParentIfIndex(g *gosnmp.GoSNMP, ifIndex uint) (uint, bool) {
+	prefix := fmt.Sprintf(".1.3.6.1.2.1.31.1.2.1.3.%d.", ifIndex)
+	result, err := g.GetNext([]string{prefix[1 : len(prefix)-1]})
+	if err != nil || len(result.Variables) != 1 {
+		return 0, false
+	}
+	name := result.Variables[0].Name
+	if !strings.HasPrefix(name, ".") {
+		name = "." + name
+	}
+	lower, ok := strings.CutPrefix(name, prefix)
+	if !ok {
+		return 0, false
+	}
+	parent, err := strconv.ParseUint(lower, 10, 32)
+	if err != nil || parent == 0 {
+		return 0, false
+	}
+	return uint(parent), true
+}
+
+// newSNMP instantiates an SNMP state to poll the provided exporter. It also
+// returns the list of communities to try (only one empty community for SNMPv3).
+func (p *Provider) newSNMP(ctx context.Context, exporter, agent netip.Addr, port uint16) (*gosnmp.GoSNMP, []string) {
+	exporterStr := exporter.Unmap().String()
+	g := &gosnmp.GoSNMP{
+		Context:                 ctx,
+		Target:                  agent.Unmap().String(),
+		Port:                    port,
+		Retries:                 p.config.PollerRetries.LookupOrDefault(exporter, 1),
+		Timeout:                 p.config.PollerTimeout.LookupOrDefault(exporter, time.Second),
+		UseUnconnectedUDPSocket: true,
+		Logger:                  gosnmp.NewLogger(&goSNMPLogger{p.r}),
+		OnRetry: func(*gosnmp.GoSNMP) {
+			p.metrics.retries.WithLabelValues(exporterStr).Inc()
+		},
+	}
+	communities := []string{""}
+	if securityParameters, ok := p.config.SecurityParameters.Lookup(exporter); ok {
+		g.Version = gosnmp.Version3
+		g.SecurityModel = gosnmp.UserSecurityModel
+		usmSecurityParameters := gosnmp.UsmSecurityParameters{
+			UserName:                 securityParameters.UserName,
+			AuthenticationProtocol:   gosnmp.SnmpV3AuthProtocol(securityParameters.AuthenticationProtocol),
+			AuthenticationPassphrase: securityParameters.AuthenticationPassphrase,
+			PrivacyProtocol:          gosnmp.SnmpV3PrivProtocol(securityParameters.PrivacyProtocol),
+			PrivacyPassphrase:        securityParameters.PrivacyPassphrase,
+		}
+		g.SecurityParameters = &usmSecurityParameters
+		if usmSecurityParameters.AuthenticationProtocol == gosnmp.NoAuth {
+			if usmSecurityParameters.PrivacyProtocol == gosnmp.NoPriv {
+				g.MsgFlags = gosnmp.NoAuthNoPriv
+			} else {
+				// Not possible
+				g.MsgFlags = gosnmp.NoAuthNoPriv
+			}
+		} else {
+			if usmSecurityParameters.PrivacyProtocol == gosnmp.NoPriv {
+				g.MsgFlags = gosnmp.AuthNoPriv
+			} else {
+				g.MsgFlags = gosnmp.AuthPriv
+			}
+		}
+		g.ContextName = securityParameters.ContextName
+	} else {
+		g.Version = gosnmp.Version2c
+		communities = p.config.Communities.LookupOrDefault(exporter, []string{"public"})
+	}
+	return g, communities
+}
+
 type goSNMPLogger struct {
 	r *reporter.Reporter
 }
@@ -247,3 +330,19 @@ func (l *goSNMPLogger) Printf(format string, v ...interface{}) {
 		e.Msg(fmt.Sprintf(format, v...))
 	}
 }
+
+// errorKind classifies an error returned by GoSNMP to tell apart
+// authentication errors and timeouts from other errors.
+func errorKind(err error) string {
+	switch {
+	case errors.Is(err, gosnmp.ErrUnknownUsername),
+		errors.Is(err, gosnmp.ErrWrongDigest),
+		errors.Is(err, gosnmp.ErrDecryption),
+		errors.Is(err, gosnmp.ErrUnknownSecurityLevel):
+		return "authentication"
+	case errors.Is(err, context.DeadlineExceeded),
+		strings.Contains(err.Error(), "timeout"):
+		return "timeout"
+	}
+	return "get"
+}
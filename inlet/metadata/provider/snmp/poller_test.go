@@ -31,8 +31,8 @@ func TestPoller(t *testing.T) {
 		{
 			Description: "SNMPv2",
 			Config: Configuration{
-				PollerRetries: 2,
-				PollerTimeout: 100 * time.Millisecond,
+				PollerRetries: helpers.MustNewSubnetMap(map[string]int{"::/0": 2}),
+				PollerTimeout: helpers.MustNewSubnetMap(map[string]time.Duration{"::/0": 100 * time.Millisecond}),
 				Communities: helpers.MustNewSubnetMap(map[string][]string{
 					"::/0": {"private"},
 				}),
@@ -43,8 +43,8 @@ func TestPoller(t *testing.T) {
 		}, {
 			Description: "SNMPv2 with several communities, first",
 			Config: Configuration{
-				PollerRetries: 2,
-				PollerTimeout: 100 * time.Millisecond,
+				PollerRetries: helpers.MustNewSubnetMap(map[string]int{"::/0": 2}),
+				PollerTimeout: helpers.MustNewSubnetMap(map[string]time.Duration{"::/0": 100 * time.Millisecond}),
 				Communities: helpers.MustNewSubnetMap(map[string][]string{
 					"::/0": {"private", "private1"},
 				}),
@@ -55,8 +55,8 @@ func TestPoller(t *testing.T) {
 		}, {
 			Description: "SNMPv2 with several communities, last",
 			Config: Configuration{
-				PollerRetries: 2,
-				PollerTimeout: 100 * time.Millisecond,
+				PollerRetries: helpers.MustNewSubnetMap(map[string]int{"::/0": 2}),
+				PollerTimeout: helpers.MustNewSubnetMap(map[string]time.Duration{"::/0": 100 * time.Millisecond}),
 				Communities: helpers.MustNewSubnetMap(map[string][]string{
 					"::/0": {"private1", "private"},
 				}),
@@ -67,8 +67,8 @@ func TestPoller(t *testing.T) {
 		}, {
 			Description: "SNMPv2 with agent mapping",
 			Config: Configuration{
-				PollerRetries: 2,
-				PollerTimeout: 100 * time.Millisecond,
+				PollerRetries: helpers.MustNewSubnetMap(map[string]int{"::/0": 2}),
+				PollerTimeout: helpers.MustNewSubnetMap(map[string]time.Duration{"::/0": 100 * time.Millisecond}),
 				Communities: helpers.MustNewSubnetMap(map[string][]string{
 					"::/0": {"private"},
 				}),
@@ -80,8 +80,8 @@ func TestPoller(t *testing.T) {
 		}, {
 			Description: "SNMPv3",
 			Config: Configuration{
-				PollerRetries: 2,
-				PollerTimeout: 100 * time.Millisecond,
+				PollerRetries: helpers.MustNewSubnetMap(map[string]int{"::/0": 2}),
+				PollerTimeout: helpers.MustNewSubnetMap(map[string]time.Duration{"::/0": 100 * time.Millisecond}),
 				Communities: helpers.MustNewSubnetMap(map[string][]string{
 					"::/0": {"public"},
 				}),
@@ -100,8 +100,8 @@ func TestPoller(t *testing.T) {
 			Description: "SNMPv3 no priv",
 			Skip:        "GoSNMPServer is broken with this configuration",
 			Config: Configuration{
-				PollerRetries: 2,
-				PollerTimeout: 100 * time.Millisecond,
+				PollerRetries: helpers.MustNewSubnetMap(map[string]int{"::/0": 2}),
+				PollerTimeout: helpers.MustNewSubnetMap(map[string]time.Duration{"::/0": 100 * time.Millisecond}),
 				Communities: helpers.MustNewSubnetMap(map[string][]string{
 					"::/0": {"public"},
 				}),
@@ -272,3 +272,161 @@ func TestPoller(t *testing.T) {
 		})
 	}
 }
+
+func TestErrorKind(t *testing.T) {
+	cases := []struct {
+		Error    error
+		Expected string
+	}{
+		{fmt.Errorf("request timeout (after %d retries)", 1), "timeout"},
+		{context.DeadlineExceeded, "timeout"},
+		{gosnmp.ErrUnknownUsername, "authentication"},
+		{fmt.Errorf("cannot get: %w", gosnmp.ErrWrongDigest), "authentication"},
+		{gosnmp.ErrDecryption, "authentication"},
+		{fmt.Errorf("SNMP error %s(%d)", gosnmp.GenErr, gosnmp.GenErr), "get"},
+	}
+	for _, tc := range cases {
+		if got := errorKind(tc.Error); got != tc.Expected {
+			t.Errorf("errorKind(%q) = %q, expected %q", tc.Error, got, tc.Expected)
+		}
+	}
+}
+
+func TestPollerSpeed(t *testing.T) {
+	lo := netip.MustParseAddr("::ffff:127.0.0.1")
+	gauge := func(oid string, value uint) *GoSNMPServer.PDUValueControlItem {
+		return &GoSNMPServer.PDUValueControlItem{
+			OID:   oid,
+			Type:  gosnmp.Gauge32,
+			OnGet: func() (interface{}, error) { return value, nil },
+		}
+	}
+	str := func(oid string, value string) *GoSNMPServer.PDUValueControlItem {
+		return &GoSNMPServer.PDUValueControlItem{
+			OID:   oid,
+			Type:  gosnmp.OctetString,
+			OnGet: func() (interface{}, error) { return value, nil },
+		}
+	}
+	master := GoSNMPServer.MasterAgent{
+		SecurityConfig: GoSNMPServer.SecurityConfig{
+			AuthoritativeEngineBoots: 10,
+		},
+		SubAgents: []*GoSNMPServer.SubAgent{
+			{
+				CommunityIDs: []string{"public"},
+				OIDs: []*GoSNMPServer.PDUValueControlItem{
+					str("1.3.6.1.2.1.1.5.0", "exporter62"),
+					str("1.3.6.1.2.1.2.2.1.2.1", "et-0/0/1"),
+					str("1.3.6.1.2.1.2.2.1.2.2", "ge-0/0/2"),
+					str("1.3.6.1.2.1.2.2.1.2.3", "et-0/0/1.100"),
+					gauge("1.3.6.1.2.1.2.2.1.5.1", 4294967295),
+					gauge("1.3.6.1.2.1.2.2.1.5.2", 1000000000),
+					gauge("1.3.6.1.2.1.2.2.1.5.3", 0),
+					gauge("1.3.6.1.2.1.31.1.1.1.15.1", 100000),
+					// ifHighSpeed.2 missing
+					gauge("1.3.6.1.2.1.31.1.1.1.15.3", 0),
+					str("1.3.6.1.2.1.31.1.1.1.18.1", "Transit"),
+					str("1.3.6.1.2.1.31.1.1.1.18.2", "Old"),
+					str("1.3.6.1.2.1.31.1.1.1.18.3", "Customer"),
+					{
+						OID:   "1.3.6.1.2.1.31.1.2.1.3.3.1",
+						Type:  gosnmp.Integer,
+						OnGet: func() (interface{}, error) { return 1, nil },
+					},
+				},
+			},
+		},
+	}
+	server := GoSNMPServer.NewSNMPServer(master)
+	if err := server.ListenUDP("udp", "127.0.0.1:0"); err != nil {
+		t.Fatalf("ListenUDP() err:\n%+v", err)
+	}
+	_, portStr, err := net.SplitHostPort(server.Address().String())
+	if err != nil {
+		panic(err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		panic(err)
+	}
+	go server.ServeForever()
+	defer server.Shutdown()
+
+	for _, inherit := range []bool{false, true} {
+		t.Run(fmt.Sprintf("inherit=%v", inherit), func(t *testing.T) {
+			r := reporter.NewMock(t)
+			config := DefaultConfiguration().(Configuration)
+			config.PollerTimeout = helpers.MustNewSubnetMap(map[string]time.Duration{"::/0": 100 * time.Millisecond})
+			config.Ports = helpers.MustNewSubnetMap(map[string]uint16{
+				"::/0": uint16(port),
+			})
+			config.InheritParentSpeed = inherit
+			got := []string{}
+			put := func(update provider.Update) {
+				got = append(got, fmt.Sprintf("%d %s %d",
+					update.IfIndex, update.Interface.Name, update.Interface.Speed))
+			}
+			p, err := config.New(r, put)
+			if err != nil {
+				t.Fatalf("New() error:\n%+v", err)
+			}
+			p.Query(context.Background(), provider.BatchQuery{ExporterIP: lo, IfIndexes: []uint{1, 2, 3}})
+			time.Sleep(50 * time.Millisecond)
+			subInterfaceSpeed := 0
+			if inherit {
+				subInterfaceSpeed = 100000
+			}
+			expected := []string{
+				"1 et-0/0/1 100000",
+				"2 ge-0/0/2 1000",
+				fmt.Sprintf("3 et-0/0/1.100 %d", subInterfaceSpeed),
+			}
+			if diff := helpers.Diff(got, expected); diff != "" {
+				t.Fatalf("Poll() (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAcquireInFlight(t *testing.T) {
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration().(Configuration)
+	config.PollerMaxInFlight = helpers.MustNewSubnetMap(map[string]int{
+		"::ffff:192.0.2.0/120": 1,
+	})
+	p, err := config.New(r, func(provider.Update) {})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	sp := p.(*Provider)
+	limited := netip.MustParseAddr("::ffff:192.0.2.1")
+	unlimited := netip.MustParseAddr("::ffff:198.51.100.1")
+
+	release, ok := sp.acquireInFlight(context.Background(), limited)
+	if !ok {
+		t.Fatal("acquireInFlight() should succeed")
+	}
+	for range 3 {
+		if _, ok := sp.acquireInFlight(context.Background(), unlimited); !ok {
+			t.Fatal("acquireInFlight() should succeed for an exporter without limit")
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, ok := sp.acquireInFlight(ctx, limited); ok {
+		t.Fatal("acquireInFlight() should wait for the first request to complete")
+	}
+	release()
+	if _, ok := sp.acquireInFlight(context.Background(), limited); !ok {
+		t.Fatal("acquireInFlight() should succeed once released")
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_metadata_provider_snmp_", "poller_in_flight_waits_total")
+	expectedMetrics := map[string]string{
+		`poller_in_flight_waits_total{exporter="192.0.2.1"}`: "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
@@ -8,6 +8,7 @@ package snmp
 import (
 	"context"
 	"net/netip"
+	"slices"
 	"sync"
 	"time"
 
@@ -22,6 +23,10 @@ type Provider struct {
 
 	pendingRequests     map[string]struct{}
 	pendingRequestsLock sync.Mutex
+	walked              map[netip.Addr]time.Time
+	walkedLock          sync.Mutex
+	inFlight            map[netip.Addr]chan struct{}
+	inFlightLock        sync.Mutex
 	errLogger           reporter.Logger
 
 	put func(provider.Update)
@@ -32,6 +37,9 @@ type Provider struct {
 		errors          *reporter.CounterVec
 		retries         *reporter.CounterVec
 		times           *reporter.SummaryVec
+		walkTimes       *reporter.SummaryVec
+		walkRows        *reporter.GaugeVec
+		inFlightWaits   *reporter.CounterVec
 	}
 }
 
@@ -51,6 +59,8 @@ func (configuration Configuration) New(r *reporter.Reporter, put func(provider.U
 		config: &configuration,
 
 		pendingRequests: make(map[string]struct{}),
+		walked:          make(map[netip.Addr]time.Time),
+		inFlight:        make(map[netip.Addr]chan struct{}),
 		errLogger:       r.Sample(reporter.BurstSampler(10*time.Second, 3)),
 
 		put: put,
@@ -86,6 +96,22 @@ func (configuration Configuration) New(r *reporter.Reporter, put func(provider.U
 			Help:       "Time to successfully poll for values.",
 			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
 		}, []string{"exporter"})
+	p.metrics.walkTimes = r.SummaryVec(
+		reporter.SummaryOpts{
+			Name:       "walker_seconds",
+			Help:       "Time to successfully walk the interfaces of an exporter.",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		}, []string{"exporter"})
+	p.metrics.walkRows = r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "walker_interfaces",
+			Help: "Number of interfaces found during the last walk of an exporter.",
+		}, []string{"exporter"})
+	p.metrics.inFlightWaits = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "poller_in_flight_waits_total",
+			Help: "Number of requests waiting for another request to the same exporter to complete.",
+		}, []string{"exporter"})
 
 	return &p, nil
 }
@@ -98,5 +124,54 @@ func (p *Provider) Query(ctx context.Context, query provider.BatchQuery) error {
 		agentIP = query.ExporterIP
 	}
 	agentPort := p.config.Ports.LookupOrDefault(query.ExporterIP, 161)
+	release, ok := p.acquireInFlight(ctx, query.ExporterIP)
+	if !ok {
+		// Shutting down
+		return nil
+	}
+	defer release()
+	// On first contact, walk all interfaces at once. Interfaces not found
+	// during the walk are polled individually.
+	if p.config.Prefetch && p.shouldWalk(query.ExporterIP) {
+		found, err := p.Walk(ctx, query.ExporterIP, agentIP, agentPort, p.put)
+		if err == nil {
+			query.IfIndexes = slices.DeleteFunc(slices.Clone(query.IfIndexes), func(ifIndex uint) bool {
+				_, ok := slices.BinarySearch(found, ifIndex)
+				return ok
+			})
+			if len(query.IfIndexes) == 0 {
+				return nil
+			}
+		}
+	}
 	return p.Poll(ctx, query.ExporterIP, agentIP, agentPort, query.IfIndexes, p.put)
 }
+
+// acquireInFlight waits until a new request can be sent to the provided
+// exporter. It returns a function to call once the request is complete, unless
+// the context is canceled while waiting.
+func (p *Provider) acquireInFlight(ctx context.Context, exporter netip.Addr) (func(), bool) {
+	limit := p.config.PollerMaxInFlight.LookupOrDefault(exporter, 0)
+	if limit <= 0 {
+		return func() {}, true
+	}
+	p.inFlightLock.Lock()
+	slots, ok := p.inFlight[exporter]
+	if !ok {
+		slots = make(chan struct{}, limit)
+		p.inFlight[exporter] = slots
+	}
+	p.inFlightLock.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+	default:
+		p.metrics.inFlightWaits.WithLabelValues(exporter.Unmap().String()).Inc()
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+	return func() { <-slots }, true
+}
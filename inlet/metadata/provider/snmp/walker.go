@@ -0,0 +1,147 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package snmp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+
+	"akvorado/inlet/metadata/provider"
+)
+
+// Walk walks the interface tables of an exporter using GETBULK requests and
+// provides an update for each interface found. It returns the interface
+// indexes found.
+func (p *Provider) Walk(ctx context.Context, exporter, agent netip.Addr, port uint16, put func(provider.Update)) ([]uint, error) {
+	exporterStr := exporter.Unmap().String()
+	g, communities := p.newSNMP(ctx, exporter, agent, port)
+	start := time.Now()
+	if err := g.Connect(); err != nil {
+		p.metrics.errors.WithLabelValues(exporterStr, "connect").Inc()
+		p.errLogger.Err(err).Str("exporter", exporterStr).Msg("unable to connect")
+	}
+
+	var lastErr error
+	for _, community := range communities {
+		g.Community = community
+		info, interfaces, err := walkInterfaces(g)
+		if errors.Is(err, context.Canceled) {
+			return nil, err
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		ifIndexes := make([]uint, 0, len(interfaces))
+		for ifIndex := range interfaces {
+			ifIndexes = append(ifIndexes, ifIndex)
+		}
+		slices.Sort(ifIndexes)
+		for _, ifIndex := range ifIndexes {
+			put(provider.Update{
+				Query: provider.Query{
+					ExporterIP: exporter,
+					IfIndex:    ifIndex,
+				},
+				Answer: provider.Answer{
+					Exporter:  info,
+					Interface: *interfaces[ifIndex],
+				},
+			})
+		}
+		p.metrics.walkTimes.WithLabelValues(exporterStr).Observe(time.Now().Sub(start).Seconds())
+		p.metrics.walkRows.WithLabelValues(exporterStr).Set(float64(len(ifIndexes)))
+		return ifIndexes, nil
+	}
+	p.metrics.errors.WithLabelValues(exporterStr, "walk").Inc()
+	p.errLogger.Err(lastErr).Str("exporter", exporterStr).Msg("unable to walk interfaces")
+	return nil, lastErr
+}
+
+// walkInterfaces walks the interface tables with the current community. It
+// returns the exporter and the interfaces indexed by their interface index.
+func walkInterfaces(g *gosnmp.GoSNMP) (provider.Exporter, map[uint]*provider.Interface, error) {
+	var exporter provider.Exporter
+	result, err := g.Get([]string{
+		"1.3.6.1.2.1.1.5.0", // sysName
+		"1.3.6.1.2.1.1.6.0", // sysLocation
+	})
+	if err != nil {
+		return exporter, nil, err
+	}
+	if len(result.Variables) != 2 || result.Variables[0].Type != gosnmp.OctetString {
+		return exporter, nil, errors.New("unable to get sysName")
+	}
+	exporter.Name = string(result.Variables[0].Value.([]byte))
+	if result.Variables[1].Type == gosnmp.OctetString {
+		exporter.Location = string(result.Variables[1].Value.([]byte))
+	}
+
+	interfaces := map[uint]*provider.Interface{}
+	columns := []string{
+		"1.3.6.1.2.1.2.2.1.2",     // ifDescr
+		"1.3.6.1.2.1.31.1.1.1.18", // ifAlias
+		"1.3.6.1.2.1.2.2.1.5",     // ifSpeed
+		"1.3.6.1.2.1.31.1.1.1.15", // ifHighSpeed (walked last to win over ifSpeed)
+	}
+	for column, oid := range columns {
+		prefix := fmt.Sprintf("%s.", oid)
+		err := g.BulkWalk(oid, func(pdu gosnmp.SnmpPDU) error {
+			index, ok := strings.CutPrefix(strings.TrimPrefix(pdu.Name, "."), prefix)
+			if !ok {
+				return nil
+			}
+			ifIndex, err := strconv.ParseUint(index, 10, 32)
+			if err != nil {
+				return nil
+			}
+			iface, ok := interfaces[uint(ifIndex)]
+			if !ok {
+				iface = &provider.Interface{}
+				interfaces[uint(ifIndex)] = iface
+			}
+			str, isStr := pdu.Value.([]byte)
+			isStr = isStr && pdu.Type == gosnmp.OctetString
+			speed, isSpeed := pdu.Value.(uint)
+			isSpeed = isSpeed && pdu.Type == gosnmp.Gauge32
+			switch {
+			case column == 0 && isStr:
+				iface.Name = string(str)
+			case column == 1 && isStr:
+				iface.Description = string(str)
+			case column == 2 && isSpeed:
+				iface.Speed = uint32(speed / 1_000_000)
+			case column == 3 && isSpeed && speed > 0:
+				iface.Speed = uint32(speed)
+			}
+			return nil
+		})
+		if err != nil {
+			return exporter, nil, err
+		}
+	}
+	return exporter, interfaces, nil
+}
+
+// shouldWalk tells if the interfaces of the provided exporter should be
+// walked. It records the walk as done.
+func (p *Provider) shouldWalk(exporter netip.Addr) bool {
+	p.walkedLock.Lock()
+	defer p.walkedLock.Unlock()
+	last, ok := p.walked[exporter]
+	if ok && (p.config.PrefetchInterval == 0 || time.Since(last) < p.config.PrefetchInterval) {
+		return false
+	}
+	p.walked[exporter] = time.Now()
+	return true
+}
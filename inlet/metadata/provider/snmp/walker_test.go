@@ -0,0 +1,119 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package snmp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/slayercat/GoSNMPServer"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/inlet/metadata/provider"
+)
+
+func TestPrefetch(t *testing.T) {
+	lo := netip.MustParseAddr("::ffff:127.0.0.1")
+	item := func(oid string, kind gosnmp.Asn1BER, value interface{}) *GoSNMPServer.PDUValueControlItem {
+		return &GoSNMPServer.PDUValueControlItem{
+			OID:  oid,
+			Type: kind,
+			OnGet: func() (interface{}, error) {
+				return value, nil
+			},
+		}
+	}
+	master := GoSNMPServer.MasterAgent{
+		SecurityConfig: GoSNMPServer.SecurityConfig{
+			AuthoritativeEngineBoots: 10,
+		},
+		SubAgents: []*GoSNMPServer.SubAgent{
+			{
+				CommunityIDs: []string{"public"},
+				OIDs: []*GoSNMPServer.PDUValueControlItem{
+					item("1.3.6.1.2.1.1.5.0", gosnmp.OctetString, "exporter62"),
+					item("1.3.6.1.2.1.1.6.0", gosnmp.OctetString, "Paris"),
+					item("1.3.6.1.2.1.2.2.1.2.1", gosnmp.OctetString, "et-0/0/1"),
+					item("1.3.6.1.2.1.2.2.1.2.2", gosnmp.OctetString, "ge-0/0/2"),
+					item("1.3.6.1.2.1.2.2.1.2.3", gosnmp.OctetString, "et-0/0/3"),
+					item("1.3.6.1.2.1.2.2.1.5.1", gosnmp.Gauge32, uint(4294967295)),
+					item("1.3.6.1.2.1.2.2.1.5.2", gosnmp.Gauge32, uint(1000000000)),
+					item("1.3.6.1.2.1.2.2.1.5.3", gosnmp.Gauge32, uint(4294967295)),
+					item("1.3.6.1.2.1.31.1.1.1.15.1", gosnmp.Gauge32, uint(100000)),
+					item("1.3.6.1.2.1.31.1.1.1.15.3", gosnmp.Gauge32, uint(400000)),
+					item("1.3.6.1.2.1.31.1.1.1.18.1", gosnmp.OctetString, "Transit"),
+					item("1.3.6.1.2.1.31.1.1.1.18.2", gosnmp.OctetString, "Old"),
+					item("1.3.6.1.2.1.31.1.1.1.18.3", gosnmp.OctetString, "Core"),
+				},
+			},
+		},
+	}
+	server := GoSNMPServer.NewSNMPServer(master)
+	if err := server.ListenUDP("udp", "127.0.0.1:0"); err != nil {
+		t.Fatalf("ListenUDP() err:\n%+v", err)
+	}
+	_, portStr, err := net.SplitHostPort(server.Address().String())
+	if err != nil {
+		panic(err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		panic(err)
+	}
+	go server.ServeForever()
+	defer server.Shutdown()
+
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration().(Configuration)
+	config.PollerTimeout = helpers.MustNewSubnetMap(map[string]time.Duration{"::/0": 100 * time.Millisecond})
+	config.Ports = helpers.MustNewSubnetMap(map[string]uint16{
+		"::/0": uint16(port),
+	})
+	config.Prefetch = true
+	got := []string{}
+	put := func(update provider.Update) {
+		got = append(got, fmt.Sprintf("%s %s %d %s %s %d",
+			update.Exporter.Name, update.Exporter.Location, update.IfIndex,
+			update.Interface.Name, update.Interface.Description, update.Interface.Speed))
+	}
+	p, err := config.New(r, put)
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+
+	// First query walks all interfaces
+	p.Query(context.Background(), provider.BatchQuery{ExporterIP: lo, IfIndexes: []uint{2}})
+	if diff := helpers.Diff(got, []string{
+		"exporter62 Paris 1 et-0/0/1 Transit 100000",
+		"exporter62 Paris 2 ge-0/0/2 Old 1000",
+		"exporter62 Paris 3 et-0/0/3 Core 400000",
+	}); diff != "" {
+		t.Fatalf("Query() (-got, +want):\n%s", diff)
+	}
+
+	// Next queries are polled individually
+	got = []string{}
+	p.Query(context.Background(), provider.BatchQuery{ExporterIP: lo, IfIndexes: []uint{3}})
+	if diff := helpers.Diff(got, []string{
+		"exporter62 Paris 3 et-0/0/3 Core 400000",
+	}); diff != "" {
+		t.Fatalf("Query() (-got, +want):\n%s", diff)
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_metadata_provider_snmp_", "walker_interfaces", "walker_seconds_count")
+	expectedMetrics := map[string]string{
+		`walker_interfaces{exporter="127.0.0.1"}`:    "3",
+		`walker_seconds_count{exporter="127.0.0.1"}`: "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
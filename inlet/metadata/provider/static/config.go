@@ -19,6 +19,10 @@ type Configuration struct {
 	// definitions to map IP address to their configuration.
 	// The results are overridden by the content of Exporters.
 	ExporterSources map[string]remotedatasourcefetcher.RemoteDataSource `validate:"dive"`
+	// ExporterFiles is a list of CSV or YAML files defining exporters. They
+	// are watched and reloaded on change. The results are overridden by the
+	// content of Exporters.
+	ExporterFiles []string
 	// ExporterSourcesTimeout tells how long to wait for exporter
 	// sources to be ready. 503 is returned when not.
 	ExporterSourcesTimeout time.Duration `validate:"min=0"`
@@ -0,0 +1,274 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package static
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/mitchellh/mapstructure"
+	"gopkg.in/yaml.v3"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/inlet/metadata/provider"
+)
+
+// parseExporterFile parses a file containing exporters. The format is
+// selected from the extension. Invalid entries are returned as a list of
+// errors and skipped. An error is returned only when the whole file cannot be
+// parsed.
+func parseExporterFile(path string) ([]exporterInfo, []error, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return parseExporterCSV(f)
+	case ".yaml", ".yml":
+		return parseExporterYAML(f)
+	default:
+		return nil, nil, fmt.Errorf("unknown format for %q", path)
+	}
+}
+
+// parseExporterCSV parses exporters from a CSV file. The first line is a
+// header naming the columns. The "exporter" and "ifindex" columns are
+// mandatory. Other accepted columns are "exporter-name", "name",
+// "description", and "speed". An empty "ifindex" (or "default") defines the
+// default interface of an exporter.
+func parseExporterCSV(r io.Reader) ([]exporterInfo, []error, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read CSV header: %w", err)
+	}
+	columns := map[string]int{}
+	for idx, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = idx
+	}
+	for _, column := range []string{"exporter", "ifindex"} {
+		if _, ok := columns[column]; !ok {
+			return nil, nil, fmt.Errorf("missing %q column in CSV header", column)
+		}
+	}
+	field := func(record []string, name string) string {
+		idx, ok := columns[name]
+		if !ok || idx >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[idx])
+	}
+
+	exporters := []exporterInfo{}
+	exporterIndexes := map[string]int{}
+	rowErrors := []error{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				rowErrors = append(rowErrors, fmt.Errorf("line %d: %w", parseErr.Line, parseErr.Err))
+				continue
+			}
+			return nil, nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		exporter := field(record, "exporter")
+		subnet, err := helpers.SubnetMapParseKey(exporter)
+		if err != nil {
+			rowErrors = append(rowErrors, fmt.Errorf("line %d: invalid exporter %q: %w", line, exporter, err))
+			continue
+		}
+		iface := provider.Interface{
+			Name:        field(record, "name"),
+			Description: field(record, "description"),
+		}
+		if speed := field(record, "speed"); speed != "" {
+			value, err := strconv.ParseUint(speed, 10, 32)
+			if err != nil {
+				rowErrors = append(rowErrors, fmt.Errorf("line %d: invalid speed %q: %w", line, speed, err))
+				continue
+			}
+			iface.Speed = uint32(value)
+		}
+		if err := helpers.Validate.Struct(iface); err != nil {
+			rowErrors = append(rowErrors, fmt.Errorf("line %d: invalid interface: %w", line, err))
+			continue
+		}
+		isDefault := false
+		var ifIndex uint64
+		switch value := field(record, "ifindex"); value {
+		case "", "default":
+			isDefault = true
+		default:
+			ifIndex, err = strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				rowErrors = append(rowErrors, fmt.Errorf("line %d: invalid ifindex %q: %w", line, value, err))
+				continue
+			}
+		}
+
+		idx, ok := exporterIndexes[subnet]
+		if !ok {
+			idx = len(exporters)
+			exporterIndexes[subnet] = idx
+			exporters = append(exporters, exporterInfo{
+				Exporter:       provider.Exporter{Name: exporter},
+				ExporterSubnet: subnet,
+				Interfaces:     []exporterInterface{},
+			})
+		}
+		if name := field(record, "exporter-name"); name != "" {
+			exporters[idx].Name = name
+		}
+		if isDefault {
+			exporters[idx].Default = iface
+		} else {
+			exporters[idx].Interfaces = append(exporters[idx].Interfaces, exporterInterface{
+				IfIndex:   uint(ifIndex),
+				Interface: iface,
+			})
+		}
+	}
+	return exporters, rowErrors, nil
+}
+
+// parseExporterYAML parses exporters from a YAML file. The file is a map from
+// exporter subnets to exporter configurations, like the "exporters" key.
+func parseExporterYAML(r io.Reader) ([]exporterInfo, []error, error) {
+	var document yaml.Node
+	if err := yaml.NewDecoder(r).Decode(&document); err == io.EOF {
+		return []exporterInfo{}, nil, nil
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("cannot parse YAML: %w", err)
+	}
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("line %d: expected a map from exporter subnets to exporters", root.Line)
+	}
+
+	exporters := []exporterInfo{}
+	entryErrors := []error{}
+	for idx := 0; idx+1 < len(root.Content); idx += 2 {
+		key, value := root.Content[idx], root.Content[idx+1]
+		subnet, err := helpers.SubnetMapParseKey(key.Value)
+		if err != nil {
+			entryErrors = append(entryErrors, fmt.Errorf("line %d: invalid exporter %q: %w", key.Line, key.Value, err))
+			continue
+		}
+		var raw interface{}
+		if err := value.Decode(&raw); err != nil {
+			entryErrors = append(entryErrors, fmt.Errorf("line %d: %w", value.Line, err))
+			continue
+		}
+		var config ExporterConfiguration
+		decoder, err := mapstructure.NewDecoder(helpers.GetMapStructureDecoderConfig(&config))
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := decoder.Decode(raw); err != nil {
+			entryErrors = append(entryErrors, fmt.Errorf("line %d: %w", value.Line, err))
+			continue
+		}
+		if err := helpers.Validate.Struct(config); err != nil {
+			entryErrors = append(entryErrors, fmt.Errorf("line %d: %w", value.Line, err))
+			continue
+		}
+		interfaces := make([]exporterInterface, 0, len(config.IfIndexes))
+		for ifIndex, iface := range config.IfIndexes {
+			interfaces = append(interfaces, exporterInterface{
+				IfIndex:   ifIndex,
+				Interface: iface,
+			})
+		}
+		exporters = append(exporters, exporterInfo{
+			Exporter:       config.Exporter,
+			ExporterSubnet: subnet,
+			Default:        config.Default,
+			Interfaces:     interfaces,
+		})
+	}
+	return exporters, entryErrors, nil
+}
+
+// loadExporterFile loads the provided exporter file and updates the exporters
+// if successful. Invalid entries are logged and skipped.
+func (p *Provider) loadExporterFile(path string) error {
+	exporters, entryErrors, err := parseExporterFile(path)
+	if err != nil {
+		p.r.Err(err).Str("file", path).Msg("cannot load exporter file")
+		return fmt.Errorf("cannot load exporter file %q: %w", path, err)
+	}
+	for _, err := range entryErrors {
+		p.r.Warn().Err(err).Str("file", path).Msg("skipping invalid entry in exporter file")
+	}
+	p.r.Info().Str("file", path).Int("exporters", len(exporters)).Msg("exporter file loaded")
+	p.exportersLock.Lock()
+	p.exportersMap[fmt.Sprintf("file:%s", path)] = exporters
+	p.exportersLock.Unlock()
+	return p.updateExporters()
+}
+
+// watchExporterFiles watches the exporter files and reloads them on change.
+func (p *Provider) watchExporterFiles(paths []string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("cannot setup watcher: %w", err)
+	}
+	dirs := map[string]struct{}{}
+	for _, path := range paths {
+		dirs[filepath.Dir(path)] = struct{}{}
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return fmt.Errorf("cannot watch exporter file directory: %w", err)
+		}
+	}
+	go func() {
+		errLogger := p.r.Sample(reporter.BurstSampler(10*time.Second, 1))
+		defer watcher.Close()
+		for {
+			select {
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				errLogger.Err(err).Msg("error from watcher")
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) {
+					continue
+				}
+				for _, path := range paths {
+					if filepath.Clean(event.Name) == filepath.Clean(path) {
+						p.loadExporterFile(path)
+						break
+					}
+				}
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,281 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package static
+
+import (
+	"cmp"
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/inlet/metadata/provider"
+)
+
+func TestParseExporterCSV(t *testing.T) {
+	input := `exporter,exporter-name,ifindex,name,description,speed
+# Lab exporter
+2001:db8:1::1,lab1,10,Gi0/0/10,PNI Netflix,1000
+2001:db8:1::1,,11,Gi0/0/11,PNI Google,1000
+2001:db8:1::1,,default,unknown,Unknown interface,100
+2001:db8:1::/48,,12,Gi0/0/12,Transit,10000
+not-an-ip,,10,Gi0/0/10,PNI,1000
+2001:db8:1::1,,eth0,Gi0/0/10,PNI,1000
+2001:db8:1::1,,13,Gi0/0/13,PNI,fast
+2001:db8:1::1,,14,,PNI,1000
+`
+	got, gotErrors, err := parseExporterCSV(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parseExporterCSV() error:\n%+v", err)
+	}
+	expected := []exporterInfo{
+		{
+			Exporter:       provider.Exporter{Name: "lab1"},
+			ExporterSubnet: "2001:db8:1::1/128",
+			Default: provider.Interface{
+				Name:        "unknown",
+				Description: "Unknown interface",
+				Speed:       100,
+			},
+			Interfaces: []exporterInterface{
+				{
+					IfIndex: 10,
+					Interface: provider.Interface{
+						Name:        "Gi0/0/10",
+						Description: "PNI Netflix",
+						Speed:       1000,
+					},
+				}, {
+					IfIndex: 11,
+					Interface: provider.Interface{
+						Name:        "Gi0/0/11",
+						Description: "PNI Google",
+						Speed:       1000,
+					},
+				},
+			},
+		}, {
+			Exporter:       provider.Exporter{Name: "2001:db8:1::/48"},
+			ExporterSubnet: "2001:db8:1::/48",
+			Interfaces: []exporterInterface{
+				{
+					IfIndex: 12,
+					Interface: provider.Interface{
+						Name:        "Gi0/0/12",
+						Description: "Transit",
+						Speed:       10000,
+					},
+				},
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Errorf("parseExporterCSV() (-got, +want):\n%s", diff)
+	}
+	gotLines := []string{}
+	for _, err := range gotErrors {
+		gotLines = append(gotLines, strings.SplitN(err.Error(), ":", 2)[0])
+	}
+	if diff := helpers.Diff(gotLines, []string{"line 7", "line 8", "line 9", "line 10"}); diff != "" {
+		t.Errorf("parseExporterCSV() errors (-got, +want):\n%s", diff)
+	}
+
+	if _, _, err := parseExporterCSV(strings.NewReader("name,description\n")); err == nil {
+		t.Error("parseExporterCSV() without exporter column did not error")
+	}
+}
+
+func TestParseExporterYAML(t *testing.T) {
+	input := `
+2001:db8:1::1:
+  name: lab1
+  region: eu
+  default:
+    name: unknown
+    description: Unknown interface
+    speed: 100
+  ifindexes:
+    10:
+      name: Gi0/0/10
+      description: PNI Netflix
+      speed: 1000
+not-an-ip:
+  name: lab2
+2001:db8:1::2:
+  ifindexes:
+    10:
+      name: Gi0/0/10
+      description: PNI Netflix
+      speed: 1000
+2001:db8:1::3:
+  name: lab3
+  unknown: key
+`
+	got, gotErrors, err := parseExporterYAML(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parseExporterYAML() error:\n%+v", err)
+	}
+	expected := []exporterInfo{
+		{
+			Exporter:       provider.Exporter{Name: "lab1", Region: "eu"},
+			ExporterSubnet: "2001:db8:1::1/128",
+			Default: provider.Interface{
+				Name:        "unknown",
+				Description: "Unknown interface",
+				Speed:       100,
+			},
+			Interfaces: []exporterInterface{
+				{
+					IfIndex: 10,
+					Interface: provider.Interface{
+						Name:        "Gi0/0/10",
+						Description: "PNI Netflix",
+						Speed:       1000,
+					},
+				},
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Errorf("parseExporterYAML() (-got, +want):\n%s", diff)
+	}
+	gotLines := []string{}
+	for _, err := range gotErrors {
+		gotLines = append(gotLines, strings.SplitN(err.Error(), ":", 2)[0])
+	}
+	if diff := helpers.Diff(gotLines, []string{"line 14", "line 17", "line 23"}); diff != "" {
+		t.Errorf("parseExporterYAML() errors (-got, +want):\n%s", diff)
+	}
+
+	if _, _, err := parseExporterYAML(strings.NewReader("- 2001:db8:1::1\n")); err == nil {
+		t.Error("parseExporterYAML() with a list did not error")
+	}
+}
+
+func TestExporterFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exporters.csv")
+	write := func(content string) {
+		t.Helper()
+		// Write then rename to update the file atomically
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, []byte(content), 0o644); err != nil {
+			t.Fatalf("WriteFile() error:\n%+v", err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatalf("Rename() error:\n%+v", err)
+		}
+	}
+	write(`exporter,exporter-name,ifindex,name,description,speed
+2001:db8:1::1,lab1,10,Gi0/0/10,PNI Netflix,1000
+`)
+
+	got := []provider.Update{}
+	r := reporter.NewMock(t)
+	config := Configuration{
+		Exporters: helpers.MustNewSubnetMap(map[string]ExporterConfiguration{
+			"2001:db8:2::1": {
+				Exporter: provider.Exporter{Name: "static"},
+				Default: provider.Interface{
+					Name:        "Default0",
+					Description: "Default interface",
+					Speed:       1000,
+				},
+			},
+		}),
+		ExporterFiles: []string{path},
+	}
+	p, err := config.New(r, func(update provider.Update) { got = append(got, update) })
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	query := func(exporter string) error {
+		return p.Query(context.Background(), provider.BatchQuery{
+			ExporterIP: netip.MustParseAddr(exporter),
+			IfIndexes:  []uint{10},
+		})
+	}
+
+	if err := query("2001:db8:1::1"); err != nil {
+		t.Fatalf("Query() error:\n%+v", err)
+	}
+	if err := query("2001:db8:2::1"); err != nil {
+		t.Fatalf("Query() error:\n%+v", err)
+	}
+	if err := query("2001:db8:1::2"); err != provider.ErrSkipProvider {
+		t.Fatalf("Query() error:\n%+v", err)
+	}
+
+	// Update the file with an invalid row
+	write(`exporter,exporter-name,ifindex,name,description,speed
+2001:db8:1::1,lab1,10,Gi0/0/10,PNI Google,1000
+2001:db8:1::2,lab2,10,Gi0/0/10,PNI Netflix,fast
+2001:db8:1::3,lab3,10,Gi0/0/10,PNI Netflix,1000
+`)
+	time.Sleep(100 * time.Millisecond)
+	if err := query("2001:db8:1::1"); err != nil {
+		t.Fatalf("Query() error:\n%+v", err)
+	}
+	if err := query("2001:db8:1::2"); err != provider.ErrSkipProvider {
+		t.Fatalf("Query() error:\n%+v", err)
+	}
+	if err := query("2001:db8:1::3"); err != nil {
+		t.Fatalf("Query() error:\n%+v", err)
+	}
+
+	slices.SortStableFunc(got, func(a, b provider.Update) int {
+		return cmp.Compare(a.Exporter.Name, b.Exporter.Name)
+	})
+	expected := []provider.Update{
+		{
+			Query: provider.Query{ExporterIP: netip.MustParseAddr("2001:db8:1::1"), IfIndex: 10},
+			Answer: provider.Answer{
+				Exporter: provider.Exporter{Name: "lab1"},
+				Interface: provider.Interface{
+					Name:        "Gi0/0/10",
+					Description: "PNI Netflix",
+					Speed:       1000,
+				},
+			},
+		}, {
+			Query: provider.Query{ExporterIP: netip.MustParseAddr("2001:db8:1::1"), IfIndex: 10},
+			Answer: provider.Answer{
+				Exporter: provider.Exporter{Name: "lab1"},
+				Interface: provider.Interface{
+					Name:        "Gi0/0/10",
+					Description: "PNI Google",
+					Speed:       1000,
+				},
+			},
+		}, {
+			Query: provider.Query{ExporterIP: netip.MustParseAddr("2001:db8:1::3"), IfIndex: 10},
+			Answer: provider.Answer{
+				Exporter: provider.Exporter{Name: "lab3"},
+				Interface: provider.Interface{
+					Name:        "Gi0/0/10",
+					Description: "PNI Netflix",
+					Speed:       1000,
+				},
+			},
+		}, {
+			Query: provider.Query{ExporterIP: netip.MustParseAddr("2001:db8:2::1"), IfIndex: 10},
+			Answer: provider.Answer{
+				Exporter: provider.Exporter{Name: "static"},
+				Interface: provider.Interface{
+					Name:        "Default0",
+					Description: "Default interface",
+					Speed:       1000,
+				},
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("Query() (-got, +want):\n%s", diff)
+	}
+}
@@ -36,6 +36,16 @@ func (configuration Configuration) New(r *reporter.Reporter, put func(provider.U
 	}
 	p.exporters.Store(configuration.Exporters)
 	p.initStaticExporters()
+	for _, path := range configuration.ExporterFiles {
+		if err := p.loadExporterFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if len(configuration.ExporterFiles) > 0 {
+		if err := p.watchExporterFiles(configuration.ExporterFiles); err != nil {
+			return nil, err
+		}
+	}
 	var err error
 	p.exporterSourcesFetcher, err = remotedatasourcefetcher.New[exporterInfo](r, p.UpdateRemoteDataSource, "metadata", configuration.ExporterSources)
 	if err != nil {
@@ -54,9 +54,7 @@ func (p *Provider) initStaticExporters() {
 		staticExporters = append(
 			staticExporters,
 			exporterInfo{
-				Exporter: provider.Exporter{
-					Name: config.Name,
-				},
+				Exporter:       config.Exporter,
 				ExporterSubnet: subnet,
 				Default:        config.Default,
 				Interfaces:     interfaces,
@@ -73,9 +71,21 @@ func (p *Provider) UpdateRemoteDataSource(ctx context.Context, name string, sour
 	if err != nil {
 		return 0, err
 	}
-	finalMap := map[string]ExporterConfiguration{}
 	p.exportersLock.Lock()
 	p.exportersMap[name] = results
+	p.exportersLock.Unlock()
+	if err := p.updateExporters(); err != nil {
+		return 0, err
+	}
+	return len(results), nil
+}
+
+// updateExporters rebuilds the exporters from the static configuration, the
+// exporter files, and the remote data sources.
+func (p *Provider) updateExporters() error {
+	finalMap := map[string]ExporterConfiguration{}
+	p.exportersLock.Lock()
+	defer p.exportersLock.Unlock()
 	for id, results := range p.exportersMap {
 		if id == "static" {
 			continue
@@ -99,11 +109,10 @@ func (p *Provider) UpdateRemoteDataSource(ctx context.Context, name string, sour
 		// This overrides duplicates config for an Exporter if it's also defined as static
 		finalMap[exporterSubnet] = exporterData.toExporterConfiguration()
 	}
-	p.exportersLock.Unlock()
 	exporters, err := helpers.NewSubnetMap[ExporterConfiguration](finalMap)
 	if err != nil {
-		return 0, err
+		return err
 	}
 	p.exporters.Swap(exporters)
-	return len(results), nil
+	return nil
 }
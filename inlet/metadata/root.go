@@ -7,8 +7,11 @@
 package metadata
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math"
+	"net"
 	"net/netip"
 	"strconv"
 	"sync"
@@ -19,6 +22,7 @@ import (
 	"gopkg.in/tomb.v2"
 
 	"akvorado/common/daemon"
+	"akvorado/common/httpserver"
 	"akvorado/common/reporter"
 	"akvorado/inlet/metadata/provider"
 )
@@ -33,13 +37,23 @@ type Component struct {
 	sc *metadataCache
 
 	healthyWorkers         chan reporter.ChannelHealthcheckFunc
-	providerChannel        chan provider.BatchQuery
+	providerChannel        chan providerRequest
 	dispatcherChannel      chan provider.Query
 	dispatcherBChannel     chan (<-chan bool) // block channel for testing
 	providerBreakersLock   sync.Mutex
 	providerBreakerLoggers map[netip.Addr]reporter.Logger
 	providerBreakers       map[netip.Addr]*breaker.Breaker
 	providers              []provider.Provider
+	providerNames          []string
+	providerStateLock      sync.Mutex
+	providerServedBy       map[netip.Addr]string
+	providerNegativeCache  map[providerNegativeKey]providerNegativeEntry
+	reverseDNSLock         sync.Mutex
+	reverseDNSCache        map[netip.Addr]reverseDNSEntry
+	lookupAddr             func(ctx context.Context, addr string) ([]string, error)
+	exporterWorkersLock    sync.Mutex
+	exporterWorkers        map[netip.Addr]int
+	maxExporterWorkers     int
 
 	metrics struct {
 		cacheRefreshRuns         reporter.Counter
@@ -47,13 +61,39 @@ type Component struct {
 		providerBusyCount        *reporter.CounterVec
 		providerBreakerOpenCount *reporter.CounterVec
 		providerBatchedCount     reporter.Counter
+		providerUpdates          *reporter.CounterVec
+		providerErrors           *reporter.CounterVec
+		providerNegativeHits     *reporter.CounterVec
+		reverseDNSErrors         reporter.Counter
+		providerThrottledCount   *reporter.CounterVec
+		providerPending          *reporter.GaugeVec
+		providerSeconds          *reporter.SummaryVec
 	}
 }
 
+// providerRequest is a batched query sent to a worker.
+type providerRequest struct {
+	provider.BatchQuery
+	dispatched time.Time
+}
+
+// providerNegativeKey is the key for the negative cache of providers.
+type providerNegativeKey struct {
+	provider int
+	exporter netip.Addr
+}
+
+// providerNegativeEntry is an entry in the negative cache of providers.
+type providerNegativeEntry struct {
+	until time.Time
+	err   error
+}
+
 // Dependencies define the dependencies of the metadata component.
 type Dependencies struct {
 	Daemon daemon.Component
 	Clock  clock.Clock
+	HTTP   *httpserver.Component // optional
 }
 
 // New creates a new metadata component.
@@ -75,24 +115,38 @@ func New(r *reporter.Reporter, configuration Configuration, dependencies Depende
 		config: configuration,
 		sc:     sc,
 
-		providerChannel:        make(chan provider.BatchQuery),
+		providerChannel:        make(chan providerRequest),
 		dispatcherChannel:      make(chan provider.Query, 100*configuration.Workers),
 		dispatcherBChannel:     make(chan (<-chan bool)),
 		providerBreakers:       make(map[netip.Addr]*breaker.Breaker),
 		providerBreakerLoggers: make(map[netip.Addr]reporter.Logger),
 		providers:              make([]provider.Provider, 0, 1),
+		providerServedBy:       make(map[netip.Addr]string),
+		providerNegativeCache:  make(map[providerNegativeKey]providerNegativeEntry),
+		reverseDNSCache:        make(map[netip.Addr]reverseDNSEntry),
+		lookupAddr:             net.DefaultResolver.LookupAddr,
+		exporterWorkers:        make(map[netip.Addr]int),
+		maxExporterWorkers: int(math.Ceil(
+			configuration.MaxExporterWorkersShare * float64(configuration.Workers))),
 	}
 	c.d.Daemon.Track(&c.t, "inlet/metadata")
 
 	// Initialize providers
 	for _, p := range c.config.Providers {
+		name := p.name()
 		selectedProvider, err := p.Config.New(r, func(update provider.Update) {
+			update.Answer.Exporter.Name = c.exporterName(update.Query.ExporterIP, update.Answer.Exporter.Name)
 			c.sc.Put(c.d.Clock.Now(), update.Query, update.Answer)
+			c.metrics.providerUpdates.WithLabelValues(name).Inc()
+			c.providerStateLock.Lock()
+			c.providerServedBy[update.Query.ExporterIP] = name
+			c.providerStateLock.Unlock()
 		})
 		if err != nil {
 			return nil, err
 		}
 		c.providers = append(c.providers, selectedProvider)
+		c.providerNames = append(c.providerNames, name)
 	}
 
 	c.metrics.cacheRefreshRuns = r.Counter(
@@ -123,6 +177,59 @@ func New(r *reporter.Reporter, configuration Configuration, dependencies Depende
 			Help: "Several requests were batched into one.",
 		},
 	)
+	c.metrics.providerUpdates = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "provider_updates_total",
+			Help: "Number of entries provided by each provider.",
+		},
+		[]string{"provider"})
+	c.metrics.providerErrors = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "provider_errors_total",
+			Help: "Number of errors returned by each provider.",
+		},
+		[]string{"provider"})
+	c.metrics.providerNegativeHits = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "provider_negative_cache_hits_total",
+			Help: "Number of times a provider was skipped due to a recent failure.",
+		},
+		[]string{"provider"})
+	c.metrics.reverseDNSErrors = r.Counter(
+		reporter.CounterOpts{
+			Name: "reverse_dns_errors_total",
+			Help: "Number of failed reverse DNS lookups for exporter names.",
+		})
+	c.metrics.providerThrottledCount = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "provider_throttled_requests_total",
+			Help: "Requests dropped because the exporter already used its share of workers.",
+		},
+		[]string{"exporter"})
+	c.metrics.providerPending = r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "provider_pending_requests",
+			Help: "Number of requests dispatched to workers and not yet answered.",
+		},
+		[]string{"exporter"})
+	c.metrics.providerSeconds = r.SummaryVec(
+		reporter.SummaryOpts{
+			Name:       "provider_seconds",
+			Help:       "Time from dispatch to answer from providers.",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		},
+		[]string{"exporter"})
+
+	if c.d.HTTP != nil {
+		c.d.HTTP.GinRouter.GET("/api/v0/inlet/metadata/providers", c.providersHTTPHandler)
+		c.d.HTTP.GinRouter.GET("/api/v0/inlet/metadata/exporters", c.exportersHTTPHandler)
+		c.d.HTTP.GinRouter.GET("/api/v0/inlet/metadata/exporters/:exporter/interfaces",
+			c.exporterInterfacesHTTPHandler)
+		c.d.HTTP.GinRouter.DELETE("/api/v0/inlet/metadata/exporters/:exporter",
+			c.exporterInvalidateHTTPHandler)
+		c.d.HTTP.GinRouter.DELETE("/api/v0/inlet/metadata/exporters/:exporter/interfaces/:ifindex",
+			c.exporterInvalidateHTTPHandler)
+	}
 	return &c, nil
 }
 
@@ -134,6 +241,15 @@ func (c *Component) Start() error {
 	if c.config.CachePersistFile != "" {
 		if err := c.sc.Load(c.config.CachePersistFile); err != nil {
 			c.r.Err(err).Msg("cannot load cache, ignoring")
+		} else {
+			discarded := 0
+			if c.config.CachePersistMaxAge > 0 {
+				discarded = c.sc.ExpireUpdatedBefore(c.d.Clock.Now().Add(-c.config.CachePersistMaxAge))
+			}
+			c.r.Info().
+				Int("loaded", c.sc.cache.Size()).
+				Int("discarded", discarded).
+				Msg("cache loaded")
 		}
 	}
 
@@ -145,6 +261,12 @@ func (c *Component) Start() error {
 		ticker := c.d.Clock.Ticker(c.config.CacheCheckInterval)
 		defer ticker.Stop()
 		defer close(healthyTicker)
+		var persistC <-chan time.Time
+		if c.config.CachePersistFile != "" && c.config.CachePersistInterval > 0 {
+			persistTicker := c.d.Clock.Ticker(c.config.CachePersistInterval)
+			defer persistTicker.Stop()
+			persistC = persistTicker.C
+		}
 		for {
 			select {
 			case <-c.t.Dying():
@@ -156,6 +278,8 @@ func (c *Component) Start() error {
 				}
 			case <-ticker.C:
 				c.expireCache()
+			case <-persistC:
+				c.saveCache()
 			}
 		}
 	})
@@ -214,9 +338,7 @@ func (c *Component) Stop() error {
 		close(c.providerChannel)
 		close(c.healthyWorkers)
 		if c.config.CachePersistFile != "" {
-			if err := c.sc.Save(c.config.CachePersistFile); err != nil {
-				c.r.Err(err).Msg("cannot save cache")
-			}
+			c.saveCache()
 		}
 		c.r.Info().Msg("metadata component stopped")
 	}()
@@ -241,6 +363,35 @@ func (c *Component) Lookup(t time.Time, exporterIP netip.Addr, ifIndex uint) (pr
 	return answer, ok
 }
 
+// invalidate removes the entries for the provided exporter and the interfaces
+// matching the provided function from the cache. They are polled again
+// immediately, without waiting for failing providers.
+func (c *Component) invalidate(exporterIP netip.Addr, match func(uint) bool) int {
+	ifIndexes := []uint{}
+	c.sc.Invalidate(func(query provider.Query) bool {
+		if query.ExporterIP != exporterIP || !match(query.IfIndex) {
+			return false
+		}
+		ifIndexes = append(ifIndexes, query.IfIndex)
+		return true
+	})
+	c.providerStateLock.Lock()
+	for key := range c.providerNegativeCache {
+		if key.exporter == exporterIP {
+			delete(c.providerNegativeCache, key)
+		}
+	}
+	c.providerStateLock.Unlock()
+	for _, ifIndex := range ifIndexes {
+		select {
+		case c.dispatcherChannel <- provider.Query{ExporterIP: exporterIP, IfIndex: ifIndex}:
+		default:
+			c.metrics.providerBusyCount.WithLabelValues(exporterIP.Unmap().String()).Inc()
+		}
+	}
+	return len(ifIndexes)
+}
+
 // dispatchIncomingRequest dispatches an incoming request to workers. It may
 // handle more than the provided request if it can.
 func (c *Component) dispatchIncomingRequest(request provider.Query) {
@@ -270,20 +421,69 @@ func (c *Component) dispatchIncomingRequest(request provider.Query) {
 		break
 	}
 	for exporterIP, ifIndexes := range requestsMap {
+		exporterStr := exporterIP.Unmap().String()
+		if !c.acquireExporterWorker(exporterIP) {
+			// Requests will be dispatched again on next lookup or refresh
+			c.metrics.providerThrottledCount.WithLabelValues(exporterStr).Add(float64(len(ifIndexes)))
+			continue
+		}
 		if len(ifIndexes) > 1 {
 			c.metrics.providerBatchedCount.Add(float64(len(ifIndexes)))
 		}
+		c.metrics.providerPending.WithLabelValues(exporterStr).Add(float64(len(ifIndexes)))
 		select {
 		case <-c.t.Dying():
+			// The request will not reach a worker
+			c.metrics.providerPending.WithLabelValues(exporterStr).Sub(float64(len(ifIndexes)))
+			c.releaseExporterWorker(exporterIP)
 			return
-		case c.providerChannel <- provider.BatchQuery{ExporterIP: exporterIP, IfIndexes: ifIndexes}:
+		case c.providerChannel <- providerRequest{
+			BatchQuery: provider.BatchQuery{ExporterIP: exporterIP, IfIndexes: ifIndexes},
+			dispatched: time.Now(),
+		}:
 		}
 	}
 }
 
+// acquireExporterWorker tells if a worker can be used for the provided
+// exporter. When true, releaseExporterWorker should be called once the request
+// is handled.
+func (c *Component) acquireExporterWorker(exporterIP netip.Addr) bool {
+	if c.maxExporterWorkers >= c.config.Workers {
+		return true
+	}
+	c.exporterWorkersLock.Lock()
+	defer c.exporterWorkersLock.Unlock()
+	if c.exporterWorkers[exporterIP] >= c.maxExporterWorkers {
+		return false
+	}
+	c.exporterWorkers[exporterIP]++
+	return true
+}
+
+// releaseExporterWorker releases a worker used for the provided exporter.
+func (c *Component) releaseExporterWorker(exporterIP netip.Addr) {
+	if c.maxExporterWorkers >= c.config.Workers {
+		return
+	}
+	c.exporterWorkersLock.Lock()
+	defer c.exporterWorkersLock.Unlock()
+	c.exporterWorkers[exporterIP]--
+	if c.exporterWorkers[exporterIP] <= 0 {
+		delete(c.exporterWorkers, exporterIP)
+	}
+}
+
 // providerIncomingRequest handles an incoming request to the provider. It
 // uses a breaker to avoid pushing working on non-responsive exporters.
-func (c *Component) providerIncomingRequest(request provider.BatchQuery) {
+func (c *Component) providerIncomingRequest(dispatched providerRequest) {
+	request := dispatched.BatchQuery
+	defer func() {
+		exporterStr := request.ExporterIP.Unmap().String()
+		c.releaseExporterWorker(request.ExporterIP)
+		c.metrics.providerPending.WithLabelValues(exporterStr).Sub(float64(len(request.IfIndexes)))
+		c.metrics.providerSeconds.WithLabelValues(exporterStr).Observe(time.Since(dispatched.dispatched).Seconds())
+	}()
 	// Avoid querying too much exporters with errors
 	c.providerBreakersLock.Lock()
 	providerBreaker, ok := c.providerBreakers[request.ExporterIP]
@@ -295,17 +495,28 @@ func (c *Component) providerIncomingRequest(request provider.BatchQuery) {
 
 	if err := providerBreaker.Run(func() error {
 		ctx := c.t.Context(nil)
-		for _, p := range c.providers {
+		var lastErr error
+		for idx, p := range c.providers {
 			// Query providers in the order they are defined and stop on the
-			// first provider accepting to handle the query.
-			if err := p.Query(ctx, request); err != nil && err != provider.ErrSkipProvider {
-				return err
-			} else if err == provider.ErrSkipProvider {
+			// first provider accepting to handle the query. When a provider
+			// fails, try the next ones. Providers which recently failed for
+			// this exporter are skipped to not add latency.
+			if entry, ok := c.providerNegativeLookup(idx, request.ExporterIP); ok {
+				c.metrics.providerNegativeHits.WithLabelValues(c.providerNames[idx]).Inc()
+				lastErr = entry.err
+				continue
+			}
+			if err := p.Query(ctx, request); err == provider.ErrSkipProvider {
+				continue
+			} else if err != nil {
+				c.metrics.providerErrors.WithLabelValues(c.providerNames[idx]).Inc()
+				c.providerNegativeStore(idx, request.ExporterIP, err)
+				lastErr = err
 				continue
 			}
 			return nil
 		}
-		return nil
+		return lastErr
 	}); err == breaker.ErrBreakerOpen {
 		c.metrics.providerBreakerOpenCount.WithLabelValues(request.ExporterIP.Unmap().String()).Inc()
 		c.providerBreakersLock.Lock()
@@ -322,6 +533,48 @@ func (c *Component) providerIncomingRequest(request provider.BatchQuery) {
 	}
 }
 
+// providerNegativeLookup tells if the provided provider should be skipped for
+// the provided exporter. The returned entry contains the last error.
+func (c *Component) providerNegativeLookup(idx int, exporterIP netip.Addr) (providerNegativeEntry, bool) {
+	if c.config.ProviderNegativeCacheDuration == 0 {
+		return providerNegativeEntry{}, false
+	}
+	key := providerNegativeKey{provider: idx, exporter: exporterIP}
+	c.providerStateLock.Lock()
+	defer c.providerStateLock.Unlock()
+	entry, ok := c.providerNegativeCache[key]
+	if !ok {
+		return providerNegativeEntry{}, false
+	}
+	if !c.d.Clock.Now().Before(entry.until) {
+		delete(c.providerNegativeCache, key)
+		return providerNegativeEntry{}, false
+	}
+	return entry, true
+}
+
+// providerNegativeStore records the provided provider failed for the provided
+// exporter.
+func (c *Component) providerNegativeStore(idx int, exporterIP netip.Addr, err error) {
+	if c.config.ProviderNegativeCacheDuration == 0 {
+		return
+	}
+	key := providerNegativeKey{provider: idx, exporter: exporterIP}
+	c.providerStateLock.Lock()
+	c.providerNegativeCache[key] = providerNegativeEntry{
+		until: c.d.Clock.Now().Add(c.config.ProviderNegativeCacheDuration),
+		err:   err,
+	}
+	c.providerStateLock.Unlock()
+}
+
+// saveCache stores the cache to the persistence file.
+func (c *Component) saveCache() {
+	if err := c.sc.Save(c.config.CachePersistFile); err != nil {
+		c.r.Err(err).Msg("cannot save cache")
+	}
+}
+
 // expireCache handles cache expiration and refresh.
 func (c *Component) expireCache() {
 	c.sc.Expire(c.d.Clock.Now().Add(-c.config.CacheDuration))
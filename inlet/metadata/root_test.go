@@ -7,14 +7,17 @@ import (
 	"context"
 	"errors"
 	"net/netip"
+	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/benbjohnson/clock"
+	"github.com/gin-gonic/gin"
 
 	"akvorado/common/daemon"
 	"akvorado/common/helpers"
+	"akvorado/common/httpserver"
 	"akvorado/common/reporter"
 	"akvorado/inlet/metadata/provider"
 	"akvorado/inlet/metadata/provider/static"
@@ -90,6 +93,49 @@ func TestComponentSaveLoad(t *testing.T) {
 	})
 }
 
+func TestComponentSaveLoadMaxAge(t *testing.T) {
+	configuration := DefaultConfiguration()
+	configuration.CachePersistFile = filepath.Join(t.TempDir(), "cache")
+	configuration.CachePersistMaxAge = time.Hour
+	mockClock := clock.NewMock()
+
+	t.Run("save", func(t *testing.T) {
+		r := reporter.NewMock(t)
+		c := NewMock(t, r, configuration, Dependencies{Daemon: daemon.NewMock(t), Clock: mockClock})
+		expectMockLookup(t, c, "127.0.0.1", 765, provider.Answer{})
+		time.Sleep(30 * time.Millisecond)
+
+		// Periodic save
+		mockClock.Add(configuration.CachePersistInterval)
+		time.Sleep(30 * time.Millisecond)
+		if _, err := os.Stat(configuration.CachePersistFile); err != nil {
+			t.Fatalf("Stat() error:\n%+v", err)
+		}
+	})
+
+	t.Run("load recent", func(t *testing.T) {
+		r := reporter.NewMock(t)
+		c := NewMock(t, r, configuration, Dependencies{Daemon: daemon.NewMock(t), Clock: mockClock})
+		expectMockLookup(t, c, "127.0.0.1", 765, provider.Answer{
+			Exporter: provider.Exporter{
+				Name: "127_0_0_1",
+			},
+			Interface: provider.Interface{
+				Name:        "Gi0/0/765",
+				Description: "Interface 765",
+				Speed:       1000,
+			},
+		})
+	})
+
+	t.Run("load old", func(t *testing.T) {
+		mockClock.Add(2 * time.Hour)
+		r := reporter.NewMock(t)
+		c := NewMock(t, r, configuration, Dependencies{Daemon: daemon.NewMock(t), Clock: mockClock})
+		expectMockLookup(t, c, "127.0.0.1", 765, provider.Answer{})
+	})
+}
+
 func TestAutoRefresh(t *testing.T) {
 	r := reporter.NewMock(t)
 	configuration := DefaultConfiguration()
@@ -306,6 +352,111 @@ func TestBatching(t *testing.T) {
 	})
 }
 
+type blockingProvider struct {
+	release chan struct{}
+}
+
+func (bp blockingProvider) Query(ctx context.Context, _ provider.BatchQuery) error {
+	select {
+	case <-bp.release:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+type blockingProviderConfiguration struct {
+	release chan struct{}
+}
+
+func (bpc blockingProviderConfiguration) New(_ *reporter.Reporter, _ func(provider.Update)) (provider.Provider, error) {
+	return blockingProvider{release: bpc.release}, nil
+}
+
+func TestMaxExporterWorkersShare(t *testing.T) {
+	r := reporter.NewMock(t)
+	release := make(chan struct{})
+	configuration := DefaultConfiguration()
+	configuration.Workers = 4
+	configuration.MaxBatchRequests = 0
+	configuration.MaxExporterWorkersShare = 0.25
+	configuration.Providers = []ProviderConfiguration{
+		{Config: blockingProviderConfiguration{release: release}},
+	}
+	c := NewMock(t, r, configuration, Dependencies{Daemon: daemon.NewMock(t)})
+
+	// The first exporter can only use one worker.
+	c.Lookup(c.d.Clock.Now(), netip.MustParseAddr("::ffff:127.0.0.1"), 765)
+	time.Sleep(20 * time.Millisecond)
+	c.Lookup(c.d.Clock.Now(), netip.MustParseAddr("::ffff:127.0.0.1"), 766)
+	c.Lookup(c.d.Clock.Now(), netip.MustParseAddr("::ffff:127.0.0.2"), 765)
+	time.Sleep(20 * time.Millisecond)
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_metadata_provider_",
+		"throttled_requests_total", "pending_requests")
+	expectedMetrics := map[string]string{
+		`throttled_requests_total{exporter="127.0.0.1"}`: "1",
+		`pending_requests{exporter="127.0.0.1"}`:         "1",
+		`pending_requests{exporter="127.0.0.2"}`:         "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+
+	// Once released, a new request can use a worker.
+	close(release)
+	time.Sleep(20 * time.Millisecond)
+	c.Lookup(c.d.Clock.Now(), netip.MustParseAddr("::ffff:127.0.0.1"), 766)
+	time.Sleep(20 * time.Millisecond)
+	gotMetrics = r.GetMetrics("akvorado_inlet_metadata_provider_",
+		"throttled_requests_total", "pending_requests", "seconds_count")
+	expectedMetrics = map[string]string{
+		`throttled_requests_total{exporter="127.0.0.1"}`: "1",
+		`pending_requests{exporter="127.0.0.1"}`:         "0",
+		`pending_requests{exporter="127.0.0.2"}`:         "0",
+		`seconds_count{exporter="127.0.0.1"}`:            "2",
+		`seconds_count{exporter="127.0.0.2"}`:            "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestMaxExporterWorkersReleasedOnStop(t *testing.T) {
+	r := reporter.NewMock(t)
+	var c *Component
+	t.Run("run", func(t *testing.T) {
+		configuration := DefaultConfiguration()
+		configuration.Workers = 2
+		configuration.MaxBatchRequests = 0
+		configuration.MaxExporterWorkersShare = 0.5
+		configuration.Providers = []ProviderConfiguration{
+			{Config: blockingProviderConfiguration{release: make(chan struct{})}},
+		}
+		c = NewMock(t, r, configuration, Dependencies{Daemon: daemon.NewMock(t)})
+
+		// Both workers are busy, the third request waits in the dispatcher.
+		c.Lookup(c.d.Clock.Now(), netip.MustParseAddr("::ffff:127.0.0.1"), 765)
+		c.Lookup(c.d.Clock.Now(), netip.MustParseAddr("::ffff:127.0.0.2"), 765)
+		time.Sleep(20 * time.Millisecond)
+		c.Lookup(c.d.Clock.Now(), netip.MustParseAddr("::ffff:127.0.0.3"), 765)
+		time.Sleep(20 * time.Millisecond)
+	})
+
+	// Once stopped, no worker should be held.
+	if diff := helpers.Diff(c.exporterWorkers, map[netip.Addr]int{}); diff != "" {
+		t.Errorf("exporterWorkers (-got, +want):\n%s", diff)
+	}
+	gotMetrics := r.GetMetrics("akvorado_inlet_metadata_provider_", "pending_requests")
+	expectedMetrics := map[string]string{
+		`pending_requests{exporter="127.0.0.1"}`: "0",
+		`pending_requests{exporter="127.0.0.2"}`: "0",
+		`pending_requests{exporter="127.0.0.3"}`: "0",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Errorf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
 func TestMultipleProviders(t *testing.T) {
 	r := reporter.NewMock(t)
 	staticConfiguration1 := static.Configuration{
@@ -387,3 +538,234 @@ func TestMultipleProviders(t *testing.T) {
 		t.Fatalf("Lookup() (-got, +want):\n%s", diff)
 	}
 }
+
+func TestProviderFallbackOnError(t *testing.T) {
+	r := reporter.NewMock(t)
+	staticConfiguration := static.Configuration{
+		Exporters: helpers.MustNewSubnetMap(map[string]static.ExporterConfiguration{
+			"2001:db8:1::/48": {
+				Exporter: provider.Exporter{
+					Name: "static1",
+				},
+				Default: provider.Interface{
+					Name:        "Gi10",
+					Description: "default interface",
+					Speed:       1000,
+				},
+			},
+		}),
+	}
+	configuration := DefaultConfiguration()
+	configuration.Providers = []ProviderConfiguration{
+		{Config: errorProviderConfiguration{}},
+		{Config: staticConfiguration},
+	}
+	c := NewMock(t, r, configuration, Dependencies{Daemon: daemon.NewMock(t)})
+	c.Lookup(time.Now(), netip.MustParseAddr("2001:db8:1::1"), 10)
+	time.Sleep(30 * time.Millisecond)
+	got, ok := c.Lookup(time.Now(), netip.MustParseAddr("2001:db8:1::1"), 10)
+	if !ok {
+		t.Fatal("Lookup() did not return a result")
+	}
+	expected := provider.Answer{
+		Exporter: provider.Exporter{
+			Name: "static1",
+		},
+		Interface: provider.Interface{
+			Name:        "Gi10",
+			Description: "default interface",
+			Speed:       1000,
+		},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("Lookup() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestProviderNegativeCache(t *testing.T) {
+	r := reporter.NewMock(t)
+	h := httpserver.NewMock(t, r)
+	mockClock := clock.NewMock()
+	staticConfiguration := static.Configuration{
+		Exporters: helpers.MustNewSubnetMap(map[string]static.ExporterConfiguration{
+			"2001:db8:1::/48": {
+				Exporter: provider.Exporter{
+					Name: "static1",
+				},
+				Default: provider.Interface{
+					Name:        "Gi10",
+					Description: "default interface",
+					Speed:       1000,
+				},
+			},
+		}),
+	}
+	configuration := DefaultConfiguration()
+	configuration.MaxBatchRequests = 0
+	configuration.Providers = []ProviderConfiguration{
+		{Config: errorProviderConfiguration{}},
+		{Config: staticConfiguration},
+	}
+	c := NewMock(t, r, configuration, Dependencies{
+		Daemon: daemon.NewMock(t),
+		Clock:  mockClock,
+		HTTP:   h,
+	})
+
+	// The error provider is queried only once, then skipped until the
+	// negative cache entry expires.
+	c.Lookup(mockClock.Now(), netip.MustParseAddr("2001:db8:1::1"), 10)
+	time.Sleep(30 * time.Millisecond)
+	c.Lookup(mockClock.Now(), netip.MustParseAddr("2001:db8:1::1"), 11)
+	time.Sleep(30 * time.Millisecond)
+
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			URL: "/api/v0/inlet/metadata/providers",
+			JSONOutput: gin.H{
+				"exporters": []gin.H{
+					{
+						"exporter":  "2001:db8:1::1",
+						"served-by": "static",
+						"skipped": []gin.H{
+							{
+								"provider": "errorproviderconfiguration",
+								"until":    "1970-01-01T00:01:00Z",
+								"error":    "noooo",
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+
+	mockClock.Add(time.Minute + time.Second)
+	c.Lookup(mockClock.Now(), netip.MustParseAddr("2001:db8:1::1"), 12)
+	time.Sleep(30 * time.Millisecond)
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_metadata_provider_",
+		"updates_total", "errors_total", "negative_cache_hits_total")
+	expectedMetrics := map[string]string{
+		`updates_total{provider="static"}`:                                 "3",
+		`errors_total{provider="errorproviderconfiguration"}`:              "2",
+		`negative_cache_hits_total{provider="errorproviderconfiguration"}`: "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestCacheHTTPEndpoints(t *testing.T) {
+	r := reporter.NewMock(t)
+	h := httpserver.NewMock(t, r)
+	mockClock := clock.NewMock()
+	c := NewMock(t, r, DefaultConfiguration(), Dependencies{
+		Daemon: daemon.NewMock(t),
+		Clock:  mockClock,
+		HTTP:   h,
+	})
+	exporter1 := netip.MustParseAddr("::ffff:192.0.2.1")
+	exporter2 := netip.MustParseAddr("::ffff:192.0.2.2")
+	for _, query := range []provider.Query{
+		{ExporterIP: exporter1, IfIndex: 1},
+		{ExporterIP: exporter1, IfIndex: 2},
+		{ExporterIP: exporter2, IfIndex: 1},
+	} {
+		c.sc.Put(mockClock.Now(), query, provider.Answer{
+			Exporter: provider.Exporter{Name: "old"},
+			Interface: provider.Interface{
+				Name:        "old",
+				Description: "old description",
+				Speed:       1000,
+			},
+		})
+	}
+	mockClock.Add(time.Minute)
+
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			URL: "/api/v0/inlet/metadata/exporters",
+			JSONOutput: gin.H{
+				"exporters": []gin.H{
+					{"exporter": "192.0.2.1", "name": "old", "interfaces": 2, "age": 60},
+					{"exporter": "192.0.2.2", "name": "old", "interfaces": 1, "age": 60},
+				},
+			},
+		}, {
+			URL: "/api/v0/inlet/metadata/exporters/192.0.2.1/interfaces",
+			JSONOutput: gin.H{
+				"interfaces": []gin.H{
+					{
+						"ifindex":     1,
+						"name":        "old",
+						"description": "old description",
+						"speed":       1000,
+						"boundary":    "undefined",
+						"age":         60,
+					}, {
+						"ifindex":     2,
+						"name":        "old",
+						"description": "old description",
+						"speed":       1000,
+						"boundary":    "undefined",
+						"age":         60,
+					},
+				},
+			},
+		}, {
+			URL:        "/api/v0/inlet/metadata/exporters/192.0.2.3/interfaces",
+			StatusCode: 404,
+			JSONOutput: gin.H{"message": "Exporter not found."},
+		}, {
+			URL:        "/api/v0/inlet/metadata/exporters/192.0.2.300/interfaces",
+			StatusCode: 400,
+			JSONOutput: gin.H{"message": "Invalid exporter IP address."},
+		}, {
+			Method:     "DELETE",
+			URL:        "/api/v0/inlet/metadata/exporters/192.0.2.1/interfaces/eth0",
+			StatusCode: 400,
+			JSONOutput: gin.H{"message": "Invalid interface index."},
+		}, {
+			Method:     "DELETE",
+			URL:        "/api/v0/inlet/metadata/exporters/192.0.2.1/interfaces/2",
+			JSONOutput: gin.H{"invalidated": 1},
+		}, {
+			Method:     "DELETE",
+			URL:        "/api/v0/inlet/metadata/exporters/192.0.2.2",
+			JSONOutput: gin.H{"invalidated": 1},
+		}, {
+			Method:     "DELETE",
+			URL:        "/api/v0/inlet/metadata/exporters/192.0.2.3",
+			StatusCode: 404,
+			JSONOutput: gin.H{"message": "No matching entry in cache."},
+		},
+	})
+
+	// Invalidated entries are polled again immediately
+	time.Sleep(30 * time.Millisecond)
+	expectMockLookup(t, c, "::ffff:192.0.2.1", 1, provider.Answer{
+		Exporter: provider.Exporter{Name: "old"},
+		Interface: provider.Interface{
+			Name:        "old",
+			Description: "old description",
+			Speed:       1000,
+		},
+	})
+	expectMockLookup(t, c, "::ffff:192.0.2.1", 2, provider.Answer{
+		Exporter: provider.Exporter{Name: "192_0_2_1"},
+		Interface: provider.Interface{
+			Name:        "Gi0/0/2",
+			Description: "Interface 2",
+			Speed:       1000,
+		},
+	})
+	expectMockLookup(t, c, "::ffff:192.0.2.2", 1, provider.Answer{
+		Exporter: provider.Exporter{Name: "192_0_2_2"},
+		Interface: provider.Interface{
+			Name:        "Gi0/0/1",
+			Description: "Interface 1",
+			Speed:       1000,
+		},
+	})
+}
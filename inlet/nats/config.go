@@ -0,0 +1,45 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package nats
+
+import (
+	"time"
+
+	"akvorado/common/helpers"
+)
+
+// Configuration describes the configuration for the NATS JetStream exporter.
+type Configuration struct {
+	// Servers is the list of NATS servers to connect to.
+	Servers []string `validate:"min=1,dive,required"`
+	// Subject is the base subject to publish flows to. The schema hash is
+	// appended as an additional token.
+	Subject string `validate:"required"`
+	// CredentialsFile is the path to a NATS credentials file. When empty,
+	// no credentials are used.
+	CredentialsFile string
+	// TLS defines the TLS configuration to connect to NATS.
+	TLS helpers.TLSConfiguration
+	// MaxPending is the maximum number of messages published and not yet
+	// acknowledged by JetStream.
+	MaxPending int `validate:"min=1"`
+	// ShutdownTimeout is the maximum time to wait for pending messages to
+	// be acknowledged when stopping.
+	ShutdownTimeout time.Duration `validate:"min=0"`
+}
+
+// DefaultConfiguration represents the default configuration for the NATS
+// JetStream exporter.
+func DefaultConfiguration() Configuration {
+	return Configuration{
+		Servers:         []string{"nats://127.0.0.1:4222"},
+		Subject:         "flows",
+		MaxPending:      4000,
+		ShutdownTimeout: 10 * time.Second,
+		TLS: helpers.TLSConfiguration{
+			Enable: false,
+			Verify: true,
+		},
+	}
+}
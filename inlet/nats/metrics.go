@@ -0,0 +1,70 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package nats
+
+import "akvorado/common/reporter"
+
+type metrics struct {
+	messagesSent *reporter.CounterVec
+	bytesSent    *reporter.CounterVec
+	errors       *reporter.CounterVec
+	stalls       reporter.Counter
+
+	shutdownFlushed reporter.Counter
+	shutdownDropped reporter.Counter
+}
+
+func (c *Component) initMetrics() {
+	c.metrics.messagesSent = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "sent_messages_total",
+			Help: "Number of messages sent from a given exporter.",
+		},
+		[]string{"exporter"},
+	)
+	c.metrics.bytesSent = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "sent_bytes_total",
+			Help: "Number of bytes sent from a given exporter.",
+		},
+		[]string{"exporter"},
+	)
+	c.metrics.errors = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "errors_total",
+			Help: "Number of errors when publishing to NATS JetStream.",
+		},
+		[]string{"error"},
+	)
+	c.metrics.stalls = c.r.Counter(
+		reporter.CounterOpts{
+			Name: "stalls_total",
+			Help: "Number of times publishing was stalled by too many unacknowledged messages.",
+		},
+	)
+	c.metrics.shutdownFlushed = c.r.Counter(
+		reporter.CounterOpts{
+			Name: "shutdown_flushed_messages_total",
+			Help: "Number of pending messages acknowledged while stopping.",
+		},
+	)
+	c.metrics.shutdownDropped = c.r.Counter(
+		reporter.CounterOpts{
+			Name: "shutdown_dropped_messages_total",
+			Help: "Number of pending messages not acknowledged while stopping.",
+		},
+	)
+	c.r.GaugeFunc(
+		reporter.GaugeOpts{
+			Name: "pending_messages",
+			Help: "Number of messages published and not yet acknowledged.",
+		},
+		func() float64 {
+			if c.publisher == nil {
+				return 0
+			}
+			return float64(c.publisher.PublishAsyncPending())
+		},
+	)
+}
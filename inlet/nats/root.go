@@ -0,0 +1,191 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Package nats handles flow exports to NATS JetStream.
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"gopkg.in/tomb.v2"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+)
+
+// Component represents the NATS JetStream exporter.
+type Component struct {
+	r      *reporter.Reporter
+	d      *Dependencies
+	t      tomb.Tomb
+	config Configuration
+
+	metrics   metrics
+	errLogger reporter.Logger
+
+	subject          string
+	conn             connection
+	publisher        publisher
+	createConnection func() (connection, publisher, error)
+}
+
+// connection is the subset of the NATS connection API we use.
+type connection interface {
+	IsConnected() bool
+	Close()
+}
+
+// publisher is the subset of the JetStream API we use.
+type publisher interface {
+	PublishMsgAsync(msg *nats.Msg, opts ...jetstream.PublishOpt) (jetstream.PubAckFuture, error)
+	PublishAsyncPending() int
+	PublishAsyncComplete() <-chan struct{}
+}
+
+// Dependencies define the dependencies of the NATS JetStream exporter.
+type Dependencies struct {
+	Daemon daemon.Component
+	Schema *schema.Component
+}
+
+// New creates a new NATS JetStream exporter component.
+func New(r *reporter.Reporter, configuration Configuration, dependencies Dependencies) (*Component, error) {
+	tlsConfig, err := configuration.TLS.MakeTLSConfig()
+	if err != nil {
+		return nil, fmt.Errorf("cannot setup TLS for NATS: %w", err)
+	}
+	options := []nats.Option{
+		nats.Name(fmt.Sprintf("akvorado-inlet/%s", helpers.AkvoradoVersion)),
+		nats.MaxReconnects(-1),
+		nats.RetryOnFailedConnect(true),
+	}
+	if tlsConfig != nil {
+		options = append(options, nats.Secure(tlsConfig))
+	}
+	if configuration.CredentialsFile != "" {
+		options = append(options, nats.UserCredentials(configuration.CredentialsFile))
+	}
+
+	c := Component{
+		r:      r,
+		d:      &dependencies,
+		config: configuration,
+
+		subject: fmt.Sprintf("%s.%s", configuration.Subject, dependencies.Schema.ProtobufMessageHash()),
+	}
+	c.errLogger = r.Sample(reporter.BurstSampler(10*time.Second, 3))
+	c.createConnection = func() (connection, publisher, error) {
+		nc, err := nats.Connect(strings.Join(c.config.Servers, ","), options...)
+		if err != nil {
+			return nil, nil, err
+		}
+		js, err := jetstream.New(nc,
+			jetstream.WithPublishAsyncMaxPending(c.config.MaxPending),
+			jetstream.WithPublishAsyncErrHandler(func(_ jetstream.JetStream, _ *nats.Msg, err error) {
+				c.reportError(err)
+			}))
+		if err != nil {
+			nc.Close()
+			return nil, nil, err
+		}
+		return nc, js, nil
+	}
+	c.initMetrics()
+	c.d.Daemon.Track(&c.t, "inlet/nats")
+	return &c, nil
+}
+
+// Start starts the NATS JetStream exporter component.
+func (c *Component) Start() error {
+	c.r.Info().Msg("starting NATS JetStream component")
+	conn, publisher, err := c.createConnection()
+	if err != nil {
+		c.r.Err(err).
+			Str("servers", strings.Join(c.config.Servers, ",")).
+			Msg("unable to connect to NATS")
+		return fmt.Errorf("unable to connect to NATS: %w", err)
+	}
+	c.conn = conn
+	c.publisher = publisher
+	c.r.RegisterHealthcheck("nats", c.healthcheck)
+	c.t.Go(func() error {
+		<-c.t.Dying()
+		c.flush()
+		return nil
+	})
+	return nil
+}
+
+// Stop stops the NATS JetStream exporter component.
+func (c *Component) Stop() error {
+	defer func() {
+		c.conn.Close()
+		c.r.Info().Msg("NATS JetStream component stopped")
+	}()
+	c.r.Info().Msg("stopping NATS JetStream component")
+	c.t.Kill(nil)
+	return c.t.Wait()
+}
+
+// flush waits for pending messages to be acknowledged, up to the configured
+// shutdown timeout. Messages still pending after the deadline are counted as
+// dropped.
+func (c *Component) flush() {
+	pending := c.publisher.PublishAsyncPending()
+	select {
+	case <-c.publisher.PublishAsyncComplete():
+		c.metrics.shutdownFlushed.Add(float64(pending))
+	case <-time.After(c.config.ShutdownTimeout):
+		dropped := c.publisher.PublishAsyncPending()
+		c.r.Warn().Int("dropped", dropped).Msg("timeout while waiting for pending messages to be acknowledged")
+		c.metrics.shutdownFlushed.Add(float64(max(pending-dropped, 0)))
+		c.metrics.shutdownDropped.Add(float64(dropped))
+	}
+}
+
+// Send a message to NATS JetStream. When too many messages are waiting for
+// an acknowledgment, this blocks.
+func (c *Component) Send(exporter string, payload []byte) {
+	msg := &nats.Msg{
+		Subject: c.subject,
+		Data:    payload,
+	}
+	for {
+		_, err := c.publisher.PublishMsgAsync(msg)
+		if err == nil {
+			c.metrics.bytesSent.WithLabelValues(exporter).Add(float64(len(payload)))
+			c.metrics.messagesSent.WithLabelValues(exporter).Inc()
+			return
+		}
+		if !errors.Is(err, jetstream.ErrTooManyStalledMsgs) || !c.t.Alive() {
+			c.reportError(err)
+			return
+		}
+		c.metrics.stalls.Inc()
+	}
+}
+
+// reportError reports an error when publishing a message.
+func (c *Component) reportError(err error) {
+	c.metrics.errors.WithLabelValues(err.Error()).Inc()
+	c.errLogger.Err(err).Str("subject", c.subject).Msg("NATS JetStream publish error")
+}
+
+// healthcheck tells if the component is connected to NATS.
+func (c *Component) healthcheck(_ context.Context) reporter.HealthcheckResult {
+	if !c.conn.IsConnected() {
+		return reporter.HealthcheckResult{
+			Status: reporter.HealthcheckWarning,
+			Reason: "not connected to NATS",
+		}
+	}
+	return reporter.HealthcheckResult{Status: reporter.HealthcheckOK, Reason: "ok"}
+}
@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package nats
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+)
+
+func TestSend(t *testing.T) {
+	r := reporter.NewMock(t)
+	c, mock := NewMock(t, r, DefaultConfiguration())
+
+	mock.stalls = 2
+	c.Send("127.0.0.1", []byte("hello world!"))
+	c.Send("127.0.0.1", []byte("goodbye world!"))
+	if err := c.Stop(); err != nil {
+		t.Fatalf("Stop() error:\n%+v", err)
+	}
+
+	subject := fmt.Sprintf("flows.%s", c.d.Schema.ProtobufMessageHash())
+	expected := []*nats.Msg{
+		{Subject: subject, Data: []byte("hello world!")},
+		{Subject: subject, Data: []byte("goodbye world!")},
+	}
+	if diff := helpers.Diff(mock.Messages(), expected); diff != "" {
+		t.Fatalf("Send() (-got, +want):\n%s", diff)
+	}
+	if !mock.closed {
+		t.Error("Stop() did not close the connection")
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_nats_", "sent_", "stalls_")
+	expectedMetrics := map[string]string{
+		`sent_bytes_total{exporter="127.0.0.1"}`:    "26",
+		`sent_messages_total{exporter="127.0.0.1"}`: "2",
+		`stalls_total`: "2",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration()
+	config.ShutdownTimeout = 20 * time.Millisecond
+	c, mock := NewMock(t, r, config)
+	mock.complete = make(chan struct{})
+	mock.pending = 3
+
+	if err := c.Stop(); err != nil {
+		t.Fatalf("Stop() error:\n%+v", err)
+	}
+	gotMetrics := r.GetMetrics("akvorado_inlet_nats_", "shutdown_", "pending_")
+	expectedMetrics := map[string]string{
+		`pending_messages`:                "3",
+		`shutdown_dropped_messages_total`: "3",
+		`shutdown_flushed_messages_total`: "0",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestHealthcheck(t *testing.T) {
+	r := reporter.NewMock(t)
+	c, mock := NewMock(t, r, DefaultConfiguration())
+
+	if got := c.healthcheck(context.Background()); got.Status != reporter.HealthcheckOK {
+		t.Errorf("healthcheck() == %+v, expected OK", got)
+	}
+	mock.lock.Lock()
+	mock.connected = false
+	mock.lock.Unlock()
+	if got := c.healthcheck(context.Background()); got.Status != reporter.HealthcheckWarning {
+		t.Errorf("healthcheck() == %+v, expected warning", got)
+	}
+}
@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+//go:build !release
+
+package nats
+
+import (
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+)
+
+// MockJetStream is a fake NATS connection and JetStream publisher.
+type MockJetStream struct {
+	lock      sync.Mutex
+	connected bool
+	closed    bool
+	messages  []*nats.Msg
+	stalls    int // number of publish calls to stall
+	pending   int
+	complete  chan struct{}
+}
+
+// IsConnected tells if the fake connection is connected.
+func (m *MockJetStream) IsConnected() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.connected
+}
+
+// Close closes the fake connection.
+func (m *MockJetStream) Close() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.closed = true
+}
+
+// PublishMsgAsync records a published message.
+func (m *MockJetStream) PublishMsgAsync(msg *nats.Msg, _ ...jetstream.PublishOpt) (jetstream.PubAckFuture, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.stalls > 0 {
+		m.stalls--
+		return nil, jetstream.ErrTooManyStalledMsgs
+	}
+	m.messages = append(m.messages, msg)
+	return nil, nil
+}
+
+// PublishAsyncPending returns the number of pending messages.
+func (m *MockJetStream) PublishAsyncPending() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.pending
+}
+
+// PublishAsyncComplete returns a channel closed once all messages are
+// acknowledged.
+func (m *MockJetStream) PublishAsyncComplete() <-chan struct{} {
+	return m.complete
+}
+
+// Messages returns the messages published so far.
+func (m *MockJetStream) Messages() []*nats.Msg {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return slices.Clone(m.messages)
+}
+
+// NewMock creates a new NATS component with a mocked JetStream. All
+// published messages are immediately acknowledged, unless the returned mock
+// is modified before stopping the component.
+func NewMock(t *testing.T, reporter *reporter.Reporter, configuration Configuration) (*Component, *MockJetStream) {
+	t.Helper()
+	c, err := New(reporter, configuration, Dependencies{
+		Daemon: daemon.NewMock(t),
+		Schema: schema.NewMock(t),
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+
+	// Use a mocked JetStream
+	mock := &MockJetStream{connected: true, complete: make(chan struct{})}
+	close(mock.complete)
+	c.createConnection = func() (connection, publisher, error) {
+		return mock, mock, nil
+	}
+
+	helpers.StartStop(t, c)
+	return c, mock
+}
@@ -4,21 +4,64 @@
 package routing
 
 import (
+	"net/netip"
+	"time"
+
 	"akvorado/common/helpers"
 	"akvorado/inlet/routing/provider"
 	"akvorado/inlet/routing/provider/bioris"
 	"akvorado/inlet/routing/provider/bmp"
+	"akvorado/inlet/routing/provider/static"
 )
 
 // Configuration describes the configuration for the routing client.
 type Configuration struct {
-	// Provider defines the configuration of the provider to use
-	Provider ProviderConfiguration
+	// Providers defines the configuration of the providers to use. They are
+	// queried in order until one of them finds a route.
+	Providers []ProviderConfiguration
+	// RPKI defines the source of validated ROA payloads used to compute the
+	// RPKI origin validation status of routes
+	RPKI RPKIConfiguration
+	// VRFs maps exporters and input interfaces or VLANs to VRFs. The first
+	// matching VRF is used.
+	VRFs []VRFConfiguration `validate:"dive"`
 }
 
 // DefaultConfiguration represents the default configuration for the routing client.
 func DefaultConfiguration() Configuration {
-	return Configuration{}
+	return Configuration{
+		RPKI: RPKIConfiguration{
+			Interval: 10 * time.Minute,
+			Timeout:  time.Minute,
+		},
+	}
+}
+
+// RPKIConfiguration describes how to get validated ROA payloads (VRPs).
+type RPKIConfiguration struct {
+	// Source is the path or the HTTP(S) URL of a JSON export of VRPs, as
+	// produced by Routinator or rpki-client. When empty, RPKI validation is
+	// disabled.
+	Source string
+	// Interval is the interval at which VRPs are refreshed
+	Interval time.Duration `validate:"min=1m"`
+	// Timeout is the maximum time to fetch and parse VRPs
+	Timeout time.Duration `validate:"min=1s"`
+}
+
+// VRFConfiguration maps exporters and input interfaces or VLANs to a VRF.
+type VRFConfiguration struct {
+	// Name is the name of the VRF
+	Name string `validate:"required"`
+	// RD is the route distinguisher of the VRF
+	RD bmp.RD `validate:"required"`
+	// Exporters is the list of exporter subnets using this VRF. When empty,
+	// any exporter matches.
+	Exporters []netip.Prefix
+	// Interfaces is the list of input interface indexes in this VRF
+	Interfaces []uint32
+	// VLANs is the list of source VLANs in this VRF
+	VLANs []uint16
 }
 
 // ProviderConfiguration represents the configuration for a routing provider.
@@ -35,9 +78,12 @@ func (pc ProviderConfiguration) MarshalYAML() (interface{}, error) {
 var providers = map[string](func() provider.Configuration){
 	"bmp":    bmp.DefaultConfiguration,
 	"bioris": bioris.DefaultConfiguration,
+	"static": static.DefaultConfiguration,
 }
 
 func init() {
+	helpers.RegisterMapstructureUnmarshallerHook(
+		helpers.RenameKeyUnmarshallerHook(Configuration{}, "Provider", "Providers"))
 	helpers.RegisterMapstructureUnmarshallerHook(
 		helpers.ParametrizedConfigurationUnmarshallerHook(ProviderConfiguration{}, providers))
 }
@@ -8,6 +8,10 @@ import "akvorado/common/reporter"
 type metrics struct {
 	routingLookups       reporter.Counter
 	routingLookupsFailed reporter.Counter
+	rpkiVRPs             reporter.Gauge
+	rpkiUpdates          reporter.Counter
+	rpkiErrors           reporter.Counter
+	rpkiLookups          *reporter.CounterVec
 }
 
 // initMetrics initialize the metrics for the BMP component.
@@ -24,4 +28,29 @@ func (c *Component) initMetrics() {
 			Help: "Number of failed routing lookups",
 		},
 	)
+	c.metrics.rpkiVRPs = c.r.Gauge(
+		reporter.GaugeOpts{
+			Name: "rpki_vrps",
+			Help: "Number of RPKI validated ROA payloads",
+		},
+	)
+	c.metrics.rpkiUpdates = c.r.Counter(
+		reporter.CounterOpts{
+			Name: "rpki_updates_total",
+			Help: "Number of successful updates of RPKI validated ROA payloads",
+		},
+	)
+	c.metrics.rpkiErrors = c.r.Counter(
+		reporter.CounterOpts{
+			Name: "rpki_errors_total",
+			Help: "Number of failed updates of RPKI validated ROA payloads",
+		},
+	)
+	c.metrics.rpkiLookups = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "rpki_lookups_total",
+			Help: "Number of RPKI validations by status",
+		},
+		[]string{"status"},
+	)
 }
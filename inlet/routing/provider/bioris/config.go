@@ -5,8 +5,10 @@
 package bioris
 
 import (
+	"errors"
 	"time"
 
+	"akvorado/common/helpers/bimap"
 	"akvorado/inlet/routing/provider"
 )
 
@@ -20,6 +22,9 @@ type Configuration struct {
 	Refresh time.Duration `validate:"min=1s"`
 	// RefreshTimeout defines the timeout to retrieve the list of routers from one RIS instance
 	RefreshTimeout time.Duration `validate:"min=1s"`
+	// Selection tells how to select a RIS instance among the healthy ones
+	// knowing the router
+	Selection InstanceSelection
 }
 
 // RISInstance stores the connection details of a single RIS connection
@@ -41,3 +46,49 @@ func DefaultConfiguration() provider.Configuration {
 		RefreshTimeout: 10 * time.Second,
 	}
 }
+
+// InstanceSelection represents a rule to select a RIS instance among the ones
+// knowing a router.
+type InstanceSelection int
+
+const (
+	// InstanceSelectionRandom selects a random healthy instance.
+	InstanceSelectionRandom InstanceSelection = iota
+	// InstanceSelectionPreferred selects the first healthy instance, in the
+	// configured order.
+	InstanceSelectionPreferred
+	// InstanceSelectionPrefixHash selects a healthy instance from a hash of the
+	// looked up prefix.
+	InstanceSelectionPrefixHash
+)
+
+var instanceSelectionMap = bimap.New(map[InstanceSelection]string{
+	InstanceSelectionRandom:     "random",
+	InstanceSelectionPreferred:  "preferred",
+	InstanceSelectionPrefixHash: "prefix-hash",
+})
+
+// MarshalText turns an instance selection to text.
+func (is InstanceSelection) MarshalText() ([]byte, error) {
+	got, ok := instanceSelectionMap.LoadValue(is)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown instance selection")
+}
+
+// String turns an instance selection to string.
+func (is InstanceSelection) String() string {
+	got, _ := instanceSelectionMap.LoadValue(is)
+	return got
+}
+
+// UnmarshalText provides an instance selection from a string.
+func (is *InstanceSelection) UnmarshalText(input []byte) error {
+	got, ok := instanceSelectionMap.LoadKey(string(input))
+	if ok {
+		*is = got
+		return nil
+	}
+	return errors.New("unknown instance selection")
+}
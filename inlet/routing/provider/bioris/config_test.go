@@ -14,3 +14,7 @@ func TestDefaultConfiguration(t *testing.T) {
 		t.Fatalf("validate.Struct() error:\n%+v", err)
 	}
 }
+
+func TestInstanceSelection(t *testing.T) {
+	instanceSelectionMap.TestMarshalUnmarshal(t)
+}
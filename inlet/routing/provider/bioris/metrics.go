@@ -12,6 +12,8 @@ type metrics struct {
 	lpmRequestErrors         *reporter.CounterVec
 	lpmRequestTimeouts       *reporter.CounterVec
 	lpmRequestSuccess        *reporter.CounterVec
+	lpmRequestDuration       *reporter.SummaryVec
+	lpmFailovers             *reporter.CounterVec
 	routerChosenFallback     *reporter.CounterVec
 	routerChosenAgentIDMatch *reporter.CounterVec
 }
@@ -61,6 +63,21 @@ func (p *Provider) initMetrics() {
 		},
 		[]string{"ris", "router"},
 	)
+	p.metrics.lpmRequestDuration = p.r.SummaryVec(
+		reporter.SummaryOpts{
+			Name:       "lpm_request_duration_seconds",
+			Help:       "Duration of LPM requests per RIS.",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		},
+		[]string{"ris"},
+	)
+	p.metrics.lpmFailovers = p.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "lpm_failover_requests_total",
+			Help: "Number of LPM requests sent to a RIS after a failure of the previous one.",
+		},
+		[]string{"ris"},
+	)
 	p.metrics.routerChosenAgentIDMatch = p.r.CounterVec(
 		reporter.CounterOpts{
 			Name: "router_agentid_requests_total",
@@ -8,9 +8,11 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"math/rand"
 	"net/netip"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	pb "github.com/bio-routing/bio-rd/cmd/ris/api"
@@ -44,6 +46,8 @@ type RISInstanceRuntime struct {
 	conn   *grpc.ClientConn
 	client pb.RoutingInformationServiceClient
 	config RISInstance
+	// down is set when the connection to the instance is failing
+	down atomic.Bool
 }
 
 // Provider represents the BioRIS routing provider.
@@ -138,6 +142,11 @@ func (p *Provider) Dial(config RISInstance) (*RISInstanceRuntime, error) {
 		conn.Close()
 		return nil, fmt.Errorf("error while opening RIS client %s", config.GRPCAddr)
 	}
+	instance := &RISInstanceRuntime{
+		config: config,
+		client: client,
+		conn:   conn,
+	}
 	p.t.Go(func() error {
 		var state connectivity.State = -1
 		for {
@@ -151,14 +160,23 @@ func (p *Provider) Dial(config RISInstance) (*RISInstanceRuntime, error) {
 				}
 				return 0
 			}())
+			// An idle connection is reconnected on the next request. A
+			// connecting one is only considered down if it failed before.
+			down := state == connectivity.TransientFailure || state == connectivity.Shutdown
+			if state == connectivity.Connecting {
+				down = instance.down.Load()
+			}
+			if previous := instance.down.Swap(down); previous != down {
+				if down {
+					p.r.Warn().Str("ris", config.GRPCAddr).Msg("RIS instance is down")
+				} else {
+					p.r.Info().Str("ris", config.GRPCAddr).Msg("RIS instance is up")
+				}
+			}
 		}
 	})
 
-	return &RISInstanceRuntime{
-		config: config,
-		client: client,
-		conn:   conn,
-	}, nil
+	return instance, nil
 }
 
 // Refresh retrieves the list of routers
@@ -216,9 +234,9 @@ func (p *Provider) Lookup(ctx context.Context, ip netip.Addr, _ netip.Addr, agen
 }
 
 // chooseRouter selects the router ID best suited for the given agent ip. It
-// returns router ID and RIS instance.
-func (p *Provider) chooseRouter(agent netip.Addr) (netip.Addr, *RISInstanceRuntime, error) {
-	var chosenRis *RISInstanceRuntime
+// returns router ID and the RIS instances to query, in order. Only healthy
+// instances are returned, ordered according to the configured selection.
+func (p *Provider) chooseRouter(agent netip.Addr, ip netip.Addr) (netip.Addr, []*RISInstanceRuntime, error) {
 	chosenRouterID := netip.IPv4Unspecified()
 	exactMatch := false
 	// We try all routers
@@ -237,13 +255,27 @@ func (p *Provider) chooseRouter(agent netip.Addr) (netip.Addr, *RISInstanceRunti
 		return chosenRouterID, nil, errNoRouter
 	}
 
-	// Randomly select a ris providing the router ID we selected earlier.
-	// In the future, we might also want to exclude currently unavailable ris instances
-	chosenRis = p.routers[chosenRouterID][rand.Intn(len(p.routers[chosenRouterID]))]
-
-	if chosenRis == nil || chosenRouterID.IsUnspecified() {
+	// Select the healthy RIS instances providing the router ID we selected
+	// earlier. They are in the configured order.
+	candidates := make([]*RISInstanceRuntime, 0, len(p.routers[chosenRouterID]))
+	for _, ris := range p.routers[chosenRouterID] {
+		if ris != nil && !ris.down.Load() {
+			candidates = append(candidates, ris)
+		}
+	}
+	if len(candidates) == 0 {
 		return chosenRouterID, nil, errNoInstance
 	}
+	switch p.config.Selection {
+	case InstanceSelectionRandom:
+		rand.Shuffle(len(candidates), func(i, j int) {
+			candidates[i], candidates[j] = candidates[j], candidates[i]
+		})
+	case InstanceSelectionPrefixHash:
+		first := int(prefixHash(ip) % uint64(len(candidates)))
+		candidates = append(candidates[first:], candidates[:first]...)
+	}
+	chosenRis := candidates[0]
 
 	// Update metrics with the chosen router/ris combination
 	if exactMatch {
@@ -252,7 +284,23 @@ func (p *Provider) chooseRouter(agent netip.Addr) (netip.Addr, *RISInstanceRunti
 		p.metrics.routerChosenFallback.WithLabelValues(chosenRis.config.GRPCAddr, chosenRouterID.Unmap().String()).Inc()
 	}
 
-	return chosenRouterID, chosenRis, nil
+	return chosenRouterID, candidates, nil
+}
+
+// prefixHash returns a hash of the /24 (for IPv4) or /48 (for IPv6) prefix
+// containing the provided IP address.
+func prefixHash(ip netip.Addr) uint64 {
+	bits := 48
+	if ip.Is4In6() {
+		ip = ip.Unmap()
+	}
+	if ip.Is4() {
+		bits = 24
+	}
+	prefix, _ := ip.Prefix(bits)
+	h := fnv.New64a()
+	h.Write(prefix.Addr().AsSlice())
+	return h.Sum64()
 }
 
 func (p *Provider) lpmResponseToLookupResult(lpm *pb.LPMResponse) (bmp.LookupResult, error) {
@@ -305,7 +353,11 @@ func (p *Provider) lpmResponseToLookupResult(lpm *pb.LPMResponse) (bmp.LookupRes
 		}
 	}
 
+	res.LocalPref = pfx.BgpPath.GetLocalPref()
+	res.MED = pfx.BgpPath.GetMed()
+
 	res.NetMask = uint8(r.Pfx.GetLength())
+	res.Found = true
 	nh := pfx.BgpPath.GetNextHop()
 	if nh != nil {
 		bnh := bnet.IPFromProtoIP(nh)
@@ -319,10 +371,12 @@ func (p *Provider) lpmResponseToLookupResult(lpm *pb.LPMResponse) (bmp.LookupRes
 	return res, nil
 }
 
-// lookupLPM does an lookupLPM GRPC call to a BioRis instance
+// lookupLPM does an lookupLPM GRPC call to a BioRis instance. When the request
+// fails, the next healthy instance is tried, as long as the timeout is not
+// reached.
 func (p *Provider) lookupLPM(ctx context.Context, ip netip.Addr, agent netip.Addr) (*pb.LPMResponse, error) {
 	// Choose router id and ris
-	chosenRouterID, chosenRis, err := p.chooseRouter(agent)
+	chosenRouterID, candidates, err := p.chooseRouter(agent, ip)
 	if err != nil {
 		return nil, err
 	}
@@ -336,21 +390,40 @@ func (p *Provider) lookupLPM(ctx context.Context, ip netip.Addr, agent netip.Add
 	if !ipAddr.IsIPv4() {
 		pfxLen = 128
 	}
-	pfx := bnet.NewPfx(ipAddr, pfxLen)
-
-	p.metrics.lpmRequests.WithLabelValues(chosenRis.config.GRPCAddr, chosenRouterID.Unmap().String()).Inc()
 
 	clientDeadline := time.Now().Add(p.config.Timeout)
 	ctx, cancel := context.WithDeadline(ctx, clientDeadline)
 	defer cancel()
 
-	var res *pb.LPMResponse
-	res, err = chosenRis.client.LPM(ctx, &pb.LPMRequest{
+	for idx, chosenRis := range candidates {
+		if idx > 0 {
+			p.metrics.lpmFailovers.WithLabelValues(chosenRis.config.GRPCAddr).Inc()
+		}
+		var res *pb.LPMResponse
+		res, err = p.lookupLPMOnInstance(ctx, chosenRis, chosenRouterID, ipAddr, pfxLen)
+		if err == nil {
+			return res, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, err
+}
+
+// lookupLPMOnInstance does an LPM GRPC call to the provided BioRIS instance.
+func (p *Provider) lookupLPMOnInstance(ctx context.Context, chosenRis *RISInstanceRuntime, chosenRouterID netip.Addr, ipAddr bnet.IP, pfxLen uint8) (*pb.LPMResponse, error) {
+	pfx := bnet.NewPfx(ipAddr, pfxLen)
+	p.metrics.lpmRequests.WithLabelValues(chosenRis.config.GRPCAddr, chosenRouterID.Unmap().String()).Inc()
+
+	start := time.Now()
+	res, err := chosenRis.client.LPM(ctx, &pb.LPMRequest{
 		Router: chosenRouterID.Unmap().String(),
 		VrfId:  chosenRis.config.VRFId,
 		Vrf:    chosenRis.config.VRF,
 		Pfx:    pfx.ToProto(),
 	})
+	p.metrics.lpmRequestDuration.WithLabelValues(chosenRis.config.GRPCAddr).Observe(time.Since(start).Seconds())
 	if errors.Is(ctx.Err(), context.Canceled) {
 		p.metrics.lpmRequestTimeouts.WithLabelValues(chosenRis.config.GRPCAddr, chosenRouterID.Unmap().String()).Inc()
 		return nil, errors.New("lpm lookup timeout")
@@ -5,6 +5,7 @@ package bioris
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/netip"
@@ -42,7 +43,7 @@ func TestChooseRouter(t *testing.T) {
 	// First test: we have no routers/ris instances and fail with an error
 	t.Run("no router", func(t *testing.T) {
 		expected := "no router"
-		_, _, err := c.chooseRouter(netip.MustParseAddr("10.0.0.0"))
+		_, _, err := c.chooseRouter(netip.MustParseAddr("10.0.0.0"), netip.Addr{})
 		if diff := helpers.Diff(err.Error(), expected); diff != "" {
 			t.Errorf("Error (-got, +want):\n%s", diff)
 		}
@@ -83,10 +84,11 @@ func TestChooseRouter(t *testing.T) {
 		expectedRis := []*RISInstanceRuntime{ris1, ris3}
 		expectedRouter := r1
 
-		router, ris, err := c.chooseRouter(netip.MustParseAddr("10.0.0.1"))
+		router, candidates, err := c.chooseRouter(netip.MustParseAddr("10.0.0.1"), netip.Addr{})
 		if err != nil {
-			t.Errorf("Unexpected error: %s", err)
+			t.Fatalf("Unexpected error: %s", err)
 		}
+		ris := candidates[0]
 		if diff := helpers.Diff(router, expectedRouter); diff != "" {
 			t.Errorf("Router (-got, +want):\n%s", diff)
 		}
@@ -99,10 +101,11 @@ func TestChooseRouter(t *testing.T) {
 		expectedRis := []*RISInstanceRuntime{ris2}
 		expectedRouter := r2
 
-		router, ris, err := c.chooseRouter(netip.MustParseAddr("10.0.0.2"))
+		router, candidates, err := c.chooseRouter(netip.MustParseAddr("10.0.0.2"), netip.Addr{})
 		if err != nil {
-			t.Errorf("Unexpected error: %s", err)
+			t.Fatalf("Unexpected error: %s", err)
 		}
+		ris := candidates[0]
 		if diff := helpers.Diff(router, expectedRouter); diff != "" {
 			t.Errorf("Router (-got, +want):\n%s", diff)
 		}
@@ -114,10 +117,11 @@ func TestChooseRouter(t *testing.T) {
 		expectedRis := []*RISInstanceRuntime{ris1, ris2, ris3}
 		expectedRouter := []netip.Addr{r1, r2, r3, r4, r5}
 
-		router, ris, err := c.chooseRouter(netip.MustParseAddr("9.9.9.9"))
+		router, candidates, err := c.chooseRouter(netip.MustParseAddr("9.9.9.9"), netip.Addr{})
 		if err != nil {
-			t.Errorf("Unexpected error: %s", err)
+			t.Fatalf("Unexpected error: %s", err)
 		}
+		ris := candidates[0]
 		if !slices.Contains(expectedRouter, router) {
 			t.Errorf("Unexpected router: %s", router)
 		}
@@ -125,6 +129,62 @@ func TestChooseRouter(t *testing.T) {
 			t.Errorf("Unexpected ris instance: %s", ris.config.GRPCAddr)
 		}
 	})
+	t.Run("preferred", func(t *testing.T) {
+		c.config.Selection = InstanceSelectionPreferred
+		defer func() { c.config.Selection = InstanceSelectionRandom }()
+		for range 10 {
+			_, candidates, err := c.chooseRouter(r1, netip.Addr{})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if diff := helpers.Diff(candidates, []*RISInstanceRuntime{ris1, ris3}); diff != "" {
+				t.Fatalf("chooseRouter() (-got, +want):\n%s", diff)
+			}
+		}
+	})
+	t.Run("preferred is down", func(t *testing.T) {
+		c.config.Selection = InstanceSelectionPreferred
+		ris1.down.Store(true)
+		defer func() {
+			c.config.Selection = InstanceSelectionRandom
+			ris1.down.Store(false)
+		}()
+		_, candidates, err := c.chooseRouter(r1, netip.Addr{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if diff := helpers.Diff(candidates, []*RISInstanceRuntime{ris3}); diff != "" {
+			t.Fatalf("chooseRouter() (-got, +want):\n%s", diff)
+		}
+		_, _, err = c.chooseRouter(r5, netip.Addr{})
+		if !errors.Is(err, errNoInstance) {
+			t.Fatalf("chooseRouter() error:\n%+v", err)
+		}
+	})
+	t.Run("prefix hash", func(t *testing.T) {
+		c.config.Selection = InstanceSelectionPrefixHash
+		defer func() { c.config.Selection = InstanceSelectionRandom }()
+		seen := map[*RISInstanceRuntime]bool{}
+		for i := range 50 {
+			ip := netip.AddrFrom4([4]byte{198, 51, byte(i), 1})
+			_, candidates1, err := c.chooseRouter(r1, ip)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			// Same prefix, same instance
+			_, candidates2, _ := c.chooseRouter(r1, netip.AddrFrom4([4]byte{198, 51, byte(i), 200}))
+			if diff := helpers.Diff(candidates1, candidates2); diff != "" {
+				t.Fatalf("chooseRouter() (-got, +want):\n%s", diff)
+			}
+			if len(candidates1) != 2 {
+				t.Fatalf("chooseRouter() returned %d candidates, expected 2", len(candidates1))
+			}
+			seen[candidates1[0]] = true
+		}
+		if len(seen) != 2 {
+			t.Errorf("chooseRouter() used %d instances, expected 2", len(seen))
+		}
+	})
 }
 
 func TestLPMResponseToLookupResult(t *testing.T) {
@@ -232,6 +292,7 @@ func TestLPMResponseToLookupResult(t *testing.T) {
 				},
 			},
 			expected: provider.LookupResult{
+				Found:            true,
 				ASN:              456,
 				ASPath:           []uint32{123, 456},
 				Communities:      []uint32{123},
@@ -261,6 +322,7 @@ func TestLPMResponseToLookupResult(t *testing.T) {
 				},
 			},
 			expected: provider.LookupResult{
+				Found:   true,
 				NetMask: 32,
 			},
 			err: "",
@@ -298,6 +360,7 @@ func TestLPMResponseToLookupResult(t *testing.T) {
 				},
 			},
 			expected: provider.LookupResult{
+				Found:            true,
 				ASN:              456,
 				ASPath:           []uint32{123, 456},
 				Communities:      []uint32{123},
@@ -443,6 +506,7 @@ func TestBioRIS(t *testing.T) {
 			t.Fatalf("Lookup() error:\n%+v", err)
 		}
 		expected := provider.LookupResult{
+			Found:       true,
 			NetMask:     64,
 			ASN:         174,
 			ASPath:      []uint32{65017, 65013, 174, 174, 174},
@@ -462,10 +526,12 @@ func TestBioRIS(t *testing.T) {
 	}
 
 	for try := 2; try >= 0; try-- {
-		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bioris_")
+		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bioris_",
+			"-lpm_request_duration_seconds{", "-lpm_request_duration_seconds_sum")
 		expectedMetrics := map[string]string{
 			// connection_up may take a bit of time
 			fmt.Sprintf(`connection_up{ris="%s"}`, addr):                                     "1",
+			fmt.Sprintf(`lpm_request_duration_seconds_count{ris="%s"}`, addr):                "1",
 			fmt.Sprintf(`known_routers_total{ris="%s"}`, addr):                               "1",
 			fmt.Sprintf(`lpm_request_errors_total{ris="%s",router="127.0.0.1"}`, addr):       "0",
 			fmt.Sprintf(`lpm_success_requests_total{ris="%s",router="127.0.0.1"}`, addr):     "1",
@@ -484,6 +550,39 @@ func TestBioRIS(t *testing.T) {
 			break
 		}
 	}
+
+	// Failover from a non-working instance
+	{
+		closedListener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Listen() error:\n%+v", err)
+		}
+		closedAddr := closedListener.Addr().String()
+		closedListener.Close()
+		c := p.(*Provider)
+		bad, err := c.Dial(RISInstance{GRPCAddr: closedAddr})
+		if err != nil {
+			t.Fatalf("Dial() error:\n%+v", err)
+		}
+		c.mu.Lock()
+		c.config.Selection = InstanceSelectionPreferred
+		c.instances[closedAddr] = bad
+		for router, instances := range c.routers {
+			c.routers[router] = append([]*RISInstanceRuntime{bad}, instances...)
+		}
+		c.mu.Unlock()
+
+		got, err := p.Lookup(context.Background(),
+			netip.MustParseAddr("2001:db8:1::10"),
+			netip.Addr{},
+			netip.MustParseAddr("2001:db8::7"))
+		if err != nil {
+			t.Fatalf("Lookup() error:\n%+v", err)
+		}
+		if got.ASN != 174 {
+			t.Errorf("Lookup() == %d, expected 174", got.ASN)
+		}
+	}
 }
 
 func TestNonWorkingBioRIS(t *testing.T) {
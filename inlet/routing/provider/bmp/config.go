@@ -4,8 +4,10 @@
 package bmp
 
 import (
+	"errors"
 	"time"
 
+	"akvorado/common/helpers/bimap"
 	"akvorado/inlet/routing/provider"
 )
 
@@ -22,8 +24,15 @@ type Configuration struct {
 	CollectASPaths bool
 	// CollectCommunities is true when we want to collect communities
 	CollectCommunities bool
+	// CollectLocalPref is true when we want to collect local preferences
+	CollectLocalPref bool
+	// CollectMED is true when we want to collect multi-exit discriminators
+	CollectMED bool
 	// Keep tells how long to keep routes from a BMP client when it goes down
 	Keep time.Duration `validate:"min=1s"`
+	// PeerDownKeep tells how long to keep routes from a peer when it goes
+	// down (0 to remove them immediately)
+	PeerDownKeep time.Duration `validate:"min=0"`
 	// RIBPeerRemovalMaxTime tells the maximum time the removal worker should run to remove a peer
 	RIBPeerRemovalMaxTime time.Duration `validate:"min=10ms"`
 	// RIBPeerRemovalSleepInterval tells how much time to sleep between two runs of the removal worker
@@ -34,6 +43,17 @@ type Configuration struct {
 	// if we have a higher priority request. This is only if RIB is in memory
 	// mode.
 	RIBPeerRemovalBatchRoutes int `validate:"min=1"`
+	// PathSelection tells how to select a path when several paths are
+	// available for a prefix (notably with ADD-PATH)
+	PathSelection PathSelection
+	// RIBSnapshotFile defines a file to store the RIB and survive restarts
+	RIBSnapshotFile string
+	// RIBSnapshotInterval defines how often to store the RIB (0 to store it
+	// only on shutdown)
+	RIBSnapshotInterval time.Duration `validate:"eq=0|min=1m"`
+	// RIBSnapshotKeep tells how long to keep routes loaded from the snapshot
+	// when peers do not send an End-of-RIB marker
+	RIBSnapshotKeep time.Duration `validate:"min=1s"`
 }
 
 // DefaultConfiguration represents the default configuration for the BMP server
@@ -48,5 +68,49 @@ func DefaultConfiguration() provider.Configuration {
 		RIBPeerRemovalSleepInterval: 500 * time.Millisecond,
 		RIBPeerRemovalMaxQueue:      10000,
 		RIBPeerRemovalBatchRoutes:   5000,
+		RIBSnapshotInterval:         10 * time.Minute,
+		RIBSnapshotKeep:             10 * time.Minute,
 	}
 }
+
+// PathSelection represents a rule to select a path among several paths for the
+// same prefix.
+type PathSelection int
+
+const (
+	// PathSelectionLowestPathID selects the path with the lowest path ID.
+	PathSelectionLowestPathID PathSelection = iota
+	// PathSelectionBest selects the path with the highest local preference,
+	// then the shortest AS path, then the lowest path ID.
+	PathSelectionBest
+)
+
+var pathSelectionMap = bimap.New(map[PathSelection]string{
+	PathSelectionLowestPathID: "lowest-path-id",
+	PathSelectionBest:         "best",
+})
+
+// MarshalText turns a path selection to text.
+func (ps PathSelection) MarshalText() ([]byte, error) {
+	got, ok := pathSelectionMap.LoadValue(ps)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown path selection")
+}
+
+// String turns a path selection to string.
+func (ps PathSelection) String() string {
+	got, _ := pathSelectionMap.LoadValue(ps)
+	return got
+}
+
+// UnmarshalText provides a path selection from a string.
+func (ps *PathSelection) UnmarshalText(input []byte) error {
+	got, ok := pathSelectionMap.LoadKey(string(input))
+	if ok {
+		*ps = got
+		return nil
+	}
+	return errors.New("unknown path selection")
+}
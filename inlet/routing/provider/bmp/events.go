@@ -21,6 +21,7 @@ type peerKey struct {
 	distinguisher RD             // peer distinguisher
 	asn           uint32         // peer ASN
 	bgpID         uint32         // peer router ID
+	detached      uint32         // non-zero when detached from its session
 }
 
 // peerInfo contains some information attached to a peer.
@@ -28,6 +29,9 @@ type peerInfo struct {
 	reference          uint32                   // used as a reference in the RIB
 	staleUntil         time.Time                // when to remove because it is stale
 	marshallingOptions []*bgp.MarshallingOption // decoding option (add-path mostly)
+	routes             int                      // number of routes in the RIB
+	lastUpdate         time.Time                // last route monitoring message
+	removing           bool                     // removal has been requested
 }
 
 // peerKeyFromBMPPeerHeader computes the peer key from the BMP peer header.
@@ -48,7 +52,7 @@ func peerKeyFromBMPPeerHeader(exporter netip.AddrPort, header *bmp.BMPPeerHeader
 func (p *Provider) scheduleStalePeersRemoval() {
 	var next time.Time
 	for _, pinfo := range p.peers {
-		if pinfo.staleUntil.IsZero() {
+		if pinfo.staleUntil.IsZero() || pinfo.removing {
 			continue
 		}
 		if next.IsZero() || pinfo.staleUntil.Before(next) {
@@ -97,6 +101,12 @@ func (p *Provider) addPeer(pkey peerKey) *peerInfo {
 
 // removePeer remove a peer (with lock held)
 func (p *Provider) removePeer(pkey peerKey, reason string) {
+	if pinfo := p.peers[pkey]; pinfo != nil {
+		if pinfo.removing {
+			return
+		}
+		pinfo.removing = true
+	}
 	exporterStr := pkey.exporter.Addr().Unmap().String()
 	peerStr := pkey.ip.Unmap().String()
 	p.r.Info().Msgf("remove peer %s for exporter %s (reason: %s)", peerStr, exporterStr, reason)
@@ -114,21 +124,76 @@ func (p *Provider) removePeer(pkey peerKey, reason string) {
 	p.mu.Lock()
 }
 
+// markPeerAsStale marks a peer as stale. Its routes are still used until it
+// is removed, but routes from live peers are preferred. This should be called
+// with the lock held.
+func (p *Provider) markPeerAsStale(pkey peerKey, pinfo *peerInfo, until time.Time) {
+	pinfo.staleUntil = until
+	if _, ok := p.stalePeers[pinfo.reference]; !ok {
+		p.stalePeers[pinfo.reference] = struct{}{}
+		exporterStr := pkey.exporter.Addr().Unmap().String()
+		p.metrics.staleRoutes.WithLabelValues(exporterStr).Add(float64(pinfo.routes))
+	}
+}
+
 // markExporterAsStale marks all peers from an exporter as stale.
 func (p *Provider) markExporterAsStale(exporter netip.AddrPort, until time.Time) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	for pkey, pinfo := range p.peers {
-		if pkey.exporter != exporter {
+		if pkey.exporter != exporter || !pinfo.staleUntil.IsZero() {
 			continue
 		}
-		pinfo.staleUntil = until
+		p.markPeerAsStale(pkey, pinfo, until)
 	}
 	p.scheduleStalePeersRemoval()
 }
 
+// detachPeer detaches a peer from its session and marks it as stale. A new
+// peer with the same key can then be added. This should be called with the
+// lock held.
+func (p *Provider) detachPeer(pkey peerKey, until time.Time) {
+	pinfo := p.peers[pkey]
+	delete(p.peers, pkey)
+	pkey.detached = pinfo.reference
+	p.peers[pkey] = pinfo
+	p.markPeerAsStale(pkey, pinfo, until)
+}
+
+// removeReplacedPeers removes the stale peers replaced by the provided live
+// peer once it has sent its whole table. This should be called with the lock
+// held.
+func (p *Provider) removeReplacedPeers(pkey peerKey) {
+	session := func(pkey peerKey) peerKey {
+		pkey.exporter = netip.AddrPortFrom(pkey.exporter.Addr(), 0)
+		pkey.detached = 0
+		return pkey
+	}
+	replaced := []peerKey{}
+	for okey, oinfo := range p.peers {
+		if okey == pkey || oinfo.staleUntil.IsZero() || session(okey) != session(pkey) {
+			continue
+		}
+		replaced = append(replaced, okey)
+	}
+	for _, okey := range replaced {
+		p.removePeer(okey, "replaced")
+	}
+}
+
+// updateRouteCount updates the number of routes of a peer and the associated
+// metrics. This should be called with the lock held.
+func (p *Provider) updateRouteCount(pkey peerKey, pinfo *peerInfo, delta int) {
+	exporterStr := pkey.exporter.Addr().Unmap().String()
+	pinfo.routes += delta
+	p.metrics.routes.WithLabelValues(exporterStr).Add(float64(delta))
+	if _, ok := p.stalePeers[pinfo.reference]; ok {
+		p.metrics.staleRoutes.WithLabelValues(exporterStr).Add(float64(delta))
+	}
+}
+
 // handlePeerDownNotification handles a peer-down notification by
-// removing the peer.
+// removing the peer or, if configured, by keeping its routes for some time.
 func (p *Provider) handlePeerDownNotification(pkey peerKey) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -139,6 +204,11 @@ func (p *Provider) handlePeerDownNotification(pkey peerKey) {
 			pkey.ip.Unmap().String())
 		return
 	}
+	if p.config.PeerDownKeep > 0 {
+		p.detachPeer(pkey, p.d.Clock.Now().Add(p.config.PeerDownKeep))
+		p.scheduleStalePeersRemoval()
+		return
+	}
 	p.removePeer(pkey, "down")
 }
 
@@ -250,13 +320,35 @@ func (p *Provider) handleRouteMonitoring(pkey peerKey, body *bmp.BMPRouteMonitor
 		p.metrics.peers.WithLabelValues(exporterStr).Inc()
 		pinfo = p.addPeer(pkey)
 	}
+	pinfo.lastUpdate = p.d.Clock.Now()
+
+	// Once a live peer has sent its whole table, remove the stale peers it
+	// replaces (previous session or snapshot).
+	if isEndOfRIB(update) {
+		if len(p.stalePeers) > 0 {
+			p.removeReplacedPeers(pkey)
+		}
+		return
+	}
 
 	var nh netip.Addr
 	var rta routeAttributes
+	if p.config.PathSelection == PathSelectionBest {
+		// Default local preference when the attribute is missing
+		rta.localPref = 100
+	}
 	for _, attr := range update.PathAttributes {
 		switch attr := attr.(type) {
 		case *bgp.PathAttributeNextHop:
 			nh, _ = netip.AddrFromSlice(attr.Value.To16())
+		case *bgp.PathAttributeLocalPref:
+			if p.config.PathSelection == PathSelectionBest || p.config.CollectLocalPref {
+				rta.localPref = attr.Value
+			}
+		case *bgp.PathAttributeMultiExitDisc:
+			if p.config.CollectMED {
+				rta.med = attr.Value
+			}
 		case *bgp.PathAttributeAsPath:
 			if p.config.CollectASNs || p.config.CollectASPaths {
 				rta.asPath = asPathFlat(attr)
@@ -420,7 +512,22 @@ func (p *Provider) handleRouteMonitoring(pkey peerKey, body *bmp.BMPRouteMonitor
 		}
 	}
 
-	p.metrics.routes.WithLabelValues(exporterStr).Add(float64(added - removed))
+	p.updateRouteCount(pkey, pinfo, added-removed)
+}
+
+// isEndOfRIB tells if a BGP update is an End-of-RIB marker (RFC 4724).
+func isEndOfRIB(update *bgp.BGPUpdate) bool {
+	if len(update.NLRI) > 0 || len(update.WithdrawnRoutes) > 0 {
+		return false
+	}
+	switch len(update.PathAttributes) {
+	case 0:
+		return true
+	case 1:
+		attr, ok := update.PathAttributes[0].(*bgp.PathAttributeMpUnreachNLRI)
+		return ok && len(attr.Value) == 0
+	}
+	return false
 }
 
 func (p *Provider) isAcceptedRD(rd RD) bool {
@@ -0,0 +1,203 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package bmp
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kentik/patricia"
+	"github.com/osrg/gobgp/v3/pkg/packet/bmp"
+)
+
+type peerStatus struct {
+	Exporter   string     `json:"exporter"`
+	Peer       string     `json:"peer"`
+	ASN        uint32     `json:"asn"`
+	Type       string     `json:"type"`
+	RD         RD         `json:"rd,omitempty"`
+	State      string     `json:"state"`
+	Routes     int        `json:"routes"`
+	LastUpdate *time.Time `json:"last-update,omitempty"`
+	StaleUntil *time.Time `json:"stale-until,omitempty"`
+}
+
+type routeStatus struct {
+	Selected         bool       `json:"selected"`
+	Peer             peerStatus `json:"peer"`
+	ASN              uint32     `json:"asn"`
+	ASPath           []uint32   `json:"as-path"`
+	Communities      []string   `json:"communities"`
+	LargeCommunities []string   `json:"large-communities"`
+	NextHop          string     `json:"next-hop"`
+	LocalPref        uint32     `json:"local-pref,omitempty"`
+	MED              uint32     `json:"med,omitempty"`
+	PathID           uint32     `json:"path-id,omitempty"`
+	RD               RD         `json:"rd,omitempty"`
+}
+
+// peerStatusFromPeer builds the status of a peer. This should be called with
+// the lock held.
+func (p *Provider) peerStatusFromPeer(pkey peerKey, pinfo *peerInfo) peerStatus {
+	status := peerStatus{
+		Exporter: pkey.exporter.Addr().Unmap().String(),
+		Peer:     pkey.ip.Unmap().String(),
+		ASN:      pkey.asn,
+		RD:       pkey.distinguisher,
+		State:    "up",
+		Routes:   pinfo.routes,
+	}
+	switch pkey.ptype {
+	case bmp.BMP_PEER_TYPE_GLOBAL:
+		status.Type = "global"
+	case bmp.BMP_PEER_TYPE_L3VPN:
+		status.Type = "l3vpn"
+	default:
+		status.Type = fmt.Sprintf("type-%d", pkey.ptype)
+	}
+	if _, ok := p.stalePeers[pinfo.reference]; ok {
+		status.State = "stale"
+	}
+	if pinfo.removing {
+		status.State = "removing"
+	}
+	if !pinfo.lastUpdate.IsZero() {
+		lastUpdate := pinfo.lastUpdate.UTC()
+		status.LastUpdate = &lastUpdate
+	}
+	if !pinfo.staleUntil.IsZero() {
+		staleUntil := pinfo.staleUntil.UTC()
+		status.StaleUntil = &staleUntil
+	}
+	return status
+}
+
+// peersHTTPHandler lists the BMP peers with their state and their number of
+// routes.
+func (p *Provider) peersHTTPHandler(gc *gin.Context) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	pkeys := make([]peerKey, 0, len(p.peers))
+	for pkey := range p.peers {
+		pkeys = append(pkeys, pkey)
+	}
+	slices.SortFunc(pkeys, func(a, b peerKey) int {
+		if n := a.exporter.Compare(b.exporter); n != 0 {
+			return n
+		}
+		if n := a.ip.Compare(b.ip); n != 0 {
+			return n
+		}
+		if n := cmp.Compare(a.distinguisher, b.distinguisher); n != 0 {
+			return n
+		}
+		return cmp.Compare(a.detached, b.detached)
+	})
+	result := make([]peerStatus, 0, len(pkeys))
+	for _, pkey := range pkeys {
+		result = append(result, p.peerStatusFromPeer(pkey, p.peers[pkey]))
+	}
+	gc.JSON(http.StatusOK, gin.H{"peers": result})
+}
+
+// ribLookupHTTPHandler returns the routes for the most specific prefix
+// matching the provided IP address. Optionally, only the routes received from
+// the provided router (exporter) or with the provided route distinguisher are
+// considered.
+func (p *Provider) ribLookupHTTPHandler(gc *gin.Context) {
+	ip, err := netip.ParseAddr(gc.Query("ip"))
+	if err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "Invalid IP address."})
+		return
+	}
+	ip = netip.AddrFrom16(ip.As16())
+	var router netip.Addr
+	if routerStr := gc.Query("router"); routerStr != "" {
+		router, err = netip.ParseAddr(routerStr)
+		if err != nil {
+			gc.JSON(http.StatusBadRequest, gin.H{"message": "Invalid router IP address."})
+			return
+		}
+		router = router.Unmap()
+	}
+	var rd *RD
+	if rdStr := gc.Query("rd"); rdStr != "" {
+		rd = new(RD)
+		if err := rd.UnmarshalText([]byte(rdStr)); err != nil {
+			gc.JSON(http.StatusBadRequest, gin.H{"message": "Invalid route distinguisher."})
+			return
+		}
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	peers := make(map[uint32]peerKey, len(p.peers))
+	for pkey, pinfo := range p.peers {
+		peers[pinfo.reference] = pkey
+	}
+	var routes []route
+	v6 := patricia.NewIPv6Address(ip.AsSlice(), 128)
+	if !router.IsValid() && rd == nil {
+		_, routes = p.rib.tree.FindDeepestTags(v6)
+	} else {
+		routes = p.deepestRoutes(v6, func(r route) bool {
+			if router.IsValid() && peers[r.peer].exporter.Addr().Unmap() != router {
+				return false
+			}
+			return rd == nil || p.rib.nlris.Get(r.nlri).rd == *rd
+		})
+	}
+	if len(routes) == 0 {
+		gc.JSON(http.StatusNotFound, gin.H{"message": "No route found."})
+		return
+	}
+
+	selected, _ := p.selectRoute(routes, netip.Addr{})
+	result := make([]routeStatus, 0, len(routes))
+	for idx, route := range routes {
+		attributes := p.rib.rtas.Get(route.attributes)
+		nlri := p.rib.nlris.Get(route.nlri)
+		status := routeStatus{
+			Selected:         idx == selected,
+			ASN:              attributes.asn,
+			ASPath:           attributes.asPath,
+			Communities:      make([]string, 0, len(attributes.communities)),
+			LargeCommunities: make([]string, 0, len(attributes.largeCommunities)),
+			NextHop:          netip.Addr(p.rib.nextHops.Get(route.nextHop)).Unmap().String(),
+			LocalPref:        attributes.localPref,
+			MED:              attributes.med,
+			PathID:           nlri.path,
+			RD:               nlri.rd,
+		}
+		if status.ASPath == nil {
+			status.ASPath = []uint32{}
+		}
+		for _, community := range attributes.communities {
+			status.Communities = append(status.Communities,
+				fmt.Sprintf("%d:%d", community>>16, community&0xffff))
+		}
+		for _, community := range attributes.largeCommunities {
+			status.LargeCommunities = append(status.LargeCommunities,
+				fmt.Sprintf("%d:%d:%d", community.ASN, community.LocalData1, community.LocalData2))
+		}
+		if pkey, ok := peers[route.peer]; ok {
+			status.Peer = p.peerStatusFromPeer(pkey, p.peers[pkey])
+		}
+		result = append(result, status)
+	}
+	plen := int(p.rib.rtas.Get(routes[0].attributes).plen)
+	prefix := netip.PrefixFrom(ip, plen).Masked()
+	if ip.Is4In6() {
+		prefix = netip.PrefixFrom(ip.Unmap(), plen-96).Masked()
+	}
+	gc.JSON(http.StatusOK, gin.H{
+		"prefix": prefix.String(),
+		"routes": result,
+	})
+}
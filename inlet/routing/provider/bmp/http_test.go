@@ -0,0 +1,120 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package bmp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/gin-gonic/gin"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/httpserver"
+	"akvorado/common/reporter"
+)
+
+func TestHTTPEndpoints(t *testing.T) {
+	r := reporter.NewMock(t)
+	h := httpserver.NewMock(t, r)
+	mockClock := clock.NewMock()
+	config := DefaultConfiguration().(Configuration)
+	config.Listen = "127.0.0.1:0"
+	pp, err := config.New(r, Dependencies{
+		Daemon: daemon.NewMock(t),
+		Clock:  mockClock,
+		HTTP:   h,
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	p := pp.(*Provider)
+	p.PopulateRIB(t)
+	mockClock.Add(time.Hour)
+	for _, pinfo := range p.peers {
+		pinfo.routes = 7
+		pinfo.lastUpdate = mockClock.Now()
+	}
+
+	peer := gin.H{
+		"exporter":    "127.0.0.1",
+		"peer":        "203.0.113.4",
+		"asn":         64500,
+		"type":        "global",
+		"state":       "up",
+		"routes":      7,
+		"last-update": "1970-01-01T01:00:00Z",
+	}
+	route1 := gin.H{
+		"selected":          true,
+		"peer":              peer,
+		"asn":               174,
+		"as-path":           []int{64200, 1299, 174},
+		"communities":       []string{"0:100", "0:200", "0:400"},
+		"large-communities": []string{"64200:2:3"},
+		"next-hop":          "198.51.100.4",
+		"path-id":           1,
+	}
+	route2 := gin.H{
+		"selected":          false,
+		"peer":              peer,
+		"asn":               174,
+		"as-path":           []int{64200, 174, 174, 174},
+		"communities":       []string{"0:100"},
+		"large-communities": []string{},
+		"next-hop":          "198.51.100.8",
+		"path-id":           2,
+	}
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			URL:        "/api/v0/inlet/routing/peers",
+			JSONOutput: gin.H{"peers": []gin.H{peer}},
+		}, {
+			URL: "/api/v0/inlet/routing/rib/lookup?ip=192.0.2.10",
+			JSONOutput: gin.H{
+				"prefix": "192.0.2.0/27",
+				"routes": []gin.H{route1, route2},
+			},
+		}, {
+			URL: "/api/v0/inlet/routing/rib/lookup?ip=192.0.2.10&router=127.0.0.1",
+			JSONOutput: gin.H{
+				"prefix": "192.0.2.0/27",
+				"routes": []gin.H{route1, route2},
+			},
+		}, {
+			URL: "/api/v0/inlet/routing/rib/lookup?ip=1.0.0.1",
+			JSONOutput: gin.H{
+				"prefix": "1.0.0.0/24",
+				"routes": []gin.H{
+					{
+						"selected":          true,
+						"peer":              peer,
+						"asn":               65300,
+						"as-path":           []int{},
+						"communities":       []string{},
+						"large-communities": []string{},
+						"next-hop":          "198.51.100.8",
+					},
+				},
+			},
+		}, {
+			URL:        "/api/v0/inlet/routing/rib/lookup?ip=192.0.2.10&router=192.0.2.99",
+			StatusCode: 404,
+			JSONOutput: gin.H{"message": "No route found."},
+		}, {
+			URL:        "/api/v0/inlet/routing/rib/lookup?ip=203.0.113.10",
+			StatusCode: 404,
+			JSONOutput: gin.H{"message": "No route found."},
+		}, {
+			URL:        "/api/v0/inlet/routing/rib/lookup?ip=192.0.2.300",
+			StatusCode: 400,
+			JSONOutput: gin.H{"message": "Invalid IP address."},
+		}, {
+			URL:        "/api/v0/inlet/routing/rib/lookup?ip=192.0.2.10&router=foo",
+			StatusCode: 400,
+			JSONOutput: gin.H{"message": "Invalid router IP address."},
+		},
+	})
+}
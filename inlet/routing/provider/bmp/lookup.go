@@ -19,11 +19,20 @@ type LookupResult = provider.LookupResult
 var errNoRouteFound = errors.New("no route found")
 
 // Lookup lookups a route for the provided IP address. It favors the
-// provided next hop if provided. This is somewhat approximate because
+// provided next hop if provided. When several paths are available, the
+// configured path selection rule is used. This is somewhat approximate because
 // we use the best route we have, while the exporter may not have this
 // best route available. The returned result should not be modified!
 // The last parameter, the agent, is ignored by this provider.
-func (p *Provider) Lookup(_ context.Context, ip netip.Addr, nh netip.Addr, _ netip.Addr) (LookupResult, error) {
+func (p *Provider) Lookup(ctx context.Context, ip netip.Addr, nh netip.Addr, agent netip.Addr) (LookupResult, error) {
+	return p.LookupVRF(ctx, ip, nh, agent, 0)
+}
+
+// LookupVRF is like Lookup, but only the routes with the provided route
+// distinguisher are considered. When none match, the routes without route
+// distinguisher (global table) are used. When the route distinguisher is 0,
+// routes are not filtered.
+func (p *Provider) LookupVRF(_ context.Context, ip netip.Addr, nh netip.Addr, _ netip.Addr, rd uint64) (LookupResult, error) {
 	if !p.config.CollectASNs && !p.config.CollectASPaths && !p.config.CollectCommunities {
 		return LookupResult{}, nil
 	}
@@ -35,30 +44,27 @@ func (p *Provider) Lookup(_ context.Context, ip netip.Addr, nh netip.Addr, _ net
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
-	bestFound := false
-	found := false
-	_, routes := p.rib.tree.FindDeepestTagsWithFilter(v6, func(route route) bool {
-		if bestFound {
-			// We already have the best route, skip remaining routes
-			return false
-		}
-		if p.rib.nextHops.Get(route.nextHop) == nextHop(nh) {
-			// Exact match found, use it and don't search further
-			bestFound = true
-			return true
-		}
-		// If we don't have a match already, use this one.
-		if !found {
-			found = true
-			return true
+	var routes []route
+	if rd == 0 {
+		_, routes = p.rib.tree.FindDeepestTags(v6)
+	} else {
+		routes = p.deepestRoutes(v6, func(r route) bool {
+			return p.rib.nlris.Get(r.nlri).rd == RD(rd)
+		})
+		if len(routes) == 0 {
+			routes = p.deepestRoutes(v6, func(r route) bool {
+				return p.rib.nlris.Get(r.nlri).rd == 0
+			})
 		}
-		// Otherwise, skip it
-		return false
-	})
-	if len(routes) == 0 {
+	}
+	selected, live := p.selectRoute(routes, nh)
+	if selected < 0 {
 		return LookupResult{}, errNoRouteFound
 	}
-	route := routes[len(routes)-1]
+	if !live {
+		p.metrics.staleLookups.Inc()
+	}
+	route := routes[selected]
 	attributes := p.rib.rtas.Get(route.attributes)
 	// The next hop is updated from the rib in every case, because the user
 	// "opted in" for bmp as source if the lookup result is evaluated
@@ -70,11 +76,70 @@ func (p *Provider) Lookup(_ context.Context, ip netip.Addr, nh netip.Addr, _ net
 		plen = plen - 96
 	}
 	return LookupResult{
+		Found:            true,
 		ASN:              attributes.asn,
 		ASPath:           attributes.asPath,
 		Communities:      attributes.communities,
 		LargeCommunities: attributes.largeCommunities,
 		NetMask:          plen,
 		NextHop:          nh,
+		LocalPref:        attributes.localPref,
+		MED:              attributes.med,
 	}, nil
 }
+
+// selectRoute selects a route among the routes for the most specific prefix.
+// It favors the ones from live peers, then the ones with the provided next
+// hop, then use the configured path selection rule. On tie, the first route is
+// kept. It returns the index of the selected route (-1 if none) and whether it
+// is from a live peer. This should be called with the lock held.
+func (p *Provider) selectRoute(routes []route, nh netip.Addr) (int, bool) {
+	selected := -1
+	selectedLive := false
+	selectedNH := false
+	for idx := range routes {
+		_, stale := p.stalePeers[routes[idx].peer]
+		live := !stale
+		matchNH := p.rib.nextHops.Get(routes[idx].nextHop) == nextHop(nh)
+		if selected >= 0 {
+			if selectedLive && !live {
+				continue
+			}
+			if selectedLive == live {
+				if selectedNH && !matchNH {
+					continue
+				}
+				if selectedNH == matchNH && !p.rib.preferRoute(routes[idx], routes[selected], p.config.PathSelection) {
+					continue
+				}
+			}
+		}
+		selected = idx
+		selectedLive = live
+		selectedNH = matchNH
+	}
+	return selected, selectedLive
+}
+
+// deepestRoutes returns the routes matching the provided filter for the most
+// specific prefix with such routes. This should be called with the lock held.
+func (p *Provider) deepestRoutes(v6 patricia.IPv6Address, filter func(route) bool) []route {
+	var routes []route
+	var current uint8
+	for _, route := range p.rib.tree.FindTags(v6) {
+		if !filter(route) {
+			continue
+		}
+		plen := p.rib.rtas.Get(route.attributes).plen
+		if len(routes) > 0 {
+			if plen < current {
+				continue
+			} else if plen > current {
+				routes = routes[:0]
+			}
+		}
+		current = plen
+		routes = append(routes, route)
+	}
+	return routes
+}
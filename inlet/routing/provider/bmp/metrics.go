@@ -10,6 +10,8 @@ type metrics struct {
 	closedConnections    *reporter.CounterVec
 	peers                *reporter.GaugeVec
 	routes               *reporter.GaugeVec
+	staleRoutes          *reporter.GaugeVec
+	staleLookups         reporter.Counter
 	ignoredNlri          *reporter.CounterVec
 	messages             *reporter.CounterVec
 	errors               *reporter.CounterVec
@@ -51,6 +53,19 @@ func (p *Provider) initMetrics() {
 		},
 		[]string{"exporter"},
 	)
+	p.metrics.staleRoutes = p.r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "stale_routes_total",
+			Help: "Number of routes from stale peers.",
+		},
+		[]string{"exporter"},
+	)
+	p.metrics.staleLookups = p.r.Counter(
+		reporter.CounterOpts{
+			Name: "stale_lookups_total",
+			Help: "Number of lookups answered with a route from a stale peer.",
+		},
+	)
 	p.metrics.ignoredNlri = p.r.CounterVec(
 		reporter.CounterOpts{
 			Name: "ignored_nlri_total",
@@ -17,7 +17,7 @@ func (p *Provider) peerRemovalWorker() error {
 			exporterStr := pkey.exporter.Addr().Unmap().String()
 			for {
 				// Do one run of removal (read/write lock)
-				removed, done, duplicate := func() (int, bool, bool) {
+				done, duplicate := func() (bool, bool) {
 					start := p.d.Clock.Now()
 					ctx, cancel := context.WithTimeout(p.t.Context(context.Background()),
 						p.config.RIBPeerRemovalMaxTime)
@@ -31,19 +31,21 @@ func (p *Provider) peerRemovalWorker() error {
 					pinfo := p.peers[pkey]
 					if pinfo == nil {
 						// Already removed (removal can be queued several times)
-						return 0, true, true
+						return true, true
 					}
 					removed, done := p.rib.flushPeerContext(ctx, pinfo.reference,
 						p.config.RIBPeerRemovalBatchRoutes)
+					p.updateRouteCount(pkey, pinfo, -removed)
 					if done {
 						// Run was complete, remove the peer (we need the lock)
 						delete(p.peers, pkey)
+						delete(p.stalePeers, pinfo.reference)
 					}
-					return removed, done, false
+					return done, false
 				}()
 
-				// Update stats and optionally sleep
-				p.metrics.routes.WithLabelValues(exporterStr).Sub(float64(removed))
+				// Update stats and optionally sleep (route count is updated
+				// with the lock held)
 				if done {
 					// Run was complete, update metrics
 					if !duplicate {
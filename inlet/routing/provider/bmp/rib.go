@@ -79,6 +79,8 @@ type routeAttributes struct {
 	asPath      []uint32
 	communities []uint32
 	plen        uint8
+	localPref   uint32
+	med         uint32
 	// extendedCommunities []uint64
 	largeCommunities []bgp.LargeCommunity
 }
@@ -88,6 +90,8 @@ type routeAttributes struct {
 func (rta routeAttributes) Hash() uint64 {
 	state := makeHash()
 	state.Add((*byte)(unsafe.Pointer(&rta.asn)), int(unsafe.Sizeof(rta.asn)))
+	state.Add((*byte)(unsafe.Pointer(&rta.localPref)), int(unsafe.Sizeof(rta.localPref)))
+	state.Add((*byte)(unsafe.Pointer(&rta.med)), int(unsafe.Sizeof(rta.med)))
 	if len(rta.asPath) > 0 {
 		state.Add((*byte)(unsafe.Pointer(&rta.asPath[0])), len(rta.asPath)*int(unsafe.Sizeof(rta.asPath[0])))
 	}
@@ -105,7 +109,7 @@ func (rta routeAttributes) Hash() uint64 {
 
 // Equal tells if two route attributes are equal.
 func (rta routeAttributes) Equal(orta routeAttributes) bool {
-	if rta.asn != orta.asn {
+	if rta.asn != orta.asn || rta.localPref != orta.localPref || rta.med != orta.med {
 		return false
 	}
 	if len(rta.asPath) != len(orta.asPath) {
@@ -153,6 +157,22 @@ func (r *rib) addPrefix(ip netip.Addr, bits int, newRoute route) int {
 	return 1
 }
 
+// preferRoute tells if the first route should be preferred over the second one
+// using the provided path selection rule. When both routes are equivalent, it
+// returns false.
+func (r *rib) preferRoute(r1, r2 route, selection PathSelection) bool {
+	if selection == PathSelectionBest {
+		a1, a2 := r.rtas.Get(r1.attributes), r.rtas.Get(r2.attributes)
+		if a1.localPref != a2.localPref {
+			return a1.localPref > a2.localPref
+		}
+		if len(a1.asPath) != len(a2.asPath) {
+			return len(a1.asPath) < len(a2.asPath)
+		}
+	}
+	return r.nlris.Get(r1.nlri).path < r.nlris.Get(r2.nlri).path
+}
+
 // removePrefix removes a route from the RIB. It returns the number of routes really removed.
 func (r *rib) removePrefix(ip netip.Addr, bits int, oldRoute route) int {
 	v6 := patricia.NewIPv6Address(ip.AsSlice(), uint(bits))
@@ -34,6 +34,7 @@ type Provider struct {
 	// RIB management with peers
 	rib               *rib
 	peers             map[peerKey]*peerInfo
+	stalePeers        map[uint32]struct{}
 	peerRemovalChan   chan peerKey
 	lastPeerReference uint32
 	staleTimer        *clock.Timer
@@ -55,6 +56,7 @@ func (configuration Configuration) New(r *reporter.Reporter, dependencies Depend
 
 		rib:             newRIB(),
 		peers:           make(map[peerKey]*peerInfo),
+		stalePeers:      make(map[uint32]struct{}),
 		peerRemovalChan: make(chan peerKey, configuration.RIBPeerRemovalMaxQueue),
 	}
 	if len(p.config.RDs) > 0 {
@@ -67,6 +69,10 @@ func (configuration Configuration) New(r *reporter.Reporter, dependencies Depend
 
 	p.d.Daemon.Track(&p.t, "inlet/bmp")
 	p.initMetrics()
+	if p.d.HTTP != nil {
+		p.d.HTTP.GinRouter.GET("/api/v0/inlet/routing/peers", p.peersHTTPHandler)
+		p.d.HTTP.GinRouter.GET("/api/v0/inlet/routing/rib/lookup", p.ribLookupHTTPHandler)
+	}
 	return &p, nil
 }
 
@@ -79,6 +85,32 @@ func (p *Provider) Start() error {
 	}
 	p.address = listener.Addr()
 
+	// Load RIB snapshot
+	if p.config.RIBSnapshotFile != "" {
+		if loaded, err := p.loadRIB(p.config.RIBSnapshotFile); err != nil {
+			p.r.Err(err).Msg("cannot load RIB snapshot, ignoring")
+		} else {
+			p.r.Info().Int("routes", loaded).Msg("RIB snapshot loaded")
+			if loaded > 0 {
+				p.active.Store(true)
+			}
+		}
+		if p.config.RIBSnapshotInterval > 0 {
+			p.t.Go(func() error {
+				ticker := p.d.Clock.Ticker(p.config.RIBSnapshotInterval)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-p.t.Dying():
+						return nil
+					case <-ticker.C:
+						p.snapshotRIB()
+					}
+				}
+			})
+		}
+	}
+
 	// Peer removal
 	p.t.Go(p.peerRemovalWorker)
 
@@ -110,6 +142,9 @@ func (p *Provider) Start() error {
 func (p *Provider) Stop() error {
 	defer func() {
 		close(p.peerRemovalChan)
+		if p.config.RIBSnapshotFile != "" {
+			p.snapshotRIB()
+		}
 		p.r.Info().Msg("BMP component stopped")
 	}()
 	p.r.Info().Msg("stopping BMP component")
@@ -18,6 +18,7 @@ import (
 	"akvorado/inlet/routing/provider"
 
 	"github.com/osrg/gobgp/v3/pkg/packet/bgp"
+	"github.com/osrg/gobgp/v3/pkg/packet/bmp"
 )
 
 func TestBMP(t *testing.T) {
@@ -86,7 +87,7 @@ func TestBMP(t *testing.T) {
 		// Init+EOR
 		send(t, conn, "bmp-init.pcap")
 		time.Sleep(20 * time.Millisecond)
-		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_")
+		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-stale_")
 		expectedMetrics := map[string]string{
 			`received_messages_total{exporter="127.0.0.1",type="initiation"}`: "1",
 			`opened_connections_total{exporter="127.0.0.1"}`:                  "1",
@@ -99,7 +100,7 @@ func TestBMP(t *testing.T) {
 
 		send(t, conn, "bmp-terminate.pcap")
 		time.Sleep(30 * time.Millisecond)
-		gotMetrics = r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration")
+		gotMetrics = r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration", "-stale_")
 		expectedMetrics = map[string]string{
 			`closed_connections_total{exporter="127.0.0.1"}`:                   "1",
 			`received_messages_total{exporter="127.0.0.1",type="initiation"}`:  "1",
@@ -124,7 +125,7 @@ func TestBMP(t *testing.T) {
 		mockClock.Add(2 * time.Hour)
 		for tries := 20; tries >= 0; tries-- {
 			time.Sleep(5 * time.Millisecond)
-			gotMetrics = r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration")
+			gotMetrics = r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration", "-stale_")
 			expectedMetrics = map[string]string{
 				`closed_connections_total{exporter="127.0.0.1"}`:                   "1",
 				`received_messages_total{exporter="127.0.0.1",type="initiation"}`:  "1",
@@ -153,7 +154,7 @@ func TestBMP(t *testing.T) {
 		send(t, conn, "bmp-peers-up.pcap")
 		send(t, conn, "bmp-eor.pcap")
 		time.Sleep(20 * time.Millisecond)
-		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration")
+		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration", "-stale_")
 		expectedMetrics := map[string]string{
 			`received_messages_total{exporter="127.0.0.1",type="initiation"}`:           "1",
 			`received_messages_total{exporter="127.0.0.1",type="peer-up-notification"}`: "4",
@@ -181,7 +182,7 @@ func TestBMP(t *testing.T) {
 		send(t, conn, "bmp-reach.pcap")
 		send(t, conn, "bmp-reach-addpath.pcap")
 		time.Sleep(20 * time.Millisecond)
-		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration")
+		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration", "-stale_")
 		expectedMetrics := map[string]string{
 			`received_messages_total{exporter="127.0.0.1",type="initiation"}`:           "1",
 			`received_messages_total{exporter="127.0.0.1",type="peer-up-notification"}`: "4",
@@ -239,7 +240,7 @@ func TestBMP(t *testing.T) {
 		send(t, conn, "bmp-init.pcap")
 		send(t, conn, "bmp-reach.pcap")
 		time.Sleep(20 * time.Millisecond)
-		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration")
+		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration", "-stale_")
 		expectedMetrics := map[string]string{
 			// Same metrics as previously, except the AddPath peer.
 			`received_messages_total{exporter="127.0.0.1",type="initiation"}`:       "1",
@@ -265,7 +266,7 @@ func TestBMP(t *testing.T) {
 		send(t, conn, "bmp-peers-up.pcap")
 		send(t, conn, "bmp-eor.pcap")
 		time.Sleep(20 * time.Millisecond)
-		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration")
+		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration", "-stale_")
 		expectedMetrics := map[string]string{
 			`received_messages_total{exporter="127.0.0.1",type="initiation"}`:           "1",
 			`received_messages_total{exporter="127.0.0.1",type="peer-up-notification"}`: "4",
@@ -293,7 +294,7 @@ func TestBMP(t *testing.T) {
 		send(t, conn, "bmp-reach.pcap")
 		send(t, conn, "bmp-peer-down.pcap")
 		time.Sleep(20 * time.Millisecond)
-		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration")
+		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration", "-stale_")
 		expectedMetrics := map[string]string{
 			`received_messages_total{exporter="127.0.0.1",type="initiation"}`:             "1",
 			`received_messages_total{exporter="127.0.0.1",type="peer-up-notification"}`:   "4",
@@ -335,6 +336,47 @@ func TestBMP(t *testing.T) {
 		}
 	})
 
+	t.Run("init, peers up, eor, reach NLRI, 1 peer down, keep", func(t *testing.T) {
+		r := reporter.NewMock(t)
+		config := DefaultConfiguration()
+		configP := config.(Configuration)
+		configP.PeerDownKeep = 5 * time.Minute
+		p, mockClock := NewMock(t, r, configP)
+		helpers.StartStop(t, p)
+		conn := dial(t, p)
+
+		send(t, conn, "bmp-init.pcap")
+		send(t, conn, "bmp-peers-up.pcap")
+		send(t, conn, "bmp-eor.pcap")
+		send(t, conn, "bmp-reach.pcap")
+		send(t, conn, "bmp-peer-down.pcap")
+		time.Sleep(20 * time.Millisecond)
+		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_",
+			"peers_total", "routes_total", "stale_routes_total", "removed_peers_total")
+		expectedMetrics := map[string]string{
+			`peers_total{exporter="127.0.0.1"}`:        "4",
+			`routes_total{exporter="127.0.0.1"}`:       "17",
+			`stale_routes_total{exporter="127.0.0.1"}`: "3",
+		}
+		if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+			t.Errorf("Metrics (-got, +want):\n%s", diff)
+		}
+
+		mockClock.Add(10 * time.Minute)
+		time.Sleep(20 * time.Millisecond)
+		gotMetrics = r.GetMetrics("akvorado_inlet_routing_provider_bmp_",
+			"peers_total", "routes_total", "stale_routes_total", "removed_peers_total")
+		expectedMetrics = map[string]string{
+			`peers_total{exporter="127.0.0.1"}`:         "3",
+			`routes_total{exporter="127.0.0.1"}`:        "14",
+			`stale_routes_total{exporter="127.0.0.1"}`:  "0",
+			`removed_peers_total{exporter="127.0.0.1"}`: "1",
+		}
+		if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+			t.Errorf("Metrics (-got, +want):\n%s", diff)
+		}
+	})
+
 	t.Run("only accept RD 65017:104", func(t *testing.T) {
 		r := reporter.NewMock(t)
 		config := DefaultConfiguration()
@@ -349,7 +391,7 @@ func TestBMP(t *testing.T) {
 		send(t, conn, "bmp-eor.pcap")
 		send(t, conn, "bmp-reach.pcap")
 		time.Sleep(20 * time.Millisecond)
-		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration")
+		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration", "-stale_")
 		expectedMetrics := map[string]string{
 			`received_messages_total{exporter="127.0.0.1",type="initiation"}`:           "1",
 			`received_messages_total{exporter="127.0.0.1",type="peer-up-notification"}`: "4",
@@ -388,7 +430,7 @@ func TestBMP(t *testing.T) {
 		send(t, conn, "bmp-eor.pcap")
 		send(t, conn, "bmp-reach.pcap")
 		time.Sleep(20 * time.Millisecond)
-		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration")
+		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration", "-stale_")
 		expectedMetrics := map[string]string{
 			`received_messages_total{exporter="127.0.0.1",type="initiation"}`:           "1",
 			`received_messages_total{exporter="127.0.0.1",type="peer-up-notification"}`: "4",
@@ -441,7 +483,7 @@ func TestBMP(t *testing.T) {
 		send(t, conn, "bmp-reach.pcap")
 		send(t, conn, "bmp-unreach.pcap")
 		time.Sleep(20 * time.Millisecond)
-		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration")
+		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration", "-stale_")
 		expectedMetrics := map[string]string{
 			`received_messages_total{exporter="127.0.0.1",type="initiation"}`:           "1",
 			`received_messages_total{exporter="127.0.0.1",type="peer-up-notification"}`: "4",
@@ -472,7 +514,7 @@ func TestBMP(t *testing.T) {
 		send(t, conn, "bmp-init.pcap")
 		send(t, conn, "bmp-l3vpn.pcap")
 		time.Sleep(20 * time.Millisecond)
-		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration")
+		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration", "-stale_")
 		expectedMetrics := map[string]string{
 			`received_messages_total{exporter="127.0.0.1",type="initiation"}`:           "1",
 			`received_messages_total{exporter="127.0.0.1",type="peer-up-notification"}`: "1",
@@ -618,7 +660,7 @@ func TestBMP(t *testing.T) {
 		send(t, conn, "bmp-eor.pcap")
 		send(t, conn, "bmp-unreach.pcap")
 		time.Sleep(20 * time.Millisecond)
-		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration")
+		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration", "-stale_")
 		expectedMetrics := map[string]string{
 			`received_messages_total{exporter="127.0.0.1",type="initiation"}`:           "1",
 			`received_messages_total{exporter="127.0.0.1",type="peer-up-notification"}`: "4",
@@ -651,7 +693,7 @@ func TestBMP(t *testing.T) {
 		send(t, conn, "bmp-unreach.pcap")
 		send(t, conn, "bmp-unreach.pcap")
 		time.Sleep(20 * time.Millisecond)
-		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration")
+		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration", "-stale_")
 		expectedMetrics := map[string]string{
 			`received_messages_total{exporter="127.0.0.1",type="initiation"}`:           "1",
 			`received_messages_total{exporter="127.0.0.1",type="peer-up-notification"}`: "4",
@@ -691,7 +733,7 @@ func TestBMP(t *testing.T) {
 		send(t, conn, "bmp-unreach.pcap")
 		send(t, conn, "bmp-unreach.pcap")
 		time.Sleep(20 * time.Millisecond)
-		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration")
+		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration", "-stale_")
 		expectedMetrics := map[string]string{
 			`received_messages_total{exporter="127.0.0.1",type="initiation"}`:           "1",
 			`received_messages_total{exporter="127.0.0.1",type="peer-up-notification"}`: "4",
@@ -721,7 +763,7 @@ func TestBMP(t *testing.T) {
 		send(t, conn, "bmp-reach.pcap")
 		send(t, conn, "bmp-eor.pcap")
 		time.Sleep(20 * time.Millisecond)
-		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration")
+		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration", "-stale_")
 		expectedMetrics := map[string]string{
 			`received_messages_total{exporter="127.0.0.1",type="initiation"}`:           "1",
 			`received_messages_total{exporter="127.0.0.1",type="peer-up-notification"}`: "4",
@@ -780,7 +822,7 @@ func TestBMP(t *testing.T) {
 		send(t, conn, "bmp-l3vpn.pcap")
 		conn.Close()
 		time.Sleep(20 * time.Millisecond)
-		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration")
+		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration", "-stale_")
 		expectedMetrics := map[string]string{
 			`received_messages_total{exporter="127.0.0.1",type="initiation"}`:           "1",
 			`received_messages_total{exporter="127.0.0.1",type="peer-up-notification"}`: "1",
@@ -808,7 +850,7 @@ func TestBMP(t *testing.T) {
 
 		mockClock.Add(2 * time.Hour)
 		time.Sleep(20 * time.Millisecond)
-		gotMetrics = r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration")
+		gotMetrics = r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration", "-stale_")
 		expectedMetrics = map[string]string{
 			`received_messages_total{exporter="127.0.0.1",type="initiation"}`:           "1",
 			`received_messages_total{exporter="127.0.0.1",type="peer-up-notification"}`: "1",
@@ -842,7 +884,7 @@ func TestBMP(t *testing.T) {
 		send(t, conn, "bmp-l3vpn.pcap")
 		send(t, conn, "bmp-reach-unknown-family.pcap")
 		time.Sleep(20 * time.Millisecond)
-		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration")
+		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration", "-stale_")
 		ignoredMetric := `ignored_updates_total{error="unknown route family. AFI: 57, SAFI: 65",exporter="127.0.0.1",reason="afi-safi"}`
 		expectedMetrics := map[string]string{
 			`received_messages_total{exporter="127.0.0.1",type="initiation"}`:           "1",
@@ -881,7 +923,7 @@ func TestBMP(t *testing.T) {
 		send(t, conn, "bmp-l3vpn.pcap")
 		send(t, conn, "bmp-reach-vpls.pcap")
 		time.Sleep(20 * time.Millisecond)
-		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration")
+		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration", "-stale_")
 		expectedMetrics := map[string]string{
 			`received_messages_total{exporter="127.0.0.1",type="initiation"}`:           "1",
 			`received_messages_total{exporter="127.0.0.1",type="peer-up-notification"}`: "1",
@@ -914,7 +956,7 @@ func TestBMP(t *testing.T) {
 		send(t, conn2, "bmp-l3vpn.pcap")
 		conn1.Close()
 		time.Sleep(20 * time.Millisecond)
-		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration")
+		gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration", "-stale_")
 		expectedMetrics := map[string]string{
 			`received_messages_total{exporter="127.0.0.1",type="initiation"}`:           "2",
 			`received_messages_total{exporter="127.0.0.1",type="peer-up-notification"}`: "2",
@@ -944,7 +986,7 @@ func TestBMP(t *testing.T) {
 
 		mockClock.Add(2 * time.Hour)
 		time.Sleep(20 * time.Millisecond)
-		gotMetrics = r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration")
+		gotMetrics = r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration", "-stale_")
 		expectedMetrics = map[string]string{
 			`received_messages_total{exporter="127.0.0.1",type="initiation"}`:           "2",
 			`received_messages_total{exporter="127.0.0.1",type="peer-up-notification"}`: "2",
@@ -973,7 +1015,7 @@ func TestBMP(t *testing.T) {
 
 		send(t, conn2, "bmp-terminate.pcap")
 		time.Sleep(30 * time.Millisecond)
-		gotMetrics = r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration")
+		gotMetrics = r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration", "-stale_")
 		expectedMetrics = map[string]string{
 			`received_messages_total{exporter="127.0.0.1",type="initiation"}`:           "2",
 			`received_messages_total{exporter="127.0.0.1",type="termination"}`:          "1",
@@ -996,7 +1038,7 @@ func TestBMP(t *testing.T) {
 
 		mockClock.Add(2 * time.Hour)
 		time.Sleep(20 * time.Millisecond)
-		gotMetrics = r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration")
+		gotMetrics = r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration", "-stale_")
 		expectedMetrics = map[string]string{
 			`received_messages_total{exporter="127.0.0.1",type="initiation"}`:           "2",
 			`received_messages_total{exporter="127.0.0.1",type="termination"}`:          "1",
@@ -1039,7 +1081,7 @@ func TestBMP(t *testing.T) {
 		mockClock.Add(2 * time.Hour)
 		for tries := 20; tries >= 0; tries-- {
 			time.Sleep(5 * time.Millisecond)
-			gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration")
+			gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "-locked_duration", "-stale_")
 			// For removed_partial_peers_total, we have 18 routes, but only 14 routes
 			// can be removed while keeping 1 route on each peer. 14 is the max, but
 			// we rely on good-willing from the scheduler to get this number.
@@ -1155,6 +1197,7 @@ func TestBMP(t *testing.T) {
 			netip.MustParseAddr("::ffff:192.168.145.10"),
 			netip.MustParseAddr("::ffff:203.0.113.14"), netip.Addr{})
 		expected := provider.LookupResult{
+			Found:   true,
 			ASN:     1234,
 			ASPath:  []uint32{1234},
 			NetMask: 22,
@@ -1164,4 +1207,164 @@ func TestBMP(t *testing.T) {
 			t.Errorf("Lookup() (-got, +want):\n%s", diff)
 		}
 	})
+
+	t.Run("VRF lookup", func(t *testing.T) {
+		r := reporter.NewMock(t)
+		config := DefaultConfiguration()
+		p, _ := NewMock(t, r, config)
+		helpers.StartStop(t, p)
+		p.active.Store(true)
+		pinfo := p.addPeer(peerKey{
+			exporter: netip.MustParseAddrPort("[::ffff:127.0.0.1]:47389"),
+			ip:       netip.MustParseAddr("::ffff:203.0.113.4"),
+			ptype:    bmp.BMP_PEER_TYPE_GLOBAL,
+			asn:      64500,
+		})
+		for _, candidate := range []struct {
+			rd   string
+			plen int
+			asn  uint32
+		}{
+			{"0:0", 24, 1},
+			{"65000:100", 25, 2},
+			{"65000:200", 26, 3},
+		} {
+			p.rib.addPrefix(netip.MustParseAddr("::ffff:198.51.100.0"), 96+candidate.plen, route{
+				peer:    pinfo.reference,
+				nlri:    p.rib.nlris.Put(nlri{family: bgp.RF_IPv4_VPN, rd: MustParseRD(candidate.rd)}),
+				nextHop: p.rib.nextHops.Put(nextHop(netip.MustParseAddr("::ffff:203.0.113.4"))),
+				attributes: p.rib.rtas.Put(routeAttributes{
+					asn:  candidate.asn,
+					plen: uint8(96 + candidate.plen),
+				}),
+			})
+		}
+		for _, tc := range []struct {
+			ip   string
+			rd   string
+			asn  uint32
+			plen uint8
+		}{
+			{"::ffff:198.51.100.10", "0:0", 3, 26},
+			{"::ffff:198.51.100.10", "65000:100", 2, 25},
+			{"::ffff:198.51.100.10", "65000:200", 3, 26},
+			{"::ffff:198.51.100.10", "65000:999", 1, 24},
+			{"::ffff:198.51.100.100", "65000:200", 1, 24},
+			{"::ffff:198.51.100.100", "65000:100", 2, 25},
+		} {
+			lookup, err := p.LookupVRF(context.Background(), netip.MustParseAddr(tc.ip),
+				netip.Addr{}, netip.Addr{}, uint64(MustParseRD(tc.rd)))
+			if err != nil {
+				t.Fatalf("LookupVRF(%s, %s) error:\n%+v", tc.ip, tc.rd, err)
+			}
+			if lookup.ASN != tc.asn || lookup.NetMask != tc.plen {
+				t.Errorf("LookupVRF(%s, %s) == AS%d/%d, expected AS%d/%d",
+					tc.ip, tc.rd, lookup.ASN, lookup.NetMask, tc.asn, tc.plen)
+			}
+		}
+	})
+
+	t.Run("local preference and MED", func(t *testing.T) {
+		for _, collect := range []bool{false, true} {
+			r := reporter.NewMock(t)
+			config := DefaultConfiguration().(Configuration)
+			config.CollectLocalPref = collect
+			config.CollectMED = collect
+			p, _ := NewMock(t, r, config)
+			helpers.StartStop(t, p)
+			p.active.Store(true)
+			p.handleRouteMonitoring(peerKey{
+				exporter: netip.MustParseAddrPort("[::ffff:127.0.0.1]:47389"),
+				ip:       netip.MustParseAddr("::ffff:203.0.113.4"),
+				ptype:    bmp.BMP_PEER_TYPE_GLOBAL,
+				asn:      64500,
+			}, &bmp.BMPRouteMonitoring{
+				BGPUpdate: bgp.NewBGPUpdateMessage(nil, []bgp.PathAttributeInterface{
+					bgp.NewPathAttributeOrigin(0),
+					bgp.NewPathAttributeAsPath([]bgp.AsPathParamInterface{
+						bgp.NewAs4PathParam(bgp.BGP_ASPATH_ATTR_TYPE_SEQ, []uint32{64500, 65000}),
+					}),
+					bgp.NewPathAttributeNextHop("198.51.100.1"),
+					bgp.NewPathAttributeLocalPref(150),
+					bgp.NewPathAttributeMultiExitDisc(20),
+				}, []*bgp.IPAddrPrefix{bgp.NewIPAddrPrefix(24, "192.0.2.0")}),
+			})
+
+			lookup, err := p.Lookup(context.Background(),
+				netip.MustParseAddr("::ffff:192.0.2.10"), netip.Addr{}, netip.Addr{})
+			if err != nil {
+				t.Fatalf("Lookup() error:\n%+v", err)
+			}
+			expected := provider.LookupResult{
+				Found:   true,
+				ASN:     65000,
+				ASPath:  []uint32{64500, 65000},
+				NetMask: 24,
+				NextHop: netip.MustParseAddr("::ffff:198.51.100.1"),
+			}
+			if collect {
+				expected.LocalPref = 150
+				expected.MED = 20
+			}
+			if diff := helpers.Diff(lookup, expected); diff != "" {
+				t.Errorf("Lookup() with collect=%v (-got, +want):\n%s", collect, diff)
+			}
+		}
+	})
+
+	t.Run("path selection", func(t *testing.T) {
+		for _, tc := range []struct {
+			selection PathSelection
+			nh        string
+			expected  uint32
+		}{
+			{PathSelectionLowestPathID, "::ffff:198.51.100.200", 4},
+			{PathSelectionBest, "::ffff:198.51.100.200", 6},
+			{PathSelectionLowestPathID, "::ffff:198.51.100.3", 3},
+			{PathSelectionBest, "::ffff:198.51.100.3", 3},
+		} {
+			r := reporter.NewMock(t)
+			config := DefaultConfiguration().(Configuration)
+			config.PathSelection = tc.selection
+			p, _ := NewMock(t, r, config)
+			helpers.StartStop(t, p)
+			p.active.Store(true)
+			pinfo := p.addPeer(peerKey{
+				exporter: netip.MustParseAddrPort("[::ffff:127.0.0.1]:47389"),
+				ip:       netip.MustParseAddr("::ffff:203.0.113.4"),
+				ptype:    bmp.BMP_PEER_TYPE_GLOBAL,
+				asn:      64500,
+			})
+			for _, candidate := range []struct {
+				id        uint32
+				localPref uint32
+				asPath    []uint32
+			}{
+				{3, 200, []uint32{1, 2, 3}},
+				{1, 100, []uint32{4}},
+				{2, 200, []uint32{5, 6}},
+			} {
+				p.rib.addPrefix(netip.MustParseAddr("::ffff:192.0.2.0"), 96+24, route{
+					peer: pinfo.reference,
+					nlri: p.rib.nlris.Put(nlri{family: bgp.RF_IPv4_UC, path: candidate.id}),
+					nextHop: p.rib.nextHops.Put(nextHop(
+						netip.MustParseAddr(fmt.Sprintf("::ffff:198.51.100.%d", candidate.id)))),
+					attributes: p.rib.rtas.Put(routeAttributes{
+						asn:       candidate.asPath[len(candidate.asPath)-1],
+						asPath:    candidate.asPath,
+						localPref: candidate.localPref,
+						plen:      96 + 24,
+					}),
+				})
+			}
+
+			lookup, _ := p.Lookup(context.Background(),
+				netip.MustParseAddr("::ffff:192.0.2.10"),
+				netip.MustParseAddr(tc.nh), netip.Addr{})
+			if lookup.ASN != tc.expected {
+				t.Errorf("Lookup(%s, %s) == %d, expected %d",
+					tc.selection, tc.nh, lookup.ASN, tc.expected)
+			}
+		}
+	})
 }
@@ -0,0 +1,252 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package bmp
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+
+	"github.com/osrg/gobgp/v3/pkg/packet/bgp"
+	"github.com/osrg/gobgp/v3/pkg/packet/bmp"
+)
+
+// ribSnapshotVersion should be increased each time we change the way we
+// encode the RIB snapshot.
+const ribSnapshotVersion = 2
+
+// errSnapshotVersion is triggered when loading a snapshot from an
+// incompatible version.
+var errSnapshotVersion = errors.New("RIB snapshot version mismatch")
+
+// ribSnapshotPeer is a peer with its routes, as stored in a snapshot.
+type ribSnapshotPeer struct {
+	Exporter      netip.AddrPort
+	IP            netip.Addr
+	PType         uint8
+	Distinguisher RD
+	ASN           uint32
+	BGPID         uint32
+	Routes        []ribSnapshotRoute
+}
+
+// ribSnapshotRoute is a route, as stored in a snapshot.
+type ribSnapshotRoute struct {
+	Prefix           netip.Prefix
+	Family           bgp.RouteFamily
+	Path             uint32
+	RD               RD
+	NextHop          netip.Addr
+	ASN              uint32
+	ASPath           []uint32
+	Communities      []uint32
+	LargeCommunities []bgp.LargeCommunity
+	PLen             uint8
+	LocalPref        uint32
+	MED              uint32
+}
+
+// ribSnapshotLayout describes the layout of the snapshot. Gob decoding is
+// pretty forgiving, therefore, we also check a string representation of the
+// encoded types.
+var ribSnapshotLayout = fmt.Sprintf("%+v\n%+v", ribSnapshotPeer{}, ribSnapshotRoute{})
+
+// saveRIB stores a snapshot of the RIB to the provided file.
+func (p *Provider) saveRIB(ribFile string) error {
+	// Collect routes with the lock held, then encode them without the lock.
+	p.mu.RLock()
+	peers := make(map[uint32]*ribSnapshotPeer, len(p.peers))
+	for pkey, pinfo := range p.peers {
+		peers[pinfo.reference] = &ribSnapshotPeer{
+			Exporter:      pkey.exporter,
+			IP:            pkey.ip,
+			PType:         pkey.ptype,
+			Distinguisher: pkey.distinguisher,
+			ASN:           pkey.asn,
+			BGPID:         pkey.bgpID,
+		}
+	}
+	iter := p.rib.tree.Iterate()
+	for iter.Next() {
+		prefix, err := netip.ParsePrefix(iter.Address().String())
+		if err != nil {
+			continue
+		}
+		if prefix.Addr().Is4() {
+			// The RIB stores IPv4 prefixes as IPv4-mapped IPv6 prefixes.
+			prefix = netip.PrefixFrom(
+				netip.AddrFrom16(prefix.Addr().As16()), prefix.Bits()+96)
+		}
+		for _, route := range iter.Tags() {
+			peer := peers[route.peer]
+			if peer == nil {
+				continue
+			}
+			nlri := p.rib.nlris.Get(route.nlri)
+			rta := p.rib.rtas.Get(route.attributes)
+			peer.Routes = append(peer.Routes, ribSnapshotRoute{
+				Prefix:           prefix,
+				Family:           nlri.family,
+				Path:             nlri.path,
+				RD:               nlri.rd,
+				NextHop:          netip.Addr(p.rib.nextHops.Get(route.nextHop)),
+				ASN:              rta.asn,
+				ASPath:           rta.asPath,
+				Communities:      rta.communities,
+				LargeCommunities: rta.largeCommunities,
+				PLen:             rta.plen,
+				LocalPref:        rta.localPref,
+				MED:              rta.med,
+			})
+		}
+	}
+	p.mu.RUnlock()
+
+	tmpFile, err := os.CreateTemp(
+		filepath.Dir(ribFile),
+		fmt.Sprintf("%s-*", filepath.Base(ribFile)))
+	if err != nil {
+		return fmt.Errorf("unable to create RIB snapshot %q: %w", ribFile, err)
+	}
+	defer func() {
+		tmpFile.Close()           // ignore errors
+		os.Remove(tmpFile.Name()) // ignore errors
+	}()
+
+	// Write snapshot: version, layout and one entry per peer
+	writer := bufio.NewWriter(tmpFile)
+	encoder := gob.NewEncoder(writer)
+	version := ribSnapshotVersion
+	if err := encoder.Encode(&version); err != nil {
+		return fmt.Errorf("unable to encode RIB snapshot: %w", err)
+	}
+	if err := encoder.Encode(&ribSnapshotLayout); err != nil {
+		return fmt.Errorf("unable to encode RIB snapshot: %w", err)
+	}
+	for _, peer := range peers {
+		if err := encoder.Encode(peer); err != nil {
+			return fmt.Errorf("unable to encode RIB snapshot: %w", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("unable to write RIB snapshot %q: %w", ribFile, err)
+	}
+
+	// Move snapshot to new location
+	if err := os.Rename(tmpFile.Name(), ribFile); err != nil {
+		return fmt.Errorf("unable to write RIB snapshot %q: %w", ribFile, err)
+	}
+	return nil
+}
+
+// loadRIB loads a snapshot of the RIB from the provided file. Loaded peers are
+// detached and marked as stale. It returns the number of loaded routes. Nothing is
+// loaded when an error is returned.
+func (p *Provider) loadRIB(ribFile string) (int, error) {
+	f, err := os.Open(ribFile)
+	if err != nil {
+		return 0, fmt.Errorf("unable to load RIB snapshot %q: %w", ribFile, err)
+	}
+	defer f.Close()
+	decoder := gob.NewDecoder(bufio.NewReader(f))
+
+	// Check version and layout
+	var version int
+	if err := decoder.Decode(&version); err != nil {
+		return 0, fmt.Errorf("unable to decode RIB snapshot: %w", err)
+	}
+	if version != ribSnapshotVersion {
+		return 0, errSnapshotVersion
+	}
+	var layout string
+	if err := decoder.Decode(&layout); err != nil {
+		return 0, fmt.Errorf("unable to decode RIB snapshot: %w", err)
+	}
+	if layout != ribSnapshotLayout {
+		return 0, errSnapshotVersion
+	}
+	peers := []ribSnapshotPeer{}
+	for {
+		var peer ribSnapshotPeer
+		if err := decoder.Decode(&peer); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return 0, fmt.Errorf("unable to decode RIB snapshot: %w", err)
+		}
+		peers = append(peers, peer)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	until := p.d.Clock.Now().Add(p.config.RIBSnapshotKeep)
+	loaded := 0
+	for _, peer := range peers {
+		pkey := peerKey{
+			exporter:      peer.Exporter,
+			ip:            peer.IP,
+			ptype:         peer.PType,
+			distinguisher: peer.Distinguisher,
+			asn:           peer.ASN,
+			bgpID:         peer.BGPID,
+		}
+		p.metrics.peers.WithLabelValues(pkey.exporter.Addr().Unmap().String()).Inc()
+		pinfo := p.addPeer(pkey)
+		p.detachPeer(pkey, until)
+		added := 0
+		for _, r := range peer.Routes {
+			if pkey.ptype != bmp.BMP_PEER_TYPE_L3VPN && !p.isAcceptedRD(r.RD) {
+				continue
+			}
+			rta := routeAttributes{
+				asn:              r.ASN,
+				asPath:           r.ASPath,
+				communities:      r.Communities,
+				largeCommunities: r.LargeCommunities,
+				plen:             r.PLen,
+			}
+			if !p.config.CollectASNs {
+				rta.asn = 0
+			}
+			if !p.config.CollectASPaths {
+				rta.asPath = nil
+			}
+			if !p.config.CollectCommunities {
+				rta.communities = nil
+				rta.largeCommunities = nil
+			}
+			if p.config.PathSelection == PathSelectionBest || p.config.CollectLocalPref {
+				rta.localPref = r.LocalPref
+			}
+			if p.config.CollectMED {
+				rta.med = r.MED
+			}
+			added += p.rib.addPrefix(r.Prefix.Addr(), r.Prefix.Bits(), route{
+				peer: pinfo.reference,
+				nlri: p.rib.nlris.Put(nlri{
+					family: r.Family,
+					path:   r.Path,
+					rd:     r.RD,
+				}),
+				nextHop:    p.rib.nextHops.Put(nextHop(r.NextHop)),
+				attributes: p.rib.rtas.Put(rta),
+			})
+		}
+		p.updateRouteCount(pkey, pinfo, added)
+		loaded += added
+	}
+	p.scheduleStalePeersRemoval()
+	return loaded, nil
+}
+
+// snapshotRIB stores the RIB to the snapshot file.
+func (p *Provider) snapshotRIB() {
+	if err := p.saveRIB(p.config.RIBSnapshotFile); err != nil {
+		p.r.Err(err).Msg("cannot save RIB snapshot")
+	}
+}
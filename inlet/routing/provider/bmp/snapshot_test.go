@@ -0,0 +1,153 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package bmp
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+
+	"github.com/osrg/gobgp/v3/pkg/packet/bgp"
+	"github.com/osrg/gobgp/v3/pkg/packet/bmp"
+)
+
+func TestRIBSnapshot(t *testing.T) {
+	ribFile := filepath.Join(t.TempDir(), "rib.bin")
+
+	// Save a populated RIB
+	r := reporter.NewMock(t)
+	p, _ := NewMock(t, r, DefaultConfiguration())
+	p.PopulateRIB(t)
+	if err := p.saveRIB(ribFile); err != nil {
+		t.Fatalf("saveRIB() error:\n%+v", err)
+	}
+
+	// Load it in a new provider
+	r = reporter.NewMock(t)
+	config := DefaultConfiguration().(Configuration)
+	config.RIBSnapshotFile = ribFile
+	p, _ = NewMock(t, r, config)
+	helpers.StartStop(t, p)
+
+	lookup, _ := p.Lookup(context.Background(),
+		netip.MustParseAddr("::ffff:192.0.2.2"),
+		netip.MustParseAddr("::ffff:198.51.100.200"), netip.Addr{})
+	if lookup.ASN != 174 {
+		t.Errorf("Lookup() == %d, expected 174", lookup.ASN)
+	}
+	gotMetrics := r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "peers_total", "routes_total", "stale_")
+	expectedMetrics := map[string]string{
+		`peers_total{exporter="127.0.0.1"}`:        "1",
+		`routes_total{exporter="127.0.0.1"}`:       "7",
+		`stale_routes_total{exporter="127.0.0.1"}`: "7",
+		`stale_lookups_total`:                      "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+
+	// A route from a live peer is preferred
+	pkey := peerKey{
+		exporter: netip.MustParseAddrPort("[::ffff:127.0.0.1]:47390"),
+		ip:       netip.MustParseAddr("::ffff:203.0.113.4"),
+		ptype:    bmp.BMP_PEER_TYPE_GLOBAL,
+		asn:      64500,
+	}
+	p.handleRouteMonitoring(pkey, &bmp.BMPRouteMonitoring{
+		BGPUpdate: bgp.NewBGPUpdateMessage(nil, []bgp.PathAttributeInterface{
+			bgp.NewPathAttributeNextHop("198.51.100.4"),
+			bgp.NewPathAttributeAsPath([]bgp.AsPathParamInterface{
+				bgp.NewAs4PathParam(bgp.BGP_ASPATH_ATTR_TYPE_SEQ, []uint32{64200, 1299, 176}),
+			}),
+		}, []*bgp.IPAddrPrefix{bgp.NewIPAddrPrefix(27, "192.0.2.0")}),
+	})
+	lookup, _ = p.Lookup(context.Background(),
+		netip.MustParseAddr("::ffff:192.0.2.2"),
+		netip.MustParseAddr("::ffff:198.51.100.200"), netip.Addr{})
+	if lookup.ASN != 176 {
+		t.Errorf("Lookup() == %d, expected 176", lookup.ASN)
+	}
+
+	// After End-of-RIB, loaded routes are removed
+	p.handleRouteMonitoring(pkey, &bmp.BMPRouteMonitoring{
+		BGPUpdate: bgp.NewBGPUpdateMessage(nil, nil, nil),
+	})
+	time.Sleep(50 * time.Millisecond)
+	lookup, _ = p.Lookup(context.Background(),
+		netip.MustParseAddr("::ffff:192.0.2.130"),
+		netip.MustParseAddr("::ffff:198.51.100.200"), netip.Addr{})
+	if lookup.ASN != 0 {
+		t.Errorf("Lookup() == %d, expected 0", lookup.ASN)
+	}
+	gotMetrics = r.GetMetrics("akvorado_inlet_routing_provider_bmp_", "peers_total", "routes_total", "stale_")
+	expectedMetrics = map[string]string{
+		`peers_total{exporter="127.0.0.1"}`:        "1",
+		`routes_total{exporter="127.0.0.1"}`:       "1",
+		`stale_routes_total{exporter="127.0.0.1"}`: "0",
+		`stale_lookups_total`:                      "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestRIBSnapshotIncompatible(t *testing.T) {
+	r := reporter.NewMock(t)
+	p, _ := NewMock(t, r, DefaultConfiguration())
+
+	t.Run("version mismatch", func(t *testing.T) {
+		ribFile := filepath.Join(t.TempDir(), "rib.bin")
+		f, err := os.Create(ribFile)
+		if err != nil {
+			t.Fatalf("Create() error:\n%+v", err)
+		}
+		version := ribSnapshotVersion + 1
+		if err := gob.NewEncoder(f).Encode(&version); err != nil {
+			t.Fatalf("Encode() error:\n%+v", err)
+		}
+		f.Close()
+		if _, err := p.loadRIB(ribFile); !errors.Is(err, errSnapshotVersion) {
+			t.Fatalf("loadRIB() error:\n%+v", err)
+		}
+	})
+
+	t.Run("layout mismatch", func(t *testing.T) {
+		ribFile := filepath.Join(t.TempDir(), "rib.bin")
+		f, err := os.Create(ribFile)
+		if err != nil {
+			t.Fatalf("Create() error:\n%+v", err)
+		}
+		encoder := gob.NewEncoder(f)
+		version := ribSnapshotVersion
+		layout := "something else"
+		encoder.Encode(&version)
+		encoder.Encode(&layout)
+		f.Close()
+		if _, err := p.loadRIB(ribFile); !errors.Is(err, errSnapshotVersion) {
+			t.Fatalf("loadRIB() error:\n%+v", err)
+		}
+	})
+
+	t.Run("garbage", func(t *testing.T) {
+		ribFile := filepath.Join(t.TempDir(), "rib.bin")
+		if err := os.WriteFile(ribFile, []byte("garbage"), 0o644); err != nil {
+			t.Fatalf("WriteFile() error:\n%+v", err)
+		}
+		if _, err := p.loadRIB(ribFile); err == nil {
+			t.Fatal("loadRIB() did not error")
+		}
+	})
+
+	if len(p.peers) != 0 {
+		t.Fatalf("loadRIB() loaded %d peers, expected none", len(p.peers))
+	}
+}
@@ -10,26 +10,34 @@ import (
 	"net/netip"
 
 	"akvorado/common/daemon"
+	"akvorado/common/httpserver"
 	"akvorado/common/reporter"
+	"akvorado/common/schema"
 
 	"github.com/benbjohnson/clock"
 	"github.com/osrg/gobgp/v3/pkg/packet/bgp"
 )
 
-// LookupResult is the result of the Lookup() function.
+// LookupResult is the result of the Lookup() function. Found is true when a
+// route was found (NetMask may then be 0 for a default route).
 type LookupResult struct {
+	Found            bool
 	ASN              uint32
 	ASPath           []uint32
 	Communities      []uint32
 	LargeCommunities []bgp.LargeCommunity
 	NetMask          uint8
 	NextHop          netip.Addr
+	LocalPref        uint32
+	MED              uint32
+	RPKIStatus       schema.RPKIStatus
 }
 
 // Dependencies are the dependencies for a provider.
 type Dependencies struct {
 	Daemon daemon.Component
 	Clock  clock.Clock
+	HTTP   *httpserver.Component // optional
 }
 
 // Provider is the interface a provider should implement.
@@ -39,6 +47,14 @@ type Provider interface {
 	Lookup(ctx context.Context, ip netip.Addr, nh netip.Addr, agent netip.Addr) (LookupResult, error)
 }
 
+// VRFProvider is the interface a provider able to lookup routes in a VRF
+// should implement.
+type VRFProvider interface {
+	// LookupVRF is like Lookup but looks up routes in the VRF with the
+	// provided route distinguisher, falling back to the global table (0).
+	LookupVRF(ctx context.Context, ip netip.Addr, nh netip.Addr, agent netip.Addr, rd uint64) (LookupResult, error)
+}
+
 // Configuration defines an interface to configure a provider.
 type Configuration interface {
 	// New instantiates a new provider from its configuration.
@@ -0,0 +1,102 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Package static is a routing provider using static routes to answer to
+// lookups.
+package static
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"akvorado/common/remotedatasourcefetcher"
+	"akvorado/inlet/routing/provider"
+)
+
+// Configuration describes the configuration for the static provider.
+type Configuration struct {
+	// Routes is a list of static routes
+	Routes []Route `validate:"dive"`
+	// RouteFiles is a list of YAML or JSON files containing a list of
+	// routes. They are watched and reloaded on change. The results are
+	// overridden by the content of Routes.
+	RouteFiles []string
+	// RouteSources defines a set of remote routes definitions. The results
+	// are overridden by the content of Routes.
+	RouteSources map[string]remotedatasourcefetcher.RemoteDataSource `validate:"dive"`
+}
+
+// Route is a static route.
+type Route struct {
+	// Prefix is the prefix of the route
+	Prefix netip.Prefix `validate:"required"`
+	// ASN is the origin AS number (the last AS number of ASPath when not set)
+	ASN uint32
+	// ASPath is the AS path of the route
+	ASPath []uint32
+	// Communities is a list of standard or large communities
+	Communities []Community
+	// NextHop is the next hop of the route
+	NextHop netip.Addr
+}
+
+// DefaultConfiguration represents the default configuration for the static
+// provider.
+func DefaultConfiguration() provider.Configuration {
+	return Configuration{
+		Routes: []Route{},
+	}
+}
+
+// Community is a standard BGP community (ASN:value) or a large BGP community
+// (ASN:value1:value2).
+type Community struct {
+	ASN    uint32
+	Value1 uint32
+	Value2 uint32
+	Large  bool
+}
+
+var errInvalidCommunity = errors.New("invalid community")
+
+// UnmarshalText parses a community.
+func (c *Community) UnmarshalText(input []byte) error {
+	parts := strings.Split(string(input), ":")
+	if len(parts) != 2 && len(parts) != 3 {
+		return errInvalidCommunity
+	}
+	values := make([]uint32, len(parts))
+	bitSize := 16
+	if len(parts) == 3 {
+		bitSize = 32
+	}
+	for idx, part := range parts {
+		value, err := strconv.ParseUint(part, 10, bitSize)
+		if err != nil {
+			return fmt.Errorf("%w %q: %w", errInvalidCommunity, input, err)
+		}
+		values[idx] = uint32(value)
+	}
+	if len(parts) == 2 {
+		*c = Community{ASN: values[0], Value1: values[1]}
+	} else {
+		*c = Community{ASN: values[0], Value1: values[1], Value2: values[2], Large: true}
+	}
+	return nil
+}
+
+// String turns a community to a string.
+func (c Community) String() string {
+	if c.Large {
+		return fmt.Sprintf("%d:%d:%d", c.ASN, c.Value1, c.Value2)
+	}
+	return fmt.Sprintf("%d:%d", c.ASN, c.Value1)
+}
+
+// MarshalText turns a community to text.
+func (c Community) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
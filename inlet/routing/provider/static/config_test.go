@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package static
+
+import (
+	"net/netip"
+	"testing"
+
+	"akvorado/common/helpers"
+)
+
+func TestDefaultConfiguration(t *testing.T) {
+	if err := helpers.Validate.Struct(DefaultConfiguration()); err != nil {
+		t.Fatalf("validate.Struct() error:\n%+v", err)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	if err := helpers.Validate.Struct(Configuration{
+		Routes: []Route{
+			{Prefix: netip.MustParsePrefix("192.0.2.0/24"), ASN: 65000},
+		},
+	}); err != nil {
+		t.Fatalf("validate.Struct() error:\n%+v", err)
+	}
+	if err := helpers.Validate.Struct(Configuration{
+		Routes: []Route{{ASN: 65000}},
+	}); err == nil {
+		t.Fatal("validate.Struct() did not error")
+	}
+}
+
+func TestCommunity(t *testing.T) {
+	cases := []struct {
+		Input    string
+		Expected Community
+		Error    bool
+	}{
+		{"65000:100", Community{ASN: 65000, Value1: 100}, false},
+		{"4200000000:1:2", Community{ASN: 4200000000, Value1: 1, Value2: 2, Large: true}, false},
+		{"65536:100", Community{}, true},
+		{"65000:65536", Community{}, true},
+		{"65000", Community{}, true},
+		{"1:2:3:4", Community{}, true},
+		{"65000:abc", Community{}, true},
+	}
+	for _, tc := range cases {
+		var got Community
+		err := got.UnmarshalText([]byte(tc.Input))
+		if err != nil && !tc.Error {
+			t.Errorf("UnmarshalText(%q) error:\n%+v", tc.Input, err)
+			continue
+		} else if err == nil && tc.Error {
+			t.Errorf("UnmarshalText(%q) did not error", tc.Input)
+			continue
+		} else if tc.Error {
+			continue
+		}
+		if diff := helpers.Diff(got, tc.Expected); diff != "" {
+			t.Errorf("UnmarshalText(%q) (-got, +want):\n%s", tc.Input, diff)
+		}
+		if got.String() != tc.Input {
+			t.Errorf("String() == %q, expected %q", got.String(), tc.Input)
+		}
+	}
+}
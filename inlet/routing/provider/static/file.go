@@ -0,0 +1,143 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package static
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/mitchellh/mapstructure"
+	"gopkg.in/yaml.v3"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+)
+
+// routeFileReloadDelay is the time to wait after the last change to a route
+// file before reloading it. This lets writers not replacing the file
+// atomically finish writing it.
+const routeFileReloadDelay = 200 * time.Millisecond
+
+// errEmptyRouteFile is returned when a route file is empty. An empty list of
+// routes should be written as "[]".
+var errEmptyRouteFile = errors.New("empty route file")
+
+// parseRouteFile parses a YAML or JSON file containing a list of routes. As
+// JSON is a subset of YAML, a single parser is used.
+func parseRouteFile(r io.Reader) ([]Route, error) {
+	var raw interface{}
+	if err := yaml.NewDecoder(r).Decode(&raw); err == io.EOF {
+		return nil, errEmptyRouteFile
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot parse YAML: %w", err)
+	}
+	routes := []Route{}
+	decoder, err := mapstructure.NewDecoder(helpers.GetMapStructureDecoderConfig(&routes))
+	if err != nil {
+		return nil, err
+	}
+	if err := decoder.Decode(raw); err != nil {
+		return nil, err
+	}
+	if err := helpers.Validate.Var(routes, "dive"); err != nil {
+		return nil, err
+	}
+	if _, err := buildRoutes(routes); err != nil {
+		return nil, err
+	}
+	return routes, nil
+}
+
+// loadRouteFile loads the provided route file and updates the routes if
+// successful. An empty file is accepted on first load only: on reload, it is
+// likely being written and the previous routes are kept.
+func (p *Provider) loadRouteFile(path string) error {
+	key := fmt.Sprintf("file:%s", path)
+	f, err := os.Open(path)
+	if err != nil {
+		p.r.Err(err).Str("file", path).Msg("cannot load route file")
+		return fmt.Errorf("cannot load route file %q: %w", path, err)
+	}
+	defer f.Close()
+	routes, err := parseRouteFile(f)
+	if errors.Is(err, errEmptyRouteFile) {
+		p.routesLock.Lock()
+		_, loaded := p.routesMap[key]
+		p.routesLock.Unlock()
+		if loaded {
+			p.r.Warn().Str("file", path).Msg("route file is empty, keep previous routes")
+			return nil
+		}
+		routes, err = []Route{}, nil
+	}
+	if err != nil {
+		p.r.Err(err).Str("file", path).Msg("cannot load route file")
+		return fmt.Errorf("cannot load route file %q: %w", path, err)
+	}
+	p.r.Info().Str("file", path).Int("routes", len(routes)).Msg("route file loaded")
+	p.routesLock.Lock()
+	p.routesMap[key] = routes
+	p.routesLock.Unlock()
+	return p.updateRoutes()
+}
+
+// watchRouteFiles watches the route files and reloads them on change. Reloads
+// are delayed until the file has not changed for routeFileReloadDelay.
+func (p *Provider) watchRouteFiles(paths []string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("cannot setup watcher: %w", err)
+	}
+	dirs := map[string]struct{}{}
+	for _, path := range paths {
+		dirs[filepath.Dir(path)] = struct{}{}
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return fmt.Errorf("cannot watch route file directory: %w", err)
+		}
+	}
+	go func() {
+		errLogger := p.r.Sample(reporter.BurstSampler(10*time.Second, 1))
+		defer watcher.Close()
+		pending := map[string]struct{}{}
+		timer := time.NewTimer(0)
+		<-timer.C
+		for {
+			select {
+			case <-timer.C:
+				for path := range pending {
+					p.loadRouteFile(path)
+				}
+				clear(pending)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				errLogger.Err(err).Msg("error from watcher")
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) {
+					continue
+				}
+				for _, path := range paths {
+					if filepath.Clean(event.Name) == filepath.Clean(path) {
+						pending[path] = struct{}{}
+						timer.Reset(routeFileReloadDelay)
+						break
+					}
+				}
+			}
+		}
+	}()
+	return nil
+}
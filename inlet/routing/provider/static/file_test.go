@@ -0,0 +1,163 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package static
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/inlet/routing/provider"
+)
+
+func TestParseRouteFile(t *testing.T) {
+	got, err := parseRouteFile(strings.NewReader(`
+- prefix: 192.0.2.0/24
+  asn: 65000
+  communities: [65000:100, 65000:1:2]
+- prefix: 2001:db8::/32
+  as-path: [65001, 65002]
+  next-hop: 2001:db8::1
+`))
+	if err != nil {
+		t.Fatalf("parseRouteFile() error:\n%+v", err)
+	}
+	expected := []Route{
+		{
+			Prefix: netip.MustParsePrefix("192.0.2.0/24"),
+			ASN:    65000,
+			Communities: []Community{
+				{ASN: 65000, Value1: 100},
+				{ASN: 65000, Value1: 1, Value2: 2, Large: true},
+			},
+		}, {
+			Prefix:  netip.MustParsePrefix("2001:db8::/32"),
+			ASPath:  []uint32{65001, 65002},
+			NextHop: netip.MustParseAddr("2001:db8::1"),
+		},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("parseRouteFile() (-got, +want):\n%s", diff)
+	}
+
+	// JSON is accepted too
+	if _, err := parseRouteFile(strings.NewReader(`[{"prefix": "192.0.2.0/24", "asn": 65000}]`)); err != nil {
+		t.Fatalf("parseRouteFile() error:\n%+v", err)
+	}
+
+	for _, input := range []string{
+		`- prefix: 192.0.2.0/24
+  unknown: 1`,
+		`- asn: 65000`,
+		`- prefix: 192.0.2.0/24
+  communities: [65000]`,
+		`- prefix: 192.0.2.0/24
+  asn: 65000
+- prefix: 192.0.2.0/24
+  asn: 65001`,
+	} {
+		if _, err := parseRouteFile(strings.NewReader(input)); err == nil {
+			t.Errorf("parseRouteFile(%q) did not error", input)
+		}
+	}
+}
+
+func TestRouteFile(t *testing.T) {
+	routeFile := filepath.Join(t.TempDir(), "routes.yaml")
+	write := func(content string) {
+		t.Helper()
+		// Write then rename to update the file atomically
+		tmp := routeFile + ".tmp"
+		if err := os.WriteFile(tmp, []byte(content), 0o644); err != nil {
+			t.Fatalf("WriteFile() error:\n%+v", err)
+		}
+		if err := os.Rename(tmp, routeFile); err != nil {
+			t.Fatalf("Rename() error:\n%+v", err)
+		}
+	}
+	write(`
+- prefix: 192.0.2.0/24
+  asn: 65000
+- prefix: 198.51.100.0/24
+  asn: 65000
+`)
+	r := reporter.NewMock(t)
+	config := Configuration{
+		Routes: []Route{
+			// Static routes override routes from files
+			{Prefix: netip.MustParsePrefix("198.51.100.0/24"), ASN: 65001},
+		},
+		RouteFiles: []string{routeFile},
+	}
+	p, err := config.New(r, provider.Dependencies{})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	lookup := func(ip string) uint32 {
+		t.Helper()
+		got, err := p.Lookup(context.Background(), netip.MustParseAddr(ip), netip.Addr{}, netip.Addr{})
+		if err != nil {
+			t.Fatalf("Lookup(%s) error:\n%+v", ip, err)
+		}
+		return got.ASN
+	}
+	if got := lookup("::ffff:192.0.2.10"); got != 65000 {
+		t.Errorf("Lookup(192.0.2.10) == AS%d, expected AS65000", got)
+	}
+	if got := lookup("::ffff:198.51.100.10"); got != 65001 {
+		t.Errorf("Lookup(198.51.100.10) == AS%d, expected AS65001", got)
+	}
+
+	// Update the file
+	write(`
+- prefix: 192.0.2.0/24
+  asn: 65002
+`)
+	for try := 0; ; try++ {
+		if got := lookup("::ffff:192.0.2.10"); got == 65002 {
+			break
+		} else if try == 20 {
+			t.Fatalf("Lookup(192.0.2.10) == AS%d, expected AS65002", got)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// Invalid file content is ignored
+	write(`garbage: [`)
+	time.Sleep(2 * routeFileReloadDelay)
+	if got := lookup("::ffff:192.0.2.10"); got != 65002 {
+		t.Errorf("Lookup(192.0.2.10) == AS%d, expected AS65002", got)
+	}
+
+	// Non-atomic write: the file is truncated, then written in several steps.
+	f, err := os.OpenFile(routeFile, os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		t.Fatalf("OpenFile() error:\n%+v", err)
+	}
+	defer f.Close()
+	time.Sleep(2 * routeFileReloadDelay)
+	if got := lookup("::ffff:192.0.2.10"); got != 65002 {
+		t.Errorf("Lookup(192.0.2.10) == AS%d after truncate, expected AS65002", got)
+	}
+	for _, chunk := range []string{"- prefix: 192.0.2.0/24\n", "  asn: 65003\n"} {
+		if _, err := f.WriteString(chunk); err != nil {
+			t.Fatalf("WriteString() error:\n%+v", err)
+		}
+		time.Sleep(routeFileReloadDelay / 4)
+	}
+	for try := 0; ; try++ {
+		if got := lookup("::ffff:192.0.2.10"); got == 65003 {
+			break
+		} else if try == 20 {
+			t.Fatalf("Lookup(192.0.2.10) == AS%d, expected AS65003", got)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
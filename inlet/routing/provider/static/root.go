@@ -0,0 +1,181 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package static
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/osrg/gobgp/v3/pkg/packet/bgp"
+
+	"akvorado/common/helpers"
+	"akvorado/common/remotedatasourcefetcher"
+	"akvorado/common/reporter"
+	"akvorado/inlet/routing/provider"
+)
+
+// Provider represents the static provider.
+type Provider struct {
+	r                   *reporter.Reporter
+	routeSourcesFetcher *remotedatasourcefetcher.Component[Route]
+	routesMap           map[string][]Route
+	routesLock          sync.Mutex
+	routes              atomic.Pointer[helpers.SubnetMap[Route]]
+}
+
+// Dependencies define the dependencies of the static provider.
+type Dependencies = provider.Dependencies
+
+// New creates a new static provider from configuration.
+func (configuration Configuration) New(r *reporter.Reporter, _ Dependencies) (provider.Provider, error) {
+	p := &Provider{
+		r:         r,
+		routesMap: map[string][]Route{},
+	}
+	if _, err := buildRoutes(configuration.Routes); err != nil {
+		return nil, err
+	}
+	p.routesMap["static"] = configuration.Routes
+	for _, path := range configuration.RouteFiles {
+		if err := p.loadRouteFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.updateRoutes(); err != nil {
+		return nil, err
+	}
+	if len(configuration.RouteFiles) > 0 {
+		if err := p.watchRouteFiles(configuration.RouteFiles); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	p.routeSourcesFetcher, err = remotedatasourcefetcher.New[Route](r, p.UpdateRemoteDataSource, "routing", configuration.RouteSources)
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize remote data source fetcher component: %w", err)
+	}
+	return p, nil
+}
+
+// Start starts the static provider.
+func (p *Provider) Start() error {
+	p.r.Info().Msg("starting static routing provider")
+	if err := p.routeSourcesFetcher.Start(); err != nil {
+		return fmt.Errorf("unable to start route sources fetcher component: %w", err)
+	}
+	return nil
+}
+
+// Lookup lookups the most specific static route for the provided IP address.
+// The next hop and the agent are ignored. When no route is found, an empty
+// result is returned.
+func (p *Provider) Lookup(_ context.Context, ip netip.Addr, _ netip.Addr, _ netip.Addr) (provider.LookupResult, error) {
+	route, ok := p.routes.Load().Lookup(ip)
+	if !ok {
+		return provider.LookupResult{}, nil
+	}
+	result := provider.LookupResult{
+		Found:   true,
+		ASN:     route.ASN,
+		ASPath:  route.ASPath,
+		NetMask: uint8(route.Prefix.Bits()),
+		NextHop: route.NextHop,
+	}
+	if result.ASN == 0 && len(route.ASPath) > 0 {
+		result.ASN = route.ASPath[len(route.ASPath)-1]
+	}
+	for _, community := range route.Communities {
+		if community.Large {
+			result.LargeCommunities = append(result.LargeCommunities,
+				*bgp.NewLargeCommunity(community.ASN, community.Value1, community.Value2))
+		} else {
+			result.Communities = append(result.Communities, community.ASN<<16|community.Value1)
+		}
+	}
+	return result, nil
+}
+
+// UpdateRemoteDataSource updates a remote routes source. It returns the
+// number of routes retrieved.
+func (p *Provider) UpdateRemoteDataSource(ctx context.Context, name string, source remotedatasourcefetcher.RemoteDataSource) (int, error) {
+	results, err := p.routeSourcesFetcher.Fetch(ctx, name, source)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := buildRoutes(results); err != nil {
+		return 0, err
+	}
+	p.routesLock.Lock()
+	p.routesMap[name] = results
+	p.routesLock.Unlock()
+	if err := p.updateRoutes(); err != nil {
+		return 0, err
+	}
+	return len(results), nil
+}
+
+// updateRoutes rebuilds the routes from the static configuration, the route
+// files, and the remote data sources. Static routes override the other ones.
+func (p *Provider) updateRoutes() error {
+	p.routesLock.Lock()
+	defer p.routesLock.Unlock()
+	finalMap := map[string]Route{}
+	for id, routes := range p.routesMap {
+		if id == "static" {
+			continue
+		}
+		built, _ := buildRoutes(routes)
+		for key, route := range built {
+			// Conflicts across multiple sources are not handled
+			finalMap[key] = route
+		}
+	}
+	built, _ := buildRoutes(p.routesMap["static"])
+	for key, route := range built {
+		finalMap[key] = route
+	}
+	routes, err := helpers.NewSubnetMap(finalMap)
+	if err != nil {
+		return err
+	}
+	p.routes.Store(routes)
+	return nil
+}
+
+// buildRoutes turns a list of routes into a map suitable for a SubnetMap.
+// Prefixes are normalized and IPv4-mapped prefixes are turned into IPv4
+// prefixes. The same prefix can only be present several times with the same
+// data.
+func buildRoutes(routes []Route) (map[string]Route, error) {
+	results := make(map[string]Route, len(routes))
+	for _, route := range routes {
+		prefix := route.Prefix.Masked()
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		route.Prefix = prefix
+		key, err := helpers.SubnetMapParseKey(prefix.String())
+		if err != nil {
+			return nil, fmt.Errorf("invalid prefix %s: %w", route.Prefix, err)
+		}
+		if existing, ok := results[key]; ok && !existing.equal(route) {
+			return nil, fmt.Errorf("conflicting routes for prefix %s", prefix)
+		}
+		results[key] = route
+	}
+	return results, nil
+}
+
+// equal tells if two routes are the same.
+func (r Route) equal(other Route) bool {
+	return r.Prefix == other.Prefix &&
+		r.ASN == other.ASN &&
+		r.NextHop == other.NextHop &&
+		slices.Equal(r.ASPath, other.ASPath) &&
+		slices.Equal(r.Communities, other.Communities)
+}
@@ -0,0 +1,126 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package static
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/osrg/gobgp/v3/pkg/packet/bgp"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/inlet/routing/provider"
+)
+
+func TestLookup(t *testing.T) {
+	r := reporter.NewMock(t)
+	config := Configuration{
+		Routes: []Route{
+			{
+				Prefix:  netip.MustParsePrefix("192.0.2.0/24"),
+				ASN:     65000,
+				NextHop: netip.MustParseAddr("::ffff:198.51.100.1"),
+			}, {
+				Prefix: netip.MustParsePrefix("192.0.2.128/25"),
+				ASPath: []uint32{65001, 65002},
+				Communities: []Community{
+					{ASN: 65001, Value1: 100},
+					{ASN: 65001, Value1: 1, Value2: 2, Large: true},
+				},
+			}, {
+				Prefix: netip.MustParsePrefix("2001:db8::/32"),
+				ASN:    65003,
+			}, {
+				// Identical duplicates are accepted
+				Prefix: netip.MustParsePrefix("2001:db8::/32"),
+				ASN:    65003,
+			},
+		},
+	}
+	p, err := config.New(r, provider.Dependencies{})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+
+	cases := []struct {
+		IP       string
+		Expected provider.LookupResult
+	}{
+		{
+			IP: "::ffff:192.0.2.10",
+			Expected: provider.LookupResult{
+				Found:   true,
+				ASN:     65000,
+				NetMask: 24,
+				NextHop: netip.MustParseAddr("::ffff:198.51.100.1"),
+			},
+		}, {
+			IP: "::ffff:192.0.2.130",
+			Expected: provider.LookupResult{
+				Found:            true,
+				ASN:              65002,
+				ASPath:           []uint32{65001, 65002},
+				Communities:      []uint32{65001<<16 | 100},
+				LargeCommunities: []bgp.LargeCommunity{{ASN: 65001, LocalData1: 1, LocalData2: 2}},
+				NetMask:          25,
+			},
+		}, {
+			IP: "2001:db8:1::1",
+			Expected: provider.LookupResult{
+				Found:   true,
+				ASN:     65003,
+				NetMask: 32,
+			},
+		}, {
+			IP:       "::ffff:198.51.100.10",
+			Expected: provider.LookupResult{},
+		},
+	}
+	for _, tc := range cases {
+		got, err := p.Lookup(context.Background(), netip.MustParseAddr(tc.IP), netip.Addr{}, netip.Addr{})
+		if err != nil {
+			t.Fatalf("Lookup(%s) error:\n%+v", tc.IP, err)
+		}
+		if diff := helpers.Diff(got, tc.Expected); diff != "" {
+			t.Errorf("Lookup(%s) (-got, +want):\n%s", tc.IP, diff)
+		}
+	}
+}
+
+func TestConflictingRoutes(t *testing.T) {
+	r := reporter.NewMock(t)
+	config := Configuration{
+		Routes: []Route{
+			{Prefix: netip.MustParsePrefix("192.0.2.0/24"), ASN: 65000},
+			{Prefix: netip.MustParsePrefix("192.0.2.0/24"), ASN: 65001},
+		},
+	}
+	if _, err := config.New(r, provider.Dependencies{}); err == nil {
+		t.Fatal("New() did not error")
+	}
+
+	// Same prefix once normalized
+	config = Configuration{
+		Routes: []Route{
+			{Prefix: netip.MustParsePrefix("192.0.2.0/24"), ASN: 65000},
+			{Prefix: netip.MustParsePrefix("::ffff:192.0.2.1/120"), ASN: 65001},
+		},
+	}
+	if _, err := config.New(r, provider.Dependencies{}); err == nil {
+		t.Fatal("New() did not error")
+	}
+
+	// Overlapping prefixes are fine
+	config = Configuration{
+		Routes: []Route{
+			{Prefix: netip.MustParsePrefix("192.0.2.0/24"), ASN: 65000},
+			{Prefix: netip.MustParsePrefix("192.0.2.0/25"), ASN: 65001},
+		},
+	}
+	if _, err := config.New(r, provider.Dependencies{}); err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+}
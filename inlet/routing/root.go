@@ -9,8 +9,12 @@ package routing
 import (
 	"context"
 	"net/netip"
+	"sync/atomic"
 	"time"
 
+	tree "github.com/kentik/patricia/generics_tree"
+	"gopkg.in/tomb.v2"
+
 	"akvorado/common/reporter"
 	"akvorado/inlet/routing/provider"
 )
@@ -18,10 +22,14 @@ import (
 // Component represents the metadata compomenent.
 type Component struct {
 	r         *reporter.Reporter
-	provider  provider.Provider
+	t         tomb.Tomb
+	providers []provider.Provider
 	metrics   metrics
 	config    Configuration
 	errLogger reporter.Logger
+
+	// vrps are the validated ROA payloads (nil when not loaded)
+	vrps atomic.Pointer[tree.TreeV6[rpkiVRP]]
 }
 
 // Dependencies define the dependencies of the metadata component.
@@ -35,12 +43,15 @@ func New(r *reporter.Reporter, configuration Configuration, dependencies Depende
 		errLogger: r.Sample(reporter.BurstSampler(time.Minute, 3)),
 	}
 	c.initMetrics()
-	// Initialize the provider
-	selectedProvider, err := configuration.Provider.Config.New(r, dependencies)
-	if err != nil {
-		return nil, err
+	// Initialize the providers
+	for _, p := range configuration.Providers {
+		selectedProvider, err := p.Config.New(r, dependencies)
+		if err != nil {
+			return nil, err
+		}
+		c.providers = append(c.providers, selectedProvider)
 	}
-	c.provider = selectedProvider
+	dependencies.Daemon.Track(&c.t, "inlet/routing")
 
 	return &c, nil
 }
@@ -48,22 +59,35 @@ func New(r *reporter.Reporter, configuration Configuration, dependencies Depende
 // Start starts the routing component.
 func (c *Component) Start() error {
 	c.r.Info().Msg("starting routing component")
-	if starterP, ok := c.provider.(starter); ok {
-		if err := starterP.Start(); err != nil {
-			return err
+	for _, p := range c.providers {
+		if starterP, ok := p.(starter); ok {
+			if err := starterP.Start(); err != nil {
+				return err
+			}
 		}
 	}
+	if c.config.RPKI.Source != "" {
+		c.startRPKI()
+	}
 	return nil
 }
 
 // Stop stops the routing component
 func (c *Component) Stop() error {
 	c.r.Info().Msg("stopping routing component")
-	if stopperP, ok := c.provider.(stopper); ok {
-		if err := stopperP.Stop(); err != nil {
+	if c.config.RPKI.Source != "" {
+		c.t.Kill(nil)
+		if err := c.t.Wait(); err != nil {
 			return err
 		}
 	}
+	for _, p := range c.providers {
+		if stopperP, ok := p.(stopper); ok {
+			if err := stopperP.Stop(); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
@@ -74,13 +98,35 @@ type stopper interface {
 	Stop() error
 }
 
-// Lookup uses the selected provider to get an answer.
+// Lookup uses the configured providers to get an answer. The first provider
+// finding a route is used.
 func (c *Component) Lookup(ctx context.Context, ip netip.Addr, nh netip.Addr, agent netip.Addr) provider.LookupResult {
+	return c.LookupVRF(ctx, ip, nh, agent, 0)
+}
+
+// LookupVRF is like Lookup but looks up routes in the VRF with the provided
+// route distinguisher (0 for the global table). Providers not supporting VRFs
+// use their regular lookup.
+func (c *Component) LookupVRF(ctx context.Context, ip netip.Addr, nh netip.Addr, agent netip.Addr, rd uint64) provider.LookupResult {
 	c.metrics.routingLookups.Inc()
-	result, err := c.provider.Lookup(ctx, ip, nh, agent)
+	var result provider.LookupResult
+	var err error
+	for _, p := range c.providers {
+		if vrfP, ok := p.(provider.VRFProvider); ok && rd != 0 {
+			result, err = vrfP.LookupVRF(ctx, ip, nh, agent, rd)
+		} else {
+			result, err = p.Lookup(ctx, ip, nh, agent)
+		}
+		if err == nil && result.Found {
+			break
+		}
+	}
 	if err != nil {
 		c.metrics.routingLookupsFailed.Inc()
 		c.errLogger.Err(err).Msgf("routing: error while looking up %s at %s", ip.String(), agent.String())
+	} else if result.ASN != 0 && c.config.RPKI.Source != "" {
+		result.RPKIStatus = c.rpkiStatus(ip, result.NetMask, result.ASN)
+		c.metrics.rpkiLookups.WithLabelValues(result.RPKIStatus.String()).Inc()
 	}
 	return result
 }
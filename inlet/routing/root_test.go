@@ -10,6 +10,8 @@ import (
 
 	"akvorado/common/helpers"
 	"akvorado/common/reporter"
+	"akvorado/inlet/routing/provider"
+	"akvorado/inlet/routing/provider/static"
 )
 
 func TestRoutingComponent(t *testing.T) {
@@ -31,3 +33,34 @@ func TestRoutingComponent(t *testing.T) {
 		t.Errorf("Lookup() == %d, expected 0", lookup.ASN)
 	}
 }
+
+func TestRoutingProvidersFallback(t *testing.T) {
+	r := reporter.NewMock(t)
+	c := NewMock(t, r)
+	staticP, err := static.Configuration{
+		Routes: []static.Route{
+			{Prefix: netip.MustParsePrefix("192.0.2.0/24"), ASN: 64501},
+		},
+	}.New(r, provider.Dependencies{})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	c.providers = append(c.providers, staticP)
+	helpers.StartStop(t, c)
+	c.PopulateRIB(t)
+
+	// Found by BMP
+	lookup := c.Lookup(context.Background(),
+		netip.MustParseAddr("::ffff:192.0.2.2"),
+		netip.MustParseAddr("::ffff:198.51.100.200"), netip.Addr{})
+	if lookup.ASN != 174 {
+		t.Errorf("Lookup() == %d, expected 174", lookup.ASN)
+	}
+	// Found by the static provider
+	lookup = c.Lookup(context.Background(),
+		netip.MustParseAddr("::ffff:192.0.2.254"),
+		netip.MustParseAddr("::ffff:198.51.100.200"), netip.Addr{})
+	if lookup.ASN != 64501 {
+		t.Errorf("Lookup() == %d, expected 64501", lookup.ASN)
+	}
+}
@@ -0,0 +1,175 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kentik/patricia"
+	tree "github.com/kentik/patricia/generics_tree"
+
+	"akvorado/common/schema"
+)
+
+// rpkiVRP is a validated ROA payload. The maximum length is for an IPv6
+// prefix (IPv4 prefixes are mapped to IPv6).
+type rpkiVRP struct {
+	asn       uint32
+	maxLength uint8
+}
+
+// rpkiASN is an AS number in a VRP export. Routinator exports it as a string
+// ("AS65000") while rpki-client exports it as a number.
+type rpkiASN uint32
+
+// UnmarshalJSON decodes an AS number from a number or a string.
+func (asn *rpkiASN) UnmarshalJSON(input []byte) error {
+	var text string
+	if err := json.Unmarshal(input, &text); err != nil {
+		text = string(input)
+	}
+	text = strings.TrimPrefix(strings.ToUpper(text), "AS")
+	value, err := strconv.ParseUint(text, 10, 32)
+	if err != nil {
+		return fmt.Errorf("cannot parse AS number %s: %w", input, err)
+	}
+	*asn = rpkiASN(value)
+	return nil
+}
+
+// rpkiExport is the JSON export of VRPs.
+type rpkiExport struct {
+	ROAs []struct {
+		ASN       rpkiASN `json:"asn"`
+		Prefix    string  `json:"prefix"`
+		MaxLength int     `json:"maxLength"`
+	} `json:"roas"`
+}
+
+// startRPKI starts the goroutine loading and refreshing VRPs.
+func (c *Component) startRPKI() {
+	c.t.Go(func() error {
+		ticker := time.NewTicker(c.config.RPKI.Interval)
+		defer ticker.Stop()
+		for {
+			c.refreshRPKI(c.t.Context(nil))
+			select {
+			case <-c.t.Dying():
+				return nil
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// refreshRPKI loads VRPs and replaces the current set. On error, the previous
+// set is kept.
+func (c *Component) refreshRPKI(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, c.config.RPKI.Timeout)
+	defer cancel()
+	vrps, count, err := c.loadRPKI(ctx)
+	if err != nil {
+		c.r.Err(err).Str("source", c.config.RPKI.Source).Msg("cannot load RPKI VRPs")
+		c.metrics.rpkiErrors.Inc()
+		return
+	}
+	c.vrps.Store(vrps)
+	c.metrics.rpkiUpdates.Inc()
+	c.metrics.rpkiVRPs.Set(float64(count))
+}
+
+// loadRPKI fetches and parses VRPs. It returns them as a tree and their number.
+func (c *Component) loadRPKI(ctx context.Context) (*tree.TreeV6[rpkiVRP], int, error) {
+	var reader io.Reader
+	source := c.config.RPKI.Source
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+		if err != nil {
+			return nil, 0, fmt.Errorf("cannot build request: %w", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, 0, fmt.Errorf("cannot fetch %s: %w", source, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, 0, fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, source)
+		}
+		reader = resp.Body
+	} else {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, 0, fmt.Errorf("cannot open %s: %w", source, err)
+		}
+		defer f.Close()
+		reader = f
+	}
+	return parseRPKI(reader)
+}
+
+// parseRPKI parses a JSON export of VRPs.
+func parseRPKI(reader io.Reader) (*tree.TreeV6[rpkiVRP], int, error) {
+	var export rpkiExport
+	if err := json.NewDecoder(reader).Decode(&export); err != nil {
+		return nil, 0, fmt.Errorf("cannot decode VRPs: %w", err)
+	}
+	if export.ROAs == nil {
+		return nil, 0, errors.New("no VRPs found")
+	}
+	vrps := tree.NewTreeV6[rpkiVRP]()
+	for _, roa := range export.ROAs {
+		prefix, err := netip.ParsePrefix(roa.Prefix)
+		if err != nil {
+			return nil, 0, fmt.Errorf("cannot parse prefix %q: %w", roa.Prefix, err)
+		}
+		bits, maxLength := prefix.Bits(), roa.MaxLength
+		if maxLength < bits {
+			maxLength = bits
+		}
+		if prefix.Addr().Is4() {
+			bits += 96
+			maxLength += 96
+		}
+		addr := prefix.Addr().As16()
+		vrps.Add(patricia.NewIPv6Address(addr[:], uint(bits)), rpkiVRP{
+			asn:       uint32(roa.ASN),
+			maxLength: uint8(min(maxLength, 128)),
+		}, nil)
+	}
+	return vrps, len(export.ROAs), nil
+}
+
+// rpkiStatus returns the RPKI origin validation status for a route (RFC 6811).
+// The prefix length is for an IPv4 prefix when the address is an IPv4 one.
+func (c *Component) rpkiStatus(ip netip.Addr, plen uint8, asn uint32) schema.RPKIStatus {
+	vrps := c.vrps.Load()
+	if vrps == nil {
+		return schema.RPKIStatusUnknown
+	}
+	bits := int(plen)
+	if ip.Is4() || ip.Is4In6() {
+		bits += 96
+	}
+	addr := ip.As16()
+	covering := vrps.FindTags(patricia.NewIPv6Address(addr[:], uint(bits)))
+	if len(covering) == 0 {
+		return schema.RPKIStatusNotFound
+	}
+	for _, vrp := range covering {
+		if vrp.asn != 0 && vrp.asn == asn && bits <= int(vrp.maxLength) {
+			return schema.RPKIStatusValid
+		}
+	}
+	return schema.RPKIStatusInvalid
+}
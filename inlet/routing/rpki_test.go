@@ -0,0 +1,102 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package routing
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+)
+
+func TestParseRPKI(t *testing.T) {
+	_, count, err := parseRPKI(strings.NewReader(`{"roas": [
+  {"asn": "AS65000", "prefix": "192.0.2.0/24", "maxLength": 24, "ta": "ripe"},
+  {"asn": 65001, "prefix": "2001:db8::/32", "maxLength": 48}
+]}`))
+	if err != nil {
+		t.Fatalf("parseRPKI() error:\n%+v", err)
+	}
+	if count != 2 {
+		t.Errorf("parseRPKI() count == %d, expected 2", count)
+	}
+
+	for _, input := range []string{
+		`{}`,
+		`{"roas": [{"asn": "ASxxx", "prefix": "192.0.2.0/24", "maxLength": 24}]}`,
+		`{"roas": [{"asn": 65000, "prefix": "192.0.2.0", "maxLength": 24}]}`,
+		`garbage`,
+	} {
+		if _, _, err := parseRPKI(strings.NewReader(input)); err == nil {
+			t.Errorf("parseRPKI(%q) did not error", input)
+		}
+	}
+}
+
+func TestRPKIStatus(t *testing.T) {
+	vrpFile := filepath.Join(t.TempDir(), "vrps.json")
+	if err := os.WriteFile(vrpFile, []byte(`{"roas": [
+  {"asn": "AS65000", "prefix": "192.0.2.0/24", "maxLength": 24},
+  {"asn": "AS65001", "prefix": "198.51.100.0/22", "maxLength": 24},
+  {"asn": "AS0", "prefix": "203.0.113.0/24", "maxLength": 24},
+  {"asn": 65002, "prefix": "2001:db8::/32", "maxLength": 48}
+]}`), 0o644); err != nil {
+		t.Fatalf("WriteFile() error:\n%+v", err)
+	}
+	r := reporter.NewMock(t)
+	c := NewMock(t, r)
+	c.config.RPKI.Source = vrpFile
+	c.refreshRPKI(context.Background())
+
+	cases := []struct {
+		IP       string
+		PLen     uint8
+		ASN      uint32
+		Expected schema.RPKIStatus
+	}{
+		{"::ffff:192.0.2.10", 24, 65000, schema.RPKIStatusValid},
+		{"::ffff:192.0.2.10", 24, 65001, schema.RPKIStatusInvalid},
+		{"::ffff:192.0.2.10", 25, 65000, schema.RPKIStatusInvalid},
+		{"::ffff:198.51.101.10", 24, 65001, schema.RPKIStatusValid},
+		{"::ffff:198.51.101.10", 23, 65001, schema.RPKIStatusValid},
+		{"::ffff:198.51.101.10", 25, 65001, schema.RPKIStatusInvalid},
+		{"::ffff:203.0.113.10", 24, 65000, schema.RPKIStatusInvalid},
+		{"::ffff:192.0.3.10", 24, 65000, schema.RPKIStatusNotFound},
+		{"2001:db8:1::1", 48, 65002, schema.RPKIStatusValid},
+		{"2001:db8:1::1", 64, 65002, schema.RPKIStatusInvalid},
+		{"2001:db9::1", 32, 65002, schema.RPKIStatusNotFound},
+	}
+	for _, tc := range cases {
+		got := c.rpkiStatus(netip.MustParseAddr(tc.IP), tc.PLen, tc.ASN)
+		if got != tc.Expected {
+			t.Errorf("rpkiStatus(%s/%d, AS%d) == %s, expected %s",
+				tc.IP, tc.PLen, tc.ASN, got, tc.Expected)
+		}
+	}
+
+	// On error, the previous set is kept
+	if err := os.WriteFile(vrpFile, []byte(`garbage`), 0o644); err != nil {
+		t.Fatalf("WriteFile() error:\n%+v", err)
+	}
+	c.refreshRPKI(context.Background())
+	if got := c.rpkiStatus(netip.MustParseAddr("::ffff:192.0.2.10"), 24, 65000); got != schema.RPKIStatusValid {
+		t.Errorf("rpkiStatus() == %s, expected %s", got, schema.RPKIStatusValid)
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_routing_rpki_")
+	expectedMetrics := map[string]string{
+		`errors_total`:  "1",
+		`updates_total`: "1",
+		`vrps`:          "4",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
@@ -21,7 +21,7 @@ func NewMock(t *testing.T, r *reporter.Reporter) *Component {
 	bmpConfigP := bmpConfig.(bmp.Configuration)
 	bmpConfigP.Listen = "127.0.0.1:0"
 	config := DefaultConfiguration()
-	config.Provider.Config = bmpConfigP
+	config.Providers = []ProviderConfiguration{{Config: bmpConfigP}}
 	c, err := New(r, config, Dependencies{
 		Daemon: daemon.NewMock(t),
 	})
@@ -33,5 +33,5 @@ func NewMock(t *testing.T, r *reporter.Reporter) *Component {
 
 // PopulateRIB adds some entries to the BMP provider.
 func (c *Component) PopulateRIB(t *testing.T) {
-	c.provider.(*bmp.Provider).PopulateRIB(t)
+	c.providers[0].(*bmp.Provider).PopulateRIB(t)
 }
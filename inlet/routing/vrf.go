@@ -0,0 +1,47 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package routing
+
+import (
+	"net/netip"
+	"slices"
+)
+
+// VRF returns the name and the route distinguisher of the VRF of a flow from
+// its exporter, its input interface and its source VLAN. When no VRF matches,
+// an empty name and a 0 route distinguisher (the global table) are returned.
+func (c *Component) VRF(exporter netip.Addr, inIf uint32, vlan uint16) (string, uint64) {
+	for _, vrf := range c.config.VRFs {
+		if !vrf.matchExporter(exporter) {
+			continue
+		}
+		if len(vrf.Interfaces) == 0 && len(vrf.VLANs) == 0 {
+			return vrf.Name, uint64(vrf.RD)
+		}
+		if inIf != 0 && slices.Contains(vrf.Interfaces, inIf) {
+			return vrf.Name, uint64(vrf.RD)
+		}
+		if vlan != 0 && slices.Contains(vrf.VLANs, vlan) {
+			return vrf.Name, uint64(vrf.RD)
+		}
+	}
+	return "", 0
+}
+
+// matchExporter tells if the exporter is part of the VRF.
+func (vrf VRFConfiguration) matchExporter(exporter netip.Addr) bool {
+	if len(vrf.Exporters) == 0 {
+		return true
+	}
+	for _, prefix := range vrf.Exporters {
+		if prefix.Addr().Is4() {
+			if prefix.Contains(exporter.Unmap()) {
+				return true
+			}
+		} else if prefix.Contains(exporter) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package routing
+
+import (
+	"net/netip"
+	"testing"
+
+	"akvorado/common/reporter"
+	"akvorado/inlet/routing/provider/bmp"
+)
+
+func TestVRF(t *testing.T) {
+	r := reporter.NewMock(t)
+	c := NewMock(t, r)
+	c.config.VRFs = []VRFConfiguration{
+		{
+			Name:       "customer-a",
+			RD:         bmp.MustParseRD("65000:100"),
+			Exporters:  []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")},
+			Interfaces: []uint32{10, 11},
+		}, {
+			Name:      "customer-b",
+			RD:        bmp.MustParseRD("65000:200"),
+			Exporters: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")},
+			VLANs:     []uint16{200},
+		}, {
+			Name:      "customer-c",
+			RD:        bmp.MustParseRD("65000:300"),
+			Exporters: []netip.Prefix{netip.MustParsePrefix("2001:db8::/32")},
+		},
+	}
+
+	cases := []struct {
+		Exporter string
+		InIf     uint32
+		VLAN     uint16
+		Name     string
+		RD       string
+	}{
+		{"::ffff:192.0.2.1", 10, 0, "customer-a", "65000:100"},
+		{"::ffff:192.0.2.1", 11, 200, "customer-a", "65000:100"},
+		{"::ffff:192.0.2.1", 12, 200, "customer-b", "65000:200"},
+		{"::ffff:192.0.2.1", 12, 0, "", ""},
+		{"::ffff:198.51.100.1", 10, 200, "", ""},
+		{"2001:db8::1", 12, 0, "customer-c", "65000:300"},
+	}
+	for _, tc := range cases {
+		name, rd := c.VRF(netip.MustParseAddr(tc.Exporter), tc.InIf, tc.VLAN)
+		expectedRD := uint64(0)
+		if tc.RD != "" {
+			expectedRD = uint64(bmp.MustParseRD(tc.RD))
+		}
+		if name != tc.Name || rd != expectedRD {
+			t.Errorf("VRF(%s, %d, %d) == %q, %d, expected %q, %d",
+				tc.Exporter, tc.InIf, tc.VLAN, name, rd, tc.Name, expectedRD)
+		}
+	}
+}
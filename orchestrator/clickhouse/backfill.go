@@ -0,0 +1,286 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package clickhouse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// backfillRequest describes the time range to rebuild in consolidated tables.
+type backfillRequest struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// MaxRowsPerSecond overrides the configured throttling when not 0.
+	MaxRowsPerSecond uint64 `json:"max-rows-per-second,omitempty"`
+}
+
+// backfillStatus describes the progress of a backfill.
+type backfillStatus struct {
+	State     string    `json:"state"` // running, done, failed, or interrupted
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	DoneUntil time.Time `json:"done-until"`
+	Rows      uint64    `json:"rows"`
+	Tables    []string  `json:"tables"`
+	Error     string    `json:"error,omitempty"`
+}
+
+var errBackfillRunning = errors.New("a backfill is already running")
+
+// alignTime aligns a time on a multiple of the provided duration since the
+// epoch, like toStartOfInterval() in ClickHouse. When up is true, the time is
+// rounded up.
+func alignTime(t time.Time, d time.Duration, up bool) time.Time {
+	seconds := int64(d.Seconds())
+	aligned := t.Unix() / seconds * seconds
+	if up && aligned < t.Unix() {
+		aligned += seconds
+	}
+	return time.Unix(aligned, 0).UTC()
+}
+
+// backfillSlice returns the time range rebuilt at once. It is a multiple of
+// all the consolidation intervals, so a consolidated row is never split
+// between two slices.
+func (c *Component) backfillSlice() time.Duration {
+	gcd := func(a, b int64) int64 {
+		for b != 0 {
+			a, b = b, a%b
+		}
+		return a
+	}
+	slice := max(int64(c.config.Backfill.Slice.Seconds()), 1)
+	for _, resolution := range c.config.Resolutions {
+		if interval := int64(resolution.Interval.Seconds()); interval > 0 {
+			slice = slice / gcd(slice, interval) * interval
+		}
+	}
+	return time.Duration(slice) * time.Second
+}
+
+// backfillRange checks a backfill request and returns the time range to
+// rebuild, aligned on slices. The range should only contain flows still
+// present in the main table and should be old enough to not receive new
+// flows.
+func (c *Component) backfillRange(req backfillRequest, now time.Time) (time.Time, time.Time, error) {
+	if req.Start.IsZero() || req.End.IsZero() {
+		return time.Time{}, time.Time{}, errors.New("start and end are mandatory")
+	}
+	if !req.Start.Before(req.End) {
+		return time.Time{}, time.Time{}, errors.New("start should be before end")
+	}
+	if len(c.config.Resolutions) < 2 {
+		return time.Time{}, time.Time{}, errors.New("no consolidated table to backfill")
+	}
+	slice := c.backfillSlice()
+	start := alignTime(req.Start, slice, false)
+	end := alignTime(req.End, slice, true)
+	if limit := alignTime(now, slice, false).Add(-slice); end.After(limit) {
+		return time.Time{}, time.Time{}, fmt.Errorf("end should not be after %s",
+			limit.Format(time.RFC3339))
+	}
+	if ttl := c.config.Resolutions[0].TTL; ttl > 0 && start.Before(now.Add(-ttl)) {
+		return time.Time{}, time.Time{}, fmt.Errorf("start should not be before %s as older flows have expired",
+			alignTime(now.Add(-ttl), slice, true).Format(time.RFC3339))
+	}
+	return start, end, nil
+}
+
+// backfillStatements returns the statements to rebuild a slice of a
+// consolidated table: the existing rows are deleted, then the rows are
+// computed again from the main table. Executing them again after an
+// interruption does not count flows twice.
+func (c *Component) backfillStatements(resolution ResolutionConfiguration, from, to time.Time) (string, string, error) {
+	condition := fmt.Sprintf("TimeReceived >= toDateTime(%d) AND TimeReceived < toDateTime(%d)",
+		from.Unix(), to.Unix())
+	tableName := c.config.TableName(fmt.Sprintf("flows_%s", resolution.Interval))
+	selectQuery, err := c.consolidationSelect(resolution, c.distributedTable(c.config.TableName("flows")))
+	if err != nil {
+		return "", "", fmt.Errorf("cannot build select statement for %s: %w", tableName, err)
+	}
+	deleteStatement := fmt.Sprintf("ALTER TABLE %s DELETE WHERE %s",
+		c.flowsTableName(resolution), condition)
+	insertStatement := fmt.Sprintf("INSERT INTO %s.%s (%s) %s\nWHERE %s",
+		c.config.Database, c.distributedTable(tableName),
+		strings.Join(append([]string{"TimeReceived"}, c.consolidationColumns(resolution)...), ", "),
+		strings.TrimSpace(selectQuery), condition)
+	return deleteStatement, insertStatement, nil
+}
+
+// runBackfill rebuilds the consolidated tables, one slice at a time, from
+// where the provided backfill has stopped. The progress is recorded in the
+// provided status.
+func (c *Component) runBackfill(ctx context.Context, status *backfillStatus, maxRowsPerSecond uint64) error {
+	slice := c.backfillSlice()
+	c.backfillLock.Lock()
+	from, end := status.DoneUntil, status.End
+	c.backfillLock.Unlock()
+	for ; from.Before(end); from = from.Add(slice) {
+		to := from.Add(slice)
+		begin := time.Now()
+		var counts []struct {
+			Count uint64 `ch:"count"`
+		}
+		if err := c.d.ClickHouse.Select(ctx, &counts, fmt.Sprintf(`
+SELECT count() AS count
+FROM %s.%s
+WHERE TimeReceived >= toDateTime(%d) AND TimeReceived < toDateTime(%d)
+`, c.config.Database, c.distributedTable(c.config.TableName("flows")), from.Unix(), to.Unix())); err != nil {
+			return fmt.Errorf("cannot count flows: %w", err)
+		}
+		rows := uint64(0)
+		if len(counts) > 0 {
+			rows = counts[0].Count
+		}
+		for _, resolution := range c.config.Resolutions {
+			if resolution.Interval == 0 {
+				continue
+			}
+			deleteStatement, insertStatement, err := c.backfillStatements(resolution, from, to)
+			if err != nil {
+				return err
+			}
+			tableName := c.flowsTableName(resolution)
+			if err := c.d.ClickHouse.ExecOnCluster(ctx, deleteStatement); err != nil {
+				return fmt.Errorf("cannot delete flows from %s: %w", tableName, err)
+			}
+			if err := c.waitMutations(ctx, tableName); err != nil {
+				return err
+			}
+			if err := c.d.ClickHouse.Exec(ctx, insertStatement); err != nil {
+				return fmt.Errorf("cannot backfill %s: %w", tableName, err)
+			}
+		}
+		c.backfillLock.Lock()
+		status.DoneUntil = to
+		status.Rows += rows
+		c.backfillLock.Unlock()
+		c.metrics.backfilledSlices.Inc()
+
+		if maxRowsPerSecond > 0 {
+			wait := time.Duration(float64(rows)/float64(maxRowsPerSecond)*float64(time.Second)) -
+				time.Since(begin)
+			if wait > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(wait):
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// startBackfill starts rebuilding the consolidated tables in the background.
+// When the last backfill for the same time range has failed or has been
+// interrupted, it resumes where it has stopped.
+func (c *Component) startBackfill(req backfillRequest, now time.Time) (backfillStatus, error) {
+	start, end, err := c.backfillRange(req, now)
+	if err != nil {
+		return backfillStatus{}, err
+	}
+	maxRowsPerSecond := c.config.Backfill.MaxRowsPerSecond
+	if req.MaxRowsPerSecond > 0 {
+		maxRowsPerSecond = req.MaxRowsPerSecond
+	}
+
+	c.backfillLock.Lock()
+	defer c.backfillLock.Unlock()
+	previous := c.backfill
+	if previous != nil && previous.State == "running" {
+		return backfillStatus{}, errBackfillRunning
+	}
+	if !c.t.Alive() {
+		return backfillStatus{}, errors.New("component is stopping")
+	}
+	status := &backfillStatus{
+		State:     "running",
+		Start:     start,
+		End:       end,
+		DoneUntil: start,
+		Tables:    []string{},
+	}
+	for _, resolution := range c.config.Resolutions[1:] {
+		status.Tables = append(status.Tables, c.flowsTableName(resolution))
+	}
+	if previous != nil && previous.State != "done" &&
+		previous.Start.Equal(start) && previous.End.Equal(end) {
+		status.DoneUntil = previous.DoneUntil
+		status.Rows = previous.Rows
+	}
+	c.backfill = status
+	c.r.Info().Msgf("backfill consolidated tables from %s to %s",
+		status.DoneUntil.Format(time.RFC3339), end.Format(time.RFC3339))
+
+	c.t.Go(func() error {
+		err := c.runBackfill(c.t.Context(nil), status, maxRowsPerSecond)
+		c.backfillLock.Lock()
+		defer c.backfillLock.Unlock()
+		switch {
+		case err == nil:
+			status.State = "done"
+			c.r.Info().Msg("backfill of consolidated tables done")
+		case !c.t.Alive():
+			status.State = "interrupted"
+		default:
+			status.State = "failed"
+			status.Error = err.Error()
+			c.r.Err(err).Msg("unable to backfill consolidated tables")
+		}
+		return nil
+	})
+
+	result := *status
+	return result, nil
+}
+
+// backfillHandler handles requests to backfill consolidated tables (POST)
+// and to get the progress of the last backfill (GET).
+func (c *Component) backfillHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		c.backfillLock.Lock()
+		var status *backfillStatus
+		if c.backfill != nil {
+			copied := *c.backfill
+			status = &copied
+		}
+		c.backfillLock.Unlock()
+		if status == nil {
+			http.Error(w, "No backfill started.", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(status)
+	case http.MethodPost:
+		var req backfillRequest
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid request: %s.", err), http.StatusBadRequest)
+			return
+		}
+		status, err := c.startBackfill(req, time.Now())
+		if errors.Is(err, errBackfillRunning) {
+			http.Error(w, "A backfill is already running.", http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid request: %s.", err), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(status)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+	}
+}
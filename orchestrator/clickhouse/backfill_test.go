@@ -0,0 +1,190 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/mock/gomock"
+
+	"akvorado/common/clickhousedb"
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+)
+
+func TestBackfillRange(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 30, 0, 0, time.UTC)
+	date := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 3, day, hour, minute, 0, 0, time.UTC)
+	}
+	cases := []struct {
+		Description   string
+		Resolutions   []ResolutionConfiguration
+		Request       backfillRequest
+		ExpectedSlice time.Duration
+		ExpectedStart time.Time
+		ExpectedEnd   time.Time
+		Error         bool
+	}{
+		{
+			Description:   "aligned range",
+			Request:       backfillRequest{Start: date(5, 0, 0), End: date(6, 0, 0)},
+			ExpectedSlice: time.Hour,
+			ExpectedStart: date(5, 0, 0),
+			ExpectedEnd:   date(6, 0, 0),
+		}, {
+			Description:   "unaligned range",
+			Request:       backfillRequest{Start: date(5, 0, 10), End: date(5, 10, 10)},
+			ExpectedSlice: time.Hour,
+			ExpectedStart: date(5, 0, 0),
+			ExpectedEnd:   date(5, 11, 0),
+		}, {
+			Description: "slice extended to the intervals",
+			Resolutions: []ResolutionConfiguration{
+				{Interval: 0, TTL: 15 * 24 * time.Hour},
+				{Interval: 7 * time.Minute, TTL: 30 * 24 * time.Hour},
+				{Interval: 2 * time.Hour},
+			},
+			Request:       backfillRequest{Start: date(5, 0, 0), End: date(5, 1, 0)},
+			ExpectedSlice: 14 * time.Hour,
+			ExpectedStart: date(4, 16, 0), // aligned on the epoch
+			ExpectedEnd:   date(5, 6, 0),
+		}, {
+			Description: "missing end",
+			Request:     backfillRequest{Start: date(5, 0, 0)},
+			Error:       true,
+		}, {
+			Description: "reversed range",
+			Request:     backfillRequest{Start: date(6, 0, 0), End: date(5, 0, 0)},
+			Error:       true,
+		}, {
+			Description: "too recent",
+			Request:     backfillRequest{Start: date(10, 0, 0), End: date(10, 12, 0)},
+			Error:       true,
+		}, {
+			Description: "expired flows",
+			Request:     backfillRequest{Start: date(1, 0, 0), End: date(2, 0, 0)},
+			Resolutions: []ResolutionConfiguration{
+				{Interval: 0, TTL: 5 * 24 * time.Hour},
+				{Interval: time.Minute},
+			},
+			Error: true,
+		}, {
+			Description: "no consolidated table",
+			Request:     backfillRequest{Start: date(5, 0, 0), End: date(6, 0, 0)},
+			Resolutions: []ResolutionConfiguration{{Interval: 0}},
+			Error:       true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			config := DefaultConfiguration()
+			if tc.Resolutions != nil {
+				config.Resolutions = tc.Resolutions
+			}
+			c := Component{config: config}
+			start, end, err := c.backfillRange(tc.Request, now)
+			if err == nil && tc.Error {
+				t.Fatal("backfillRange() did not error")
+			} else if err != nil && !tc.Error {
+				t.Fatalf("backfillRange() error:\n%+v", err)
+			}
+			if tc.Error {
+				return
+			}
+			if got := c.backfillSlice(); got != tc.ExpectedSlice {
+				t.Errorf("backfillSlice() == %s, expected %s", got, tc.ExpectedSlice)
+			}
+			if diff := helpers.Diff([]time.Time{start, end},
+				[]time.Time{tc.ExpectedStart, tc.ExpectedEnd}); diff != "" {
+				t.Errorf("backfillRange() (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRunBackfill(t *testing.T) {
+	r := reporter.NewMock(t)
+	chComponent, mockConn := clickhousedb.NewMock(t, r)
+	config := DefaultConfiguration()
+	config.Resolutions = []ResolutionConfiguration{
+		{Interval: 0},
+		{Interval: time.Minute},
+		{Interval: time.Hour},
+	}
+	c := Component{
+		r:      r,
+		d:      &Dependencies{ClickHouse: chComponent, Schema: schema.NewMock(t)},
+		config: config,
+	}
+	c.initMetrics()
+	start := time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)
+	status := &backfillStatus{
+		State:     "running",
+		Start:     start,
+		End:       start.Add(2 * time.Hour),
+		DoneUntil: start.Add(time.Hour), // resume after the first slice
+	}
+
+	from, to := start.Add(time.Hour).Unix(), start.Add(2*time.Hour).Unix()
+	condition := fmt.Sprintf("TimeReceived >= toDateTime(%d) AND TimeReceived < toDateTime(%d)", from, to)
+	gomock.InOrder(
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), fmt.Sprintf(`
+SELECT count() AS count
+FROM default.flows
+WHERE %s
+`, condition)).
+			SetArg(1, []struct {
+				Count uint64 `ch:"count"`
+			}{{1000}}).
+			Return(nil),
+		mockConn.EXPECT().
+			Exec(gomock.Any(), fmt.Sprintf("ALTER TABLE flows_1m0s DELETE WHERE %s", condition)).
+			Return(nil),
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any(), "default", "flows_1m0s").
+			Return(nil),
+		mockConn.EXPECT().
+			Exec(gomock.Any(), gomock.Cond(func(statement string) bool {
+				return strings.HasPrefix(statement, "INSERT INTO default.flows_1m0s (TimeReceived, ") &&
+					strings.Contains(statement, "toStartOfInterval(TimeReceived, toIntervalSecond(60)) AS TimeReceived") &&
+					strings.HasSuffix(statement, fmt.Sprintf("FROM default.flows\nWHERE %s", condition))
+			})).
+			Return(nil),
+		mockConn.EXPECT().
+			Exec(gomock.Any(), fmt.Sprintf("ALTER TABLE flows_1h0m0s DELETE WHERE %s", condition)).
+			Return(nil),
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any(), "default", "flows_1h0m0s").
+			Return(nil),
+		mockConn.EXPECT().
+			Exec(gomock.Any(), gomock.Cond(func(statement string) bool {
+				return strings.HasPrefix(statement, "INSERT INTO default.flows_1h0m0s (TimeReceived, ")
+			})).
+			Return(nil),
+	)
+
+	if err := c.runBackfill(context.Background(), status, 0); err != nil {
+		t.Fatalf("runBackfill() error:\n%+v", err)
+	}
+	if !status.DoneUntil.Equal(status.End) {
+		t.Errorf("runBackfill() done until %s, expected %s", status.DoneUntil, status.End)
+	}
+	if status.Rows != 1000 {
+		t.Errorf("runBackfill() rows == %d, expected 1000", status.Rows)
+	}
+	gotMetrics := r.GetMetrics("akvorado_orchestrator_clickhouse_", "backfilled_")
+	expectedMetrics := map[string]string{
+		"backfilled_slices_total": "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Errorf("Metrics (-got, +want):\n%s", diff)
+	}
+}
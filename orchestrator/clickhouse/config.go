@@ -12,6 +12,7 @@ import (
 	"akvorado/common/clickhousedb"
 	"akvorado/common/helpers"
 	"akvorado/common/kafka"
+	"akvorado/common/schema"
 
 	"github.com/mitchellh/mapstructure"
 )
@@ -21,22 +22,42 @@ type Configuration struct {
 	clickhousedb.Configuration `mapstructure:",squash" yaml:"-,inline"`
 	// SkipMigrations tell if we should skip migrations.
 	SkipMigrations bool
+	// ReplicationPath is the path in ZooKeeper of replicated tables when
+	// operating on a cluster. {table} is replaced by the name of the table.
+	// Other macros are expanded by ClickHouse.
+	ReplicationPath string
+	// ReplicaName is the name of the replica for replicated tables when
+	// operating on a cluster.
+	ReplicaName string
 	// Kafka describes Kafka-specific configuration
 	Kafka KafkaConfiguration
 	// Resolutions describe the various resolutions to use to
 	// store data and the associated TTLs.
 	Resolutions []ResolutionConfiguration `validate:"min=1,dive"`
+	// IndexMaterialization tells how to build new data-skipping indexes and
+	// projections for existing data.
+	IndexMaterialization IndexMaterializationConfiguration
+	// Backfill tells how consolidated tables are rebuilt from the main
+	// flows table.
+	Backfill BackfillConfiguration
 	// MaxPartitions define the number of partitions to have for a
 	// consolidated flow tables when full.
 	MaxPartitions int `validate:"isdefault|min=1"`
 	// SystemLogTTL is the TTL to set for system log tables.
 	SystemLogTTL time.Duration `validate:"isdefault|min=1m"`
+	// IngestionCheck configures the periodic checks of the ingestion of
+	// flows in ClickHouse.
+	IngestionCheck IngestionCheckConfiguration
 	// PrometheusEndpoint defines the endpoint ClickHouse can use to expose
 	// metrics to Prometheus. If not defined, this is not configured.
 	PrometheusEndpoint string
 	// ASNs is a mapping from AS numbers to names. It replaces or
 	// extends the builtin list of AS numbers.
 	ASNs map[uint32]string
+	// DictionaryFiles maps the name of a builtin dictionary (asns,
+	// protocols, dscp, tcp, or udp) to a CSV file whose entries replace or
+	// extend the builtin ones.
+	DictionaryFiles map[string]string `validate:"dive,required"`
 	// Networks is a mapping from IP networks to attributes. It is used
 	// to instantiate the SrcNet* and DstNet* columns.
 	Networks *helpers.SubnetMap[NetworkAttributes] `validate:"omitempty,dive"`
@@ -72,6 +93,86 @@ type ResolutionConfiguration struct {
 	// TTL is how long to keep data for this resolution. A
 	// value of 0 means to never expire.
 	TTL time.Duration `validate:"isdefault|min=1h"`
+	// StoragePolicy is the ClickHouse storage policy to use for the
+	// table. When empty, the default storage policy is used.
+	StoragePolicy string
+	// Moves tells when to move data to another volume of the storage
+	// policy.
+	Moves []MoveConfiguration `validate:"dive"`
+	// Dimensions is the list of dimensions to keep in a consolidated
+	// table. When empty, all dimensions are kept. Primary keys are always
+	// kept.
+	Dimensions []schema.ColumnKey
+	// Indexes is the list of data-skipping indexes to add to the table.
+	Indexes []IndexConfiguration `validate:"dive"`
+	// Projections is the list of projections to add to the table.
+	Projections []ProjectionConfiguration `validate:"dive"`
+}
+
+// IndexConfiguration describes a data-skipping index on a column.
+type IndexConfiguration struct {
+	// Column is the column to index.
+	Column schema.ColumnKey `validate:"required"`
+	// Type is the type of the index, for example bloom_filter or
+	// minmax. The default is bloom_filter.
+	Type string
+	// Granularity is the number of granules covered by one index entry.
+	Granularity int `validate:"min=0"`
+}
+
+// ProjectionConfiguration describes a projection storing the rows of the
+// table in another order.
+type ProjectionConfiguration struct {
+	// Name is the name of the projection.
+	Name string `validate:"required"`
+	// OrderBy is the list of columns to sort the rows of the projection.
+	OrderBy []schema.ColumnKey `validate:"min=1"`
+}
+
+// IndexMaterializationConfiguration describes how data-skipping indexes and
+// projections are built for existing data.
+type IndexMaterializationConfiguration struct {
+	// Mode is either immediate (one mutation for the whole table during
+	// migration), throttled (one partition at a time, in the background,
+	// after the migration), or never (only new data is indexed).
+	Mode string `validate:"oneof=immediate throttled never"`
+	// Delay is the delay between the materialization of two partitions in
+	// throttled mode.
+	Delay time.Duration `validate:"min=0"`
+}
+
+// MoveConfiguration describes when to move data to a volume.
+type MoveConfiguration struct {
+	// After is the age of the data before moving it to the volume.
+	After time.Duration `validate:"min=1m"`
+	// Volume is the name of the volume to move data to.
+	Volume string `validate:"required"`
+}
+
+// BackfillConfiguration describes how consolidated tables are rebuilt from
+// the main flows table.
+type BackfillConfiguration struct {
+	// Slice is the time range rebuilt at once. It is rounded up to a
+	// multiple of the consolidation intervals.
+	Slice time.Duration `validate:"min=1m"`
+	// MaxRowsPerSecond is the maximum number of rows to read from the main
+	// flows table per second. 0 means no limit.
+	MaxRowsPerSecond uint64
+}
+
+// IngestionCheckConfiguration describes the checks of the ingestion of flows
+// in ClickHouse.
+type IngestionCheckConfiguration struct {
+	// Interval is the interval between two checks. A value of 0 disables
+	// the checks.
+	Interval time.Duration `validate:"isdefault|min=10s"`
+	// MaxDelay is the maximum age of the most recent flow. It is also the
+	// window used to look for recent errors.
+	MaxDelay time.Duration `validate:"min=1s"`
+	// MaxMutations is the maximum number of pending mutations.
+	MaxMutations int `validate:"min=1"`
+	// MaxParts is the maximum number of active parts in a partition.
+	MaxParts int `validate:"min=1"`
 }
 
 // KafkaConfiguration describes Kafka-specific configuration
@@ -82,6 +183,16 @@ type KafkaConfiguration struct {
 	// GroupName defines the Kafka consumers group used to poll data from topic,
 	// shared between all Consumers.
 	GroupName string
+	// MaxBlockSize is the maximum number of messages in a block written by
+	// a consumer (kafka_max_block_size). When 0, the ClickHouse default is
+	// used.
+	MaxBlockSize int `validate:"min=0"`
+	// PollMaxBatchSize is the maximum number of messages polled at once
+	// (kafka_poll_max_batch_size). When 0, the ClickHouse default is used.
+	PollMaxBatchSize int `validate:"min=0"`
+	// ConsumerTables is the number of Kafka engine tables to create. Each
+	// of them uses Consumers consumers from the same consumer group.
+	ConsumerTables int `validate:"min=1"`
 	// EngineSettings allows one to set arbitrary settings for Kafka engine in
 	// ClickHouse.
 	EngineSettings []string
@@ -92,15 +203,31 @@ func DefaultConfiguration() Configuration {
 	return Configuration{
 		Configuration: clickhousedb.DefaultConfiguration(),
 		Kafka: KafkaConfiguration{
-			Consumers: 1,
-			GroupName: "clickhouse",
+			Consumers:      1,
+			ConsumerTables: 1,
+			GroupName:      "clickhouse",
 		},
 		Resolutions: []ResolutionConfiguration{
-			{0, 15 * 24 * time.Hour},                   // 15 days
-			{time.Minute, 7 * 24 * time.Hour},          // 7 days
-			{5 * time.Minute, 3 * 30 * 24 * time.Hour}, // 90 days
-			{time.Hour, 12 * 30 * 24 * time.Hour},      // 1 year
+			{Interval: 0, TTL: 15 * 24 * time.Hour},                   // 15 days
+			{Interval: time.Minute, TTL: 7 * 24 * time.Hour},          // 7 days
+			{Interval: 5 * time.Minute, TTL: 3 * 30 * 24 * time.Hour}, // 90 days
+			{Interval: time.Hour, TTL: 12 * 30 * 24 * time.Hour},      // 1 year
+		},
+		IndexMaterialization: IndexMaterializationConfiguration{
+			Mode:  "throttled",
+			Delay: 30 * time.Second,
+		},
+		Backfill: BackfillConfiguration{
+			Slice: time.Hour,
+		},
+		IngestionCheck: IngestionCheckConfiguration{
+			Interval:     time.Minute,
+			MaxDelay:     5 * time.Minute,
+			MaxMutations: 50,
+			MaxParts:     200,
 		},
+		ReplicationPath:       "/clickhouse/tables/shard-{shard}/{table}",
+		ReplicaName:           "replica-{replica}",
 		MaxPartitions:         50,
 		NetworkSourcesTimeout: 10 * time.Second,
 		SystemLogTTL:          30 * 24 * time.Hour, // 30 days
@@ -128,6 +255,10 @@ type NetworkAttributes struct {
 	Tenant string
 	// ASN is the AS number associated to the network.
 	ASN uint32
+	// ASName is the name of the AS associated to the network.
+	ASName string
+	// Custom contains the custom attributes declared in the schema.
+	Custom map[string]string
 }
 
 // NetworkAttributesUnmarshallerHook decodes network attributes. It
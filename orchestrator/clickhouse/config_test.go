@@ -72,6 +72,21 @@ func TestNetworkNamesUnmarshalHook(t *testing.T) {
 				Region: "france",
 				Tenant: "mobile",
 			}}),
+		}, {
+			Description: "custom attributes",
+			Initial:     func() interface{} { return helpers.SubnetMap[NetworkAttributes]{} },
+			Configuration: func() interface{} {
+				return gin.H{"203.0.113.0/24": gin.H{
+					"name": "customer1",
+					"custom": gin.H{
+						"environment": "production",
+					},
+				}}
+			},
+			Expected: helpers.MustNewSubnetMap(map[string]NetworkAttributes{"::ffff:203.0.113.0/120": {
+				Name:   "customer1",
+				Custom: map[string]string{"environment": "production"},
+			}}),
 		}, {
 			Description:   "Invalid subnet (1)",
 			Initial:       func() interface{} { return helpers.SubnetMap[NetworkAttributes]{} },
@@ -0,0 +1,265 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package clickhouse
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"akvorado/common/helpers"
+	"akvorado/common/schema"
+)
+
+// deleteRequest describes the flows to delete.
+type deleteRequest struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Exporter  string    `json:"exporter,omitempty"`
+	SrcPrefix string    `json:"src-prefix,omitempty"`
+	DstPrefix string    `json:"dst-prefix,omitempty"`
+	// Confirm is the token returned when planning the deletion.
+	Confirm string `json:"confirm,omitempty"`
+}
+
+// deleteTablePlan describes what will be done (or was done) for a table.
+type deleteTablePlan struct {
+	Table             string   `json:"table"`
+	Skipped           string   `json:"skipped,omitempty"`
+	DroppedPartitions []string `json:"dropped-partitions,omitempty"`
+	MutatedPartitions []string `json:"mutated-partitions,omitempty"`
+	Statements        []string `json:"statements,omitempty"`
+	Mutations         []string `json:"mutations,omitempty"`
+}
+
+// deleteResult is the answer to a deletion request.
+type deleteResult struct {
+	Executed bool              `json:"executed"`
+	Token    string            `json:"token,omitempty"`
+	Tables   []deleteTablePlan `json:"tables"`
+}
+
+var errDeletePlanChanged = errors.New("deletion plan has changed, request a new token")
+
+// deleteConditions turns a deletion request into a list of conditions.
+// The columns used by the conditions are also returned.
+func deleteConditions(req deleteRequest) ([]string, []schema.ColumnKey, error) {
+	if req.Start.IsZero() || req.End.IsZero() {
+		return nil, nil, errors.New("start and end are mandatory")
+	}
+	if !req.Start.Before(req.End) {
+		return nil, nil, errors.New("start should be before end")
+	}
+	conditions := []string{
+		fmt.Sprintf("TimeReceived >= toDateTime(%d)", req.Start.Unix()),
+		fmt.Sprintf("TimeReceived < toDateTime(%d)", req.End.Unix()),
+	}
+	columns := []schema.ColumnKey{}
+	if req.Exporter != "" {
+		addr, err := netip.ParseAddr(req.Exporter)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid exporter address %q: %w", req.Exporter, err)
+		}
+		addr = netip.AddrFrom16(addr.As16())
+		conditions = append(conditions, fmt.Sprintf("ExporterAddress = toIPv6(%s)",
+			quoteString(addr.String())))
+		columns = append(columns, schema.ColumnExporterAddress)
+	}
+	for _, prefix := range []struct {
+		Column schema.ColumnKey
+		Value  string
+	}{
+		{schema.ColumnSrcAddr, req.SrcPrefix},
+		{schema.ColumnDstAddr, req.DstPrefix},
+	} {
+		if prefix.Value == "" {
+			continue
+		}
+		key, err := helpers.SubnetMapParseKey(prefix.Value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid prefix %q: %w", prefix.Value, err)
+		}
+		network := netip.MustParsePrefix(key).Masked()
+		conditions = append(conditions,
+			fmt.Sprintf("%s BETWEEN IPv6CIDRToRange(toIPv6(%s), %d).1 AND IPv6CIDRToRange(toIPv6(%s), %d).2",
+				prefix.Column,
+				quoteString(network.Addr().String()), network.Bits(),
+				quoteString(network.Addr().String()), network.Bits()))
+		columns = append(columns, prefix.Column)
+	}
+	return conditions, columns, nil
+}
+
+// planDelete computes the statements needed to delete the requested flows
+// from each flows table. Partitions fully included in the time range are
+// dropped when there is no other condition. Otherwise, a mutation is used.
+func (c *Component) planDelete(ctx context.Context, req deleteRequest) ([]deleteTablePlan, error) {
+	conditions, columns, err := deleteConditions(req)
+	if err != nil {
+		return nil, err
+	}
+	filtered := len(columns) > 0
+	plans := []deleteTablePlan{}
+	for _, resolution := range c.config.Resolutions {
+		tableName := c.flowsTableName(resolution)
+		plan := deleteTablePlan{Table: tableName}
+		for _, key := range columns {
+			column, ok := c.d.Schema.LookupColumnByKey(key)
+			if !ok || column.Disabled || !c.keepColumn(resolution, *column) {
+				plan.Skipped = fmt.Sprintf("column %s not present", key)
+				break
+			}
+		}
+		if plan.Skipped != "" {
+			plans = append(plans, plan)
+			continue
+		}
+
+		var partitions []struct {
+			ID      string    `ch:"partition_id"`
+			MinTime time.Time `ch:"min_time"`
+			MaxTime time.Time `ch:"max_time"`
+		}
+		if err := c.d.ClickHouse.Select(ctx, &partitions, fmt.Sprintf(`
+SELECT partition_id, min(min_time) AS min_time, max(max_time) AS max_time
+FROM %s
+WHERE database = $1
+AND table = $2
+AND active
+GROUP BY partition_id
+ORDER BY partition_id
+`, c.systemTable("parts")), c.config.Database, tableName); err != nil {
+			return nil, fmt.Errorf("cannot query partitions of %s: %w", tableName, err)
+		}
+		for idx, partition := range partitions {
+			if partition.MaxTime.Before(req.Start) || !partition.MinTime.Before(req.End) {
+				continue
+			}
+			// The last partition may still receive flows for the range.
+			if !filtered && idx < len(partitions)-1 &&
+				!partition.MinTime.Before(req.Start) && partition.MaxTime.Before(req.End) {
+				plan.DroppedPartitions = append(plan.DroppedPartitions, partition.ID)
+				plan.Statements = append(plan.Statements,
+					fmt.Sprintf("ALTER TABLE %s DROP PARTITION ID %s", tableName, quoteString(partition.ID)))
+				continue
+			}
+			plan.MutatedPartitions = append(plan.MutatedPartitions, partition.ID)
+		}
+		if len(plan.MutatedPartitions) > 0 {
+			plan.Statements = append(plan.Statements,
+				fmt.Sprintf("ALTER TABLE %s DELETE WHERE %s", tableName, strings.Join(conditions, " AND ")))
+		}
+		if len(plan.Statements) == 0 {
+			plan.Skipped = "no matching partition"
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+// deleteToken computes the confirmation token for a deletion plan.
+func (c *Component) deleteToken(req deleteRequest, plans []deleteTablePlan) string {
+	req.Confirm = ""
+	mac := hmac.New(sha256.New, c.deleteSecret)
+	json.NewEncoder(mac).Encode(req)
+	for _, plan := range plans {
+		for _, statement := range plan.Statements {
+			fmt.Fprintf(mac, "%s\n", statement)
+		}
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deleteFlows plans the deletion of flows. If the request contains a valid
+// confirmation token, the deletion is executed.
+func (c *Component) deleteFlows(ctx context.Context, req deleteRequest) (deleteResult, error) {
+	plans, err := c.planDelete(ctx, req)
+	if err != nil {
+		return deleteResult{}, err
+	}
+	token := c.deleteToken(req, plans)
+	if req.Confirm == "" {
+		return deleteResult{Token: token, Tables: plans}, nil
+	}
+	if !hmac.Equal([]byte(req.Confirm), []byte(token)) {
+		return deleteResult{}, errDeletePlanChanged
+	}
+
+	for idx := range plans {
+		plan := &plans[idx]
+		var command string
+		for _, statement := range plan.Statements {
+			c.r.Info().Msgf("delete flows: %s", statement)
+			if err := c.d.ClickHouse.ExecOnCluster(ctx, statement); err != nil {
+				return deleteResult{}, fmt.Errorf("cannot delete flows from %s: %w", plan.Table, err)
+			}
+			if cmd, ok := strings.CutPrefix(statement, fmt.Sprintf("ALTER TABLE %s ", plan.Table)); ok &&
+				strings.HasPrefix(cmd, "DELETE WHERE ") {
+				command = cmd
+			}
+		}
+		if command == "" {
+			continue
+		}
+		// Only report the mutation matching the command we just issued.
+		var mutations []struct {
+			ID string `ch:"mutation_id"`
+		}
+		if err := c.d.ClickHouse.Select(ctx, &mutations, fmt.Sprintf(`
+SELECT mutation_id
+FROM %s
+WHERE database = $1
+AND table = $2
+AND command = $3
+GROUP BY mutation_id
+ORDER BY max(create_time) DESC
+LIMIT 1
+`, c.systemTable("mutations")), c.config.Database, plan.Table, command); err != nil {
+			return deleteResult{}, fmt.Errorf("cannot query mutations of %s: %w", plan.Table, err)
+		}
+		for _, mutation := range mutations {
+			plan.Mutations = append(plan.Mutations, mutation.ID)
+		}
+	}
+	return deleteResult{Executed: true, Tables: plans}, nil
+}
+
+// deleteHandler handles requests to delete flows.
+func (c *Component) deleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	var req deleteRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %s.", err), http.StatusBadRequest)
+		return
+	}
+	if _, _, err := deleteConditions(req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %s.", err), http.StatusBadRequest)
+		return
+	}
+	result, err := c.deleteFlows(r.Context(), req)
+	if errors.Is(err, errDeletePlanChanged) {
+		http.Error(w, "Confirmation token does not match the current plan.", http.StatusConflict)
+		return
+	} else if err != nil {
+		c.r.Err(err).Msg("unable to delete flows")
+		http.Error(w, fmt.Sprintf("Unable to delete flows: %s.", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(result)
+}
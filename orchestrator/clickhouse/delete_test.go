@@ -0,0 +1,252 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/mock/gomock"
+
+	"akvorado/common/clickhousedb"
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+)
+
+func TestDeleteConditions(t *testing.T) {
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		Description string
+		Request     deleteRequest
+		Expected    []string
+		Error       bool
+	}{
+		{
+			Description: "missing end",
+			Request:     deleteRequest{Start: start},
+			Error:       true,
+		}, {
+			Description: "reversed range",
+			Request:     deleteRequest{Start: end, End: start},
+			Error:       true,
+		}, {
+			Description: "time range only",
+			Request:     deleteRequest{Start: start, End: end},
+			Expected: []string{
+				"TimeReceived >= toDateTime(1709251200)",
+				"TimeReceived < toDateTime(1709337600)",
+			},
+		}, {
+			Description: "all filters",
+			Request: deleteRequest{
+				Start:     start,
+				End:       end,
+				Exporter:  "192.0.2.1",
+				SrcPrefix: "198.51.100.0/24",
+				DstPrefix: "2001:db8::/32",
+			},
+			Expected: []string{
+				"TimeReceived >= toDateTime(1709251200)",
+				"TimeReceived < toDateTime(1709337600)",
+				"ExporterAddress = toIPv6('::ffff:192.0.2.1')",
+				"SrcAddr BETWEEN IPv6CIDRToRange(toIPv6('::ffff:198.51.100.0'), 120).1 AND IPv6CIDRToRange(toIPv6('::ffff:198.51.100.0'), 120).2",
+				"DstAddr BETWEEN IPv6CIDRToRange(toIPv6('2001:db8::'), 32).1 AND IPv6CIDRToRange(toIPv6('2001:db8::'), 32).2",
+			},
+		}, {
+			Description: "invalid exporter",
+			Request:     deleteRequest{Start: start, End: end, Exporter: "nope"},
+			Error:       true,
+		}, {
+			Description: "invalid prefix",
+			Request:     deleteRequest{Start: start, End: end, SrcPrefix: "192.0.2.0/33"},
+			Error:       true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			got, _, err := deleteConditions(tc.Request)
+			if err == nil && tc.Error {
+				t.Fatal("deleteConditions() did not error")
+			} else if err != nil && !tc.Error {
+				t.Fatalf("deleteConditions() error:\n%+v", err)
+			}
+			if diff := helpers.Diff(got, tc.Expected); diff != "" {
+				t.Fatalf("deleteConditions() (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDeleteFlows(t *testing.T) {
+	type partition = struct {
+		ID      string    `ch:"partition_id"`
+		MinTime time.Time `ch:"min_time"`
+		MaxTime time.Time `ch:"max_time"`
+	}
+	type mutation = struct {
+		ID string `ch:"mutation_id"`
+	}
+	r := reporter.NewMock(t)
+	chComponent, mockConn := clickhousedb.NewMock(t, r)
+	config := DefaultConfiguration()
+	config.Resolutions = []ResolutionConfiguration{
+		{Interval: 0},
+		{Interval: time.Hour},
+	}
+	c := Component{
+		r:            r,
+		d:            &Dependencies{ClickHouse: chComponent, Schema: schema.NewMock(t)},
+		config:       config,
+		deleteSecret: []byte("secret"),
+	}
+	ctx := context.Background()
+	date := func(day, hour int) time.Time {
+		return time.Date(2024, 3, day, hour, 0, 0, 0, time.UTC)
+	}
+	partitionsQuery := `
+SELECT partition_id, min(min_time) AS min_time, max(max_time) AS max_time
+FROM system.parts
+WHERE database = $1
+AND table = $2
+AND active
+GROUP BY partition_id
+ORDER BY partition_id
+`
+	expectPartitions := func(table string, partitions []partition) {
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), partitionsQuery, "default", table).
+			SetArg(1, partitions).
+			Return(nil)
+	}
+	flowsPartitions := []partition{
+		{"20240301000000", date(1, 0), date(1, 23)},
+		{"20240302000000", date(2, 0), date(2, 23)},
+		{"20240303000000", date(3, 0), date(3, 23)},
+		{"20240304000000", date(4, 0), date(4, 5)},
+	}
+	hourlyPartitions := []partition{
+		{"20240301000000", date(1, 0), date(4, 5)},
+	}
+
+	t.Run("time range only", func(t *testing.T) {
+		req := deleteRequest{Start: date(2, 0), End: date(5, 0)}
+		expectPartitions("flows", flowsPartitions)
+		expectPartitions("flows_1h0m0s", hourlyPartitions)
+		got, err := c.deleteFlows(ctx, req)
+		if err != nil {
+			t.Fatalf("deleteFlows() error:\n%+v", err)
+		}
+		expected := []deleteTablePlan{
+			{
+				Table:             "flows",
+				DroppedPartitions: []string{"20240302000000", "20240303000000"},
+				MutatedPartitions: []string{"20240304000000"},
+				Statements: []string{
+					"ALTER TABLE flows DROP PARTITION ID '20240302000000'",
+					"ALTER TABLE flows DROP PARTITION ID '20240303000000'",
+					"ALTER TABLE flows DELETE WHERE TimeReceived >= toDateTime(1709337600) AND TimeReceived < toDateTime(1709596800)",
+				},
+			}, {
+				Table:             "flows_1h0m0s",
+				MutatedPartitions: []string{"20240301000000"},
+				Statements: []string{
+					"ALTER TABLE flows_1h0m0s DELETE WHERE TimeReceived >= toDateTime(1709337600) AND TimeReceived < toDateTime(1709596800)",
+				},
+			},
+		}
+		if got.Executed || got.Token == "" {
+			t.Fatalf("deleteFlows() executed without confirmation")
+		}
+		if diff := helpers.Diff(got.Tables, expected); diff != "" {
+			t.Fatalf("deleteFlows() (-got, +want):\n%s", diff)
+		}
+
+		// Now, confirm
+		req.Confirm = got.Token
+		expectPartitions("flows", flowsPartitions)
+		expectPartitions("flows_1h0m0s", hourlyPartitions)
+		for _, plan := range expected {
+			for _, statement := range plan.Statements {
+				mockConn.EXPECT().Exec(gomock.Any(), statement).Return(nil)
+			}
+		}
+		mutationsQuery := `
+SELECT mutation_id
+FROM system.mutations
+WHERE database = $1
+AND table = $2
+AND command = $3
+GROUP BY mutation_id
+ORDER BY max(create_time) DESC
+LIMIT 1
+`
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), mutationsQuery, "default", "flows",
+				"DELETE WHERE TimeReceived >= toDateTime(1709337600) AND TimeReceived < toDateTime(1709596800)").
+			SetArg(1, []mutation{{"mutation_12.txt"}}).
+			Return(nil)
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), mutationsQuery, "default", "flows_1h0m0s",
+				"DELETE WHERE TimeReceived >= toDateTime(1709337600) AND TimeReceived < toDateTime(1709596800)").
+			SetArg(1, []mutation{{"mutation_4.txt"}}).
+			Return(nil)
+		got, err = c.deleteFlows(ctx, req)
+		if err != nil {
+			t.Fatalf("deleteFlows() error:\n%+v", err)
+		}
+		expected[0].Mutations = []string{"mutation_12.txt"}
+		expected[1].Mutations = []string{"mutation_4.txt"}
+		if !got.Executed {
+			t.Fatalf("deleteFlows() was not executed")
+		}
+		if diff := helpers.Diff(got.Tables, expected); diff != "" {
+			t.Fatalf("deleteFlows() (-got, +want):\n%s", diff)
+		}
+	})
+
+	t.Run("prefix filter", func(t *testing.T) {
+		req := deleteRequest{Start: date(2, 0), End: date(3, 0), SrcPrefix: "192.0.2.0/24"}
+		expectPartitions("flows", flowsPartitions)
+		got, err := c.deleteFlows(ctx, req)
+		if err != nil {
+			t.Fatalf("deleteFlows() error:\n%+v", err)
+		}
+		expected := []deleteTablePlan{
+			{
+				Table:             "flows",
+				MutatedPartitions: []string{"20240302000000"},
+				Statements: []string{
+					"ALTER TABLE flows DELETE WHERE TimeReceived >= toDateTime(1709337600) AND TimeReceived < toDateTime(1709424000) AND SrcAddr BETWEEN IPv6CIDRToRange(toIPv6('::ffff:192.0.2.0'), 120).1 AND IPv6CIDRToRange(toIPv6('::ffff:192.0.2.0'), 120).2",
+				},
+			}, {
+				Table:   "flows_1h0m0s",
+				Skipped: "column SrcAddr not present",
+			},
+		}
+		if diff := helpers.Diff(got.Tables, expected); diff != "" {
+			t.Fatalf("deleteFlows() (-got, +want):\n%s", diff)
+		}
+	})
+
+	t.Run("stale token", func(t *testing.T) {
+		req := deleteRequest{Start: date(2, 0), End: date(3, 0), Exporter: "192.0.2.1"}
+		expectPartitions("flows", flowsPartitions)
+		expectPartitions("flows_1h0m0s", hourlyPartitions)
+		got, err := c.deleteFlows(ctx, req)
+		if err != nil {
+			t.Fatalf("deleteFlows() error:\n%+v", err)
+		}
+		req.Confirm = got.Token
+		req.Exporter = "192.0.2.2"
+		expectPartitions("flows", flowsPartitions)
+		expectPartitions("flows_1h0m0s", hourlyPartitions)
+		if _, err := c.deleteFlows(ctx, req); !errors.Is(err, errDeletePlanChanged) {
+			t.Fatalf("deleteFlows() error:\n%+v", err)
+		}
+	})
+}
@@ -0,0 +1,263 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package clickhouse
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/exp/slices"
+
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+)
+
+// overridableDictionaries are the builtin dictionaries which can be extended
+// with a user-provided CSV file. Their key is the first column.
+var overridableDictionaries = []string{
+	schema.DictionaryASNs,
+	schema.DictionaryProtocols,
+	schema.DictionaryDSCP,
+	schema.DictionaryTCP,
+	schema.DictionaryUDP,
+}
+
+// builtinDictionaryHeader returns the header of a builtin dictionary.
+func builtinDictionaryHeader(name string) ([]string, error) {
+	f, err := data.Open(fmt.Sprintf("data/%s.csv", name))
+	if err != nil {
+		return nil, fmt.Errorf("cannot open builtin dictionary %s: %w", name, err)
+	}
+	defer f.Close()
+	header, err := csv.NewReader(f).Read()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read header of builtin dictionary %s: %w", name, err)
+	}
+	return header, nil
+}
+
+// loadDictionaryFile loads the user-provided CSV file for the provided
+// dictionary. The file should have the same header as the builtin
+// dictionary. On error, the previous content is kept.
+func (c *Component) loadDictionaryFile(name string) error {
+	path := c.config.DictionaryFiles[name]
+	expectedHeader, err := builtinDictionaryHeader(name)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("cannot open dictionary file %s: %w", path, err)
+	}
+	defer f.Close()
+	rd := csv.NewReader(f)
+	rd.FieldsPerRecord = len(expectedHeader)
+	header, err := rd.Read()
+	if err != nil {
+		return fmt.Errorf("cannot read header of dictionary file %s: %w", path, err)
+	}
+	if !slices.Equal(header, expectedHeader) {
+		return fmt.Errorf("dictionary file %s should have %v as header", path, expectedHeader)
+	}
+	records := [][]string{}
+	for {
+		record, err := rd.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("cannot parse dictionary file %s: %w", path, err)
+		}
+		key, err := strconv.ParseUint(record[0], 10, 32)
+		if err != nil {
+			line, _ := rd.FieldPos(0)
+			return fmt.Errorf("invalid key %q in dictionary file %s (line %d)", record[0], path, line)
+		}
+		record[0] = strconv.FormatUint(key, 10)
+		records = append(records, record)
+	}
+
+	c.dictionaryOverridesLock.Lock()
+	c.dictionaryOverrides[name] = records
+	c.dictionaryOverridesLock.Unlock()
+	c.r.Info().Msgf("loaded %d entries for dictionary %s from %s", len(records), name, path)
+	return nil
+}
+
+// dictionaryOverridden tells if a builtin dictionary has additional entries.
+func (c *Component) dictionaryOverridden(name string) bool {
+	if _, ok := c.config.DictionaryFiles[name]; ok {
+		return true
+	}
+	return name == schema.DictionaryASNs && len(c.config.ASNs) != 0
+}
+
+// writeDictionary writes the content of a builtin dictionary as CSV, with
+// entries replaced or extended by the ones provided by the user. Entries
+// from the configuration have precedence over the ones from files.
+func (c *Component) writeDictionary(w io.Writer, name string) error {
+	f, err := data.Open(fmt.Sprintf("data/%s.csv", name))
+	if err != nil {
+		return fmt.Errorf("cannot open builtin dictionary %s: %w", name, err)
+	}
+	defer f.Close()
+	rd := csv.NewReader(f)
+	header, err := rd.Read()
+	if err != nil {
+		return fmt.Errorf("cannot read header of builtin dictionary %s: %w", name, err)
+	}
+	rd.FieldsPerRecord = len(header)
+	wr := csv.NewWriter(w)
+	wr.Write(header)
+	seen := map[string]struct{}{}
+
+	// Custom ASNs
+	if name == schema.DictionaryASNs {
+		for asn, asName := range c.config.ASNs {
+			key := strconv.FormatUint(uint64(asn), 10)
+			wr.Write([]string{key, asName})
+			seen[key] = struct{}{}
+		}
+	}
+
+	// Entries from the dictionary file
+	c.dictionaryOverridesLock.RLock()
+	for _, record := range c.dictionaryOverrides[name] {
+		if _, ok := seen[record[0]]; ok {
+			continue
+		}
+		wr.Write(record)
+		seen[record[0]] = struct{}{}
+	}
+	c.dictionaryOverridesLock.RUnlock()
+
+	// Builtin entries
+	for count := 1; ; count++ {
+		record, err := rd.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.r.Err(err).Msgf("unable to parse data/%s.csv (line %d)", name, count)
+			continue
+		}
+		key, err := strconv.ParseUint(record[0], 10, 32)
+		if err != nil {
+			c.r.Err(err).Msgf("invalid key in data/%s.csv (line %d)", name, count)
+			continue
+		}
+		if _, ok := seen[strconv.FormatUint(key, 10)]; !ok {
+			wr.Write(record)
+		}
+	}
+	wr.Flush()
+	return wr.Error()
+}
+
+// dictionaryHandler serves a builtin dictionary merged with the entries
+// provided by the user.
+func (c *Component) dictionaryHandler(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		if err := c.writeDictionary(w, name); err != nil {
+			c.r.Err(err).Msgf("unable to write dictionary %s", name)
+		}
+	})
+}
+
+// watchDictionaryFiles watches the user-provided dictionary files. When one
+// of them is modified, it is loaded again and the dictionary is reloaded in
+// ClickHouse.
+func (c *Component) watchDictionaryFiles() error {
+	if len(c.config.DictionaryFiles) == 0 {
+		return nil
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		c.r.Err(err).Msg("cannot setup watcher for dictionary files")
+		return fmt.Errorf("cannot setup watcher: %w", err)
+	}
+	dirs := map[string]struct{}{}
+	for _, path := range c.config.DictionaryFiles {
+		dirs[filepath.Dir(path)] = struct{}{}
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			c.r.Err(err).Msg("cannot watch dictionary directory")
+			return fmt.Errorf("cannot watch dictionary directory: %w", err)
+		}
+	}
+	c.t.Go(func() error {
+		errLogger := c.r.Sample(reporter.BurstSampler(10*time.Second, 1))
+		defer watcher.Close()
+
+		for {
+			select {
+			case <-c.t.Dying():
+				return nil
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return errors.New("file watcher died")
+				}
+				errLogger.Err(err).Msg("error from watcher")
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return errors.New("file watcher died")
+				}
+				if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) {
+					continue
+				}
+				for name, path := range c.config.DictionaryFiles {
+					if filepath.Clean(event.Name) != filepath.Clean(path) {
+						continue
+					}
+					if err := c.loadDictionaryFile(name); err != nil {
+						c.r.Err(err).Msgf("cannot reload dictionary %s", name)
+						break
+					}
+					if err := c.ReloadDictionary(c.t.Context(nil), name); err != nil {
+						c.r.Err(err).Msgf("cannot reload dictionary %s in ClickHouse", name)
+					}
+					break
+				}
+			}
+		}
+	})
+	return nil
+}
+
+// fetchCustomDictionary fetches the content of a custom dictionary from a
+// remote URL.
+func fetchCustomDictionary(ctx context.Context, url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot build request for %s: %w", url, err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot fetch %s: unexpected status %s", url, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %w", url, err)
+	}
+	return body, nil
+}
@@ -0,0 +1,210 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/mock/gomock"
+
+	"akvorado/common/clickhousedb"
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/httpserver"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+)
+
+func TestDictionaryFiles(t *testing.T) {
+	r := reporter.NewMock(t)
+	dir := t.TempDir()
+	asnsFile := filepath.Join(dir, "asns.csv")
+	tcpFile := filepath.Join(dir, "tcp.csv")
+	os.WriteFile(asnsFile, []byte("asn,name\n1,Other network\n64512,Private network\n"), 0o644)
+	os.WriteFile(tcpFile, []byte("port,name\n22,secure shell\n8443,https-alt\n"), 0o644)
+	config := DefaultConfiguration()
+	config.ASNs = map[uint32]string{
+		1: "New network",
+	}
+	config.DictionaryFiles = map[string]string{
+		"asns": asnsFile,
+		"tcp":  tcpFile,
+	}
+	c, err := New(r, config, Dependencies{
+		Daemon: daemon.NewMock(t),
+		HTTP:   httpserver.NewMock(t, r),
+		Schema: schema.NewMock(t),
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+
+	cases := helpers.HTTPEndpointCases{
+		{
+			URL:         "/api/v0/orchestrator/clickhouse/asns.csv",
+			ContentType: "text/csv; charset=utf-8",
+			FirstLines: []string{
+				`asn,name`,
+				`1,New network`,
+				`64512,Private network`,
+				`2,University of Delaware`,
+			},
+		}, {
+			URL:         "/api/v0/orchestrator/clickhouse/tcp.csv",
+			ContentType: "text/csv; charset=utf-8",
+			FirstLines: []string{
+				`port,name`,
+				`22,secure shell`,
+				`8443,https-alt`,
+				`80,http`,
+			},
+		}, {
+			URL:         "/api/v0/orchestrator/clickhouse/udp.csv",
+			ContentType: "text/csv; charset=utf-8",
+			FirstLines: []string{
+				`port,name`,
+				`53,domain`,
+			},
+		},
+	}
+
+	helpers.TestHTTPEndpoints(t, c.d.HTTP.LocalAddr(), cases)
+}
+
+func TestDictionaryFilesErrors(t *testing.T) {
+	dir := t.TempDir()
+	badHeader := filepath.Join(dir, "bad-header.csv")
+	os.WriteFile(badHeader, []byte("as,name\n1,Other network\n"), 0o644)
+	badKey := filepath.Join(dir, "bad-key.csv")
+	os.WriteFile(badKey, []byte("asn,name\nAS1,Other network\n"), 0o644)
+	badFields := filepath.Join(dir, "bad-fields.csv")
+	os.WriteFile(badFields, []byte("asn,name\n1,Other network,extra\n"), 0o644)
+
+	for _, files := range []map[string]string{
+		{"icmp": badHeader},
+		{"asns": filepath.Join(dir, "missing.csv")},
+		{"asns": badHeader},
+		{"asns": badKey},
+		{"asns": badFields},
+	} {
+		r := reporter.NewMock(t)
+		config := DefaultConfiguration()
+		config.DictionaryFiles = files
+		if _, err := New(r, config, Dependencies{
+			Daemon: daemon.NewMock(t),
+			HTTP:   httpserver.NewMock(t, r),
+			Schema: schema.NewMock(t),
+		}); err == nil {
+			t.Errorf("New(%v) did not error", files)
+		}
+	}
+}
+
+func TestDictionaryFilesReload(t *testing.T) {
+	r := reporter.NewMock(t)
+	chComponent, mockConn := clickhousedb.NewMock(t, r)
+	tcpFile := filepath.Join(t.TempDir(), "tcp.csv")
+	os.WriteFile(tcpFile, []byte("port,name\n22,secure shell\n"), 0o644)
+	config := DefaultConfiguration()
+	config.DictionaryFiles = map[string]string{"tcp": tcpFile}
+	c, err := New(r, config, Dependencies{
+		Daemon:     daemon.NewMock(t),
+		HTTP:       httpserver.NewMock(t, r),
+		Schema:     schema.NewMock(t),
+		ClickHouse: chComponent,
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	if err := c.watchDictionaryFiles(); err != nil {
+		t.Fatalf("watchDictionaryFiles() error:\n%+v", err)
+	}
+	defer func() {
+		c.t.Kill(nil)
+		c.t.Wait()
+	}()
+
+	reloaded := make(chan bool, 10)
+	mockConn.EXPECT().
+		Exec(gomock.Any(), "SYSTEM RELOAD DICTIONARY tcp").
+		DoAndReturn(func(context.Context, string, ...any) error {
+			reloaded <- true
+			return nil
+		}).
+		MinTimes(1)
+	os.WriteFile(tcpFile, []byte("port,name\n22,ssh over the internet\n"), 0o644)
+	select {
+	case <-reloaded:
+	case <-time.After(time.Second):
+		t.Fatal("dictionary not reloaded")
+	}
+
+	cases := helpers.HTTPEndpointCases{
+		{
+			URL:         "/api/v0/orchestrator/clickhouse/tcp.csv",
+			ContentType: "text/csv; charset=utf-8",
+			FirstLines: []string{
+				`port,name`,
+				`22,ssh over the internet`,
+			},
+		},
+	}
+	helpers.TestHTTPEndpoints(t, c.d.HTTP.LocalAddr(), cases)
+}
+
+func TestCustomDictionaryURL(t *testing.T) {
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/exporters.csv" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("addr,site\n2001:db8::1,paris\n"))
+	}))
+	defer remote.Close()
+
+	r := reporter.NewMock(t)
+	schemaConfig := schema.DefaultConfiguration()
+	schemaConfig.CustomDictionaries = map[string]schema.CustomDict{
+		"exporters": {Source: remote.URL + "/exporters.csv"},
+		"missing":   {Source: remote.URL + "/missing.csv"},
+	}
+	sch, err := schema.New(schemaConfig)
+	if err != nil {
+		t.Fatalf("schema.New() error:\n%+v", err)
+	}
+	c, err := New(r, DefaultConfiguration(), Dependencies{
+		Daemon: daemon.NewMock(t),
+		HTTP:   httpserver.NewMock(t, r),
+		Schema: sch,
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+
+	cases := helpers.HTTPEndpointCases{
+		{
+			URL:         "/api/v0/orchestrator/clickhouse/custom_dict_exporters.csv",
+			ContentType: "text/csv; charset=utf-8",
+			FirstLines: []string{
+				`addr,site`,
+				`2001:db8::1,paris`,
+			},
+		}, {
+			URL:         "/api/v0/orchestrator/clickhouse/custom_dict_missing.csv",
+			ContentType: "text/plain; charset=utf-8",
+			StatusCode:  502,
+			FirstLines: []string{
+				fmt.Sprintf("unable to fetch custom dict csv file %s/missing.csv", remote.URL),
+			},
+		},
+	}
+	helpers.TestHTTPEndpoints(t, c.d.HTTP.LocalAddr(), cases)
+}
@@ -7,12 +7,11 @@ import (
 	"bytes"
 	"compress/gzip"
 	"embed"
-	"encoding/csv"
+	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
-	"strconv"
+	"strings"
 	"text/template"
 	"time"
 )
@@ -20,6 +19,7 @@ import (
 var (
 	//go:embed data/protocols.csv
 	//go:embed data/icmp.csv
+	//go:embed data/dscp.csv
 	//go:embed data/asns.csv
 	//go:embed data/tcp.csv
 	//go:embed data/udp.csv
@@ -111,11 +111,24 @@ func (c *Component) registerHTTPHandlers() error {
 
 	// Add handler for custom dicts
 	for name, dict := range c.d.Schema.GetCustomDictConfig() {
-		c.d.HTTP.AddHandler(fmt.Sprintf("/api/v0/orchestrator/clickhouse/custom_dict_%s.csv", name), http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		c.d.HTTP.AddHandler(fmt.Sprintf("/api/v0/orchestrator/clickhouse/custom_dict_%s.csv", name), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(dict.Source, "http://") || strings.HasPrefix(dict.Source, "https://") {
+				file, err := fetchCustomDictionary(r.Context(), dict.Source)
+				if err != nil {
+					c.r.Err(err).Msg("unable to fetch custom dict csv file")
+					http.Error(w, fmt.Sprintf("unable to fetch custom dict csv file %s", dict.Source), http.StatusBadGateway)
+					return
+				}
+				w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+				w.WriteHeader(http.StatusOK)
+				w.Write(file)
+				return
+			}
 			file, err := os.ReadFile(dict.Source)
 			if err != nil {
 				c.r.Err(err).Msg("unable to deliver custom dict csv file")
 				http.Error(w, fmt.Sprintf("unable to deliver custom dict csv file %s", dict.Source), http.StatusNotFound)
+				return
 			}
 			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
 			w.WriteHeader(http.StatusOK)
@@ -184,53 +197,61 @@ func (c *Component) registerHTTPHandlers() error {
 			}
 		}))
 
-	// asns.csv (when there are some custom-defined ASNs)
-	if len(c.config.ASNs) != 0 {
-		c.d.HTTP.AddHandler("/api/v0/orchestrator/clickhouse/asns.csv",
-			http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
-				f, err := data.Open("data/asns.csv")
-				if err != nil {
-					c.r.Err(err).Msg("unable to open data/asns.csv")
-					http.Error(w, "Unable to open ASN file.",
-						http.StatusInternalServerError)
-					return
-				}
-				rd := csv.NewReader(f)
-				rd.ReuseRecord = true
-				rd.FieldsPerRecord = 2
-				w.Header().Set("Content-Type", "text/csv; charset=utf-8")
-				w.WriteHeader(http.StatusOK)
-				wr := csv.NewWriter(w)
-				wr.Write([]string{"asn", "name"})
-				// Custom ASNs
-				for asn, name := range c.config.ASNs {
-					wr.Write([]string{strconv.Itoa(int(asn)), name})
-				}
-				// Other ASNs
-				for count := 0; ; count++ {
-					record, err := rd.Read()
-					if err == io.EOF {
-						break
-					}
-					if err != nil {
-						c.r.Err(err).Msgf("unable to parse data/asns.csv (line %d)", count)
-						continue
-					}
-					if count == 0 {
-						continue
-					}
-					asn, err := strconv.ParseUint(record[0], 10, 32)
-					if err != nil {
-						c.r.Err(err).Msgf("invalid AS number (line %d)", count)
-						continue
-					}
-					if _, ok := c.config.ASNs[uint32(asn)]; !ok {
-						wr.Write(record)
-					}
-				}
-				wr.Flush()
-			}))
-	}
+	// Migrations to apply, without applying them
+	c.d.HTTP.AddHandler("/api/v0/orchestrator/clickhouse/migrations",
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			statements, err := c.PlanMigrations(r.Context())
+			if err != nil {
+				c.r.Err(err).Msg("unable to plan migrations")
+				http.Error(w, fmt.Sprintf("Unable to plan migrations: %s.", err), http.StatusInternalServerError)
+				return
+			}
+			if r.URL.Query().Get("format") == "json" {
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(struct {
+					Statements []string `json:"statements"`
+				}{statements})
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			for _, statement := range statements {
+				fmt.Fprintf(w, "%s;\n\n", statement)
+			}
+		}))
+
+	// Result of the last ingestion checks
+	c.d.HTTP.AddHandler("/api/v0/orchestrator/clickhouse/ingestion",
+		http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			c.ingestionHealthLock.RLock()
+			health := c.ingestionHealth
+			c.ingestionHealthLock.RUnlock()
+			if health == nil {
+				http.Error(w, "Ingestion not checked yet.", http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			json.NewEncoder(w).Encode(health)
+		}))
+
+	// Delete flows for a time range
+	c.d.HTTP.AddHandler("/api/v0/orchestrator/clickhouse/delete",
+		http.HandlerFunc(c.deleteHandler))
+
+	// Rebuild consolidated tables for a time range
+	c.d.HTTP.AddHandler("/api/v0/orchestrator/clickhouse/backfill",
+		http.HandlerFunc(c.backfillHandler))
+
+	// Trigger an immediate refresh of network sources
+	c.d.HTTP.AddHandler("/api/v0/orchestrator/clickhouse/network-sources/refresh",
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				w.Header().Set("Allow", http.MethodPost)
+				http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+				return
+			}
+			c.networkSourcesFetcher.Refresh()
+			w.WriteHeader(http.StatusAccepted)
+		}))
 
 	// Static CSV files
 	entries, err := data.ReadDir("data")
@@ -241,10 +262,12 @@ func (c *Component) registerHTTPHandlers() error {
 		if entry.IsDir() {
 			continue
 		}
-		if entry.Name() == "asns.csv" && len(c.config.ASNs) != 0 {
+		url := fmt.Sprintf("/api/v0/orchestrator/clickhouse/%s", entry.Name())
+		if name := strings.TrimSuffix(entry.Name(), ".csv"); c.dictionaryOverridden(name) {
+			// Builtin dictionary extended by the user
+			c.d.HTTP.AddHandler(url, c.dictionaryHandler(name))
 			continue
 		}
-		url := fmt.Sprintf("/api/v0/orchestrator/clickhouse/%s", entry.Name())
 		path := fmt.Sprintf("data/%s", entry.Name())
 		c.addHandlerEmbedded(url, path)
 	}
@@ -60,6 +60,14 @@ func TestHTTPEndpoints(t *testing.T) {
 				`0,HOPOPT,IPv6 Hop-by-Hop Option`,
 				`1,ICMP,Internet Control Message`,
 			},
+		}, {
+			URL:         "/api/v0/orchestrator/clickhouse/dscp.csv",
+			ContentType: "text/csv; charset=utf-8",
+			FirstLines: []string{
+				`dscp,name`,
+				`0,CS0`,
+				`1,LE`,
+			},
 		}, {
 			URL:         "/api/v0/orchestrator/clickhouse/asns.csv",
 			ContentType: "text/csv; charset=utf-8",
@@ -71,8 +79,8 @@ func TestHTTPEndpoints(t *testing.T) {
 			URL:         "/api/v0/orchestrator/clickhouse/networks.csv",
 			ContentType: "text/csv; charset=utf-8",
 			FirstLines: []string{
-				`network,name,role,site,region,country,state,city,tenant,asn`,
-				`192.0.2.0/24,infra,,,,,,,,`,
+				`network,name,role,site,region,country,state,city,tenant,asn,asname`,
+				`192.0.2.0/24,infra,,,,,,,,,`,
 			},
 		}, {
 			URL:         "/api/v0/orchestrator/clickhouse/init.sh",
@@ -0,0 +1,299 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"akvorado/common/schema"
+)
+
+var (
+	projectionNameRegex     = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	existingProjectionRegex = regexp.MustCompile(`PROJECTION ([a-zA-Z0-9_]+) \(`)
+)
+
+// materialization is an index or a projection to build for existing data.
+type materialization struct {
+	Table string
+	Kind  string // INDEX or PROJECTION
+	Name  string
+}
+
+// flowsTableName returns the name of the local flows table for a resolution.
+func (c *Component) flowsTableName(resolution ResolutionConfiguration) string {
+	if resolution.Interval == 0 {
+		return c.localTable(c.config.TableName("flows"))
+	}
+	return c.localTable(c.config.TableName(fmt.Sprintf("flows_%s", resolution.Interval)))
+}
+
+// indexDefinition returns the definition of a data-skipping index.
+func indexDefinition(index IndexConfiguration) (name string, definition string) {
+	name = fmt.Sprintf("idx_%s", index.Column)
+	indexType := index.Type
+	if indexType == "" {
+		indexType = "bloom_filter"
+	}
+	granularity := max(index.Granularity, 1)
+	return name, fmt.Sprintf("%s %s TYPE %s GRANULARITY %d", name, index.Column, indexType, granularity)
+}
+
+// projectionDefinition returns the definition of a projection, formatted as
+// ClickHouse does in the CREATE TABLE statement.
+func projectionDefinition(projection ProjectionConfiguration) string {
+	columns := []string{}
+	for _, column := range projection.OrderBy {
+		columns = append(columns, column.String())
+	}
+	orderBy := columns[0]
+	if len(columns) > 1 {
+		orderBy = fmt.Sprintf("(%s)", strings.Join(columns, ", "))
+	}
+	return fmt.Sprintf("%s (SELECT * ORDER BY %s)", projection.Name, orderBy)
+}
+
+// checkResolutionIndexes checks the indexes and projections of a resolution.
+func (c *Component) checkResolutionIndexes(resolution ResolutionConfiguration) error {
+	checkColumn := func(what string, key schema.ColumnKey) error {
+		column, ok := c.d.Schema.LookupColumnByKey(key)
+		if !ok || column.Disabled || !c.keepColumn(resolution, *column) {
+			return fmt.Errorf("resolution %s: %s uses column %s which is not in the table",
+				resolution.Interval, what, key)
+		}
+		return nil
+	}
+	for _, index := range resolution.Indexes {
+		if err := checkColumn("index", index.Column); err != nil {
+			return err
+		}
+	}
+	for _, projection := range resolution.Projections {
+		if !projectionNameRegex.MatchString(projection.Name) {
+			return fmt.Errorf("resolution %s: invalid projection name %q",
+				resolution.Interval, projection.Name)
+		}
+		for _, column := range projection.OrderBy {
+			if err := checkColumn(fmt.Sprintf("projection %s", projection.Name), column); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// createOrUpdateFlowsIndexes adds the configured data-skipping indexes and
+// projections to the flows table of a resolution and removes the ones which
+// are not configured anymore.
+func (c *Component) createOrUpdateFlowsIndexes(ctx context.Context, resolution ResolutionConfiguration) error {
+	tableName := c.flowsTableName(resolution)
+
+	// Existing indexes
+	var existingIndexes []struct {
+		Name        string `ch:"name"`
+		Type        string `ch:"type_full"`
+		Expression  string `ch:"expr"`
+		Granularity uint64 `ch:"granularity"`
+	}
+	if err := c.d.ClickHouse.Select(ctx, &existingIndexes, `
+SELECT name, type_full, expr, granularity
+FROM system.data_skipping_indices
+WHERE database = $1
+AND table = $2
+`, c.config.Database, tableName); err != nil {
+		return fmt.Errorf("cannot query indexes of %s: %w", tableName, err)
+	}
+	wantedIndexes := map[string]string{}
+	for _, index := range resolution.Indexes {
+		name, definition := indexDefinition(index)
+		wantedIndexes[name] = definition
+	}
+	modifications := []string{}
+	materializations := []materialization{}
+	existing := map[string]bool{}
+	for _, index := range existingIndexes {
+		definition := fmt.Sprintf("%s %s TYPE %s GRANULARITY %d",
+			index.Name, index.Expression, index.Type, index.Granularity)
+		if wantedIndexes[index.Name] == definition {
+			existing[index.Name] = true
+			continue
+		}
+		modifications = append(modifications, fmt.Sprintf("DROP INDEX IF EXISTS %s", index.Name))
+	}
+	for _, index := range resolution.Indexes {
+		name, definition := indexDefinition(index)
+		if !existing[name] {
+			modifications = append(modifications, fmt.Sprintf("ADD INDEX IF NOT EXISTS %s", definition))
+			materializations = append(materializations, materialization{tableName, "INDEX", name})
+		}
+	}
+
+	// Existing projections
+	var createTableQueries []struct {
+		Query string `ch:"create_table_query"`
+	}
+	if err := c.d.ClickHouse.Select(ctx, &createTableQueries, `
+SELECT create_table_query
+FROM system.tables
+WHERE database = $1
+AND name = $2
+`, c.config.Database, tableName); err != nil {
+		return fmt.Errorf("cannot query definition of %s: %w", tableName, err)
+	}
+	createTableQuery := ""
+	if len(createTableQueries) > 0 {
+		createTableQuery = createTableQueries[0].Query
+	}
+	wantedProjections := map[string]string{}
+	for _, projection := range resolution.Projections {
+		wantedProjections[projection.Name] = projectionDefinition(projection)
+	}
+	existing = map[string]bool{}
+	for _, match := range existingProjectionRegex.FindAllStringSubmatch(createTableQuery, -1) {
+		name := match[1]
+		if definition, ok := wantedProjections[name]; ok &&
+			strings.Contains(createTableQuery, fmt.Sprintf("PROJECTION %s", definition)) {
+			existing[name] = true
+			continue
+		}
+		modifications = append(modifications, fmt.Sprintf("DROP PROJECTION IF EXISTS %s", name))
+	}
+	for _, projection := range resolution.Projections {
+		if !existing[projection.Name] {
+			modifications = append(modifications,
+				fmt.Sprintf("ADD PROJECTION IF NOT EXISTS %s", projectionDefinition(projection)))
+			materializations = append(materializations,
+				materialization{tableName, "PROJECTION", projection.Name})
+		}
+	}
+
+	if len(modifications) == 0 {
+		return errSkipStep
+	}
+	c.r.Info().Msgf("update indexes and projections of %s", tableName)
+	if err := c.execMigration(ctx, fmt.Sprintf("ALTER TABLE %s %s",
+		tableName, strings.Join(modifications, ", "))); err != nil {
+		return fmt.Errorf("cannot update indexes and projections of %s: %w", tableName, err)
+	}
+
+	// Build the indexes and projections for existing data
+	switch c.config.IndexMaterialization.Mode {
+	case "immediate":
+		for _, m := range materializations {
+			c.r.Warn().Msgf("materialize %s %s of %s, this can take a long time",
+				strings.ToLower(m.Kind), m.Name, m.Table)
+			if err := c.execMigration(ctx, fmt.Sprintf("ALTER TABLE %s MATERIALIZE %s %s",
+				m.Table, m.Kind, m.Name)); err != nil {
+				return fmt.Errorf("cannot materialize %s %s of %s: %w",
+					strings.ToLower(m.Kind), m.Name, m.Table, err)
+			}
+		}
+	case "throttled":
+		if migrationPlanFromContext(ctx) == nil {
+			c.materializationsLock.Lock()
+			c.materializations = append(c.materializations, materializations...)
+			c.materializationsLock.Unlock()
+		}
+	}
+	return nil
+}
+
+// materializePartitions builds an index or a projection for the existing
+// data of a table, one partition at a time, waiting for the previous
+// mutation to complete before starting the next one. Most recent partitions
+// are handled first.
+func (c *Component) materializePartitions(ctx context.Context, m materialization) error {
+	var partitions []struct {
+		ID string `ch:"partition_id"`
+	}
+	if err := c.d.ClickHouse.Select(ctx, &partitions, `
+SELECT DISTINCT partition_id
+FROM system.parts
+WHERE database = $1
+AND table = $2
+AND active
+ORDER BY partition_id DESC
+`, c.config.Database, m.Table); err != nil {
+		return fmt.Errorf("cannot query partitions of %s: %w", m.Table, err)
+	}
+	c.r.Info().Msgf("materialize %s %s of %s for %d partitions",
+		strings.ToLower(m.Kind), m.Name, m.Table, len(partitions))
+	for idx, partition := range partitions {
+		if idx > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(c.config.IndexMaterialization.Delay):
+			}
+		}
+		if err := c.d.ClickHouse.ExecOnCluster(ctx,
+			fmt.Sprintf("ALTER TABLE %s MATERIALIZE %s %s IN PARTITION ID %s",
+				m.Table, m.Kind, m.Name, quoteString(partition.ID))); err != nil {
+			return fmt.Errorf("cannot materialize %s %s of %s for partition %s: %w",
+				strings.ToLower(m.Kind), m.Name, m.Table, partition.ID, err)
+		}
+		if err := c.waitMutations(ctx, m.Table); err != nil {
+			return err
+		}
+		c.metrics.materializedPartitions.Inc()
+	}
+	return nil
+}
+
+// waitMutations waits for the mutations of a table to complete.
+func (c *Component) waitMutations(ctx context.Context, table string) error {
+	for {
+		var pending []struct {
+			Count uint64 `ch:"count"`
+		}
+		if err := c.d.ClickHouse.Select(ctx, &pending, fmt.Sprintf(`
+SELECT count() AS count
+FROM %s
+WHERE database = $1
+AND table = $2
+AND NOT is_done
+`, c.systemTable("mutations")), c.config.Database, table); err != nil {
+			return fmt.Errorf("cannot query mutations of %s: %w", table, err)
+		}
+		if len(pending) == 0 || pending[0].Count == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(materializationPollInterval):
+		}
+	}
+}
+
+// materializationPollInterval is the interval to check if a mutation is
+// complete.
+var materializationPollInterval = 5 * time.Second
+
+// materializer builds the indexes and projections added during the migration
+// for existing data, once migrations are done.
+func (c *Component) materializer() {
+	select {
+	case <-c.t.Dying():
+		return
+	case <-c.migrationsDone:
+	}
+	c.materializationsLock.Lock()
+	materializations := c.materializations
+	c.materializations = nil
+	c.materializationsLock.Unlock()
+	for _, m := range materializations {
+		if err := c.materializePartitions(c.t.Context(nil), m); err != nil {
+			if !c.t.Alive() {
+				return
+			}
+			c.r.Err(err).Msgf("unable to materialize %s %s of %s",
+				strings.ToLower(m.Kind), m.Name, m.Table)
+		}
+	}
+}
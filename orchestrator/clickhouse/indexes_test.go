@@ -0,0 +1,292 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/mock/gomock"
+
+	"akvorado/common/clickhousedb"
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/httpserver"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+)
+
+func TestFlowsIndexes(t *testing.T) {
+	type index = struct {
+		Name        string `ch:"name"`
+		Type        string `ch:"type_full"`
+		Expression  string `ch:"expr"`
+		Granularity uint64 `ch:"granularity"`
+	}
+	type table = struct {
+		Query string `ch:"create_table_query"`
+	}
+	r := reporter.NewMock(t)
+	chComponent, mockConn := clickhousedb.NewMock(t, r)
+	config := DefaultConfiguration()
+	config.IndexMaterialization.Mode = "immediate"
+	c := Component{
+		r:      r,
+		d:      &Dependencies{ClickHouse: chComponent, Schema: schema.NewMock(t)},
+		config: config,
+	}
+	resolution := ResolutionConfiguration{
+		Indexes: []IndexConfiguration{
+			{Column: schema.ColumnExporterAddress, Type: "bloom_filter(0.01)", Granularity: 4},
+			{Column: schema.ColumnSrcAddr},
+		},
+		Projections: []ProjectionConfiguration{
+			{
+				Name:    "by_exporter",
+				OrderBy: []schema.ColumnKey{schema.ColumnExporterAddress, schema.ColumnTimeReceived},
+			},
+		},
+	}
+
+	expectIndexes := func(indexes []index, query string) {
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), `
+SELECT name, type_full, expr, granularity
+FROM system.data_skipping_indices
+WHERE database = $1
+AND table = $2
+`, "default", "flows").
+			SetArg(1, indexes).
+			Return(nil)
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), `
+SELECT create_table_query
+FROM system.tables
+WHERE database = $1
+AND name = $2
+`, "default", "flows").
+			SetArg(1, []table{{query}}).
+			Return(nil)
+	}
+
+	// Initial state: an index and a projection to remove
+	expectIndexes([]index{
+		{"idx_ExporterAddress", "bloom_filter(0.01)", "ExporterAddress", 4},
+		{"idx_DstAddr", "bloom_filter", "DstAddr", 1},
+	}, "CREATE TABLE default.flows (`TimeReceived` DateTime, "+
+		"INDEX idx_ExporterAddress ExporterAddress TYPE bloom_filter(0.01) GRANULARITY 4, "+
+		"INDEX idx_DstAddr DstAddr TYPE bloom_filter GRANULARITY 1, "+
+		"PROJECTION old_projection (SELECT * ORDER BY SrcAddr)) ENGINE = MergeTree")
+	plan := &migrationPlan{}
+	ctx := context.WithValue(context.Background(), migrationPlanKey{}, plan)
+	if err := c.createOrUpdateFlowsIndexes(ctx, resolution); err != nil {
+		t.Fatalf("createOrUpdateFlowsIndexes() error:\n%+v", err)
+	}
+	expectedStatements := []string{
+		"ALTER TABLE flows DROP INDEX IF EXISTS idx_DstAddr, " +
+			"ADD INDEX IF NOT EXISTS idx_SrcAddr SrcAddr TYPE bloom_filter GRANULARITY 1, " +
+			"DROP PROJECTION IF EXISTS old_projection, " +
+			"ADD PROJECTION IF NOT EXISTS by_exporter (SELECT * ORDER BY (ExporterAddress, TimeReceived))",
+		"ALTER TABLE flows MATERIALIZE INDEX idx_SrcAddr",
+		"ALTER TABLE flows MATERIALIZE PROJECTION by_exporter",
+	}
+	if diff := helpers.Diff(plan.statements, expectedStatements); diff != "" {
+		t.Fatalf("createOrUpdateFlowsIndexes() (-got, +want):\n%s", diff)
+	}
+
+	// Final state: nothing to do
+	expectIndexes([]index{
+		{"idx_ExporterAddress", "bloom_filter(0.01)", "ExporterAddress", 4},
+		{"idx_SrcAddr", "bloom_filter", "SrcAddr", 1},
+	}, "CREATE TABLE default.flows (`TimeReceived` DateTime, "+
+		"INDEX idx_ExporterAddress ExporterAddress TYPE bloom_filter(0.01) GRANULARITY 4, "+
+		"INDEX idx_SrcAddr SrcAddr TYPE bloom_filter GRANULARITY 1, "+
+		"PROJECTION by_exporter (SELECT * ORDER BY (ExporterAddress, TimeReceived))) ENGINE = MergeTree")
+	if err := c.createOrUpdateFlowsIndexes(ctx, resolution); !errors.Is(err, errSkipStep) {
+		t.Fatalf("createOrUpdateFlowsIndexes() error:\n%+v", err)
+	}
+
+	// Throttled: materialization is queued
+	c.config.IndexMaterialization.Mode = "throttled"
+	expectIndexes([]index{}, "CREATE TABLE default.flows (`TimeReceived` DateTime) ENGINE = MergeTree")
+	mockConn.EXPECT().
+		Exec(gomock.Any(), "ALTER TABLE flows ADD INDEX IF NOT EXISTS idx_SrcAddr SrcAddr TYPE bloom_filter GRANULARITY 1").
+		Return(nil)
+	if err := c.createOrUpdateFlowsIndexes(context.Background(), ResolutionConfiguration{
+		Indexes: resolution.Indexes[1:],
+	}); err != nil {
+		t.Fatalf("createOrUpdateFlowsIndexes() error:\n%+v", err)
+	}
+	if diff := helpers.Diff(c.materializations, []materialization{
+		{Table: "flows", Kind: "INDEX", Name: "idx_SrcAddr"},
+	}); diff != "" {
+		t.Fatalf("createOrUpdateFlowsIndexes() materializations (-got, +want):\n%s", diff)
+	}
+}
+
+func TestMaterializePartitions(t *testing.T) {
+	type partition = struct {
+		ID string `ch:"partition_id"`
+	}
+	type pending = struct {
+		Count uint64 `ch:"count"`
+	}
+	r := reporter.NewMock(t)
+	chComponent, mockConn := clickhousedb.NewMock(t, r)
+	config := DefaultConfiguration()
+	config.IndexMaterialization.Delay = 0
+	c := Component{
+		r:      r,
+		d:      &Dependencies{ClickHouse: chComponent},
+		config: config,
+	}
+	c.initMetrics()
+	defer func(previous time.Duration) {
+		materializationPollInterval = previous
+	}(materializationPollInterval)
+	materializationPollInterval = 0
+
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), `
+SELECT DISTINCT partition_id
+FROM system.parts
+WHERE database = $1
+AND table = $2
+AND active
+ORDER BY partition_id DESC
+`, "default", "flows").
+		SetArg(1, []partition{{"20240302000000"}, {"20240301000000"}}).
+		Return(nil)
+	waitQuery := `
+SELECT count() AS count
+FROM system.mutations
+WHERE database = $1
+AND table = $2
+AND NOT is_done
+`
+	gomock.InOrder(
+		mockConn.EXPECT().
+			Exec(gomock.Any(), "ALTER TABLE flows MATERIALIZE INDEX idx_SrcAddr IN PARTITION ID '20240302000000'").
+			Return(nil),
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), waitQuery, "default", "flows").
+			SetArg(1, []pending{{1}}).
+			Return(nil),
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), waitQuery, "default", "flows").
+			SetArg(1, []pending{{0}}).
+			Return(nil),
+		mockConn.EXPECT().
+			Exec(gomock.Any(), "ALTER TABLE flows MATERIALIZE INDEX idx_SrcAddr IN PARTITION ID '20240301000000'").
+			Return(nil),
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), waitQuery, "default", "flows").
+			SetArg(1, []pending{{0}}).
+			Return(nil),
+	)
+
+	if err := c.materializePartitions(context.Background(),
+		materialization{Table: "flows", Kind: "INDEX", Name: "idx_SrcAddr"}); err != nil {
+		t.Fatalf("materializePartitions() error:\n%+v", err)
+	}
+	gotMetrics := r.GetMetrics("akvorado_orchestrator_clickhouse_", "materialized_")
+	expectedMetrics := map[string]string{
+		`materialized_partitions_total`: "2",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestResolutionIndexesValidation(t *testing.T) {
+	for _, resolution := range []ResolutionConfiguration{
+		{
+			Interval: time.Hour,
+			TTL:      24 * time.Hour,
+			Indexes:  []IndexConfiguration{{Column: schema.ColumnSrcAddr}},
+		}, {
+			Interval:    time.Hour,
+			TTL:         24 * time.Hour,
+			Dimensions:  []schema.ColumnKey{schema.ColumnSrcCountry},
+			Projections: []ProjectionConfiguration{{Name: "by_dst", OrderBy: []schema.ColumnKey{schema.ColumnDstCountry}}},
+		}, {
+			Interval:    time.Hour,
+			TTL:         24 * time.Hour,
+			Projections: []ProjectionConfiguration{{Name: "by-src", OrderBy: []schema.ColumnKey{schema.ColumnSrcCountry}}},
+		},
+	} {
+		r := reporter.NewMock(t)
+		config := DefaultConfiguration()
+		config.Resolutions = append(config.Resolutions, resolution)
+		if _, err := New(r, config, Dependencies{
+			Daemon: daemon.NewMock(t),
+			HTTP:   httpserver.NewMock(t, r),
+			Schema: schema.NewMock(t),
+		}); err == nil {
+			t.Errorf("New(%+v) did not error", resolution)
+		}
+	}
+}
+
+// TestIndexesIngestOverhead measures the overhead of data-skipping indexes
+// and projections when inserting flows.
+func TestIndexesIngestOverhead(t *testing.T) {
+	r := reporter.NewMock(t)
+	chComponent := clickhousedb.SetupClickHouse(t, r, false)
+	ctx := context.Background()
+
+	variants := []struct {
+		Name    string
+		Indexes string
+	}{
+		{"none", ""},
+		{"bloom filters", `,
+ INDEX idx_ExporterAddress ExporterAddress TYPE bloom_filter GRANULARITY 4,
+ INDEX idx_SrcAddr SrcAddr TYPE bloom_filter GRANULARITY 4,
+ INDEX idx_DstAddr DstAddr TYPE bloom_filter GRANULARITY 4`},
+		{"projection", `,
+ PROJECTION by_exporter (SELECT * ORDER BY (ExporterAddress, TimeReceived))`},
+	}
+	var reference float64
+	for idx, variant := range variants {
+		table := fmt.Sprintf("bench_indexes_%d", idx)
+		chComponent.Exec(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s SYNC", table))
+		if err := chComponent.Exec(ctx, fmt.Sprintf(`
+CREATE TABLE %s (
+ TimeReceived DateTime,
+ ExporterAddress IPv6,
+ SrcAddr IPv6,
+ DstAddr IPv6,
+ Bytes UInt64%s
+) ENGINE = MergeTree
+ORDER BY toStartOfFiveMinutes(TimeReceived)`, table, variant.Indexes)); err != nil {
+			t.Fatalf("Exec() error:\n%+v", err)
+		}
+		result := testing.Benchmark(func(b *testing.B) {
+			for range b.N {
+				if err := chComponent.Exec(ctx, fmt.Sprintf(`
+INSERT INTO %s
+SELECT
+ now() - number %% 3600,
+ toIPv6(IPv4NumToString(toUInt32(3221225984 + number %% 16))),
+ toIPv6(IPv4NumToString(toUInt32(rand32()))),
+ toIPv6(IPv4NumToString(toUInt32(rand32()))),
+ number %% 1500
+FROM numbers(100000)`, table)); err != nil {
+					b.Fatalf("Exec() error:\n%+v", err)
+				}
+			}
+		})
+		chComponent.Exec(ctx, fmt.Sprintf("DROP TABLE %s SYNC", table))
+		perOp := float64(result.NsPerOp())
+		if reference == 0 {
+			reference = perOp
+		}
+		t.Logf("%s: %s per 100k flows (%+.1f%%)",
+			variant.Name, time.Duration(result.NsPerOp()), (perOp/reference-1)*100)
+	}
+}
@@ -0,0 +1,293 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"akvorado/common/reporter"
+)
+
+// ingestionCheckResult is the result of one of the ingestion checks.
+type ingestionCheckResult struct {
+	reporter.HealthcheckResult
+	Details any `json:"details,omitempty"`
+}
+
+// ingestionHealth is the result of the ingestion checks.
+type ingestionHealth struct {
+	Status    reporter.HealthcheckStatus      `json:"status"`
+	LastCheck time.Time                       `json:"last-check"`
+	Checks    map[string]ingestionCheckResult `json:"checks"`
+}
+
+// ingestionCheck is a check of the ingestion health.
+type ingestionCheck struct {
+	Name  string
+	Check func(context.Context) ingestionCheckResult
+}
+
+// systemTable returns the name of a system table. When using a cluster, the
+// table is queried on all the replicas.
+func (c *Component) systemTable(name string) string {
+	if c.config.Cluster == "" {
+		return fmt.Sprintf("system.%s", name)
+	}
+	return fmt.Sprintf("clusterAllReplicas(%s, system.%s)", quoteString(c.config.Cluster), name)
+}
+
+// ingestionChecks returns the checks to run.
+func (c *Component) ingestionChecks() []ingestionCheck {
+	checks := []ingestionCheck{
+		{"flows", c.checkLastFlow},
+		{"errors", c.checkErrors},
+		{"backlog", c.checkBacklog},
+	}
+	if !c.config.Kafka.Disable {
+		checks = append(checks, ingestionCheck{"kafka", c.checkKafkaConsumers})
+	}
+	return checks
+}
+
+// checkLastFlow checks the age of the most recent flow.
+func (c *Component) checkLastFlow(ctx context.Context) ingestionCheckResult {
+	var results []struct {
+		Last time.Time `ch:"last"`
+	}
+	if err := c.d.ClickHouse.Select(ctx, &results, fmt.Sprintf(`
+SELECT max(TimeReceived) AS last
+FROM %s
+WHERE TimeReceived > now() - INTERVAL 1 DAY
+`, c.config.TableName("flows"))); err != nil {
+		c.r.Err(err).Msg("cannot get most recent flow")
+		return ingestionCheckResult{HealthcheckResult: reporter.HealthcheckResult{
+			Status: reporter.HealthcheckWarning,
+			Reason: fmt.Sprintf("cannot get most recent flow: %s", err),
+		}}
+	}
+	if len(results) == 0 || results[0].Last.Unix() <= 0 {
+		return ingestionCheckResult{HealthcheckResult: reporter.HealthcheckResult{
+			Status: reporter.HealthcheckWarning,
+			Reason: "no flow received during the last day",
+		}}
+	}
+	last := results[0].Last
+	delay := time.Since(last).Truncate(time.Second)
+	c.metrics.ingestionDelay.Set(delay.Seconds())
+	result := ingestionCheckResult{
+		HealthcheckResult: reporter.HealthcheckResult{
+			Status: reporter.HealthcheckOK,
+			Reason: "ok",
+		},
+		Details: struct {
+			LastReceived time.Time `json:"last-received"`
+			Delay        string    `json:"delay"`
+		}{last, delay.String()},
+	}
+	if delay > c.config.IngestionCheck.MaxDelay {
+		result.Status = reporter.HealthcheckWarning
+		result.Reason = fmt.Sprintf("most recent flow received %s ago", delay)
+	}
+	return result
+}
+
+// checkKafkaConsumers checks the Kafka engine consumers did not raise
+// exceptions recently.
+func (c *Component) checkKafkaConsumers(ctx context.Context) ingestionCheckResult {
+	var consumers []struct {
+		Table     string    `ch:"table" json:"-"`
+		Time      time.Time `ch:"time" json:"time"`
+		Exception string    `ch:"exception" json:"exception"`
+	}
+	if err := c.d.ClickHouse.Select(ctx, &consumers, fmt.Sprintf(`
+SELECT table, exceptions.time[-1] AS time, exceptions.text[-1] AS exception
+FROM %s
+WHERE database = $1 AND length(exceptions.time) > 0
+ORDER BY time DESC
+`, c.systemTable("kafka_consumers")), c.config.Database); err != nil {
+		c.r.Err(err).Msg("cannot get exceptions from Kafka consumers")
+		return ingestionCheckResult{HealthcheckResult: reporter.HealthcheckResult{
+			Status: reporter.HealthcheckWarning,
+			Reason: fmt.Sprintf("cannot get exceptions from Kafka consumers: %s", err),
+		}}
+	}
+	// Keep the most recent exception for each table
+	details := map[string]any{}
+	recent := 0
+	for _, consumer := range consumers {
+		if _, ok := details[consumer.Table]; ok {
+			continue
+		}
+		details[consumer.Table] = consumer
+		if time.Since(consumer.Time) < c.config.IngestionCheck.MaxDelay {
+			recent++
+		}
+	}
+	result := ingestionCheckResult{
+		HealthcheckResult: reporter.HealthcheckResult{
+			Status: reporter.HealthcheckOK,
+			Reason: "ok",
+		},
+	}
+	if len(details) > 0 {
+		result.Details = details
+	}
+	if recent > 0 {
+		result.Status = reporter.HealthcheckWarning
+		result.Reason = fmt.Sprintf("%d Kafka consumer tables with recent exceptions", recent)
+	}
+	return result
+}
+
+// checkErrors checks if recent errors are related to our tables.
+func (c *Component) checkErrors(ctx context.Context) ingestionCheckResult {
+	var recentErrors []struct {
+		Name    string    `ch:"name" json:"name"`
+		Count   uint64    `ch:"value" json:"count"`
+		Time    time.Time `ch:"last_error_time" json:"time"`
+		Message string    `ch:"last_error_message" json:"message"`
+	}
+	if err := c.d.ClickHouse.Select(ctx, &recentErrors, fmt.Sprintf(`
+SELECT name, value, last_error_time, last_error_message
+FROM %s
+WHERE last_error_time > now() - toIntervalSecond($1)
+AND position(last_error_message, $2) > 0
+ORDER BY last_error_time DESC
+`, c.systemTable("errors")),
+		uint64(c.config.IngestionCheck.MaxDelay.Seconds()),
+		fmt.Sprintf("%s.%s", c.config.Database, c.config.TableName("flows"))); err != nil {
+		c.r.Err(err).Msg("cannot get recent errors")
+		return ingestionCheckResult{HealthcheckResult: reporter.HealthcheckResult{
+			Status: reporter.HealthcheckWarning,
+			Reason: fmt.Sprintf("cannot get recent errors: %s", err),
+		}}
+	}
+	if len(recentErrors) == 0 {
+		return ingestionCheckResult{HealthcheckResult: reporter.HealthcheckResult{
+			Status: reporter.HealthcheckOK,
+			Reason: "ok",
+		}}
+	}
+	return ingestionCheckResult{
+		HealthcheckResult: reporter.HealthcheckResult{
+			Status: reporter.HealthcheckWarning,
+			Reason: fmt.Sprintf("%d recent errors related to flows tables", len(recentErrors)),
+		},
+		Details: recentErrors,
+	}
+}
+
+// checkBacklog checks the number of pending mutations and of parts to merge.
+func (c *Component) checkBacklog(ctx context.Context) ingestionCheckResult {
+	var backlog []struct {
+		Mutations uint64 `ch:"mutations" json:"pending-mutations"`
+		Parts     uint64 `ch:"parts" json:"max-parts"`
+		Table     string `ch:"table" json:"max-parts-table"`
+	}
+	if err := c.d.ClickHouse.Select(ctx, &backlog, fmt.Sprintf(`
+SELECT
+ (SELECT count() FROM %s WHERE database = $1 AND NOT is_done) AS mutations,
+ max(parts) AS parts,
+ argMax(table, parts) AS table
+FROM (
+ SELECT table, count() AS parts
+ FROM %s
+ WHERE database = $1 AND active
+ GROUP BY hostName(), table, partition_id
+)
+`, c.systemTable("mutations"), c.systemTable("parts")), c.config.Database); err != nil {
+		c.r.Err(err).Msg("cannot get merge backlog")
+		return ingestionCheckResult{HealthcheckResult: reporter.HealthcheckResult{
+			Status: reporter.HealthcheckWarning,
+			Reason: fmt.Sprintf("cannot get merge backlog: %s", err),
+		}}
+	}
+	result := ingestionCheckResult{
+		HealthcheckResult: reporter.HealthcheckResult{
+			Status: reporter.HealthcheckOK,
+			Reason: "ok",
+		},
+	}
+	if len(backlog) == 0 {
+		return result
+	}
+	c.metrics.ingestionMutations.Set(float64(backlog[0].Mutations))
+	c.metrics.ingestionParts.Set(float64(backlog[0].Parts))
+	result.Details = backlog[0]
+	switch {
+	case backlog[0].Mutations > uint64(c.config.IngestionCheck.MaxMutations):
+		result.Status = reporter.HealthcheckWarning
+		result.Reason = fmt.Sprintf("%d pending mutations", backlog[0].Mutations)
+	case backlog[0].Parts > uint64(c.config.IngestionCheck.MaxParts):
+		result.Status = reporter.HealthcheckWarning
+		result.Reason = fmt.Sprintf("%d active parts in a partition of %s",
+			backlog[0].Parts, backlog[0].Table)
+	}
+	return result
+}
+
+// checkIngestion runs all the ingestion checks and records their results.
+func (c *Component) checkIngestion(ctx context.Context) ingestionHealth {
+	health := ingestionHealth{
+		Status:    reporter.HealthcheckOK,
+		LastCheck: time.Now(),
+		Checks:    map[string]ingestionCheckResult{},
+	}
+	for _, check := range c.ingestionChecks() {
+		result := check.Check(ctx)
+		health.Checks[check.Name] = result
+		health.Status = max(health.Status, result.Status)
+		c.metrics.ingestionStatus.WithLabelValues(check.Name).Set(float64(result.Status))
+	}
+	c.ingestionHealthLock.Lock()
+	c.ingestionHealth = &health
+	c.ingestionHealthLock.Unlock()
+	return health
+}
+
+// ingestionChecker periodically checks the ingestion once migrations are
+// done.
+func (c *Component) ingestionChecker() {
+	select {
+	case <-c.t.Dying():
+		return
+	case <-c.migrationsDone:
+	}
+	ticker := time.NewTicker(c.config.IngestionCheck.Interval)
+	defer ticker.Stop()
+	for {
+		c.checkIngestion(c.t.Context(nil))
+		select {
+		case <-c.t.Dying():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ingestionHealthcheck returns the result of the last ingestion checks.
+func (c *Component) ingestionHealthcheck(_ context.Context) reporter.HealthcheckResult {
+	c.ingestionHealthLock.RLock()
+	defer c.ingestionHealthLock.RUnlock()
+	if c.ingestionHealth == nil {
+		return reporter.HealthcheckResult{
+			Status: reporter.HealthcheckOK,
+			Reason: "not checked yet",
+		}
+	}
+	if c.ingestionHealth.Status == reporter.HealthcheckOK {
+		return reporter.HealthcheckResult{Status: reporter.HealthcheckOK, Reason: "ok"}
+	}
+	for _, check := range c.ingestionChecks() {
+		if result := c.ingestionHealth.Checks[check.Name]; result.Status == c.ingestionHealth.Status {
+			return reporter.HealthcheckResult{
+				Status: result.Status,
+				Reason: fmt.Sprintf("%s: %s", check.Name, result.Reason),
+			}
+		}
+	}
+	return reporter.HealthcheckResult{Status: c.ingestionHealth.Status}
+}
@@ -0,0 +1,216 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package clickhouse
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/mock/gomock"
+
+	"akvorado/common/clickhousedb"
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/httpserver"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+)
+
+func TestCheckIngestion(t *testing.T) {
+	type lastFlow = struct {
+		Last time.Time `ch:"last"`
+	}
+	type consumer = struct {
+		Table     string    `ch:"table" json:"-"`
+		Time      time.Time `ch:"time" json:"time"`
+		Exception string    `ch:"exception" json:"exception"`
+	}
+	type recentError = struct {
+		Name    string    `ch:"name" json:"name"`
+		Count   uint64    `ch:"value" json:"count"`
+		Time    time.Time `ch:"last_error_time" json:"time"`
+		Message string    `ch:"last_error_message" json:"message"`
+	}
+	type backlog = struct {
+		Mutations uint64 `ch:"mutations" json:"pending-mutations"`
+		Parts     uint64 `ch:"parts" json:"max-parts"`
+		Table     string `ch:"table" json:"max-parts-table"`
+	}
+	r := reporter.NewMock(t)
+	chComponent, mockConn := clickhousedb.NewMock(t, r)
+	c, err := New(r, DefaultConfiguration(), Dependencies{
+		Daemon:     daemon.NewMock(t),
+		HTTP:       httpserver.NewMock(t, r),
+		Schema:     schema.NewMock(t),
+		ClickHouse: chComponent,
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+
+	helpers.TestHTTPEndpoints(t, c.d.HTTP.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			Description: "not checked yet",
+			URL:         "/api/v0/orchestrator/clickhouse/ingestion",
+			StatusCode:  503,
+			ContentType: "text/plain; charset=utf-8",
+			FirstLines:  []string{"Ingestion not checked yet."},
+		},
+	})
+	if got := c.ingestionHealthcheck(context.Background()); got.Status != reporter.HealthcheckOK {
+		t.Errorf("ingestionHealthcheck() == %+v, expected OK", got)
+	}
+
+	now := time.Now()
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), `
+SELECT max(TimeReceived) AS last
+FROM flows
+WHERE TimeReceived > now() - INTERVAL 1 DAY
+`).
+		SetArg(1, []lastFlow{{now.Add(-10 * time.Second)}}).
+		Return(nil)
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), `
+SELECT name, value, last_error_time, last_error_message
+FROM system.errors
+WHERE last_error_time > now() - toIntervalSecond($1)
+AND position(last_error_message, $2) > 0
+ORDER BY last_error_time DESC
+`, uint64(300), "default.flows").
+		SetArg(1, []recentError{}).
+		Return(nil)
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), `
+SELECT
+ (SELECT count() FROM system.mutations WHERE database = $1 AND NOT is_done) AS mutations,
+ max(parts) AS parts,
+ argMax(table, parts) AS table
+FROM (
+ SELECT table, count() AS parts
+ FROM system.parts
+ WHERE database = $1 AND active
+ GROUP BY hostName(), table, partition_id
+)
+`, "default").
+		SetArg(1, []backlog{{Mutations: 2, Parts: 250, Table: "flows"}}).
+		Return(nil)
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), `
+SELECT table, exceptions.time[-1] AS time, exceptions.text[-1] AS exception
+FROM system.kafka_consumers
+WHERE database = $1 AND length(exceptions.time) > 0
+ORDER BY time DESC
+`, "default").
+		SetArg(1, []consumer{
+			{"flows_HASH_raw", now.Add(-time.Minute), "Cannot parse input"},
+			{"flows_HASH_raw", now.Add(-time.Hour), "Broker unreachable"},
+			{"flows_HASH_raw_2", now.Add(-time.Hour), "Broker unreachable"},
+		}).
+		Return(nil)
+
+	health := c.checkIngestion(context.Background())
+	if health.Status != reporter.HealthcheckWarning {
+		t.Errorf("checkIngestion() status == %s, expected warning", health.Status)
+	}
+	gotReasons := map[string]string{}
+	for name, check := range health.Checks {
+		gotReasons[name] = check.Reason
+	}
+	expectedReasons := map[string]string{
+		"flows":   "ok",
+		"errors":  "ok",
+		"backlog": "250 active parts in a partition of flows",
+		"kafka":   "1 Kafka consumer tables with recent exceptions",
+	}
+	if diff := helpers.Diff(gotReasons, expectedReasons); diff != "" {
+		t.Errorf("checkIngestion() (-got, +want):\n%s", diff)
+	}
+	expectedHealthcheck := reporter.HealthcheckResult{
+		Status: reporter.HealthcheckWarning,
+		Reason: "backlog: 250 active parts in a partition of flows",
+	}
+	if diff := helpers.Diff(c.ingestionHealthcheck(context.Background()), expectedHealthcheck); diff != "" {
+		t.Errorf("ingestionHealthcheck() (-got, +want):\n%s", diff)
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_orchestrator_clickhouse_ingestion_", "check_", "pending_", "max_")
+	expectedMetrics := map[string]string{
+		`check_status{check="backlog"}`: "1",
+		`check_status{check="errors"}`:  "0",
+		`check_status{check="flows"}`:   "0",
+		`check_status{check="kafka"}`:   "1",
+		`pending_mutations`:             "2",
+		`max_parts_per_partition`:       "250",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Errorf("Metrics (-got, +want):\n%s", diff)
+	}
+
+	// Check the JSON output with a fixed result
+	lastCheck := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	c.ingestionHealth = &ingestionHealth{
+		Status:    reporter.HealthcheckWarning,
+		LastCheck: lastCheck,
+		Checks: map[string]ingestionCheckResult{
+			"flows": {
+				HealthcheckResult: reporter.HealthcheckResult{
+					Status: reporter.HealthcheckWarning,
+					Reason: "most recent flow received 10m0s ago",
+				},
+			},
+		},
+	}
+	helpers.TestHTTPEndpoints(t, c.d.HTTP.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			URL: "/api/v0/orchestrator/clickhouse/ingestion",
+			JSONOutput: gin.H{
+				"status":     "warning",
+				"last-check": "2024-03-01T10:00:00Z",
+				"checks": gin.H{
+					"flows": gin.H{
+						"status": "warning",
+						"reason": "most recent flow received 10m0s ago",
+					},
+				},
+			},
+		},
+	})
+}
+
+func TestCheckLastFlow(t *testing.T) {
+	type lastFlow = struct {
+		Last time.Time `ch:"last"`
+	}
+	cases := []struct {
+		Description string
+		Last        []lastFlow
+		Status      reporter.HealthcheckStatus
+	}{
+		{"recent flow", []lastFlow{{time.Now().Add(-30 * time.Second)}}, reporter.HealthcheckOK},
+		{"old flow", []lastFlow{{time.Now().Add(-time.Hour)}}, reporter.HealthcheckWarning},
+		{"no flow", []lastFlow{{time.Unix(0, 0)}}, reporter.HealthcheckWarning},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			r := reporter.NewMock(t)
+			chComponent, mockConn := clickhousedb.NewMock(t, r)
+			c := Component{
+				r:      r,
+				d:      &Dependencies{ClickHouse: chComponent},
+				config: DefaultConfiguration(),
+			}
+			c.initMetrics()
+			mockConn.EXPECT().
+				Select(gomock.Any(), gomock.Any(), gomock.Any()).
+				SetArg(1, tc.Last).
+				Return(nil)
+			if got := c.checkLastFlow(context.Background()); got.Status != tc.Status {
+				t.Errorf("checkLastFlow() == %+v, expected %s", got, tc.Status)
+			}
+		})
+	}
+}
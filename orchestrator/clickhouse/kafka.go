@@ -0,0 +1,127 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package clickhouse
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// kafkaLagRefreshInterval is the interval between two refreshes of the lag of
+// the Kafka engine consumers.
+const kafkaLagRefreshInterval = 30 * time.Second
+
+// kafkaPartitionLag is the lag of the Kafka engine consumers for a
+// partition.
+type kafkaPartitionLag struct {
+	Table     string
+	Topic     string
+	Partition int32
+	Lag       int64
+}
+
+// rdkafkaStats is the subset of the librdkafka statistics we use.
+type rdkafkaStats struct {
+	Topics map[string]struct {
+		Partitions map[string]struct {
+			Partition   int32 `json:"partition"`
+			ConsumerLag int64 `json:"consumer_lag"`
+		} `json:"partitions"`
+	} `json:"topics"`
+}
+
+// kafkaConsumersLag returns the lag of the Kafka engine consumers for each
+// partition, using the statistics exposed in system.kafka_consumers.
+func (c *Component) kafkaConsumersLag(ctx context.Context) ([]kafkaPartitionLag, error) {
+	var consumers []struct {
+		Table string `ch:"table"`
+		Stats string `ch:"rdkafka_stat"`
+	}
+	if err := c.d.ClickHouse.Select(ctx, &consumers, fmt.Sprintf(`
+SELECT table, rdkafka_stat
+FROM %s
+WHERE database = $1 AND rdkafka_stat != ''
+`, c.systemTable("kafka_consumers")), c.config.Database); err != nil {
+		return nil, fmt.Errorf("cannot query Kafka consumers: %w", err)
+	}
+
+	// A partition is assigned to only one consumer. Other consumers report a
+	// negative lag.
+	lags := map[kafkaPartitionLag]int64{}
+	for _, consumer := range consumers {
+		var stats rdkafkaStats
+		if err := json.Unmarshal([]byte(consumer.Stats), &stats); err != nil {
+			return nil, fmt.Errorf("cannot parse statistics for Kafka consumer of %s: %w",
+				consumer.Table, err)
+		}
+		for topic, topicStats := range stats.Topics {
+			for _, partition := range topicStats.Partitions {
+				if partition.Partition < 0 || partition.ConsumerLag < 0 {
+					continue
+				}
+				key := kafkaPartitionLag{
+					Table:     consumer.Table,
+					Topic:     topic,
+					Partition: partition.Partition,
+				}
+				lags[key] = max(lags[key], partition.ConsumerLag)
+			}
+		}
+	}
+	result := make([]kafkaPartitionLag, 0, len(lags))
+	for key, lag := range lags {
+		key.Lag = lag
+		result = append(result, key)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Table != result[j].Table {
+			return result[i].Table < result[j].Table
+		}
+		if result[i].Topic != result[j].Topic {
+			return result[i].Topic < result[j].Topic
+		}
+		return result[i].Partition < result[j].Partition
+	})
+	return result, nil
+}
+
+// refreshKafkaLag updates the metrics about the lag of the Kafka engine
+// consumers.
+func (c *Component) refreshKafkaLag(ctx context.Context) {
+	lags, err := c.kafkaConsumersLag(ctx)
+	if err != nil {
+		c.r.Err(err).Msg("unable to get lag of Kafka consumers")
+		return
+	}
+	c.metrics.kafkaConsumerLag.Reset()
+	for _, lag := range lags {
+		c.metrics.kafkaConsumerLag.
+			WithLabelValues(lag.Table, lag.Topic, strconv.Itoa(int(lag.Partition))).
+			Set(float64(lag.Lag))
+	}
+}
+
+// kafkaLagRefresher periodically refreshes the lag of the Kafka engine
+// consumers once migrations are done.
+func (c *Component) kafkaLagRefresher() {
+	select {
+	case <-c.t.Dying():
+		return
+	case <-c.migrationsDone:
+	}
+	ticker := time.NewTicker(kafkaLagRefreshInterval)
+	defer ticker.Stop()
+	for {
+		c.refreshKafkaLag(c.t.Context(nil))
+		select {
+		case <-c.t.Dying():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package clickhouse
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/mock/gomock"
+
+	"akvorado/common/clickhousedb"
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+)
+
+func TestKafkaConsumersLag(t *testing.T) {
+	type consumer = struct {
+		Table string `ch:"table"`
+		Stats string `ch:"rdkafka_stat"`
+	}
+	r := reporter.NewMock(t)
+	chComponent, mockConn := clickhousedb.NewMock(t, r)
+	c := Component{
+		r:      r,
+		d:      &Dependencies{ClickHouse: chComponent},
+		config: DefaultConfiguration(),
+	}
+	c.initMetrics()
+
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), `
+SELECT table, rdkafka_stat
+FROM system.kafka_consumers
+WHERE database = $1 AND rdkafka_stat != ''
+`, "default").
+		SetArg(1, []consumer{
+			{"flows_HASH_raw", `{"topics": {"flows-HASH": {"partitions": {
+  "0": {"partition": 0, "consumer_lag": 120},
+  "1": {"partition": 1, "consumer_lag": -1},
+  "-1": {"partition": -1, "consumer_lag": -1}}}}}`},
+			{"flows_HASH_raw", `{"topics": {"flows-HASH": {"partitions": {
+  "0": {"partition": 0, "consumer_lag": -1},
+  "1": {"partition": 1, "consumer_lag": 30}}}}}`},
+			{"flows_HASH_raw_2", `{"topics": {"flows-HASH": {"partitions": {
+  "2": {"partition": 2, "consumer_lag": 0}}}}}`},
+		}).
+		Return(nil)
+
+	c.refreshKafkaLag(context.Background())
+	gotMetrics := r.GetMetrics("akvorado_orchestrator_clickhouse_", "kafka_")
+	expectedMetrics := map[string]string{
+		`kafka_consumer_lag_messages{partition="0",table="flows_HASH_raw",topic="flows-HASH"}`:   "120",
+		`kafka_consumer_lag_messages{partition="1",table="flows_HASH_raw",topic="flows-HASH"}`:   "30",
+		`kafka_consumer_lag_messages{partition="2",table="flows_HASH_raw_2",topic="flows-HASH"}`: "0",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestKafkaConsumersLagCluster(t *testing.T) {
+	type consumer = struct {
+		Table string `ch:"table"`
+		Stats string `ch:"rdkafka_stat"`
+	}
+	r := reporter.NewMock(t)
+	chComponent, mockConn := clickhousedb.NewMock(t, r)
+	config := DefaultConfiguration()
+	config.Cluster = "akvorado"
+	c := Component{
+		r:      r,
+		d:      &Dependencies{ClickHouse: chComponent},
+		config: config,
+	}
+
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), `
+SELECT table, rdkafka_stat
+FROM clusterAllReplicas('akvorado', system.kafka_consumers)
+WHERE database = $1 AND rdkafka_stat != ''
+`, "default").
+		SetArg(1, []consumer{{"flows_HASH_raw", `not JSON`}}).
+		Return(nil)
+
+	if _, err := c.kafkaConsumersLag(context.Background()); err == nil {
+		t.Fatal("kafkaConsumersLag() did not error")
+	}
+}
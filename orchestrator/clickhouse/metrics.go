@@ -10,7 +10,16 @@ type metrics struct {
 	migrationsApplied    reporter.Counter
 	migrationsNotApplied reporter.Counter
 
-	networksReload reporter.Counter
+	networksReload         reporter.Counter
+	materializedPartitions reporter.Counter
+	backfilledSlices       reporter.Counter
+
+	kafkaConsumerLag *reporter.GaugeVec
+
+	ingestionStatus    *reporter.GaugeVec
+	ingestionDelay     reporter.Gauge
+	ingestionMutations reporter.Gauge
+	ingestionParts     reporter.Gauge
 }
 
 func (c *Component) initMetrics() {
@@ -38,4 +47,48 @@ func (c *Component) initMetrics() {
 			Help: "Number of reloads triggered for networks dictionary.",
 		},
 	)
+	c.metrics.materializedPartitions = c.r.Counter(
+		reporter.CounterOpts{
+			Name: "materialized_partitions_total",
+			Help: "Number of partitions where an index or a projection was materialized.",
+		},
+	)
+	c.metrics.backfilledSlices = c.r.Counter(
+		reporter.CounterOpts{
+			Name: "backfilled_slices_total",
+			Help: "Number of time slices rebuilt in consolidated tables.",
+		},
+	)
+	c.metrics.kafkaConsumerLag = c.r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "kafka_consumer_lag_messages",
+			Help: "Number of messages not yet consumed by ClickHouse for a partition.",
+		},
+		[]string{"table", "topic", "partition"},
+	)
+	c.metrics.ingestionStatus = c.r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "ingestion_check_status",
+			Help: "Status of an ingestion check (0 is OK, 1 is warning, 2 is error).",
+		},
+		[]string{"check"},
+	)
+	c.metrics.ingestionDelay = c.r.Gauge(
+		reporter.GaugeOpts{
+			Name: "ingestion_delay_seconds",
+			Help: "Age of the most recent flow.",
+		},
+	)
+	c.metrics.ingestionMutations = c.r.Gauge(
+		reporter.GaugeOpts{
+			Name: "ingestion_pending_mutations",
+			Help: "Number of pending mutations.",
+		},
+	)
+	c.metrics.ingestionParts = c.r.Gauge(
+		reporter.GaugeOpts{
+			Name: "ingestion_max_parts_per_partition",
+			Help: "Maximum number of active parts in a partition.",
+		},
+	)
 }
@@ -34,7 +34,28 @@ type migrationStepWithDescription struct {
 // migrateDatabase execute database migration
 func (c *Component) migrateDatabase() error {
 	ctx := c.t.Context(nil)
+	c.migrationsLock.Lock()
+	defer c.migrationsLock.Unlock()
 
+	if err := c.runMigrations(ctx); err != nil {
+		return err
+	}
+
+	close(c.migrationsDone)
+	c.metrics.migrationsRunning.Set(0)
+	c.r.Info().Msg("database migration done")
+
+	// Reload dictionaries
+	if err := c.d.ClickHouse.ExecOnCluster(ctx, "SYSTEM RELOAD DICTIONARIES"); err != nil {
+		c.r.Err(err).Msg("unable to reload dictionaries after migration")
+	}
+
+	return nil
+}
+
+// runMigrations runs all the migration steps. When the context contains a
+// migration plan, the statements are recorded instead of being executed.
+func (c *Component) runMigrations(ctx context.Context) error {
 	// Set orchestrator URL
 	if c.config.OrchestratorURL == "" {
 		baseURL, err := c.getHTTPBaseURL("1.1.1.1:80")
@@ -56,24 +77,18 @@ func (c *Component) migrateDatabase() error {
 		c.r.Warn().Msgf("too many consumers requested, capping to %d", threads)
 		c.config.Kafka.Consumers = int(threads)
 	}
+	if total := c.config.Kafka.Consumers * len(c.rawFlowsTables()); total > int(threads) {
+		c.r.Warn().Msgf("%d consumers spread over several tables, more than the %d available threads",
+			total, threads)
+	}
 	if err := validateVersion(version); err != nil {
 		return fmt.Errorf("incorrect ClickHouse version: %w", err)
 	}
 
 	if c.config.Cluster != "" {
-		var shardNum uint64
-		row = c.d.ClickHouse.QueryRow(ctx,
-			`SELECT countDistinct(shard_num) AS num FROM system.clusters WHERE cluster = $1`,
-			c.config.Cluster,
-		)
-		if err := row.Scan(&shardNum); err != nil {
-			c.r.Err(err).Msg("unable to parse cluster settings")
-			return fmt.Errorf("unable to parse cluster settings: %w", err)
-		}
-		if shardNum == 0 {
-			return fmt.Errorf("cannot get the number of shards for the cluster")
+		if err := c.checkCluster(ctx); err != nil {
+			return err
 		}
-		c.shards = int(shardNum)
 	}
 
 	// Create dictionaries
@@ -89,9 +104,17 @@ func (c *Component) migrateDatabase() error {
 			return c.createDictionary(ctx, schema.DictionaryICMP, "complex_key_hashed",
 				"`proto` UInt8, `type` UInt8, `code` UInt8, `name` String", "proto, type, code")
 		}, func(ctx context.Context) error {
-			return c.createDictionary(ctx, schema.DictionaryNetworks, "ip_trie",
-				"`network` String, `name` String, `role` String, `site` String, `region` String, `city` String, `state` String, `country` String, `tenant` String, `asn` UInt32",
-				"network")
+			return c.createDictionary(ctx, schema.DictionaryDSCP, "hashed",
+				"`dscp` UInt8 INJECTIVE, `name` String", "dscp")
+		}, func(ctx context.Context) error {
+			attributes := "`network` String, `name` String, `role` String, `site` String, `region` String, `city` String, `state` String, `country` String, `tenant` String, `asn` UInt32, `asname` String"
+			if c.networksWithPrefixLen() {
+				attributes = fmt.Sprintf("%s, `prefixlen` UInt8 DEFAULT %d", attributes, schema.NetPrefixLenUnknown)
+			}
+			for _, attr := range c.d.Schema.GetCustomNetworkAttributes() {
+				attributes = fmt.Sprintf("%s, `%s` String", attributes, attr)
+			}
+			return c.createDictionary(ctx, schema.DictionaryNetworks, "ip_trie", attributes, "network")
 		}, func(ctx context.Context) error {
 			return c.createDictionary(ctx, schema.DictionaryTCP, "hashed",
 				"`port` UInt16 INJECTIVE, `name` String", "port")
@@ -143,11 +166,14 @@ func (c *Component) migrateDatabase() error {
 		err := c.wrapMigrations(ctx,
 			func(ctx context.Context) error {
 				return c.createOrUpdateFlowsTable(ctx, resolution)
+			}, func(ctx context.Context) error {
+				return c.createOrUpdateFlowsIndexes(ctx, resolution)
 			}, func(ctx context.Context) error {
 				if resolution.Interval == 0 {
-					return c.createDistributedTable(ctx, "flows")
+					return c.createDistributedTable(ctx, c.config.TableName("flows"))
 				}
-				return c.createDistributedTable(ctx, fmt.Sprintf("flows_%s", resolution.Interval))
+				return c.createDistributedTable(ctx,
+					c.config.TableName(fmt.Sprintf("flows_%s", resolution.Interval)))
 			}, func(ctx context.Context) error {
 				return c.createFlowsConsumerView(ctx, resolution)
 			})
@@ -158,31 +184,37 @@ func (c *Component) migrateDatabase() error {
 
 	// Remaining tables
 	err = c.wrapMigrations(ctx,
+		c.dropRemovedFlowsTables,
 		c.createExportersTable,
 		c.createExportersConsumerView,
-		c.createRawFlowsTable,
-		c.createRawFlowsConsumerView,
 		c.createRawFlowsErrors,
 		func(ctx context.Context) error {
-			return c.createDistributedTable(ctx, "flows_raw_errors")
+			return c.createDistributedTable(ctx, c.config.TableName("flows_raw_errors"))
 		},
-		c.createRawFlowsErrorsConsumerView,
-		c.deleteOldRawFlowsErrorsView,
 	)
 	if err != nil {
 		return err
 	}
 
-	close(c.migrationsDone)
-	c.metrics.migrationsRunning.Set(0)
-	c.r.Info().Msg("database migration done")
-
-	// Reload dictionaries
-	if err := c.d.ClickHouse.ExecOnCluster(ctx, "SYSTEM RELOAD DICTIONARIES"); err != nil {
-		c.r.Err(err).Msg("unable to reload dictionaries after migration")
+	// Raw tables
+	for _, tableName := range c.rawFlowsTables() {
+		err := c.wrapMigrations(ctx,
+			func(ctx context.Context) error {
+				return c.createRawFlowsTable(ctx, tableName)
+			}, func(ctx context.Context) error {
+				return c.createRawFlowsConsumerView(ctx, tableName)
+			}, func(ctx context.Context) error {
+				return c.createRawFlowsErrorsConsumerView(ctx, tableName)
+			})
+		if err != nil {
+			return err
+		}
 	}
-
-	return nil
+	err = c.wrapMigrations(ctx,
+		c.dropRemovedRawFlowsTables,
+		c.deleteOldRawFlowsErrorsView,
+	)
+	return err
 }
 
 // getHTTPBaseURL tries to guess the appropriate URL to access our
@@ -210,5 +242,6 @@ func (c *Component) getHTTPBaseURL(address string) (string, error) {
 
 // ReloadDictionary will reload the specified dictionnary.
 func (c *Component) ReloadDictionary(ctx context.Context, dictName string) error {
-	return c.d.ClickHouse.ExecOnCluster(ctx, fmt.Sprintf("SYSTEM RELOAD DICTIONARY %s", dictName))
+	return c.d.ClickHouse.ExecOnCluster(ctx,
+		fmt.Sprintf("SYSTEM RELOAD DICTIONARY %s", c.config.TableName(dictName)))
 }
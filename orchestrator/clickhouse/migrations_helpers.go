@@ -8,7 +8,9 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"net"
 	"regexp"
+	"strconv"
 	"strings"
 	"text/template"
 	"time"
@@ -17,6 +19,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"golang.org/x/exp/slices"
 
+	"akvorado/common/clickhousedb"
 	"akvorado/common/schema"
 )
 
@@ -26,11 +29,16 @@ var errSkipStep = errors.New("migration: skip this step")
 // metrics up-to-date as long as the migration function returns `errSkipStep`
 // when a step is skipped.
 func (c *Component) wrapMigrations(ctx context.Context, fns ...func(context.Context) error) error {
+	planning := migrationPlanFromContext(ctx) != nil
 	for _, fn := range fns {
 		if err := fn(ctx); err == nil {
-			c.metrics.migrationsApplied.Inc()
+			if !planning {
+				c.metrics.migrationsApplied.Inc()
+			}
 		} else if err == errSkipStep {
-			c.metrics.migrationsNotApplied.Inc()
+			if !planning {
+				c.metrics.migrationsNotApplied.Inc()
+			}
 		} else {
 			return err
 		}
@@ -59,20 +67,61 @@ func quoteString(s string) string {
 
 // tableAlreadyExists compare the provided table with the one in database.
 // `column` can either be "create_table_query" or "as_select". target is the
-// expected value.
+// expected value. On a cluster, the table should match on all replicas.
 func (c *Component) tableAlreadyExists(ctx context.Context, table, column, target string) (bool, error) {
 	// Normalize a bit the target. This is far from perfect, but we test that
 	// and we hope this does not differ between ClickHouse versions!
 	target = strings.TrimSpace(regexp.MustCompile("\\s+").ReplaceAllString(target, " "))
 
-	// Fetch the existing one
-	row := c.d.ClickHouse.QueryRow(ctx,
-		fmt.Sprintf("SELECT %s FROM system.tables WHERE name = $1 AND database = $2", column),
+	if c.config.Cluster == "" {
+		// Fetch the existing one
+		row := c.d.ClickHouse.QueryRow(ctx,
+			fmt.Sprintf("SELECT %s FROM system.tables WHERE name = $1 AND database = $2", column),
+			table, c.config.Database)
+		var existing string
+		if err := row.Scan(&existing); err != nil && err != sql.ErrNoRows {
+			return false, fmt.Errorf("cannot check if table %s already exists: %w", table, err)
+		}
+		return c.tableStateMatches(table, existing, target), nil
+	}
+
+	// Fetch the existing ones from all replicas. Some of them may be
+	// missing if a previous migration was interrupted.
+	rows, err := c.d.ClickHouse.Query(ctx,
+		fmt.Sprintf(`
+SELECT hostName(), %s
+FROM clusterAllReplicas(%s, system.tables)
+WHERE name = $1 AND database = $2`, column, quoteString(c.config.Cluster)),
 		table, c.config.Database)
-	var existing string
-	if err := row.Scan(&existing); err != nil && err != sql.ErrNoRows {
+	if err != nil {
+		return false, fmt.Errorf("cannot check if table %s already exists: %w", table, err)
+	}
+	defer rows.Close()
+	found := 0
+	matches := true
+	for rows.Next() {
+		var host, existing string
+		if err := rows.Scan(&host, &existing); err != nil {
+			return false, fmt.Errorf("cannot check if table %s already exists: %w", table, err)
+		}
+		found++
+		if !c.tableStateMatches(table, existing, target) {
+			c.r.Debug().Str("host", host).Msgf("table %s differs on one replica", table)
+			matches = false
+		}
+	}
+	if err := rows.Err(); err != nil {
 		return false, fmt.Errorf("cannot check if table %s already exists: %w", table, err)
 	}
+	if found < c.replicas {
+		c.r.Debug().Msgf("table %s is missing on %d replicas", table, c.replicas-found)
+		return false, nil
+	}
+	return found > 0 && matches, nil
+}
+
+// tableStateMatches compares the existing state of a table with the target.
+func (c *Component) tableStateMatches(table, existing, target string) bool {
 	// Add a few tweaks
 	existing = strings.ReplaceAll(existing,
 		fmt.Sprintf(`dictGetOrDefault('%s.`, c.config.Database),
@@ -84,12 +133,12 @@ func (c *Component) tableAlreadyExists(ctx context.Context, table, column, targe
 
 	// Compare!
 	if existing == target {
-		return true, nil
+		return true
 	}
 	c.r.Debug().
 		Str("target", target).Str("existing", existing).
 		Msgf("table %s state difference detected", table)
-	return false, nil
+	return false
 }
 
 // mergeTreeEngine returns a MergeTree engine definition, either plain or using
@@ -98,8 +147,8 @@ func (c *Component) mergeTreeEngine(table string, variant string, args ...string
 	if c.config.Cluster != "" {
 		return fmt.Sprintf(`Replicated%sMergeTree(%s)`, variant, strings.Join(
 			append([]string{
-				fmt.Sprintf("'/clickhouse/tables/shard-{shard}/%s'", table),
-				"'replica-{replica}'",
+				quoteString(strings.ReplaceAll(c.config.ReplicationPath, "{table}", table)),
+				quoteString(c.config.ReplicaName),
 			}, args...),
 			", "))
 	}
@@ -124,9 +173,68 @@ func (c *Component) localTable(table string) string {
 	return table
 }
 
-// createDictionary creates the provided dictionary.
-func (c *Component) createDictionary(ctx context.Context, name, layout, schema, primary string) error {
-	url := fmt.Sprintf("%s/api/v0/orchestrator/clickhouse/%s.csv", c.config.OrchestratorURL, name)
+// checkCluster checks the topology of the cluster and the connectivity to
+// each shard. It also records the number of shards and replicas.
+func (c *Component) checkCluster(ctx context.Context) error {
+	var replicas []struct {
+		ShardNum uint32 `ch:"shard_num"`
+		HostName string `ch:"host_name"`
+		Port     uint16 `ch:"port"`
+	}
+	if err := c.d.ClickHouse.Select(ctx, &replicas, `
+SELECT shard_num, host_name, port
+FROM system.clusters
+WHERE cluster = $1
+ORDER BY shard_num, replica_num
+`, c.config.Cluster); err != nil {
+		c.r.Err(err).Msg("unable to parse cluster settings")
+		return fmt.Errorf("unable to parse cluster settings: %w", err)
+	}
+	if len(replicas) == 0 {
+		return fmt.Errorf("cluster %s is not defined in ClickHouse", c.config.Cluster)
+	}
+
+	// Query each shard. Unavailable shards are skipped.
+	var reachable []struct {
+		ShardNum uint32 `ch:"shard_num"`
+	}
+	if err := c.d.ClickHouse.Select(ctx, &reachable, fmt.Sprintf(`
+SELECT DISTINCT _shard_num AS shard_num
+FROM cluster(%s, system.one)
+SETTINGS skip_unavailable_shards = 1
+`, quoteString(c.config.Cluster))); err != nil {
+		return fmt.Errorf("cannot query shards of cluster %s: %w", c.config.Cluster, err)
+	}
+	hosts := map[uint32][]string{}
+	shards := []uint32{}
+	for _, replica := range replicas {
+		if _, ok := hosts[replica.ShardNum]; !ok {
+			shards = append(shards, replica.ShardNum)
+		}
+		hosts[replica.ShardNum] = append(hosts[replica.ShardNum],
+			net.JoinHostPort(replica.HostName, strconv.Itoa(int(replica.Port))))
+	}
+	for _, shard := range reachable {
+		delete(hosts, shard.ShardNum)
+	}
+	for _, shard := range shards {
+		if unreachable, ok := hosts[shard]; ok {
+			return fmt.Errorf("cannot reach shard %d of cluster %s (%s)",
+				shard, c.config.Cluster, strings.Join(unreachable, ", "))
+		}
+	}
+
+	c.shards = len(shards)
+	c.replicas = len(replicas)
+	c.r.Debug().Msgf("cluster %s has %d shards and %d replicas", c.config.Cluster, c.shards, c.replicas)
+	return nil
+}
+
+// createDictionary creates the provided dictionary. The provided name is
+// prefixed with the table prefix.
+func (c *Component) createDictionary(ctx context.Context, dictName, layout, schema, primary string) error {
+	url := fmt.Sprintf("%s/api/v0/orchestrator/clickhouse/%s.csv", c.config.OrchestratorURL, dictName)
+	name := c.config.TableName(dictName)
 	sourceParams := []string{
 		fmt.Sprintf("URL %s", quoteString(url)),
 		"FORMAT 'CSVWithNames'",
@@ -168,7 +276,7 @@ LAYOUT({{ .Layout }}())
 	}
 	c.r.Info().Msgf("create dictionary %s", name)
 	createOrReplaceQuery := strings.Replace(createQuery, "CREATE ", "CREATE OR REPLACE ", 1)
-	if err := c.d.ClickHouse.ExecOnCluster(ctx, createOrReplaceQuery); err != nil {
+	if err := c.execMigration(ctx, createOrReplaceQuery); err != nil {
 		return fmt.Errorf("cannot create dictionary %s: %w", name, err)
 	}
 	return nil
@@ -190,7 +298,7 @@ func (c *Component) createExportersTable(ctx context.Context) error {
 	}
 
 	// Build CREATE TABLE
-	name := "exporters"
+	name := c.config.TableName("exporters")
 	createQuery, err := stemplate(
 		`CREATE TABLE {{ .Database }}.{{ .Table }}
 ({{ .Schema }})
@@ -221,7 +329,7 @@ TTL TimeReceived + toIntervalDay(1)`,
 		"allow_suspicious_low_cardinality_types": 1,
 	}))
 	createOrReplaceQuery := strings.Replace(createQuery, "CREATE ", "CREATE OR REPLACE ", 1)
-	if err := c.d.ClickHouse.ExecOnCluster(ctx, createOrReplaceQuery); err != nil {
+	if err := c.execMigration(ctx, createOrReplaceQuery); err != nil {
 		return fmt.Errorf("cannot create exporters table: %w", err)
 	}
 
@@ -247,7 +355,7 @@ func (c *Component) createExportersConsumerView(ctx context.Context) error {
 	selectQuery, err := stemplate(
 		`SELECT DISTINCT {{ .Columns }} FROM {{ .Database }}.{{ .Table }} ARRAY JOIN arrayEnumerate([1, 2]) AS num`,
 		gin.H{
-			"Table":    c.distributedTable("flows"),
+			"Table":    c.distributedTable(c.config.TableName("flows")),
 			"Database": c.config.Database,
 			"Columns":  strings.Join(cols, ", "),
 		})
@@ -256,9 +364,8 @@ func (c *Component) createExportersConsumerView(ctx context.Context) error {
 	}
 
 	// Check if the table already exists with these columns and with a TTL.
-	if ok, err := c.tableAlreadyExists(ctx,
-		"exporters_consumer", "as_select",
-		selectQuery); err != nil {
+	viewName := c.config.TableName("exporters_consumer")
+	if ok, err := c.tableAlreadyExists(ctx, viewName, "as_select", selectQuery); err != nil {
 		return err
 	} else if ok {
 		c.r.Info().Msg("exporters view already exists, skip migration")
@@ -267,27 +374,57 @@ func (c *Component) createExportersConsumerView(ctx context.Context) error {
 
 	// Drop existing table and recreate
 	c.r.Info().Msg("create exporters view")
-	if err := c.d.ClickHouse.ExecOnCluster(ctx, `DROP TABLE IF EXISTS exporters_consumer SYNC`); err != nil {
+	if err := c.execMigration(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s SYNC`, viewName)); err != nil {
 		return fmt.Errorf("cannot drop existing exporters view: %w", err)
 	}
-	if err := c.d.ClickHouse.ExecOnCluster(ctx, fmt.Sprintf(`
-CREATE MATERIALIZED VIEW exporters_consumer TO %s AS %s
-`, "exporters", selectQuery)); err != nil {
+	if err := c.execMigration(ctx, fmt.Sprintf(`
+CREATE MATERIALIZED VIEW %s TO %s AS %s
+`, viewName, c.config.TableName("exporters"), selectQuery)); err != nil {
 		return fmt.Errorf("cannot create exporters view: %w", err)
 	}
 
 	return nil
 }
 
-// createRawFlowsTable creates the raw flow table
-func (c *Component) createRawFlowsTable(ctx context.Context) error {
+// rawFlowsTables returns the names of the raw flows tables. The first one is
+// also the one used when the Kafka engine is disabled.
+func (c *Component) rawFlowsTables() []string {
+	first := c.config.TableName(fmt.Sprintf("flows_%s_raw", c.d.Schema.ProtobufMessageHash()))
+	tables := []string{first}
+	if c.config.Kafka.Disable {
+		return tables
+	}
+	for i := 2; i <= c.config.Kafka.ConsumerTables; i++ {
+		tables = append(tables, fmt.Sprintf("%s_%d", first, i))
+	}
+	return tables
+}
+
+// rawFlowsErrorsConsumerView returns the name of the view collecting errors
+// from the provided raw flows table.
+func (c *Component) rawFlowsErrorsConsumerView(tableName string) string {
+	prefix := c.config.TableName(fmt.Sprintf("flows_%s_raw", c.d.Schema.ProtobufMessageHash()))
+	return fmt.Sprintf("%s%s", c.config.TableName("flows_raw_errors_consumer"),
+		strings.TrimPrefix(tableName, prefix))
+}
+
+// rawFlowsEngine returns the engine for the raw flows tables.
+func (c *Component) rawFlowsEngine() string {
+	if c.config.Kafka.Disable {
+		// Flows are inserted directly by the inlet. The table only
+		// triggers the consumer view.
+		return "Null"
+	}
 	hash := c.d.Schema.ProtobufMessageHash()
-	tableName := fmt.Sprintf("flows_%s_raw", hash)
+	topics := []string{c.config.Kafka.TopicName("", hash)}
+	for _, suffix := range c.config.Kafka.TopicSuffixes() {
+		topics = append(topics, c.config.Kafka.TopicName(suffix, hash))
+	}
 	kafkaSettings := []string{
 		fmt.Sprintf(`kafka_broker_list = %s`,
 			quoteString(strings.Join(c.config.Kafka.Brokers, ","))),
 		fmt.Sprintf(`kafka_topic_list = %s`,
-			quoteString(fmt.Sprintf("%s-%s", c.config.Kafka.Topic, hash))),
+			quoteString(strings.Join(topics, ","))),
 		fmt.Sprintf(`kafka_group_name = %s`, quoteString(c.config.Kafka.GroupName)),
 		`kafka_format = 'Protobuf'`,
 		fmt.Sprintf(`kafka_schema = 'flow-%s.proto:FlowMessagev%s'`, hash, hash),
@@ -295,11 +432,22 @@ func (c *Component) createRawFlowsTable(ctx context.Context) error {
 		`kafka_thread_per_consumer = 1`,
 		`kafka_handle_error_mode = 'stream'`,
 	}
+	if c.config.Kafka.MaxBlockSize > 0 {
+		kafkaSettings = append(kafkaSettings,
+			fmt.Sprintf(`kafka_max_block_size = %d`, c.config.Kafka.MaxBlockSize))
+	}
+	if c.config.Kafka.PollMaxBatchSize > 0 {
+		kafkaSettings = append(kafkaSettings,
+			fmt.Sprintf(`kafka_poll_max_batch_size = %d`, c.config.Kafka.PollMaxBatchSize))
+	}
 	for _, setting := range c.config.Kafka.EngineSettings {
 		kafkaSettings = append(kafkaSettings, setting)
 	}
-	kafkaEngine := fmt.Sprintf("Kafka SETTINGS %s", strings.Join(kafkaSettings, ", "))
+	return fmt.Sprintf("Kafka SETTINGS %s", strings.Join(kafkaSettings, ", "))
+}
 
+// createRawFlowsTable creates the provided raw flow table
+func (c *Component) createRawFlowsTable(ctx context.Context, tableName string) error {
 	// Build CREATE query
 	createQuery, err := stemplate(
 		`CREATE TABLE {{ .Database }}.{{ .Table }} ({{ .Schema }}) ENGINE = {{ .Engine }}`,
@@ -310,7 +458,7 @@ func (c *Component) createRawFlowsTable(ctx context.Context) error {
 				schema.ClickHouseSkipGeneratedColumns,
 				schema.ClickHouseUseTransformFromType,
 				schema.ClickHouseSkipAliasedColumns),
-			"Engine": kafkaEngine,
+			"Engine": c.rawFlowsEngine(),
 		})
 	if err != nil {
 		return fmt.Errorf("cannot build query to create raw flows table: %w", err)
@@ -320,25 +468,25 @@ func (c *Component) createRawFlowsTable(ctx context.Context) error {
 	if ok, err := c.tableAlreadyExists(ctx, tableName, "create_table_query", createQuery); err != nil {
 		return err
 	} else if ok {
-		c.r.Info().Msg("raw flows table already exists, skip migration")
+		c.r.Info().Msgf("raw flows table %s already exists, skip migration", tableName)
 		return errSkipStep
 	}
 
 	// Drop table if it exists as well as all the dependents and recreate the raw table
-	c.r.Info().Msg("create raw flows table")
+	c.r.Info().Msgf("create raw flows table %s", tableName)
 	for _, table := range []string{
 		fmt.Sprintf("%s_consumer", tableName),
 		fmt.Sprintf("%s_errors", tableName),
 		tableName,
 	} {
-		if err := c.d.ClickHouse.ExecOnCluster(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s SYNC`, table)); err != nil {
+		if err := c.execMigration(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s SYNC`, table)); err != nil {
 			return fmt.Errorf("cannot drop %s: %w", table, err)
 		}
 	}
 	ctx = clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{
 		"allow_suspicious_low_cardinality_types": 1,
 	}))
-	if err := c.d.ClickHouse.ExecOnCluster(ctx, createQuery); err != nil {
+	if err := c.execMigration(ctx, createQuery); err != nil {
 		return fmt.Errorf("cannot create raw flows table: %w", err)
 	}
 
@@ -347,8 +495,7 @@ func (c *Component) createRawFlowsTable(ctx context.Context) error {
 
 var dictionaryNetworksLookupRegex = regexp.MustCompile(`\bc_(Src|Dst)Networks\[([[:lower:]]+)\]\B`)
 
-func (c *Component) createRawFlowsConsumerView(ctx context.Context) error {
-	tableName := fmt.Sprintf("flows_%s_raw", c.d.Schema.ProtobufMessageHash())
+func (c *Component) createRawFlowsConsumerView(ctx context.Context, tableName string) error {
 	viewName := fmt.Sprintf("%s_consumer", tableName)
 
 	// Build SELECT query
@@ -360,9 +507,12 @@ func (c *Component) createRawFlowsConsumerView(ctx context.Context) error {
 		"Database": c.config.Database,
 		"Table":    tableName,
 	}
-	selectQuery, err := stemplate(
-		`SELECT {{ .Columns }} FROM {{ .Database }}.{{ .Table }} WHERE length(_error) = 0`,
-		args)
+	query := `SELECT {{ .Columns }} FROM {{ .Database }}.{{ .Table }} WHERE length(_error) = 0`
+	if c.config.Kafka.Disable {
+		// Without the Kafka engine, there is no error column.
+		query = `SELECT {{ .Columns }} FROM {{ .Database }}.{{ .Table }}`
+	}
+	selectQuery, err := stemplate(query, args)
 	if err != nil {
 		return fmt.Errorf("cannot build select statement for raw flows consumer view: %w", err)
 	}
@@ -422,23 +572,24 @@ func (c *Component) createRawFlowsConsumerView(ctx context.Context) error {
 	if len(with) > 0 {
 		selectQuery = fmt.Sprintf("WITH %s %s", strings.Join(with, ", "), selectQuery)
 	}
+	selectQuery = c.config.PrefixDictionaries(selectQuery)
 
 	// Check the existing one
 	if ok, err := c.tableAlreadyExists(ctx, viewName, "as_select", selectQuery); err != nil {
 		return err
 	} else if ok {
-		c.r.Info().Msg("raw flows consumer view already exists, skip migration")
+		c.r.Info().Msgf("raw flows consumer view %s already exists, skip migration", viewName)
 		return errSkipStep
 	}
 
 	// Drop and create
-	c.r.Info().Msg("create raw flows consumer view")
-	if err := c.d.ClickHouse.ExecOnCluster(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s SYNC`, viewName)); err != nil {
+	c.r.Info().Msgf("create raw flows consumer view %s", viewName)
+	if err := c.execMigration(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s SYNC`, viewName)); err != nil {
 		return fmt.Errorf("cannot drop table %s: %w", viewName, err)
 	}
-	if err := c.d.ClickHouse.ExecOnCluster(ctx,
+	if err := c.execMigration(ctx,
 		fmt.Sprintf("CREATE MATERIALIZED VIEW %s TO %s AS %s",
-			viewName, c.distributedTable("flows"), selectQuery)); err != nil {
+			viewName, c.distributedTable(c.config.TableName("flows")), selectQuery)); err != nil {
 		return fmt.Errorf("cannot create raw flows consumer view: %w", err)
 	}
 
@@ -446,7 +597,7 @@ func (c *Component) createRawFlowsConsumerView(ctx context.Context) error {
 }
 
 func (c *Component) createRawFlowsErrors(ctx context.Context) error {
-	name := c.localTable("flows_raw_errors")
+	name := c.localTable(c.config.TableName("flows_raw_errors"))
 	createQuery, err := stemplate(`CREATE TABLE {{ .Database }}.{{ .Table }}
 (`+"`timestamp`"+` DateTime,
  `+"`topic`"+` LowCardinality(String),
@@ -474,15 +625,29 @@ TTL timestamp + toIntervalDay(1)
 	}
 	c.r.Info().Msgf("create table %s", name)
 	createOrReplaceQuery := strings.Replace(createQuery, "CREATE ", "CREATE OR REPLACE ", 1)
-	if err := c.d.ClickHouse.ExecOnCluster(ctx, createOrReplaceQuery); err != nil {
+	if err := c.execMigration(ctx, createOrReplaceQuery); err != nil {
 		return fmt.Errorf("cannot create table %s: %w", name, err)
 	}
 	return nil
 }
 
-func (c *Component) createRawFlowsErrorsConsumerView(ctx context.Context) error {
-	source := fmt.Sprintf("flows_%s_raw", c.d.Schema.ProtobufMessageHash())
-	viewName := "flows_raw_errors_consumer"
+func (c *Component) createRawFlowsErrorsConsumerView(ctx context.Context, source string) error {
+	viewName := c.rawFlowsErrorsConsumerView(source)
+
+	if c.config.Kafka.Disable {
+		// Without the Kafka engine, there is no error to collect.
+		if ok, err := c.tableAlreadyExists(ctx, viewName, "name", viewName); err != nil {
+			return err
+		} else if !ok {
+			c.r.Debug().Msg("raw flows errors view does not exist, skip migration")
+			return errSkipStep
+		}
+		c.r.Info().Msg("delete raw flows errors view")
+		if err := c.execMigration(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s SYNC`, viewName)); err != nil {
+			return fmt.Errorf("cannot drop table %s: %w", viewName, err)
+		}
+		return nil
+	}
 
 	// Build SELECT query
 	selectQuery, err := stemplate(`
@@ -506,18 +671,18 @@ WHERE length(_error) > 0`, gin.H{
 	if ok, err := c.tableAlreadyExists(ctx, viewName, "as_select", selectQuery); err != nil {
 		return err
 	} else if ok {
-		c.r.Info().Msg("raw flows errors view already exists, skip migration")
+		c.r.Info().Msgf("raw flows errors view %s already exists, skip migration", viewName)
 		return errSkipStep
 	}
 
 	// Drop and create
-	c.r.Info().Msg("create raw flows errors view")
-	if err := c.d.ClickHouse.ExecOnCluster(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s SYNC`, viewName)); err != nil {
+	c.r.Info().Msgf("create raw flows errors view %s", viewName)
+	if err := c.execMigration(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s SYNC`, viewName)); err != nil {
 		return fmt.Errorf("cannot drop table %s: %w", viewName, err)
 	}
-	if err := c.d.ClickHouse.ExecOnCluster(ctx,
+	if err := c.execMigration(ctx,
 		fmt.Sprintf(`CREATE MATERIALIZED VIEW %s TO %s AS %s`,
-			viewName, c.distributedTable("flows_raw_errors"), selectQuery)); err != nil {
+			viewName, c.distributedTable(c.config.TableName("flows_raw_errors")), selectQuery)); err != nil {
 		return fmt.Errorf("cannot create raw flows errors view: %w", err)
 	}
 
@@ -525,7 +690,7 @@ WHERE length(_error) > 0`, gin.H{
 }
 
 func (c *Component) deleteOldRawFlowsErrorsView(ctx context.Context) error {
-	tableName := fmt.Sprintf("flows_%s_raw", c.d.Schema.ProtobufMessageHash())
+	tableName := c.config.TableName(fmt.Sprintf("flows_%s_raw", c.d.Schema.ProtobufMessageHash()))
 	viewName := fmt.Sprintf("%s_errors", tableName)
 
 	// Check the existing one
@@ -538,73 +703,227 @@ func (c *Component) deleteOldRawFlowsErrorsView(ctx context.Context) error {
 
 	// Drop
 	c.r.Info().Msg("delete old raw flows errors view")
-	if err := c.d.ClickHouse.ExecOnCluster(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s SYNC`, viewName)); err != nil {
+	if err := c.execMigration(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s SYNC`, viewName)); err != nil {
 		return fmt.Errorf("cannot drop table %s: %w", viewName, err)
 	}
 	return nil
 }
 
+// dropRemovedRawFlowsTables drops the additional raw flows tables, and their
+// consumers, when they are not configured anymore.
+func (c *Component) dropRemovedRawFlowsTables(ctx context.Context) error {
+	var existingTables []struct {
+		Name string `ch:"name"`
+	}
+	if err := c.d.ClickHouse.Select(ctx, &existingTables, fmt.Sprintf(`
+SELECT name
+FROM system.tables
+WHERE database = $1
+AND (name LIKE '%[1]sflows_%%\\_raw\\_%%' OR name LIKE '%[1]sflows_raw_errors_consumer_%%')
+`, clickhousedb.EscapeLike(c.config.TablePrefix)), c.config.Database); err != nil {
+		return fmt.Errorf("cannot query tables: %w", err)
+	}
+	configured := map[string]bool{}
+	for _, table := range c.rawFlowsTables() {
+		configured[table] = true
+	}
+	rawPrefix := c.config.TableName(fmt.Sprintf("flows_%s_raw_", c.d.Schema.ProtobufMessageHash()))
+	errorsPrefix := c.config.TableName("flows_raw_errors_consumer_")
+	// Drop consumers first, then tables.
+	var views, tables []string
+	for _, table := range existingTables {
+		var name string
+		switch {
+		case strings.HasPrefix(table.Name, rawPrefix):
+			name = strings.TrimPrefix(table.Name, rawPrefix)
+		case strings.HasPrefix(table.Name, errorsPrefix):
+			name = strings.TrimPrefix(table.Name, errorsPrefix)
+		default:
+			continue
+		}
+		index, err := strconv.Atoi(strings.TrimSuffix(name, "_consumer"))
+		if err != nil || index < 2 || configured[fmt.Sprintf("%s%d", rawPrefix, index)] {
+			continue
+		}
+		if strings.HasPrefix(table.Name, rawPrefix) && !strings.HasSuffix(name, "_consumer") {
+			tables = append(tables, table.Name)
+		} else {
+			views = append(views, table.Name)
+		}
+	}
+	toDrop := append(views, tables...)
+	if len(toDrop) == 0 {
+		return errSkipStep
+	}
+	for _, name := range toDrop {
+		c.r.Warn().Msgf("drop %s as it is not configured anymore", name)
+		if err := c.execMigration(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s SYNC`, name)); err != nil {
+			return fmt.Errorf("cannot drop %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// checkResolutionDimensions checks the dimensions to keep for a resolution.
+func (c *Component) checkResolutionDimensions(resolution ResolutionConfiguration) error {
+	if len(resolution.Dimensions) == 0 {
+		return nil
+	}
+	if resolution.Interval == 0 {
+		return errors.New("dimensions cannot be selected for the resolution with interval: 0")
+	}
+	for _, key := range resolution.Dimensions {
+		column, ok := c.d.Schema.LookupColumnByKey(key)
+		if !ok || column.Disabled {
+			return fmt.Errorf("resolution %s: column %s is not enabled", resolution.Interval, key)
+		}
+		if column.ConsoleNotDimension || column.ClickHouseMainOnly {
+			return fmt.Errorf("resolution %s: column %s is not a dimension of consolidated tables",
+				resolution.Interval, key)
+		}
+	}
+	return nil
+}
+
+// keepColumn tells if a column is present in the flows table for the provided
+// resolution. Consolidated tables do not contain the columns for the main
+// table only and, if dimensions are provided, only keep these dimensions,
+// the primary keys, and the columns which are not dimensions.
+func (c *Component) keepColumn(resolution ResolutionConfiguration, column schema.Column) bool {
+	if resolution.Interval == 0 {
+		return true
+	}
+	if column.ClickHouseMainOnly {
+		return false
+	}
+	if len(resolution.Dimensions) == 0 || column.ConsoleNotDimension {
+		return true
+	}
+	if slices.Contains(c.d.Schema.ClickHousePrimaryKeys(), column.Name) {
+		return true
+	}
+	return slices.Contains(resolution.Dimensions, column.Key)
+}
+
+// flowsTableColumns returns the columns of the flows table for the provided
+// resolution, using the provided options.
+func (c *Component) flowsTableColumns(resolution ResolutionConfiguration, options ...schema.ClickHouseTableOption) []schema.Column {
+	columns := []schema.Column{}
+	for _, column := range c.d.Schema.ClickHouseColumns(options...) {
+		if c.keepColumn(resolution, column) {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// flowsTableSortingKeys returns the sorting keys of a consolidated flows
+// table.
+func (c *Component) flowsTableSortingKeys(resolution ResolutionConfiguration) []string {
+	keys := []string{}
+	for _, key := range c.d.Schema.ClickHouseSortingKeys() {
+		if column, ok := c.d.Schema.LookupColumnByName(key); ok && c.keepColumn(resolution, *column) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// columnsDefinition returns the definition of the provided columns for a CREATE
+// TABLE statement.
+func columnsDefinition(columns []schema.Column) string {
+	lines := []string{}
+	for _, column := range columns {
+		lines = append(lines, column.ClickHouseDefinition())
+	}
+	return strings.Join(lines, ",\n")
+}
+
+// columnNames returns the names of the provided columns.
+func columnNames(columns []schema.Column) []string {
+	names := []string{}
+	for _, column := range columns {
+		names = append(names, column.Name)
+	}
+	return names
+}
+
+// ttlClause returns the TTL clause for a flows table. Data is moved to the
+// configured volumes before being deleted.
+func ttlClause(resolution ResolutionConfiguration) string {
+	expressions := []string{}
+	for _, move := range resolution.Moves {
+		expressions = append(expressions,
+			fmt.Sprintf("TimeReceived + toIntervalSecond(%d) TO VOLUME %s",
+				uint64(move.After.Seconds()), quoteString(move.Volume)))
+	}
+	expressions = append(expressions,
+		fmt.Sprintf("TimeReceived + toIntervalSecond(%d)", uint64(resolution.TTL.Seconds())))
+	return fmt.Sprintf("TTL %s", strings.Join(expressions, ", "))
+}
+
 func (c *Component) createOrUpdateFlowsTable(ctx context.Context, resolution ResolutionConfiguration) error {
 	ctx = clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{
 		"allow_suspicious_low_cardinality_types": 1,
 	}))
-	var tableName string
-	if resolution.Interval == 0 {
-		tableName = "flows"
-	} else {
-		tableName = fmt.Sprintf("flows_%s", resolution.Interval)
-	}
-	tableName = c.localTable(tableName)
+	tableName := c.flowsTableName(resolution)
 	partitionInterval := uint64((resolution.TTL / time.Duration(c.config.MaxPartitions)).Seconds())
-	ttl := uint64(resolution.TTL.Seconds())
+	ttl := ttlClause(resolution)
 	settings := `index_granularity = 8192, ttl_only_drop_parts = 1`
+	createSettings := settings
+	if resolution.StoragePolicy != "" {
+		createSettings = fmt.Sprintf("%s, storage_policy = %s", settings, quoteString(resolution.StoragePolicy))
+	}
 
-	// Create table if it does not exist
-	if ok, err := c.tableAlreadyExists(ctx, tableName, "name", tableName); err != nil {
-		return err
-	} else if !ok {
-		var createQuery string
-		var err error
-		if resolution.Interval == 0 {
-			createQuery, err = stemplate(`
-CREATE TABLE {{ .Table }} ({{ .Schema }})
+	// Build the statement to create the table
+	var createQuery string
+	var err error
+	if resolution.Interval == 0 {
+		createQuery, err = stemplate(`
+CREATE TABLE IF NOT EXISTS {{ .Table }} ({{ .Schema }})
 ENGINE = {{ .Engine }}
 PARTITION BY toYYYYMMDDhhmmss(toStartOfInterval(TimeReceived, INTERVAL {{ .PartitionInterval }} second))
 ORDER BY (toStartOfFiveMinutes(TimeReceived), ExporterAddress, InIfName, OutIfName)
-TTL TimeReceived + toIntervalSecond({{ .TTL }})
+{{ .TTL }}
 SETTINGS {{ .Settings }}
 `, gin.H{
-				"Table":             tableName,
-				"Schema":            c.d.Schema.ClickHouseCreateTable(),
-				"PartitionInterval": partitionInterval,
-				"TTL":               ttl,
-				"Engine":            c.mergeTreeEngine(tableName, ""),
-				"Settings":          settings,
-			})
-		} else {
-			createQuery, err = stemplate(`
-CREATE TABLE {{ .Table }} ({{ .Schema }})
+			"Table":             tableName,
+			"Schema":            c.d.Schema.ClickHouseCreateTable(),
+			"PartitionInterval": partitionInterval,
+			"TTL":               ttl,
+			"Engine":            c.mergeTreeEngine(tableName, ""),
+			"Settings":          createSettings,
+		})
+	} else {
+		createQuery, err = stemplate(`
+CREATE TABLE IF NOT EXISTS {{ .Table }} ({{ .Schema }})
 ENGINE = {{ .Engine }}
 PARTITION BY toYYYYMMDDhhmmss(toStartOfInterval(TimeReceived, INTERVAL {{ .PartitionInterval }} second))
 PRIMARY KEY ({{ .PrimaryKey }})
 ORDER BY ({{ .SortingKey }})
-TTL TimeReceived + toIntervalSecond({{ .TTL }})
+{{ .TTL }}
 SETTINGS {{ .Settings }}
 `, gin.H{
-				"Table":             tableName,
-				"Schema":            c.d.Schema.ClickHouseCreateTable(schema.ClickHouseSkipMainOnlyColumns),
-				"PartitionInterval": partitionInterval,
-				"PrimaryKey":        strings.Join(c.d.Schema.ClickHousePrimaryKeys(), ", "),
-				"SortingKey":        strings.Join(c.d.Schema.ClickHouseSortingKeys(), ", "),
-				"TTL":               ttl,
-				"Engine":            c.mergeTreeEngine(tableName, "Summing", "(Bytes, Packets)"),
-				"Settings":          settings,
-			})
-		}
-		if err != nil {
-			return fmt.Errorf("cannot build create table statement for %s: %w", tableName, err)
-		}
-		if err := c.d.ClickHouse.ExecOnCluster(ctx, createQuery); err != nil {
+			"Table":             tableName,
+			"Schema":            columnsDefinition(c.flowsTableColumns(resolution, schema.ClickHouseSkipMainOnlyColumns)),
+			"PartitionInterval": partitionInterval,
+			"PrimaryKey":        strings.Join(c.d.Schema.ClickHousePrimaryKeys(), ", "),
+			"SortingKey":        strings.Join(c.flowsTableSortingKeys(resolution), ", "),
+			"TTL":               ttl,
+			"Engine":            c.mergeTreeEngine(tableName, "Summing", "(Bytes, Packets)"),
+			"Settings":          createSettings,
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("cannot build create table statement for %s: %w", tableName, err)
+	}
+	createQuery = c.config.PrefixDictionaries(createQuery)
+
+	// Create table if it does not exist
+	if ok, err := c.tableAlreadyExists(ctx, tableName, "name", tableName); err != nil {
+		return err
+	} else if !ok {
+		if err := c.execMigration(ctx, createQuery); err != nil {
 			return fmt.Errorf("cannot create %s: %w", tableName, err)
 		}
 		return nil
@@ -629,13 +948,35 @@ ORDER BY position ASC
 		return fmt.Errorf("cannot query columns table: %w", err)
 	}
 
+	// Removing a dimension from a consolidated table is not possible as it
+	// is part of the sorting key. The table is recreated.
+	for _, existingColumn := range existingColumns {
+		column, ok := c.d.Schema.LookupColumnByName(existingColumn.Name)
+		if !ok || column.Disabled || c.keepColumn(resolution, *column) {
+			continue
+		}
+		viewName := c.config.TableName(fmt.Sprintf("flows_%s_consumer", resolution.Interval))
+		c.r.Warn().Msgf("dimension %s removed from %s, recreate the table and lose its data",
+			existingColumn.Name, tableName)
+		if err := c.execMigration(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s SYNC`, viewName)); err != nil {
+			return fmt.Errorf("cannot drop %s: %w", viewName, err)
+		}
+		if err := c.execMigration(ctx, fmt.Sprintf(`DROP TABLE %s SYNC`, tableName)); err != nil {
+			return fmt.Errorf("cannot drop %s: %w", tableName, err)
+		}
+		if err := c.execMigration(ctx, createQuery); err != nil {
+			return fmt.Errorf("cannot create %s: %w", tableName, err)
+		}
+		return nil
+	}
+
 	// Plan for modifications. We don't check everything: we assume the
 	// modifications to be done are covered by the unit tests.
 	modifications := []string{}
 	previousColumn := ""
 outer:
 	for _, wantedColumn := range c.d.Schema.Columns() {
-		if resolution.Interval > 0 && wantedColumn.ClickHouseMainOnly {
+		if !c.keepColumn(resolution, wantedColumn) {
 			continue
 		}
 		// Check if the column already exists
@@ -649,25 +990,30 @@ outer:
 							tableName, wantedColumn.Name, existingColumn.Type, wantedColumn.ClickHouseType)
 					}
 				}
+				removeCodec := false
 				if wantedColumn.ClickHouseCodec != "" {
 					wantedCodec := fmt.Sprintf("CODEC(%s)", wantedColumn.ClickHouseCodec)
 					if wantedCodec != existingColumn.CompressionCodec {
 						modifyTypeOrCodec = true
 					}
+				} else if existingColumn.CompressionCodec != "" {
+					// The codec was set through the configuration and is
+					// not anymore.
+					removeCodec = true
 				}
 				// change alias existence has changed. ALIAS expression changes are not yet checked here.
 				if (wantedColumn.ClickHouseAlias != "") != (existingColumn.DefaultKind == "ALIAS") {
 					// either the column was an alias and should be none, or the other way around. Either way, we need to recreate.
 					c.r.Debug().Msg(fmt.Sprintf("column %s alias content has changed, recreating. New ALIAS: %s", existingColumn.Name, wantedColumn.ClickHouseAlias))
-					err := c.d.ClickHouse.ExecOnCluster(ctx,
-						fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", tableName, existingColumn.Name))
+					err := c.execMigration(ctx,
+						fmt.Sprintf("ALTER TABLE %s DROP COLUMN IF EXISTS %s", tableName, existingColumn.Name))
 					if err != nil {
 						return fmt.Errorf("cannot drop %s from %s to cleanup aliasing: %w",
 							existingColumn.Name, tableName, err)
 					}
 					// Schedule adding it back
 					modifications = append(modifications,
-						fmt.Sprintf("ADD COLUMN %s AFTER %s", wantedColumn.ClickHouseDefinition(), previousColumn))
+						fmt.Sprintf("ADD COLUMN IF NOT EXISTS %s AFTER %s", wantedColumn.ClickHouseDefinition(), previousColumn))
 				}
 
 				if resolution.Interval > 0 && slices.Contains(c.d.Schema.ClickHousePrimaryKeys(), wantedColumn.Name) && existingColumn.IsPrimaryKey == 0 {
@@ -676,18 +1022,21 @@ outer:
 				}
 				if resolution.Interval > 0 && !wantedColumn.ClickHouseNotSortingKey && existingColumn.IsSortingKey == 0 {
 					// That's something we can fix, but we need to drop it before recreating it
-					err := c.d.ClickHouse.ExecOnCluster(ctx,
-						fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", tableName, existingColumn.Name))
+					err := c.execMigration(ctx,
+						fmt.Sprintf("ALTER TABLE %s DROP COLUMN IF EXISTS %s", tableName, existingColumn.Name))
 					if err != nil {
 						return fmt.Errorf("cannot drop %s from %s to fix ordering: %w",
 							existingColumn.Name, tableName, err)
 					}
 					// Schedule adding it back
 					modifications = append(modifications,
-						fmt.Sprintf("ADD COLUMN %s AFTER %s", wantedColumn.ClickHouseDefinition(), previousColumn))
+						fmt.Sprintf("ADD COLUMN IF NOT EXISTS %s AFTER %s", wantedColumn.ClickHouseDefinition(), previousColumn))
 				} else if modifyTypeOrCodec {
 					modifications = append(modifications,
 						fmt.Sprintf("MODIFY COLUMN %s", wantedColumn.ClickHouseDefinition()))
+				} else if removeCodec {
+					modifications = append(modifications,
+						fmt.Sprintf("MODIFY COLUMN %s REMOVE CODEC", wantedColumn.Name))
 				}
 				previousColumn = wantedColumn.Name
 				continue outer
@@ -700,7 +1049,7 @@ outer:
 		}
 		c.r.Debug().Msgf("add missing column %s to %s", wantedColumn.Name, tableName)
 		modifications = append(modifications,
-			fmt.Sprintf("ADD COLUMN %s AFTER %s", wantedColumn.ClickHouseDefinition(), previousColumn))
+			fmt.Sprintf("ADD COLUMN IF NOT EXISTS %s AFTER %s", wantedColumn.ClickHouseDefinition(), previousColumn))
 		previousColumn = wantedColumn.Name
 	}
 	modified := false
@@ -708,17 +1057,18 @@ outer:
 		// Also update ORDER BY
 		if resolution.Interval > 0 {
 			modifications = append(modifications,
-				fmt.Sprintf("MODIFY ORDER BY (%s)", strings.Join(c.d.Schema.ClickHouseSortingKeys(), ", ")))
+				fmt.Sprintf("MODIFY ORDER BY (%s)", strings.Join(c.flowsTableSortingKeys(resolution), ", ")))
 		}
 		c.r.Info().Msgf("apply %d modifications to %s", len(modifications), tableName)
 		if resolution.Interval > 0 {
 			// Drop the view
 			viewName := fmt.Sprintf("%s_consumer", tableName)
-			if err := c.d.ClickHouse.ExecOnCluster(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s SYNC`, viewName)); err != nil {
+			if err := c.execMigration(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s SYNC`, viewName)); err != nil {
 				return fmt.Errorf("cannot drop %s: %w", viewName, err)
 			}
 		}
-		err := c.d.ClickHouse.ExecOnCluster(ctx, fmt.Sprintf("ALTER TABLE %s %s", tableName, strings.Join(modifications, ", ")))
+		err := c.execMigration(ctx, c.config.PrefixDictionaries(
+			fmt.Sprintf("ALTER TABLE %s %s", tableName, strings.Join(modifications, ", "))))
 		if err != nil {
 			return fmt.Errorf("cannot update table %s: %w", tableName, err)
 		}
@@ -726,26 +1076,43 @@ outer:
 	}
 
 	// Check if we need to update the settings
-	settingsClauseLike := fmt.Sprintf("CAST(engine_full LIKE '%% SETTINGS %s', 'String')", settings)
+	settingsClauseLike := fmt.Sprintf("CAST(engine_full LIKE '%% SETTINGS %%%s%%', 'String')", settings)
 	if ok, err := c.tableAlreadyExists(ctx, tableName, settingsClauseLike, "1"); err != nil {
 		return err
 	} else if !ok {
 		c.r.Info().Msgf("updating settings of %s to %s", tableName, resolution.Interval)
-		if err := c.d.ClickHouse.ExecOnCluster(ctx, fmt.Sprintf("ALTER TABLE %s MODIFY SETTING %s", tableName, settings)); err != nil {
+		if err := c.execMigration(ctx, fmt.Sprintf("ALTER TABLE %s MODIFY SETTING %s", tableName, settings)); err != nil {
 			return fmt.Errorf("cannot modify settings for table %s: %w", tableName, err)
 		}
 		modified = true
 	}
 
+	// Check if we need to update the storage policy. This needs to be done
+	// before updating the TTL as it may reference volumes of the new
+	// policy. ClickHouse only accepts a new policy if it contains all the
+	// volumes of the current one.
+	if resolution.StoragePolicy != "" {
+		if ok, err := c.tableAlreadyExists(ctx, tableName, "storage_policy", resolution.StoragePolicy); err != nil {
+			return err
+		} else if !ok {
+			c.r.Info().Msgf("updating storage policy of %s to %s", tableName, resolution.StoragePolicy)
+			if err := c.execMigration(ctx,
+				fmt.Sprintf("ALTER TABLE %s MODIFY SETTING storage_policy = %s",
+					tableName, quoteString(resolution.StoragePolicy))); err != nil {
+				return fmt.Errorf("cannot modify storage policy for table %s: %w", tableName, err)
+			}
+			modified = true
+		}
+	}
+
 	// Check if we need to update the TTL
-	ttlClause := fmt.Sprintf("TTL TimeReceived + toIntervalSecond(%d)", ttl)
-	ttlClauseLike := fmt.Sprintf("CAST(engine_full LIKE '%% %s %%', 'String')", ttlClause)
+	ttlClauseLike := fmt.Sprintf("CAST(engine_full LIKE %s, 'String')", quoteString(fmt.Sprintf("%% %s %%", ttl)))
 	if ok, err := c.tableAlreadyExists(ctx, tableName, ttlClauseLike, "1"); err != nil {
 		return err
 	} else if !ok {
 		c.r.Warn().
 			Msgf("updating TTL of %s with interval %s, this can take a long time", tableName, resolution.Interval)
-		if err := c.d.ClickHouse.ExecOnCluster(ctx, fmt.Sprintf("ALTER TABLE %s MODIFY %s", tableName, ttlClause)); err != nil {
+		if err := c.execMigration(ctx, fmt.Sprintf("ALTER TABLE %s MODIFY %s", tableName, ttl)); err != nil {
 			return fmt.Errorf("cannot modify TTL for table %s: %w", tableName, err)
 		}
 		modified = true
@@ -757,28 +1124,92 @@ outer:
 	return errSkipStep
 }
 
-func (c *Component) createFlowsConsumerView(ctx context.Context, resolution ResolutionConfiguration) error {
-	if resolution.Interval == 0 {
-		// The consumer for the main table is created elsewhere.
+// dropRemovedFlowsTables drops the consolidated flows tables, and their
+// consumers, for resolutions which are not configured anymore.
+func (c *Component) dropRemovedFlowsTables(ctx context.Context) error {
+	var existingTables []struct {
+		Name string `ch:"name"`
+	}
+	if err := c.d.ClickHouse.Select(ctx, &existingTables, fmt.Sprintf(`
+SELECT name
+FROM system.tables
+WHERE database = $1
+AND name LIKE '%sflows_%%'
+`, clickhousedb.EscapeLike(c.config.TablePrefix)), c.config.Database); err != nil {
+		return fmt.Errorf("cannot query tables: %w", err)
+	}
+	configured := map[time.Duration]bool{}
+	for _, resolution := range c.config.Resolutions {
+		configured[resolution.Interval] = true
+	}
+	// Drop consumers first, then distributed tables, then local tables.
+	var views, tables, localTables []string
+	for _, table := range existingTables {
+		name, ok := strings.CutPrefix(table.Name, c.config.TableName("flows_"))
+		if !ok {
+			continue
+		}
+		interval, err := time.ParseDuration(
+			strings.TrimSuffix(strings.TrimSuffix(name, "_consumer"), "_local"))
+		if err != nil || interval == 0 || configured[interval] {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(name, "_consumer"):
+			views = append(views, table.Name)
+		case strings.HasSuffix(name, "_local"):
+			localTables = append(localTables, table.Name)
+		default:
+			tables = append(tables, table.Name)
+		}
+	}
+	toDrop := append(append(views, tables...), localTables...)
+	if len(toDrop) == 0 {
 		return errSkipStep
 	}
-	tableName := fmt.Sprintf("flows_%s", resolution.Interval)
-	viewName := fmt.Sprintf("%s_consumer", tableName)
+	for _, name := range toDrop {
+		c.r.Warn().Msgf("drop %s as its resolution is not configured anymore", name)
+		if err := c.execMigration(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s SYNC`, name)); err != nil {
+			return fmt.Errorf("cannot drop %s: %w", name, err)
+		}
+	}
+	return nil
+}
 
-	// Build SELECT query
-	selectQuery, err := stemplate(`
+// consolidationColumns returns the columns copied from the main flows table
+// to the consolidated table of a resolution, except TimeReceived.
+func (c *Component) consolidationColumns(resolution ResolutionConfiguration) []string {
+	return columnNames(c.flowsTableColumns(resolution,
+		schema.ClickHouseSkipTimeReceived,
+		schema.ClickHouseSkipMainOnlyColumns,
+		schema.ClickHouseSkipAliasedColumns))
+}
+
+// consolidationSelect returns the SELECT query turning flows from the
+// provided main table into flows for the consolidated table of a resolution.
+func (c *Component) consolidationSelect(resolution ResolutionConfiguration, source string) (string, error) {
+	return stemplate(`
 SELECT
  toStartOfInterval(TimeReceived, toIntervalSecond({{ .Seconds }})) AS TimeReceived,
  {{ .Columns }}
 FROM {{ .Database }}.{{ .Table }}`, gin.H{
 		"Database": c.config.Database,
-		"Table":    c.localTable("flows"),
+		"Table":    source,
 		"Seconds":  uint64(resolution.Interval.Seconds()),
-		"Columns": strings.Join(c.d.Schema.ClickHouseSelectColumns(
-			schema.ClickHouseSkipTimeReceived,
-			schema.ClickHouseSkipMainOnlyColumns,
-			schema.ClickHouseSkipAliasedColumns), ",\n "),
+		"Columns":  strings.Join(c.consolidationColumns(resolution), ",\n "),
 	})
+}
+
+func (c *Component) createFlowsConsumerView(ctx context.Context, resolution ResolutionConfiguration) error {
+	if resolution.Interval == 0 {
+		// The consumer for the main table is created elsewhere.
+		return errSkipStep
+	}
+	tableName := c.config.TableName(fmt.Sprintf("flows_%s", resolution.Interval))
+	viewName := fmt.Sprintf("%s_consumer", tableName)
+
+	// Build SELECT query
+	selectQuery, err := c.consolidationSelect(resolution, c.localTable(c.config.TableName("flows")))
 	if err != nil {
 		return fmt.Errorf("cannot build select statement for consumer %s: %w", viewName, err)
 	}
@@ -793,10 +1224,10 @@ FROM {{ .Database }}.{{ .Table }}`, gin.H{
 
 	// Drop and create
 	c.r.Info().Msgf("create %s", viewName)
-	if err := c.d.ClickHouse.ExecOnCluster(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s SYNC`, viewName)); err != nil {
+	if err := c.execMigration(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s SYNC`, viewName)); err != nil {
 		return fmt.Errorf("cannot drop table %s: %w", viewName, err)
 	}
-	if err := c.d.ClickHouse.ExecOnCluster(ctx,
+	if err := c.execMigration(ctx,
 		fmt.Sprintf(`CREATE MATERIALIZED VIEW %s TO %s AS %s`, viewName,
 			c.localTable(tableName), selectQuery)); err != nil {
 		return fmt.Errorf("cannot create %s: %w", viewName, err)
@@ -869,7 +1300,7 @@ ENGINE = Distributed('{{ .Cluster }}', '{{ .Database}}', '{{ .Source }}', rand()
 	ctx = clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{
 		"allow_suspicious_low_cardinality_types": 1,
 	}))
-	if err := c.d.ClickHouse.ExecOnCluster(ctx, createOrReplaceQuery); err != nil {
+	if err := c.execMigration(ctx, createOrReplaceQuery); err != nil {
 		return fmt.Errorf("cannot create %s: %w", c.distributedTable(source), err)
 	}
 	return nil
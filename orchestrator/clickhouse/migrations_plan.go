@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: 2024 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package clickhouse
+
+import (
+	"context"
+	"strings"
+
+	"akvorado/common/clickhousedb"
+)
+
+type migrationPlanKey struct{}
+
+// migrationPlan records the statements a migration would execute.
+type migrationPlan struct {
+	statements []string
+}
+
+// migrationPlanFromContext returns the migration plan attached to the
+// context, if any.
+func migrationPlanFromContext(ctx context.Context) *migrationPlan {
+	plan, _ := ctx.Value(migrationPlanKey{}).(*migrationPlan)
+	return plan
+}
+
+// execMigration executes a statement on the cluster. When the context
+// contains a migration plan, the statement is recorded instead.
+func (c *Component) execMigration(ctx context.Context, query string) error {
+	if plan := migrationPlanFromContext(ctx); plan != nil {
+		if c.config.Cluster != "" {
+			query = clickhousedb.TransformQueryOnCluster(query, c.config.Cluster)
+		}
+		plan.statements = append(plan.statements, strings.TrimSpace(query))
+		return nil
+	}
+	return c.d.ClickHouse.ExecOnCluster(ctx, query)
+}
+
+// PlanMigrations returns the statements to execute to migrate the database,
+// without executing them. The plan is computed against the current state of
+// the database: a statement depending on an object created by a previous
+// statement may be different once this object exists.
+func (c *Component) PlanMigrations(ctx context.Context) ([]string, error) {
+	c.migrationsLock.Lock()
+	defer c.migrationsLock.Unlock()
+	plan := &migrationPlan{statements: []string{}}
+	ctx = context.WithValue(ctx, migrationPlanKey{}, plan)
+	if err := c.runMigrations(ctx); err != nil {
+		return nil, err
+	}
+	return plan.statements, nil
+}
@@ -26,6 +26,8 @@ import (
 	"akvorado/orchestrator/geoip"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
+	"go.uber.org/mock/gomock"
+	"golang.org/x/exp/slices"
 )
 
 type tableWithSchema struct {
@@ -250,6 +252,7 @@ WHERE database=currentDatabase() AND table NOT LIKE '.%'`)
 			}
 			expected := []string{
 				schema.DictionaryASNs,
+				schema.DictionaryDSCP,
 				"exporters",
 				"exporters_consumer",
 				// No exporters_local, because exporters is always local
@@ -555,3 +558,372 @@ func TestQuoteString(t *testing.T) {
 		}
 	}
 }
+
+func TestTTLClause(t *testing.T) {
+	cases := []struct {
+		resolution ResolutionConfiguration
+		expected   string
+	}{
+		{
+			ResolutionConfiguration{TTL: 24 * time.Hour},
+			"TTL TimeReceived + toIntervalSecond(86400)",
+		}, {
+			ResolutionConfiguration{
+				TTL: 2 * 365 * 24 * time.Hour,
+				Moves: []MoveConfiguration{
+					{After: 24 * time.Hour, Volume: "ssd"},
+					{After: 7 * 24 * time.Hour, Volume: "hdd"},
+				},
+			},
+			"TTL TimeReceived + toIntervalSecond(86400) TO VOLUME 'ssd', TimeReceived + toIntervalSecond(604800) TO VOLUME 'hdd', TimeReceived + toIntervalSecond(63072000)",
+		},
+	}
+	for _, tc := range cases {
+		got := ttlClause(tc.resolution)
+		if diff := helpers.Diff(got, tc.expected); diff != "" {
+			t.Errorf("ttlClause(%+v) (-got, +want):\n%s", tc.resolution, diff)
+		}
+	}
+}
+
+func TestResolutionMovesValidation(t *testing.T) {
+	cases := []struct {
+		description string
+		resolution  ResolutionConfiguration
+		err         bool
+	}{
+		{
+			description: "no storage policy",
+			resolution: ResolutionConfiguration{
+				TTL:   24 * time.Hour,
+				Moves: []MoveConfiguration{{After: time.Hour, Volume: "hdd"}},
+			},
+			err: true,
+		}, {
+			description: "move after TTL",
+			resolution: ResolutionConfiguration{
+				TTL:           24 * time.Hour,
+				StoragePolicy: "tiered",
+				Moves:         []MoveConfiguration{{After: 48 * time.Hour, Volume: "hdd"}},
+			},
+			err: true,
+		}, {
+			description: "valid",
+			resolution: ResolutionConfiguration{
+				TTL:           24 * time.Hour,
+				StoragePolicy: "tiered",
+				Moves:         []MoveConfiguration{{After: time.Hour, Volume: "hdd"}},
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			r := reporter.NewMock(t)
+			configuration := DefaultConfiguration()
+			configuration.Resolutions = []ResolutionConfiguration{tc.resolution}
+			_, err := New(r, configuration, Dependencies{
+				Daemon: daemon.NewMock(t),
+				HTTP:   httpserver.NewMock(t, r),
+				Schema: schema.NewMock(t),
+			})
+			if (err != nil) != tc.err {
+				t.Fatalf("New() error = %v, expected error: %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestResolutionDimensions(t *testing.T) {
+	r := reporter.NewMock(t)
+	c, err := New(r, DefaultConfiguration(), Dependencies{
+		Daemon: daemon.NewMock(t),
+		HTTP:   httpserver.NewMock(t, r),
+		Schema: schema.NewMock(t),
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	resolution := ResolutionConfiguration{
+		Interval:   time.Hour,
+		Dimensions: []schema.ColumnKey{schema.ColumnSrcCountry},
+	}
+
+	got := columnNames(c.flowsTableColumns(resolution, schema.ClickHouseSkipMainOnlyColumns))
+	for _, name := range []string{"TimeReceived", "SamplingRate", "ExporterAddress", "SrcCountry", "Bytes", "Packets"} {
+		if !slices.Contains(got, name) {
+			t.Errorf("flowsTableColumns() does not contain %s", name)
+		}
+	}
+	for _, name := range []string{"DstCountry", "SrcAddr", "SrcNetName"} {
+		if slices.Contains(got, name) {
+			t.Errorf("flowsTableColumns() contains %s", name)
+		}
+	}
+
+	expectedKeys := append(c.d.Schema.ClickHousePrimaryKeys(), "SrcCountry")
+	if diff := helpers.Diff(c.flowsTableSortingKeys(resolution), expectedKeys); diff != "" {
+		t.Errorf("flowsTableSortingKeys() (-got, +want):\n%s", diff)
+	}
+
+	// Without dimensions, all columns are kept.
+	resolution.Dimensions = nil
+	if diff := helpers.Diff(
+		columnsDefinition(c.flowsTableColumns(resolution, schema.ClickHouseSkipMainOnlyColumns)),
+		c.d.Schema.ClickHouseCreateTable(schema.ClickHouseSkipMainOnlyColumns)); diff != "" {
+		t.Errorf("flowsTableColumns() (-got, +want):\n%s", diff)
+	}
+	if diff := helpers.Diff(c.flowsTableSortingKeys(resolution), c.d.Schema.ClickHouseSortingKeys()); diff != "" {
+		t.Errorf("flowsTableSortingKeys() (-got, +want):\n%s", diff)
+	}
+
+	// Invalid dimensions
+	for _, resolution := range []ResolutionConfiguration{
+		{Interval: 0, Dimensions: []schema.ColumnKey{schema.ColumnSrcCountry}},
+		{Interval: time.Hour, Dimensions: []schema.ColumnKey{schema.ColumnSrcPort}},
+		{Interval: time.Hour, Dimensions: []schema.ColumnKey{schema.ColumnBytes}},
+	} {
+		if err := c.checkResolutionDimensions(resolution); err == nil {
+			t.Errorf("checkResolutionDimensions(%+v) did not error", resolution)
+		}
+	}
+}
+
+func TestCheckCluster(t *testing.T) {
+	type replica = struct {
+		ShardNum uint32 `ch:"shard_num"`
+		HostName string `ch:"host_name"`
+		Port     uint16 `ch:"port"`
+	}
+	type shard = struct {
+		ShardNum uint32 `ch:"shard_num"`
+	}
+	replicas := []replica{
+		{1, "clickhouse-1", 9000},
+		{1, "clickhouse-2", 9000},
+		{2, "clickhouse-3", 9000},
+		{2, "clickhouse-4", 9000},
+	}
+	cases := []struct {
+		Description string
+		Replicas    []replica
+		Reachable   []shard
+		Error       string
+	}{
+		{
+			Description: "all shards reachable",
+			Replicas:    replicas,
+			Reachable:   []shard{{1}, {2}},
+		}, {
+			Description: "unknown cluster",
+			Replicas:    []replica{},
+			Error:       "cluster akvorado is not defined in ClickHouse",
+		}, {
+			Description: "unreachable shard",
+			Replicas:    replicas,
+			Reachable:   []shard{{1}},
+			Error:       "cannot reach shard 2 of cluster akvorado (clickhouse-3:9000, clickhouse-4:9000)",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			r := reporter.NewMock(t)
+			chComponent, mockConn := clickhousedb.NewMock(t, r)
+			config := DefaultConfiguration()
+			config.Cluster = "akvorado"
+			c := Component{
+				r:      r,
+				d:      &Dependencies{ClickHouse: chComponent},
+				config: config,
+			}
+			mockConn.EXPECT().
+				Select(gomock.Any(), gomock.Any(), `
+SELECT shard_num, host_name, port
+FROM system.clusters
+WHERE cluster = $1
+ORDER BY shard_num, replica_num
+`, "akvorado").
+				SetArg(1, tc.Replicas).
+				Return(nil)
+			if len(tc.Replicas) > 0 {
+				mockConn.EXPECT().
+					Select(gomock.Any(), gomock.Any(), `
+SELECT DISTINCT _shard_num AS shard_num
+FROM cluster('akvorado', system.one)
+SETTINGS skip_unavailable_shards = 1
+`).
+					SetArg(1, tc.Reachable).
+					Return(nil)
+			}
+			err := c.checkCluster(context.Background())
+			if tc.Error != "" {
+				if err == nil || err.Error() != tc.Error {
+					t.Fatalf("checkCluster() error:\n%v\nexpected:\n%s", err, tc.Error)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("checkCluster() error:\n%+v", err)
+			}
+			if c.shards != 2 || c.replicas != 4 {
+				t.Fatalf("checkCluster() shards=%d replicas=%d, expected 2 and 4", c.shards, c.replicas)
+			}
+		})
+	}
+}
+
+func TestMergeTreeEngine(t *testing.T) {
+	config := DefaultConfiguration()
+	c := Component{config: config}
+	if got := c.mergeTreeEngine("flows", "Summing", "(Bytes, Packets)"); got != "SummingMergeTree((Bytes, Packets))" {
+		t.Errorf("mergeTreeEngine() == %q", got)
+	}
+	c.config.Cluster = "akvorado"
+	if diff := helpers.Diff(c.mergeTreeEngine("exporters", "Replacing", "TimeReceived"),
+		"ReplicatedReplacingMergeTree('/clickhouse/tables/shard-{shard}/exporters', 'replica-{replica}', TimeReceived)"); diff != "" {
+		t.Errorf("mergeTreeEngine() (-got, +want):\n%s", diff)
+	}
+	c.config.ReplicationPath = "/clickhouse/{cluster}/{shard}/akvorado/{table}"
+	c.config.ReplicaName = "{replica}"
+	if diff := helpers.Diff(c.mergeTreeEngine("flows_local", ""),
+		"ReplicatedMergeTree('/clickhouse/{cluster}/{shard}/akvorado/flows_local', '{replica}')"); diff != "" {
+		t.Errorf("mergeTreeEngine() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestReplicationPathValidation(t *testing.T) {
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration()
+	config.Cluster = "akvorado"
+	config.ReplicationPath = "/clickhouse/tables/{shard}"
+	_, err := New(r, config, Dependencies{
+		Daemon: daemon.NewMock(t),
+		HTTP:   httpserver.NewMock(t, r),
+		Schema: schema.NewMock(t),
+	})
+	if err == nil {
+		t.Fatal("New() did not error")
+	}
+}
+
+func TestExecMigrationPlan(t *testing.T) {
+	r := reporter.NewMock(t)
+	c := Component{r: r, config: DefaultConfiguration()}
+	plan := &migrationPlan{}
+	ctx := context.WithValue(context.Background(), migrationPlanKey{}, plan)
+	if err := c.execMigration(ctx, "DROP TABLE IF EXISTS flows_1m0s_consumer SYNC"); err != nil {
+		t.Fatalf("execMigration() error:\n%+v", err)
+	}
+	c.config.Cluster = "akvorado"
+	if err := c.execMigration(ctx, "\nALTER TABLE flows MODIFY TTL TimeReceived + toIntervalSecond(3600)\n"); err != nil {
+		t.Fatalf("execMigration() error:\n%+v", err)
+	}
+	expected := []string{
+		"DROP TABLE IF EXISTS flows_1m0s_consumer SYNC",
+		"ALTER TABLE flows ON CLUSTER akvorado MODIFY TTL TimeReceived + toIntervalSecond(3600)",
+	}
+	if diff := helpers.Diff(plan.statements, expected); diff != "" {
+		t.Fatalf("execMigration() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestPlanMigrationsAfterMigration(t *testing.T) {
+	r := reporter.NewMock(t)
+	chComponent := clickhousedb.SetupClickHouse(t, r, false)
+	dropAllTables(t, chComponent)
+	ch := startTestComponent(t, r, chComponent, nil)
+
+	// Once migrations are done, there is nothing left to do.
+	statements, err := ch.PlanMigrations(context.Background())
+	if err != nil {
+		t.Fatalf("PlanMigrations() error:\n%+v", err)
+	}
+	if diff := helpers.Diff(statements, []string{}); diff != "" {
+		t.Fatalf("PlanMigrations() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestRawFlowsTables(t *testing.T) {
+	r := reporter.NewMock(t)
+	chComponent, mockConn := clickhousedb.NewMock(t, r)
+	config := DefaultConfiguration()
+	config.Kafka.Topic = "flows"
+	config.Kafka.Brokers = []string{"kafka:9092"}
+	config.Kafka.Consumers = 4
+	config.Kafka.ConsumerTables = 2
+	config.Kafka.MaxBlockSize = 100000
+	config.Kafka.PollMaxBatchSize = 50000
+	c := Component{
+		r:      r,
+		d:      &Dependencies{ClickHouse: chComponent, Schema: schema.NewMock(t)},
+		config: config,
+	}
+	hash := c.d.Schema.ProtobufMessageHash()
+
+	expectedTables := []string{
+		fmt.Sprintf("flows_%s_raw", hash),
+		fmt.Sprintf("flows_%s_raw_2", hash),
+	}
+	if diff := helpers.Diff(c.rawFlowsTables(), expectedTables); diff != "" {
+		t.Errorf("rawFlowsTables() (-got, +want):\n%s", diff)
+	}
+	if got := c.rawFlowsErrorsConsumerView(expectedTables[0]); got != "flows_raw_errors_consumer" {
+		t.Errorf("rawFlowsErrorsConsumerView(%q) == %q", expectedTables[0], got)
+	}
+	if got := c.rawFlowsErrorsConsumerView(expectedTables[1]); got != "flows_raw_errors_consumer_2" {
+		t.Errorf("rawFlowsErrorsConsumerView(%q) == %q", expectedTables[1], got)
+	}
+	expectedEngine := fmt.Sprintf("Kafka SETTINGS kafka_broker_list = 'kafka:9092', "+
+		"kafka_topic_list = 'flows-%s', kafka_group_name = 'clickhouse', "+
+		"kafka_format = 'Protobuf', kafka_schema = 'flow-%s.proto:FlowMessagev%s', "+
+		"kafka_num_consumers = 4, kafka_thread_per_consumer = 1, "+
+		"kafka_handle_error_mode = 'stream', kafka_max_block_size = 100000, "+
+		"kafka_poll_max_batch_size = 50000", hash, hash, hash)
+	if diff := helpers.Diff(c.rawFlowsEngine(), expectedEngine); diff != "" {
+		t.Errorf("rawFlowsEngine() (-got, +want):\n%s", diff)
+	}
+
+	// Drop the tables which are not configured anymore
+	type table = struct {
+		Name string `ch:"name"`
+	}
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), `
+SELECT name
+FROM system.tables
+WHERE database = $1
+AND (name LIKE 'flows_%\\_raw\\_%' OR name LIKE 'flows_raw_errors_consumer_%')
+`, "default").
+		SetArg(1, []table{
+			{fmt.Sprintf("flows_%s_raw_consumer", hash)},
+			{fmt.Sprintf("flows_%s_raw_2", hash)},
+			{fmt.Sprintf("flows_%s_raw_2_consumer", hash)},
+			{fmt.Sprintf("flows_%s_raw_3", hash)},
+			{fmt.Sprintf("flows_%s_raw_3_consumer", hash)},
+			{"flows_raw_errors_consumer_2"},
+			{"flows_raw_errors_consumer_3"},
+			{"flows_OTHERHASH_raw_3"},
+		}).
+		Return(nil)
+	plan := &migrationPlan{}
+	ctx := context.WithValue(context.Background(), migrationPlanKey{}, plan)
+	if err := c.dropRemovedRawFlowsTables(ctx); err != nil {
+		t.Fatalf("dropRemovedRawFlowsTables() error:\n%+v", err)
+	}
+	expectedStatements := []string{
+		fmt.Sprintf("DROP TABLE IF EXISTS flows_%s_raw_3_consumer SYNC", hash),
+		"DROP TABLE IF EXISTS flows_raw_errors_consumer_3 SYNC",
+		fmt.Sprintf("DROP TABLE IF EXISTS flows_%s_raw_3 SYNC", hash),
+	}
+	if diff := helpers.Diff(plan.statements, expectedStatements); diff != "" {
+		t.Errorf("dropRemovedRawFlowsTables() (-got, +want):\n%s", diff)
+	}
+
+	// Without Kafka, only one table is used
+	c.config.Kafka.Disable = true
+	if diff := helpers.Diff(c.rawFlowsTables(), expectedTables[:1]); diff != "" {
+		t.Errorf("rawFlowsTables() (-got, +want):\n%s", diff)
+	}
+	if got := c.rawFlowsEngine(); got != "Null" {
+		t.Errorf("rawFlowsEngine() == %q", got)
+	}
+}
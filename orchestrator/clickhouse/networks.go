@@ -7,9 +7,13 @@ import (
 	"compress/gzip"
 	"context"
 	"encoding/csv"
+	"fmt"
+	"maps"
 	"net"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"time"
 
@@ -22,6 +26,18 @@ import (
 
 const networksCSVPattern = "networks*.csv.gz"
 
+// networksWithPrefixLen tells if the prefix length of each network should be
+// exported in the networks dictionary. This is only needed when
+// SrcNetPrefixLen or DstNetPrefixLen are enabled.
+func (c *Component) networksWithPrefixLen() bool {
+	for _, key := range []schema.ColumnKey{schema.ColumnSrcNetPrefixLen, schema.ColumnDstNetPrefixLen} {
+		if column, ok := c.d.Schema.LookupColumnByKey(key); ok && !column.Disabled {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Component) refreshNetworksCSV() {
 	select {
 	case c.networksCSVUpdateChan <- true:
@@ -63,7 +79,8 @@ func (c *Component) networksCSVRefresher() {
 				return err
 			}
 			attrs := NetworkAttributes{
-				ASN: data.ASNumber,
+				ASN:    data.ASNumber,
+				ASName: data.ASName,
 			}
 			return networks.Update(subV6Str, attrs, overrideNetworkAttrs(attrs))
 		})
@@ -141,7 +158,13 @@ func (c *Component) networksCSVRefresher() {
 		// Write a gzip dump to the disk
 		gzipWriter := gzip.NewWriter(tmpfile)
 		csvWriter := csv.NewWriter(gzipWriter)
-		csvWriter.Write([]string{"network", "name", "role", "site", "region", "country", "state", "city", "tenant", "asn"})
+		customAttributes := c.d.Schema.GetCustomNetworkAttributes()
+		withPrefixLen := c.networksWithPrefixLen()
+		header := []string{"network", "name", "role", "site", "region", "country", "state", "city", "tenant", "asn", "asname"}
+		if withPrefixLen {
+			header = append(header, "prefixlen")
+		}
+		csvWriter.Write(append(header, customAttributes...))
 		networks.Iter(func(address patricia.IPv6Address, tags [][]NetworkAttributes) error {
 			current := NetworkAttributes{}
 			for _, nodeTags := range tags {
@@ -154,7 +177,7 @@ func (c *Component) networksCSVRefresher() {
 			if current.ASN != 0 {
 				asnVal = strconv.Itoa(int(current.ASN))
 			}
-			csvWriter.Write([]string{
+			record := []string{
 				address.String(),
 				current.Name,
 				current.Role,
@@ -165,7 +188,19 @@ func (c *Component) networksCSVRefresher() {
 				current.City,
 				current.Tenant,
 				asnVal,
-			})
+				current.ASName,
+			}
+			if withPrefixLen {
+				var prefixLen string
+				if prefix, err := netip.ParsePrefix(address.String()); err == nil {
+					prefixLen = strconv.Itoa(prefix.Bits())
+				}
+				record = append(record, prefixLen)
+			}
+			for _, attr := range customAttributes {
+				record = append(record, current.Custom[attr])
+			}
+			csvWriter.Write(record)
 			return nil
 		})
 		csvWriter.Flush()
@@ -201,8 +236,14 @@ func overrideNetworkAttrs(newAttrs NetworkAttributes) func(existing NetworkAttri
 
 func mergeNetworkAttrs(existing, newAttrs NetworkAttributes) NetworkAttributes {
 	if newAttrs.ASN != 0 {
+		if newAttrs.ASN != existing.ASN {
+			existing.ASName = ""
+		}
 		existing.ASN = newAttrs.ASN
 	}
+	if newAttrs.ASName != "" {
+		existing.ASName = newAttrs.ASName
+	}
 	if newAttrs.Name != "" {
 		existing.Name = newAttrs.Name
 	}
@@ -227,5 +268,27 @@ func mergeNetworkAttrs(existing, newAttrs NetworkAttributes) NetworkAttributes {
 	if newAttrs.City != "" {
 		existing.City = newAttrs.City
 	}
+	if len(newAttrs.Custom) > 0 {
+		custom := make(map[string]string, len(existing.Custom)+len(newAttrs.Custom))
+		maps.Copy(custom, existing.Custom)
+		for k, v := range newAttrs.Custom {
+			if v != "" {
+				custom[k] = v
+			}
+		}
+		existing.Custom = custom
+	}
 	return existing
 }
+
+// checkNetworkAttributes checks that the custom attributes of a network are
+// declared in the schema.
+func (c *Component) checkNetworkAttributes(attrs NetworkAttributes) error {
+	declared := c.d.Schema.GetCustomNetworkAttributes()
+	for attr := range attrs.Custom {
+		if !slices.Contains(declared, attr) {
+			return fmt.Errorf("custom network attribute %q not declared in schema", attr)
+		}
+	}
+	return nil
+}
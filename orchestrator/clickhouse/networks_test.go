@@ -43,23 +43,23 @@ func TestNetworksCSVWithGeoip(t *testing.T) {
 				URL:         "/api/v0/orchestrator/clickhouse/networks.csv",
 				ContentType: "text/csv; charset=utf-8",
 				FirstLines: []string{
-					"network,name,role,site,region,country,state,city,tenant,asn",
-					"1.0.0.0/24,,,,,,,,,15169",
-					"1.128.0.0/11,,,,,,,,,1221",
-					"2.19.4.136/30,,,,,SG,,,,32787",
-					"2.19.4.140/32,,,,,SG,,,,32787",
-					"2.125.160.216/29,,,,,GB,,,,",
-					"12.81.92.0/22,,,,,,,,,7018",
-					"12.81.96.0/19,,,,,,,,,7018",
-					"12.81.128.0/17,,,,,,,,,7018",
-					"12.82.0.0/15,,,,,,,,,7018",
-					"12.84.0.0/14,,,,,,,,,7018",
-					"12.88.0.0/13,,,,,,,,,7018",
-					"12.96.0.0/20,,,,,,,,,7018",
-					"12.96.16.0/24,,,,,,,,,7018",
-					"15.0.0.0/8,,,,,,,,,71",
-					"16.0.0.0/8,,,,,,,,,71",
-					"18.0.0.0/8,,,,,,,,,3",
+					"network,name,role,site,region,country,state,city,tenant,asn,asname",
+					"1.0.0.0/24,,,,,,,,,15169,Google Inc.",
+					"1.128.0.0/11,,,,,,,,,1221,Telstra Pty Ltd",
+					`2.19.4.136/30,,,,,SG,,,,32787,"Akamai Technologies, Inc."`,
+					`2.19.4.140/32,,,,,SG,,,,32787,"Akamai Technologies, Inc."`,
+					"2.125.160.216/29,,,,,GB,,,,,",
+					"12.81.92.0/22,,,,,,,,,7018,AT&T Services",
+					"12.81.96.0/19,,,,,,,,,7018,",
+					"12.81.128.0/17,,,,,,,,,7018,",
+					"12.82.0.0/15,,,,,,,,,7018,",
+					"12.84.0.0/14,,,,,,,,,7018,",
+					"12.88.0.0/13,,,,,,,,,7018,",
+					"12.96.0.0/20,,,,,,,,,7018,",
+					"12.96.16.0/24,,,,,,,,,7018,",
+					"15.0.0.0/8,,,,,,,,,71,Hewlett-Packard Company",
+					"16.0.0.0/8,,,,,,,,,71,Hewlett-Packard Company",
+					"18.0.0.0/8,,,,,,,,,3,Massachusetts Institute of Technology",
 				},
 			},
 		})
@@ -91,26 +91,26 @@ func TestNetworksCSVWithGeoip(t *testing.T) {
 				URL:         "/api/v0/orchestrator/clickhouse/networks.csv",
 				ContentType: "text/csv; charset=utf-8",
 				FirstLines: []string{
-					"network,name,role,site,region,country,state,city,tenant,asn",
-					"1.0.0.0/24,,,,,,,,,15169",
-					"1.128.0.0/11,,,,,,,,,1221",
-					"2.19.4.136/30,,,,,SG,,,,32787",
-					"2.19.4.140/32,,,,,SG,,,,32787",
-					"2.125.160.216/29,,,,,GB,,,,",
-					"12.80.0.0/16,infra,,,,,,,,", // not covered by GeoIP
-					"12.81.92.0/22,,,,,,,,,7018",
-					"12.81.96.0/19,infra,,,,,,,,7018",       // matching a GeoIP entry
-					"12.81.96.0/24,infra,,,,,,,Alfred,7018", // nested in previous one
-					"12.81.128.0/17,,,,,,,,,7018",
-					"12.82.0.0/15,,,,,,,,,7018",
-					"12.84.0.0/14,,,,,,,,,7018",
-					"12.88.0.0/13,,,,,,,,,7018",
-					"12.96.0.0/20,,,,,,,,,7018",
-					"12.96.16.0/24,,,,,,,,,7018",
-					"14.0.0.0/7,,,,,,,,Alfred,",   // not covered by GeoIP
-					"15.0.0.0/8,,,,,,,,Alfred,71", // but covers GeoIP entries
-					"16.0.0.0/8,,,,,,,,,71",
-					"18.0.0.0/8,,,,,,,,,3",
+					"network,name,role,site,region,country,state,city,tenant,asn,asname",
+					"1.0.0.0/24,,,,,,,,,15169,Google Inc.",
+					"1.128.0.0/11,,,,,,,,,1221,Telstra Pty Ltd",
+					`2.19.4.136/30,,,,,SG,,,,32787,"Akamai Technologies, Inc."`,
+					`2.19.4.140/32,,,,,SG,,,,32787,"Akamai Technologies, Inc."`,
+					"2.125.160.216/29,,,,,GB,,,,,",
+					"12.80.0.0/16,infra,,,,,,,,,", // not covered by GeoIP
+					"12.81.92.0/22,,,,,,,,,7018,AT&T Services",
+					"12.81.96.0/19,infra,,,,,,,,7018,",       // matching a GeoIP entry
+					"12.81.96.0/24,infra,,,,,,,Alfred,7018,", // nested in previous one
+					"12.81.128.0/17,,,,,,,,,7018,",
+					"12.82.0.0/15,,,,,,,,,7018,",
+					"12.84.0.0/14,,,,,,,,,7018,",
+					"12.88.0.0/13,,,,,,,,,7018,",
+					"12.96.0.0/20,,,,,,,,,7018,",
+					"12.96.16.0/24,,,,,,,,,7018,",
+					"14.0.0.0/7,,,,,,,,Alfred,,",                          // not covered by GeoIP
+					"15.0.0.0/8,,,,,,,,Alfred,71,Hewlett-Packard Company", // but covers GeoIP entries
+					"16.0.0.0/8,,,,,,,,,71,Hewlett-Packard Company",
+					"18.0.0.0/8,,,,,,,,,3,Massachusetts Institute of Technology",
 				},
 			},
 		})
@@ -140,7 +140,7 @@ func TestNetworksCSVWithGeoip(t *testing.T) {
 				URL:         "/api/v0/orchestrator/clickhouse/networks.csv",
 				ContentType: "text/csv; charset=utf-8",
 				FirstLines: []string{
-					"network,name,role,site,region,country,state,city,tenant,asn",
+					"network,name,role,site,region,country,state,city,tenant,asn,asname",
 				},
 			},
 		})
@@ -160,3 +160,113 @@ func TestNetworksCSVWithGeoip(t *testing.T) {
 	})
 
 }
+
+func TestNetworksCSVCustomAttributes(t *testing.T) {
+	config := DefaultConfiguration()
+	config.SkipMigrations = true
+	r := reporter.NewMock(t)
+	clickhouseComponent := clickhousedb.SetupClickHouse(t, r, false)
+	schemaConfig := schema.DefaultConfiguration()
+	schemaConfig.CustomNetworkAttributes = []string{"environment", "owner"}
+	sch, err := schema.New(schemaConfig)
+	if err != nil {
+		t.Fatalf("schema.New() error:\n%+v", err)
+	}
+
+	t.Run("declared attributes", func(t *testing.T) {
+		config.Networks = helpers.MustNewSubnetMap(map[string]NetworkAttributes{
+			"::ffff:192.0.2.0/120": {
+				Name:   "infra",
+				Custom: map[string]string{"environment": "production", "owner": "netops"},
+			},
+			"::ffff:192.0.2.0/123": {
+				Custom: map[string]string{"environment": "staging"},
+			},
+		})
+		c, err := New(r, config, Dependencies{
+			Daemon:     daemon.NewMock(t),
+			HTTP:       httpserver.NewMock(t, r),
+			Schema:     sch,
+			GeoIP:      geoip.NewMock(t, r, false),
+			ClickHouse: clickhouseComponent,
+		})
+		if err != nil {
+			t.Fatalf("New() error:\n%+v", err)
+		}
+		helpers.StartStop(t, c)
+
+		helpers.TestHTTPEndpoints(t, c.d.HTTP.LocalAddr(), helpers.HTTPEndpointCases{
+			{
+				Description: "networks.csv",
+				URL:         "/api/v0/orchestrator/clickhouse/networks.csv",
+				ContentType: "text/csv; charset=utf-8",
+				FirstLines: []string{
+					"network,name,role,site,region,country,state,city,tenant,asn,asname,environment,owner",
+					"192.0.2.0/24,infra,,,,,,,,,,production,netops",
+					"192.0.2.0/27,infra,,,,,,,,,,staging,netops",
+				},
+			},
+		})
+	})
+
+	t.Run("undeclared attribute", func(t *testing.T) {
+		config.Networks = helpers.MustNewSubnetMap(map[string]NetworkAttributes{
+			"::ffff:192.0.2.0/120": {
+				Custom: map[string]string{"customer": "alfred"},
+			},
+		})
+		_, err := New(r, config, Dependencies{
+			Daemon:     daemon.NewMock(t),
+			HTTP:       httpserver.NewMock(t, r),
+			Schema:     sch,
+			GeoIP:      geoip.NewMock(t, r, false),
+			ClickHouse: clickhouseComponent,
+		})
+		if err == nil {
+			t.Fatal("New() did not error")
+		}
+	})
+}
+
+func TestNetworksCSVPrefixLen(t *testing.T) {
+	config := DefaultConfiguration()
+	config.SkipMigrations = true
+	config.Networks = helpers.MustNewSubnetMap(map[string]NetworkAttributes{
+		"::ffff:192.0.2.0/120": {Name: "infra"},
+		"::ffff:192.0.2.0/123": {Role: "servers"},
+		"2001:db8::/48":        {Name: "infra6"},
+	})
+	r := reporter.NewMock(t)
+	clickhouseComponent := clickhousedb.SetupClickHouse(t, r, false)
+	schemaConfig := schema.DefaultConfiguration()
+	schemaConfig.Enabled = []schema.ColumnKey{schema.ColumnDstNetPrefixLen}
+	sch, err := schema.New(schemaConfig)
+	if err != nil {
+		t.Fatalf("schema.New() error:\n%+v", err)
+	}
+	c, err := New(r, config, Dependencies{
+		Daemon:     daemon.NewMock(t),
+		HTTP:       httpserver.NewMock(t, r),
+		Schema:     sch,
+		GeoIP:      geoip.NewMock(t, r, false),
+		ClickHouse: clickhouseComponent,
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	helpers.StartStop(t, c)
+
+	helpers.TestHTTPEndpoints(t, c.d.HTTP.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			Description: "networks.csv",
+			URL:         "/api/v0/orchestrator/clickhouse/networks.csv",
+			ContentType: "text/csv; charset=utf-8",
+			FirstLines: []string{
+				"network,name,role,site,region,country,state,city,tenant,asn,asname,prefixlen",
+				"192.0.2.0/24,infra,,,,,,,,,,24",
+				"192.0.2.0/27,infra,servers,,,,,,,,,27",
+				"2001:db8::/48,infra6,,,,,,,,,,48",
+			},
+		},
+	})
+}
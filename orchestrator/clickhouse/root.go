@@ -5,15 +5,19 @@
 package clickhouse
 
 import (
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"os"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"akvorado/common/remotedatasourcefetcher"
 
 	"github.com/cenkalti/backoff/v4"
+	"golang.org/x/exp/slices"
 	"gopkg.in/tomb.v2"
 
 	"akvorado/common/clickhousedb"
@@ -32,14 +36,30 @@ type Component struct {
 	config  Configuration
 	metrics metrics
 
-	shards int // number of shards if in a cluster
+	shards   int // number of shards if in a cluster
+	replicas int // number of replicas (for all shards) if in a cluster
 
+	migrationsLock        sync.Mutex
 	migrationsDone        chan bool // closed when migrations are done
 	migrationsOnce        chan bool // closed after first attempt to migrate
 	networkSourcesFetcher *remotedatasourcefetcher.Component[externalNetworkAttributes]
 	networkSources        map[string][]externalNetworkAttributes
 	networkSourcesLock    sync.RWMutex
 
+	dictionaryOverrides     map[string][][]string
+	dictionaryOverridesLock sync.RWMutex
+
+	ingestionHealth     *ingestionHealth
+	ingestionHealthLock sync.RWMutex
+
+	materializations     []materialization // indexes and projections to build
+	materializationsLock sync.Mutex
+
+	deleteSecret []byte // used to sign deletion plans
+
+	backfill     *backfillStatus // current or last backfill
+	backfillLock sync.Mutex
+
 	networksCSVReady      chan bool // close when networks.csv was generated once
 	networksCSVUpdateChan chan bool // channel to write to to request updates
 	networksCSVFile       *os.File
@@ -64,6 +84,7 @@ func New(r *reporter.Reporter, configuration Configuration, dependencies Depende
 		migrationsDone:        make(chan bool),
 		migrationsOnce:        make(chan bool),
 		networkSources:        make(map[string][]externalNetworkAttributes),
+		dictionaryOverrides:   make(map[string][][]string),
 		networksCSVReady:      make(chan bool),
 		networksCSVUpdateChan: make(chan bool, 1),
 	}
@@ -75,6 +96,35 @@ func New(r *reporter.Reporter, configuration Configuration, dependencies Depende
 	}
 	c.initMetrics()
 
+	c.deleteSecret = make([]byte, 32)
+	if _, err := rand.Read(c.deleteSecret); err != nil {
+		return nil, fmt.Errorf("unable to generate secret: %w", err)
+	}
+
+	for prefix, attrs := range c.config.Networks.ToMap() {
+		if err := c.checkNetworkAttributes(attrs); err != nil {
+			return nil, fmt.Errorf("network %s: %w", prefix, err)
+		}
+	}
+
+	for name := range c.config.DictionaryFiles {
+		if !slices.Contains(overridableDictionaries, name) {
+			return nil, fmt.Errorf("dictionary %s cannot be extended with a file", name)
+		}
+		if err := c.loadDictionaryFile(name); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.config.Cluster != "" {
+		if !strings.Contains(c.config.ReplicationPath, "{table}") && !strings.Contains(c.config.ReplicationPath, "{uuid}") {
+			return nil, errors.New("replication path should contain {table} or {uuid}")
+		}
+		if c.config.ReplicaName == "" {
+			return nil, errors.New("replica name should not be empty")
+		}
+	}
+
 	if err := c.registerHTTPHandlers(); err != nil {
 		return nil, err
 	}
@@ -86,6 +136,24 @@ func New(r *reporter.Reporter, configuration Configuration, dependencies Depende
 	if len(c.config.Resolutions) == 0 || c.config.Resolutions[0].Interval != 0 {
 		return nil, fmt.Errorf("resolutions need to be configured, including interval: 0")
 	}
+	for _, resolution := range c.config.Resolutions {
+		if err := c.checkResolutionDimensions(resolution); err != nil {
+			return nil, err
+		}
+		if err := c.checkResolutionIndexes(resolution); err != nil {
+			return nil, err
+		}
+		if len(resolution.Moves) > 0 && resolution.StoragePolicy == "" {
+			return nil, fmt.Errorf("resolution %s: moving data to a volume requires a storage policy",
+				resolution.Interval)
+		}
+		for _, move := range resolution.Moves {
+			if resolution.TTL > 0 && move.After >= resolution.TTL {
+				return nil, fmt.Errorf("resolution %s: data is moved to volume %s after its TTL",
+					resolution.Interval, move.Volume)
+			}
+		}
+	}
 
 	c.d.Daemon.Track(&c.t, "orchestrator/clickhouse")
 
@@ -137,6 +205,36 @@ func (c *Component) Start() error {
 		return fmt.Errorf("unable to start network sources fetcher component: %w", err)
 	}
 
+	// Dictionary files updates
+	if err := c.watchDictionaryFiles(); err != nil {
+		return err
+	}
+
+	// Kafka consumers lag
+	if !c.config.Kafka.Disable {
+		c.t.Go(func() error {
+			c.kafkaLagRefresher()
+			return nil
+		})
+	}
+
+	// Indexes and projections materialization
+	if c.config.IndexMaterialization.Mode == "throttled" {
+		c.t.Go(func() error {
+			c.materializer()
+			return nil
+		})
+	}
+
+	// Ingestion checks
+	if c.config.IngestionCheck.Interval > 0 {
+		c.r.RegisterHealthcheck("clickhouse/ingestion", c.ingestionHealthcheck)
+		c.t.Go(func() error {
+			c.ingestionChecker()
+			return nil
+		})
+	}
+
 	// GeoIP updates
 	notifyChan := c.d.GeoIP.Notify()
 	c.t.Go(func() error {
@@ -5,6 +5,7 @@ package clickhouse
 
 import (
 	"context"
+	"fmt"
 	"net/netip"
 
 	"akvorado/common/remotedatasourcefetcher"
@@ -22,6 +23,11 @@ func (c *Component) UpdateRemoteDataSource(ctx context.Context, name string, sou
 	if err != nil {
 		return 0, err
 	}
+	for _, result := range results {
+		if err := c.checkNetworkAttributes(result.NetworkAttributes); err != nil {
+			return 0, fmt.Errorf("network %s: %w", result.Prefix, err)
+		}
+	}
 	c.networkSourcesLock.Lock()
 	c.networkSources[name] = results
 	c.networkSourcesLock.Unlock()
@@ -21,6 +21,7 @@ type geoDatabase interface {
 	Close()
 	IterASNDatabase(AsnIterFunc) error
 	IterGeoDatabase(GeoIterFunc) error
+	LookupNetwork(net.IP) (*net.IPNet, bool)
 }
 
 // openDatabase opens the provided database and closes the current
@@ -76,3 +77,14 @@ func getGeoDatabase(db *maxminddb.Reader) (geoDatabase, error) {
 	}
 	return &maxmindDB{db: db}, nil
 }
+
+// lookupNetwork returns the network containing the provided IP address in the
+// provided database, if any.
+func lookupNetwork(db *maxminddb.Reader, ip net.IP) (*net.IPNet, bool) {
+	var record struct{}
+	network, ok, err := db.LookupNetwork(ip, &record)
+	if err != nil || !ok {
+		return nil, false
+	}
+	return network, true
+}
@@ -5,6 +5,7 @@ package geoip
 
 import (
 	"fmt"
+	"net"
 )
 
 // GeoInfo describes geographical data of a geo the database.
@@ -20,10 +21,13 @@ type ASNInfo struct {
 	ASName   string
 }
 
-// IterGeoDatabases iter all entries in all geo databases.
+// IterGeoDatabases iter all entries in all geo databases. When several
+// databases contain the same address, the first one wins: networks entirely
+// covered by a previous database are skipped.
 func (c *Component) IterGeoDatabases(f GeoIterFunc) error {
 	c.db.lock.RLock()
 	defer c.db.lock.RUnlock()
+	previous := []geoDatabase{}
 	for _, path := range c.config.GeoDatabase {
 		geoDB, ok := c.db.geo[path]
 		if !ok && c.config.Optional {
@@ -31,17 +35,29 @@ func (c *Component) IterGeoDatabases(f GeoIterFunc) error {
 		} else if !ok {
 			return fmt.Errorf("database not found %s", path)
 		}
-		if err := geoDB.IterGeoDatabase(f); err != nil {
+		var count int
+		if err := geoDB.IterGeoDatabase(func(subnet *net.IPNet, info GeoInfo) error {
+			if coveredByDatabases(previous, subnet) {
+				return nil
+			}
+			count++
+			return f(subnet, info)
+		}); err != nil {
 			return err
 		}
+		c.metrics.databaseNetworks.WithLabelValues("geo", path).Set(float64(count))
+		previous = append(previous, geoDB)
 	}
 	return nil
 }
 
-// IterASNDatabases iter all entries in all ASN databases.
+// IterASNDatabases iter all entries in all ASN databases. When several
+// databases contain the same address, the first one wins: networks entirely
+// covered by a previous database are skipped.
 func (c *Component) IterASNDatabases(f AsnIterFunc) error {
 	c.db.lock.RLock()
 	defer c.db.lock.RUnlock()
+	previous := []geoDatabase{}
 	for _, path := range c.config.ASNDatabase {
 		asnDB, ok := c.db.asn[path]
 		if !ok && c.config.Optional {
@@ -49,9 +65,41 @@ func (c *Component) IterASNDatabases(f AsnIterFunc) error {
 		} else if !ok {
 			return fmt.Errorf("database not found %s", path)
 		}
-		if err := asnDB.IterASNDatabase(f); err != nil {
+		var count int
+		if err := asnDB.IterASNDatabase(func(subnet *net.IPNet, info ASNInfo) error {
+			if coveredByDatabases(previous, subnet) {
+				return nil
+			}
+			count++
+			return f(subnet, info)
+		}); err != nil {
 			return err
 		}
+		c.metrics.databaseNetworks.WithLabelValues("asn", path).Set(float64(count))
+		previous = append(previous, asnDB)
 	}
 	return nil
 }
+
+// coveredByDatabases tells if the provided subnet is entirely covered by a
+// network from one of the provided databases. When a database only contains a
+// more specific network, the subnet is not covered and the more specific
+// network will take precedence when merging.
+func coveredByDatabases(dbs []geoDatabase, subnet *net.IPNet) bool {
+	for _, db := range dbs {
+		network, ok := db.LookupNetwork(subnet.IP)
+		if ok && prefixLength(network) <= prefixLength(subnet) {
+			return true
+		}
+	}
+	return false
+}
+
+// prefixLength returns the prefix length of a network as an IPv6 network.
+func prefixLength(network *net.IPNet) int {
+	ones, bits := network.Mask.Size()
+	if bits == 32 {
+		return ones + 96
+	}
+	return ones
+}
@@ -4,6 +4,7 @@
 package geoip
 
 import (
+	"net"
 	"strconv"
 
 	"github.com/oschwald/maxminddb-golang"
@@ -69,6 +70,10 @@ func (mmdb *ipinfoDB) IterGeoDatabase(f GeoIterFunc) error {
 	return nil
 }
 
+func (mmdb *ipinfoDB) LookupNetwork(ip net.IP) (*net.IPNet, bool) {
+	return lookupNetwork(mmdb.db, ip)
+}
+
 func (mmdb *ipinfoDB) Close() {
 	mmdb.db.Close()
 }
@@ -4,6 +4,8 @@
 package geoip
 
 import (
+	"net"
+
 	"github.com/oschwald/maxminddb-golang"
 )
 
@@ -77,6 +79,10 @@ func (mmdb *maxmindDB) IterGeoDatabase(f GeoIterFunc) error {
 	return nil
 }
 
+func (mmdb *maxmindDB) LookupNetwork(ip net.IP) (*net.IPNet, bool) {
+	return lookupNetwork(mmdb.db, ip)
+}
+
 func (mmdb *maxmindDB) Close() {
 	mmdb.db.Close()
 }
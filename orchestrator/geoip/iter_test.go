@@ -4,6 +4,7 @@
 package geoip
 
 import (
+	"fmt"
 	"net"
 	"path/filepath"
 	"testing"
@@ -123,3 +124,40 @@ func TestIterNonExistingDatabase(t *testing.T) {
 		t.Fatalf("IterGeoDatabases() error:\n%+v", err)
 	}
 }
+
+func TestIterDatabaseFirstWins(t *testing.T) {
+	original := filepath.Join("testdata", "GeoLite2-ASN-Test.mmdb")
+	copied := filepath.Join(t.TempDir(), "GeoLite2-ASN-Copy.mmdb")
+	copyFile(t, original, copied)
+
+	config := DefaultConfiguration()
+	config.ASNDatabase = []string{original, copied}
+	r := reporter.NewMock(t)
+	c, err := New(r, config, Dependencies{Daemon: daemon.NewMock(t)})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	helpers.StartStop(t, c)
+
+	seen := map[string]int{}
+	if err := c.IterASNDatabases(func(n *net.IPNet, _ ASNInfo) error {
+		seen[n.String()]++
+		return nil
+	}); err != nil {
+		t.Fatalf("IterASNDatabases() error:\n%+v", err)
+	}
+	for subnet, count := range seen {
+		if count != 1 {
+			t.Errorf("IterASNDatabases() returned %s %d times", subnet, count)
+		}
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_orchestrator_geoip_", "db_networks")
+	expectedMetrics := map[string]string{
+		fmt.Sprintf(`db_networks{path="%s",type="asn"}`, original): fmt.Sprint(len(seen)),
+		fmt.Sprintf(`db_networks{path="%s",type="asn"}`, copied):   "0",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
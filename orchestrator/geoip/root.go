@@ -32,7 +32,8 @@ type Component struct {
 	}
 
 	metrics struct {
-		databaseRefresh *reporter.CounterVec
+		databaseRefresh  *reporter.CounterVec
+		databaseNetworks *reporter.GaugeVec
 	}
 
 	onOpenChan        chan struct{}   // input notification channel
@@ -72,6 +73,13 @@ func New(r *reporter.Reporter, configuration Configuration, dependencies Depende
 		},
 		[]string{"database"},
 	)
+	c.metrics.databaseNetworks = c.r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "db_networks",
+			Help: "Number of networks served by a GeoIP database during the last iteration.",
+		},
+		[]string{"type", "path"},
+	)
 	return &c, nil
 }
 
@@ -4,12 +4,25 @@
 package orchestrator
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+
+	"akvorado/common/helpers/yaml"
 )
 
+// ConfigurationHash returns the hash of a serialized configuration. It is
+// used as an ETag by the orchestrator and exposed as a metric by services
+// fetching their configuration from the orchestrator.
+func ConfigurationHash(serialized []byte) string {
+	sum := sha256.Sum256(serialized)
+	return hex.EncodeToString(sum[:])
+}
+
 func (c *Component) configurationHandlerFunc(gc *gin.Context) {
 	service := gc.Param("service")
 	indexStr := gc.Param("index")
@@ -39,5 +52,17 @@ func (c *Component) configurationHandlerFunc(gc *gin.Context) {
 		gc.JSON(http.StatusNotFound, gin.H{"message": "Configuration not found."})
 		return
 	}
-	gc.YAML(http.StatusOK, configuration)
+	output, err := yaml.Marshal(configuration)
+	if err != nil {
+		c.r.Err(err).Msg("unable to serialize configuration")
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "Unable to serialize configuration."})
+		return
+	}
+	etag := fmt.Sprintf(`"%s"`, ConfigurationHash(output))
+	gc.Header("ETag", etag)
+	if gc.GetHeader("If-None-Match") == etag {
+		gc.Status(http.StatusNotModified)
+		return
+	}
+	gc.Data(http.StatusOK, "application/yaml; charset=utf-8", output)
 }
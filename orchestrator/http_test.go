@@ -4,6 +4,9 @@
 package orchestrator
 
 import (
+	"fmt"
+	"io"
+	"net/http"
 	"testing"
 
 	"akvorado/common/helpers"
@@ -65,3 +68,49 @@ func TestConfigurationEndpoint(t *testing.T) {
 		},
 	})
 }
+
+func TestConfigurationEndpointETag(t *testing.T) {
+	r := reporter.NewMock(t)
+	h := httpserver.NewMock(t, r)
+	c, err := New(r, DefaultConfiguration(), Dependencies{
+		HTTP: h,
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	c.RegisterConfiguration(InletService, map[string]string{
+		"hello": "Hello world!",
+	})
+	url := fmt.Sprintf("http://%s/api/v0/orchestrator/configuration/inlet", h.LocalAddr())
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s:\n%+v", url, err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	expected := fmt.Sprintf(`"%s"`, ConfigurationHash(body))
+	if etag := resp.Header.Get("ETag"); etag != expected {
+		t.Fatalf("GET %s: ETag %q, expected %q", url, etag, expected)
+	}
+
+	for _, tc := range []struct {
+		ETag       string
+		StatusCode int
+	}{
+		{expected, http.StatusNotModified},
+		{`"something else"`, http.StatusOK},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("If-None-Match", tc.ETag)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s:\n%+v", url, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.StatusCode {
+			t.Errorf("GET %s with If-None-Match %s: got status %d, expected %d",
+				url, tc.ETag, resp.StatusCode, tc.StatusCode)
+		}
+	}
+}
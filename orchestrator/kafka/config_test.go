@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"akvorado/common/helpers"
+	"akvorado/common/kafka"
 )
 
 func TestDefaultConfiguration(t *testing.T) {
@@ -74,3 +75,33 @@ func TestShouldAlterConfiguration(t *testing.T) {
 		})
 	}
 }
+
+func TestTopicConfigurationForSuffix(t *testing.T) {
+	retention1 := "1000"
+	retention2 := "2000"
+	segment := "100000"
+	config := DefaultConfiguration()
+	config.TopicConfiguration.ConfigEntries = map[string]*string{
+		"retention.ms":  &retention1,
+		"segment.bytes": &segment,
+	}
+	config.TopicRules = []kafka.TopicRule{
+		{Condition: "true", Suffix: "ix", ConfigEntries: map[string]*string{"retention.ms": &retention2}},
+		{Condition: "true", Suffix: "transit"},
+	}
+	c := Component{config: config}
+
+	got := c.topicConfigurationForSuffix("ix")
+	expected := config.TopicConfiguration
+	expected.ConfigEntries = map[string]*string{
+		"retention.ms":  &retention2,
+		"segment.bytes": &segment,
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("topicConfigurationForSuffix() (-got, +want):\n%s", diff)
+	}
+	got = c.topicConfigurationForSuffix("transit")
+	if diff := helpers.Diff(got, config.TopicConfiguration); diff != "" {
+		t.Fatalf("topicConfigurationForSuffix() (-got, +want):\n%s", diff)
+	}
+}
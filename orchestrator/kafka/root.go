@@ -32,7 +32,7 @@ type Dependencies struct {
 
 // New creates a new Kafka configurator.
 func New(r *reporter.Reporter, config Configuration, dependencies Dependencies) (*Component, error) {
-	kafkaConfig, err := kafka.NewConfig(config.Configuration)
+	kafkaConfig, err := kafka.NewConfig(r, config.Configuration)
 	if err != nil {
 		return nil, err
 	}
@@ -46,12 +46,16 @@ func New(r *reporter.Reporter, config Configuration, dependencies Dependencies)
 		config: config,
 
 		kafkaConfig: kafkaConfig,
-		kafkaTopic:  fmt.Sprintf("%s-%s", config.Topic, dependencies.Schema.ProtobufMessageHash()),
+		kafkaTopic:  config.TopicName("", dependencies.Schema.ProtobufMessageHash()),
 	}, nil
 }
 
 // Start starts Kafka configuration.
 func (c *Component) Start() error {
+	if c.config.Disable {
+		c.r.Info().Msg("Kafka is disabled, topics are not configured")
+		return nil
+	}
 	c.r.Info().Msg("starting Kafka component")
 	kafka.GlobalKafkaLogger.Register(c.r)
 	defer func() {
@@ -59,7 +63,7 @@ func (c *Component) Start() error {
 		c.r.Info().Msg("Kafka component stopped")
 	}()
 
-	// Create topic
+	// Create topics
 	admin, err := sarama.NewClusterAdmin(c.config.Brokers, c.kafkaConfig)
 	if err != nil {
 		c.r.Err(err).
@@ -68,48 +72,86 @@ func (c *Component) Start() error {
 		return fmt.Errorf("unable to get admin client for topic creation: %w", err)
 	}
 	defer admin.Close()
-	l := c.r.With().
-		Str("brokers", strings.Join(c.config.Brokers, ",")).
-		Str("topic", c.kafkaTopic).
-		Logger()
 	topics, err := admin.ListTopics()
 	if err != nil {
-		l.Err(err).Msg("unable to get metadata for topics")
+		c.r.Err(err).
+			Str("brokers", strings.Join(c.config.Brokers, ",")).
+			Msg("unable to get metadata for topics")
 		return fmt.Errorf("unable to get metadata for topics: %w", err)
 	}
-	if topic, ok := topics[c.kafkaTopic]; !ok {
-		if err := admin.CreateTopic(c.kafkaTopic,
+	hash := c.d.Schema.ProtobufMessageHash()
+	if err := c.configureTopic(admin, topics, c.kafkaTopic, c.config.TopicConfiguration); err != nil {
+		return err
+	}
+	for _, suffix := range c.config.TopicSuffixes() {
+		if err := c.configureTopic(admin, topics,
+			c.config.TopicName(suffix, hash), c.topicConfigurationForSuffix(suffix)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// topicConfigurationForSuffix returns the configuration of the topic for the
+// provided suffix. Configuration entries from the topic rules override the
+// ones from the main topic.
+func (c *Component) topicConfigurationForSuffix(suffix string) TopicConfiguration {
+	topicConfiguration := c.config.TopicConfiguration
+	configEntries := map[string]*string{}
+	for k, v := range c.config.TopicConfiguration.ConfigEntries {
+		configEntries[k] = v
+	}
+	for _, rule := range c.config.TopicRules {
+		if rule.Suffix != suffix {
+			continue
+		}
+		for k, v := range rule.ConfigEntries {
+			configEntries[k] = v
+		}
+	}
+	topicConfiguration.ConfigEntries = configEntries
+	return topicConfiguration
+}
+
+// configureTopic creates the provided topic or updates its configuration.
+func (c *Component) configureTopic(admin sarama.ClusterAdmin, topics map[string]sarama.TopicDetail, name string, topicConfiguration TopicConfiguration) error {
+	l := c.r.With().
+		Str("brokers", strings.Join(c.config.Brokers, ",")).
+		Str("topic", name).
+		Logger()
+	if topic, ok := topics[name]; !ok {
+		if err := admin.CreateTopic(name,
 			&sarama.TopicDetail{
-				NumPartitions:     c.config.TopicConfiguration.NumPartitions,
-				ReplicationFactor: c.config.TopicConfiguration.ReplicationFactor,
-				ConfigEntries:     c.config.TopicConfiguration.ConfigEntries,
+				NumPartitions:     topicConfiguration.NumPartitions,
+				ReplicationFactor: topicConfiguration.ReplicationFactor,
+				ConfigEntries:     topicConfiguration.ConfigEntries,
 			}, false); err != nil {
 			l.Err(err).Msg("unable to create topic")
-			return fmt.Errorf("unable to create topic %q: %w", c.kafkaTopic, err)
+			return fmt.Errorf("unable to create topic %q: %w", name, err)
 		}
 		l.Info().Msg("topic created")
 	} else {
-		if topic.NumPartitions > c.config.TopicConfiguration.NumPartitions {
+		if topic.NumPartitions > topicConfiguration.NumPartitions {
 			l.Warn().Msgf("cannot decrease the number of partitions (from %d to %d)",
-				topic.NumPartitions, c.config.TopicConfiguration.NumPartitions)
-		} else if topic.NumPartitions < c.config.TopicConfiguration.NumPartitions {
-			nb := c.config.TopicConfiguration.NumPartitions
-			if err := admin.CreatePartitions(c.kafkaTopic, nb, nil, false); err != nil {
+				topic.NumPartitions, topicConfiguration.NumPartitions)
+		} else if topic.NumPartitions < topicConfiguration.NumPartitions {
+			nb := topicConfiguration.NumPartitions
+			if err := admin.CreatePartitions(name, nb, nil, false); err != nil {
 				l.Err(err).Msg("unable to add more partitions")
 				return fmt.Errorf("unable to add more partitions to topic %q: %w",
-					c.kafkaTopic, err)
+					name, err)
 			}
 		}
-		if c.config.TopicConfiguration.ReplicationFactor != topic.ReplicationFactor {
+		if topicConfiguration.ReplicationFactor != topic.ReplicationFactor {
 			// TODO: https://github.com/deviceinsight/kafkactl/blob/main/internal/topic/topic-operation.go
 			l.Warn().Msgf("mismatch for replication factor: got %d, want %d",
-				topic.ReplicationFactor, c.config.TopicConfiguration.ReplicationFactor)
+				topic.ReplicationFactor, topicConfiguration.ReplicationFactor)
 		}
-		if ShouldAlterConfiguration(c.config.TopicConfiguration.ConfigEntries, topic.ConfigEntries, c.config.TopicConfiguration.ConfigEntriesStrictSync) {
-			if err := admin.AlterConfig(sarama.TopicResource, c.kafkaTopic, c.config.TopicConfiguration.ConfigEntries, false); err != nil {
+		if ShouldAlterConfiguration(topicConfiguration.ConfigEntries, topic.ConfigEntries, topicConfiguration.ConfigEntriesStrictSync) {
+			if err := admin.AlterConfig(sarama.TopicResource, name, topicConfiguration.ConfigEntries, false); err != nil {
 				l.Err(err).Msg("unable to set topic configuration")
 				return fmt.Errorf("unable to set topic configuration for %q: %w",
-					c.kafkaTopic, err)
+					name, err)
 			}
 			l.Info().Msg("topic updated")
 		}